@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/cube/pkg/plugin"
@@ -17,8 +18,13 @@ func main() {
 	// from Grafana to create different instances of SampleDatasource (per datasource
 	// ID). When datasource configuration changed Dispose method will be called and
 	// new datasource instance created using NewSampleDatasource factory.
-	if err := datasource.Manage("grafana-cube-datasource", plugin.NewDatasource, datasource.ManageOpts{}); err != nil {
+	if err := datasource.Manage("grafana-cube-datasource", plugin.NewDatasource, datasource.ManageOpts{
+		QueryConversionHandler: backend.ConvertQueryFunc(plugin.ConvertQueryDataRequest),
+		AdmissionHandler:       plugin.AdmissionHandler{},
+	}); err != nil {
 		log.DefaultLogger.Error(err.Error())
+		plugin.Shutdown()
 		os.Exit(1)
 	}
+	plugin.Shutdown()
 }
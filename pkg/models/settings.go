@@ -3,26 +3,451 @@ package models
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
 
+// Deployment types supported by the plugin.
+const (
+	DeploymentTypeCloud         = "cloud"
+	DeploymentTypeSelfHosted    = "self-hosted"
+	DeploymentTypeSelfHostedDev = "self-hosted-dev"
+)
+
+// ValidDeploymentTypes lists the canonical deployment type values, in the
+// order they are shown in error messages.
+var ValidDeploymentTypes = []string{DeploymentTypeCloud, DeploymentTypeSelfHosted, DeploymentTypeSelfHostedDev}
+
+// deploymentTypeAliases maps accepted spellings (after trimming and
+// lowercasing) to their canonical deployment type. Provisioning files are
+// often hand-written, so common variants are accepted rather than rejected.
+var deploymentTypeAliases = map[string]string{
+	"selfhosted":      DeploymentTypeSelfHosted,
+	"self_hosted":     DeploymentTypeSelfHosted,
+	"dev":             DeploymentTypeSelfHostedDev,
+	"selfhosted-dev":  DeploymentTypeSelfHostedDev,
+	"self_hosted_dev": DeploymentTypeSelfHostedDev,
+}
+
+// JWT signing algorithms supported for self-hosted deployments.
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmES256 = "ES256"
+)
+
+// ValidJWTAlgorithms lists the supported JWT signing algorithms.
+var ValidJWTAlgorithms = []string{JWTAlgorithmHS256, JWTAlgorithmRS256, JWTAlgorithmES256}
+
+// Query transports: Cube's REST API (/v1/load), its SQL API, which speaks
+// the Postgres wire protocol, or its GraphQL API.
+const (
+	QueryTransportREST    = "rest"
+	QueryTransportSQL     = "sql"
+	QueryTransportGraphQL = "graphql"
+)
+
+// NormalizeDeploymentType trims and lowercases a deployment type and resolves
+// documented aliases ("selfhosted", "dev", ...) to the canonical value.
+// Unknown values are returned trimmed and lowercased so validation can report
+// them.
+func NormalizeDeploymentType(deploymentType string) string {
+	normalized := strings.ToLower(strings.TrimSpace(deploymentType))
+	if canonical, ok := deploymentTypeAliases[normalized]; ok {
+		return canonical
+	}
+	return normalized
+}
+
 type PluginSettings struct {
-	URL              string                `json:"-"`
+	// URL is the Cube URL, or several separated by commas for HA
+	// deployments: requests fail over to the next one; see Endpoints.
+	URL                     string                `json:"-"`
 	DeploymentType          string                `json:"deploymentType"` // "cloud", "self-hosted", or "self-hosted-dev"
 	ExploreSqlDatasourceUid string                `json:"exploreSqlDatasourceUid"`
 	Secrets                 *SecretPluginSettings `json:"-"`
 
+	// CustomHeaders are the headers sent with every Cube request, configured
+	// with Grafana's httpHeaderName<N> (jsonData) and httpHeaderValue<N>
+	// (secureJsonData) pairs.
+	CustomHeaders map[string]string `json:"-"`
+
 	// NetworkErrorRetries configures how many times a transient transport
 	// failure (network error / HTTP 502) on the /v1/load path is retried.
 	// nil = plugin default; 0 mirrors the Cube JS SDK default (networkErrorRetries: 0).
 	// See docs/sdk-parity.md.
 	NetworkErrorRetries *int `json:"networkErrorRetries,omitempty"`
+
+	// Timeouts for outgoing Cube requests, in seconds. nil or 0 = no
+	// plugin-side timeout (only Grafana's request context applies).
+	// QueryTimeout bounds a whole /v1/load call including Continue-wait
+	// polling, MetaTimeout bounds /v1/meta and model/playground calls, and
+	// ConnectTimeout bounds establishing the TCP connection.
+	QueryTimeout   *int `json:"queryTimeout,omitempty"`
+	MetaTimeout    *int `json:"metaTimeout,omitempty"`
+	ConnectTimeout *int `json:"connectTimeout,omitempty"`
+
+	// Continue-wait polling, in seconds. ContinueWaitPollInterval is the
+	// delay before polling Cube again after a "Continue wait" response; nil
+	// or 0 polls immediately like the Cube JS SDK, relying on Cube's server
+	// side long-poll for pacing. ContinueWaitMaxDuration fails a query that
+	// is still not ready after polling this long; nil or 0 = no limit (only
+	// queryTimeout applies). Queries can override both.
+	ContinueWaitPollInterval *int `json:"continueWaitPollInterval,omitempty"`
+	ContinueWaitMaxDuration  *int `json:"continueWaitMaxDuration,omitempty"`
+
+	// AllowedViews restricts the datasource to these views (or cubes): the
+	// metadata only lists them, and queries referencing members of other
+	// views are rejected. Empty allows the whole model.
+	AllowedViews []string `json:"allowedViews,omitempty"`
+
+	// DefaultLimit is the row limit of queries that do not set one, and
+	// MaxLimit the largest row limit a query may use; larger limits are
+	// lowered to it. nil or 0 = no plugin-side limit (Cube's default applies).
+	DefaultLimit *int `json:"defaultLimit,omitempty"`
+	MaxLimit     *int `json:"maxLimit,omitempty"`
+
+	// MaxConcurrentQueries bounds the queries this datasource runs against
+	// Cube at once, panels and resource calls together; further queries
+	// wait for a free slot. nil or 0 = no limit.
+	MaxConcurrentQueries *int `json:"maxConcurrentQueries,omitempty"`
+
+	// MaxDimensionCardinality refuses queries grouping by a dimension with
+	// more distinct values than this, as counted by the cardinality
+	// resource, before they run. Queries whose dimensions cannot be counted
+	// are refused too. nil or 0 = no limit.
+	MaxDimensionCardinality *int `json:"maxDimensionCardinality,omitempty"`
+
+	// ResourceRateLimit is the number of calls per second the chatty
+	// resources (tag keys and values, metadata, SQL compilation) accept,
+	// with bursts of twice as many; calls above it get HTTP 429. nil = the
+	// plugin default, 0 or less = no limit.
+	ResourceRateLimit *int `json:"resourceRateLimit,omitempty"`
+
+	// MaxResponseBytes bounds the size of a query result, whichever the
+	// transport: the /v1/load or GraphQL response body, or the rows sent by
+	// the SQL API. Larger results fail the query instead of being read into
+	// memory. nil = the plugin default, 0 or less = no limit.
+	MaxResponseBytes *int64 `json:"maxResponseBytes,omitempty"`
+
+	// TimeLayouts are extra Go time layouts (e.g. "02/01/2006 15:04") tried
+	// when a time member's value is in none of the formats the plugin
+	// recognizes.
+	TimeLayouts []string `json:"timeLayouts,omitempty"`
+
+	// MetaCacheTTL is how many seconds /v1/meta responses are reused by the
+	// query editor before the model is fetched again. nil = plugin default;
+	// 0 disables the cache.
+	MetaCacheTTL *int `json:"metaCacheTTL,omitempty"`
+
+	// DbSchemaCacheTTL is how many seconds the warehouse schema
+	// (/playground/db-schema) is reused by the schema browser before Cube
+	// introspects the warehouse again. nil = plugin default; 0 disables the
+	// cache.
+	DbSchemaCacheTTL *int `json:"dbSchemaCacheTTL,omitempty"`
+
+	// TagValuesCacheTTL is how many seconds tag values (AdHoc filter
+	// suggestions) are reused per key and filters before Cube is queried
+	// again. nil = plugin default; 0 disables the cache.
+	TagValuesCacheTTL *int `json:"tagValuesCacheTTL,omitempty"`
+
+	// SlowQueryThresholdMs makes /v1/load requests taking longer than this
+	// many milliseconds (Continue-wait polling included) log at WARN and count
+	// towards the slow query metric. nil or 0 disables slow query logging.
+	SlowQueryThresholdMs *int `json:"slowQueryThresholdMs,omitempty"`
+
+	// ResultCacheTTL caches /v1/load results in the plugin for this many
+	// seconds, keyed by query, time range and credentials, so identical
+	// panels do not each query Cube. nil or 0 disables the cache.
+	// ResultCacheMaxEntries bounds the number of cached results; nil = plugin
+	// default.
+	ResultCacheTTL        *int `json:"resultCacheTTL,omitempty"`
+	ResultCacheMaxEntries *int `json:"resultCacheMaxEntries,omitempty"`
+
+	// Transport tuning for the pooled HTTP client. nil = plugin default.
+	// IdleConnTimeout, TLSHandshakeTimeout and KeepAlive are in seconds.
+	// ForceHTTP2 disables HTTP/1.1 so every connection (TLS or cleartext h2c)
+	// speaks HTTP/2, multiplexing concurrent queries over one connection.
+	MaxIdleConns        *int `json:"maxIdleConns,omitempty"`
+	MaxIdleConnsPerHost *int `json:"maxIdleConnsPerHost,omitempty"`
+	IdleConnTimeout     *int `json:"idleConnTimeout,omitempty"`
+	TLSHandshakeTimeout *int `json:"tlsHandshakeTimeout,omitempty"`
+	KeepAlive           *int `json:"keepAlive,omitempty"`
+	ForceHTTP2          bool `json:"forceHTTP2,omitempty"`
+
+	// TLSSkipVerify disables verification of Cube's certificate. Prefer the
+	// tlsCACert secret for self-signed deployments; this is for testing.
+	TLSSkipVerify bool `json:"tlsSkipVerify,omitempty"`
+
+	// UseWebSockets sends /v1/load queries over Cube's WebSocket API
+	// (CUBEJS_WEB_SOCKETS=true on the Cube side) instead of HTTP, falling back
+	// to HTTP when the WebSocket connection cannot be established.
+	// WebSocketPath is the path Cube serves WebSockets on (Cube's
+	// webSocketsBasePath); empty means "/".
+	UseWebSockets bool   `json:"useWebSockets,omitempty"`
+	WebSocketPath string `json:"webSocketPath,omitempty"`
+
+	// QueryTransport selects how panel queries reach Cube: "rest" (the
+	// default, /v1/load), "sql", which compiles them to SQL and runs them
+	// on Cube's SQL API over the Postgres protocol, or "graphql", which
+	// translates them to Cube's GraphQL API (/cubejs-api/graphql).
+	// SQLAPIAddress is the host:port of the SQL API; empty means the Cube
+	// URL's host on port 15432. SQLAPIUser and the sqlApiPassword secret are
+	// the credentials Cube's checkSqlAuth receives.
+	QueryTransport string `json:"queryTransport,omitempty"`
+	SQLAPIAddress  string `json:"sqlApiAddress,omitempty"`
+	SQLAPIUser     string `json:"sqlApiUser,omitempty"`
+
+	// DefaultFilters are Cube filters (e.g. {"member": "orders.tenant",
+	// "operator": "equals", "values": ["acme"]}) added to every query sent to
+	// Cube from this datasource, so admins can scope dashboards centrally.
+	// Queries opt out with ignoreDefaultFilters.
+	DefaultFilters []map[string]interface{} `json:"defaultFilters,omitempty"`
+
+	// QueryRewriteRules are governance rules applied to every query before
+	// it is sent to Cube, for multi-tenant deployments. Unlike
+	// defaultFilters, queries cannot opt out of them.
+	QueryRewriteRules []QueryRewriteRule `json:"queryRewriteRules,omitempty"`
+
+	// AllowModelFileWrites lets admins save model files (POST model-files)
+	// to a self-hosted-dev Cube through its playground file API.
+	AllowModelFileWrites bool `json:"allowModelFileWrites,omitempty"`
+
+	// DeepHealthCheck makes the health check also run a one-row /v1/load
+	// query, so that a reachable Cube with a broken warehouse connection is
+	// reported as unhealthy.
+	DeepHealthCheck bool `json:"deepHealthCheck,omitempty"`
+
+	// FiscalYearStartMonth is the month (1-12) the fiscal year starts in;
+	// 0 means January. It sets the periods of fiscal date ranges ("this
+	// fiscal year", "last fiscal quarter", ...) and the FY labels of queries
+	// with fiscalLabels.
+	FiscalYearStartMonth int `json:"fiscalYearStartMonth,omitempty"`
+
+	// AutoTimeDimension adds the queried cube's time dimension, over the
+	// dashboard time range with a granularity matching the panel's interval,
+	// to time series queries (format "time_series") that have none.
+	AutoTimeDimension bool `json:"autoTimeDimension,omitempty"`
+
+	// DataSourceLabels maps Cube data_source names (for models that read from
+	// several warehouses) to the labels shown in the query editor.
+	DataSourceLabels map[string]string `json:"dataSourceLabels,omitempty"`
+
+	// SeriesColors maps dimension values to colors (hex or Grafana color
+	// names). Dimension fields holding one of these values get a value
+	// mapping with its color, keeping brand and status colors consistent
+	// across dashboards.
+	SeriesColors map[string]string `json:"seriesColors,omitempty"`
+
+	// SecurityContext holds claims added to the JWT signed for self-hosted
+	// deployments (e.g. {"tenant": "acme"}). Cube exposes the claims as the
+	// security context to queryRewrite and row-level security rules.
+	SecurityContext map[string]interface{} `json:"securityContext,omitempty"`
+
+	// JWT signing for self-hosted deployments. JWTAlgorithm is HS256 (the
+	// default, signed with the API secret), RS256 or ES256 (signed with the
+	// jwtPrivateKey secret). JWTTTL is the token lifetime in seconds; nil or
+	// 0 = one hour. JWTIssuer and JWTAudience set the iss and aud claims.
+	JWTAlgorithm string `json:"jwtAlgorithm,omitempty"`
+	JWTTTL       *int   `json:"jwtTTL,omitempty"`
+	JWTIssuer    string `json:"jwtIssuer,omitempty"`
+	JWTAudience  string `json:"jwtAudience,omitempty"`
+}
+
+// QueryRewriteRule is a rule of QueryRewriteRules: it adds Filter to
+// queries, bounds their time dimensions to MaxDateRangeDays, or both.
+type QueryRewriteRule struct {
+	// Views limits the rule to queries using members of these views (or
+	// cubes); empty applies it to every query.
+	Views []string `json:"views,omitempty"`
+	// Filter is a Cube filter added to the query. Its string values may use
+	// the requesting user's $user.login, $user.email, $user.name,
+	// $user.role and $user.orgId.
+	Filter map[string]interface{} `json:"filter,omitempty"`
+	// MaxDateRangeDays shortens the date range of every time dimension to at
+	// most this many days, keeping its end. Time dimensions without a date
+	// range get the last MaxDateRangeDays days.
+	MaxDateRangeDays int `json:"maxDateRangeDays,omitempty"`
+}
+
+// QueryTimeoutDuration returns the configured query timeout, or 0 if unset.
+func (s *PluginSettings) QueryTimeoutDuration() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return secondsToDuration(s.QueryTimeout)
+}
+
+// MetaTimeoutDuration returns the configured metadata timeout, or 0 if unset.
+func (s *PluginSettings) MetaTimeoutDuration() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return secondsToDuration(s.MetaTimeout)
+}
+
+// ConnectTimeoutDuration returns the configured connect timeout, or 0 if unset.
+func (s *PluginSettings) ConnectTimeoutDuration() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return secondsToDuration(s.ConnectTimeout)
+}
+
+// ContinueWaitPollIntervalDuration returns the configured delay between
+// Continue-wait polls, or 0 to poll immediately.
+func (s *PluginSettings) ContinueWaitPollIntervalDuration() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return secondsToDuration(s.ContinueWaitPollInterval)
+}
+
+// MaxContinueWait returns the configured limit on Continue-wait
+// polling, or 0 if unset.
+func (s *PluginSettings) MaxContinueWait() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return secondsToDuration(s.ContinueWaitMaxDuration)
+}
+
+// FiscalYearStart returns the month the fiscal year starts in, or an error
+// when FiscalYearStartMonth is not a month.
+func (s *PluginSettings) FiscalYearStart() (time.Month, error) {
+	switch {
+	case s == nil || s.FiscalYearStartMonth == 0:
+		return time.January, nil
+	case s.FiscalYearStartMonth < 1 || s.FiscalYearStartMonth > 12:
+		return 0, fmt.Errorf("invalid fiscalYearStartMonth %d: must be a month from 1 to 12", s.FiscalYearStartMonth)
+	}
+	return time.Month(s.FiscalYearStartMonth), nil
+}
+
+// JWTTTLDuration returns the configured JWT lifetime, or 0 if unset.
+func (s *PluginSettings) JWTTTLDuration() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return secondsToDuration(s.JWTTTL)
+}
+
+// SlowQueryThreshold returns the configured slow query threshold, or 0 if
+// slow query logging is disabled.
+func (s *PluginSettings) SlowQueryThreshold() time.Duration {
+	if s == nil || s.SlowQueryThresholdMs == nil || *s.SlowQueryThresholdMs <= 0 {
+		return 0
+	}
+	return time.Duration(*s.SlowQueryThresholdMs) * time.Millisecond
+}
+
+// ResultCacheTTLDuration returns the configured result cache TTL, or 0 if
+// result caching is disabled.
+func (s *PluginSettings) ResultCacheTTLDuration() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return secondsToDuration(s.ResultCacheTTL)
+}
+
+// ViewAllowed reports whether AllowedViews allows the view (or cube).
+func (s *PluginSettings) ViewAllowed(view string) bool {
+	if s == nil || len(s.AllowedViews) == 0 {
+		return true
+	}
+	return slices.Contains(s.AllowedViews, view)
+}
+
+// MemberAllowed reports whether AllowedViews allows the view (or cube) of a
+// member ("view.member").
+func (s *PluginSettings) MemberAllowed(member string) bool {
+	view, _, _ := strings.Cut(member, ".")
+	return s.ViewAllowed(view)
+}
+
+// Endpoints returns the Cube URLs of URL, which may list several separated
+// by commas for failover, in order and without trailing slashes. The first
+// is the primary.
+func (s *PluginSettings) Endpoints() []string {
+	if s == nil {
+		return nil
+	}
+	var endpoints []string
+	for _, endpoint := range strings.Split(s.URL, ",") {
+		if endpoint = strings.TrimRight(strings.TrimSpace(endpoint), "/"); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// CustomTimeLayouts returns TimeLayouts; nil settings have none.
+func (s *PluginSettings) CustomTimeLayouts() []string {
+	if s == nil {
+		return nil
+	}
+	return s.TimeLayouts
+}
+
+// RowLimit returns the row limit a query with the given limit runs with:
+// DefaultLimit when it sets none, lowered to MaxLimit. nil means no limit is
+// sent to Cube.
+func (s *PluginSettings) RowLimit(limit *int) *int {
+	if s == nil {
+		return limit
+	}
+	if limit == nil && s.DefaultLimit != nil && *s.DefaultLimit > 0 {
+		limit = s.DefaultLimit
+	}
+	if s.MaxLimit != nil && *s.MaxLimit > 0 && (limit == nil || *limit > *s.MaxLimit) {
+		limit = s.MaxLimit
+	}
+	return limit
+}
+
+// QueryConcurrency returns MaxConcurrentQueries, 0 meaning no limit.
+func (s *PluginSettings) QueryConcurrency() int {
+	if s == nil || s.MaxConcurrentQueries == nil || *s.MaxConcurrentQueries < 0 {
+		return 0
+	}
+	return *s.MaxConcurrentQueries
+}
+
+// DimensionCardinalityLimit returns MaxDimensionCardinality, 0 meaning no
+// limit.
+func (s *PluginSettings) DimensionCardinalityLimit() int {
+	if s == nil || s.MaxDimensionCardinality == nil || *s.MaxDimensionCardinality < 0 {
+		return 0
+	}
+	return *s.MaxDimensionCardinality
+}
+
+// secondsToDuration converts an optional number of seconds to a duration,
+// treating nil and non-positive values as unset.
+func secondsToDuration(seconds *int) time.Duration {
+	if seconds == nil || *seconds <= 0 {
+		return 0
+	}
+	return time.Duration(*seconds) * time.Second
 }
 
 type SecretPluginSettings struct {
 	ApiKey    string `json:"apiKey"`    // For Cube Cloud
 	ApiSecret string `json:"apiSecret"` // For self-hosted Cube (JWT generation)
+	// JWTPrivateKey is the PEM encoded private key for RS256 and ES256 JWTs.
+	JWTPrivateKey string `json:"jwtPrivateKey"`
+	// TLSClientCert and TLSClientKey are the PEM encoded client certificate
+	// and key presented to Cube instances that require mutual TLS.
+	TLSClientCert string `json:"tlsClientCert"`
+	TLSClientKey  string `json:"tlsClientKey"`
+	// TLSCACert is a PEM encoded CA bundle trusted, in addition to the
+	// system roots, when verifying Cube's certificate.
+	TLSCACert string `json:"tlsCACert"`
+	// SQLAPIPassword is the password of the SQL API user.
+	SQLAPIPassword string `json:"sqlApiPassword"`
 }
 
 func LoadPluginSettings(source backend.DataSourceInstanceSettings) (*PluginSettings, error) {
@@ -33,14 +458,49 @@ func LoadPluginSettings(source backend.DataSourceInstanceSettings) (*PluginSetti
 	}
 
 	settings.URL = source.URL
+	settings.DeploymentType = NormalizeDeploymentType(settings.DeploymentType)
+	settings.JWTAlgorithm = strings.ToUpper(strings.TrimSpace(settings.JWTAlgorithm))
+	settings.QueryTransport = strings.ToLower(strings.TrimSpace(settings.QueryTransport))
 	settings.Secrets = loadSecretPluginSettings(source.DecryptedSecureJSONData)
+	settings.CustomHeaders = loadCustomHeaders(source.JSONData, source.DecryptedSecureJSONData)
 
 	return &settings, nil
 }
 
 func loadSecretPluginSettings(source map[string]string) *SecretPluginSettings {
 	return &SecretPluginSettings{
-		ApiKey:    source["apiKey"],
-		ApiSecret: source["apiSecret"],
+		ApiKey:         source["apiKey"],
+		ApiSecret:      source["apiSecret"],
+		JWTPrivateKey:  source["jwtPrivateKey"],
+		TLSClientCert:  source["tlsClientCert"],
+		TLSClientKey:   source["tlsClientKey"],
+		TLSCACert:      source["tlsCACert"],
+		SQLAPIPassword: source["sqlApiPassword"],
+	}
+}
+
+// loadCustomHeaders returns the headers configured with httpHeaderName<N>
+// keys in jsonData, each with the value of the matching httpHeaderValue<N>
+// secret. Pairs without a name are ignored.
+func loadCustomHeaders(jsonData []byte, secure map[string]string) map[string]string {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(jsonData, &raw); err != nil {
+		return nil
+	}
+	var headers map[string]string
+	for key, value := range raw {
+		index, ok := strings.CutPrefix(key, "httpHeaderName")
+		if !ok {
+			continue
+		}
+		name, _ := value.(string)
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[name] = secure["httpHeaderValue"+index]
 	}
+	return headers
 }
@@ -1,7 +1,10 @@
 package models
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
@@ -47,3 +50,220 @@ func TestLoadPluginSettings(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadPluginSettingsTimeouts(t *testing.T) {
+	source := backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"deploymentType": "self-hosted-dev", "queryTimeout": 120, "metaTimeout": 15, "connectTimeout": 0}`),
+	}
+
+	settings, err := LoadPluginSettings(source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := settings.QueryTimeoutDuration(); got != 120*time.Second {
+		t.Errorf("Expected query timeout 120s, got %s", got)
+	}
+	if got := settings.MetaTimeoutDuration(); got != 15*time.Second {
+		t.Errorf("Expected meta timeout 15s, got %s", got)
+	}
+	if got := settings.ConnectTimeoutDuration(); got != 0 {
+		t.Errorf("Expected zero connect timeout to mean unset, got %s", got)
+	}
+
+	var nilSettings *PluginSettings
+	if got := nilSettings.QueryTimeoutDuration(); got != 0 {
+		t.Errorf("Expected nil settings to have no timeout, got %s", got)
+	}
+}
+
+func TestLoadPluginSettingsNormalizesDeploymentType(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+	}{
+		{"cloud", "cloud"},
+		{" Cloud ", "cloud"},
+		{"SELF-HOSTED", "self-hosted"},
+		{"selfhosted", "self-hosted"},
+		{"Self_Hosted", "self-hosted"},
+		{"dev", "self-hosted-dev"},
+		{"self-hosted-dev\n", "self-hosted-dev"},
+		{"Bogus", "bogus"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			jsonData, _ := json.Marshal(map[string]string{"deploymentType": tt.raw})
+			settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{JSONData: jsonData})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if settings.DeploymentType != tt.expected {
+				t.Errorf("Expected deployment type %q, got %q", tt.expected, settings.DeploymentType)
+			}
+		})
+	}
+}
+
+func TestSlowQueryThreshold(t *testing.T) {
+	settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"deploymentType": "self-hosted-dev", "slowQueryThresholdMs": 2500}`),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := settings.SlowQueryThreshold(); got != 2500*time.Millisecond {
+		t.Errorf("Expected threshold 2.5s, got %v", got)
+	}
+
+	var unset *PluginSettings
+	if got := unset.SlowQueryThreshold(); got != 0 {
+		t.Errorf("Expected nil settings to disable slow query logging, got %v", got)
+	}
+}
+
+func TestResultCacheTTLDuration(t *testing.T) {
+	settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"deploymentType": "self-hosted-dev", "resultCacheTTL": 30, "resultCacheMaxEntries": 100}`),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := settings.ResultCacheTTLDuration(); got != 30*time.Second {
+		t.Errorf("Expected TTL 30s, got %v", got)
+	}
+	if settings.ResultCacheMaxEntries == nil || *settings.ResultCacheMaxEntries != 100 {
+		t.Errorf("Expected resultCacheMaxEntries 100, got %v", settings.ResultCacheMaxEntries)
+	}
+
+	var unset *PluginSettings
+	if got := unset.ResultCacheTTLDuration(); got != 0 {
+		t.Errorf("Expected nil settings to disable the result cache, got %v", got)
+	}
+}
+
+func TestRowLimit(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	tests := []struct {
+		name     string
+		jsonData string
+		limit    *int
+		want     *int
+	}{
+		{name: "unset", jsonData: `{}`, limit: intPtr(50000), want: intPtr(50000)},
+		{name: "default applies", jsonData: `{"defaultLimit": 1000}`, want: intPtr(1000)},
+		{name: "query limit wins over default", jsonData: `{"defaultLimit": 1000}`, limit: intPtr(10), want: intPtr(10)},
+		{name: "clamped to max", jsonData: `{"defaultLimit": 1000, "maxLimit": 5000}`, limit: intPtr(50000), want: intPtr(5000)},
+		{name: "max without default", jsonData: `{"maxLimit": 5000}`, want: intPtr(5000)},
+		{name: "default above max", jsonData: `{"defaultLimit": 10000, "maxLimit": 5000}`, want: intPtr(5000)},
+		{name: "zero disables", jsonData: `{"defaultLimit": 0, "maxLimit": 0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var settings PluginSettings
+			if err := json.Unmarshal([]byte(tt.jsonData), &settings); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got := settings.RowLimit(tt.limit)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("Expected limit %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAllowedViews(t *testing.T) {
+	settings := &PluginSettings{AllowedViews: []string{"orders_view"}}
+	if !settings.ViewAllowed("orders_view") || settings.ViewAllowed("users_view") {
+		t.Errorf("Expected only orders_view to be allowed")
+	}
+	if !settings.MemberAllowed("orders_view.count") || settings.MemberAllowed("users_view.count") || settings.MemberAllowed("orders_view_2.count") {
+		t.Errorf("Expected only members of orders_view to be allowed")
+	}
+
+	var unset *PluginSettings
+	if !unset.ViewAllowed("users_view") || !(&PluginSettings{}).MemberAllowed("users_view.count") {
+		t.Errorf("Expected every view to be allowed without an allowlist")
+	}
+}
+
+func TestLoadPluginSettingsDefaultFilters(t *testing.T) {
+	settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"deploymentType": "cloud", "defaultFilters": [{"member": "orders.tenant", "operator": "equals", "values": ["acme"]}]}`),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(settings.DefaultFilters) != 1 || settings.DefaultFilters[0]["member"] != "orders.tenant" {
+		t.Errorf("Unexpected default filters %v", settings.DefaultFilters)
+	}
+
+	if _, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"deploymentType": "cloud", "defaultFilters": "orders.tenant = acme"}`),
+	}); err == nil {
+		t.Error("Expected defaultFilters that are not an array of filters to be rejected")
+	}
+}
+
+func TestLoadPluginSettingsContinueWait(t *testing.T) {
+	settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"deploymentType": "self-hosted-dev", "continueWaitPollInterval": 2, "continueWaitMaxDuration": 300}`),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := settings.ContinueWaitPollIntervalDuration(); got != 2*time.Second {
+		t.Errorf("Expected poll interval 2s, got %s", got)
+	}
+	if got := settings.MaxContinueWait(); got != 5*time.Minute {
+		t.Errorf("Expected max continue wait 5m, got %s", got)
+	}
+
+	var nilSettings *PluginSettings
+	if nilSettings.ContinueWaitPollIntervalDuration() != 0 || nilSettings.MaxContinueWait() != 0 {
+		t.Errorf("Expected nil settings to poll immediately without a limit")
+	}
+}
+
+func TestLoadPluginSettingsSecurityContext(t *testing.T) {
+	settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"deploymentType": "self-hosted", "securityContext": {"tenant": "acme", "level": 2}}`),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := map[string]interface{}{"tenant": "acme", "level": float64(2)}
+	if !reflect.DeepEqual(settings.SecurityContext, want) {
+		t.Errorf("Expected security context %v, got %v", want, settings.SecurityContext)
+	}
+}
+
+func TestLoadPluginSettingsCustomHeaders(t *testing.T) {
+	settings, err := LoadPluginSettings(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"deploymentType": "self-hosted-dev", "httpHeaderName1": "X-Tenant", "httpHeaderName2": "X-Waf-Token", "httpHeaderName3": " "}`),
+		DecryptedSecureJSONData: map[string]string{
+			"httpHeaderValue1": "acme",
+			"httpHeaderValue2": "token",
+			"httpHeaderValue3": "ignored",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := map[string]string{"X-Tenant": "acme", "X-Waf-Token": "token"}
+	if !reflect.DeepEqual(settings.CustomHeaders, want) {
+		t.Errorf("Expected custom headers %v, got %v", want, settings.CustomHeaders)
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	settings := &PluginSettings{URL: " http://cube-a:4000/ ,, http://cube-b:4000"}
+	if want := []string{"http://cube-a:4000", "http://cube-b:4000"}; !reflect.DeepEqual(settings.Endpoints(), want) {
+		t.Errorf("Expected %v, got %v", want, settings.Endpoints())
+	}
+	var unset *PluginSettings
+	if unset.Endpoints() != nil || (&PluginSettings{URL: " "}).Endpoints() != nil {
+		t.Errorf("Expected no endpoints without a URL")
+	}
+}
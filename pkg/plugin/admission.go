@@ -0,0 +1,216 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// fieldError is a settings error about a single field, named by its path in
+// the datasource object, e.g. jsonData.deploymentType.
+type fieldError struct {
+	field string
+	msg   string
+}
+
+func (e *fieldError) Error() string { return e.msg }
+
+// AdmissionHandler validates datasource settings when they are saved, so an
+// invalid configuration is rejected with the fields to fix instead of failing
+// later on every query. It is stateless.
+type AdmissionHandler struct{}
+
+// ValidateAdmission rejects datasource settings with errors; see
+// settingsErrors.
+func (AdmissionHandler) ValidateAdmission(_ context.Context, req *backend.AdmissionRequest) (*backend.ValidationResponse, error) {
+	if req.Operation == backend.AdmissionRequestDelete {
+		return &backend.ValidationResponse{Allowed: true}, nil
+	}
+	settings, err := admissionSettings(req)
+	if err != nil {
+		return &backend.ValidationResponse{Result: admissionFailure([]string{err.Error()})}, nil
+	}
+	if errs := admissionErrors(req, settings); len(errs) > 0 {
+		return &backend.ValidationResponse{Result: admissionFailure(errs)}, nil
+	}
+	return &backend.ValidationResponse{Allowed: true}, nil
+}
+
+// MutateAdmission stores the deployment type, JWT algorithm and query
+// transport in their canonical spelling and the URL without surrounding
+// whitespace, then validates the settings like ValidateAdmission.
+func (AdmissionHandler) MutateAdmission(_ context.Context, req *backend.AdmissionRequest) (*backend.MutationResponse, error) {
+	if req.Operation == backend.AdmissionRequestDelete {
+		return &backend.MutationResponse{Allowed: true, ObjectBytes: req.ObjectBytes}, nil
+	}
+	settings, err := admissionSettings(req)
+	if err != nil {
+		return &backend.MutationResponse{Result: admissionFailure([]string{err.Error()})}, nil
+	}
+	if errs := admissionErrors(req, settings); len(errs) > 0 {
+		return &backend.MutationResponse{Result: admissionFailure(errs)}, nil
+	}
+
+	settings.URL = strings.TrimSpace(settings.URL)
+	if settings.JSONData, err = normalizeSettingsJSON(settings.JSONData); err != nil {
+		return &backend.MutationResponse{Result: admissionFailure([]string{err.Error()})}, nil
+	}
+	objectBytes, err := backend.DataSourceInstanceSettingsToProtoBytes(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode datasource settings: %w", err)
+	}
+	return &backend.MutationResponse{Allowed: true, ObjectBytes: objectBytes}, nil
+}
+
+// admissionSettings decodes the datasource settings of an admission request.
+func admissionSettings(req *backend.AdmissionRequest) (*backend.DataSourceInstanceSettings, error) {
+	settings, err := backend.DataSourceInstanceSettingsFromProto(req.ObjectBytes, req.PluginContext.PluginID)
+	if err != nil || settings == nil {
+		return nil, errors.New("invalid datasource settings")
+	}
+	return settings, nil
+}
+
+// admissionErrors returns the errors of the settings of an admission request.
+// On updates, secrets left out of the request are validated with their stored
+// value. They are merged into a copy, so settings keep only the request's
+// secrets and a mutated object never echoes the stored ones back.
+func admissionErrors(req *backend.AdmissionRequest, settings *backend.DataSourceInstanceSettings) []string {
+	if req.Operation != backend.AdmissionRequestUpdate {
+		return settingsErrors(settings)
+	}
+	old, err := backend.DataSourceInstanceSettingsFromProto(req.OldObjectBytes, req.PluginContext.PluginID)
+	if err != nil || old == nil {
+		return settingsErrors(settings)
+	}
+	secrets := maps.Clone(old.DecryptedSecureJSONData)
+	if secrets == nil {
+		secrets = map[string]string{}
+	}
+	maps.Copy(secrets, settings.DecryptedSecureJSONData)
+	merged := *settings
+	merged.DecryptedSecureJSONData = secrets
+	return settingsErrors(&merged)
+}
+
+// settingsErrors returns the errors of datasource settings, each prefixed
+// with the field it is about.
+func settingsErrors(settings *backend.DataSourceInstanceSettings) []string {
+	var errs []string
+	add := func(err error) {
+		var fieldErr *fieldError
+		if errors.As(err, &fieldErr) {
+			errs = append(errs, fieldErr.field+": "+fieldErr.msg)
+		} else {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if err := validateCubeURLs(settings.URL); err != nil {
+		add(err)
+	}
+	if err := validateSettingsJSON(settings.JSONData); err != nil {
+		// The other checks need the settings to decode.
+		add(err)
+		return errs
+	}
+	config, err := models.LoadPluginSettings(*settings)
+	if err != nil {
+		add(err)
+		return errs
+	}
+	if err := validateCredentials(config); err != nil {
+		add(err)
+	}
+	if err := validateQueryTransport(config); err != nil {
+		add(&fieldError{field: "jsonData.queryTransport", msg: err.Error()})
+	}
+	if _, err := config.FiscalYearStart(); err != nil {
+		add(&fieldError{field: "jsonData.fiscalYearStartMonth", msg: err.Error()})
+	}
+	return errs
+}
+
+// validateSettingsJSON checks that jsonData decodes into the settings,
+// naming the field of a value with the wrong type, e.g. a securityContext
+// that is not an object.
+func validateSettingsJSON(jsonData []byte) error {
+	if len(jsonData) == 0 {
+		return nil
+	}
+	var settings models.PluginSettings
+	err := json.Unmarshal(jsonData, &settings)
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return &fieldError{field: "jsonData." + typeErr.Field, msg: fmt.Sprintf("expected %s, got %s", jsonTypeName(typeErr.Type), typeErr.Value)}
+	default:
+		return &fieldError{field: "jsonData", msg: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+}
+
+// jsonTypeName names the JSON type a Go type decodes from.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	default:
+		return "a number"
+	}
+}
+
+// normalizeSettingsJSON rewrites the deployment type, JWT algorithm and
+// query transport of jsonData in their canonical spelling, keeping the other
+// fields as they are.
+func normalizeSettingsJSON(jsonData []byte) ([]byte, error) {
+	if len(jsonData) == 0 {
+		return jsonData, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(jsonData, &fields); err != nil {
+		return nil, fmt.Errorf("jsonData: invalid JSON: %w", err)
+	}
+	for key, normalize := range map[string]func(string) string{
+		"deploymentType": models.NormalizeDeploymentType,
+		"jwtAlgorithm":   func(s string) string { return strings.ToUpper(strings.TrimSpace(s)) },
+		"queryTransport": func(s string) string { return strings.ToLower(strings.TrimSpace(s)) },
+	} {
+		var value string
+		if raw, ok := fields[key]; !ok || json.Unmarshal(raw, &value) != nil {
+			continue
+		}
+		normalized, err := json.Marshal(normalize(value))
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = normalized
+	}
+	return json.Marshal(fields)
+}
+
+// admissionFailure is the result of rejected settings.
+func admissionFailure(errs []string) *backend.StatusResult {
+	return &backend.StatusResult{
+		Status:  "Failure",
+		Message: "invalid datasource settings: " + strings.Join(errs, "; "),
+		Reason:  "Invalid",
+		Code:    400,
+	}
+}
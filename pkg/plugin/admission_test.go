@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// admissionRequest returns an admission request for settings.
+func admissionRequest(t *testing.T, op backend.AdmissionRequestOperation, settings, old *backend.DataSourceInstanceSettings) *backend.AdmissionRequest {
+	t.Helper()
+	objectBytes, err := backend.DataSourceInstanceSettingsToProtoBytes(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldObjectBytes, err := backend.DataSourceInstanceSettingsToProtoBytes(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &backend.AdmissionRequest{Operation: op, ObjectBytes: objectBytes, OldObjectBytes: oldObjectBytes}
+}
+
+func TestValidateAdmission(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings backend.DataSourceInstanceSettings
+		old      *backend.DataSourceInstanceSettings
+		want     []string
+	}{
+		{
+			name:     "valid",
+			settings: backend.DataSourceInstanceSettings{URL: "https://cube.example.com", JSONData: []byte(`{"deploymentType": "cloud"}`), DecryptedSecureJSONData: map[string]string{"apiKey": "key"}},
+		},
+		{
+			name:     "bad URL scheme and missing secret",
+			settings: backend.DataSourceInstanceSettings{URL: "ftp://cube.example.com", JSONData: []byte(`{"deploymentType": "self-hosted"}`)},
+			want:     []string{`url: invalid Cube API URL format: invalid protocol scheme "ftp"`, "secureJsonData.apiSecret: API secret is required"},
+		},
+		{
+			name:     "malformed securityContext",
+			settings: backend.DataSourceInstanceSettings{URL: "http://cube:4000", JSONData: []byte(`{"deploymentType": "self-hosted", "securityContext": "{tenant: acme}"}`)},
+			want:     []string{"jsonData.securityContext: expected an object, got string"},
+		},
+		{
+			name:     "unknown settings values",
+			settings: backend.DataSourceInstanceSettings{URL: "http://cube:4000", JSONData: []byte(`{"deploymentType": "local", "queryTransport": "odbc", "fiscalYearStartMonth": 13}`)},
+			want:     []string{"jsonData.deploymentType: unknown deployment type", "jsonData.queryTransport: unknown query transport", "jsonData.fiscalYearStartMonth: invalid fiscalYearStartMonth 13"},
+		},
+		{
+			name:     "stored secret on update",
+			settings: backend.DataSourceInstanceSettings{URL: "http://cube:4000", JSONData: []byte(`{"deploymentType": "self-hosted"}`)},
+			old:      &backend.DataSourceInstanceSettings{DecryptedSecureJSONData: map[string]string{"apiSecret": "secret"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := backend.AdmissionRequestCreate
+			if tt.old != nil {
+				op = backend.AdmissionRequestUpdate
+			}
+			resp, err := AdmissionHandler{}.ValidateAdmission(context.Background(), admissionRequest(t, op, &tt.settings, tt.old))
+			if err != nil {
+				t.Fatalf("ValidateAdmission failed: %v", err)
+			}
+			if len(tt.want) == 0 {
+				if !resp.Allowed {
+					t.Errorf("expected the settings to be allowed, got %+v", resp.Result)
+				}
+				return
+			}
+			if resp.Allowed || resp.Result == nil || resp.Result.Code != 400 {
+				t.Fatalf("expected the settings to be rejected, got %+v", resp)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(resp.Result.Message, want) {
+					t.Errorf("expected %q in %q", want, resp.Result.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestMutateAdmission(t *testing.T) {
+	settings := &backend.DataSourceInstanceSettings{
+		URL:                     " http://cube:4000 ",
+		JSONData:                []byte(`{"deploymentType": "SelfHosted", "jwtAlgorithm": "hs256", "queryTransport": "REST", "jwtTTL": 60}`),
+		DecryptedSecureJSONData: map[string]string{"apiSecret": "secret"},
+	}
+	resp, err := AdmissionHandler{}.MutateAdmission(context.Background(), admissionRequest(t, backend.AdmissionRequestCreate, settings, nil))
+	if err != nil {
+		t.Fatalf("MutateAdmission failed: %v", err)
+	}
+	if !resp.Allowed {
+		t.Fatalf("expected the settings to be allowed, got %+v", resp.Result)
+	}
+	mutated, err := backend.DataSourceInstanceSettingsFromProto(resp.ObjectBytes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mutated.URL != "http://cube:4000" {
+		t.Errorf("expected the URL trimmed, got %q", mutated.URL)
+	}
+	if want := `{"deploymentType":"self-hosted","jwtAlgorithm":"HS256","jwtTTL":60,"queryTransport":"rest"}`; string(mutated.JSONData) != want {
+		t.Errorf("expected %s, got %s", want, mutated.JSONData)
+	}
+}
+
+func TestMutateAdmissionDoesNotEchoStoredSecrets(t *testing.T) {
+	old := &backend.DataSourceInstanceSettings{
+		URL:                     "http://cube:4000",
+		JSONData:                []byte(`{"deploymentType": "self-hosted"}`),
+		DecryptedSecureJSONData: map[string]string{"apiSecret": "stored-secret", "sqlApiPassword": "stored-password"},
+	}
+	settings := &backend.DataSourceInstanceSettings{
+		URL:                     "http://cube:4000",
+		JSONData:                []byte(`{"deploymentType": "SelfHosted"}`),
+		DecryptedSecureJSONData: map[string]string{"sqlApiPassword": "new-password"},
+	}
+	resp, err := AdmissionHandler{}.MutateAdmission(context.Background(), admissionRequest(t, backend.AdmissionRequestUpdate, settings, old))
+	if err != nil {
+		t.Fatalf("MutateAdmission failed: %v", err)
+	}
+	if !resp.Allowed {
+		t.Fatalf("expected the stored apiSecret to satisfy validation, got %+v", resp.Result)
+	}
+	mutated, err := backend.DataSourceInstanceSettingsFromProto(resp.ObjectBytes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mutated.DecryptedSecureJSONData) != 1 || mutated.DecryptedSecureJSONData["sqlApiPassword"] != "new-password" {
+		t.Errorf("expected only the request's secrets, got %v", mutated.DecryptedSecureJSONData)
+	}
+}
@@ -0,0 +1,165 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Supported values for CubeQuery.QueryType.
+const (
+	// queryTypeInstant returns one value per series: a numeric wide frame.
+	queryTypeInstant = "instant"
+	// queryTypeRange returns series over time: a time series wide frame.
+	queryTypeRange = "range"
+)
+
+// validateQueryType checks that a query type is one we know how to shape.
+// An empty value keeps the frame as Cube returned it.
+func validateQueryType(queryType string) error {
+	switch queryType {
+	case "", queryTypeInstant, queryTypeRange:
+		return nil
+	default:
+		return fmt.Errorf("invalid queryType %q (must be %q or %q)", queryType, queryTypeInstant, queryTypeRange)
+	}
+}
+
+// alertingSeries is the values of the measures of one label set.
+type alertingSeries struct {
+	labels data.Labels
+	// values maps a measure to its values by time; instant queries use the
+	// zero time.
+	values map[string]map[time.Time]*float64
+}
+
+// alertingFrame reshapes a long frame for alert rules and other consumers
+// that expect Grafana's numeric data types. Every measure becomes a nullable
+// float64 field named after the measure, one per series; the values of the
+// other (non-time) dimensions become the field's labels, so a query grouped
+// by several dimensions produces one series per combination.
+//
+// Range queries produce a time series wide frame, with the first time
+// dimension as the time field; they fail when the query has none. Instant
+// queries produce a numeric wide frame with a single row holding the last
+// value of each series.
+func alertingFrame(frame *data.Frame, query CubeQuery) (*data.Frame, error) {
+	var timeField *data.Field
+	var measureFields, labelFields []*data.Field
+	for _, field := range frame.Fields {
+		switch {
+		case slices.Contains(query.Measures, field.Name):
+			measureFields = append(measureFields, field)
+		case field.Type().Time() && timeField == nil:
+			timeField = field
+		case !field.Type().Time():
+			labelFields = append(labelFields, field)
+		}
+	}
+	if query.QueryType == queryTypeRange && timeField == nil {
+		return nil, fmt.Errorf("%s queries need a time dimension", queryTypeRange)
+	}
+
+	var series []*alertingSeries
+	seriesByKey := make(map[string]*alertingSeries)
+	var times []time.Time
+	seenTimes := make(map[time.Time]bool)
+	for row := 0; row < frame.Rows(); row++ {
+		var at time.Time
+		if query.QueryType == queryTypeRange {
+			t, ok := timeField.ConcreteAt(row)
+			if !ok {
+				continue
+			}
+			at = t.(time.Time)
+			if !seenTimes[at] {
+				seenTimes[at] = true
+				times = append(times, at)
+			}
+		}
+
+		labels := make(data.Labels, len(labelFields))
+		for _, field := range labelFields {
+			labels[field.Name] = labelValue(field, row)
+		}
+		key := labels.String()
+		s, ok := seriesByKey[key]
+		if !ok {
+			s = &alertingSeries{labels: labels, values: make(map[string]map[time.Time]*float64)}
+			seriesByKey[key] = s
+			series = append(series, s)
+		}
+		for _, field := range measureFields {
+			if s.values[field.Name] == nil {
+				s.values[field.Name] = make(map[time.Time]*float64)
+			}
+			// Rows are in Cube's order, so for instant queries the last
+			// row of a series wins.
+			value, err := field.NullableFloatAt(row)
+			if err != nil {
+				value = nil
+			}
+			s.values[field.Name][at] = value
+		}
+	}
+
+	// Series come in a stable order regardless of Cube's row order.
+	sort.SliceStable(series, func(i, j int) bool {
+		return series[i].labels.String() < series[j].labels.String()
+	})
+
+	result := data.NewFrame(frame.Name)
+	if query.QueryType == queryTypeRange {
+		slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+		result.Fields = append(result.Fields, data.NewField(timeField.Name, nil, times))
+		result.SetMeta(&data.FrameMeta{Type: data.FrameTypeTimeSeriesWide, TypeVersion: data.FrameTypeVersion{0, 1}})
+	} else {
+		times = []time.Time{{}}
+		result.SetMeta(&data.FrameMeta{Type: data.FrameTypeNumericWide, TypeVersion: data.FrameTypeVersion{0, 1}})
+	}
+
+	for _, s := range series {
+		for _, measure := range measureFields {
+			values := make([]*float64, len(times))
+			for i, t := range times {
+				values[i] = s.values[measure.Name][t]
+			}
+			field := data.NewField(measure.Name, s.labels, values)
+			field.Config = measure.Config
+			result.Fields = append(result.Fields, field)
+		}
+	}
+	// A measures-only result has a single series without labels; keep its
+	// fields when Cube returned no rows so the query reports null values
+	// rather than no data.
+	if len(series) == 0 && len(labelFields) == 0 && query.QueryType == queryTypeInstant {
+		for _, measure := range measureFields {
+			field := data.NewField(measure.Name, nil, []*float64{nil})
+			field.Config = measure.Config
+			result.Fields = append(result.Fields, field)
+		}
+	}
+	return result, nil
+}
+
+// labelValue formats the value of a label field at row; null values are
+// empty labels.
+func labelValue(field *data.Field, row int) string {
+	value, ok := field.ConcreteAt(row)
+	if !ok {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return strings.TrimSpace(fmt.Sprint(v))
+	}
+}
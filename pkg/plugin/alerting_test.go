@@ -0,0 +1,133 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestQueryDataRangeQueryType(t *testing.T) {
+	server := newCubeLoadServer(t, CubeAPIResponse{
+		Data: []map[string]interface{}{
+			{"orders.created_at": "2024-01-02T00:00:00.000", "orders.status": "shipped", "orders.count": "3"},
+			{"orders.created_at": "2024-01-01T00:00:00.000", "orders.status": "shipped", "orders.count": "1"},
+			{"orders.created_at": "2024-01-01T00:00:00.000", "orders.status": "pending", "orders.count": "2"},
+		},
+		Annotation: CubeAnnotation{
+			Dimensions:     map[string]CubeFieldInfo{"orders.status": {Type: "string"}},
+			Measures:       map[string]CubeFieldInfo{"orders.count": {Type: "number"}},
+			TimeDimensions: map[string]CubeFieldInfo{"orders.created_at": {Type: "time"}},
+		},
+	})
+	ds := &Datasource{BaseURL: server.URL}
+
+	res := runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId":"A","queryType":"range",
+		"measures":["orders.count"],"dimensions":["orders.created_at","orders.status"]}`)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+
+	frame := res.Frames[0]
+	if frame.Meta == nil || frame.Meta.Type != data.FrameTypeTimeSeriesWide {
+		t.Fatalf("expected a time series wide frame, got %+v", frame.Meta)
+	}
+	if len(frame.Fields) != 3 || frame.Rows() != 2 {
+		t.Fatalf("expected a time field and two series over two times, got %d fields and %d rows", len(frame.Fields), frame.Rows())
+	}
+	if got := frame.Fields[0].At(0).(time.Time); !got.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected times in ascending order, got %v first", got)
+	}
+	pending, shipped := frame.Fields[1], frame.Fields[2]
+	if pending.Name != "orders.count" || pending.Labels["orders.status"] != "pending" || shipped.Labels["orders.status"] != "shipped" {
+		t.Fatalf("expected orders.count series labelled by status, got %s%v and %s%v", pending.Name, pending.Labels, shipped.Name, shipped.Labels)
+	}
+	if v, _ := pending.NullableFloatAt(1); v != nil {
+		t.Errorf("expected a null where the series has no value, got %v", *v)
+	}
+	if v, _ := shipped.NullableFloatAt(1); v == nil || *v != 3 {
+		t.Errorf("expected 3, got %v", v)
+	}
+}
+
+func TestQueryDataInstantQueryType(t *testing.T) {
+	server := newCubeLoadServer(t, CubeAPIResponse{
+		Data: []map[string]interface{}{
+			{"orders.status": "shipped", "orders.region": "eu", "orders.count": "3", "orders.total": "30"},
+			{"orders.status": "pending", "orders.region": "eu", "orders.count": "2", "orders.total": "20"},
+		},
+		Annotation: CubeAnnotation{
+			Dimensions: map[string]CubeFieldInfo{"orders.status": {Type: "string"}, "orders.region": {Type: "string"}},
+			Measures:   map[string]CubeFieldInfo{"orders.count": {Type: "number"}, "orders.total": {Type: "number"}},
+		},
+	})
+	ds := &Datasource{BaseURL: server.URL}
+
+	res := ds.query(t.Context(), newTestPluginContext(server.URL), backend.DataQuery{
+		RefID:     "A",
+		QueryType: "instant",
+		JSON:      []byte(`{"refId":"A","measures":["orders.count","orders.total"],"dimensions":["orders.status","orders.region"]}`),
+	})
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+
+	frame := res.Frames[0]
+	if frame.Meta == nil || frame.Meta.Type != data.FrameTypeNumericWide {
+		t.Fatalf("expected a numeric wide frame, got %+v", frame.Meta)
+	}
+	if len(frame.Fields) != 4 || frame.Rows() != 1 {
+		t.Fatalf("expected one field per measure and series in a single row, got %d fields and %d rows", len(frame.Fields), frame.Rows())
+	}
+	first := frame.Fields[0]
+	if first.Name != "orders.count" || first.Labels.String() != "orders.region=eu, orders.status=pending" {
+		t.Errorf("expected the pending series first, got %s{%s}", first.Name, first.Labels)
+	}
+	if v, _ := first.NullableFloatAt(0); v == nil || *v != 2 {
+		t.Errorf("expected 2, got %v", v)
+	}
+}
+
+func TestQueryDataInstantQueryTypeMeasuresOnlyEmptyResult(t *testing.T) {
+	server := newCubeLoadServer(t, CubeAPIResponse{
+		Data:       []map[string]interface{}{},
+		Annotation: CubeAnnotation{Measures: map[string]CubeFieldInfo{"orders.count": {Type: "number"}}},
+	})
+	ds := &Datasource{BaseURL: server.URL}
+
+	res := runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId":"A","queryType":"instant","measures":["orders.count"]}`)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	frame := res.Frames[0]
+	if len(frame.Fields) != 1 || frame.Rows() != 1 {
+		t.Fatalf("expected a single null value, got %d fields and %d rows", len(frame.Fields), frame.Rows())
+	}
+	if v, _ := frame.Fields[0].NullableFloatAt(0); v != nil {
+		t.Errorf("expected null, got %v", *v)
+	}
+}
+
+func TestQueryDataQueryTypeErrors(t *testing.T) {
+	server := newCubeLoadServer(t, CubeAPIResponse{
+		Data:       []map[string]interface{}{{"orders.count": "1"}},
+		Annotation: CubeAnnotation{Measures: map[string]CubeFieldInfo{"orders.count": {Type: "number"}}},
+	})
+	ds := &Datasource{BaseURL: server.URL}
+
+	tests := []struct {
+		query   string
+		wantErr string
+	}{
+		{query: `{"refId":"A","queryType":"logs","measures":["orders.count"]}`, wantErr: "invalid queryType"},
+		{query: `{"refId":"A","queryType":"range","measures":["orders.count"]}`, wantErr: "range queries need a time dimension"},
+	}
+	for _, tt := range tests {
+		res := runSingleQuery(t, ds, newTestPluginContext(server.URL), tt.query)
+		if res.Error == nil || res.Status != backend.StatusBadRequest || !strings.Contains(res.Error.Error(), tt.wantErr) {
+			t.Errorf("expected a bad request containing %q, got %d: %v", tt.wantErr, res.Status, res.Error)
+		}
+	}
+}
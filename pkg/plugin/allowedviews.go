@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/cube/pkg/models"
+)
+
+// checkAllowedMembers returns an error naming the first member outside the
+// datasource's allowedViews.
+func checkAllowedMembers(config *models.PluginSettings, members []string) error {
+	for _, member := range members {
+		if member != "" && !config.MemberAllowed(member) {
+			return fmt.Errorf("%s is not in the views this datasource allows", member)
+		}
+	}
+	return nil
+}
+
+// checkAllowedQueryJSON is checkAllowedMembers for the members and segments
+// of a Cube query given as JSON.
+func checkAllowedQueryJSON(config *models.PluginSettings, queryJSON string) error {
+	var query CubeQuery
+	if err := json.Unmarshal([]byte(queryJSON), &query); err != nil {
+		return err
+	}
+	var segments struct {
+		Segments []string `json:"segments"`
+	}
+	if err := json.Unmarshal([]byte(queryJSON), &segments); err != nil {
+		return err
+	}
+	return checkAllowedMembers(config, append(queryMembers(query), segments.Segments...))
+}
+
+// tagValuesMembers returns the members a tag values query reads: the key and
+// the members of its scoping filters and segments.
+func tagValuesMembers(key string, filters []interface{}, segments []string) []string {
+	members := []string{key}
+	walkMemberFilters(filters, func(member string, _ map[string]interface{}) {
+		members = append(members, member)
+	})
+	return append(members, segments...)
+}
@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func newAllowedViewsServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+			_ = json.NewEncoder(w).Encode(CubeMetaResponse{Cubes: []CubeMeta{
+				{Name: "orders_view", Type: "view", Dimensions: []CubeDimension{{Name: "orders_view.status", Type: "string"}}},
+				{Name: "salaries_view", Type: "view", Dimensions: []CubeDimension{{Name: "salaries_view.amount", Type: "number"}}},
+			}})
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": [{"orders_view.status": "shipped"}]}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func allowedViewsPluginContext(url string) backend.PluginContext {
+	pCtx := newTestPluginContext(url)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "allowedViews": ["orders_view"]}`)
+	return pCtx
+}
+
+func TestQueryDataAllowedViews(t *testing.T) {
+	server := newAllowedViewsServer(t)
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := allowedViewsPluginContext(server.URL)
+
+	tests := []struct {
+		name    string
+		query   string
+		wantErr string
+	}{
+		{name: "allowed", query: `{"refId":"A","dimensions":["orders_view.status"]}`},
+		{name: "dimension of another view", query: `{"refId":"A","dimensions":["salaries_view.amount"]}`, wantErr: "salaries_view.amount is not in the views this datasource allows"},
+		{
+			name:    "filter on another view",
+			query:   `{"refId":"A","dimensions":["orders_view.status"],"filters":[{"member":"salaries_view.amount","operator":"gt","values":["1"]}]}`,
+			wantErr: "salaries_view.amount is not in the views this datasource allows",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runSingleQuery(t, ds, pCtx, tt.query)
+			if tt.wantErr == "" {
+				if res.Error != nil {
+					t.Fatalf("unexpected error: %v", res.Error)
+				}
+				return
+			}
+			if res.Error == nil || !strings.Contains(res.Error.Error(), tt.wantErr) || res.Status != backend.StatusForbidden {
+				t.Errorf("expected a 403 containing %q, got %d: %v", tt.wantErr, res.Status, res.Error)
+			}
+		})
+	}
+}
+
+func TestHandleMetadataAllowedViews(t *testing.T) {
+	server := newAllowedViewsServer(t)
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := allowedViewsPluginContext(server.URL)
+
+	resp := callHandler(t, ds.handleMetadata, &backend.CallResourceRequest{PluginContext: pCtx, URL: "metadata"})
+	var metadata MetadataResponse
+	if err := json.Unmarshal(resp.Body, &metadata); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(metadata.Dimensions) != 1 || metadata.Dimensions[0].Value != "orders_view.status" {
+		t.Errorf("expected only the allowed view's dimensions, got %+v", metadata.Dimensions)
+	}
+
+	resp = callHandler(t, ds.handleMetadata, &backend.CallResourceRequest{PluginContext: pCtx, URL: "metadata?cube=salaries_view"})
+	if resp.Status != http.StatusNotFound {
+		t.Errorf("expected a view outside the allowlist to be not found, got %d: %s", resp.Status, resp.Body)
+	}
+}
+
+func TestQueryResourcesAllowedViews(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/v1/dry-run") {
+			_, _ = w.Write([]byte(`{"queryType": "regularQuery", "normalizedQueries": [], "pivotQuery": {}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"sql": {"sql": ["SELECT 1", []]}}`))
+	}))
+	defer server.Close()
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := allowedViewsPluginContext(server.URL)
+
+	for name, handler := range map[string]backend.CallResourceHandlerFunc{
+		"sql":                     ds.handleSQLCompilation,
+		"dry-run":                 ds.handleDryRun,
+		"pre-aggregation-preview": ds.handlePreAggregationPreview,
+	} {
+		t.Run(name, func(t *testing.T) {
+			for query, want := range map[string]int{
+				`{"dimensions": ["salaries_view.amount"]}`:                                      http.StatusForbidden,
+				`{"measures": ["orders_view.count"], "segments": ["salaries_view.high"]}`:       http.StatusForbidden,
+				`{"measures": ["orders_view.count"], "order": {"salaries_view.amount": "asc"}}`: http.StatusForbidden,
+				`{"dimensions": ["orders_view.status"]}`:                                        http.StatusOK,
+			} {
+				resp := callHandler(t, handler, &backend.CallResourceRequest{PluginContext: pCtx, URL: name + "?query=" + url.QueryEscape(query)})
+				if resp.Status != want {
+					t.Errorf("%s: expected %d, got %d: %s", query, want, resp.Status, resp.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleTagValuesAllowedViews(t *testing.T) {
+	server := newAllowedViewsServer(t)
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := allowedViewsPluginContext(server.URL)
+
+	resp := callHandler(t, ds.handleTagValues, &backend.CallResourceRequest{PluginContext: pCtx, URL: "tag-values?key=salaries_view.amount"})
+	if resp.Status != http.StatusForbidden {
+		t.Errorf("expected 403, got %d: %s", resp.Status, resp.Body)
+	}
+	resp = callHandler(t, ds.handleTagValues, &backend.CallResourceRequest{PluginContext: pCtx, URL: "tag-values?key=orders_view.status"})
+	if resp.Status != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+}
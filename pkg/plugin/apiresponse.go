@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxBodySummaryLength bounds the part of a non-JSON body quoted in errors.
+const maxBodySummaryLength = 200
+
+// nonJSONResponseError reports a Cube API response whose body is not JSON.
+// This is almost always an error page from a reverse proxy or load balancer in
+// front of Cube (nginx's "502 Bad Gateway", an SSO login page, ...) rather
+// than a Cube answer, so the error names the status and the page instead of
+// failing to parse it.
+type nonJSONResponseError struct {
+	StatusCode  int
+	ContentType string
+	// FirstLine is the first meaningful line of the body, truncated.
+	FirstLine string
+}
+
+func (e *nonJSONResponseError) Error() string {
+	msg := fmt.Sprintf("Cube API returned a non-JSON response (HTTP %d", e.StatusCode)
+	if e.ContentType != "" {
+		msg += ", " + e.ContentType
+	}
+	msg += "); check for a proxy or gateway in front of Cube"
+	if e.FirstLine != "" {
+		msg += ": " + e.FirstLine
+	}
+	return msg
+}
+
+// isJSONBody reports whether a response body looks like JSON. Cube answers
+// with JSON objects (and arrays for some playground endpoints); anything else,
+// including an empty body, did not come from Cube's API.
+func isJSONBody(body []byte) bool {
+	trimmed := bytes.TrimSpace(body)
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')
+}
+
+// newNonJSONResponseError describes a non-JSON response body.
+func newNonJSONResponseError(statusCode int, contentType string, body []byte) *nonJSONResponseError {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	return &nonJSONResponseError{
+		StatusCode:  statusCode,
+		ContentType: contentType,
+		FirstLine:   firstBodyLine(body),
+	}
+}
+
+// firstBodyLine returns the first non-blank line of a body, skipping HTML
+// doctype and comment lines, truncated to maxBodySummaryLength.
+func firstBodyLine(body []byte) string {
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "<!") {
+			continue
+		}
+		if len(line) > maxBodySummaryLength {
+			line = line[:maxBodySummaryLength] + "..."
+		}
+		return line
+	}
+	return ""
+}
+
+// readJSONResponse reads the body of a Cube API response, returning an error
+// for non-200 statuses (a *CubeAPIError for JSON error bodies) and for bodies
+// that are not JSON.
+func readJSONResponse(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return jsonResponseBody(resp, body)
+}
+
+// jsonResponseBody checks the body read from a Cube API response like
+// readJSONResponse does.
+func jsonResponseBody(resp *http.Response, body []byte) ([]byte, error) {
+	if resp.StatusCode != http.StatusOK {
+		if !isJSONBody(body) {
+			return nil, newNonJSONResponseError(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+		return nil, &CubeAPIError{StatusCode: resp.StatusCode, Body: body, ContentType: resp.Header.Get("Content-Type")}
+	}
+	if !isJSONBody(body) {
+		return nil, newNonJSONResponseError(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	return body, nil
+}
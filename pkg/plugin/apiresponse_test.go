@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const nginxBadGateway = `<html>
+<head><title>502 Bad Gateway</title></head>
+<body>
+<center><h1>502 Bad Gateway</h1></center>
+<hr><center>nginx</center>
+</body>
+</html>
+`
+
+const ssoLoginPage = `<!DOCTYPE html>
+<html lang="en"><head><title>Sign in</title></head>
+<body><form action="/login"></form></body></html>`
+
+// newHTMLServer answers every request with an HTML page and the given status.
+func newHTMLServer(t *testing.T, status int, page string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(page))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNonJSONResponseError(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		want        string
+	}{
+		{
+			name:        "nginx error page",
+			status:      http.StatusBadGateway,
+			contentType: "text/html; charset=utf-8",
+			body:        nginxBadGateway,
+			want:        "Cube API returned a non-JSON response (HTTP 502, text/html); check for a proxy or gateway in front of Cube: <html>",
+		},
+		{
+			name:        "doctype is skipped",
+			status:      http.StatusOK,
+			contentType: "text/html",
+			body:        ssoLoginPage,
+			want:        `(HTTP 200, text/html); check for a proxy or gateway in front of Cube: <html lang="en"><head><title>Sign in</title></head>`,
+		},
+		{
+			name:   "empty body",
+			status: http.StatusServiceUnavailable,
+			want:   "Cube API returned a non-JSON response (HTTP 503); check for a proxy or gateway in front of Cube",
+		},
+		{
+			name:        "long line is truncated",
+			status:      http.StatusBadGateway,
+			contentType: "text/plain",
+			body:        strings.Repeat("x", 500),
+			want:        ": " + strings.Repeat("x", maxBodySummaryLength) + "...",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newNonJSONResponseError(tt.status, tt.contentType, []byte(tt.body)).Error()
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("expected %q to contain %q", got, tt.want)
+			}
+			if strings.HasSuffix(tt.want, "Cube") && !strings.HasSuffix(got, tt.want) {
+				t.Errorf("expected no body summary, got %q", got)
+			}
+		})
+	}
+}
+
+func TestIsJSONBody(t *testing.T) {
+	for body, want := range map[string]bool{
+		`{"data": []}`:    true,
+		"  \n[1, 2]":      true,
+		nginxBadGateway:   false,
+		"Bad Gateway":     false,
+		"":                false,
+		"   ":             false,
+		`"just a string"`: false,
+	} {
+		if got := isJSONBody([]byte(body)); got != want {
+			t.Errorf("isJSONBody(%q) = %v, want %v", body, got, want)
+		}
+	}
+}
+
+func TestQueryDataHTMLResponses(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		page       string
+		wantStatus backend.Status
+		wantMsg    string
+	}{
+		{name: "proxy error page", status: http.StatusBadGateway, page: nginxBadGateway, wantStatus: backend.StatusBadGateway, wantMsg: "non-JSON response (HTTP 502, text/html)"},
+		{name: "login page with 200", status: http.StatusOK, page: ssoLoginPage, wantStatus: backend.StatusBadGateway, wantMsg: "non-JSON response (HTTP 200, text/html)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newHTMLServer(t, tt.status, tt.page)
+			ds := &Datasource{BaseURL: server.URL, maxNetworkRetries: intPtr(0)}
+
+			resp := runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId": "A", "measures": ["orders.count"]}`)
+			if resp.Error == nil {
+				t.Fatal("expected an error")
+			}
+			if resp.Status != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, resp.Status)
+			}
+			if msg := resp.Error.Error(); !strings.Contains(msg, tt.wantMsg) || strings.Contains(msg, "invalid character") {
+				t.Errorf("unexpected error message %q", msg)
+			}
+		})
+	}
+}
+
+func TestHandleTagValuesHTMLErrorPage(t *testing.T) {
+	server := newHTMLServer(t, http.StatusBadGateway, nginxBadGateway)
+	ds := &Datasource{BaseURL: server.URL, maxNetworkRetries: intPtr(0)}
+
+	resp := callHandler(t, ds.handleTagValues, &backend.CallResourceRequest{
+		Path:          "tag-values",
+		Method:        "GET",
+		URL:           "/tag-values?key=orders.status",
+		PluginContext: newTestPluginContext(server.URL),
+	})
+	if resp.Status != http.StatusBadGateway {
+		t.Errorf("expected status 502, got %d", resp.Status)
+	}
+	if !isJSONBody(resp.Body) || !strings.Contains(string(resp.Body), "non-JSON response") {
+		t.Errorf("expected a JSON error instead of the HTML page, got %s", resp.Body)
+	}
+}
+
+func TestFetchCubeMetadataHTMLResponse(t *testing.T) {
+	server := newHTMLServer(t, http.StatusOK, ssoLoginPage)
+	ds := &Datasource{BaseURL: server.URL}
+
+	_, err := ds.fetchCubeMetadata(context.Background(), newTestPluginContext(server.URL))
+	var nonJSONErr *nonJSONResponseError
+	if !errors.As(err, &nonJSONErr) {
+		t.Fatalf("expected nonJSONResponseError, got %v", err)
+	}
+	if nonJSONErr.StatusCode != http.StatusOK || nonJSONErr.ContentType != "text/html" {
+		t.Errorf("unexpected error %+v", nonJSONErr)
+	}
+}
+
+func TestCheckHealthHTMLResponse(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusBadGateway} {
+		server := newHTMLServer(t, status, nginxBadGateway)
+		ds := &Datasource{}
+
+		res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+			PluginContext: newTestPluginContext(server.URL),
+		})
+		if err != nil {
+			t.Fatalf("CheckHealth returned unexpected error: %v", err)
+		}
+		if res.Status != backend.HealthStatusError || !strings.Contains(res.Message, "non-JSON response") {
+			t.Errorf("status %d: expected a non-JSON error, got %v %q", status, res.Status, res.Message)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// timeSeriesFormat is the query format time series panels send. Only these
+// queries get an automatic time dimension; a table or stat panel querying
+// the same members wants one row per group, not per time bucket.
+const timeSeriesFormat = "time_series"
+
+// defaultAutoMaxDataPoints is used to derive a granularity when the request
+// carries neither an interval nor maxDataPoints.
+const defaultAutoMaxDataPoints = 1000
+
+// cubeGranularities are Cube's time dimension granularities, finest first,
+// with their (approximate, for month and coarser) bucket size.
+var cubeGranularities = []struct {
+	name string
+	size time.Duration
+}{
+	{"second", time.Second},
+	{"minute", time.Minute},
+	{"hour", time.Hour},
+	{"day", 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+	{"quarter", 91 * 24 * time.Hour},
+	{"year", 365 * 24 * time.Hour},
+}
+
+// autoGranularity returns the finest Cube granularity whose buckets are at
+// least the panel's interval, so the series has about as many points as the
+// panel can draw. Without an interval it is derived from the time range and
+// maxDataPoints.
+func autoGranularity(query backend.DataQuery) string {
+	interval := query.Interval
+	if interval <= 0 {
+		maxDataPoints := query.MaxDataPoints
+		if maxDataPoints <= 0 {
+			maxDataPoints = defaultAutoMaxDataPoints
+		}
+		interval = query.TimeRange.Duration() / time.Duration(maxDataPoints)
+	}
+	for _, g := range cubeGranularities {
+		if g.size >= interval {
+			return g.name
+		}
+	}
+	return cubeGranularities[len(cubeGranularities)-1].name
+}
+
+// queriedCube returns the cube or view the query's first member belongs to.
+func queriedCube(query CubeQuery) string {
+	for _, members := range [][]string{query.Measures, query.Dimensions} {
+		for _, member := range members {
+			if cube, _, ok := strings.Cut(member, "."); ok {
+				return cube
+			}
+		}
+	}
+	return ""
+}
+
+// primaryTimeDimension returns the first time dimension of the named cube or
+// view in model order, or "" when it has none.
+func primaryTimeDimension(meta *CubeMetaResponse, cubeName string) string {
+	for _, cube := range meta.Cubes {
+		if cube.Name != cubeName {
+			continue
+		}
+		for _, dim := range cube.Dimensions {
+			if dim.Type == "time" {
+				return dim.Name
+			}
+		}
+	}
+	return ""
+}
+
+// addAutoTimeDimension adds the queried cube's primary time dimension, over
+// the dashboard time range with autoGranularity, to a time series query that
+// has no time dimension, when autoTimeDimension is enabled. Without it a
+// graph of a measure is a single bar. Queries that already group by that
+// dimension are left alone, as are queries whose cube has no time dimension.
+// Metadata failures are logged and the query is sent unchanged.
+func (d *Datasource) addAutoTimeDimension(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, cubeQuery *CubeQuery) {
+	if cubeQuery.Format != timeSeriesFormat || len(cubeQuery.TimeDimensions) > 0 || len(cubeQuery.Measures) == 0 {
+		return
+	}
+	if pCtx.DataSourceInstanceSettings == nil {
+		return
+	}
+	config, err := d.loadSettings(*pCtx.DataSourceInstanceSettings)
+	if err != nil || !config.AutoTimeDimension {
+		return
+	}
+	cubeName := queriedCube(*cubeQuery)
+	if cubeName == "" {
+		return
+	}
+
+	meta, err := d.getCubeMetadata(ctx, pCtx)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Warn("Failed to fetch metadata for automatic time dimension", "error", err)
+		return
+	}
+	dimension := primaryTimeDimension(meta, cubeName)
+	if dimension == "" || slices.Contains(cubeQuery.Dimensions, dimension) {
+		return
+	}
+
+	cubeQuery.TimeDimensions = []interface{}{map[string]interface{}{
+		"dimension":   dimension,
+		"granularity": autoGranularity(query),
+		"dateRange":   cubeDateRange(query.TimeRange.From, query.TimeRange.To),
+	}}
+	backend.Logger.FromContext(ctx).Debug("Added automatic time dimension", "refId", query.RefID, "dimension", dimension)
+}
+
+// cubeDateRange returns a Cube dateRange covering from to to, in UTC.
+func cubeDateRange(from, to time.Time) []string {
+	const layout = "2006-01-02T15:04:05.000"
+	return []string{from.UTC().Format(layout), to.UTC().Format(layout)}
+}
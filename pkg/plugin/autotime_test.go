@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const autoTimeMeta = `{"cubes": [{"name": "orders", "type": "view", "dimensions": [
+	{"name": "orders.status", "type": "string"},
+	{"name": "orders.created_at", "type": "time"},
+	{"name": "orders.shipped_at", "type": "time"}
+]}, {"name": "customers", "type": "cube", "dimensions": [{"name": "customers.name", "type": "string"}]}]}`
+
+func TestAutoGranularity(t *testing.T) {
+	day := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(0, 0).Add(24 * time.Hour)}
+	tests := []struct {
+		name  string
+		query backend.DataQuery
+		want  string
+	}{
+		{name: "interval", query: backend.DataQuery{Interval: 30 * time.Second, TimeRange: day}, want: "minute"},
+		{name: "exact interval", query: backend.DataQuery{Interval: time.Hour, TimeRange: day}, want: "hour"},
+		{name: "max data points", query: backend.DataQuery{MaxDataPoints: 10, TimeRange: day}, want: "day"},
+		{name: "default max data points", query: backend.DataQuery{TimeRange: day}, want: "hour"},
+		{name: "coarser than a year", query: backend.DataQuery{Interval: 1000 * 24 * time.Hour, TimeRange: day}, want: "year"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := autoGranularity(tt.query); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestQueryDataAutoTimeDimension(t *testing.T) {
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(7 * 24 * time.Hour)
+
+	tests := []struct {
+		name     string
+		jsonData string
+		query    string
+		want     string // expected time dimension; "" for none
+	}{
+		{
+			name:     "time series query without time dimension",
+			jsonData: `{"deploymentType": "self-hosted-dev", "autoTimeDimension": true}`,
+			query:    `{"refId": "A", "measures": ["orders.count"], "format": "time_series"}`,
+			want:     "orders.created_at",
+		},
+		{
+			name:     "setting disabled",
+			jsonData: `{"deploymentType": "self-hosted-dev"}`,
+			query:    `{"refId": "A", "measures": ["orders.count"], "format": "time_series"}`,
+		},
+		{
+			name:     "table query",
+			jsonData: `{"deploymentType": "self-hosted-dev", "autoTimeDimension": true}`,
+			query:    `{"refId": "A", "measures": ["orders.count"], "format": "table"}`,
+		},
+		{
+			name:     "query with its own time dimension",
+			jsonData: `{"deploymentType": "self-hosted-dev", "autoTimeDimension": true}`,
+			query:    `{"refId": "A", "measures": ["orders.count"], "format": "time_series", "timeDimensions": [{"dimension": "orders.shipped_at", "granularity": "month"}]}`,
+			want:     "orders.shipped_at",
+		},
+		{
+			name:     "cube without time dimension",
+			jsonData: `{"deploymentType": "self-hosted-dev", "autoTimeDimension": true}`,
+			query:    `{"refId": "A", "measures": ["customers.count"], "format": "time_series"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lastQuery string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+					_, _ = w.Write([]byte(autoTimeMeta))
+					return
+				}
+				lastQuery = r.URL.Query().Get("query")
+				_, _ = w.Write([]byte(`{"data": [], "annotation": {}}`))
+			}))
+			defer server.Close()
+
+			ds := &Datasource{BaseURL: server.URL}
+			pCtx := newTestPluginContext(server.URL)
+			pCtx.DataSourceInstanceSettings.JSONData = []byte(tt.jsonData)
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: pCtx,
+				Queries: []backend.DataQuery{{
+					RefID:     "A",
+					JSON:      []byte(tt.query),
+					TimeRange: backend.TimeRange{From: from, To: to},
+					Interval:  time.Hour,
+				}},
+			})
+			if err != nil {
+				t.Fatalf("QueryData failed: %v", err)
+			}
+			if resp.Responses["A"].Error != nil {
+				t.Fatalf("unexpected error: %v", resp.Responses["A"].Error)
+			}
+
+			var sent struct {
+				TimeDimensions []struct {
+					Dimension   string   `json:"dimension"`
+					Granularity string   `json:"granularity"`
+					DateRange   []string `json:"dateRange"`
+				} `json:"timeDimensions"`
+			}
+			if err := json.Unmarshal([]byte(lastQuery), &sent); err != nil {
+				t.Fatalf("invalid Cube query %s: %v", lastQuery, err)
+			}
+			if tt.want == "" {
+				if len(sent.TimeDimensions) != 0 {
+					t.Fatalf("expected no time dimension, got %s", lastQuery)
+				}
+				return
+			}
+			if len(sent.TimeDimensions) != 1 || sent.TimeDimensions[0].Dimension != tt.want {
+				t.Fatalf("expected time dimension %s, got %s", tt.want, lastQuery)
+			}
+			if tt.want == "orders.created_at" {
+				td := sent.TimeDimensions[0]
+				if td.Granularity != "hour" {
+					t.Errorf("expected hour granularity, got %q", td.Granularity)
+				}
+				if len(td.DateRange) != 2 || td.DateRange[0] != "2026-10-01T00:00:00.000" || td.DateRange[1] != "2026-10-08T00:00:00.000" {
+					t.Errorf("expected the dashboard range, got %v", td.DateRange)
+				}
+			}
+		})
+	}
+}
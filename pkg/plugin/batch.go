@@ -0,0 +1,228 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// queryBatch executes several panel queries with a single Cube multi-query
+// request (an array of queries sent with queryType=multi) and splits the
+// results back per refId. This saves a round trip and an auth header per
+// query.
+//
+// SDK alignment: @cubejs-client/core's load() always sends queryType=multi and
+// reads results[] from the response; we do the same for the batched path.
+//
+// Cube may refuse a particular combination of queries in one request (it
+// validates the array as a whole). When Cube rejects the batch with a 400, or
+// the response cannot be split back per query, the queries are run one by one
+// instead so batching never makes a query fail that would succeed on its own.
+// Transport failures, timeouts and cancellations apply to every query in the
+// batch and are not retried individually.
+func (d *Datasource) queryBatch(ctx context.Context, pCtx backend.PluginContext, queries []backend.DataQuery) map[string]backend.DataResponse {
+	responses := make(map[string]backend.DataResponse, len(queries))
+
+	prepared := d.prepareConcurrently(ctx, pCtx, queries, responses)
+	prepared = d.answerFromResultCache(ctx, pCtx, prepared, responses)
+
+	// Blended queries are already sent as a query array of their own.
+	var blended []*preparedQuery
+	prepared, blended = splitBlended(prepared)
+	maps.Copy(responses, d.executeConcurrently(ctx, pCtx, blended))
+
+	// The SQL API and GraphQL run one query per request, so nothing is
+	// batched.
+	if len(prepared) < 2 || d.singleQueryTransport(pCtx) {
+		maps.Copy(responses, d.executeConcurrently(ctx, pCtx, prepared))
+		return responses
+	}
+
+	results, err := d.executeMultiQuery(ctx, pCtx, prepared)
+	if err != nil {
+		if !shouldRetryUnbatched(err) {
+			backend.Logger.FromContext(ctx).Error("Failed to fetch batched queries from Cube API", "error", err, "queries", len(prepared))
+			errResponse := loadErrorResponse(err)
+			for _, p := range prepared {
+				responses[p.refID] = errResponse
+			}
+			return responses
+		}
+		backend.Logger.FromContext(ctx).Warn("Cube rejected batched queries, running them individually", "error", err, "queries", len(prepared))
+		maps.Copy(responses, d.executeConcurrently(ctx, pCtx, prepared))
+		return responses
+	}
+
+	for i, p := range prepared {
+		responses[p.refID] = d.respond(ctx, pCtx, p, results[i])
+	}
+	return responses
+}
+
+// prepareConcurrently prepares queries on a pool bounded like
+// executeConcurrently's, since preparing may cost Cube round trips of its own
+// (the dimension cardinality check). Queries answered or refused while
+// preparing get their response in responses; the others are returned in
+// request order.
+func (d *Datasource) prepareConcurrently(ctx context.Context, pCtx backend.PluginContext, queries []backend.DataQuery, responses map[string]backend.DataResponse) []*preparedQuery {
+	prepared := make([]*preparedQuery, len(queries))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxQueryConcurrency)
+
+	for i, q := range queries {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			mu.Lock()
+			responses[q.RefID] = backend.ErrDataResponse(statusForContextErr(ctx.Err()), "query cancelled before it was sent to Cube")
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, q backend.DataQuery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var res backend.DataResponse
+			if variable, ok := parseMetadataVariableQuery(q.JSON); ok {
+				res = d.variableQuery(ctx, pCtx, variable)
+			} else if isModelQuery(q) {
+				res = d.modelQuery(ctx, pCtx)
+			} else if prepared[i], res = d.prepareQuery(ctx, pCtx, q); prepared[i] != nil {
+				return
+			}
+			mu.Lock()
+			responses[q.RefID] = res
+			mu.Unlock()
+		}(i, q)
+	}
+
+	wg.Wait()
+	return slices.DeleteFunc(prepared, func(p *preparedQuery) bool { return p == nil })
+}
+
+// errBatchResultMismatch reports a multi-query response that cannot be mapped
+// back onto the queries that were sent.
+var errBatchResultMismatch = errors.New("batched response does not match the queries sent")
+
+// executeMultiQuery sends the prepared queries as one multi-query /v1/load
+// request and returns the results in query order.
+func (d *Datasource) executeMultiQuery(ctx context.Context, pCtx backend.PluginContext, prepared []*preparedQuery) ([]CubeAPIResponse, error) {
+	apiQueries := make([]map[string]interface{}, len(prepared))
+	queries := make([]CubeQuery, len(prepared))
+	for i, p := range prepared {
+		apiQueries[i] = p.apiQuery
+		queries[i] = p.query
+	}
+	queriesJSON, err := json.Marshal(apiQueries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Cube queries: %w", err)
+	}
+
+	apiReq, err := d.buildAPIURL(pCtx, "load")
+	if err != nil {
+		return nil, &loadRequestError{status: backend.StatusBadRequest, msg: err.Error()}
+	}
+	apiReq.Config = continueWaitConfig(apiReq.Config, queries...)
+
+	backend.Logger.FromContext(ctx).Debug("Making batched API request", "url", apiReq.URL.String(), "queries", len(prepared))
+
+	start := time.Now()
+	loadCtx, polls := countContinueWaits(ctx)
+	body, err := d.doCubeMultiLoadRequest(loadCtx, apiReq.URL.String(), queriesJSON, apiReq.Config)
+	if err != nil {
+		observeLoadRequest(time.Since(start), *polls, err)
+		logSlowQuery(ctx, apiReq.Config, queriesJSON, time.Since(start), *polls, 0, err)
+		return nil, err
+	}
+
+	// Anything that cannot be split per query, including an error Cube
+	// reported for the batch as a whole, is retried as individual queries.
+	envelope, err := decodeCubeEnvelope(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBatchResultMismatch, err)
+	}
+	results, err := envelope.results()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBatchResultMismatch, err)
+	}
+	rows := 0
+	for _, result := range results {
+		rows += len(result.Data)
+	}
+	observeLoadRequest(time.Since(start), *polls, nil)
+	logSlowQuery(ctx, apiReq.Config, queriesJSON, time.Since(start), *polls, rows, nil)
+	if len(results) != len(prepared) {
+		return nil, fmt.Errorf("%w: expected %d results, got %d", errBatchResultMismatch, len(prepared), len(results))
+	}
+	for i, p := range prepared {
+		if key, ok := preparedCacheKey(p, apiReq.Config); ok {
+			d.cacheResult(key, results[i], apiReq.Config)
+		}
+	}
+	return results, nil
+}
+
+// shouldRetryUnbatched reports whether a failed batch should be re-run as
+// individual queries: Cube rejected the batch as a client error, or answered
+// with something we could not split per query.
+func shouldRetryUnbatched(err error) bool {
+	if errors.Is(err, errBatchResultMismatch) {
+		return true
+	}
+	var cubeErr *CubeAPIError
+	if errors.As(err, &cubeErr) {
+		return cubeErr.StatusCode == http.StatusBadRequest
+	}
+	return false
+}
+
+// maxQueryConcurrency bounds how many queries of a single QueryData call are
+// sent to Cube at the same time.
+const maxQueryConcurrency = 5
+
+// executeConcurrently runs the prepared queries individually on a bounded pool
+// of goroutines, so a panel with several refIds does not serialize its Cube
+// round trips. Queries still waiting for a slot when ctx is done are not sent
+// and fail with the context's status.
+func (d *Datasource) executeConcurrently(ctx context.Context, pCtx backend.PluginContext, prepared []*preparedQuery) map[string]backend.DataResponse {
+	responses := make(map[string]backend.DataResponse, len(prepared))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxQueryConcurrency)
+
+	for _, p := range prepared {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			mu.Lock()
+			responses[p.refID] = backend.ErrDataResponse(statusForContextErr(ctx.Err()), "query cancelled before it was sent to Cube")
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(p *preparedQuery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			res := d.executeQuery(ctx, pCtx, p)
+			mu.Lock()
+			responses[p.refID] = res
+			mu.Unlock()
+		}(p)
+	}
+
+	wg.Wait()
+	return responses
+}
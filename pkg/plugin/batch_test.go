@@ -0,0 +1,222 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestQueryDataBatchesQueriesIntoOneMultiRequest(t *testing.T) {
+	var requestCount atomic.Int32
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		if got := r.URL.Query().Get("queryType"); got != "multi" {
+			t.Errorf("expected queryType=multi, got %q", got)
+		}
+		var queries []map[string]interface{}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("query")), &queries); err != nil {
+			t.Errorf("expected an array of queries: %v", err)
+		}
+		if len(queries) != 2 {
+			t.Errorf("expected 2 batched queries, got %d", len(queries))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CubeMultiAPIResponse{
+			QueryType: "multi",
+			Results: []CubeAPIResponse{
+				{
+					Data:       []map[string]interface{}{{"orders.count": "1"}},
+					Annotation: CubeAnnotation{Measures: map[string]CubeFieldInfo{"orders.count": {Type: "number"}}},
+				},
+				{
+					Data:       []map[string]interface{}{{"orders.total": "2"}},
+					Annotation: CubeAnnotation{Measures: map[string]CubeFieldInfo{"orders.total": {Type: "number"}}},
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: []byte(`{"refId":"A","measures":["orders.count"]}`)},
+			{RefID: "B", JSON: []byte(`{"refId":"B","measures":["orders.total"]}`)},
+			{RefID: "C", JSON: []byte(`{not json`)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("QueryData failed: %v", err)
+	}
+
+	if n := requestCount.Load(); n != 1 {
+		t.Fatalf("expected 1 batched request, got %d", n)
+	}
+	for refID, measure := range map[string]string{"A": "orders.count", "B": "orders.total"} {
+		res := resp.Responses[refID]
+		if res.Error != nil {
+			t.Fatalf("%s: unexpected error: %v", refID, res.Error)
+		}
+		if got := res.Frames[0].Fields[0].Name; got != measure {
+			t.Errorf("%s: expected field %s, got %s", refID, measure, got)
+		}
+	}
+	if res := resp.Responses["C"]; res.Error == nil || res.Status != backend.StatusBadRequest {
+		t.Errorf("expected invalid query C to fail with 400, got status %d err %v", res.Status, res.Error)
+	}
+}
+
+func TestQueryDataBatchRejectedFallsBackToIndividualQueries(t *testing.T) {
+	var multiRequests, singleRequests atomic.Int32
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("queryType") == "multi" {
+			multiRequests.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"Can't determine query type"}`))
+			return
+		}
+		singleRequests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CubeAPIResponse{
+			Data:       []map[string]interface{}{{"orders.count": "1"}},
+			Annotation: CubeAnnotation{Measures: map[string]CubeFieldInfo{"orders.count": {Type: "number"}}},
+		})
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: []byte(`{"refId":"A","measures":["orders.count"]}`)},
+			{RefID: "B", JSON: []byte(`{"refId":"B","measures":["orders.total"]}`)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("QueryData failed: %v", err)
+	}
+
+	if multiRequests.Load() != 1 || singleRequests.Load() != 2 {
+		t.Fatalf("expected 1 rejected batch + 2 single requests, got %d + %d", multiRequests.Load(), singleRequests.Load())
+	}
+	for _, refID := range []string{"A", "B"} {
+		if res := resp.Responses[refID]; res.Error != nil {
+			t.Errorf("%s: unexpected error after fallback: %v", refID, res.Error)
+		}
+	}
+}
+
+func TestQueryDataBatchServerErrorIsNotRetriedIndividually(t *testing.T) {
+	var requestCount atomic.Int32
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"boom"}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: []byte(`{"refId":"A","measures":["orders.count"]}`)},
+			{RefID: "B", JSON: []byte(`{"refId":"B","measures":["orders.count"]}`)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("QueryData failed: %v", err)
+	}
+
+	if n := requestCount.Load(); n != 1 {
+		t.Fatalf("expected only the batched request, got %d requests", n)
+	}
+	for _, refID := range []string{"A", "B"} {
+		if res := resp.Responses[refID]; res.Status != backend.StatusInternal {
+			t.Errorf("%s: expected upstream 500 to be preserved, got %d", refID, res.Status)
+		}
+	}
+}
+
+func TestExecuteConcurrentlyRunsQueriesInParallel(t *testing.T) {
+	const queries = 3
+	arrived := make(chan struct{}, queries)
+	release := make(chan struct{})
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CubeAPIResponse{
+			Data:       []map[string]interface{}{{"orders.count": "1"}},
+			Annotation: CubeAnnotation{Measures: map[string]CubeFieldInfo{"orders.count": {Type: "number"}}},
+		})
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	// Distinct queries, so they are not collapsed into one request.
+	measures := []string{"orders.count", "orders.total", "orders.avg"}
+	prepared := make([]*preparedQuery, queries)
+	for i := range prepared {
+		prepared[i] = &preparedQuery{
+			refID:    string(rune('A' + i)),
+			query:    CubeQuery{Measures: []string{measures[i]}},
+			apiQuery: map[string]interface{}{"measures": []string{measures[i]}},
+		}
+	}
+
+	done := make(chan map[string]backend.DataResponse)
+	go func() {
+		done <- ds.executeConcurrently(context.Background(), newTestPluginContext(server.URL), prepared)
+	}()
+
+	// All queries must reach the server before any of them is answered.
+	for i := 0; i < queries; i++ {
+		select {
+		case <-arrived:
+		case <-time.After(5 * time.Second):
+			close(release)
+			t.Fatalf("only %d of %d queries were in flight concurrently", i, queries)
+		}
+	}
+	close(release)
+
+	responses := <-done
+	for _, p := range prepared {
+		if res := responses[p.refID]; res.Error != nil {
+			t.Errorf("%s: unexpected error: %v", p.refID, res.Error)
+		}
+	}
+}
+
+func TestExecuteConcurrentlyCancelledContext(t *testing.T) {
+	var requestCount atomic.Int32
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ds := &Datasource{BaseURL: server.URL}
+	prepared := []*preparedQuery{{refID: "A", apiQuery: map[string]interface{}{}}, {refID: "B", apiQuery: map[string]interface{}{}}}
+
+	responses := ds.executeConcurrently(ctx, newTestPluginContext(server.URL), prepared)
+
+	for _, p := range prepared {
+		if res := responses[p.refID]; res.Error == nil {
+			t.Errorf("%s: expected an error for a cancelled context", p.refID)
+		}
+	}
+	if n := requestCount.Load(); n != 0 {
+		t.Errorf("expected no requests to reach Cube, got %d", n)
+	}
+}
@@ -0,0 +1,248 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// blendLabel is the label naming the query a series of a blended frame comes
+// from.
+const blendLabel = "query"
+
+// BlendedQuery is a query blended with a panel query (see CubeQuery.Blend).
+type BlendedQuery struct {
+	// Alias names the query's series; defaults to the refId followed by the
+	// query's position, e.g. "A2".
+	Alias    string        `json:"alias,omitempty"`
+	Measures []string      `json:"measures"`
+	Filters  []interface{} `json:"filters,omitempty"`
+}
+
+// alias returns the label of the i-th blended query of refID.
+func (q BlendedQuery) alias(refID string, i int) string {
+	if q.Alias != "" {
+		return q.Alias
+	}
+	return fmt.Sprintf("%s%d", refID, i+2)
+}
+
+// validateBlend checks that a query can be blended: Cube blends queries on a
+// single time dimension with a granularity, and the merged frame has one row
+// per time bucket, so the query cannot group by other dimensions.
+func validateBlend(query CubeQuery, apiQuery map[string]interface{}) error {
+	if len(query.Blend) == 0 {
+		return nil
+	}
+	if query.RawQuery != "" || query.QueryType != "" {
+		return errors.New("blended queries cannot be combined with raw queries or alerting query types")
+	}
+	tds := timeDimensionList(apiQuery)
+	if len(tds) != 1 {
+		return errors.New("blended queries need exactly one time dimension")
+	}
+	dimension, _ := tds[0]["dimension"].(string)
+	granularity, _ := tds[0]["granularity"].(string)
+	if dimension == "" || granularity == "" {
+		return errors.New("blended queries need a time dimension with a granularity")
+	}
+	for _, member := range query.Dimensions {
+		if member != dimension+"."+granularity {
+			return fmt.Errorf("blended queries cannot group by %s: only the time dimension is shared", member)
+		}
+	}
+	for i, blended := range query.Blend {
+		if len(blended.Measures) == 0 {
+			return fmt.Errorf("blended query %s has no measures", blended.alias(query.RefID, i))
+		}
+	}
+	return nil
+}
+
+// splitBlended separates the queries with blended queries from the others.
+func splitBlended(prepared []*preparedQuery) (plain, blended []*preparedQuery) {
+	for _, p := range prepared {
+		if len(p.blended) > 0 {
+			blended = append(blended, p)
+		} else {
+			plain = append(plain, p)
+		}
+	}
+	return plain, blended
+}
+
+// prepareBlend prepares the queries blended with a panel query. Each is the
+// panel query with its own measures and filters, prepared like any other
+// query, so default filters, rewrite rules and allowed views apply to it.
+func (d *Datasource) prepareBlend(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, cubeQuery CubeQuery) ([]*preparedQuery, backend.DataResponse) {
+	var base map[string]interface{}
+	if err := json.Unmarshal(query.JSON, &base); err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Invalid query JSON: %v", err))
+	}
+	delete(base, "blend")
+	delete(base, "filters")
+
+	blended := make([]*preparedQuery, 0, len(cubeQuery.Blend))
+	for _, b := range cubeQuery.Blend {
+		base["measures"] = b.Measures
+		base["filters"] = b.Filters
+		blendedJSON, err := json.Marshal(base)
+		if err != nil {
+			return nil, backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Failed to marshal blended query: %v", err))
+		}
+		blendedQuery := query
+		blendedQuery.JSON = blendedJSON
+		p, errResponse := d.prepareQuery(ctx, pCtx, blendedQuery)
+		if p == nil {
+			return nil, errResponse
+		}
+		blended = append(blended, p)
+	}
+	return blended, backend.DataResponse{}
+}
+
+// executeBlend sends a panel query and the queries blended with it as one
+// /v1/load request, which Cube runs as a blending query, and merges their
+// results on time into one frame.
+func (d *Datasource) executeBlend(ctx context.Context, pCtx backend.PluginContext, prepared *preparedQuery) backend.DataResponse {
+	all := append([]*preparedQuery{prepared}, prepared.blended...)
+	apiQueries := make([]map[string]interface{}, len(all))
+	queries := make([]CubeQuery, len(all))
+	for i, p := range all {
+		apiQueries[i] = p.apiQuery
+		queries[i] = p.query
+	}
+	queriesJSON, err := json.Marshal(apiQueries)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Failed to marshal Cube query: %v", err))
+	}
+
+	apiReq, err := d.buildAPIURL(pCtx, "load")
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	apiReq.Config = continueWaitConfig(apiReq.Config, queries...)
+	if err := validateQueryTransport(apiReq.Config); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if usesSQLAPI(apiReq.Config) || usesGraphQL(apiReq.Config) {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("blended queries are not supported over the %s transport", apiReq.Config.QueryTransport))
+	}
+
+	start := time.Now()
+	loadCtx, polls := countContinueWaits(ctx)
+	body, err := d.doCubeMultiLoadRequest(loadCtx, apiReq.URL.String(), queriesJSON, apiReq.Config)
+	if err != nil {
+		observeLoadRequest(time.Since(start), *polls, err)
+		logSlowQuery(ctx, apiReq.Config, queriesJSON, time.Since(start), *polls, 0, err)
+		return loadErrorResponse(err)
+	}
+	envelope, err := decodeCubeEnvelope(body)
+	if err != nil {
+		return loadErrorResponse(err)
+	}
+	results, err := envelope.results()
+	if err != nil {
+		return loadErrorResponse(err)
+	}
+	rows := 0
+	for _, result := range results {
+		rows += len(result.Data)
+	}
+	observeLoadRequest(time.Since(start), *polls, nil)
+	logSlowQuery(ctx, apiReq.Config, queriesJSON, time.Since(start), *polls, rows, nil)
+	if len(results) != len(all) {
+		return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("blending query returned %d results for %d queries", len(results), len(all)))
+	}
+
+	frames := make([]*data.Frame, len(all))
+	labels := make([]string, len(all))
+	for i, p := range all {
+		response := d.buildDataResponse(p, results[i])
+		if response.Error != nil {
+			return response
+		}
+		frames[i] = response.Frames[0]
+		labels[i] = prepared.refID
+		if i > 0 {
+			labels[i] = prepared.query.Blend[i-1].alias(prepared.refID, i-1)
+		}
+	}
+	merged, err := mergeBlendedFrames(frames, labels, all)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusInternal, err.Error())
+	}
+
+	response := backend.DataResponse{Frames: data.Frames{merged}}
+	for _, p := range all {
+		response = d.addDeprecationNotices(ctx, pCtx, p, response)
+	}
+	return response
+}
+
+// mergeBlendedFrames aligns the frames of blended queries on their time field
+// into one wide frame: the time buckets of every query in order, then the
+// measure fields of each query labeled with its name. Buckets a query has no
+// row for are null.
+func mergeBlendedFrames(frames []*data.Frame, labels []string, prepared []*preparedQuery) (*data.Frame, error) {
+	timeFields := make([]int, len(frames))
+	index := make(map[int64]int)
+	var times []time.Time
+	for i, frame := range frames {
+		timeFields[i], _, _, _ = fillTimeDimension(frame, prepared[i].apiQuery, prepared[i].timeRange)
+		if timeFields[i] < 0 {
+			return nil, fmt.Errorf("result of blended query %s has no time field", labels[i])
+		}
+		for row := 0; row < frame.Rows(); row++ {
+			if v, ok := frame.Fields[timeFields[i]].ConcreteAt(row); ok {
+				t := v.(time.Time)
+				if _, seen := index[t.UnixNano()]; !seen {
+					index[t.UnixNano()] = 0
+					times = append(times, t)
+				}
+			}
+		}
+	}
+	sort.Slice(times, func(a, b int) bool { return times[a].Before(times[b]) })
+	for i, t := range times {
+		index[t.UnixNano()] = i
+	}
+
+	timeValues := make([]time.Time, len(times))
+	copy(timeValues, times)
+	merged := data.NewFrame("response", data.NewField(frames[0].Fields[timeFields[0]].Name, nil, timeValues))
+	for i, frame := range frames {
+		for f, field := range frame.Fields {
+			if f == timeFields[i] {
+				continue
+			}
+			aligned := data.NewFieldFromFieldType(field.Type().NullableType(), len(times))
+			aligned.Name = field.Name
+			aligned.Labels = data.Labels{blendLabel: labels[i]}
+			if field.Config != nil {
+				config := *field.Config
+				aligned.Config = &config
+			}
+			for row := 0; row < frame.Rows(); row++ {
+				t, ok := frame.Fields[timeFields[i]].ConcreteAt(row)
+				if !ok {
+					continue
+				}
+				if v, ok := field.ConcreteAt(row); ok {
+					aligned.SetConcrete(index[t.(time.Time).UnixNano()], v)
+				}
+			}
+			merged.Fields = append(merged.Fields, aligned)
+		}
+		if frame.Meta != nil {
+			merged.AppendNotices(frame.Meta.Notices...)
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestQueryDataBlend(t *testing.T) {
+	var sent []map[string]interface{}
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("queryType"); got != queryTypeMulti {
+			t.Errorf("expected queryType=multi, got %q", got)
+		}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("query")), &sent); err != nil {
+			t.Errorf("expected a query array: %v", err)
+		}
+		annotation := `"annotation": {"measures": {"orders.count": {"type": "number"}}, "timeDimensions": {"orders.created_at.day": {"type": "time"}}}`
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"queryType": "blendingQuery", "results": [
+			{"data": [{"orders.created_at.day": "2024-01-01T00:00:00.000", "orders.count": "1"}, {"orders.created_at.day": "2024-01-02T00:00:00.000", "orders.count": "2"}], ` + annotation + `},
+			{"data": [{"orders.created_at.day": "2024-01-02T00:00:00.000", "orders.count": "5"}, {"orders.created_at.day": "2024-01-03T00:00:00.000", "orders.count": "6"}], ` + annotation + `}
+		]}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	query := `{"refId": "A", "measures": ["orders.count"], "dimensions": ["orders.created_at.day"],
+		"timeDimensions": [{"dimension": "orders.created_at", "granularity": "day"}],
+		"filters": [{"member": "orders.status", "operator": "equals", "values": ["completed"]}],
+		"blend": [{"alias": "Cancelled", "measures": ["orders.count"], "filters": [{"member": "orders.status", "operator": "equals", "values": ["cancelled"]}]}]}`
+	resp := runSingleQuery(t, ds, newTestPluginContext(server.URL), query)
+	if resp.Error != nil {
+		t.Fatalf("unexpected error: %v", resp.Error)
+	}
+
+	if len(sent) != 2 {
+		t.Fatalf("expected 2 blended queries, got %v", sent)
+	}
+	if filters, _ := json.Marshal(sent[1]["filters"]); !strings.Contains(string(filters), "cancelled") || strings.Contains(string(filters), "completed") {
+		t.Errorf("expected the blended query to keep its own filters, got %s", filters)
+	}
+
+	frame := resp.Frames[0]
+	if len(frame.Fields) != 3 || frame.Rows() != 3 {
+		t.Fatalf("expected a time field and 2 series over 3 buckets, got %d fields and %d rows", len(frame.Fields), frame.Rows())
+	}
+	for i, day := range []int{1, 2, 3} {
+		if got := frame.Fields[0].At(i).(time.Time); got.Day() != day {
+			t.Errorf("row %d: expected day %d, got %s", i, day, got)
+		}
+	}
+	if frame.Fields[1].Labels[blendLabel] != "A" || frame.Fields[2].Labels[blendLabel] != "Cancelled" {
+		t.Errorf("unexpected series labels %v, %v", frame.Fields[1].Labels, frame.Fields[2].Labels)
+	}
+	assertFloats(t, "A", nullableFloats(t, frame.Fields[1]), []*float64{floatPtr(1), floatPtr(2), nil})
+	assertFloats(t, "Cancelled", nullableFloats(t, frame.Fields[2]), []*float64{nil, floatPtr(5), floatPtr(6)})
+}
+
+func TestQueryDataBlendValidation(t *testing.T) {
+	ds := &Datasource{}
+	pCtx := newTestPluginContext("http://localhost:4000")
+	for name, query := range map[string]string{
+		"no time dimension":  `{"refId": "A", "measures": ["orders.count"], "blend": [{"measures": ["orders.count"]}]}`,
+		"no granularity":     `{"refId": "A", "measures": ["orders.count"], "timeDimensions": [{"dimension": "orders.created_at"}], "blend": [{"measures": ["orders.count"]}]}`,
+		"other dimensions":   `{"refId": "A", "measures": ["orders.count"], "dimensions": ["orders.status"], "timeDimensions": [{"dimension": "orders.created_at", "granularity": "day"}], "blend": [{"measures": ["orders.count"]}]}`,
+		"no blended measure": `{"refId": "A", "measures": ["orders.count"], "timeDimensions": [{"dimension": "orders.created_at", "granularity": "day"}], "blend": [{}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp := ds.query(context.Background(), pCtx, backend.DataQuery{RefID: "A", JSON: []byte(query)})
+			if resp.Status != backend.StatusBadRequest {
+				t.Errorf("expected 400, got %v: %v", resp.Status, resp.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// capabilitiesVersion is bumped when the shape of the capabilities response
+// changes incompatibly. Adding a feature or limit does not change it.
+const capabilitiesVersion = 1
+
+// Capabilities tells the frontend which backend features this plugin version
+// supports and which are enabled for the datasource, so the query editor can
+// enable UI by feature rather than by plugin version. Features the frontend
+// does not know are ignored; features missing from the response are
+// unsupported.
+type Capabilities struct {
+	Version  int                `json:"version"`
+	Features map[string]bool    `json:"features"`
+	Limits   CapabilitiesLimits `json:"limits"`
+}
+
+// CapabilitiesLimits reports the configured limits. Durations are in
+// seconds; 0 means no limit (or the feature is disabled).
+type CapabilitiesLimits struct {
+	QueryTimeout            int `json:"queryTimeout"`
+	MetaCacheTTL            int `json:"metaCacheTTL"`
+	TagValuesCacheTTL       int `json:"tagValuesCacheTTL"`
+	DbSchemaCacheTTL        int `json:"dbSchemaCacheTTL"`
+	ResultCacheTTL          int `json:"resultCacheTTL"`
+	ResultCacheMaxEntries   int `json:"resultCacheMaxEntries"`
+	DefaultLimit            int `json:"defaultLimit"`
+	MaxLimit                int `json:"maxLimit"`
+	MaxConcurrentQueries    int `json:"maxConcurrentQueries"`
+	ResourceRateLimit       int `json:"resourceRateLimit"`
+	MaxDimensionCardinality int `json:"maxDimensionCardinality"`
+}
+
+// capabilitiesFor returns the capabilities of a datasource with the given
+// settings, for a user who is an admin or not.
+func capabilitiesFor(config *models.PluginSettings, admin bool) Capabilities {
+	resultCacheTTL := int(config.ResultCacheTTLDuration().Seconds())
+	limits := CapabilitiesLimits{
+		QueryTimeout:      int(config.QueryTimeoutDuration().Seconds()),
+		MetaCacheTTL:      int(metaCacheTTL(config).Seconds()),
+		TagValuesCacheTTL: int(tagValuesCacheTTL(config).Seconds()),
+		DbSchemaCacheTTL:  int(dbSchemaCacheTTL(config).Seconds()),
+		ResultCacheTTL:    resultCacheTTL,
+	}
+	if resultCacheTTL > 0 {
+		limits.ResultCacheMaxEntries = resultCacheMaxEntries(config)
+	}
+	if config.DefaultLimit != nil && *config.DefaultLimit > 0 {
+		limits.DefaultLimit = *config.DefaultLimit
+	}
+	if config.MaxLimit != nil && *config.MaxLimit > 0 {
+		limits.MaxLimit = *config.MaxLimit
+	}
+	limits.MaxConcurrentQueries = config.QueryConcurrency()
+	limits.ResourceRateLimit = resourceRateLimit(config)
+	limits.MaxDimensionCardinality = config.DimensionCardinalityLimit()
+
+	return Capabilities{
+		Version: capabilitiesVersion,
+		Features: map[string]bool{
+			// Always available.
+			"batching":                true,
+			"tagKeys":                 true,
+			"tagValuesBulk":           true,
+			"streaming":               true,
+			"sqlCompilation":          true,
+			"dryRun":                  true,
+			"preAggregationPreview":   true,
+			"preAggregations":         true,
+			"metadataRefresh":         true,
+			"health":                  true,
+			"diagnostics":             true,
+			"modelFiles":              true,
+			"dbSchema":                true,
+			"deprecationWarnings":     true,
+			"normalize":               true,
+			"typeOverrides":           true,
+			"unitConversion":          true,
+			"instantTime":             true,
+			"alertingQueryTypes":      true,
+			"memberColors":            true,
+			"variableValues":          true,
+			"metadataVariableQueries": true,
+			"metadataSearch":          true,
+			"rawQuery":                true,
+			"fill":                    true,
+			"members":                 true,
+			"blending":                true,
+			"join":                    true,
+			"fiscalPeriods":           true,
+			"export":                  true,
+			"pagination":              true,
+			"explain":                 true,
+			"modelGraph":              true,
+			"ranges":                  true,
+			"cardinality":             true,
+			// Depend on the datasource settings or the user.
+			"generateSchema":    admin,
+			"saveModelFiles":    admin && modelFileWritesAllowed(config),
+			"webSockets":        config.UseWebSockets,
+			"liveQueries":       !usesSQLAPI(config) && !usesGraphQL(config),
+			"resultCache":       resultCacheTTL > 0,
+			"metaCache":         limits.MetaCacheTTL > 0,
+			"tagValuesCache":    limits.TagValuesCacheTTL > 0,
+			"dbSchemaCache":     limits.DbSchemaCacheTTL > 0,
+			"defaultFilters":    len(config.DefaultFilters) > 0,
+			"allowedViews":      len(config.AllowedViews) > 0,
+			"queryRewriteRules": len(config.QueryRewriteRules) > 0,
+			"autoTimeDimension": config.AutoTimeDimension,
+			"seriesColors":      len(config.SeriesColors) > 0,
+			"deepHealthCheck":   config.DeepHealthCheck,
+		},
+		Limits: limits,
+	}
+}
+
+// handleCapabilities returns the Capabilities of the datasource.
+func (d *Datasource) handleCapabilities(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if req.PluginContext.DataSourceInstanceSettings == nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("datasource settings are required")))
+	}
+	config, err := d.loadSettings(*req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+
+	body, err := json.Marshal(capabilitiesFor(config, isAdmin(req)))
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal capabilities response", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   body,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
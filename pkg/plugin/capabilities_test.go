@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHandleCapabilities(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     string
+		jsonData string
+		check    func(t *testing.T, c Capabilities)
+	}{
+		{
+			name:     "defaults",
+			role:     "Viewer",
+			jsonData: `{"deploymentType": "self-hosted-dev"}`,
+			check: func(t *testing.T, c Capabilities) {
+				if !c.Features["batching"] || !c.Features["metaCache"] {
+					t.Errorf("expected batching and the metadata cache, got %v", c.Features)
+				}
+				if c.Features["resultCache"] || c.Features["generateSchema"] || c.Features["autoTimeDimension"] {
+					t.Errorf("expected disabled features to be false, got %v", c.Features)
+				}
+				if c.Limits.MetaCacheTTL != 60 || c.Limits.ResultCacheTTL != 0 || c.Limits.ResultCacheMaxEntries != 0 {
+					t.Errorf("unexpected limits %+v", c.Limits)
+				}
+			},
+		},
+		{
+			name: "configured",
+			role: "Admin",
+			jsonData: `{"deploymentType": "self-hosted-dev", "queryTimeout": 30, "metaCacheTTL": 0,
+				"resultCacheTTL": 10, "useWebSockets": true, "autoTimeDimension": true,
+				"defaultFilters": [{"member": "orders.tenant", "operator": "equals", "values": ["acme"]}]}`,
+			check: func(t *testing.T, c Capabilities) {
+				for _, feature := range []string{"generateSchema", "resultCache", "webSockets", "autoTimeDimension", "defaultFilters"} {
+					if !c.Features[feature] {
+						t.Errorf("expected %s to be enabled, got %v", feature, c.Features)
+					}
+				}
+				if c.Features["metaCache"] {
+					t.Errorf("expected the metadata cache to be disabled")
+				}
+				want := CapabilitiesLimits{QueryTimeout: 30, TagValuesCacheTTL: 30, DbSchemaCacheTTL: 600, ResultCacheTTL: 10, ResultCacheMaxEntries: defaultResultCacheEntries, ResourceRateLimit: defaultResourceRateLimit}
+				if c.Limits != want {
+					t.Errorf("expected limits %+v, got %+v", want, c.Limits)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pCtx := newTestPluginContextWithUser("http://cube.example", tt.role)
+			pCtx.DataSourceInstanceSettings.JSONData = []byte(tt.jsonData)
+			ds := &Datasource{}
+
+			resp := callHandler(t, ds.CallResource, &backend.CallResourceRequest{
+				Path:          "capabilities",
+				Method:        "GET",
+				PluginContext: pCtx,
+			})
+			if resp.Status != 200 {
+				t.Fatalf("expected status 200, got %d: %s", resp.Status, resp.Body)
+			}
+			var c Capabilities
+			if err := json.Unmarshal(resp.Body, &c); err != nil {
+				t.Fatalf("invalid response %s: %v", resp.Body, err)
+			}
+			if c.Version != capabilitiesVersion {
+				t.Errorf("expected version %d, got %d", capabilitiesVersion, c.Version)
+			}
+			tt.check(t, c)
+		})
+	}
+}
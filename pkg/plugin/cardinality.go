@@ -0,0 +1,228 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// cardinalityWarningThreshold is the distinct count above which the
+// cardinality resource flags a dimension as high-cardinality when the
+// datasource sets no maxDimensionCardinality.
+const cardinalityWarningThreshold = 10000
+
+// cardinalityCacheTTL is how long distinct counts are reused. Cardinality
+// changes slowly, and counting is a warehouse query.
+const cardinalityCacheTTL = 10 * time.Minute
+
+// maxCardinalityCacheEntries bounds the cardinality cache.
+const maxCardinalityCacheEntries = 500
+
+// CardinalityResponse is the cardinality resource response.
+type CardinalityResponse struct {
+	Member string `json:"member"`
+	// Cardinality is the approximate number of distinct values of the
+	// member, within the datasource's default filters.
+	Cardinality int64 `json:"cardinality"`
+	// HighCardinality is set above maxDimensionCardinality, or above
+	// cardinalityWarningThreshold when the datasource sets no limit.
+	HighCardinality bool `json:"highCardinality"`
+	// MaxCardinality is the datasource's maxDimensionCardinality, when set:
+	// queries grouping by the member above it are refused.
+	MaxCardinality int    `json:"maxCardinality,omitempty"`
+	Warning        string `json:"warning,omitempty"`
+}
+
+// cardinalityCache holds recent distinct counts per count query and
+// credentials.
+type cardinalityCache struct {
+	mu      sync.Mutex
+	entries map[string]cardinalityCacheEntry
+}
+
+type cardinalityCacheEntry struct {
+	count   int64
+	expires time.Time
+}
+
+// get returns the cached count for key if it has not expired.
+func (c *cardinalityCache) get(key string, now time.Time) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expires) {
+		return 0, false
+	}
+	return entry.count, true
+}
+
+// put stores count for cardinalityCacheTTL. When the cache is full, expired
+// entries are dropped, and every entry if none had expired.
+func (c *cardinalityCache) put(key string, count int64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cardinalityCacheEntry)
+	}
+	if len(c.entries) >= maxCardinalityCacheEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCardinalityCacheEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = cardinalityCacheEntry{count: count, expires: now.Add(cardinalityCacheTTL)}
+}
+
+// dimensionCardinality returns the approximate number of distinct values of
+// member within the datasource's default filters. It is not a countDistinct
+// measure, which the model may not define for the member: it is the total row
+// count Cube reports (total: true) for a query grouped by member with a limit
+// of 1, so only one row is transferred. That counts the groups, one per
+// distinct value, null included, and is exact up to the time the data
+// changes. Counts are cached for cardinalityCacheTTL.
+func (d *Datasource) dimensionCardinality(ctx context.Context, pCtx backend.PluginContext, apiReq *APIRequestContext, member string) (int64, error) {
+	filters, segments := d.tagValueFilters(ctx, pCtx, "")
+	query := map[string]interface{}{
+		"dimensions": []string{member},
+		"limit":      1,
+		"total":      true,
+	}
+	if len(filters) > 0 {
+		query["filters"] = filters
+	}
+	if len(segments) > 0 {
+		query["segments"] = segments
+	}
+	queryJSON, err := json.Marshal(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal query: %w", err)
+	}
+	if queryJSON, err = rewriteQueryJSON(queryJSON, pCtx, apiReq.Config); err != nil {
+		return 0, &loadRequestError{status: backend.StatusBadRequest, msg: err.Error()}
+	}
+	key := resultCacheKey(queryJSON, backend.TimeRange{}, apiReq.Config)
+	if count, ok := d.cardinalities.get(key, time.Now()); ok {
+		return count, nil
+	}
+
+	body, err := d.doCubeLoadRequest(ctx, apiReq.URL.String(), queryJSON, apiReq.Config)
+	if err != nil {
+		return 0, err
+	}
+	result, err := decodeLoadResult(body)
+	if err != nil {
+		return 0, err
+	}
+	if result.Total == nil {
+		return 0, &loadRequestError{status: backend.StatusBadGateway, msg: "Cube did not report the total row count"}
+	}
+	d.cardinalities.put(key, *result.Total, time.Now())
+	return *result.Total, nil
+}
+
+// checkCardinality refuses queries grouping by a dimension with more
+// distinct values than the datasource's maxDimensionCardinality. Time
+// dimensions and members missing from the model are not checked. The check
+// fails closed: a query whose dimensions cannot be counted is refused too,
+// as the limit exists to protect the warehouse.
+func (d *Datasource) checkCardinality(ctx context.Context, pCtx backend.PluginContext, apiReq *APIRequestContext, dimensions []string) error {
+	limit := apiReq.Config.DimensionCardinalityLimit()
+	if limit == 0 || len(dimensions) == 0 {
+		return nil
+	}
+	meta, err := d.getCubeMetadata(ctx, pCtx)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Warn("Failed to fetch cube metadata for the cardinality check", "error", err)
+		return &loadRequestError{status: backend.StatusBadGateway, msg: fmt.Sprintf("failed to fetch the model to check maxDimensionCardinality: %v", err)}
+	}
+	members := filterableMembers(meta)
+	for _, dimension := range dimensions {
+		if info, ok := members[dimension]; !ok || info.Kind != "dimension" || info.Type == "time" {
+			continue
+		}
+		count, err := d.dimensionCardinality(ctx, pCtx, apiReq, dimension)
+		if err != nil {
+			backend.Logger.FromContext(ctx).Warn("Failed to count distinct values", "dimension", dimension, "error", err)
+			return &loadRequestError{status: backend.StatusBadGateway, msg: fmt.Sprintf("failed to count the distinct values of %s to check maxDimensionCardinality: %v", dimension, err)}
+		}
+		if count > int64(limit) {
+			return &loadRequestError{
+				status: backend.StatusBadRequest,
+				msg:    fmt.Sprintf("%s has about %d distinct values, above the datasource's maxDimensionCardinality of %d: filter it or group by another dimension", dimension, count, limit),
+			}
+		}
+	}
+	return nil
+}
+
+// handleCardinality returns the approximate number of distinct values of a
+// dimension (cardinality?member=orders.customer_id), so the editor can warn
+// before a query groups by a high-cardinality dimension.
+func (d *Datasource) handleCardinality(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
+	}
+	member := parsedURL.Query().Get("member")
+	if member == "" {
+		return sender.Send(jsonErrorResponse(400, errors.New("member parameter is required")))
+	}
+
+	meta, err := d.getCubeMetadata(ctx, req.PluginContext)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to fetch cube metadata", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to fetch metadata from Cube API")))
+	}
+	if info, ok := filterableMembers(meta)[member]; !ok || info.Kind != "dimension" {
+		return sender.Send(jsonErrorResponse(400, fmt.Errorf("dimension %q not found in the Cube model", member)))
+	}
+	apiReq, err := d.buildAPIURL(req.PluginContext, "load")
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	if err := checkAllowedMembers(apiReq.Config, []string{member}); err != nil {
+		return sender.Send(jsonErrorResponse(http.StatusForbidden, err))
+	}
+
+	count, err := d.dimensionCardinality(ctx, req.PluginContext, apiReq, member)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to count distinct values", "member", member, "error", err)
+		return sender.Send(cubeLoadErrorResponse(err))
+	}
+	res := CardinalityResponse{Member: member, Cardinality: count, MaxCardinality: apiReq.Config.DimensionCardinalityLimit()}
+	threshold := cardinalityWarningThreshold
+	if res.MaxCardinality > 0 {
+		threshold = res.MaxCardinality
+	}
+	if count > int64(threshold) {
+		res.HighCardinality = true
+		res.Warning = fmt.Sprintf("%s has about %d distinct values; grouping by it returns very large results", member, count)
+		if res.MaxCardinality > 0 {
+			res.Warning = fmt.Sprintf("%s has about %d distinct values, above the datasource's maxDimensionCardinality of %d: queries grouping by it are refused", member, count, res.MaxCardinality)
+		}
+	}
+
+	body, err := json.Marshal(res)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal cardinality response", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   body,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
@@ -0,0 +1,218 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// newCardinalityServer starts a mock Cube server with an orders cube that
+// reports total as the row count of every load.
+func newCardinalityServer(t *testing.T, total int, counts *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+			_, _ = w.Write([]byte(`{"cubes": [{"name": "orders", "type": "cube",
+				"dimensions": [{"name": "orders.customer_id", "type": "string"}, {"name": "orders.created_at", "type": "time"}],
+				"measures": [{"name": "orders.count", "type": "number"}]}]}`))
+			return
+		}
+		var query map[string]interface{}
+		_ = json.Unmarshal([]byte(r.URL.Query().Get("query")), &query)
+		if query["total"] == true {
+			counts.Add(1)
+		}
+		_, _ = w.Write([]byte(`{"data": [{"orders.customer_id": "c1", "orders.count": "1"}], "total": ` + strconv.Itoa(total) + `}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHandleCardinality(t *testing.T) {
+	var counts atomic.Int32
+	server := newCardinalityServer(t, 2000000, &counts)
+	ds := &Datasource{}
+	for range 2 {
+		resp := callHandler(t, ds.handleCardinality, &backend.CallResourceRequest{
+			PluginContext: newTestPluginContext(server.URL),
+			URL:           "cardinality?member=orders.customer_id",
+		})
+		if resp.Status != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+		}
+		var res CardinalityResponse
+		if err := json.Unmarshal(resp.Body, &res); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		if res.Cardinality != 2000000 || !res.HighCardinality || res.Warning == "" || res.MaxCardinality != 0 {
+			t.Errorf("expected a high-cardinality warning, got %+v", res)
+		}
+	}
+	if got := counts.Load(); got != 1 {
+		t.Errorf("expected the count to be cached after 1 Cube query, got %d", got)
+	}
+
+	resp := callHandler(t, ds.handleCardinality, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "cardinality?member=orders.count",
+	})
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf("expected measures to be rejected, got %d: %s", resp.Status, resp.Body)
+	}
+}
+
+func TestQueryDataMaxDimensionCardinality(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantError bool
+	}{
+		{name: "above the limit", query: `{"refId": "A", "measures": ["orders.count"], "dimensions": ["orders.customer_id"]}`, wantError: true},
+		{name: "time dimensions not checked", query: `{"refId": "A", "measures": ["orders.count"], "dimensions": ["orders.created_at"]}`},
+		{name: "no dimensions", query: `{"refId": "A", "measures": ["orders.count"]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counts atomic.Int32
+			server := newCardinalityServer(t, 50000, &counts)
+			pCtx := newTestPluginContext(server.URL)
+			pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "maxDimensionCardinality": 10000}`)
+			res := runSingleQuery(t, &Datasource{}, pCtx, tt.query)
+			if tt.wantError {
+				if res.Status != backend.StatusBadRequest || res.Error == nil || !strings.Contains(res.Error.Error(), "maxDimensionCardinality") {
+					t.Errorf("expected the query to be refused, got %v: %v", res.Status, res.Error)
+				}
+				return
+			}
+			if res.Error != nil || counts.Load() != 0 {
+				t.Errorf("expected the query to run unchecked, got %v after %d counts", res.Error, counts.Load())
+			}
+		})
+	}
+}
+
+func TestQueryDataMaxDimensionCardinalityFailsClosed(t *testing.T) {
+	var loads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+			_, _ = w.Write([]byte(`{"cubes": [{"name": "orders", "type": "cube", "dimensions": [{"name": "orders.customer_id", "type": "string"}]}]}`))
+			return
+		}
+		if !strings.Contains(r.URL.Query().Get("query"), `"total":true`) {
+			loads.Add(1)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error": "warehouse unavailable"}`))
+	}))
+	defer server.Close()
+
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "maxDimensionCardinality": 10000}`)
+	res := runSingleQuery(t, &Datasource{}, pCtx, `{"refId": "A", "measures": ["orders.count"], "dimensions": ["orders.customer_id"]}`)
+	if res.Error == nil || !strings.Contains(res.Error.Error(), "maxDimensionCardinality") {
+		t.Errorf("expected the query to be refused when the count fails, got %v: %v", res.Status, res.Error)
+	}
+	if got := loads.Load(); got != 0 {
+		t.Errorf("expected the query not to run, got %d loads", got)
+	}
+}
+
+func TestQueryDataMaxDimensionCardinalityWithoutCubeURL(t *testing.T) {
+	server := newFakeSQLAPI(t, []pgColumn{{Name: "orders.count", TypeOID: pgTypeInt4}}, nil)
+	pCtx := sqlAPIPluginContext(t, server.listener.Addr().String())
+	pCtx.DataSourceInstanceSettings.URL = ""
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "queryTransport": "sql", "sqlApiAddress": "` +
+		server.listener.Addr().String() + `", "maxDimensionCardinality": 10000}`)
+
+	res := runSingleQuery(t, &Datasource{}, pCtx, `{"refId": "A", "measures": ["orders.count"], "dimensions": ["orders.customer_id"]}`)
+	if res.Status != backend.StatusBadRequest || res.Error == nil || !strings.Contains(res.Error.Error(), "Cube API URL is required") {
+		t.Errorf("expected the query to be refused, got %v: %v", res.Status, res.Error)
+
+	}
+	select {
+	case query := <-server.queries:
+		t.Errorf("expected the query not to run, got %s", query)
+	default:
+	}
+}
+
+func TestQueryDataChecksCardinalityConcurrently(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	bothCounting := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+			_, _ = w.Write([]byte(`{"cubes": [{"name": "orders", "type": "cube",
+				"dimensions": [{"name": "orders.customer_id", "type": "string"}, {"name": "orders.status", "type": "string"}],
+				"measures": [{"name": "orders.count", "type": "number"}]}]}`))
+			return
+		}
+		if strings.Contains(r.URL.Query().Get("query"), `"total":true`) {
+			n := inFlight.Add(1)
+			if n > maxInFlight.Load() {
+				maxInFlight.Store(n)
+			}
+			if n == 2 {
+				close(bothCounting)
+			}
+			// Wait for the other query's count, giving up so a serial
+			// implementation fails the test rather than hanging it.
+			select {
+			case <-bothCounting:
+			case <-time.After(2 * time.Second):
+			}
+			inFlight.Add(-1)
+		}
+		_, _ = w.Write([]byte(`{"data": [], "total": 5}`))
+	}))
+	defer server.Close()
+
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "maxDimensionCardinality": 10000}`)
+	resp, err := (&Datasource{}).QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pCtx,
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: []byte(`{"refId": "A", "measures": ["orders.count"], "dimensions": ["orders.customer_id"]}`)},
+			{RefID: "B", JSON: []byte(`{"refId": "B", "measures": ["orders.count"], "dimensions": ["orders.status"]}`)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("QueryData failed: %v", err)
+	}
+	for _, refID := range []string{"A", "B"} {
+		if res := resp.Responses[refID]; res.Error != nil {
+			t.Errorf("query %s failed: %v", refID, res.Error)
+		}
+	}
+	if got := maxInFlight.Load(); got != 2 {
+		t.Errorf("expected both cardinality checks to run at once, got at most %d", got)
+	}
+}
+
+func TestCardinalityCache(t *testing.T) {
+	var c cardinalityCache
+	now := time.Now()
+	c.put("a", 5, now)
+	if count, ok := c.get("a", now); !ok || count != 5 {
+		t.Errorf("expected the cached count, got %d %v", count, ok)
+	}
+	if _, ok := c.get("a", now.Add(cardinalityCacheTTL+time.Second)); ok {
+		t.Error("expected the count to expire")
+	}
+	for i := range maxCardinalityCacheEntries + 10 {
+		c.put(strconv.Itoa(i), int64(i), now)
+	}
+	if len(c.entries) > maxCardinalityCacheEntries {
+		t.Errorf("expected at most %d entries, got %d", maxCardinalityCacheEntries, len(c.entries))
+	}
+}
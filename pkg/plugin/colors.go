@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// colorIndex caches the colors set on members of the Cube model, built from
+// the metadata response meta.
+type colorIndex struct {
+	mu     sync.Mutex
+	meta   *CubeMetaResponse
+	colors map[string]string
+}
+
+// memberColors extracts the members with a color in their meta. Model owners
+// set a member's color as
+//
+//	meta: { color: "#73BF69" }
+//
+// Any color Grafana understands works: hex values or named colors ("green").
+func memberColors(meta *CubeMetaResponse) map[string]string {
+	colors := make(map[string]string)
+	add := func(name string, memberMeta map[string]interface{}) {
+		if color, _ := memberMeta["color"].(string); color != "" {
+			colors[name] = color
+		}
+	}
+	for _, cube := range meta.Cubes {
+		for _, dim := range cube.Dimensions {
+			add(dim.Name, dim.Meta)
+		}
+		for _, measure := range cube.Measures {
+			add(measure.Name, measure.Meta)
+		}
+	}
+	return colors
+}
+
+// getMemberColors returns the member colors of the model, from the metadata
+// cache. The index is rebuilt when the cached metadata changes. Colors are
+// best effort: without metadata there are none.
+func (d *Datasource) getMemberColors(ctx context.Context, pCtx backend.PluginContext) map[string]string {
+	meta, err := d.getCubeMetadata(ctx, pCtx)
+	if err != nil {
+		if ctx.Err() == nil {
+			backend.Logger.FromContext(ctx).Warn("Failed to fetch metadata for member colors", "error", err)
+		}
+		return nil
+	}
+
+	d.colors.mu.Lock()
+	defer d.colors.mu.Unlock()
+	if d.colors.meta != meta {
+		d.colors.meta, d.colors.colors = meta, memberColors(meta)
+	}
+	return d.colors.colors
+}
+
+// fixedColor sets the color of field to color, keeping the rest of its
+// config.
+func fixedColor(field *data.Field, color string) {
+	if field.Config == nil {
+		field.Config = &data.FieldConfig{}
+	}
+	field.Config.Color = map[string]interface{}{"mode": "fixed", "fixedColor": color}
+}
+
+// paletteMappings returns value mappings coloring the values of a dimension
+// field that appear in palette, or nil when none do. Only values present in
+// the result are mapped, so a large palette does not bloat every frame.
+func paletteMappings(field *data.Field, palette map[string]string) data.ValueMappings {
+	var values []string
+	for i := 0; i < field.Len(); i++ {
+		v, ok := field.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok || palette[s] == "" || slices.Contains(values, s) {
+			continue
+		}
+		values = append(values, s)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Strings(values)
+
+	mapper := make(data.ValueMapper, len(values))
+	for i, value := range values {
+		mapper[value] = data.ValueMappingResult{Color: palette[value], Index: i}
+	}
+	return data.ValueMappings{mapper}
+}
+
+// applyColors sets the field colors of a frame: members with a color in their
+// meta get it as a fixed color, and string dimensions get value mappings for
+// the values listed in the datasource's seriesColors palette, so brand and
+// status colors stay the same on every dashboard.
+func applyColors(frame *data.Frame, query CubeQuery, colors map[string]string, palette map[string]string) {
+	for _, field := range frame.Fields {
+		if color, ok := colors[field.Name]; ok {
+			fixedColor(field, color)
+		}
+		if len(palette) == 0 || !slices.Contains(query.Dimensions, field.Name) {
+			continue
+		}
+		if mappings := paletteMappings(field, palette); mappings != nil {
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			field.Config.Mappings = append(field.Config.Mappings, mappings...)
+		}
+	}
+}
+
+// addColors colors the frames of a successful response from member meta and
+// the datasource's seriesColors palette.
+func (d *Datasource) addColors(ctx context.Context, pCtx backend.PluginContext, prepared *preparedQuery, response backend.DataResponse) backend.DataResponse {
+	if response.Error != nil || len(response.Frames) == 0 {
+		return response
+	}
+	var palette map[string]string
+	if pCtx.DataSourceInstanceSettings != nil {
+		if config, err := d.loadSettings(*pCtx.DataSourceInstanceSettings); err == nil {
+			palette = config.SeriesColors
+		}
+	}
+	colors := d.getMemberColors(ctx, pCtx)
+	if len(colors) == 0 && len(palette) == 0 {
+		return response
+	}
+	for _, frame := range response.Frames {
+		applyColors(frame, prepared.query, colors, palette)
+	}
+	return response
+}
@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestMemberColors(t *testing.T) {
+	meta := &CubeMetaResponse{Cubes: []CubeMeta{{
+		Name: "orders",
+		Measures: []CubeMeasure{
+			{Name: "orders.revenue", Meta: map[string]interface{}{"color": "#73BF69"}},
+			{Name: "orders.count"},
+		},
+		Dimensions: []CubeDimension{
+			{Name: "orders.status", Meta: map[string]interface{}{"color": "blue"}},
+			{Name: "orders.region", Meta: map[string]interface{}{"color": 3}},
+		},
+	}}}
+
+	want := map[string]string{"orders.revenue": "#73BF69", "orders.status": "blue"}
+	if got := memberColors(meta); !reflect.DeepEqual(got, want) {
+		t.Errorf("memberColors() = %v, want %v", got, want)
+	}
+}
+
+func TestApplyColors(t *testing.T) {
+	frame := data.NewFrame("response",
+		data.NewField("orders.status", nil, []string{"completed", "failed", "pending", "failed"}),
+		data.NewField("orders.count", nil, []float64{1, 2, 3, 4}),
+	)
+	query := CubeQuery{Dimensions: []string{"orders.status"}, Measures: []string{"orders.count"}}
+	palette := map[string]string{"failed": "red", "completed": "green", "unused": "purple"}
+
+	applyColors(frame, query, map[string]string{"orders.count": "#5794F2"}, palette)
+
+	count := frame.Fields[1].Config
+	if count == nil || !reflect.DeepEqual(count.Color, map[string]interface{}{"mode": "fixed", "fixedColor": "#5794F2"}) {
+		t.Errorf("expected a fixed color on orders.count, got %+v", count)
+	}
+
+	status := frame.Fields[0].Config
+	if status == nil || len(status.Mappings) != 1 {
+		t.Fatalf("expected one value mapping on orders.status, got %+v", status)
+	}
+	want := data.ValueMapper{
+		"completed": {Color: "green", Index: 0},
+		"failed":    {Color: "red", Index: 1},
+	}
+	if !reflect.DeepEqual(status.Mappings[0], want) {
+		t.Errorf("unexpected mapping %+v", status.Mappings[0])
+	}
+}
+
+func TestQueryDataAppliesColors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+			_, _ = w.Write([]byte(`{"cubes": [{"name": "orders", "type": "cube",
+				"measures": [{"name": "orders.count", "type": "number", "meta": {"color": "#F2495C"}}],
+				"dimensions": [{"name": "orders.status", "type": "string"}]}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": [{"orders.status": "completed", "orders.count": "10"}],
+			"annotation": {"measures": {"orders.count": {"type": "number"}}, "dimensions": {"orders.status": {"type": "string"}}}}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "seriesColors": {"completed": "green"}}`)
+
+	res := runSingleQuery(t, ds, pCtx, `{"refId": "A", "measures": ["orders.count"], "dimensions": ["orders.status"]}`)
+	if res.Error != nil {
+		t.Fatalf("query failed: %v", res.Error)
+	}
+	frame := res.Frames[0]
+	status, _ := frame.FieldByName("orders.status")
+	if status == nil || status.Config == nil || len(status.Config.Mappings) != 1 {
+		t.Fatalf("expected a palette mapping on orders.status, got %+v", status)
+	}
+	count, _ := frame.FieldByName("orders.count")
+	if count == nil || count.Config == nil || count.Config.Color["fixedColor"] != "#F2495C" {
+		t.Errorf("expected the meta color on orders.count, got %+v", count)
+	}
+}
+
+func TestQueryDataWithoutColorsLeavesFieldConfig(t *testing.T) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [{"orders.count": "10"}],
+			"annotation": {"measures": {"orders.count": {"type": "number"}}}}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	res := runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId": "A", "measures": ["orders.count"]}`)
+	if res.Error != nil {
+		t.Fatalf("query failed: %v", res.Error)
+	}
+	for _, field := range res.Frames[0].Fields {
+		if field.Config != nil && (field.Config.Color != nil || len(field.Config.Mappings) > 0) {
+			t.Errorf("expected no colors on %s, got %+v", field.Name, field.Config)
+		}
+	}
+}
+
+func TestMemberColorsFollowMetadataCache(t *testing.T) {
+	var color atomic.Value
+	color.Store("green")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"cubes": [{"name": "orders", "type": "cube",
+			"measures": [{"name": "orders.count", "type": "number", "meta": {"color": %q}}]}]}`, color.Load())
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+	if got := ds.getMemberColors(context.Background(), pCtx)["orders.count"]; got != "green" {
+		t.Fatalf("expected green, got %q", got)
+	}
+	color.Store("red")
+	if got := ds.getMemberColors(context.Background(), pCtx)["orders.count"]; got != "green" {
+		t.Errorf("expected the cached metadata to be used, got %q", got)
+	}
+	ds.invalidateMetadata()
+	if got := ds.getMemberColors(context.Background(), pCtx)["orders.count"]; got != "red" {
+		t.Errorf("expected the refreshed metadata to be used, got %q", got)
+	}
+
+	// Without a metadata cache every query reads the current model.
+	uncached := newTestPluginContext(server.URL)
+	uncached.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "metaCacheTTL": 0}`)
+	color.Store("blue")
+	if got := ds.getMemberColors(context.Background(), uncached)["orders.count"]; got != "blue" {
+		t.Errorf("expected colors without a metadata cache to follow the model, got %q", got)
+	}
+}
@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// conditionalCache remembers the validators (ETag, Last-Modified) and body of
+// the last successful response per URL, so the next fetch of the same URL can
+// be a conditional request and a 304 Not Modified is answered from the stored
+// body. Large Cube models then only cross the network when they change. Only
+// responses that carry a validator are stored.
+type conditionalCache struct {
+	mu      sync.Mutex
+	entries map[string]conditionalEntry
+}
+
+type conditionalEntry struct {
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+// addConditionalHeaders makes req conditional on the stored response for its
+// URL, if there is one.
+func (c *conditionalCache) addConditionalHeaders(req *http.Request) {
+	c.mu.Lock()
+	entry, ok := c.entries[req.URL.String()]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	if entry.etag != "" {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+	if entry.lastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.lastModified)
+	}
+}
+
+// readResponse reads the JSON body of a response to a request prepared with
+// addConditionalHeaders: the stored body for 304 Not Modified, otherwise the
+// response body (see readJSONResponse), which is stored when the response
+// carries a validator.
+func (c *conditionalCache) readResponse(req *http.Request, resp *http.Response) ([]byte, error) {
+	key := req.URL.String()
+
+	if resp.StatusCode == http.StatusNotModified {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		c.mu.Lock()
+		entry, ok := c.entries[key]
+		c.mu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("API request failed with status %d: no cached response to revalidate", resp.StatusCode)
+		}
+		return entry.body, nil
+	}
+
+	body, err := readJSONResponse(resp)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	entry := conditionalEntry{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		body:         body,
+	}
+	if entry.etag == "" && entry.lastModified == "" {
+		delete(c.entries, key)
+		return body, nil
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]conditionalEntry)
+	}
+	c.entries[key] = entry
+	return body, nil
+}
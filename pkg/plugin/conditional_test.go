@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// newRevalidatingServer serves body with the given validator header and
+// answers 304 Not Modified when the request carries the matching
+// conditional header. It counts full and 304 responses.
+func newRevalidatingServer(t *testing.T, validator, value, conditional, body string, full, notModified *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if value != "" && r.Header.Get(conditional) == value {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if value != "" {
+			w.Header().Set(validator, value)
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchCubeMetadataRevalidatesWithETag(t *testing.T) {
+	var full, notModified atomic.Int32
+	server := newRevalidatingServer(t, "ETag", `"v1"`, "If-None-Match",
+		`{"cubes": [{"name": "orders", "type": "cube"}]}`, &full, &notModified)
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+
+	for i := 0; i < 3; i++ {
+		meta, err := ds.fetchCubeMetadata(context.Background(), pCtx)
+		if err != nil {
+			t.Fatalf("fetch %d: unexpected error %v", i, err)
+		}
+		if len(meta.Cubes) != 1 || meta.Cubes[0].Name != "orders" {
+			t.Fatalf("fetch %d: unexpected metadata %+v", i, meta)
+		}
+	}
+	if full.Load() != 1 || notModified.Load() != 2 {
+		t.Errorf("expected 1 full response and 2 revalidations, got %d and %d", full.Load(), notModified.Load())
+	}
+}
+
+func TestHandleModelFilesRevalidatesWithLastModified(t *testing.T) {
+	const lastModified = "Wed, 14 Oct 2026 10:00:00 GMT"
+	var full, notModified atomic.Int32
+	server := newRevalidatingServer(t, "Last-Modified", lastModified, "If-Modified-Since",
+		`{"files": [{"fileName": "orders.yml", "content": "cubes: []"}]}`, &full, &notModified)
+	ds := &Datasource{BaseURL: server.URL}
+
+	for i := 0; i < 2; i++ {
+		resp := callHandler(t, ds.handleModelFiles, &backend.CallResourceRequest{
+			PluginContext: newTestPluginContext(server.URL),
+			Path:          "model-files",
+			Method:        "GET",
+		})
+		if resp.Status != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d: %s", i, resp.Status, resp.Body)
+		}
+		if !strings.Contains(string(resp.Body), "orders.yml") {
+			t.Fatalf("request %d: expected the model file, got %s", i, resp.Body)
+		}
+	}
+	if full.Load() != 1 || notModified.Load() != 1 {
+		t.Errorf("expected 1 full response and 1 revalidation, got %d and %d", full.Load(), notModified.Load())
+	}
+}
+
+func TestFetchCubeMetadataWithoutValidators(t *testing.T) {
+	var full, notModified atomic.Int32
+	server := newRevalidatingServer(t, "ETag", "", "If-None-Match", `{"cubes": []}`, &full, &notModified)
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+
+	for i := 0; i < 2; i++ {
+		if _, err := ds.fetchCubeMetadata(context.Background(), pCtx); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if full.Load() != 2 {
+		t.Errorf("expected every fetch to get a full response, got %d", full.Load())
+	}
+	if len(ds.validators.entries) != 0 {
+		t.Errorf("expected nothing to be stored without validators, got %d entries", len(ds.validators.entries))
+	}
+}
+
+func TestConditionalCacheNotModifiedWithoutStoredResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	if _, err := ds.fetchCubeMetadata(context.Background(), newTestPluginContext(server.URL)); err == nil {
+		t.Fatal("expected an error for an unsolicited 304")
+	}
+}
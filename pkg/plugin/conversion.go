@@ -0,0 +1,149 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// queryMigrations upgrade the panel query model: queryMigrations[i] upgrades
+// a query of schema version i to version i+1. Each works on the query's
+// top-level JSON fields and leaves queries already in the newer shape
+// unchanged, so queries saved without a schemaVersion can be migrated any
+// number of times.
+var queryMigrations = []func(query map[string]json.RawMessage) error{
+	migrateFilterDimensions,
+	migrateOrderObject,
+}
+
+// querySchemaVersion is the current version of the panel query model.
+var querySchemaVersion = len(queryMigrations)
+
+// ConvertQueryDataRequest upgrades the queries of saved dashboards to the
+// current query model when Grafana loads them, so the model can change
+// without breaking existing panels. Queries that cannot be decoded are left
+// as they are; running them reports the error. It is the plugin's stateless
+// query conversion handler.
+func ConvertQueryDataRequest(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryConversionResponse, error) {
+	queries := make([]any, 0, len(req.Queries))
+	for _, q := range req.Queries {
+		migrated, err := migrateQuery(q.JSON)
+		if err != nil {
+			backend.Logger.FromContext(ctx).Debug("Query not migrated", "refId", q.RefID, "error", err)
+		} else {
+			q.JSON = migrated
+		}
+		queries = append(queries, q)
+	}
+	return &backend.QueryConversionResponse{Queries: queries}, nil
+}
+
+// migrateQuery upgrades a panel query from its schemaVersion to
+// querySchemaVersion.
+func migrateQuery(raw json.RawMessage) (json.RawMessage, error) {
+	var query map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &query); err != nil {
+		return nil, fmt.Errorf("invalid query JSON: %w", err)
+	}
+	version := 0
+	if v, ok := query["schemaVersion"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil || version < 0 {
+			return nil, fmt.Errorf("invalid schemaVersion %s", v)
+		}
+	}
+	if version >= querySchemaVersion {
+		return raw, nil
+	}
+	for _, migrate := range queryMigrations[version:] {
+		if err := migrate(query); err != nil {
+			return nil, err
+		}
+	}
+	query["schemaVersion"] = json.RawMessage(fmt.Sprint(querySchemaVersion))
+	return json.Marshal(query)
+}
+
+// migrateFilterDimensions renames the "dimension" key of filters to
+// "member", which replaced it in Cube's query format, inside and/or groups
+// too.
+func migrateFilterDimensions(query map[string]json.RawMessage) error {
+	raw, ok := query["filters"]
+	if !ok {
+		return nil
+	}
+	var filters []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&filters); err != nil {
+		return fmt.Errorf("invalid filters: %w", err)
+	}
+	renameFilterDimensions(filters)
+	migrated, err := json.Marshal(filters)
+	if err != nil {
+		return err
+	}
+	query["filters"] = migrated
+	return nil
+}
+
+// renameFilterDimensions renames the "dimension" key of filters in place.
+func renameFilterDimensions(filters []interface{}) {
+	for _, f := range filters {
+		filter, ok := f.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if dimension, ok := filter["dimension"]; ok {
+			if _, hasMember := filter["member"]; !hasMember {
+				filter["member"] = dimension
+			}
+			delete(filter, "dimension")
+		}
+		for _, group := range []string{"and", "or"} {
+			if nested, ok := filter[group].([]interface{}); ok {
+				renameFilterDimensions(nested)
+			}
+		}
+	}
+}
+
+// migrateOrderObject turns an order object, {"orders.count": "desc"}, into
+// the array of [member, direction] pairs the query editor writes, keeping the
+// object's key order. Members ordered "none" are dropped.
+func migrateOrderObject(query map[string]json.RawMessage) error {
+	raw, ok := query["order"]
+	if !ok || !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("invalid order: %w", err)
+	}
+	pairs := [][2]string{}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("invalid order: %w", err)
+		}
+		var direction string
+		if err := decoder.Decode(&direction); err != nil {
+			return fmt.Errorf("invalid order of %v: %w", key, err)
+		}
+		if direction != "none" {
+			pairs = append(pairs, [2]string{key.(string), direction})
+		}
+	}
+	if len(pairs) == 0 {
+		delete(query, "order")
+		return nil
+	}
+	migrated, err := json.Marshal(pairs)
+	if err != nil {
+		return err
+	}
+	query["order"] = migrated
+	return nil
+}
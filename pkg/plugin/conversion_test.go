@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestMigrateQuery(t *testing.T) {
+	tests := map[string]struct {
+		query string
+		want  string
+	}{
+		"legacy filters and order": {
+			query: `{"refId": "A", "measures": ["orders.count"],
+				"filters": [{"dimension": "orders.status", "operator": "equals", "values": ["completed"]},
+					{"or": [{"dimension": "orders.amount", "operator": "gt", "values": [10.50]}]}],
+				"order": {"orders.status": "asc", "orders.created_at": "none", "orders.count": "desc"}}`,
+			want: `{"filters":[{"member":"orders.status","operator":"equals","values":["completed"]},{"or":[{"member":"orders.amount","operator":"gt","values":[10.50]}]}],` +
+				`"measures":["orders.count"],"order":[["orders.status","asc"],["orders.count","desc"]],"refId":"A","schemaVersion":2}`,
+		},
+		"current shapes": {
+			query: `{"refId": "A", "filters": [{"member": "orders.status", "operator": "set"}], "order": [["orders.count", "desc"]]}`,
+			want:  `{"filters":[{"member":"orders.status","operator":"set"}],"order":[["orders.count","desc"]],"refId":"A","schemaVersion":2}`,
+		},
+		"only none order": {
+			query: `{"refId": "A", "order": {"orders.count": "none"}}`,
+			want:  `{"refId":"A","schemaVersion":2}`,
+		},
+		"current version": {
+			query: `{"refId": "A", "order": {"orders.count": "desc"}, "schemaVersion": 2}`,
+			want:  `{"refId": "A", "order": {"orders.count": "desc"}, "schemaVersion": 2}`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := migrateQuery(json.RawMessage(tt.query))
+			if err != nil {
+				t.Fatalf("migrateQuery failed: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestConvertQueryDataRequest(t *testing.T) {
+	resp, err := ConvertQueryDataRequest(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{
+		{RefID: "A", JSON: json.RawMessage(`{"refId": "A", "order": {"orders.count": "desc"}}`)},
+		{RefID: "B", JSON: json.RawMessage(`{"refId": "B", "filters": "nope"}`)},
+	}})
+	if err != nil {
+		t.Fatalf("ConvertQueryDataRequest failed: %v", err)
+	}
+	if len(resp.Queries) != 2 {
+		t.Fatalf("expected 2 queries, got %d", len(resp.Queries))
+	}
+	if got := string(resp.Queries[0].(backend.DataQuery).JSON); got != `{"order":[["orders.count","desc"]],"refId":"A","schemaVersion":2}` {
+		t.Errorf("expected the order migrated, got %s", got)
+	}
+	if got := string(resp.Queries[1].(backend.DataQuery).JSON); got != `{"refId": "B", "filters": "nope"}` {
+		t.Errorf("expected an invalid query left as is, got %s", got)
+	}
+}
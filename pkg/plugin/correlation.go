@@ -0,0 +1,41 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// correlationIDKey is the context key of the correlation ID.
+type correlationIDKey struct{}
+
+// withCorrelationID returns a context carrying a new correlation ID. The ID is
+// added to every log entry written with backend.Logger.FromContext(ctx), sent
+// to Cube as X-Request-Id (which Cube logs with the query), and appended to
+// query errors, so support can follow a failing panel from Grafana's logs to
+// Cube's.
+func withCorrelationID(ctx context.Context) context.Context {
+	id := uuid.NewString()
+	ctx = context.WithValue(ctx, correlationIDKey{}, id)
+	return log.WithContextualAttributes(ctx, []any{"correlationID", id})
+}
+
+// correlationIDFrom returns the correlation ID of ctx, or "".
+func correlationIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// withCorrelationIDError appends the correlation ID of ctx to the error of a
+// response, so users can quote it when reporting the failure.
+func withCorrelationIDError(ctx context.Context, response backend.DataResponse) backend.DataResponse {
+	id := correlationIDFrom(ctx)
+	if response.Error == nil || id == "" {
+		return response
+	}
+	response.Error = fmt.Errorf("%w (request ID: %s)", response.Error, id)
+	return response
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+func TestWithCorrelationID(t *testing.T) {
+	ctx := withCorrelationID(context.Background())
+	id := correlationIDFrom(ctx)
+	if id == "" {
+		t.Fatal("expected a correlation ID")
+	}
+	if other := correlationIDFrom(withCorrelationID(context.Background())); other == id {
+		t.Errorf("expected a new ID per call, got %q twice", id)
+	}
+
+	attrs := log.ContextualAttributesFromContext(ctx)
+	if len(attrs) != 2 || attrs[0] != "correlationID" || attrs[1] != id {
+		t.Errorf("expected the ID as a contextual log attribute, got %v", attrs)
+	}
+}
+
+func TestQueryDataSendsCorrelationIDAndReportsItInErrors(t *testing.T) {
+	var requestIDs []string
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		requestIDs = append(requestIDs, r.Header.Get(requestIDHeader))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": "Query should contain either measures, dimensions or timeDimensions"}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	res := runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId": "A", "measures": ["orders.count"]}`)
+	if res.Error == nil {
+		t.Fatal("expected the query to fail")
+	}
+
+	if len(requestIDs) != 1 || requestIDs[0] == "" {
+		t.Fatalf("expected one load request with an X-Request-Id, got %q", requestIDs)
+	}
+	if want := "(request ID: " + requestIDs[0] + ")"; !strings.HasSuffix(res.Error.Error(), want) {
+		t.Errorf("expected the error to end with %q, got %q", want, res.Error.Error())
+	}
+	if res.Status != backend.StatusBadRequest {
+		t.Errorf("expected the status to be kept, got %d", res.Status)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/grafana/cube/pkg/models"
@@ -97,7 +98,7 @@ func classifyTransportError(err error) transportErrorKind {
 	switch {
 	case errors.Is(err, context.DeadlineExceeded):
 		return transportTimeout
-	case errors.Is(err, context.Canceled):
+	case errors.Is(err, context.Canceled), errors.Is(err, errInstanceDisposed):
 		return transportAborted
 	default:
 		return transportNetworkError
@@ -143,13 +144,14 @@ func (d *Datasource) retryBackoff(attempt int) time.Duration {
 	return backoff
 }
 
-// sleepWithContext waits for d, returning the context error if the context is
-// cancelled first. A non-positive duration still honours cancellation.
+// sleepWithContext waits for d, returning the context's cancellation cause if
+// the context is cancelled first. A non-positive duration still honours
+// cancellation.
 func sleepWithContext(ctx context.Context, d time.Duration) error {
 	if d <= 0 {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return context.Cause(ctx)
 		default:
 			return nil
 		}
@@ -158,7 +160,7 @@ func sleepWithContext(ctx context.Context, d time.Duration) error {
 	defer timer.Stop()
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return context.Cause(ctx)
 	case <-timer.C:
 		return nil
 	}
@@ -172,7 +174,7 @@ func interruptedWaitError(ctxErr error, progress continueWaitProgress, haveProgr
 	if errors.Is(ctxErr, context.DeadlineExceeded) {
 		msg = "Cube API request timed out while waiting for results to be computed"
 	} else {
-		msg = "query cancelled while waiting for Cube to compute results"
+		msg = cancelledWaitMessage(ctxErr)
 	}
 	if haveProgress && (progress.Stage != "" || progress.TimeElapsed > 0) {
 		msg = fmt.Sprintf("%s (stage: %s, Cube timeElapsed: %ds)", msg, progress.Stage, int(progress.TimeElapsed))
@@ -180,18 +182,39 @@ func interruptedWaitError(ctxErr error, progress continueWaitProgress, haveProgr
 	return &loadRequestError{status: statusForContextErr(ctxErr), msg: msg}
 }
 
+// cancelledWaitMessage describes a wait on Cube cancelled with cause.
+func cancelledWaitMessage(cause error) string {
+	if errors.Is(cause, errInstanceDisposed) {
+		return "query cancelled because the datasource settings changed or Grafana is shutting down"
+	}
+	return "query cancelled while waiting for Cube to compute results"
+}
+
 // CubeAPIError represents a non-200 HTTP response from the Cube API.
 // It preserves the original status code and body so callers (e.g. handleTagValues)
 // can forward them to the frontend instead of collapsing everything to 500.
 type CubeAPIError struct {
-	StatusCode int
-	Body       []byte
+	StatusCode  int
+	Body        []byte
+	ContentType string
 }
 
 func (e *CubeAPIError) Error() string {
+	if nonJSON := e.nonJSON(); nonJSON != nil {
+		return nonJSON.Error()
+	}
 	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, string(e.Body))
 }
 
+// nonJSON describes the body when it is not JSON (a proxy error page rather
+// than a Cube error), or returns nil.
+func (e *CubeAPIError) nonJSON() *nonJSONResponseError {
+	if isJSONBody(e.Body) {
+		return nil
+	}
+	return newNonJSONResponseError(e.StatusCode, e.ContentType, e.Body)
+}
+
 // doCubeLoadRequest sends a query to Cube's /v1/load endpoint, handling the
 // "Continue wait" polling protocol. Cube returns {"error": "Continue wait"} (HTTP 200)
 // when query results aren't cached yet (e.g. the upstream warehouse is still computing).
@@ -205,21 +228,72 @@ func (e *CubeAPIError) Error() string {
 // (default 10s, see cubejs-query-orchestrator QueryQueue) before returning
 // {"error":"Continue wait"}, so each HTTP round-trip already blocks server-side.
 // Adding a client-side delay would double-pace and add latency, so we mirror the
-// SDK and retry immediately. This is SDK-aligned, not a divergence.
+// SDK and retry immediately by default. This is SDK-aligned, not a divergence.
+// Admins can still add a delay (continueWaitPollInterval) for Cube deployments
+// with a short continueWaitTimeout, and bound the polling
+// (continueWaitMaxDuration) independently of the overall query timeout.
 //
 // SDK alignment: like @cubejs-client/core, the query is sent via GET with the
 // query JSON URL-encoded in the query string while the full URL stays under
 // urlLengthLimit, and via POST with a {"query": ...} JSON body otherwise.
 func (d *Datasource) doCubeLoadRequest(ctx context.Context, loadURL string, queryJSON []byte, config *models.PluginSettings) ([]byte, error) {
+	return d.doCubeLoad(ctx, loadURL, queryJSON, "", config)
+}
+
+// doCubeMultiLoadRequest sends an array of queries to /v1/load with
+// queryType=multi, the same way @cubejs-client/core's load() does. Cube answers
+// with {"queryType": "multi", "results": [...]}, one result per query in order.
+func (d *Datasource) doCubeMultiLoadRequest(ctx context.Context, loadURL string, queriesJSON []byte, config *models.PluginSettings) ([]byte, error) {
+	return d.doCubeLoad(ctx, loadURL, queriesJSON, queryTypeMulti, config)
+}
+
+// queryTypeMulti is the /v1/load queryType that makes Cube return a results
+// array instead of a single result.
+const queryTypeMulti = "multi"
+
+// doCubeLoad implements doCubeLoadRequest and doCubeMultiLoadRequest. An empty
+// queryType omits the parameter.
+func (d *Datasource) doCubeLoad(ctx context.Context, loadURL string, queryJSON []byte, queryType string, config *models.PluginSettings) ([]byte, error) {
+	// The configured query timeout bounds the whole call, Continue-wait
+	// polling and retries included.
+	ctx, cancel := withTimeout(ctx, config.QueryTimeoutDuration())
+	defer cancel()
+	ctx, done := d.loads.track(ctx)
+	defer done()
+	release, err := d.acquireQuerySlot(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, span := traceLoad(ctx, queryType)
+	defer span.End()
+
+	if config.UseWebSockets {
+		body, err := d.doCubeLoadWebSocket(ctx, loadURL, queryJSON, queryType, config)
+		var unavailable *webSocketUnavailableError
+		if !errors.As(err, &unavailable) {
+			return body, err
+		}
+		backend.Logger.FromContext(ctx).Warn("Cube WebSocket API unavailable, falling back to HTTP", "url", loadURL, "error", err)
+	}
+
 	params := url.Values{}
 	params.Add("query", string(queryJSON))
+	if queryType != "" {
+		params.Add("queryType", queryType)
+	}
 	getURL := loadURL + "?" + params.Encode()
 
 	usePost := len(getURL) >= urlLengthLimit
 	var postBody []byte
 	if usePost {
+		payload := map[string]json.RawMessage{"query": queryJSON}
+		if queryType != "" {
+			payload["queryType"] = json.RawMessage(strconv.Quote(queryType))
+		}
 		var err error
-		postBody, err = json.Marshal(map[string]json.RawMessage{"query": queryJSON})
+		postBody, err = json.Marshal(payload)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
@@ -248,8 +322,7 @@ func (d *Datasource) doCubeLoadRequest(ctx context.Context, loadURL string, quer
 		}
 		req.Header.Set("Content-Type", "application/json")
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		resp, err := d.doHTTP(req, config)
 		if err != nil {
 			switch classifyTransportError(err) {
 			case transportTimeout:
@@ -260,7 +333,7 @@ func (d *Datasource) doCubeLoadRequest(ctx context.Context, loadURL string, quer
 				}
 				return nil, &loadRequestError{status: backend.StatusTimeout, msg: msg}
 			case transportAborted:
-				msg := "query cancelled while waiting for Cube to compute results"
+				msg := cancelledWaitMessage(context.Cause(ctx))
 				if haveContinueWaitProgress && (lastContinueWaitProgress.Stage != "" || lastContinueWaitProgress.TimeElapsed > 0) {
 					msg = fmt.Sprintf("%s (stage: %s, Cube timeElapsed: %ds)", msg, lastContinueWaitProgress.Stage, int(lastContinueWaitProgress.TimeElapsed))
 				}
@@ -272,7 +345,7 @@ func (d *Datasource) doCubeLoadRequest(ctx context.Context, loadURL string, quer
 					networkRetriesLeft--
 					backoff := d.retryBackoff(networkAttempt)
 					networkAttempt++
-					backend.Logger.Warn("Cube API request failed with transient network error, retrying",
+					backend.Logger.FromContext(ctx).Warn("Cube API request failed with transient network error, retrying",
 						"url", loadURL, "backoff", backoff, "error", err)
 					if waitErr := sleepWithContext(ctx, backoff); waitErr != nil {
 						return nil, interruptedWaitError(waitErr, lastContinueWaitProgress, haveContinueWaitProgress)
@@ -297,7 +370,7 @@ func (d *Datasource) doCubeLoadRequest(ctx context.Context, loadURL string, quer
 				networkRetriesLeft--
 				backoff := d.retryBackoff(networkAttempt)
 				networkAttempt++
-				backend.Logger.Warn("Cube API returned 502 Bad Gateway, retrying",
+				backend.Logger.FromContext(ctx).Warn("Cube API returned 502 Bad Gateway, retrying",
 					"url", loadURL, "backoff", backoff)
 				if waitErr := sleepWithContext(ctx, backoff); waitErr != nil {
 					// Cancelled/timed out during backoff: surface the
@@ -306,36 +379,52 @@ func (d *Datasource) doCubeLoadRequest(ctx context.Context, loadURL string, quer
 				}
 				continue
 			}
-			return nil, &CubeAPIError{StatusCode: resp.StatusCode, Body: errorBody}
+			return nil, &CubeAPIError{StatusCode: resp.StatusCode, Body: errorBody, ContentType: resp.Header.Get("Content-Type")}
 		}
 
-		body, err := io.ReadAll(resp.Body)
+		body, err := readLoadBody(resp.Body, maxResponseBytes(config))
 		_ = resp.Body.Close()
 		if err != nil {
-			return nil, fmt.Errorf("failed to read response body: %w", err)
+			return nil, err
+		}
+		if !isJSONBody(body) {
+			return nil, newNonJSONResponseError(resp.StatusCode, resp.Header.Get("Content-Type"), body)
 		}
 
-		if isContinueWait(body) {
-			// Parse progress info from the response for logging and error messages.
-			// Cube returns {"error": "Continue wait", "stage": "...", "timeElapsed": N}
-			progress := parseContinueWaitProgress(body)
+		if envelope, err := decodeCubeEnvelope(body); err == nil && envelope.isContinueWait() {
+			// Keep the progress info for logging and error messages.
+			progress := envelope.progress()
 			lastContinueWaitProgress = progress
 			haveContinueWaitProgress = true
 
 			if pollRetries == 0 {
-				backend.Logger.Info("Cube query not yet ready, polling for results", "url", loadURL)
+				backend.Logger.FromContext(ctx).Info("Cube query not yet ready, polling for results", "url", loadURL)
 			}
 			pollRetries++
-			backend.Logger.Debug("Cube returned 'Continue wait', polling again",
+			backend.Logger.FromContext(ctx).Debug("Cube returned 'Continue wait', polling again",
 				"url", loadURL, "attempt", pollRetries,
 				"stage", progress.Stage, "cubeTimeElapsed", progress.TimeElapsed)
+			if observe := continueWaitObserverFrom(ctx); observe != nil {
+				observe(progress)
+			}
+			if maxWait := config.MaxContinueWait(); maxWait > 0 && time.Since(pollStart) >= maxWait {
+				msg := fmt.Sprintf("Cube query still not ready after waiting %s (continueWaitMaxDuration)", time.Since(pollStart).Round(time.Millisecond))
+				if progress.Stage != "" || progress.TimeElapsed > 0 {
+					msg = fmt.Sprintf("%s (stage: %s, Cube timeElapsed: %ds)", msg, progress.Stage, int(progress.TimeElapsed))
+				}
+				return nil, &loadRequestError{status: backend.StatusTimeout, msg: msg}
+			}
+			if pollInterval := config.ContinueWaitPollIntervalDuration(); pollInterval > 0 {
+				// Interrupted sleeps are reported below.
+				_ = sleepWithContext(ctx, pollInterval)
+			}
 			select {
 			case <-ctx.Done():
 				var msg string
 				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
 					msg = "Cube API request timed out while waiting for results to be computed"
 				} else {
-					msg = "query cancelled while waiting for Cube to compute results"
+					msg = cancelledWaitMessage(context.Cause(ctx))
 				}
 				if progress.Stage != "" || progress.TimeElapsed > 0 {
 					msg = fmt.Sprintf("%s (stage: %s, Cube timeElapsed: %ds)", msg, progress.Stage, int(progress.TimeElapsed))
@@ -347,45 +436,40 @@ func (d *Datasource) doCubeLoadRequest(ctx context.Context, loadURL string, quer
 		}
 
 		if pollRetries > 0 {
-			backend.Logger.Info("Cube query results ready after polling", "url", loadURL, "retries", pollRetries, "duration", time.Since(pollStart).Round(time.Millisecond))
+			backend.Logger.FromContext(ctx).Info("Cube query results ready after polling", "url", loadURL, "retries", pollRetries, "duration", time.Since(pollStart).Round(time.Millisecond))
 		}
 
 		return body, nil
 	}
 }
 
-// isContinueWait checks whether a Cube API response body is a "Continue wait"
-// polling response. Cube returns {"error": "Continue wait"} (HTTP 200) when
-// the query result is not yet ready (e.g. the upstream warehouse is still
-// computing). The caller is expected to retry until actual data arrives.
-func isContinueWait(body []byte) bool {
-	var probe struct {
-		Error string `json:"error"`
-	}
-	if err := json.Unmarshal(body, &probe); err != nil {
-		return false
-	}
-	return probe.Error == "Continue wait"
-}
-
 // continueWaitProgress holds progress information from a Cube "Continue wait" response.
 type continueWaitProgress struct {
 	Stage       string  `json:"stage"`
 	TimeElapsed float64 `json:"timeElapsed"`
 }
 
-// parseContinueWaitProgress extracts stage and timeElapsed from a Cube
-// "Continue wait" response body. Returns zero values if fields are missing.
-func parseContinueWaitProgress(body []byte) continueWaitProgress {
-	var progress continueWaitProgress
-	_ = json.Unmarshal(body, &progress)
-	return progress
-}
-
 // CubeAPIResponse represents the response structure from Cube API
 type CubeAPIResponse struct {
 	Data       []map[string]interface{} `json:"data"`
 	Annotation CubeAnnotation           `json:"annotation"`
+	// UsedPreAggregations lists the pre-aggregations Cube answered from.
+	UsedPreAggregations map[string]usedPreAggregation `json:"usedPreAggregations,omitempty"`
+	// RequestID, DBType, External and SlowQuery describe how Cube ran the
+	// query; see responseMetaFor.
+	RequestID string `json:"requestId,omitempty"`
+	DBType    string `json:"dbType,omitempty"`
+	External  *bool  `json:"external,omitempty"`
+	SlowQuery bool   `json:"slowQuery,omitempty"`
+	// Total is the row count of the query without limit and offset, which
+	// Cube returns for queries with total set.
+	Total *int64 `json:"total,omitempty"`
+}
+
+// CubeMultiAPIResponse represents a /v1/load response for queryType=multi
+type CubeMultiAPIResponse struct {
+	QueryType string            `json:"queryType"`
+	Results   []CubeAPIResponse `json:"results"`
 }
 
 // CubeAnnotation represents the type information from Cube API
@@ -411,6 +495,9 @@ func (d *Datasource) fetchCubeMetadata(ctx context.Context, pluginContext backen
 		return nil, err
 	}
 
+	ctx, cancel := withTimeout(ctx, apiReq.Config.MetaTimeoutDuration())
+	defer cancel()
+
 	// Create HTTP request
 	req, err := http.NewRequestWithContext(ctx, "GET", apiReq.URL.String(), nil)
 	if err != nil {
@@ -421,29 +508,22 @@ func (d *Datasource) fetchCubeMetadata(ctx context.Context, pluginContext backen
 		return nil, fmt.Errorf("failed to add auth headers: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	d.validators.addConditionalHeaders(req)
 
 	// Make the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := d.doHTTP(req, apiReq.Config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make API request: %w", err)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			backend.Logger.Warn("Failed to close response body", "error", err)
+			backend.Logger.FromContext(ctx).Warn("Failed to close response body", "error", err)
 		}
 	}()
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		errorBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(errorBody))
-	}
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := d.validators.readResponse(req, resp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
 
 	// Parse the API response
@@ -462,27 +542,75 @@ type CubeMetaResponse struct {
 
 // CubeMeta represents metadata for a single cube or view
 type CubeMeta struct {
-	Name       string          `json:"name"`
-	Title      string          `json:"title"`
-	Type       string          `json:"type"` // "cube" or "view"
-	Dimensions []CubeDimension `json:"dimensions"`
-	Measures   []CubeMeasure   `json:"measures"`
+	Name        string          `json:"name"`
+	Title       string          `json:"title"`
+	Description string          `json:"description,omitempty"`
+	Type        string          `json:"type"`                 // "cube" or "view"
+	DataSource  string          `json:"dataSource,omitempty"` // Cube data_source; empty when not reported
+	Dimensions  []CubeDimension `json:"dimensions"`
+	Measures    []CubeMeasure   `json:"measures"`
+	Segments    []CubeSegment   `json:"segments,omitempty"`
+	// IsVisible and Public are false for views and members the model hides;
+	// nil when Cube does not report them.
+	IsVisible *bool `json:"isVisible,omitempty"`
+	Public    *bool `json:"public,omitempty"`
+	// Folders, NestedFolders and Hierarchies organize the members of views.
+	Folders       []CubeFolder    `json:"folders,omitempty"`
+	NestedFolders []CubeFolder    `json:"nestedFolders,omitempty"`
+	Hierarchies   []CubeHierarchy `json:"hierarchies,omitempty"`
+	// ConnectedComponent identifies the part of the join graph a cube
+	// belongs to: cubes with the same component can be queried together.
+	// nil for views and when Cube does not report it.
+	ConnectedComponent *int `json:"connectedComponent,omitempty"`
 }
 
 // CubeDimension represents a dimension in a cube
 type CubeDimension struct {
+	Name        string                 `json:"name"`
+	Title       string                 `json:"title"`
+	Type        string                 `json:"type"`
+	ShortTitle  string                 `json:"shortTitle"`
+	Description string                 `json:"description"`
+	Meta        map[string]interface{} `json:"meta,omitempty"` // custom member meta from the model
+	IsVisible   *bool                  `json:"isVisible,omitempty"`
+	Public      *bool                  `json:"public,omitempty"`
+}
+
+// CubeSegment represents a segment in a cube
+type CubeSegment struct {
 	Name        string `json:"name"`
 	Title       string `json:"title"`
-	Type        string `json:"type"`
 	ShortTitle  string `json:"shortTitle"`
 	Description string `json:"description"`
+	IsVisible   *bool  `json:"isVisible,omitempty"`
+	Public      *bool  `json:"public,omitempty"`
 }
 
 // CubeMeasure represents a measure in a cube
 type CubeMeasure struct {
-	Name        string `json:"name"`
-	Title       string `json:"title"`
-	Type        string `json:"type"`
-	ShortTitle  string `json:"shortTitle"`
-	Description string `json:"description"`
+	Name         string                 `json:"name"`
+	Title        string                 `json:"title"`
+	Type         string                 `json:"type"`
+	ShortTitle   string                 `json:"shortTitle"`
+	Description  string                 `json:"description"`
+	Meta         map[string]interface{} `json:"meta,omitempty"`         // custom member meta from the model
+	DrillMembers []string               `json:"drillMembers,omitempty"` // members listing the rows behind a value
+	AggType      string                 `json:"aggType,omitempty"`      // aggregation: count, sum, avg, countDistinct, ...
+	Cumulative   bool                   `json:"cumulative,omitempty"`   // set for rolling window measures
+	IsVisible    *bool                  `json:"isVisible,omitempty"`
+	Public       *bool                  `json:"public,omitempty"`
+	// RollingWindow is the window a cumulative measure aggregates over, when
+	// Cube reports it.
+	RollingWindow *CubeRollingWindow `json:"rollingWindow,omitempty"`
+}
+
+// CubeRollingWindow is the rolling window of a cumulative measure. Trailing
+// and Leading are intervals ("7 day") or "unbounded"; Type and Granularity
+// describe to_date windows.
+type CubeRollingWindow struct {
+	Trailing    string `json:"trailing,omitempty"`
+	Leading     string `json:"leading,omitempty"`
+	Offset      string `json:"offset,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Granularity string `json:"granularity,omitempty"`
 }
@@ -3,12 +3,15 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -26,14 +29,26 @@ var (
 	_ backend.QueryDataHandler      = (*Datasource)(nil)
 	_ backend.CheckHealthHandler    = (*Datasource)(nil)
 	_ backend.CallResourceHandler   = (*Datasource)(nil)
+	_ backend.StreamHandler         = (*Datasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*Datasource)(nil)
 )
 
 // NewDatasource creates a new datasource instance.
-func NewDatasource(_ context.Context, _ backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	return &Datasource{
-		jwtCache: make(map[string]jwtCacheEntry),
-	}, nil
+func NewDatasource(_ context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+	// Settings errors are reported per request (see buildAPIURL); here they
+	// only mean the HTTP client falls back to default transport settings.
+	state := newInstanceState(settings)
+	config := state.config
+
+	ds := &Datasource{
+		state:       state,
+		jwtCache:    make(map[string]jwtCacheEntry),
+		httpClient:  newHTTPClient(config),
+		uid:         settings.UID,
+		fingerprint: cacheFingerprint(config),
+	}
+	ds.register()
+	return ds, nil
 }
 
 // jwtCacheEntry represents a cached JWT token with its expiration time
@@ -48,10 +63,76 @@ type Datasource struct {
 	// BaseURL allows overriding the Cube API URL for testing
 	BaseURL string
 
+	// state holds the settings parsed by NewDatasource; nil for Datasource
+	// literals, which load the settings of each request.
+	state *instanceState
+
+	// httpClient is shared by every request of this instance so connections
+	// to Cube are pooled and reused. Use getHTTPClient to access it.
+	httpClient     *http.Client
+	httpClientOnce sync.Once
+
+	// meta caches the /v1/meta response for the query editor
+	meta metaCache
+
+	// deprecations caches the model's deprecated members for query warnings
+	deprecations deprecationIndex
+
+	// colors caches the model's member colors for field config
+	colors colorIndex
+
+	// schedules caches pre-aggregation refresh schedules for refresh hints
+	schedules refreshSchedules
+
+	// results caches /v1/load results when resultCacheTTL is configured
+	results resultCache
+
+	// tagValues caches tag values for AdHoc filter dropdowns
+	tagValues tagValuesCache
+
+	// cardinalities caches distinct counts of dimensions
+	cardinalities cardinalityCache
+
+	// dbSchema caches the warehouse schema for the schema browser
+	dbSchema dbSchemaCache
+
+	// inflight deduplicates identical queries running at the same time
+	inflight inflightQueries
+
+	// validators makes meta and model-file fetches conditional on the last
+	// response's ETag or Last-Modified
+	validators conditionalCache
+
 	// JWT cache keyed by API secret
 	jwtCache      map[string]jwtCacheEntry
 	jwtCacheMutex sync.RWMutex
 
+	// uid is the datasource UID, and fingerprint the cacheFingerprint of its
+	// settings. Used to hand warm caches over to the next instance after a
+	// settings change; see register.
+	uid         string
+	fingerprint string
+
+	// activeRequests counts the requests running on this instance, which
+	// Dispose lets finish.
+	activeRequests atomic.Int64
+
+	// loads tracks the Cube loads running on this instance, which Dispose
+	// cancels once the grace period is over.
+	loads activeLoads
+
+	// queries bounds the Cube queries running at once to the
+	// maxConcurrentQueries setting.
+	queries queryLimiter
+
+	// resourceRate rate limits the chatty resources; see
+	// rateLimitedResources.
+	resourceRate tokenBucket
+
+	// endpoints tracks the health of the Cube URLs of datasources with
+	// several, for failover.
+	endpoints endpointHealth
+
 	// maxNetworkRetries overrides the number of bounded retries for transient
 	// transport failures (network errors / HTTP 502) in doCubeLoadRequest.
 	// nil means use defaultNetworkErrorRetries. Set by tests for determinism.
@@ -80,30 +161,57 @@ type APIRequestContext struct {
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
 // created. As soon as datasource settings change detected by SDK old datasource instance will
 // be disposed and a new one will be created using NewSampleDatasource factory function.
+//
+// Requests already running on this instance, such as queries still polling
+// Cube with Continue wait, are given up to disposeGracePeriod to finish
+// before the connections are released, so saving the settings does not fail
+// the panels loading at that moment. Cube loads still running after that,
+// such as shared queries nobody waits for any more, are cancelled so they do
+// not keep polling Cube.
 func (d *Datasource) Dispose() {
+	d.unregister()
+	if !d.drain(disposeGracePeriod) {
+		backend.Logger.Warn("Disposing datasource instance with requests still running", "requests", d.activeRequests.Load())
+	}
+	if n := d.loads.cancelAll(); n > 0 {
+		backend.Logger.Warn("Cancelled Cube queries of disposed datasource instance", "queries", n)
+	}
+
 	// Clean up datasource instance resources.
+	if d.httpClient != nil {
+		d.httpClient.CloseIdleConnections()
+	}
 }
 
 // validateCredentials checks that the required credentials are present for the deployment type.
 // Returns an error if credentials are missing or deployment type is invalid.
 func validateCredentials(config *models.PluginSettings) error {
 	if config.DeploymentType == "" {
-		return fmt.Errorf("deployment type is required")
+		return &fieldError{field: "jsonData.deploymentType", msg: "deployment type is required"}
 	}
 
 	switch config.DeploymentType {
 	case "cloud":
 		if config.Secrets.ApiKey == "" {
-			return fmt.Errorf("API key is required for Cube Cloud deployments")
+			return &fieldError{field: "secureJsonData.apiKey", msg: "API key is required for Cube Cloud deployments"}
 		}
 	case "self-hosted":
-		if config.Secrets.ApiSecret == "" {
-			return fmt.Errorf("API secret is required for self-hosted Cube deployments")
+		switch config.JWTAlgorithm {
+		case "", models.JWTAlgorithmHS256:
+			if config.Secrets.ApiSecret == "" {
+				return &fieldError{field: "secureJsonData.apiSecret", msg: "API secret is required for self-hosted Cube deployments"}
+			}
+		case models.JWTAlgorithmRS256, models.JWTAlgorithmES256:
+			if config.Secrets.JWTPrivateKey == "" {
+				return &fieldError{field: "secureJsonData.jwtPrivateKey", msg: fmt.Sprintf("private key is required for %s JWT signing", config.JWTAlgorithm)}
+			}
+		default:
+			return &fieldError{field: "jsonData.jwtAlgorithm", msg: fmt.Sprintf("unknown JWT algorithm: %q (valid values: %s)", config.JWTAlgorithm, strings.Join(models.ValidJWTAlgorithms, ", "))}
 		}
 	case "self-hosted-dev":
 		// No credentials required for dev mode
 	default:
-		return fmt.Errorf("unknown deployment type: %s", config.DeploymentType)
+		return &fieldError{field: "jsonData.deploymentType", msg: fmt.Sprintf("unknown deployment type: %q (valid values: %s)", config.DeploymentType, strings.Join(models.ValidDeploymentTypes, ", "))}
 	}
 
 	return nil
@@ -112,32 +220,55 @@ func validateCredentials(config *models.PluginSettings) error {
 // addAuthHeaders sets the Authorization header based on the deployment type.
 // It validates that credentials are present before attempting to add headers.
 func (d *Datasource) addAuthHeaders(req *http.Request, config *models.PluginSettings) error {
+	token, err := d.authToken(config)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// authToken returns the credential sent to Cube as a Bearer token, or "" when
+// the deployment does not authenticate.
+func (d *Datasource) authToken(config *models.PluginSettings) (string, error) {
 	// Validate credentials first
 	if err := validateCredentials(config); err != nil {
-		return err
+		return "", err
 	}
 
 	switch config.DeploymentType {
 	case "cloud":
 		// Cube Cloud: Use API key as Bearer token
-		req.Header.Set("Authorization", "Bearer "+config.Secrets.ApiKey)
+		return config.Secrets.ApiKey, nil
 	case "self-hosted":
-		// Self-hosted: Generate JWT token using API secret
-		token, err := d.generateJWT(config.Secrets.ApiSecret)
+		// Self-hosted: Generate JWT token using API secret or private key
+		token, err := d.generateJWT(jwtOptionsFrom(config))
 		if err != nil {
-			return fmt.Errorf("failed to generate JWT: %w", err)
+			return "", fmt.Errorf("failed to generate JWT: %w", err)
 		}
-		req.Header.Set("Authorization", "Bearer "+token)
-	case "self-hosted-dev":
+		return token, nil
+	default:
 		// Self-hosted development mode: No authentication
-		// Do nothing
+		return "", nil
 	}
-	return nil
 }
 
 // generateJWT creates a JWT token for self-hosted Cube authentication.
-// It caches tokens until near expiration (55 minutes) to reduce signing operations.
-func (d *Datasource) generateJWT(secret string) (string, error) {
+// It caches tokens until near expiration (55 minutes of the default hour) to
+// reduce signing operations.
+//
+// The security context claims are added to the token. Cube passes the token's
+// claims to queryRewrite and the other security context hooks, so tenant
+// attributes set here drive row-level security. They cannot override exp, iat,
+// iss and aud; sub defaults to the datasource's identifier.
+func (d *Datasource) generateJWT(opts jwtOptions) (string, error) {
+	cacheKey, err := opts.cacheKey()
+	if err != nil {
+		return "", err
+	}
+
 	// Initialize cache if needed (for tests that create Datasource directly)
 	d.jwtCacheMutex.Lock()
 	if d.jwtCache == nil {
@@ -147,11 +278,11 @@ func (d *Datasource) generateJWT(secret string) (string, error) {
 
 	// Fast path: Check cache with read lock
 	d.jwtCacheMutex.RLock()
-	if cached, exists := d.jwtCache[secret]; exists {
+	if cached, exists := d.jwtCache[cacheKey]; exists {
 		// Check if token is still valid (not expired and not near expiration)
-		// Cache until 55 minutes to ensure we refresh before the 1-hour expiration
 		if time.Now().Before(cached.expiration) {
 			d.jwtCacheMutex.RUnlock()
+			jwtCacheRequestsTotal.WithLabelValues("hit").Inc()
 			return cached.token, nil
 		}
 	}
@@ -161,35 +292,43 @@ func (d *Datasource) generateJWT(secret string) (string, error) {
 	// This prevents multiple goroutines from generating tokens concurrently
 	d.jwtCacheMutex.Lock()
 	// Double-check: Another goroutine may have updated the cache while we waited for the lock
-	if cached, exists := d.jwtCache[secret]; exists {
+	if cached, exists := d.jwtCache[cacheKey]; exists {
 		if time.Now().Before(cached.expiration) {
 			d.jwtCacheMutex.Unlock()
+			jwtCacheRequestsTotal.WithLabelValues("hit").Inc()
 			return cached.token, nil
 		}
 	}
+	jwtCacheRequestsTotal.WithLabelValues("miss").Inc()
 
-	// Generate new token
-	// Create JWT claims with 1 hour expiration
-	claims := jwt.MapClaims{
-		"exp": time.Now().Add(time.Hour).Unix(),
-		"iat": time.Now().Unix(),
-		"sub": "grafana-cube-datasource", // Identifies the token issuer
+	method, key, err := d.signingKey(opts)
+	if err != nil {
+		d.jwtCacheMutex.Unlock()
+		return "", err
 	}
 
-	// Create token with HS256 signing method
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// Generate new token
+	now := time.Now()
+	claims := jwt.MapClaims{"sub": "grafana-cube-datasource"} // Identifies the token issuer
+	maps.Copy(claims, opts.SecurityContext)
+	claims["exp"] = now.Add(opts.ttl()).Unix()
+	claims["iat"] = now.Unix()
+	if opts.Issuer != "" {
+		claims["iss"] = opts.Issuer
+	}
+	if opts.Audience != "" {
+		claims["aud"] = opts.Audience
+	}
 
-	// Sign the token with the API secret
-	tokenString, err := token.SignedString([]byte(secret))
+	tokenString, err := jwt.NewWithClaims(method, claims).SignedString(key)
 	if err != nil {
 		d.jwtCacheMutex.Unlock()
 		return "", fmt.Errorf("failed to sign JWT: %w", err)
 	}
 
-	// Cache the token until 55 minutes from now
-	d.jwtCache[secret] = jwtCacheEntry{
+	d.jwtCache[cacheKey] = jwtCacheEntry{
 		token:      tokenString,
-		expiration: time.Now().Add(55 * time.Minute),
+		expiration: now.Add(opts.cacheFor()),
 	}
 	d.jwtCacheMutex.Unlock()
 
@@ -197,36 +336,72 @@ func (d *Datasource) generateJWT(secret string) (string, error) {
 }
 
 // buildAPIURL constructs a Cube API URL for the given endpoint.
-// It handles loading plugin settings, URL validation, and test overrides.
+// It handles loading plugin settings (see loadSettings), URL validation, and
+// test overrides.
 func (d *Datasource) buildAPIURL(pluginContext backend.PluginContext, endpoint string) (*APIRequestContext, error) {
 	// Load plugin settings
-	config, err := models.LoadPluginSettings(*pluginContext.DataSourceInstanceSettings)
+	config, err := d.loadSettings(*pluginContext.DataSourceInstanceSettings)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load plugin settings: %w", err)
 	}
 
-	// Get base URL with test override support
-	baseURL := config.URL
-	if d.BaseURL != "" {
-		// Override for testing
-		baseURL = d.BaseURL
+	// Validate every configured URL, failover ones included
+	if d.BaseURL == "" {
+		if err := validateCubeURLs(config.URL); err != nil {
+			return nil, err
+		}
+	}
+	// Get base URL with test override support and failover
+	baseURL := strings.TrimSpace(d.baseURL(config))
+	if err := validateCubeURL(baseURL); err != nil {
+		return nil, err
+	}
+
+	if err := d.checkTLSConfig(config); err != nil {
+		return nil, err
+	}
+
+	// Construct full API URL, handling trailing slashes properly
+	baseURL = strings.TrimRight(baseURL, "/")
+	apiURL := CubeAPIURL(baseURL + "/cubejs-api/v1/" + endpoint)
+
+	return &APIRequestContext{
+		URL:    apiURL,
+		Config: config,
+	}, nil
+}
+
+// validateCubeURLs checks every URL of a comma-separated list of Cube URLs
+// with validateCubeURL.
+func validateCubeURLs(urls string) error {
+	endpoints := (&models.PluginSettings{URL: urls}).Endpoints()
+	if len(endpoints) == 0 {
+		return validateCubeURL("")
+	}
+	for _, endpoint := range endpoints {
+		if err := validateCubeURL(endpoint); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// Trim whitespace and validate URL is configured
-	baseURL = strings.TrimSpace(baseURL)
+// validateCubeURL checks that baseURL, the configured Cube URL without
+// surrounding whitespace, is an http or https URL with a host.
+func validateCubeURL(baseURL string) error {
 	if baseURL == "" {
-		return nil, fmt.Errorf("Cube API URL is required") //nolint:staticcheck // Cube is a product name
+		return &fieldError{field: "url", msg: "Cube API URL is required"}
 	}
 
 	// Validate URL format and required components
 	parsedURL, err := url.Parse(baseURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid Cube API URL format: %w", err)
+		return &fieldError{field: "url", msg: fmt.Sprintf("invalid Cube API URL format: %v", err)}
 	}
 
 	// Ensure URL has a scheme
 	if parsedURL.Scheme == "" {
-		return nil, fmt.Errorf("invalid Cube API URL format: missing protocol scheme (http:// or https://)")
+		return &fieldError{field: "url", msg: "invalid Cube API URL format: missing protocol scheme (http:// or https://)"}
 	}
 
 	// Validate scheme is http or https (file:, ftp:, etc. are invalid for Cube API)
@@ -234,24 +409,16 @@ func (d *Datasource) buildAPIURL(pluginContext backend.PluginContext, endpoint s
 	// (e.g., "localhost:4000" gets parsed with scheme="localhost" instead of being recognized as missing scheme)
 	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
 		if !strings.Contains(baseURL, "://") {
-			return nil, fmt.Errorf("invalid Cube API URL format: missing protocol scheme (http:// or https://)")
+			return &fieldError{field: "url", msg: "invalid Cube API URL format: missing protocol scheme (http:// or https://)"}
 		}
-		return nil, fmt.Errorf("invalid Cube API URL format: invalid protocol scheme %q (must be http:// or https://)", parsedURL.Scheme)
+		return &fieldError{field: "url", msg: fmt.Sprintf("invalid Cube API URL format: invalid protocol scheme %q (must be http:// or https://)", parsedURL.Scheme)}
 	}
 
 	// Ensure URL has a host
 	if parsedURL.Host == "" {
-		return nil, fmt.Errorf("invalid Cube API URL format: missing host")
+		return &fieldError{field: "url", msg: "invalid Cube API URL format: missing host"}
 	}
-
-	// Construct full API URL, handling trailing slashes properly
-	baseURL = strings.TrimRight(baseURL, "/")
-	apiURL := CubeAPIURL(baseURL + "/cubejs-api/v1/" + endpoint)
-
-	return &APIRequestContext{
-		URL:    apiURL,
-		Config: config,
-	}, nil
+	return nil
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin.
@@ -270,6 +437,9 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 		return res, nil
 	}
 
+	ctx, cancel := withTimeout(ctx, apiReq.Config.MetaTimeoutDuration())
+	defer cancel()
+
 	// Check Cube by calling /v1/meta endpoint
 	// This endpoint is accessible by default and validates both connectivity and data model
 	metaReq, err := http.NewRequestWithContext(ctx, "GET", apiReq.URL.String(), nil)
@@ -286,8 +456,7 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 		return res, nil
 	}
 
-	client := &http.Client{}
-	metaResp, err := client.Do(metaReq)
+	metaResp, err := d.doHTTP(metaReq, apiReq.Config)
 	if err != nil {
 		res.Status = backend.HealthStatusError
 		res.Message = fmt.Sprintf("Failed to connect to Cube API: %v", err)
@@ -295,7 +464,7 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 	}
 	defer func() {
 		if err := metaResp.Body.Close(); err != nil {
-			backend.Logger.Error("Failed to close response body", "error", err)
+			backend.Logger.FromContext(ctx).Error("Failed to close response body", "error", err)
 		}
 	}()
 
@@ -307,10 +476,22 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 	}
 
 	// Check for other errors
+	body, _ := io.ReadAll(metaResp.Body)
+	contentType := metaResp.Header.Get("Content-Type")
 	if metaResp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(metaResp.Body)
 		res.Status = backend.HealthStatusError
-		res.Message = fmt.Sprintf("Cube API returned status %d: %s", metaResp.StatusCode, string(body))
+		if !isJSONBody(body) {
+			res.Message = newNonJSONResponseError(metaResp.StatusCode, contentType, body).Error()
+		} else {
+			res.Message = fmt.Sprintf("Cube API returned status %d: %s", metaResp.StatusCode, string(body))
+		}
+		return res, nil
+	}
+	// A proxy in front of Cube may answer 200 with its own page (e.g. an SSO
+	// login), which means Cube was never reached.
+	if !isJSONBody(body) {
+		res.Status = backend.HealthStatusError
+		res.Message = newNonJSONResponseError(metaResp.StatusCode, contentType, body).Error()
 		return res, nil
 	}
 
@@ -322,17 +503,33 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 
 	// Parse meta response and always nudge the user toward the Data Model tab.
 	// Tailor the hint based on whether cubes already exist.
-	body, _ := io.ReadAll(metaResp.Body)
 	var metaResponse CubeMetaResponse
-	if err := json.Unmarshal(body, &metaResponse); err == nil && len(metaResponse.Cubes) == 0 {
+	parsed := json.Unmarshal(body, &metaResponse) == nil
+	if parsed && len(metaResponse.Cubes) == 0 {
 		message += ". ℹ️ No data model found yet — visit the Data Model tab to get started"
 	} else {
 		message += ". ℹ️ Visit the Data Model tab to review or update your data model"
 	}
 
+	var meta *CubeMetaResponse
+	if parsed {
+		meta = &metaResponse
+	}
+
+	// A deep check also runs a one-row query, since /v1/meta answers without
+	// touching the warehouse.
+	if apiReq.Config.DeepHealthCheck && meta != nil {
+		if err := d.runHealthQuery(ctx, req.PluginContext, meta); err != nil && !errors.Is(err, errNoHealthQueryMember) {
+			res.Status = backend.HealthStatusError
+			res.Message = fmt.Sprintf("Connected to Cube API, but a test query failed: %v", err)
+			return res, nil
+		}
+	}
+
 	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusOk,
-		Message: message,
+		Status:      backend.HealthStatusOk,
+		Message:     message,
+		JSONDetails: d.healthCheckDetails(ctx, apiReq.Config, meta),
 	}, nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -158,7 +159,7 @@ func TestGenerateJWT(t *testing.T) {
 	ds := &Datasource{}
 	secret := "test-secret-key"
 
-	tokenString, err := ds.generateJWT(secret)
+	tokenString, err := ds.generateJWT(jwtOptions{Key: secret})
 	if err != nil {
 		t.Fatalf("generateJWT failed: %v", err)
 	}
@@ -220,7 +221,7 @@ func TestGenerateJWTCaching(t *testing.T) {
 	secret := "test-secret-key"
 
 	// First call should generate a new token
-	token1, err := ds.generateJWT(secret)
+	token1, err := ds.generateJWT(jwtOptions{Key: secret})
 	if err != nil {
 		t.Fatalf("generateJWT failed: %v", err)
 	}
@@ -229,7 +230,7 @@ func TestGenerateJWTCaching(t *testing.T) {
 	}
 
 	// Second call with same secret should return cached token
-	token2, err := ds.generateJWT(secret)
+	token2, err := ds.generateJWT(jwtOptions{Key: secret})
 	if err != nil {
 		t.Fatalf("generateJWT failed: %v", err)
 	}
@@ -239,7 +240,7 @@ func TestGenerateJWTCaching(t *testing.T) {
 
 	// Different secret should generate different token
 	secret2 := "different-secret-key"
-	token3, err := ds.generateJWT(secret2)
+	token3, err := ds.generateJWT(jwtOptions{Key: secret2})
 	if err != nil {
 		t.Fatalf("generateJWT failed: %v", err)
 	}
@@ -248,7 +249,7 @@ func TestGenerateJWTCaching(t *testing.T) {
 	}
 
 	// Same secret should still return cached token
-	token4, err := ds.generateJWT(secret)
+	token4, err := ds.generateJWT(jwtOptions{Key: secret})
 	if err != nil {
 		t.Fatalf("generateJWT failed: %v", err)
 	}
@@ -257,12 +258,54 @@ func TestGenerateJWTCaching(t *testing.T) {
 	}
 }
 
+func TestGenerateJWTSecurityContext(t *testing.T) {
+	ds := &Datasource{}
+	secret := "test-secret-key"
+	securityContext := map[string]interface{}{"tenant": "acme", "regions": []interface{}{"eu"}, "exp": 1}
+
+	tokenString, err := ds.generateJWT(jwtOptions{Key: secret, SecurityContext: securityContext})
+	if err != nil {
+		t.Fatalf("generateJWT failed: %v", err)
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}); err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if claims["tenant"] != "acme" || !reflect.DeepEqual(claims["regions"], []interface{}{"eu"}) {
+		t.Errorf("Expected the security context in the claims, got %v", claims)
+	}
+	if claims["sub"] != "grafana-cube-datasource" {
+		t.Errorf("Expected the default sub claim, got %v", claims["sub"])
+	}
+	if exp, _ := claims["exp"].(float64); time.Unix(int64(exp), 0).Before(time.Now()) {
+		t.Errorf("Expected the security context not to override exp, got %v", claims["exp"])
+	}
+
+	// Tokens are cached per security context.
+	other, err := ds.generateJWT(jwtOptions{Key: secret, SecurityContext: map[string]interface{}{"tenant": "globex"}})
+	if err != nil {
+		t.Fatalf("generateJWT failed: %v", err)
+	}
+	if other == tokenString {
+		t.Error("Expected a different token for a different security context")
+	}
+	plain, err := ds.generateJWT(jwtOptions{Key: secret})
+	if err != nil {
+		t.Fatalf("generateJWT failed: %v", err)
+	}
+	if plain == tokenString || plain == other {
+		t.Error("Expected a different token without security context")
+	}
+}
+
 func TestGenerateJWTCacheExpiration(t *testing.T) {
 	ds := &Datasource{}
 	secret := "test-secret-key"
 
 	// Generate first token
-	token1, err := ds.generateJWT(secret)
+	token1, err := ds.generateJWT(jwtOptions{Key: secret})
 	if err != nil {
 		t.Fatalf("generateJWT failed: %v", err)
 	}
@@ -289,7 +332,7 @@ func TestGenerateJWTCacheExpiration(t *testing.T) {
 	}
 
 	// Next call should generate a new token since cache expired
-	token2, err := ds.generateJWT(secret)
+	token2, err := ds.generateJWT(jwtOptions{Key: secret})
 	if err != nil {
 		t.Fatalf("generateJWT failed: %v", err)
 	}
@@ -323,7 +366,7 @@ func TestGenerateJWTConcurrentAccess(t *testing.T) {
 
 	for i := 0; i < numGoroutines; i++ {
 		go func() {
-			token, err := ds.generateJWT(secret)
+			token, err := ds.generateJWT(jwtOptions{Key: secret})
 			results <- token
 			errors <- err
 		}()
@@ -422,6 +465,24 @@ func TestCheckHealth(t *testing.T) {
 			expectedStatus: backend.HealthStatusError,
 			expectedMsg:    "API secret is required for self-hosted Cube deployments",
 		},
+		{
+			name:           "RS256 signing without private key",
+			sourceURL:      "http://localhost:4000",
+			jsonData:       `{"deploymentType": "self-hosted", "jwtAlgorithm": "rs256"}`,
+			secureJsonData: map[string]string{"apiSecret": "test-api-secret"},
+			mockServer:     false,
+			expectedStatus: backend.HealthStatusError,
+			expectedMsg:    "private key is required for RS256 JWT signing",
+		},
+		{
+			name:           "unknown JWT algorithm",
+			sourceURL:      "http://localhost:4000",
+			jsonData:       `{"deploymentType": "self-hosted", "jwtAlgorithm": "none"}`,
+			secureJsonData: map[string]string{"apiSecret": "test-api-secret"},
+			mockServer:     false,
+			expectedStatus: backend.HealthStatusError,
+			expectedMsg:    `unknown JWT algorithm: "NONE" (valid values: HS256, RS256, ES256)`,
+		},
 		{
 			name:           "unknown deployment type",
 			sourceURL:      "http://localhost:4000",
@@ -429,7 +490,16 @@ func TestCheckHealth(t *testing.T) {
 			secureJsonData: map[string]string{},
 			mockServer:     false,
 			expectedStatus: backend.HealthStatusError,
-			expectedMsg:    "unknown deployment type",
+			expectedMsg:    `unknown deployment type: "unknown" (valid values: cloud, self-hosted, self-hosted-dev)`,
+		},
+		{
+			name:           "deployment type casing and whitespace are normalized",
+			sourceURL:      "http://localhost:4000",
+			jsonData:       `{"deploymentType": " Self-Hosted "}`,
+			secureJsonData: map[string]string{},
+			mockServer:     false,
+			expectedStatus: backend.HealthStatusError,
+			expectedMsg:    "API secret is required for self-hosted Cube deployments",
 		},
 		{
 			name:           "invalid cube API URL",
@@ -523,6 +593,11 @@ func TestCheckHealth(t *testing.T) {
 
 			if tt.mockServer {
 				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					// The Cube version lookup for the health details is best effort.
+					if r.URL.Path == "/playground/context" {
+						http.NotFound(w, r)
+						return
+					}
 					if !strings.HasSuffix(r.URL.Path, "/cubejs-api/v1/meta") {
 						t.Errorf("Expected /cubejs-api/v1/meta endpoint, got %s", r.URL.Path)
 					}
@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// defaultDbSchemaCacheTTL is how long /playground/db-schema responses are
+// reused when dbSchemaCacheTTL is not configured.
+const defaultDbSchemaCacheTTL = 10 * time.Minute
+
+// dbSchemaCache holds the last /playground/db-schema response of the
+// instance. Cube introspects the whole warehouse to answer it, which takes a
+// while on big warehouses, and tables change rarely, so the schema browser
+// reuses the response until it is older than the TTL or refreshed with
+// db-schema?refresh=true. The cached response is shared and must not be
+// modified.
+type dbSchemaCache struct {
+	// mu is held during a fetch, so concurrent callers wait for the fetch in
+	// progress instead of sending their own.
+	mu        sync.Mutex
+	schema    *DbSchemaResponse
+	fetchedAt time.Time
+}
+
+// dbSchemaCacheTTL returns the configured database schema cache TTL: nil
+// means defaultDbSchemaCacheTTL, and 0 (or less) disables the cache.
+func dbSchemaCacheTTL(config *models.PluginSettings) time.Duration {
+	if config.DbSchemaCacheTTL == nil {
+		return defaultDbSchemaCacheTTL
+	}
+	if *config.DbSchemaCacheTTL <= 0 {
+		return 0
+	}
+	return time.Duration(*config.DbSchemaCacheTTL) * time.Second
+}
+
+// getCubeDbSchema returns the database schema, from the cache while it is
+// fresh unless refresh is set. Failed fetches are not cached.
+func (d *Datasource) getCubeDbSchema(ctx context.Context, pCtx backend.PluginContext, refresh bool) (*DbSchemaResponse, error) {
+	config := d.pluginSettings(pCtx)
+	if config == nil {
+		// fetchCubeDbSchema reports the settings error.
+		return d.fetchCubeDbSchema(ctx, pCtx)
+	}
+	ttl := dbSchemaCacheTTL(config)
+	if ttl == 0 {
+		return d.fetchCubeDbSchema(ctx, pCtx)
+	}
+
+	d.dbSchema.mu.Lock()
+	defer d.dbSchema.mu.Unlock()
+
+	if !refresh && d.dbSchema.schema != nil && time.Since(d.dbSchema.fetchedAt) < ttl {
+		return d.dbSchema.schema, nil
+	}
+	schema, err := d.fetchCubeDbSchema(ctx, pCtx)
+	if err != nil {
+		return nil, err
+	}
+	d.dbSchema.schema, d.dbSchema.fetchedAt = schema, time.Now()
+	return schema, nil
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestDbSchemaCacheTTL(t *testing.T) {
+	zero, ten := 0, 10
+	for _, tt := range []struct {
+		setting *int
+		want    time.Duration
+	}{
+		{setting: nil, want: defaultDbSchemaCacheTTL},
+		{setting: &zero, want: 0},
+		{setting: &ten, want: 10 * time.Second},
+	} {
+		if got := dbSchemaCacheTTL(&models.PluginSettings{DbSchemaCacheTTL: tt.setting}); got != tt.want {
+			t.Errorf("expected %v, got %v", tt.want, got)
+		}
+	}
+}
+
+func TestHandleDbSchemaCache(t *testing.T) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"tablesSchema": {"public": {"orders": []}}}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		jsonData string
+		urls     []string
+		want     int32
+	}{
+		{name: "cached", jsonData: `{"deploymentType": "self-hosted-dev"}`, urls: []string{"db-schema", "db-schema", "db-schema?refresh=false"}, want: 1},
+		{name: "refresh bypasses the cache", jsonData: `{"deploymentType": "self-hosted-dev"}`, urls: []string{"db-schema", "db-schema?refresh=true", "db-schema"}, want: 2},
+		{name: "cache disabled", jsonData: `{"deploymentType": "self-hosted-dev", "dbSchemaCacheTTL": 0}`, urls: []string{"db-schema", "db-schema"}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetches.Store(0)
+			ds := &Datasource{BaseURL: server.URL}
+			pCtx := newTestPluginContext(server.URL)
+			pCtx.DataSourceInstanceSettings.JSONData = []byte(tt.jsonData)
+			for _, u := range tt.urls {
+				resp := callHandler(t, ds.handleDbSchema, &backend.CallResourceRequest{PluginContext: pCtx, Path: "db-schema", URL: u})
+				if resp.Status != http.StatusOK {
+					t.Fatalf("%s: expected 200, got %d: %s", u, resp.Status, resp.Body)
+				}
+			}
+			if got := fetches.Load(); got != tt.want {
+				t.Errorf("expected %d fetches, got %d", tt.want, got)
+			}
+		})
+	}
+
+	ds := &Datasource{BaseURL: server.URL}
+	resp := callHandler(t, ds.handleDbSchema, &backend.CallResourceRequest{PluginContext: newTestPluginContext(server.URL), Path: "db-schema", URL: "db-schema?refresh=maybe"})
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid refresh, got %d", resp.Status)
+	}
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"encoding/json"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// defaultFilters returns the datasource's defaultFilters setting. It returns
+// nil when none are configured or the settings cannot be loaded; settings
+// errors are reported by buildAPIURL when the query is sent.
+func (d *Datasource) defaultFilters(pCtx backend.PluginContext) []map[string]interface{} {
+	if pCtx.DataSourceInstanceSettings == nil {
+		return nil
+	}
+	config, err := d.loadSettings(*pCtx.DataSourceInstanceSettings)
+	if err != nil {
+		return nil
+	}
+	return config.DefaultFilters
+}
+
+// withDefaultFilters returns the query's filters followed by the default
+// filters. Cube ANDs top-level filters, so a query can narrow the defaults
+// but never widen them. The query's filters are not modified.
+func withDefaultFilters(filters []interface{}, defaults []map[string]interface{}) []interface{} {
+	if len(defaults) == 0 {
+		return filters
+	}
+	combined := make([]interface{}, 0, len(filters)+len(defaults))
+	combined = append(combined, filters...)
+	for _, filter := range defaults {
+		combined = append(combined, filter)
+	}
+	return combined
+}
+
+// withDefaultFiltersJSON adds the default filters to a Cube query given as
+// JSON, for endpoints that pass the frontend's query through.
+func withDefaultFiltersJSON(queryJSON string, defaults []map[string]interface{}) (string, error) {
+	if len(defaults) == 0 {
+		return queryJSON, nil
+	}
+	var query map[string]interface{}
+	if err := json.Unmarshal([]byte(queryJSON), &query); err != nil {
+		return "", err
+	}
+	filters, _ := query["filters"].([]interface{})
+	query["filters"] = withDefaultFilters(filters, defaults)
+	out, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
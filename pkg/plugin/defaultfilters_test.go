@@ -0,0 +1,170 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const defaultFiltersJSONData = `{
+	"deploymentType": "self-hosted-dev",
+	"defaultFilters": [
+		{"member": "orders.tenant", "operator": "equals", "values": ["acme"]},
+		{"member": "orders.is_test", "operator": "equals", "values": ["false"]}
+	]
+}`
+
+// newDefaultFiltersContext returns a plugin context for url with the
+// defaultFilters above configured.
+func newDefaultFiltersContext(url string) backend.PluginContext {
+	pCtx := newTestPluginContext(url)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(defaultFiltersJSONData)
+	return pCtx
+}
+
+// filterMembers returns the member of each filter in a Cube query.
+func filterMembers(t *testing.T, queryJSON string) []string {
+	t.Helper()
+	var query struct {
+		Filters []struct {
+			Member string `json:"member"`
+		} `json:"filters"`
+	}
+	if err := json.Unmarshal([]byte(queryJSON), &query); err != nil {
+		t.Fatalf("invalid Cube query %s: %v", queryJSON, err)
+	}
+	members := make([]string, len(query.Filters))
+	for i, filter := range query.Filters {
+		members[i] = filter.Member
+	}
+	return members
+}
+
+func assertMembers(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected filters on %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected filters on %v, got %v", want, got)
+		}
+	}
+}
+
+// newQueryRecordingServer answers /v1/load and /v1/sql and records the last
+// Cube query it received.
+func newQueryRecordingServer(t *testing.T, lastQuery *string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		*lastQuery = r.URL.Query().Get("query")
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/cubejs-api/v1/sql" {
+			_, _ = w.Write([]byte(`{"sql": {"sql": ["SELECT 1", []]}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": [{"orders.status": "completed"}], "annotation": {}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWithDefaultFilters(t *testing.T) {
+	query := []interface{}{map[string]interface{}{"member": "orders.status"}}
+	defaults := []map[string]interface{}{{"member": "orders.tenant"}}
+
+	combined := withDefaultFilters(query, defaults)
+	if len(combined) != 2 || combined[1].(map[string]interface{})["member"] != "orders.tenant" {
+		t.Errorf("expected the default filter after the query's, got %v", combined)
+	}
+	if len(query) != 1 {
+		t.Errorf("expected the query's filters not to be modified, got %v", query)
+	}
+	if got := withDefaultFilters(query, nil); len(got) != 1 {
+		t.Errorf("expected no defaults to leave the filters alone, got %v", got)
+	}
+}
+
+func TestQueryDataAddsDefaultFilters(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{
+			name:  "appended after the query's filters",
+			query: `{"refId": "A", "dimensions": ["orders.status"], "filters": [{"member": "orders.status", "operator": "set"}]}`,
+			want:  []string{"orders.status", "orders.tenant", "orders.is_test"},
+		},
+		{
+			name:  "query without filters",
+			query: `{"refId": "A", "dimensions": ["orders.status"]}`,
+			want:  []string{"orders.tenant", "orders.is_test"},
+		},
+		{
+			name:  "query opted out",
+			query: `{"refId": "A", "dimensions": ["orders.status"], "ignoreDefaultFilters": true}`,
+			want:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lastQuery string
+			server := newQueryRecordingServer(t, &lastQuery)
+			ds := &Datasource{BaseURL: server.URL}
+
+			resp := runSingleQuery(t, ds, newDefaultFiltersContext(server.URL), tt.query)
+			if resp.Error != nil {
+				t.Fatalf("unexpected error: %v", resp.Error)
+			}
+			assertMembers(t, filterMembers(t, lastQuery), tt.want...)
+		})
+	}
+}
+
+func TestHandleTagValuesAddsDefaultFilters(t *testing.T) {
+	var lastQuery string
+	server := newQueryRecordingServer(t, &lastQuery)
+	ds := &Datasource{BaseURL: server.URL}
+
+	scoping := url.QueryEscape(`[{"member": "orders.customer", "operator": "equals", "values": ["x"]}]`)
+	resp := callHandler(t, ds.handleTagValues, &backend.CallResourceRequest{
+		Path:          "tag-values",
+		Method:        "GET",
+		URL:           "/tag-values?key=orders.status&filters=" + scoping,
+		PluginContext: newDefaultFiltersContext(server.URL),
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Status, resp.Body)
+	}
+	assertMembers(t, filterMembers(t, lastQuery), "orders.customer", "orders.tenant", "orders.is_test")
+}
+
+func TestHandleSQLCompilationAddsDefaultFilters(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{query: `{"measures": ["orders.count"]}`, want: []string{"orders.tenant", "orders.is_test"}},
+		{query: `{"measures": ["orders.count"], "ignoreDefaultFilters": true}`, want: []string{}},
+	} {
+		var lastQuery string
+		server := newQueryRecordingServer(t, &lastQuery)
+		ds := &Datasource{BaseURL: server.URL}
+
+		resp := callHandler(t, ds.handleSQLCompilation, &backend.CallResourceRequest{
+			Path:          "sql",
+			Method:        "GET",
+			URL:           "/sql?query=" + url.QueryEscape(tt.query),
+			PluginContext: newDefaultFiltersContext(server.URL),
+		})
+		if resp.Status != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", resp.Status, resp.Body)
+		}
+		assertMembers(t, filterMembers(t, lastQuery), tt.want...)
+	}
+}
@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// memberDeprecation describes a member whose meta has "deprecated": true.
+type memberDeprecation struct {
+	// ReplacedBy is the member to use instead, from meta.replacedBy. Optional.
+	ReplacedBy string
+}
+
+// deprecationIndex caches the deprecated members of the Cube model, built
+// from the metadata response meta.
+type deprecationIndex struct {
+	mu      sync.Mutex
+	meta    *CubeMetaResponse
+	members map[string]memberDeprecation
+}
+
+// deprecatedMembers extracts the members marked deprecated in their meta.
+// Model owners mark a member as
+//
+//	meta: { deprecated: true, replacedBy: "orders.total" }
+func deprecatedMembers(meta *CubeMetaResponse) map[string]memberDeprecation {
+	members := make(map[string]memberDeprecation)
+	add := func(name string, memberMeta map[string]interface{}) {
+		if deprecated, _ := memberMeta["deprecated"].(bool); !deprecated {
+			return
+		}
+		replacedBy, _ := memberMeta["replacedBy"].(string)
+		members[name] = memberDeprecation{ReplacedBy: replacedBy}
+	}
+	for _, cube := range meta.Cubes {
+		for _, dim := range cube.Dimensions {
+			add(dim.Name, dim.Meta)
+		}
+		for _, measure := range cube.Measures {
+			add(measure.Name, measure.Meta)
+		}
+	}
+	return members
+}
+
+// getDeprecatedMembers returns the deprecated members of the model, from the
+// metadata cache. The index is rebuilt when the cached metadata changes.
+// Deprecation warnings are best effort: without metadata there are none.
+func (d *Datasource) getDeprecatedMembers(ctx context.Context, pCtx backend.PluginContext) map[string]memberDeprecation {
+	meta, err := d.getCubeMetadata(ctx, pCtx)
+	if err != nil {
+		if ctx.Err() == nil {
+			backend.Logger.FromContext(ctx).Warn("Failed to fetch metadata for deprecation warnings", "error", err)
+		}
+		return nil
+	}
+
+	d.deprecations.mu.Lock()
+	defer d.deprecations.mu.Unlock()
+	if d.deprecations.meta != meta {
+		d.deprecations.meta, d.deprecations.members = meta, deprecatedMembers(meta)
+	}
+	return d.deprecations.members
+}
+
+// queryMembers returns every member referenced by the query: measures,
+// dimensions, time dimensions, filter members (including nested and/or
+// groups) and order keys.
+func queryMembers(query CubeQuery) []string {
+	seen := make(map[string]bool)
+	var members []string
+	add := func(member string) {
+		if member != "" && !seen[member] {
+			seen[member] = true
+			members = append(members, member)
+		}
+	}
+
+	for _, m := range query.Measures {
+		add(m)
+	}
+	for _, m := range query.Dimensions {
+		add(m)
+	}
+	for _, td := range query.TimeDimensions {
+		if obj, ok := td.(map[string]interface{}); ok {
+			member, _ := obj["dimension"].(string)
+			add(member)
+		}
+	}
+
+	var walkFilters func(filters []interface{})
+	walkFilters = func(filters []interface{}) {
+		for _, f := range filters {
+			obj, ok := f.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, key := range []string{"member", "dimension"} {
+				member, _ := obj[key].(string)
+				add(member)
+			}
+			for _, key := range []string{"and", "or"} {
+				if group, ok := obj[key].([]interface{}); ok {
+					walkFilters(group)
+				}
+			}
+		}
+	}
+	walkFilters(query.Filters)
+
+	switch order := query.Order.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(order))
+		for member := range order {
+			keys = append(keys, member)
+		}
+		sort.Strings(keys)
+		for _, member := range keys {
+			add(member)
+		}
+	case []interface{}:
+		for _, entry := range order {
+			if pair, ok := entry.([]interface{}); ok && len(pair) > 0 {
+				member, _ := pair[0].(string)
+				add(member)
+			}
+		}
+	}
+
+	return members
+}
+
+// deprecationNotices returns a warning for every deprecated member the query
+// uses, in query order.
+func deprecationNotices(query CubeQuery, deprecated map[string]memberDeprecation) []data.Notice {
+	if len(deprecated) == 0 {
+		return nil
+	}
+	var notices []data.Notice
+	for _, member := range queryMembers(query) {
+		deprecation, ok := deprecated[member]
+		if !ok {
+			continue
+		}
+		text := fmt.Sprintf("%s is deprecated", member)
+		if deprecation.ReplacedBy != "" {
+			text = fmt.Sprintf("%s, use %s", text, deprecation.ReplacedBy)
+		}
+		notices = append(notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: text})
+	}
+	return notices
+}
+
+// addDeprecationNotices attaches deprecation warnings for the query's members
+// to the frames of a successful response. The query has already run; the
+// warnings only steer dashboard authors towards the replacement members.
+func (d *Datasource) addDeprecationNotices(ctx context.Context, pCtx backend.PluginContext, prepared *preparedQuery, response backend.DataResponse) backend.DataResponse {
+	if response.Error != nil || len(response.Frames) == 0 {
+		return response
+	}
+	notices := deprecationNotices(prepared.query, d.getDeprecatedMembers(ctx, pCtx))
+	if len(notices) == 0 {
+		return response
+	}
+	for _, frame := range response.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Notices = append(frame.Meta.Notices, notices...)
+	}
+	return response
+}
@@ -0,0 +1,149 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestQueryMembers(t *testing.T) {
+	query := CubeQuery{
+		Measures:   []string{"orders.count"},
+		Dimensions: []string{"orders.status", "orders.count"},
+		TimeDimensions: []interface{}{
+			map[string]interface{}{"dimension": "orders.created_at", "granularity": "day"},
+		},
+		Filters: []interface{}{
+			map[string]interface{}{"member": "orders.region", "operator": "equals", "values": []interface{}{"EU"}},
+			map[string]interface{}{"or": []interface{}{
+				map[string]interface{}{"member": "orders.old_total", "operator": "gt", "values": []interface{}{"10"}},
+				map[string]interface{}{"dimension": "orders.legacy_status", "operator": "set"},
+			}},
+		},
+		Order: []interface{}{[]interface{}{"orders.priority", "desc"}},
+	}
+
+	want := []string{
+		"orders.count", "orders.status", "orders.created_at", "orders.region",
+		"orders.old_total", "orders.legacy_status", "orders.priority",
+	}
+	if got := queryMembers(query); !reflect.DeepEqual(got, want) {
+		t.Errorf("queryMembers() = %v, want %v", got, want)
+	}
+}
+
+func TestDeprecatedMembers(t *testing.T) {
+	meta := &CubeMetaResponse{Cubes: []CubeMeta{{
+		Name: "orders",
+		Measures: []CubeMeasure{
+			{Name: "orders.old_total", Meta: map[string]interface{}{"deprecated": true, "replacedBy": "orders.total"}},
+			{Name: "orders.total"},
+		},
+		Dimensions: []CubeDimension{
+			{Name: "orders.legacy_status", Meta: map[string]interface{}{"deprecated": true}},
+			{Name: "orders.status", Meta: map[string]interface{}{"deprecated": "soon"}},
+		},
+	}}}
+
+	want := map[string]memberDeprecation{
+		"orders.old_total":     {ReplacedBy: "orders.total"},
+		"orders.legacy_status": {},
+	}
+	if got := deprecatedMembers(meta); !reflect.DeepEqual(got, want) {
+		t.Errorf("deprecatedMembers() = %v, want %v", got, want)
+	}
+}
+
+func TestQueryDataDeprecatedMemberWarnings(t *testing.T) {
+	var metaRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+			metaRequests.Add(1)
+			_, _ = w.Write([]byte(`{"cubes": [{"name": "orders", "type": "cube",
+				"measures": [{"name": "orders.old_total", "type": "number", "meta": {"deprecated": true, "replacedBy": "orders.total"}}],
+				"dimensions": [{"name": "orders.status", "type": "string"}]}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": [{"orders.status": "completed", "orders.old_total": "10"}],
+			"annotation": {"measures": {"orders.old_total": {"type": "number"}}, "dimensions": {"orders.status": {"type": "string"}}}}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+
+	for i := 0; i < 2; i++ {
+		res := runSingleQuery(t, ds, pCtx, `{"refId": "A", "measures": ["orders.old_total"], "dimensions": ["orders.status"]}`)
+		if res.Error != nil {
+			t.Fatalf("query failed: %v", res.Error)
+		}
+		if len(res.Frames) != 1 || res.Frames[0].Rows() != 1 {
+			t.Fatalf("expected the deprecated member to still be queried")
+		}
+		meta := res.Frames[0].Meta
+		if meta == nil || len(meta.Notices) != 1 {
+			t.Fatalf("expected 1 notice, got %+v", meta)
+		}
+		notice := meta.Notices[0]
+		if notice.Severity != data.NoticeSeverityWarning || notice.Text != "orders.old_total is deprecated, use orders.total" {
+			t.Errorf("unexpected notice %+v", notice)
+		}
+	}
+
+	if got := metaRequests.Load(); got != 1 {
+		t.Errorf("expected the model to be fetched once and reused, got %d meta requests", got)
+	}
+}
+
+func TestQueryDataMetaFailureDoesNotFailQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [{"orders.count": "5"}], "annotation": {"measures": {"orders.count": {"type": "number"}}}}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	res := runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId": "A", "measures": ["orders.count"]}`)
+	if res.Error != nil {
+		t.Fatalf("expected the query to succeed without deprecation info, got %v", res.Error)
+	}
+	if meta := res.Frames[0].Meta; meta != nil && len(meta.Notices) > 0 {
+		t.Errorf("expected no notices, got %+v", meta.Notices)
+	}
+}
+
+func TestDeprecatedMembersFollowMetadataCache(t *testing.T) {
+	var deprecated atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"cubes": [{"name": "orders", "type": "cube",
+			"measures": [{"name": "orders.old_total", "type": "number", "meta": {"deprecated": %t}}]}]}`, deprecated.Load())
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+	if got := ds.getDeprecatedMembers(context.Background(), pCtx); len(got) != 0 {
+		t.Fatalf("expected no deprecated members, got %v", got)
+	}
+	deprecated.Store(true)
+	if got := ds.getDeprecatedMembers(context.Background(), pCtx); len(got) != 0 {
+		t.Errorf("expected the cached metadata to be used, got %v", got)
+	}
+	ds.invalidateMetadata()
+	if got := ds.getDeprecatedMembers(context.Background(), pCtx); len(got) != 1 {
+		t.Errorf("expected the refreshed metadata to be used, got %v", got)
+	}
+}
@@ -0,0 +1,177 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// slowLoadThreshold and slowLoadFactor decide when the diagnostics warn that
+// /v1/load is slow compared to /v1/meta: Cube itself answers quickly, so the
+// time goes to the warehouse or to building pre-aggregations.
+const (
+	slowLoadThreshold = time.Second
+	slowLoadFactor    = 5
+)
+
+// diagnosticProbe is the outcome of one timed request to Cube.
+type diagnosticProbe struct {
+	Endpoint string `json:"endpoint"`
+	// Status is "ok", "error", or "skipped" when the probe could not run.
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// diagnosticsResponse is the body of the diagnostics resource.
+type diagnosticsResponse struct {
+	Probes   []diagnosticProbe `json:"probes"`
+	Warnings []string          `json:"warnings,omitempty"`
+	// Endpoints is the health of each Cube URL, when the datasource has
+	// several.
+	Endpoints []endpointStatus `json:"endpoints,omitempty"`
+}
+
+// probeMember returns a member of the model to run the load and sql probes
+// with: the first measure of the first view with one, else its first
+// dimension, falling back to cubes when the model has no views.
+func probeMember(meta *CubeMetaResponse) (string, bool) {
+	for _, views := range []bool{true, false} {
+		for _, item := range meta.Cubes {
+			if (item.Type == "view") != views {
+				continue
+			}
+			if len(item.Measures) > 0 {
+				return item.Measures[0].Name, true
+			}
+			if len(item.Dimensions) > 0 {
+				return item.Dimensions[0].Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// probeQuery returns the smallest query that reads member.
+func probeQuery(member string, meta *CubeMetaResponse) []byte {
+	field := "dimensions"
+	for _, item := range meta.Cubes {
+		for _, measure := range item.Measures {
+			if measure.Name == member {
+				field = "measures"
+			}
+		}
+	}
+	query, _ := json.Marshal(map[string]interface{}{field: []string{member}, "limit": 1})
+	return query
+}
+
+// timeProbe runs fn and records its latency and outcome.
+func timeProbe(endpoint string, fn func() error) diagnosticProbe {
+	start := time.Now()
+	err := fn()
+	probe := diagnosticProbe{Endpoint: endpoint, Status: healthStepOK, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		probe.Status, probe.Error = healthStepError, err.Error()
+	}
+	return probe
+}
+
+// runDiagnostics times a /v1/meta request, then a one-row /v1/load query and
+// a /v1/sql compilation of a member from the model. Each request is sent to
+// Cube, bypassing the plugin's caches.
+func (d *Datasource) runDiagnostics(ctx context.Context, pCtx backend.PluginContext) diagnosticsResponse {
+	var res diagnosticsResponse
+
+	var meta *CubeMetaResponse
+	metaProbe := timeProbe("meta", func() error {
+		var err error
+		meta, err = d.fetchCubeMetadata(ctx, pCtx)
+		return err
+	})
+	res.Probes = append(res.Probes, metaProbe)
+
+	member, ok := "", false
+	if metaProbe.Status == healthStepOK {
+		member, ok = probeMember(meta)
+	}
+	if !ok {
+		skipped := "the data model has no members to query"
+		if metaProbe.Status != healthStepOK {
+			skipped = "the meta probe failed"
+		}
+		for _, endpoint := range []string{"load", "sql"} {
+			res.Probes = append(res.Probes, diagnosticProbe{Endpoint: endpoint, Status: healthStepSkipped, Error: skipped})
+		}
+		return res
+	}
+	query := probeQuery(member, meta)
+
+	loadProbe := timeProbe("load", func() error {
+		apiReq, err := d.buildAPIURL(pCtx, "load")
+		if err != nil {
+			return err
+		}
+		body, err := d.doCubeLoadRequest(ctx, apiReq.URL.String(), query, apiReq.Config)
+		if err != nil {
+			return err
+		}
+		_, err = decodeLoadResult(body)
+		return err
+	})
+	res.Probes = append(res.Probes, loadProbe)
+
+	res.Probes = append(res.Probes, timeProbe("sql", func() error {
+		_, err := d.fetchCubeSQL(ctx, pCtx, string(query))
+		return err
+	}))
+
+	if warning := slowLoadWarning(metaProbe, loadProbe, member); warning != "" {
+		res.Warnings = append(res.Warnings, warning)
+	}
+	return res
+}
+
+// slowLoadWarning returns a warning when the load probe was slow compared to
+// the meta probe, or "".
+func slowLoadWarning(metaProbe, loadProbe diagnosticProbe, member string) string {
+	loadLatency := time.Duration(loadProbe.LatencyMs) * time.Millisecond
+	metaLatency := time.Duration(metaProbe.LatencyMs) * time.Millisecond
+	if loadProbe.Status != healthStepOK || loadLatency < slowLoadThreshold || loadLatency < slowLoadFactor*metaLatency {
+		return ""
+	}
+	return fmt.Sprintf(
+		"Cube answered /v1/meta in %dms but a one-row /v1/load query on %s took %dms: the time is spent in the warehouse or building pre-aggregations, not in reaching Cube",
+		metaProbe.LatencyMs, member, loadProbe.LatencyMs)
+}
+
+// handleDiagnostics runs timed probes against Cube so the configuration page
+// can show where the time of a query goes. Failures are reported per probe in
+// a 200 response.
+func (d *Datasource) handleDiagnostics(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if _, err := d.buildAPIURL(req.PluginContext, "meta"); err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+
+	res := d.runDiagnostics(ctx, req.PluginContext)
+	if endpoints := d.pluginSettings(req.PluginContext).Endpoints(); len(endpoints) > 1 {
+		res.Endpoints = d.endpoints.statuses(endpoints, time.Now())
+	}
+	body, err := json.Marshal(res)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal diagnostics response", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   body,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHandleDiagnostics(t *testing.T) {
+	var loadQuery, sqlQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/cubejs-api/v1/meta":
+			_, _ = w.Write([]byte(`{"cubes": [
+				{"name": "orders", "type": "cube", "measures": [{"name": "orders.count"}]},
+				{"name": "orders_view", "type": "view", "dimensions": [{"name": "orders_view.status"}], "measures": [{"name": "orders_view.count"}]}
+			]}`))
+		case "/cubejs-api/v1/load":
+			loadQuery = r.URL.Query().Get("query")
+			_, _ = w.Write([]byte(`{"data": [{"orders_view.count": "3"}], "annotation": {}}`))
+		case "/cubejs-api/v1/sql":
+			sqlQuery = r.URL.Query().Get("query")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "SQL compilation failed"}`))
+		}
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	resp := callHandler(t, ds.CallResource, &backend.CallResourceRequest{
+		Path:          "diagnostics",
+		Method:        "GET",
+		PluginContext: newTestPluginContext(server.URL),
+	})
+	if resp.Status != 200 {
+		t.Fatalf("expected status 200, got %d: %s", resp.Status, resp.Body)
+	}
+	var got diagnosticsResponse
+	if err := json.Unmarshal(resp.Body, &got); err != nil {
+		t.Fatalf("invalid response %s: %v", resp.Body, err)
+	}
+
+	want := []struct{ endpoint, status string }{{"meta", "ok"}, {"load", "ok"}, {"sql", "error"}}
+	if len(got.Probes) != len(want) {
+		t.Fatalf("expected %d probes, got %+v", len(want), got.Probes)
+	}
+	for i, w := range want {
+		if got.Probes[i].Endpoint != w.endpoint || got.Probes[i].Status != w.status {
+			t.Errorf("probe %d: expected %s %s, got %+v", i, w.endpoint, w.status, got.Probes[i])
+		}
+	}
+	if got.Probes[2].Error == "" {
+		t.Errorf("expected the sql probe error to be reported")
+	}
+	const wantQuery = `{"limit":1,"measures":["orders_view.count"]}`
+	if loadQuery != wantQuery || sqlQuery != wantQuery {
+		t.Errorf("expected the probes to query %s, got load %s and sql %s", wantQuery, loadQuery, sqlQuery)
+	}
+}
+
+func TestHandleDiagnosticsMetaFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cubejs-api/v1/meta" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error": "Compile errors"}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	resp := callHandler(t, ds.handleDiagnostics, &backend.CallResourceRequest{
+		Path:          "diagnostics",
+		PluginContext: newTestPluginContext(server.URL),
+	})
+	var got diagnosticsResponse
+	if err := json.Unmarshal(resp.Body, &got); err != nil {
+		t.Fatalf("invalid response %s: %v", resp.Body, err)
+	}
+	if len(got.Probes) != 3 || got.Probes[0].Status != "error" || got.Probes[1].Status != "skipped" || got.Probes[2].Status != "skipped" {
+		t.Errorf("expected the meta probe to fail and the others to be skipped, got %+v", got.Probes)
+	}
+}
+
+func TestSlowLoadWarning(t *testing.T) {
+	tests := []struct {
+		name        string
+		meta, load  int64
+		loadStatus  string
+		wantWarning bool
+	}{
+		{name: "slow load, fast meta", meta: 40, load: 3000, loadStatus: "ok", wantWarning: true},
+		{name: "fast load", meta: 40, load: 200, loadStatus: "ok"},
+		{name: "everything slow", meta: 900, load: 2000, loadStatus: "ok"},
+		{name: "failed load", meta: 40, load: 3000, loadStatus: "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warning := slowLoadWarning(
+				diagnosticProbe{Endpoint: "meta", Status: "ok", LatencyMs: tt.meta},
+				diagnosticProbe{Endpoint: "load", Status: tt.loadStatus, LatencyMs: tt.load},
+				"orders.count")
+			if (warning != "") != tt.wantWarning {
+				t.Errorf("expected warning %v, got %q", tt.wantWarning, warning)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// DryRunResponse is the response of the dry-run resource: whether Cube
+// accepts the query and, when it does, the query as Cube normalized it.
+type DryRunResponse struct {
+	Valid bool `json:"valid"`
+	// Error is Cube's validation error when the query is invalid.
+	Error string `json:"error,omitempty"`
+	// QueryType is Cube's classification of the query ("regularQuery",
+	// "compareDateRangeQuery" or "blendingQuery").
+	QueryType string `json:"queryType,omitempty"`
+	// NormalizedQueries are the queries Cube will run, with defaults filled
+	// in and members resolved.
+	NormalizedQueries []json.RawMessage `json:"normalizedQueries,omitempty"`
+	// QueryOrder is the order Cube applies, as [{member: direction}, ...].
+	QueryOrder json.RawMessage `json:"queryOrder,omitempty"`
+}
+
+// handleDryRun validates a query with Cube's /v1/dry-run endpoint without
+// running it against the warehouse. The query is validated as it will run,
+// with the default filters, segment filters and rewrite rules applied. An invalid query is
+// not an error of the resource: the response reports it with valid=false.
+func (d *Datasource) handleDryRun(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
+	}
+	queryParam := parsedURL.Query().Get("query")
+	if queryParam == "" {
+		return sender.Send(jsonErrorResponse(400, errors.New("query parameter is required")))
+	}
+	var cubeQuery CubeQuery
+	if err := json.Unmarshal([]byte(queryParam), &cubeQuery); err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid query JSON")))
+	}
+	if !cubeQuery.IgnoreDefaultFilters {
+		if queryParam, err = withDefaultFiltersJSON(queryParam, d.defaultFilters(req.PluginContext)); err != nil {
+			return sender.Send(jsonErrorResponse(400, errors.New("invalid query JSON")))
+		}
+	}
+	if queryParam, err = withSegmentFiltersJSON(queryParam); err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	config := d.pluginSettings(req.PluginContext)
+	if err := checkAllowedQueryJSON(config, queryParam); err != nil {
+		return sender.Send(jsonErrorResponse(403, err))
+	}
+	queryJSON, err := rewriteQueryJSON([]byte(queryParam), req.PluginContext, config)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+
+	result, err := d.fetchCubeDryRun(ctx, req.PluginContext, string(queryJSON))
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to dry-run query with Cube", "error", err)
+		return sender.Send(cubeLoadErrorResponse(err))
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal dry-run response", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   body,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
+
+// fetchCubeDryRun sends a query to Cube's /v1/dry-run endpoint. Cube rejects
+// invalid queries with a 400 and an {"error": ...} body; that is returned as
+// an invalid DryRunResponse rather than an error.
+func (d *Datasource) fetchCubeDryRun(ctx context.Context, pluginContext backend.PluginContext, query string) (*DryRunResponse, error) {
+	apiReq, err := d.buildAPIURL(pluginContext, "dry-run")
+	if err != nil {
+		return nil, fmt.Errorf("failed to build API URL: %w", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, apiReq.Config.QueryTimeoutDuration())
+	defer cancel()
+
+	u, err := url.Parse(apiReq.URL.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse API URL: %w", err)
+	}
+	u.RawQuery = url.Values{"query": {query}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := d.addAuthHeaders(req, apiReq.Config); err != nil {
+		return nil, fmt.Errorf("failed to add auth headers: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.doHTTP(req, apiReq.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make API request: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			backend.Logger.FromContext(ctx).Warn("Failed to close response body", "error", err)
+		}
+	}()
+
+	body, err := readJSONResponse(resp)
+	if err != nil {
+		var cubeErr *CubeAPIError
+		if errors.As(err, &cubeErr) && cubeErr.StatusCode == http.StatusBadRequest {
+			var errBody struct {
+				Error string `json:"error"`
+			}
+			if json.Unmarshal(cubeErr.Body, &errBody) == nil && errBody.Error != "" {
+				return &DryRunResponse{Error: errBody.Error}, nil
+			}
+		}
+		return nil, err
+	}
+
+	result := &DryRunResponse{}
+	if err := json.Unmarshal(body, result); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+	result.Valid = true
+	return result, nil
+}
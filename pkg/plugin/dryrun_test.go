@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHandleDryRun(t *testing.T) {
+	var gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cubejs-api/v1/dry-run" {
+			t.Errorf("expected path /cubejs-api/v1/dry-run, got %s", r.URL.Path)
+		}
+		gotQuery = r.URL.Query().Get("query")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"queryType": "regularQuery",
+			"normalizedQueries": [{"measures": ["orders.count"], "segments": ["orders.completed"], "timezone": "UTC"}],
+			"queryOrder": [{"orders.count": "desc"}],
+			"pivotQuery": {}}`))
+	}))
+	defer server.Close()
+
+	query := `{"measures":["orders.count"],"filters":[{"member":"segment:orders.completed","operator":"equals","values":["true"]}]}`
+	resp := callHandler(t, (&Datasource{}).handleDryRun, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "dry-run?query=" + url.QueryEscape(query),
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+	if !strings.Contains(gotQuery, `"segments":["orders.completed"]`) {
+		t.Errorf("expected segment filters to be sent as segments, got %s", gotQuery)
+	}
+
+	var result DryRunResponse
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if !result.Valid || result.QueryType != "regularQuery" || len(result.NormalizedQueries) != 1 {
+		t.Errorf("expected a valid regular query, got %+v", result)
+	}
+	if string(result.QueryOrder) != `[{"orders.count":"desc"}]` {
+		t.Errorf("expected Cube's query order, got %s", result.QueryOrder)
+	}
+}
+
+func TestHandleDryRunInvalidQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": "Error: Query should contain either measures, dimensions or timeDimensions with granularities in order to be valid"}`))
+	}))
+	defer server.Close()
+
+	resp := callHandler(t, (&Datasource{}).handleDryRun, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "dry-run?query=" + url.QueryEscape(`{"measures":[]}`),
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+	var result DryRunResponse
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if result.Valid || !strings.Contains(result.Error, "Query should contain") {
+		t.Errorf("expected Cube's validation error, got %+v", result)
+	}
+}
+
+func TestHandleDryRunForwardsCubeErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error": "Invalid token"}`))
+	}))
+	defer server.Close()
+
+	resp := callHandler(t, (&Datasource{}).handleDryRun, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "dry-run?query=" + url.QueryEscape(`{"measures":["orders.count"]}`),
+	})
+	if resp.Status != http.StatusForbidden {
+		t.Errorf("expected Cube's 403 to be forwarded, got %d: %s", resp.Status, resp.Body)
+	}
+}
+
+func TestHandleDryRunMissingQuery(t *testing.T) {
+	resp := callHandler(t, (&Datasource{}).handleDryRun, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext("http://unused"),
+		URL:           "dry-run",
+	})
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", resp.Status)
+	}
+}
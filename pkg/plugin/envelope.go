@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// continueWaitError is the error Cube answers with while a query is still
+// being computed.
+const continueWaitError = "Continue wait"
+
+// cubeEnvelope is the top level of a /v1/load response body, received over
+// HTTP or as a WebSocket message. Cube answers with one of:
+//
+//	{"error": "Continue wait", "stage": "...", "timeElapsed": 3}  // still computing
+//	{"error": "..."}                                            // query error
+//	{"queryType": "multi", "results": [<result>, ...]}          // queryType=multi
+//	{"data": [{...}, ...], "annotation": {...}}                 // result
+//	{"data": {"members": [...], "dataset": [[...]]}, ...}       // responseFormat=compact
+//
+// Every /v1/load body goes through decodeCubeEnvelope so the variants are
+// told apart in one place.
+type cubeEnvelope struct {
+	Error       string            `json:"error"`
+	Stage       string            `json:"stage"`
+	TimeElapsed float64           `json:"timeElapsed"`
+	QueryType   string            `json:"queryType"`
+	Results     []json.RawMessage `json:"results"`
+	Data        json.RawMessage   `json:"data"`
+	Annotation  CubeAnnotation    `json:"annotation"`
+
+	UsedPreAggregations map[string]usedPreAggregation `json:"usedPreAggregations"`
+
+	RequestID string `json:"requestId"`
+	DBType    string `json:"dbType"`
+	External  *bool  `json:"external"`
+	SlowQuery bool   `json:"slowQuery"`
+	Total     *int64 `json:"total"`
+}
+
+// compactData is the "data" of a result in Cube's compact response format:
+// the member names once, then one array of values per row.
+type compactData struct {
+	Members []string        `json:"members"`
+	Dataset [][]interface{} `json:"dataset"`
+}
+
+// decodeCubeEnvelope decodes the top level of a /v1/load response body. The
+// rows are only decoded by result and results.
+func decodeCubeEnvelope(body []byte) (*cubeEnvelope, error) {
+	var envelope cubeEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, err
+	}
+	return &envelope, nil
+}
+
+// isContinueWait reports whether Cube is still computing the result and the
+// request has to be repeated.
+func (e *cubeEnvelope) isContinueWait() bool {
+	return e.Error == continueWaitError
+}
+
+// progress returns the stage and timeElapsed of a Continue-wait response.
+// Both are zero when Cube did not report them.
+func (e *cubeEnvelope) progress() continueWaitProgress {
+	return continueWaitProgress{Stage: e.Stage, TimeElapsed: e.TimeElapsed}
+}
+
+// err returns the error Cube reported in a successful (HTTP 200) response, or
+// nil. Continue wait is not an error.
+func (e *cubeEnvelope) err() error {
+	if e.Error == "" || e.isContinueWait() {
+		return nil
+	}
+	return &loadRequestError{status: backend.StatusBadRequest, msg: fmt.Sprintf("Cube returned an error: %s", e.Error)}
+}
+
+// result decodes a single-query result. Rows in the compact format are
+// expanded into the same member -> value maps as the default format.
+func (e *cubeEnvelope) result() (CubeAPIResponse, error) {
+	if err := e.err(); err != nil {
+		return CubeAPIResponse{}, err
+	}
+	if e.isContinueWait() {
+		return CubeAPIResponse{}, fmt.Errorf("result is not ready (Continue wait)")
+	}
+	if e.Results != nil {
+		return CubeAPIResponse{}, fmt.Errorf("expected a single result, got %d results", len(e.Results))
+	}
+
+	rows, err := decodeRows(e.Data)
+	if err != nil {
+		return CubeAPIResponse{}, err
+	}
+	return CubeAPIResponse{
+		Data:                rows,
+		Annotation:          e.Annotation,
+		UsedPreAggregations: e.UsedPreAggregations,
+		RequestID:           e.RequestID,
+		DBType:              e.DBType,
+		External:            e.External,
+		SlowQuery:           e.SlowQuery,
+		Total:               e.Total,
+	}, nil
+}
+
+// results decodes the results of a queryType=multi response, in query order.
+func (e *cubeEnvelope) results() ([]CubeAPIResponse, error) {
+	if err := e.err(); err != nil {
+		return nil, err
+	}
+	if e.Results == nil {
+		return nil, fmt.Errorf("expected a multi-query response with results")
+	}
+
+	results := make([]CubeAPIResponse, len(e.Results))
+	for i, raw := range e.Results {
+		envelope, err := decodeCubeEnvelope(raw)
+		if err != nil {
+			return nil, fmt.Errorf("result %d: %w", i, err)
+		}
+		if results[i], err = envelope.result(); err != nil {
+			return nil, fmt.Errorf("result %d: %w", i, err)
+		}
+	}
+	return results, nil
+}
+
+// decodeRows decodes the "data" of a result in either the default format (an
+// array of objects) or the compact format.
+func decodeRows(raw json.RawMessage) ([]map[string]interface{}, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil, nil
+	}
+
+	if trimmed[0] != '{' {
+		var rows []map[string]interface{}
+		if err := json.Unmarshal(trimmed, &rows); err != nil {
+			return nil, fmt.Errorf("invalid data: %w", err)
+		}
+		return rows, nil
+	}
+
+	var compact compactData
+	if err := json.Unmarshal(trimmed, &compact); err != nil {
+		return nil, fmt.Errorf("invalid compact data: %w", err)
+	}
+	rows := make([]map[string]interface{}, len(compact.Dataset))
+	for i, values := range compact.Dataset {
+		if len(values) != len(compact.Members) {
+			return nil, fmt.Errorf("invalid compact data: row %d has %d values for %d members", i, len(values), len(compact.Members))
+		}
+		row := make(map[string]interface{}, len(values))
+		for j, member := range compact.Members {
+			row[member] = values[j]
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// decodeLoadResult decodes a single-query /v1/load response body.
+func decodeLoadResult(body []byte) (CubeAPIResponse, error) {
+	envelope, err := decodeCubeEnvelope(body)
+	if err != nil {
+		return CubeAPIResponse{}, err
+	}
+	return envelope.result()
+}
@@ -0,0 +1,194 @@
+package plugin
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Fixtures for each /v1/load response variant Cube sends.
+const (
+	envelopeResult = `{
+		"query": {"measures": ["orders.count"], "dimensions": ["orders.status"]},
+		"data": [{"orders.status": "completed", "orders.count": "10"}, {"orders.status": "pending", "orders.count": "3"}],
+		"annotation": {"measures": {"orders.count": {"title": "Orders Count", "type": "number"}}, "dimensions": {"orders.status": {"title": "Orders Status", "type": "string"}}}
+	}`
+	envelopeCompactResult = `{
+		"data": {"members": ["orders.status", "orders.count"], "dataset": [["completed", "10"], ["pending", null]]},
+		"annotation": {"measures": {"orders.count": {"type": "number"}}}
+	}`
+	envelopeEmptyResult       = `{"data": [], "annotation": {}}`
+	envelopeContinueWait      = `{"error": "Continue wait", "stage": "Executing query", "timeElapsed": 4.5}`
+	envelopeContinueWaitEmpty = `{"error": "Continue wait"}`
+	envelopeError             = `{"error": "Cube 'orders' not found for path 'orders.nope'"}`
+	envelopeMulti             = `{
+		"queryType": "multi",
+		"results": [
+			{"data": [{"orders.count": "10"}], "annotation": {"measures": {"orders.count": {"type": "number"}}}},
+			{"data": {"members": ["users.count"], "dataset": [["7"]]}, "annotation": {}}
+		]
+	}`
+	envelopeMultiWithError = `{"queryType": "multi", "results": [{"data": []}, {"error": "Query timeout"}]}`
+)
+
+func TestDecodeCubeEnvelopeContinueWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wait     bool
+		progress continueWaitProgress
+	}{
+		{name: "with progress", body: envelopeContinueWait, wait: true, progress: continueWaitProgress{Stage: "Executing query", TimeElapsed: 4.5}},
+		{name: "without progress", body: envelopeContinueWaitEmpty, wait: true},
+		{name: "result", body: envelopeResult},
+		{name: "error", body: envelopeError},
+		{name: "multi", body: envelopeMulti},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envelope, err := decodeCubeEnvelope([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("decodeCubeEnvelope failed: %v", err)
+			}
+			if envelope.isContinueWait() != tt.wait {
+				t.Fatalf("isContinueWait() = %v, want %v", envelope.isContinueWait(), tt.wait)
+			}
+			if envelope.err() != nil && tt.wait {
+				t.Errorf("Continue wait must not be reported as an error, got %v", envelope.err())
+			}
+			if tt.wait && envelope.progress() != tt.progress {
+				t.Errorf("progress() = %+v, want %+v", envelope.progress(), tt.progress)
+			}
+		})
+	}
+}
+
+func TestDecodeCubeEnvelopeInvalid(t *testing.T) {
+	for _, body := range []string{``, `not json`, `<html>502 Bad Gateway</html>`, `[]`} {
+		if _, err := decodeCubeEnvelope([]byte(body)); err == nil {
+			t.Errorf("expected %q to fail to decode", body)
+		}
+	}
+}
+
+func TestDecodeLoadResult(t *testing.T) {
+	t.Run("rows", func(t *testing.T) {
+		result, err := decodeLoadResult([]byte(envelopeResult))
+		if err != nil {
+			t.Fatalf("decodeLoadResult failed: %v", err)
+		}
+		if len(result.Data) != 2 || result.Data[1]["orders.status"] != "pending" || result.Data[1]["orders.count"] != "3" {
+			t.Errorf("unexpected rows %v", result.Data)
+		}
+		if result.Annotation.Measures["orders.count"].Type != "number" || result.Annotation.Dimensions["orders.status"].Title != "Orders Status" {
+			t.Errorf("unexpected annotation %+v", result.Annotation)
+		}
+	})
+
+	t.Run("compact rows are expanded", func(t *testing.T) {
+		result, err := decodeLoadResult([]byte(envelopeCompactResult))
+		if err != nil {
+			t.Fatalf("decodeLoadResult failed: %v", err)
+		}
+		if len(result.Data) != 2 {
+			t.Fatalf("expected 2 rows, got %d", len(result.Data))
+		}
+		if result.Data[0]["orders.status"] != "completed" || result.Data[0]["orders.count"] != "10" {
+			t.Errorf("unexpected first row %v", result.Data[0])
+		}
+		if v, ok := result.Data[1]["orders.count"]; !ok || v != nil {
+			t.Errorf("expected a null orders.count in the second row, got %v", result.Data[1])
+		}
+		if result.Annotation.Measures["orders.count"].Type != "number" {
+			t.Errorf("unexpected annotation %+v", result.Annotation)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		for _, body := range []string{envelopeEmptyResult, `{}`, `{"data": null}`} {
+			result, err := decodeLoadResult([]byte(body))
+			if err != nil {
+				t.Fatalf("decodeLoadResult(%s) failed: %v", body, err)
+			}
+			if len(result.Data) != 0 {
+				t.Errorf("decodeLoadResult(%s): expected no rows, got %v", body, result.Data)
+			}
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, err := decodeLoadResult([]byte(envelopeError))
+		var reqErr *loadRequestError
+		if !errors.As(err, &reqErr) {
+			t.Fatalf("expected loadRequestError, got %v", err)
+		}
+		if reqErr.status != backend.StatusBadRequest || !strings.Contains(reqErr.msg, "Cube 'orders' not found") {
+			t.Errorf("unexpected error %+v", reqErr)
+		}
+	})
+
+	t.Run("not a single result", func(t *testing.T) {
+		for _, body := range []string{
+			envelopeContinueWait,
+			envelopeMulti,
+			`{"data": "rows"}`,
+			`{"data": {"members": ["a", "b"], "dataset": [["only one"]]}}`,
+			`not json`,
+		} {
+			if _, err := decodeLoadResult([]byte(body)); err == nil {
+				t.Errorf("expected decodeLoadResult(%s) to fail", body)
+			}
+		}
+	})
+}
+
+func TestCubeEnvelopeResults(t *testing.T) {
+	envelope, err := decodeCubeEnvelope([]byte(envelopeMulti))
+	if err != nil {
+		t.Fatalf("decodeCubeEnvelope failed: %v", err)
+	}
+	results, err := envelope.results()
+	if err != nil {
+		t.Fatalf("results failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Data[0]["orders.count"] != "10" || results[0].Annotation.Measures["orders.count"].Type != "number" {
+		t.Errorf("unexpected first result %+v", results[0])
+	}
+	if results[1].Data[0]["users.count"] != "7" {
+		t.Errorf("expected the compact second result to be expanded, got %+v", results[1])
+	}
+
+	for _, body := range []string{envelopeMultiWithError, envelopeResult, envelopeError} {
+		envelope, err := decodeCubeEnvelope([]byte(body))
+		if err != nil {
+			t.Fatalf("decodeCubeEnvelope failed: %v", err)
+		}
+		if _, err := envelope.results(); err == nil {
+			t.Errorf("expected results() of %s to fail", body)
+		}
+	}
+}
+
+func TestQueryDataCubeErrorWithStatusOK(t *testing.T) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(envelopeError))
+	}))
+	defer server.Close()
+	ds := &Datasource{BaseURL: server.URL}
+
+	resp := runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId": "A", "measures": ["orders.count"]}`)
+	if resp.Error == nil || !strings.Contains(resp.Error.Error(), "Cube 'orders' not found") {
+		t.Fatalf("expected the Cube error to be surfaced, got %v", resp.Error)
+	}
+	if resp.Status != backend.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", backend.StatusBadRequest, resp.Status)
+	}
+}
@@ -0,0 +1,182 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// ExplainResponse is the explain resource response: how Cube compiles a
+// query, whether a pre-aggregation serves it, and how long it took.
+type ExplainResponse struct {
+	SQL    string        `json:"sql"`
+	Params []interface{} `json:"params"`
+	// UsesPreAggregation is false when the query hits the warehouse.
+	UsesPreAggregation bool                     `json:"usesPreAggregation"`
+	PreAggregations    []CompiledPreAggregation `json:"preAggregations"`
+	DataSource         string                   `json:"dataSource,omitempty"`
+	Timings            ExplainTimings           `json:"timings"`
+}
+
+// ExplainTimings are the timings of an explained query, in milliseconds.
+type ExplainTimings struct {
+	// Compile is the duration of the /v1/sql request compiling the query.
+	Compile int64 `json:"compile"`
+	// Load is set when the query was also run (run=true).
+	Load *ExplainLoad `json:"load,omitempty"`
+}
+
+// ExplainLoad describes a run of an explained query.
+type ExplainLoad struct {
+	// Duration is the duration of the /v1/load request, Continue-wait
+	// polling included.
+	Duration int64 `json:"duration"`
+	// Stages are the Continue-wait answers Cube gave while computing the
+	// result, with the time Cube reported for each, in seconds.
+	Stages []ExplainStage `json:"stages"`
+	Rows   int            `json:"rows"`
+	// UsedPreAggregations are the pre-aggregation tables Cube answered
+	// from, which can differ from the compiled plan when they are not
+	// built yet.
+	UsedPreAggregations []string `json:"usedPreAggregations"`
+	DBType              string   `json:"dbType,omitempty"`
+	External            *bool    `json:"external,omitempty"`
+	SlowQuery           bool     `json:"slowQuery"`
+	RequestID           string   `json:"requestId,omitempty"`
+}
+
+// ExplainStage is a Continue-wait answer of Cube.
+type ExplainStage struct {
+	Stage       string  `json:"stage"`
+	TimeElapsed float64 `json:"timeElapsed"`
+}
+
+// handleExplain explains a query for the editor's "Explain query" action: the
+// SQL Cube compiles it to, with the pre-aggregations that serve it, and the
+// compile time. With run=true the query also runs, adding the load time,
+// Cube's Continue-wait stages and the pre-aggregations actually used. The
+// query is prepared as a panel query, so it is checked against the allowed
+// views, row limits and dimension cardinality, and explained as it will run.
+// It is read from the query parameter, or the body of a POST request.
+func (d *Datasource) handleExplain(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
+	}
+	queryParam := parsedURL.Query().Get("query")
+	if req.Method == http.MethodPost {
+		var body struct {
+			Query json.RawMessage `json:"query"`
+		}
+		if err := json.Unmarshal(req.Body, &body); err != nil {
+			return sender.Send(jsonErrorResponse(400, errors.New("invalid request body")))
+		}
+		queryParam = string(body.Query)
+	}
+	if queryParam == "" {
+		return sender.Send(jsonErrorResponse(400, errors.New("query parameter is required")))
+	}
+	run := false
+	if value := parsedURL.Query().Get("run"); value != "" {
+		if run, err = strconv.ParseBool(value); err != nil {
+			return sender.Send(jsonErrorResponse(400, fmt.Errorf("invalid run %q", value)))
+		}
+	}
+
+	if !json.Valid([]byte(queryParam)) {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid query JSON")))
+	}
+	prepared, errResponse := d.prepareQuery(ctx, req.PluginContext, backend.DataQuery{RefID: "explain", JSON: []byte(queryParam)})
+	if prepared == nil {
+		return sender.Send(jsonErrorResponse(int(errResponse.Status), errResponse.Error))
+	}
+	if len(prepared.blended) > 0 {
+		return sender.Send(jsonErrorResponse(400, errors.New("blended queries cannot be explained")))
+	}
+	queryJSON, err := json.Marshal(prepared.apiQuery)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal query")))
+	}
+
+	start := time.Now()
+	body, err := d.fetchCubeSQLBody(ctx, req.PluginContext, string(queryJSON))
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to compile query with Cube", "error", err)
+		return sender.Send(cubeLoadErrorResponse(err))
+	}
+	var plan cubeSQLPlan
+	if err := json.Unmarshal(body, &plan); err != nil {
+		return sender.Send(jsonErrorResponse(502, fmt.Errorf("failed to parse API response: %w", err)))
+	}
+	compiled, err := compiledSQLFromPlan(plan, true)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(502, err))
+	}
+	explained := ExplainResponse{
+		SQL:                compiled.SQL,
+		Params:             compiled.Params,
+		UsesPreAggregation: len(compiled.PreAggregations) > 0,
+		PreAggregations:    compiled.PreAggregations,
+		DataSource:         plan.SQL.DataSource,
+		Timings:            ExplainTimings{Compile: time.Since(start).Milliseconds()},
+	}
+
+	if run {
+		if explained.Timings.Load, err = d.explainLoad(ctx, req.PluginContext, prepared.query, queryJSON); err != nil {
+			backend.Logger.FromContext(ctx).Error("Failed to run explained query", "error", err)
+			return sender.Send(cubeLoadErrorResponse(err))
+		}
+	}
+
+	responseBody, err := json.Marshal(explained)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal explain response", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   responseBody,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
+
+// explainLoad runs a query and reports how Cube answered it.
+func (d *Datasource) explainLoad(ctx context.Context, pCtx backend.PluginContext, query CubeQuery, queryJSON []byte) (*ExplainLoad, error) {
+	apiReq, err := d.buildAPIURL(pCtx, "load")
+	if err != nil {
+		return nil, fmt.Errorf("failed to build API URL: %w", err)
+	}
+	apiReq.Config = continueWaitConfig(apiReq.Config, query)
+	load := &ExplainLoad{Stages: []ExplainStage{}, UsedPreAggregations: []string{}}
+	ctx = withContinueWaitObserver(ctx, func(progress continueWaitProgress) {
+		load.Stages = append(load.Stages, ExplainStage{Stage: progress.Stage, TimeElapsed: progress.TimeElapsed})
+	})
+
+	start := time.Now()
+	body, err := d.doCubeLoadRequest(ctx, apiReq.URL.String(), queryJSON, apiReq.Config)
+	if err != nil {
+		return nil, err
+	}
+	load.Duration = time.Since(start).Milliseconds()
+	result, err := decodeLoadResult(body)
+	if err != nil {
+		return nil, err
+	}
+	load.Rows = len(result.Data)
+	for name := range result.UsedPreAggregations {
+		load.UsedPreAggregations = append(load.UsedPreAggregations, name)
+	}
+	slices.Sort(load.UsedPreAggregations)
+	load.DBType, load.External, load.SlowQuery, load.RequestID = result.DBType, result.External, result.SlowQuery, result.RequestID
+	return load, nil
+}
@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHandleExplain(t *testing.T) {
+	loads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/sql"):
+			_, _ = w.Write([]byte(`{"sql": {"sql": ["SELECT count(*) FROM orders_rollup WHERE status = ?", ["completed"]], "dataSource": "default",
+				"preAggregations": [{"preAggregationId": "orders.rollup", "tableName": "prod_pre_aggregations.orders_rollup", "type": "rollup", "external": true}]}}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/load"):
+			loads++
+			if loads == 1 {
+				_, _ = w.Write([]byte(`{"error": "Continue wait", "stage": "Executing query", "timeElapsed": 2}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data": [{"orders.count": "3"}], "annotation": {"measures": {"orders.count": {"type": "number"}}},
+				"usedPreAggregations": {"prod_pre_aggregations.orders_rollup": {"targetTableName": "prod_pre_aggregations.orders_rollup_abc"}},
+				"dbType": "postgres", "external": true, "requestId": "req-1"}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "continueWaitPollInterval": 0}`)
+	params := url.Values{"query": {`{"measures": ["orders.count"]}`}, "run": {"true"}}
+	resp := callHandler(t, (&Datasource{}).handleExplain, &backend.CallResourceRequest{
+		PluginContext: pCtx,
+		URL:           "explain?" + params.Encode(),
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+
+	var explained ExplainResponse
+	if err := json.Unmarshal(resp.Body, &explained); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if explained.SQL != "SELECT count(*) FROM orders_rollup WHERE status = ?" || !reflect.DeepEqual(explained.Params, []interface{}{"completed"}) {
+		t.Errorf("unexpected SQL %q %v", explained.SQL, explained.Params)
+	}
+	if !explained.UsesPreAggregation || len(explained.PreAggregations) != 1 || explained.PreAggregations[0].ID != "orders.rollup" {
+		t.Errorf("expected the rollup to serve the query, got %+v", explained.PreAggregations)
+	}
+	load := explained.Timings.Load
+	if load == nil {
+		t.Fatal("expected load timings with run=true")
+	}
+	if want := []ExplainStage{{Stage: "Executing query", TimeElapsed: 2}}; !reflect.DeepEqual(load.Stages, want) {
+		t.Errorf("expected stages %v, got %v", want, load.Stages)
+	}
+	if load.Rows != 1 || load.DBType != "postgres" || load.RequestID != "req-1" {
+		t.Errorf("unexpected load %+v", load)
+	}
+	if want := []string{"prod_pre_aggregations.orders_rollup"}; !reflect.DeepEqual(load.UsedPreAggregations, want) {
+		t.Errorf("expected used pre-aggregations %v, got %v", want, load.UsedPreAggregations)
+	}
+}
+
+func TestHandleExplainWithoutRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/sql") {
+			t.Errorf("expected only the query to be compiled, got %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"sql": {"sql": ["SELECT 1", []]}}`))
+	}))
+	defer server.Close()
+
+	resp := callHandler(t, (&Datasource{}).handleExplain, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Method:        http.MethodPost,
+		URL:           "explain",
+		Body:          []byte(`{"query": {"measures": ["orders.count"]}}`),
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+	var explained ExplainResponse
+	if err := json.Unmarshal(resp.Body, &explained); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if explained.UsesPreAggregation || explained.Timings.Load != nil {
+		t.Errorf("expected a warehouse query without load timings, got %+v", explained)
+	}
+}
+
+func TestHandleExplainAppliesQueryChecks(t *testing.T) {
+	var compiled map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/v1/load") {
+			t.Errorf("expected the query not to run")
+		}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("query")), &compiled); err != nil {
+			t.Errorf("invalid compiled query: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"sql": {"sql": ["SELECT 1", []]}}`))
+	}))
+	defer server.Close()
+
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "allowedViews": ["orders"], "defaultLimit": 100}`)
+	explain := func(query string) *backend.CallResourceResponse {
+		params := url.Values{"query": {query}, "run": {"true"}}
+		return callHandler(t, (&Datasource{}).handleExplain, &backend.CallResourceRequest{
+			PluginContext: pCtx,
+			URL:           "explain?" + params.Encode(),
+		})
+	}
+
+	if resp := explain(`{"measures": ["secret_view.count"]}`); resp.Status != http.StatusForbidden {
+		t.Errorf("expected 403 for a view outside allowedViews, got %d: %s", resp.Status, resp.Body)
+	}
+	params := url.Values{"query": {`{"measures": ["orders.count"]}`}}
+	resp := callHandler(t, (&Datasource{}).handleExplain, &backend.CallResourceRequest{
+		PluginContext: pCtx,
+		URL:           "explain?" + params.Encode(),
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+	if compiled["limit"] != float64(100) {
+		t.Errorf("expected the default limit to be applied, got %v", compiled)
+	}
+}
+
+func TestHandleExplainErrors(t *testing.T) {
+	for name, rawURL := range map[string]string{
+		"no query":    "explain",
+		"invalid run": "explain?run=maybe&query=" + url.QueryEscape(`{"measures": ["orders.count"]}`),
+		"bad JSON":    "explain?query=" + url.QueryEscape(`{"measures": `),
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp := callHandler(t, (&Datasource{}).handleExplain, &backend.CallResourceRequest{
+				PluginContext: newTestPluginContext("http://localhost:4000"),
+				URL:           rawURL,
+			})
+			if resp.Status != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d: %s", resp.Status, resp.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// exportPageSize is the number of rows loaded from Cube per request of an
+// export, below Cube's default query limit of 50000.
+const exportPageSize = 10000
+
+// exportMaxRows bounds the rows of an export when the datasource sets no
+// maxLimit.
+const exportMaxRows = 1000000
+
+// exportFormats are the formats the export resource writes.
+var exportFormats = []string{"csv"}
+
+// exportFilenameUnsafe matches the characters replaced in export file names.
+var exportFilenameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// exportRequest holds the parameters of an export call.
+type exportRequest struct {
+	query     []byte
+	timeRange backend.TimeRange
+	filename  string
+}
+
+// parseExportRequest validates an export call: a panel query (the query
+// parameter, or the request body), the dashboard time range (from and to in
+// epoch milliseconds) and the file name.
+func parseExportRequest(req *backend.CallResourceRequest) (*exportRequest, error) {
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, errors.New("invalid URL")
+	}
+	params := parsedURL.Query()
+	if format := params.Get("format"); format != "" && format != "csv" {
+		return nil, fmt.Errorf("unsupported format %q (supported: %s)", format, strings.Join(exportFormats, ", "))
+	}
+
+	r := &exportRequest{filename: "export"}
+	if r.query, r.timeRange, err = parsePanelQuery(req, params); err != nil {
+		return nil, err
+	}
+	if name := strings.TrimSuffix(params.Get("filename"), ".csv"); name != "" {
+		r.filename = strings.Trim(exportFilenameUnsafe.ReplaceAllString(name, "_"), "_.")
+		if r.filename == "" {
+			r.filename = "export"
+		}
+	}
+	return r, nil
+}
+
+// parsePanelQuery reads the panel query of a resource call, from the query
+// parameter or the request body, and the dashboard time range, from the from
+// and to parameters in epoch milliseconds.
+func parsePanelQuery(req *backend.CallResourceRequest, params url.Values) ([]byte, backend.TimeRange, error) {
+	var timeRange backend.TimeRange
+	query := []byte(params.Get("query"))
+	if len(query) == 0 {
+		query = req.Body
+	}
+	if len(bytes.TrimSpace(query)) == 0 {
+		return nil, timeRange, errors.New("query is required")
+	}
+	if !json.Valid(query) {
+		return nil, timeRange, errors.New("invalid query JSON")
+	}
+
+	from, to := params.Get("from"), params.Get("to")
+	if from != "" || to != "" {
+		var err error
+		if timeRange.From, err = parseEpochMillis(from); err != nil {
+			return nil, timeRange, fmt.Errorf("invalid from: %w", err)
+		}
+		if timeRange.To, err = parseEpochMillis(to); err != nil {
+			return nil, timeRange, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	return query, timeRange, nil
+}
+
+// handleExport runs a panel query and returns its full result as a CSV file,
+// for report downloads larger than panels show. The panel's row limit does
+// not apply, the datasource's maxLimit does: the result is loaded from Cube
+// in pages of exportPageSize rows, up to maxLimit or exportMaxRows without
+// one, and each page is sent as it arrives. An error after the first page
+// ends the file early.
+//
+// Pages are loaded over the datasource's query transport and converted like
+// a panel's result, unit conversion included. What needs the whole result
+// does not apply to a paged export: the file holds the rows Cube returned,
+// without normalization, filled time buckets, fiscal labels or the alerting
+// and instant reshaping of panel frames.
+func (d *Datasource) handleExport(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	r, err := parseExportRequest(req)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	prepared, errResponse := d.prepareQuery(ctx, req.PluginContext, backend.DataQuery{RefID: "export", JSON: r.query, TimeRange: r.timeRange})
+	if prepared == nil {
+		return sender.Send(jsonErrorResponse(int(errResponse.Status), errResponse.Error))
+	}
+	if len(prepared.blended) > 0 {
+		return sender.Send(jsonErrorResponse(400, errors.New("blended queries cannot be exported")))
+	}
+	apiReq, err := d.buildAPIURL(req.PluginContext, "load")
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	if err := validateQueryTransport(apiReq.Config); err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	// Export pages would only evict the panels' results from the cache.
+	config := *apiReq.Config
+	config.ResultCacheTTL = nil
+	apiReq.Config = &config
+
+	headers := map[string][]string{
+		"Content-Type":        {"text/csv; charset=utf-8"},
+		"Content-Disposition": {fmt.Sprintf("attachment; filename=%q", r.filename+".csv")},
+	}
+	maxRows, limited := exportMaxRows, false
+	if config.MaxLimit != nil && *config.MaxLimit > 0 && *config.MaxLimit <= exportMaxRows {
+		maxRows, limited = *config.MaxLimit, true
+	}
+	exported := 0
+	for offset := 0; offset < maxRows; offset += exportPageSize {
+		pageSize := min(exportPageSize, maxRows-offset)
+		page, err := d.loadExportPage(ctx, prepared, apiReq, offset, pageSize)
+		if err != nil {
+			backend.Logger.FromContext(ctx).Error("Failed to export query result", "error", err, "rows", exported)
+			if offset == 0 {
+				var reqErr *loadRequestError
+				if errors.As(err, &reqErr) {
+					return sender.Send(jsonErrorResponse(int(reqErr.status), err))
+				}
+				return sender.Send(cubeLoadErrorResponse(err))
+			}
+			// The status was sent with the first page.
+			return nil
+		}
+		body, err := exportCSV(page, offset == 0)
+		if err != nil {
+			return sender.Send(jsonErrorResponse(500, errors.New("failed to write CSV")))
+		}
+		res := &backend.CallResourceResponse{Body: body}
+		if offset == 0 {
+			res.Status = http.StatusOK
+			res.Headers = headers
+		}
+		if err := sender.Send(res); err != nil {
+			return err
+		}
+		exported += page.Rows()
+		if page.Rows() < pageSize {
+			return nil
+		}
+	}
+	if limited {
+		return nil
+	}
+	backend.Logger.FromContext(ctx).Warn("Export truncated", "maxRows", maxRows)
+	return nil
+}
+
+// loadExportPage loads up to limit rows of the prepared query from offset on,
+// over the same transport as executeQuery, and converts them into a frame.
+func (d *Datasource) loadExportPage(ctx context.Context, prepared *preparedQuery, apiReq *APIRequestContext, offset, limit int) (*data.Frame, error) {
+	apiQuery := maps.Clone(prepared.apiQuery)
+	apiQuery["limit"] = limit
+	apiQuery["offset"] = offset
+	queryJSON, err := json.Marshal(apiQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+	var result CubeAPIResponse
+	switch {
+	case usesSQLAPI(apiReq.Config):
+		result, err = d.loadSQLAPIResult(ctx, apiReq.Config, queryJSON, prepared.query.Measures, prepared.measureFilters, "")
+	case usesGraphQL(apiReq.Config):
+		result, err = d.loadGraphQLResult(ctx, apiReq, queryJSON, "")
+	default:
+		result, err = d.loadQueryResult(ctx, apiReq, queryJSON, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	query := prepared.query
+	annotation := applyTypeOverrides(result.Annotation, query.TypeOverrides)
+	rows := coerceOverriddenStrings(result.Data, query.TypeOverrides)
+	frame := d.buildFrame("export", rows, query, annotation, prepared.timeLayouts)
+	d.convertUnits(frame, query.UnitConversion)
+	return frame, nil
+}
+
+// exportCSV writes the rows of frame as CSV, after a header of field names
+// when header is set. Times are written in RFC 3339 and nulls as empty
+// cells. Text starting like a spreadsheet formula is prefixed with a quote
+// so spreadsheets show it rather than evaluate it.
+func exportCSV(frame *data.Frame, header bool) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	record := make([]string, len(frame.Fields))
+	if header {
+		for i, field := range frame.Fields {
+			record[i] = field.Name
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	for row := 0; row < frame.Rows(); row++ {
+		for i, field := range frame.Fields {
+			record[i] = exportCell(field, row)
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// exportCell formats a value of field for CSV.
+func exportCell(field *data.Field, row int) string {
+	v, ok := field.ConcreteAt(row)
+	if !ok {
+		return ""
+	}
+	switch value := v.(type) {
+	case time.Time:
+		return value.UTC().Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case string:
+		if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+			return "'" + value
+		}
+		return value
+	}
+	return fmt.Sprint(v)
+}
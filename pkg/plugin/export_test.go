@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// callExport calls the export resource and returns the responses it sent.
+func callExport(t *testing.T, ds *Datasource, req *backend.CallResourceRequest) []*backend.CallResourceResponse {
+	t.Helper()
+	var sent []*backend.CallResourceResponse
+	sender := backend.CallResourceResponseSenderFunc(func(res *backend.CallResourceResponse) error {
+		sent = append(sent, res)
+		return nil
+	})
+	if err := ds.handleExport(context.Background(), req, sender); err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+	return sent
+}
+
+func TestHandleExport(t *testing.T) {
+	var offsets []float64
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		var query map[string]interface{}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("query")), &query); err != nil {
+			t.Errorf("invalid query: %v", err)
+		}
+		if query["limit"] != float64(exportPageSize) {
+			t.Errorf("expected pages of %d rows, got limit %v", exportPageSize, query["limit"])
+		}
+		offset := query["offset"].(float64)
+		offsets = append(offsets, offset)
+
+		rows := make([]string, 0, exportPageSize)
+		if offset == 0 {
+			rows = append(rows, `{"orders.status": "say \"hi\", then =1+1", "orders.count": "1"}`)
+			for i := 1; i < exportPageSize; i++ {
+				rows = append(rows, fmt.Sprintf(`{"orders.status": "s%d", "orders.count": "%d"}`, i, i))
+			}
+		} else {
+			rows = append(rows, `{"orders.status": "=HYPERLINK()", "orders.count": null}`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [` + strings.Join(rows, ",") + `],
+			"annotation": {"dimensions": {"orders.status": {"type": "string"}}, "measures": {"orders.count": {"type": "number"}}}}`))
+	}))
+	defer server.Close()
+
+	params := url.Values{
+		"format":   {"csv"},
+		"filename": {"Orders report"},
+		"query":    {`{"refId": "A", "dimensions": ["orders.status"], "measures": ["orders.count"], "limit": 100}`},
+	}
+	sent := callExport(t, &Datasource{}, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "export?" + params.Encode(),
+	})
+
+	if len(offsets) != 2 || offsets[1] != exportPageSize {
+		t.Fatalf("expected two pages ignoring the query's limit, got offsets %v", offsets)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("expected a response per page, got %d", len(sent))
+	}
+	if sent[0].Status != http.StatusOK || sent[0].Headers["Content-Type"][0] != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected first response %d %v", sent[0].Status, sent[0].Headers)
+	}
+	if got := sent[0].Headers["Content-Disposition"][0]; got != `attachment; filename="Orders_report.csv"` {
+		t.Errorf("unexpected Content-Disposition %s", got)
+	}
+
+	lines := strings.Split(string(sent[0].Body), "\n")
+	if lines[0] != "orders.status,orders.count" {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+	if want := `"say ""hi"", then =1+1",1`; lines[1] != want {
+		t.Errorf("expected %s, got %s", want, lines[1])
+	}
+	if got := string(sent[1].Body); got != "'=HYPERLINK(),\n" {
+		t.Errorf("expected the formula escaped and null empty without a header, got %q", got)
+	}
+}
+
+func TestHandleExportMaxLimit(t *testing.T) {
+	var limits, offsets []float64
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		var query map[string]interface{}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("query")), &query); err != nil {
+			t.Errorf("invalid query: %v", err)
+		}
+		limit := query["limit"].(float64)
+		limits, offsets = append(limits, limit), append(offsets, query["offset"].(float64))
+
+		rows := make([]string, int(limit))
+		for i := range rows {
+			rows[i] = fmt.Sprintf(`{"orders.status": "s%d"}`, i)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [` + strings.Join(rows, ",") + `], "annotation": {"dimensions": {"orders.status": {"type": "string"}}}}`))
+	}))
+	defer server.Close()
+
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "maxLimit": 15000}`)
+	params := url.Values{"query": {`{"dimensions": ["orders.status"]}`}}
+	sent := callExport(t, &Datasource{}, &backend.CallResourceRequest{PluginContext: pCtx, URL: "export?" + params.Encode()})
+
+	if want := []float64{exportPageSize, 5000}; !slices.Equal(limits, want) {
+		t.Errorf("expected page limits %v, got %v", want, limits)
+	}
+	if want := []float64{0, exportPageSize}; !slices.Equal(offsets, want) {
+		t.Errorf("expected offsets %v, got %v", want, offsets)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("expected a response per page, got %d", len(sent))
+	}
+}
+
+func TestHandleExportSQLAPI(t *testing.T) {
+	a, b, fast, slow := "a", "b", "1500", "3000"
+	server := newFakeSQLAPI(t,
+		[]pgColumn{{Name: "orders.status", TypeOID: 25}, {Name: "orders.duration_ms", TypeOID: pgTypeInt4}},
+		[][]*string{{&a, &fast}, {&b, &slow}})
+
+	// Unit conversion applies to exported rows; normalization, which needs
+	// the whole result, does not.
+	params := url.Values{"query": {`{"dimensions": ["orders.status"], "measures": ["orders.duration_ms"],
+		"unitConversion": {"orders.duration_ms": {"from": "ms", "to": "s"}}, "normalize": "index100"}`}}
+	sent := callExport(t, &Datasource{}, &backend.CallResourceRequest{
+		PluginContext: sqlAPIPluginContext(t, server.listener.Addr().String()),
+		URL:           "export?" + params.Encode(),
+	})
+
+	<-server.user
+	<-server.password
+	if got := <-server.queries; !strings.HasSuffix(got, fmt.Sprintf("LIMIT %d OFFSET 0", exportPageSize)) {
+		t.Errorf("expected the first page from the SQL API, got SQL: %s", got)
+	}
+	if len(sent) != 1 || sent[0].Status != http.StatusOK {
+		t.Fatalf("expected a single page, got %+v", sent)
+	}
+	if want := "orders.status,orders.duration_ms\na,1.5\nb,3\n"; string(sent[0].Body) != want {
+		t.Errorf("expected %q, got %q", want, sent[0].Body)
+	}
+}
+
+func TestHandleExportSQLAPIError(t *testing.T) {
+	server := newFakeSQLAPI(t, nil, nil)
+	server.errMsg = "Unknown column 'nope'"
+
+	params := url.Values{"query": {`{"measures": ["orders.nope"]}`}}
+	sent := callExport(t, &Datasource{}, &backend.CallResourceRequest{
+		PluginContext: sqlAPIPluginContext(t, server.listener.Addr().String()),
+		URL:           "export?" + params.Encode(),
+	})
+	if len(sent) != 1 || sent[0].Status != http.StatusBadRequest || !strings.Contains(string(sent[0].Body), "Unknown column 'nope'") {
+		t.Fatalf("expected the SQL API error as a bad request, got %+v", sent)
+	}
+}
+
+func TestHandleExportErrors(t *testing.T) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": "Unknown member orders.nope"}`))
+	}))
+	defer server.Close()
+
+	for name, tt := range map[string]struct {
+		url    string
+		status int
+	}{
+		"no query":       {"export", http.StatusBadRequest},
+		"invalid format": {"export?format=xlsx&query=" + url.QueryEscape(`{"measures": ["orders.count"]}`), http.StatusBadRequest},
+		"invalid range":  {"export?from=yesterday&to=1&query=" + url.QueryEscape(`{"measures": ["orders.count"]}`), http.StatusBadRequest},
+		"cube error":     {"export?query=" + url.QueryEscape(`{"measures": ["orders.nope"]}`), http.StatusBadRequest},
+	} {
+		t.Run(name, func(t *testing.T) {
+			sent := callExport(t, &Datasource{}, &backend.CallResourceRequest{PluginContext: newTestPluginContext(server.URL), URL: tt.url})
+			if len(sent) != 1 || sent[0].Status != tt.status {
+				t.Fatalf("expected a single %d response, got %+v", tt.status, sent)
+			}
+		})
+	}
+}
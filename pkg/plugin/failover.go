@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// endpointCooldown is how long a Cube endpoint that failed is tried after the
+// healthy ones.
+const endpointCooldown = 30 * time.Second
+
+// endpointStatus is the health of a Cube endpoint, as shown in diagnostics.
+type endpointStatus struct {
+	URL       string     `json:"url"`
+	Healthy   bool       `json:"healthy"`
+	FailedAt  *time.Time `json:"failedAt,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+}
+
+// endpointFailure records the last failure of an endpoint.
+type endpointFailure struct {
+	at  time.Time
+	err string
+}
+
+// endpointHealth tracks the Cube endpoints that failed recently, for
+// datasources with several Cube URLs. Its zero value is ready to use.
+type endpointHealth struct {
+	mu       sync.Mutex
+	failures map[string]endpointFailure
+}
+
+// order returns endpoints with the healthy ones first, each group in the
+// configured order. An endpoint is healthy when it has not failed within
+// endpointCooldown.
+func (h *endpointHealth) order(endpoints []string, now time.Time) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ordered := make([]string, 0, len(endpoints))
+	var failing []string
+	for _, endpoint := range endpoints {
+		if failure, ok := h.failures[endpoint]; ok && now.Sub(failure.at) < endpointCooldown {
+			failing = append(failing, endpoint)
+			continue
+		}
+		ordered = append(ordered, endpoint)
+	}
+	return append(ordered, failing...)
+}
+
+// markFailed records a failure of endpoint.
+func (h *endpointHealth) markFailed(endpoint string, now time.Time, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failures == nil {
+		h.failures = make(map[string]endpointFailure)
+	}
+	h.failures[endpoint] = endpointFailure{at: now, err: reason}
+}
+
+// markHealthy forgets the failures of endpoint.
+func (h *endpointHealth) markHealthy(endpoint string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.failures, endpoint)
+}
+
+// statuses returns the health of endpoints.
+func (h *endpointHealth) statuses(endpoints []string, now time.Time) []endpointStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	statuses := make([]endpointStatus, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		status := endpointStatus{URL: endpoint, Healthy: true}
+		if failure, ok := h.failures[endpoint]; ok {
+			at := failure.at
+			status.Healthy = now.Sub(at) >= endpointCooldown
+			status.FailedAt, status.LastError = &at, failure.err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// baseURL returns the Cube URL to send requests to: the first healthy
+// endpoint, or the test override.
+func (d *Datasource) baseURL(config *models.PluginSettings) string {
+	if d.BaseURL != "" {
+		// Override for testing
+		return d.BaseURL
+	}
+	endpoints := d.endpoints.order(config.Endpoints(), time.Now())
+	if len(endpoints) == 0 {
+		return ""
+	}
+	return endpoints[0]
+}
+
+// endpointOf returns the endpoint rawURL was built from, or "".
+func endpointOf(rawURL string, endpoints []string) string {
+	for _, endpoint := range endpoints {
+		if rawURL == endpoint || strings.HasPrefix(rawURL, endpoint+"/") || strings.HasPrefix(rawURL, endpoint+"?") {
+			return endpoint
+		}
+	}
+	return ""
+}
+
+// endpointFailed reports whether a response or transport error means the
+// endpoint is down: a connection failure or a 5xx status.
+func endpointFailed(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// doHTTPWithFailover sends req with send and, when the datasource has
+// several Cube URLs and the endpoint req goes to is down, sends it again to
+// the next endpoint, healthy ones first, until one answers. Endpoints that
+// fail are tried last for endpointCooldown.
+func (d *Datasource) doHTTPWithFailover(req *http.Request, config *models.PluginSettings, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	endpoints := config.Endpoints()
+	current := endpointOf(req.URL.String(), endpoints)
+	if len(endpoints) < 2 || current == "" || d.BaseURL != "" {
+		return send(req)
+	}
+
+	tried := []string{}
+	for {
+		resp, err := send(req)
+		tried = append(tried, current)
+		if !endpointFailed(resp, err) || req.Context().Err() != nil {
+			if err == nil {
+				d.endpoints.markHealthy(current)
+			}
+			return resp, err
+		}
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+		}
+		d.endpoints.markFailed(current, time.Now(), reason)
+
+		next := ""
+		for _, endpoint := range d.endpoints.order(endpoints, time.Now()) {
+			if !slices.Contains(tried, endpoint) {
+				next = endpoint
+				break
+			}
+		}
+		if next == "" {
+			return resp, err
+		}
+		retry, retryErr := requestForEndpoint(req, current, next)
+		if retryErr != nil {
+			return resp, err
+		}
+		backend.Logger.FromContext(req.Context()).Warn("Cube endpoint failed, failing over", "endpoint", current, "next", next, "reason", reason)
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		req, current = retry, next
+	}
+}
+
+// requestForEndpoint returns a copy of req sent to endpoint to instead of
+// from.
+func requestForEndpoint(req *http.Request, from, to string) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	parsed, err := retry.URL.Parse(to + strings.TrimPrefix(req.URL.String(), from))
+	if err != nil {
+		return nil, err
+	}
+	retry.URL = parsed
+	retry.Host = ""
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, errors.New("request body cannot be sent again")
+		}
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return retry, nil
+}
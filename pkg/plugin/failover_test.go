@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestEndpointHealthOrder(t *testing.T) {
+	var health endpointHealth
+	endpoints := []string{"http://a", "http://b", "http://c"}
+	now := time.Now()
+	if got := health.order(endpoints, now); !reflect.DeepEqual(got, endpoints) {
+		t.Errorf("expected the configured order, got %v", got)
+	}
+
+	health.markFailed("http://a", now, "503 Service Unavailable")
+	if want, got := []string{"http://b", "http://c", "http://a"}, health.order(endpoints, now); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the failed endpoint last, got %v", got)
+	}
+	if got := health.order(endpoints, now.Add(endpointCooldown)); !reflect.DeepEqual(got, endpoints) {
+		t.Errorf("expected the failed endpoint back after the cooldown, got %v", got)
+	}
+	statuses := health.statuses(endpoints, now)
+	if statuses[0].Healthy || statuses[0].LastError != "503 Service Unavailable" || !statuses[1].Healthy {
+		t.Errorf("unexpected statuses %+v", statuses)
+	}
+
+	health.markHealthy("http://a")
+	if got := health.order(endpoints, now); !reflect.DeepEqual(got, endpoints) {
+		t.Errorf("expected the recovered endpoint first again, got %v", got)
+	}
+}
+
+func TestEndpointOf(t *testing.T) {
+	endpoints := []string{"http://cube:4000", "http://cube:40000"}
+	for rawURL, want := range map[string]string{
+		"http://cube:4000/cubejs-api/v1/load":  "http://cube:4000",
+		"http://cube:40000/cubejs-api/v1/load": "http://cube:40000",
+		"http://other:4000/cubejs-api/v1/load": "",
+	} {
+		if got := endpointOf(rawURL, endpoints); got != want {
+			t.Errorf("endpointOf(%s) = %q, want %q", rawURL, got, want)
+		}
+	}
+}
+
+func TestQueryDataFailsOver(t *testing.T) {
+	var primaryRequests, secondaryRequests atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryRequests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		secondaryRequests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [{"orders.count": "3"}], "annotation": {"measures": {"orders.count": {"type": "number"}}}}`))
+	}))
+	defer secondary.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	ds := &Datasource{}
+	pCtx := newTestPluginContext(down.URL + ", " + primary.URL + "/, " + secondary.URL)
+	resp := runSingleQuery(t, ds, pCtx, `{"refId": "A", "measures": ["orders.count"]}`)
+	if resp.Error != nil {
+		t.Fatalf("expected the third endpoint to answer, got %v", resp.Error)
+	}
+	if resp.Frames[0].Rows() != 1 {
+		t.Errorf("expected 1 row, got %d", resp.Frames[0].Rows())
+	}
+
+	// The failed endpoints are now tried last.
+	primaryRequests.Store(0)
+	secondaryRequests.Store(0)
+	resp = runSingleQuery(t, ds, pCtx, `{"refId": "A", "measures": ["orders.count"], "limit": 10}`)
+	if resp.Error != nil || primaryRequests.Load() != 0 || secondaryRequests.Load() != 1 {
+		t.Errorf("expected the healthy endpoint to be used first, got %v after %d primary requests", resp.Error, primaryRequests.Load())
+	}
+}
+
+func TestQueryDataFailoverKeepsClientErrors(t *testing.T) {
+	var secondaryRequests atomic.Int32
+	primary := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": "Unknown member orders.nope"}`))
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryRequests.Add(1)
+	}))
+	defer secondary.Close()
+
+	resp := runSingleQuery(t, &Datasource{}, newTestPluginContext(primary.URL+","+secondary.URL), `{"refId": "A", "measures": ["orders.nope"]}`)
+	if resp.Status != backend.StatusBadRequest || secondaryRequests.Load() != 0 {
+		t.Errorf("expected the 400 without failover, got %v after %d secondary requests", resp.Status, secondaryRequests.Load())
+	}
+}
@@ -0,0 +1,259 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Supported values for CubeQuery.Fill and CubeQuery.MeasureFill.
+const (
+	// fillZero gives the measures of missing time buckets the value 0.
+	fillZero = "zero"
+	// fillNull gives the measures of missing time buckets a null value, so
+	// graphs show a gap instead of connecting the surrounding points.
+	fillNull = "null"
+)
+
+// maxFillBuckets bounds the number of time buckets generated per series, so a
+// fine granularity over a long range cannot blow up the response.
+const maxFillBuckets = 10000
+
+// validateFill checks the fill options of a query. An empty value means "no
+// fill".
+func validateFill(query CubeQuery) error {
+	if err := validateFillMode(query.Fill); err != nil {
+		return err
+	}
+	for measure, mode := range query.MeasureFill {
+		if mode == "" {
+			return fmt.Errorf("invalid fill option for %s: must be %q or %q", measure, fillZero, fillNull)
+		}
+		if err := validateFillMode(mode); err != nil {
+			return fmt.Errorf("%s: %w", measure, err)
+		}
+	}
+	return nil
+}
+
+func validateFillMode(mode string) error {
+	switch mode {
+	case "", fillZero, fillNull:
+		return nil
+	default:
+		return fmt.Errorf("invalid fill option %q (must be %q or %q)", mode, fillZero, fillNull)
+	}
+}
+
+// measureFillMode returns how the missing buckets of a measure are filled, or
+// "" when they are not.
+func measureFillMode(query CubeQuery, measure string) string {
+	if mode, ok := query.MeasureFill[measure]; ok {
+		return mode
+	}
+	return query.Fill
+}
+
+// fillTimeBuckets adds a row for every time bucket missing from each series
+// of the frame, over the date range of the query's first time dimension with
+// a granularity (the dashboard time range when it has no absolute date
+// range). Measures of the added rows are 0 or null according to the query's
+// fill options, dimensions repeat their series' values, and rows are ordered
+// by time. Cube only returns buckets with data, so without this a sparse
+// series is drawn as a line connecting distant points.
+func fillTimeBuckets(frame *data.Frame, query CubeQuery, apiQuery map[string]interface{}, timeRange backend.TimeRange) *data.Frame {
+	if query.Fill == "" && len(query.MeasureFill) == 0 {
+		return frame
+	}
+	timeField, granularity, from, to := fillTimeDimension(frame, apiQuery, timeRange)
+	if timeField < 0 {
+		return frame
+	}
+	buckets := timeBuckets(from, to, granularity)
+	if len(buckets) == 0 {
+		return frame
+	}
+
+	// Group the rows into series and record the buckets each one has.
+	seriesKeys := seriesKeysForRows(frame, query)
+	var series []int // first row of every series, in order of appearance
+	present := make(map[string]map[int64]bool)
+	for row, key := range seriesKeys {
+		if _, ok := present[key]; !ok {
+			present[key] = make(map[int64]bool)
+			series = append(series, row)
+		}
+		if t, ok := frame.Fields[timeField].ConcreteAt(row); ok {
+			present[key][t.(time.Time).UnixNano()] = true
+		}
+	}
+	if len(series) == 0 {
+		// No rows at all: fill a single series with no dimension values.
+		series = append(series, -1)
+		present[""] = map[int64]bool{}
+	}
+
+	// A source row, or a missing bucket of the series starting at row.
+	type fillRow struct {
+		src    int
+		series int
+		bucket time.Time
+	}
+	rows := make([]fillRow, 0, frame.Rows())
+	for row := range seriesKeys {
+		rows = append(rows, fillRow{src: row})
+	}
+	for _, first := range series {
+		key := ""
+		if first >= 0 {
+			key = seriesKeys[first]
+		}
+		for _, bucket := range buckets {
+			if !present[key][bucket.UnixNano()] {
+				rows = append(rows, fillRow{src: -1, series: first, bucket: bucket})
+			}
+		}
+	}
+	if len(rows) == frame.Rows() {
+		return frame
+	}
+	rowTime := func(r fillRow) time.Time {
+		if r.src < 0 {
+			return r.bucket
+		}
+		if t, ok := frame.Fields[timeField].ConcreteAt(r.src); ok {
+			return t.(time.Time)
+		}
+		return time.Time{}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rowTime(rows[i]).Before(rowTime(rows[j]))
+	})
+
+	filled := data.NewFrame(frame.Name)
+	filled.Meta = frame.Meta
+	for i, field := range frame.Fields {
+		newField := data.NewFieldFromFieldType(field.Type(), len(rows))
+		newField.Name = field.Name
+		newField.Labels = field.Labels
+		newField.Config = field.Config
+		mode := ""
+		if slices.Contains(query.Measures, field.Name) {
+			mode = measureFillMode(query, field.Name)
+		}
+		for j, r := range rows {
+			switch {
+			case r.src >= 0:
+				newField.Set(j, field.CopyAt(r.src))
+			case i == timeField:
+				newField.SetConcrete(j, r.bucket)
+			case slices.Contains(query.Dimensions, field.Name) && r.series >= 0:
+				newField.Set(j, field.CopyAt(r.series))
+			case mode == fillZero && field.Type().NonNullableType() == data.FieldTypeFloat64:
+				newField.SetConcrete(j, float64(0))
+			}
+		}
+		filled.Fields = append(filled.Fields, newField)
+	}
+	return filled
+}
+
+// fillTimeDimension finds the field of the first time dimension of the query
+// with a granularity and the range to fill it over. It returns a negative
+// field index when there is none.
+func fillTimeDimension(frame *data.Frame, apiQuery map[string]interface{}, timeRange backend.TimeRange) (int, string, time.Time, time.Time) {
+	for _, td := range timeDimensionList(apiQuery) {
+		dimension, _ := td["dimension"].(string)
+		granularity, _ := td["granularity"].(string)
+		if dimension == "" || granularity == "" {
+			continue
+		}
+		index := -1
+		for _, name := range []string{dimension + "." + granularity, dimension} {
+			if _, i := frame.FieldByName(name); i >= 0 && frame.Fields[i].Type().Time() {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			continue
+		}
+		from, to, err := dateRangeBounds(td["dateRange"])
+		if err != nil || to.IsZero() {
+			from, to = timeRange.From.UTC(), timeRange.To.UTC()
+		}
+		return index, granularity, from, to
+	}
+	return -1, "", time.Time{}, time.Time{}
+}
+
+// timeBuckets returns the start of every bucket of the granularity from the
+// bucket containing from to the bucket containing to. It returns nil for an
+// unknown granularity or more than maxFillBuckets buckets.
+func timeBuckets(from, to time.Time, granularity string) []time.Time {
+	start := truncateToGranularity(from, granularity)
+	if start.IsZero() || to.Before(from) {
+		return nil
+	}
+	var buckets []time.Time
+	for t := start; !t.After(to); t = nextBucket(t, granularity) {
+		if len(buckets) == maxFillBuckets {
+			return nil
+		}
+		buckets = append(buckets, t)
+	}
+	return buckets
+}
+
+// truncateToGranularity returns the start of the bucket containing t, in
+// UTC; Cube's timestamps are parsed as UTC wall clock times. Weeks start on
+// Monday. It returns the zero time for an unknown granularity.
+func truncateToGranularity(t time.Time, granularity string) time.Time {
+	t = t.UTC()
+	switch granularity {
+	case "second":
+		return t.Truncate(time.Second)
+	case "minute":
+		return t.Truncate(time.Minute)
+	case "hour":
+		return t.Truncate(time.Hour)
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case "quarter":
+		return time.Date(t.Year(), t.Month()-(t.Month()-1)%3, 1, 0, 0, 0, 0, time.UTC)
+	case "year":
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Time{}
+}
+
+// nextBucket returns the start of the bucket after the one starting at t.
+func nextBucket(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case "second":
+		return t.Add(time.Second)
+	case "minute":
+		return t.Add(time.Minute)
+	case "hour":
+		return t.Add(time.Hour)
+	case "day":
+		return t.AddDate(0, 0, 1)
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	case "quarter":
+		return t.AddDate(0, 3, 0)
+	default:
+		return t.AddDate(1, 0, 0)
+	}
+}
@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestTimeBuckets(t *testing.T) {
+	tests := []struct {
+		granularity string
+		from, to    string
+		want        []string
+	}{
+		{granularity: "day", from: "2024-01-01T10:00:00Z", to: "2024-01-03T00:00:00Z", want: []string{"2024-01-01", "2024-01-02", "2024-01-03"}},
+		{granularity: "week", from: "2024-01-03T00:00:00Z", to: "2024-01-15T00:00:00Z", want: []string{"2024-01-01", "2024-01-08", "2024-01-15"}},
+		{granularity: "month", from: "2024-01-31T00:00:00Z", to: "2024-03-01T00:00:00Z", want: []string{"2024-01-01", "2024-02-01", "2024-03-01"}},
+		{granularity: "quarter", from: "2024-05-10T00:00:00Z", to: "2024-12-31T00:00:00Z", want: []string{"2024-04-01", "2024-07-01", "2024-10-01"}},
+		{granularity: "fortnight", from: "2024-01-01T00:00:00Z", to: "2024-02-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.granularity, func(t *testing.T) {
+			from, _ := time.Parse(time.RFC3339, tt.from)
+			to, _ := time.Parse(time.RFC3339, tt.to)
+			var got []string
+			for _, b := range timeBuckets(from, to, tt.granularity) {
+				got = append(got, b.Format("2006-01-02"))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if buckets := timeBuckets(from, from.AddDate(1, 0, 0), "minute"); buckets != nil {
+		t.Errorf("expected no buckets beyond maxFillBuckets, got %d", len(buckets))
+	}
+}
+
+func TestFillTimeBuckets(t *testing.T) {
+	day := func(d int) *time.Time {
+		t := time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	frame := data.NewFrame("response",
+		data.NewField("orders.created_at.day", nil, []*time.Time{day(1), day(1), day(3)}),
+		data.NewField("orders.status", nil, []*string{strPtr("a"), strPtr("b"), strPtr("a")}),
+		data.NewField("orders.count", nil, []*float64{floatPtr(1), floatPtr(2), floatPtr(3)}),
+		data.NewField("orders.avg", nil, []*float64{floatPtr(10), floatPtr(20), floatPtr(30)}),
+	)
+	query := CubeQuery{
+		Dimensions:  []string{"orders.created_at.day", "orders.status"},
+		Measures:    []string{"orders.count", "orders.avg"},
+		Fill:        fillZero,
+		MeasureFill: map[string]string{"orders.avg": fillNull},
+	}
+	apiQuery := map[string]interface{}{"timeDimensions": []interface{}{map[string]interface{}{
+		"dimension": "orders.created_at", "granularity": "day", "dateRange": []interface{}{"2024-01-01", "2024-01-03"},
+	}}}
+
+	filled := fillTimeBuckets(frame, query, apiQuery, backend.TimeRange{})
+
+	if filled.Rows() != 6 {
+		t.Fatalf("expected 3 days for 2 series, got %d rows", filled.Rows())
+	}
+	var got []string
+	for i := 0; i < filled.Rows(); i++ {
+		ts, _ := filled.Fields[0].ConcreteAt(i)
+		status, _ := filled.Fields[1].ConcreteAt(i)
+		got = append(got, ts.(time.Time).Format("02")+status.(string))
+	}
+	if strings.Join(got, ",") != "01a,01b,02a,02b,03a,03b" {
+		t.Errorf("unexpected rows: %v", got)
+	}
+	assertFloats(t, "orders.count", nullableFloats(t, filled.Fields[2]), []*float64{floatPtr(1), floatPtr(2), floatPtr(0), floatPtr(0), floatPtr(3), floatPtr(0)})
+	assertFloats(t, "orders.avg", nullableFloats(t, filled.Fields[3]), []*float64{floatPtr(10), floatPtr(20), nil, nil, floatPtr(30), nil})
+}
+
+func TestFillTimeBucketsWithoutGranularity(t *testing.T) {
+	frame := data.NewFrame("response",
+		data.NewField("orders.count", nil, []*float64{floatPtr(1)}),
+	)
+	query := CubeQuery{Measures: []string{"orders.count"}, Fill: fillZero}
+	apiQuery := map[string]interface{}{"timeDimensions": []interface{}{map[string]interface{}{
+		"dimension": "orders.created_at", "dateRange": []interface{}{"2024-01-01", "2024-01-03"},
+	}}}
+
+	if filled := fillTimeBuckets(frame, query, apiQuery, backend.TimeRange{}); filled != frame {
+		t.Error("expected the frame to be left alone")
+	}
+}
+
+func TestQueryDataFill(t *testing.T) {
+	server := newCubeLoadServer(t, CubeAPIResponse{
+		Data: []map[string]interface{}{
+			{"orders.created_at.month": "2024-01-01T00:00:00.000", "orders.count": "5"},
+			{"orders.created_at.month": "2024-03-01T00:00:00.000", "orders.count": "7"},
+		},
+		Annotation: CubeAnnotation{
+			Measures:       map[string]CubeFieldInfo{"orders.count": {Type: "number"}},
+			TimeDimensions: map[string]CubeFieldInfo{"orders.created_at.month": {Type: "time"}},
+		},
+	})
+	ds := &Datasource{BaseURL: server.URL}
+
+	res := runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId":"A","measures":["orders.count"],"dimensions":["orders.created_at.month"],
+		"timeDimensions":[{"dimension":"orders.created_at","granularity":"month","dateRange":["2024-01-01","2024-03-31"]}],"fill":"zero"}`)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	assertFloats(t, "orders.count", nullableFloats(t, res.Frames[0].Fields[1]), []*float64{floatPtr(5), floatPtr(0), floatPtr(7)})
+}
+
+func TestQueryDataFillInvalidOption(t *testing.T) {
+	ds := &Datasource{BaseURL: "http://unused"}
+
+	res := runSingleQuery(t, ds, newTestPluginContext("http://unused"), `{"refId":"A","measures":["orders.count"],"measureFill":{"orders.count":"previous"}}`)
+	if res.Error == nil || !strings.Contains(res.Error.Error(), `invalid fill option "previous"`) {
+		t.Errorf("expected an invalid fill option error, got %v", res.Error)
+	}
+	if res.Status != backend.StatusBadRequest {
+		t.Errorf("expected 400, got %d", res.Status)
+	}
+}
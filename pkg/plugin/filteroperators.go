@@ -0,0 +1,126 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// filterOperators maps each Cube member type to the filter operators Cube
+// accepts for it. Members of other types (Cube reports none in practice) are
+// treated as strings.
+var filterOperators = map[string][]string{
+	"string": {
+		"equals", "notEquals",
+		"contains", "notContains",
+		"startsWith", "notStartsWith",
+		"endsWith", "notEndsWith",
+		"set", "notSet",
+	},
+	"number": {
+		"equals", "notEquals",
+		"gt", "gte", "lt", "lte",
+		"set", "notSet",
+	},
+	"time": {
+		"equals", "notEquals",
+		"inDateRange", "notInDateRange",
+		"beforeDate", "beforeOrOnDate",
+		"afterDate", "afterOrOnDate",
+		"set", "notSet",
+	},
+	"boolean": {
+		"equals", "notEquals",
+		"set", "notSet",
+	},
+}
+
+// operatorsForType returns the filter operators valid for a member type.
+func operatorsForType(memberType string) []string {
+	return filterOperators[operatorsType(memberType)]
+}
+
+// validFilterOperator reports whether operator can filter a member of the
+// given type.
+func validFilterOperator(memberType, operator string) bool {
+	return slices.Contains(operatorsForType(memberType), operator)
+}
+
+// filterMember is a member of the model as far as filter validation is
+// concerned.
+type filterMember struct {
+	// Kind is "dimension" or "measure", for error messages.
+	Kind string
+	Type string
+}
+
+// filterableMembers indexes the dimensions and measures of the model by name.
+// Measures of an aggregation type Cube filters as numbers.
+func filterableMembers(meta *CubeMetaResponse) map[string]filterMember {
+	members := make(map[string]filterMember)
+	for _, cube := range meta.Cubes {
+		for _, dim := range cube.Dimensions {
+			members[dim.Name] = filterMember{Kind: "dimension", Type: dim.Type}
+		}
+		for _, measure := range cube.Measures {
+			memberType := measure.Type
+			if _, ok := filterOperators[memberType]; !ok {
+				memberType = "number"
+			}
+			members[measure.Name] = filterMember{Kind: "measure", Type: memberType}
+		}
+	}
+	return members
+}
+
+// validateFilterOperators checks the operator of every filter, including
+// those nested in and/or groups, against the type of its member. Members
+// missing from the model are left for Cube to report.
+func validateFilterOperators(filters []interface{}, members map[string]filterMember) error {
+	var err error
+	walkMemberFilters(filters, func(name string, filter map[string]interface{}) {
+		operator, _ := filter["operator"].(string)
+		member, ok := members[name]
+		if err != nil || !ok || operator == "" {
+			return
+		}
+		if !validFilterOperator(member.Type, operator) {
+			err = fmt.Errorf("operator '%s' is not valid for %s %s %s", operator, operatorsType(member.Type), member.Kind, name)
+		}
+	})
+	return err
+}
+
+// operatorsType returns the type whose operators apply to a member type.
+func operatorsType(memberType string) string {
+	if _, ok := filterOperators[memberType]; ok {
+		return memberType
+	}
+	return "string"
+}
+
+// checkFilters validates the filters of a query against the model before
+// the query is sent, so a wrong operator or measure filter gets an actionable
+// error instead of Cube's. It returns the measures the filters apply to
+// (Cube's HAVING filters). Validation is skipped when the model cannot be
+// fetched.
+func (d *Datasource) checkFilters(ctx context.Context, pCtx backend.PluginContext, filters []interface{}) ([]string, error) {
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	meta, err := d.getCubeMetadata(ctx, pCtx)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Debug("Failed to fetch metadata for filter validation", "error", err)
+		return nil, nil
+	}
+	members := filterableMembers(meta)
+	if err := validateFilterOperators(filters, members); err != nil {
+		return nil, err
+	}
+	if err := validateMeasureFilters(filters, members); err != nil {
+		return nil, err
+	}
+	return measureFilterMembers(filters, members), nil
+}
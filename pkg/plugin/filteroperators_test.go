@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidFilterOperator(t *testing.T) {
+	tests := []struct {
+		memberType string
+		operator   string
+		want       bool
+	}{
+		{"string", "contains", true},
+		{"string", "gt", false},
+		{"number", "gte", true},
+		{"number", "startsWith", false},
+		{"time", "inDateRange", true},
+		{"time", "contains", false},
+		{"boolean", "equals", true},
+		{"boolean", "lt", false},
+		// Unknown types are filtered like strings.
+		{"geo", "contains", true},
+	}
+	for _, tt := range tests {
+		if got := validFilterOperator(tt.memberType, tt.operator); got != tt.want {
+			t.Errorf("validFilterOperator(%q, %q) = %v, want %v", tt.memberType, tt.operator, got, tt.want)
+		}
+	}
+}
+
+func TestMetadataFilterOperators(t *testing.T) {
+	metadata := (&Datasource{}).extractMetadataFromResponse(&CubeMetaResponse{})
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	var decoded struct {
+		FilterOperators map[string][]string `json:"filterOperators"`
+	}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	for _, memberType := range []string{"string", "number", "time", "boolean"} {
+		if !slices.Contains(decoded.FilterOperators[memberType], "set") {
+			t.Errorf("expected the %s operators to include set, got %v", memberType, decoded.FilterOperators[memberType])
+		}
+	}
+}
+
+func TestValidateFilterOperators(t *testing.T) {
+	members := filterableMembers(&CubeMetaResponse{Cubes: []CubeMeta{{
+		Name:       "orders",
+		Dimensions: []CubeDimension{{Name: "orders.users_age", Type: "number"}, {Name: "orders.status", Type: "string"}},
+		Measures:   []CubeMeasure{{Name: "orders.count", Type: "count"}},
+	}}})
+	tests := []struct {
+		name    string
+		filters string
+		wantErr string
+	}{
+		{name: "valid", filters: `[{"member": "orders.status", "operator": "contains", "values": ["ship"]}]`},
+		{name: "unknown member", filters: `[{"member": "orders.nope", "operator": "gt", "values": ["1"]}]`},
+		{
+			name:    "number dimension",
+			filters: `[{"member": "orders.users_age", "operator": "contains", "values": ["1"]}]`,
+			wantErr: "operator 'contains' is not valid for number dimension orders.users_age",
+		},
+		{
+			name:    "measure of an aggregation type",
+			filters: `[{"member": "orders.count", "operator": "startsWith", "values": ["1"]}]`,
+			wantErr: "operator 'startsWith' is not valid for number measure orders.count",
+		},
+		{
+			name:    "nested group",
+			filters: `[{"or": [{"member": "orders.status", "operator": "equals", "values": ["a"]}, {"and": [{"dimension": "orders.status", "operator": "gt", "values": ["a"]}]}]}]`,
+			wantErr: "operator 'gt' is not valid for string dimension orders.status",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filters []interface{}
+			if err := json.Unmarshal([]byte(tt.filters), &filters); err != nil {
+				t.Fatalf("invalid filters: %v", err)
+			}
+			err := validateFilterOperators(filters, members)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestQueryDataRejectsInvalidFilterOperator(t *testing.T) {
+	var loads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+			_, _ = w.Write([]byte(`{"cubes": [{"name": "orders", "dimensions": [{"name": "orders.users_age", "type": "number"}]}]}`))
+			return
+		}
+		loads++
+		_, _ = w.Write([]byte(`{"data": []}`))
+	}))
+	defer server.Close()
+
+	res := runSingleQuery(t, &Datasource{BaseURL: server.URL}, newTestPluginContext(server.URL), `{"refId":"A","dimensions":["orders.users_age"],
+		"filters":[{"member":"orders.users_age","operator":"contains","values":["3"]}]}`)
+	if res.Error == nil || !strings.Contains(res.Error.Error(), "operator 'contains' is not valid for number dimension orders.users_age") {
+		t.Fatalf("expected an invalid operator error, got %v", res.Error)
+	}
+	if loads != 0 {
+		t.Errorf("expected the query not to be sent, got %d loads", loads)
+	}
+}
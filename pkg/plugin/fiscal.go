@@ -0,0 +1,185 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// fiscalPeriodFieldName is the name of the field fiscalLabels adds.
+const fiscalPeriodFieldName = "fiscalPeriod"
+
+// fiscalDateRange matches the fiscal relative date ranges, which Cube does
+// not know: "this fiscal year", "last fiscal quarter", "next fiscal year"...
+var fiscalDateRange = regexp.MustCompile(`(?i)^\s*(this|last|next)\s+fiscal\s+(year|quarter)\s*$`)
+
+// fiscalYearStartOf returns the start of the fiscal year containing t, for
+// fiscal years starting in start.
+func fiscalYearStartOf(t time.Time, start time.Month) time.Time {
+	t = t.UTC()
+	year := t.Year()
+	if t.Month() < start {
+		year--
+	}
+	return time.Date(year, start, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// fiscalPeriodStart returns the start of the fiscal year or quarter (period)
+// containing t.
+func fiscalPeriodStart(t time.Time, start time.Month, period string) time.Time {
+	yearStart := fiscalYearStartOf(t, start)
+	if period != "quarter" {
+		return yearStart
+	}
+	months := (int(t.UTC().Month()) - int(start) + 12) % 12
+	return yearStart.AddDate(0, months/3*3, 0)
+}
+
+// fiscalPeriodLength returns the number of months of a fiscal period.
+func fiscalPeriodLength(period string) int {
+	if period == "quarter" {
+		return 3
+	}
+	return 12
+}
+
+// fiscalLabel labels the fiscal period starting at t, e.g. "FY25 Q2". Fiscal
+// years are named after the calendar year they end in.
+func fiscalLabel(t time.Time, start time.Month, period string) string {
+	yearStart := fiscalYearStartOf(t, start)
+	year := yearStart.Year()
+	if start != time.January {
+		year++
+	}
+	label := fmt.Sprintf("FY%02d", year%100)
+	if period == "quarter" {
+		months := (int(t.UTC().Month()) - int(start) + 12) % 12
+		label += fmt.Sprintf(" Q%d", months/3+1)
+	}
+	return label
+}
+
+// resolveFiscalDateRange returns the absolute date range of a fiscal relative
+// date range at now, and false when dateRange is not one.
+func resolveFiscalDateRange(dateRange interface{}, start time.Month, now time.Time) ([]string, bool) {
+	text, _ := dateRange.(string)
+	match := fiscalDateRange.FindStringSubmatch(text)
+	if match == nil {
+		return nil, false
+	}
+	period := strings.ToLower(match[2])
+	from := fiscalPeriodStart(now, start, period)
+	switch strings.ToLower(match[1]) {
+	case "last":
+		from = from.AddDate(0, -fiscalPeriodLength(period), 0)
+	case "next":
+		from = from.AddDate(0, fiscalPeriodLength(period), 0)
+	}
+	to := from.AddDate(0, fiscalPeriodLength(period), 0).Add(-time.Millisecond)
+	return cubeDateRange(from, to), true
+}
+
+// applyFiscalPeriods resolves the fiscal relative date ranges of the time
+// dimensions of apiQuery. With fiscalLabels, it also widens the date range
+// of the labeled time dimension to whole fiscal periods, so the first and
+// last buckets are not partial. An invalid fiscalYearStartMonth is only
+// reported to queries using fiscal periods.
+func applyFiscalPeriods(apiQuery map[string]interface{}, query CubeQuery, config *models.PluginSettings, now time.Time) error {
+	list, _ := apiQuery["timeDimensions"].([]interface{})
+	fiscal := query.FiscalLabels
+	for _, td := range timeDimensionList(apiQuery) {
+		text, _ := td["dateRange"].(string)
+		fiscal = fiscal || fiscalDateRange.MatchString(text)
+	}
+	if !fiscal {
+		return nil
+	}
+	start, err := config.FiscalYearStart()
+	if err != nil {
+		return err
+	}
+
+	adjusted := make([]interface{}, len(list))
+	labeled := false
+	for i, td := range list {
+		obj, ok := td.(map[string]interface{})
+		if !ok {
+			adjusted[i] = td
+			continue
+		}
+		obj = maps.Clone(obj)
+		adjusted[i] = obj
+		if dateRange, ok := resolveFiscalDateRange(obj["dateRange"], start, now); ok {
+			obj["dateRange"] = dateRange
+		}
+		granularity, _ := obj["granularity"].(string)
+		if !query.FiscalLabels || labeled || granularity == "" {
+			continue
+		}
+		if err := validateFiscalGranularity(granularity, start); err != nil {
+			return err
+		}
+		labeled = true
+		from, to, err := dateRangeBounds(obj["dateRange"])
+		if err != nil || to.IsZero() {
+			// Cube's own relative ranges are left as they are.
+			continue
+		}
+		from = fiscalPeriodStart(from, start, granularity)
+		to = fiscalPeriodStart(to, start, granularity).AddDate(0, fiscalPeriodLength(granularity), 0).Add(-time.Millisecond)
+		obj["dateRange"] = cubeDateRange(from, to)
+	}
+	if query.FiscalLabels && !labeled {
+		return errors.New("fiscalLabels needs a time dimension with a quarter or year granularity")
+	}
+	apiQuery["timeDimensions"] = adjusted
+	return nil
+}
+
+// validateFiscalGranularity checks that Cube's buckets of granularity are
+// fiscal periods: Cube's quarters and years are calendar ones, so fiscal
+// quarters need a fiscal year starting with a calendar quarter and fiscal
+// years one starting in January.
+func validateFiscalGranularity(granularity string, start time.Month) error {
+	switch granularity {
+	case "quarter":
+		if (start-1)%3 != 0 {
+			return fmt.Errorf("fiscal quarters need a fiscal year starting in January, April, July or October, not %s", start)
+		}
+	case "year":
+		if start != time.January {
+			return fmt.Errorf("year buckets are calendar years; use a quarter granularity for fiscal years starting in %s", start)
+		}
+	default:
+		return fmt.Errorf("fiscalLabels needs a quarter or year granularity, not %q", granularity)
+	}
+	return nil
+}
+
+// addFiscalLabels adds a fiscalPeriod field labeling the fiscal quarter or
+// year of each time bucket, after the time field.
+func addFiscalLabels(frame *data.Frame, apiQuery map[string]interface{}, timeRange backend.TimeRange, start time.Month) *data.Frame {
+	timeField, granularity, _, _ := fillTimeDimension(frame, apiQuery, timeRange)
+	if timeField < 0 {
+		return frame
+	}
+	labels := make([]*string, frame.Rows())
+	for row := range labels {
+		if v, ok := frame.Fields[timeField].ConcreteAt(row); ok {
+			label := fiscalLabel(v.(time.Time), start, granularity)
+			labels[row] = &label
+		}
+	}
+	fields := make([]*data.Field, 0, len(frame.Fields)+1)
+	fields = append(fields, frame.Fields[:timeField+1]...)
+	fields = append(fields, data.NewField(fiscalPeriodFieldName, nil, labels))
+	frame.Fields = append(fields, frame.Fields[timeField+1:]...)
+	return frame
+}
@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestFiscalLabel(t *testing.T) {
+	tests := []struct {
+		t      time.Time
+		start  time.Month
+		period string
+		want   string
+	}{
+		{time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.April, "quarter", "FY25 Q1"},
+		{time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), time.April, "quarter", "FY25 Q2"},
+		{time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.April, "quarter", "FY25 Q4"},
+		{time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.January, "quarter", "FY25 Q1"},
+		{time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.January, "year", "FY25"},
+	}
+	for _, tt := range tests {
+		if got := fiscalLabel(tt.t, tt.start, tt.period); got != tt.want {
+			t.Errorf("fiscalLabel(%s, %s, %s) = %s, want %s", tt.t.Format(time.DateOnly), tt.start, tt.period, got, tt.want)
+		}
+	}
+}
+
+func TestResolveFiscalDateRange(t *testing.T) {
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	tests := map[string][]string{
+		"this fiscal year":    {"2024-04-01T00:00:00.000", "2025-03-31T23:59:59.999"},
+		"Last Fiscal Year":    {"2023-04-01T00:00:00.000", "2024-03-31T23:59:59.999"},
+		"this fiscal quarter": {"2024-04-01T00:00:00.000", "2024-06-30T23:59:59.999"},
+		"last fiscal quarter": {"2024-01-01T00:00:00.000", "2024-03-31T23:59:59.999"},
+		"next fiscal quarter": {"2024-07-01T00:00:00.000", "2024-09-30T23:59:59.999"},
+	}
+	for dateRange, want := range tests {
+		got, ok := resolveFiscalDateRange(dateRange, time.April, now)
+		if !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", dateRange, want, got)
+		}
+	}
+	if _, ok := resolveFiscalDateRange("last year", time.April, now); ok {
+		t.Error("expected Cube's relative ranges to be left alone")
+	}
+}
+
+func TestApplyFiscalPeriodsErrors(t *testing.T) {
+	config := &models.PluginSettings{FiscalYearStartMonth: 2}
+	tests := map[string]string{
+		"misaligned quarters": `{"fiscalLabels": true, "timeDimensions": [{"dimension": "orders.created_at", "granularity": "quarter"}]}`,
+		"fiscal year buckets": `{"fiscalLabels": true, "timeDimensions": [{"dimension": "orders.created_at", "granularity": "year"}]}`,
+		"no granularity":      `{"fiscalLabels": true, "timeDimensions": [{"dimension": "orders.created_at"}]}`,
+	}
+	for name, queryJSON := range tests {
+		t.Run(name, func(t *testing.T) {
+			var query CubeQuery
+			if err := json.Unmarshal([]byte(queryJSON), &query); err != nil {
+				t.Fatal(err)
+			}
+			apiQuery := map[string]interface{}{"timeDimensions": query.TimeDimensions}
+			if err := applyFiscalPeriods(apiQuery, query, config, time.Now()); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	invalid := &models.PluginSettings{FiscalYearStartMonth: 13}
+	apiQuery := map[string]interface{}{"timeDimensions": []interface{}{map[string]interface{}{"dimension": "orders.created_at", "dateRange": "this fiscal year"}}}
+	if err := applyFiscalPeriods(apiQuery, CubeQuery{}, invalid, time.Now()); err == nil {
+		t.Error("expected an invalid fiscalYearStartMonth to be reported")
+	}
+	if err := applyFiscalPeriods(map[string]interface{}{}, CubeQuery{}, invalid, time.Now()); err != nil {
+		t.Errorf("expected queries without fiscal periods to ignore the setting, got %v", err)
+	}
+}
+
+func TestQueryDataFiscalLabels(t *testing.T) {
+	var sent map[string]interface{}
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("query")), &sent); err != nil {
+			t.Errorf("invalid query: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [
+			{"orders.created_at.quarter": "2024-04-01T00:00:00.000", "orders.count": "1"},
+			{"orders.created_at.quarter": "2024-07-01T00:00:00.000", "orders.count": "2"}],
+			"annotation": {"measures": {"orders.count": {"type": "number"}}, "timeDimensions": {"orders.created_at.quarter": {"type": "time"}}}}`))
+	}))
+	defer server.Close()
+
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "fiscalYearStartMonth": 4}`)
+	resp := runSingleQuery(t, &Datasource{}, pCtx, `{"refId": "A", "fiscalLabels": true,
+		"measures": ["orders.count"], "dimensions": ["orders.created_at.quarter"],
+		"timeDimensions": [{"dimension": "orders.created_at", "granularity": "quarter", "dateRange": ["2024-05-10", "2024-08-20"]}]}`)
+	if resp.Error != nil {
+		t.Fatalf("unexpected error: %v", resp.Error)
+	}
+
+	dateRange, _ := json.Marshal(sent["timeDimensions"].([]interface{})[0].(map[string]interface{})["dateRange"])
+	if want := `["2024-04-01T00:00:00.000","2024-09-30T23:59:59.999"]`; string(dateRange) != want {
+		t.Errorf("expected the date range widened to whole quarters, got %s", dateRange)
+	}
+
+	frame := resp.Frames[0]
+	field, _ := frame.FieldByName(fiscalPeriodFieldName)
+	if field == nil || field.Len() != 2 {
+		t.Fatalf("expected a fiscalPeriod field with 2 labels, got %v", frame.Fields)
+	}
+	for i, want := range []string{"FY25 Q1", "FY25 Q2"} {
+		if got, _ := field.ConcreteAt(i); got != want {
+			t.Errorf("row %d: expected %s, got %v", i, want, got)
+		}
+	}
+}
+
+func TestQueryDataFiscalLabelsValidation(t *testing.T) {
+	res := runSingleQuery(t, &Datasource{}, newTestPluginContext("http://localhost:4000"), `{"refId": "A", "fiscalLabels": true,
+		"measures": ["orders.count"], "timeDimensions": [{"dimension": "orders.created_at", "granularity": "month"}]}`)
+	if res.Status != backend.StatusBadRequest {
+		t.Errorf("expected 400, got %v: %v", res.Status, res.Error)
+	}
+}
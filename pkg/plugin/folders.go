@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"encoding/json"
+)
+
+// CubeFolder is a folder of a Cube view, grouping its members the way the
+// modeler organized them. In /v1/meta, "folders" lists the folders with their
+// members flattened and "nestedFolders" lists them with their subfolders.
+type CubeFolder struct {
+	Name    string             `json:"name"`
+	Members []CubeFolderMember `json:"members"`
+}
+
+// CubeFolderMember is a member of a folder: a member name, or a subfolder.
+type CubeFolderMember struct {
+	Name   string
+	Folder *CubeFolder
+}
+
+// UnmarshalJSON decodes a folder member from a member name or a folder
+// object.
+func (m *CubeFolderMember) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &m.Name); err == nil {
+		return nil
+	}
+	m.Folder = &CubeFolder{}
+	return json.Unmarshal(b, m.Folder)
+}
+
+// MarshalJSON encodes a folder member the way Cube does.
+func (m CubeFolderMember) MarshalJSON() ([]byte, error) {
+	if m.Folder != nil {
+		return json.Marshal(m.Folder)
+	}
+	return json.Marshal(m.Name)
+}
+
+// CubeHierarchy is a hierarchy of a cube or view: dimensions ordered from
+// the coarsest to the finest level.
+type CubeHierarchy struct {
+	Name   string   `json:"name"`
+	Title  string   `json:"title"`
+	Levels []string `json:"levels"`
+}
+
+// MetadataFolder is a folder of a view in the metadata response.
+type MetadataFolder struct {
+	Name string `json:"name"`
+	// Cube is the view the folder belongs to.
+	Cube    string           `json:"cube"`
+	Members []string         `json:"members"`
+	Folders []MetadataFolder `json:"folders,omitempty"`
+}
+
+// MetadataHierarchy is a hierarchy of a view in the metadata response.
+type MetadataHierarchy struct {
+	Name  string `json:"name"`
+	Title string `json:"title,omitempty"`
+	// Cube is the view the hierarchy belongs to.
+	Cube   string   `json:"cube"`
+	Levels []string `json:"levels"`
+}
+
+// viewFolders returns the folder tree of a view, from its nested folders
+// when Cube reports them and from its flat folders otherwise.
+func viewFolders(view CubeMeta) []MetadataFolder {
+	folders := view.NestedFolders
+	if len(folders) == 0 {
+		folders = view.Folders
+	}
+	return metadataFolders(view.Name, folders)
+}
+
+func metadataFolders(view string, folders []CubeFolder) []MetadataFolder {
+	if len(folders) == 0 {
+		return nil
+	}
+	result := make([]MetadataFolder, 0, len(folders))
+	for _, folder := range folders {
+		mf := MetadataFolder{Name: folder.Name, Cube: view, Members: []string{}}
+		var subfolders []CubeFolder
+		for _, member := range folder.Members {
+			if member.Folder != nil {
+				subfolders = append(subfolders, *member.Folder)
+				continue
+			}
+			mf.Members = append(mf.Members, member.Name)
+		}
+		mf.Folders = metadataFolders(view, subfolders)
+		result = append(result, mf)
+	}
+	return result
+}
+
+// viewHierarchies returns the hierarchies of a view.
+func viewHierarchies(view CubeMeta) []MetadataHierarchy {
+	result := make([]MetadataHierarchy, 0, len(view.Hierarchies))
+	for _, h := range view.Hierarchies {
+		result = append(result, MetadataHierarchy{Name: h.Name, Title: h.Title, Cube: view.Name, Levels: h.Levels})
+	}
+	return result
+}
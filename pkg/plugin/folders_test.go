@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExtractMetadataFoldersAndHierarchies(t *testing.T) {
+	var meta CubeMetaResponse
+	if err := json.Unmarshal([]byte(`{"cubes": [
+		{"name": "orders_view", "type": "view",
+			"dimensions": [{"name": "orders_view.status", "type": "string"}, {"name": "orders_view.city", "type": "string"}, {"name": "orders_view.country", "type": "string"}],
+			"folders": [{"name": "Location", "members": ["orders_view.city", "orders_view.country"]}],
+			"nestedFolders": [
+				{"name": "Details", "members": ["orders_view.status", {"name": "Location", "members": ["orders_view.city", "orders_view.country"]}]}
+			],
+			"hierarchies": [{"name": "orders_view.location", "title": "Location", "levels": ["orders_view.country", "orders_view.city"]}]},
+		{"name": "users_view", "type": "view",
+			"dimensions": [{"name": "users_view.name", "type": "string"}],
+			"folders": [{"name": "People", "members": ["users_view.name"]}]},
+		{"name": "orders", "type": "cube",
+			"hierarchies": [{"name": "orders.location", "levels": ["orders.country"]}]}
+	]}`), &meta); err != nil {
+		t.Fatalf("failed to parse meta: %v", err)
+	}
+
+	metadata := (&Datasource{}).extractMetadataFromResponse(&meta)
+
+	wantFolders := []MetadataFolder{
+		{Name: "Details", Cube: "orders_view", Members: []string{"orders_view.status"}, Folders: []MetadataFolder{
+			{Name: "Location", Cube: "orders_view", Members: []string{"orders_view.city", "orders_view.country"}},
+		}},
+		{Name: "People", Cube: "users_view", Members: []string{"users_view.name"}},
+	}
+	if !reflect.DeepEqual(metadata.Folders, wantFolders) {
+		t.Errorf("expected folders %+v, got %+v", wantFolders, metadata.Folders)
+	}
+
+	wantHierarchies := []MetadataHierarchy{
+		{Name: "orders_view.location", Title: "Location", Cube: "orders_view", Levels: []string{"orders_view.country", "orders_view.city"}},
+	}
+	if !reflect.DeepEqual(metadata.Hierarchies, wantHierarchies) {
+		t.Errorf("expected hierarchies of views only %+v, got %+v", wantHierarchies, metadata.Hierarchies)
+	}
+}
+
+func TestExtractMetadataWithoutFolders(t *testing.T) {
+	metadata := (&Datasource{}).extractMetadataFromResponse(&CubeMetaResponse{Cubes: []CubeMeta{
+		{Name: "orders_view", Type: "view", Dimensions: []CubeDimension{{Name: "orders_view.status", Type: "string"}}},
+	}})
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	var fields map[string]interface{}
+	_ = json.Unmarshal(body, &fields)
+	if _, ok := fields["folders"]; ok {
+		t.Error("expected folders to be omitted")
+	}
+	if _, ok := fields["hierarchies"]; ok {
+		t.Error("expected hierarchies to be omitted")
+	}
+}
+
+func TestCubeFolderMemberRoundTrip(t *testing.T) {
+	in := `{"name":"Details","members":["orders_view.status",{"name":"Location","members":["orders_view.city"]}]}`
+	var folder CubeFolder
+	if err := json.Unmarshal([]byte(in), &folder); err != nil {
+		t.Fatalf("failed to parse folder: %v", err)
+	}
+	out, err := json.Marshal(folder)
+	if err != nil {
+		t.Fatalf("failed to marshal folder: %v", err)
+	}
+	if string(out) != in {
+		t.Errorf("expected %s, got %s", in, out)
+	}
+}
@@ -0,0 +1,298 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// buildFrame converts Cube result rows into a frame with one field per query
+// member: dimensions first, then measures, each in query order. Fields are
+// typed from the annotation and filled directly into preallocated slices
+// (values point into one backing array per field rather than being allocated
+// one by one):
+//
+//   - time dimensions (and dimensions annotated "time") become nullable
+//     time fields, parsed from Cube's timestamp strings, epoch seconds or
+//     milliseconds, or one of the datasource's timeLayouts; values that
+//     cannot be parsed become null and add a warning notice to the frame;
+//   - members annotated "number" become nullable float64 fields, parsing the
+//     numeric strings Cube returns; values that are not numbers become null;
+//   - members annotated "boolean" become nullable bool fields, parsing the
+//     "true"/"false" strings Cube returns (or 1/0 float64 fields when the
+//     query sets booleansAsNumbers, for stat panels); values that are not
+//     booleans become null;
+//   - other members take the type of their first non-null value (string,
+//     bool or float64).
+//
+// A member with no non-null value in any row (Cube omits such keys) gets a
+// null field typed from the annotation; see createNullField.
+func (d *Datasource) buildFrame(name string, rows []map[string]interface{}, query CubeQuery, annotation CubeAnnotation, timeLayouts []string) *data.Frame {
+	frame := data.NewFrame(name)
+	frame.Fields = make([]*data.Field, 0, len(query.Dimensions)+len(query.Measures))
+	var notices []data.Notice
+
+	built := make(map[string]*data.Field, len(query.Dimensions)+len(query.Measures))
+	for _, members := range [][]string{query.Dimensions, query.Measures} {
+		for _, member := range members {
+			field, ok := built[member]
+			if !ok {
+				if isTimeMember(member, annotation) {
+					var unparsed int
+					field, unparsed = d.buildTimeField(member, rows, annotation, timeLayouts)
+					if unparsed > 0 {
+						notices = append(notices, data.Notice{
+							Severity: data.NoticeSeverityWarning,
+							Text:     fmt.Sprintf("%d value(s) of %s could not be parsed as time and are shown as empty; add their format to the datasource's time layouts", unparsed, member),
+						})
+					}
+				} else {
+					field = d.buildField(member, rows, annotation, query.BooleansAsNumbers)
+				}
+				built[member] = field
+			}
+			frame.Fields = append(frame.Fields, field)
+		}
+	}
+	if len(notices) > 0 {
+		frame.AppendNotices(notices...)
+	}
+	return frame
+}
+
+// buildTimeField builds the field for a time member and counts the non-null
+// values it could not parse; see buildFrame.
+func (d *Datasource) buildTimeField(member string, rows []map[string]interface{}, annotation CubeAnnotation, layouts []string) (*data.Field, int) {
+	values := make([]*time.Time, len(rows))
+	backing := make([]time.Time, len(rows))
+	found := false
+	unparsed := 0
+	for i, row := range rows {
+		value := row[member]
+		if value == nil {
+			continue
+		}
+		found = true
+		var t time.Time
+		ok := false
+		switch v := value.(type) {
+		case string:
+			t, ok = parseCubeTime(v, layouts...)
+		case float64:
+			t, ok = epochTime(v), true
+		}
+		if !ok {
+			unparsed++
+			continue
+		}
+		backing[i] = t
+		values[i] = &backing[i]
+	}
+	if !found {
+		return d.createNullField(member, len(rows), annotation), 0
+	}
+	return data.NewField(member, nil, values), unparsed
+}
+
+// buildField builds the field for a single member that is not a time member;
+// see buildFrame.
+func (d *Datasource) buildField(member string, rows []map[string]interface{}, annotation CubeAnnotation, booleansAsNumbers bool) *data.Field {
+	if memberType(member, annotation) == "number" {
+		values := make([]*float64, len(rows))
+		backing := make([]float64, len(rows))
+		found := false
+		for i, row := range rows {
+			value := row[member]
+			if value == nil {
+				continue
+			}
+			found = true
+			// Cube returns numbers as strings; parse them without going
+			// through convertToNumber's interface result to save an
+			// allocation per value.
+			switch v := value.(type) {
+			case string:
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					backing[i] = f
+					values[i] = &backing[i]
+				}
+			default:
+				if f, ok := d.convertToNumber(v).(float64); ok {
+					backing[i] = f
+					values[i] = &backing[i]
+				}
+			}
+		}
+		if !found {
+			return d.createNullField(member, len(rows), annotation)
+		}
+		return data.NewField(member, nil, values)
+	}
+
+	if memberType(member, annotation) == "boolean" {
+		return d.buildBooleanField(member, rows, annotation, booleansAsNumbers)
+	}
+
+	// Untyped (or non-numeric) members: the first non-null value decides.
+	for _, row := range rows {
+		switch row[member].(type) {
+		case string:
+			return data.NewField(member, nil, columnValues[string](member, rows))
+		case bool:
+			return data.NewField(member, nil, columnValues[bool](member, rows))
+		case float64:
+			return data.NewField(member, nil, columnValues[float64](member, rows))
+		}
+	}
+	return d.createNullField(member, len(rows), annotation)
+}
+
+// buildBooleanField builds the field for a member annotated "boolean"; see
+// buildFrame.
+func (d *Datasource) buildBooleanField(member string, rows []map[string]interface{}, annotation CubeAnnotation, asNumbers bool) *data.Field {
+	values := make([]*bool, len(rows))
+	backing := make([]bool, len(rows))
+	found := false
+	for i, row := range rows {
+		value := row[member]
+		if value == nil {
+			continue
+		}
+		found = true
+		if b, ok := parseCubeBool(value); ok {
+			backing[i] = b
+			values[i] = &backing[i]
+		}
+	}
+	if !asNumbers {
+		if !found {
+			return d.createNullField(member, len(rows), annotation)
+		}
+		return data.NewField(member, nil, values)
+	}
+
+	numbers := make([]*float64, len(rows))
+	numberBacking := make([]float64, len(rows))
+	for i, b := range values {
+		if b == nil {
+			continue
+		}
+		if *b {
+			numberBacking[i] = 1
+		}
+		numbers[i] = &numberBacking[i]
+	}
+	return data.NewField(member, nil, numbers)
+}
+
+// parseCubeBool parses a boolean value as Cube returns it: usually the
+// string "true" or "false", depending on the database also "t"/"f", "1"/"0"
+// or a JSON boolean or number.
+func parseCubeBool(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case string:
+		switch v {
+		case "true", "TRUE", "True", "t", "1":
+			return true, true
+		case "false", "FALSE", "False", "f", "0":
+			return false, true
+		}
+	case float64:
+		switch v {
+		case 1:
+			return true, true
+		case 0:
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// columnValues collects a member's values of type T; values of other types
+// are null.
+func columnValues[T any](member string, rows []map[string]interface{}) []*T {
+	values := make([]*T, len(rows))
+	backing := make([]T, len(rows))
+	for i, row := range rows {
+		if v, ok := row[member].(T); ok {
+			backing[i] = v
+			values[i] = &backing[i]
+		}
+	}
+	return values
+}
+
+// memberType returns the annotated type of a member, or "" when Cube did not
+// annotate it.
+func memberType(member string, annotation CubeAnnotation) string {
+	for _, infos := range []map[string]CubeFieldInfo{annotation.Dimensions, annotation.Measures, annotation.TimeDimensions, annotation.Segments} {
+		if info, ok := infos[member]; ok {
+			return info.Type
+		}
+	}
+	return ""
+}
+
+// isTimeMember reports whether a member's values are timestamps: a time
+// dimension, or a regular dimension of type "time" (a date field queried
+// without granularity).
+func isTimeMember(member string, annotation CubeAnnotation) bool {
+	if info, ok := annotation.TimeDimensions[member]; ok && info.Type == "time" {
+		return true
+	}
+	info, ok := annotation.Dimensions[member]
+	return ok && info.Type == "time"
+}
+
+// cubeTimeLayouts are the timestamp formats Cube returns, tried in order.
+// RFC 3339 covers numeric offsets ("+02:00") and fractional seconds.
+var cubeTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05.000Z",
+	"2006-01-02T15:04:05.000",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05.000",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseCubeTime parses a Cube timestamp string: one of cubeTimeLayouts, then
+// one of the extra layouts, then a year ("2024") or epoch seconds or
+// milliseconds. Empty and unparseable values report false.
+func parseCubeTime(s string, layouts ...string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range cubeTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	if len(s) == 4 {
+		if t, err := time.Parse("2006", s); err == nil {
+			return t, true
+		}
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return epochTime(n), true
+	}
+	return time.Time{}, false
+}
+
+// epochTime converts a Unix timestamp to a time. Values beyond what epoch
+// seconds reach before the year 5138 are taken as milliseconds.
+func epochTime(n float64) time.Time {
+	if n >= 1e11 || n <= -1e11 {
+		return time.UnixMilli(int64(n)).UTC()
+	}
+	return time.Unix(int64(n), 0).UTC()
+}
@@ -0,0 +1,290 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/framestruct"
+)
+
+func TestParseCubeTime(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // RFC3339, or empty when parsing should fail
+	}{
+		{input: "2024-01-15T10:30:00Z", expected: "2024-01-15T10:30:00Z"},
+		{input: "2024-01-15T10:30:00.123Z", expected: "2024-01-15T10:30:00Z"},
+		{input: "2018-01-01T00:00:00.000", expected: "2018-01-01T00:00:00Z"},
+		{input: "2024-01-15", expected: "2024-01-15T00:00:00Z"},
+		{input: "2024-01-15T12:30:00+02:00", expected: "2024-01-15T10:30:00Z"},
+		{input: "2024-01-15 10:30:00", expected: "2024-01-15T10:30:00Z"},
+		{input: "2024-01-15 10:30:00.250", expected: "2024-01-15T10:30:00Z"},
+		{input: "2024", expected: "2024-01-01T00:00:00Z"},
+		{input: "1705314600", expected: "2024-01-15T10:30:00Z"},
+		{input: "1705314600000", expected: "2024-01-15T10:30:00Z"},
+		{input: "not-a-date"},
+		{input: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := parseCubeTime(tt.input)
+			if tt.expected == "" {
+				if ok {
+					t.Errorf("expected %q not to parse, got %v", tt.input, got)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("expected %q to parse", tt.input)
+			}
+			if actual := got.UTC().Format(time.RFC3339); actual != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBuildFrameTimeMembers(t *testing.T) {
+	ds := &Datasource{}
+
+	rows := []map[string]interface{}{
+		{"orders.created_at": "2024-01-15T10:30:00Z", "orders.order_date": "2018-01-01T00:00:00.000", "orders.status": "completed"},
+		{"orders.created_at": nil, "orders.order_date": "not-a-date", "orders.status": "pending"},
+		{"orders.created_at": "2024-02-20", "orders.order_date": true, "orders.status": "pending"},
+	}
+	annotation := CubeAnnotation{
+		TimeDimensions: map[string]CubeFieldInfo{"orders.created_at": {Type: "time"}},
+		// A date field used as a regular dimension (queried without granularity)
+		Dimensions: map[string]CubeFieldInfo{
+			"orders.order_date": {Type: "time"},
+			"orders.status":     {Type: "string"},
+		},
+	}
+	query := CubeQuery{Dimensions: []string{"orders.created_at", "orders.order_date", "orders.status"}}
+
+	frame := ds.buildFrame("response", rows, query, annotation, nil)
+
+	for _, name := range []string{"orders.created_at", "orders.order_date"} {
+		field, _ := frame.FieldByName(name)
+		if field == nil || field.Type() != data.FieldTypeNullableTime {
+			t.Fatalf("expected %s to be a nullable time field, got %v", name, field)
+		}
+	}
+	if frame.Fields[2].Type() != data.FieldTypeNullableString {
+		t.Errorf("expected orders.status to remain a nullable string, got %s", frame.Fields[2].Type())
+	}
+
+	expected := map[string][]string{
+		"orders.created_at": {"2024-01-15T10:30:00Z", "", "2024-02-20T00:00:00Z"},
+		// Unparseable strings and non-time values become null
+		"orders.order_date": {"2018-01-01T00:00:00Z", "", ""},
+	}
+	for name, want := range expected {
+		field, _ := frame.FieldByName(name)
+		for i, w := range want {
+			got := field.At(i).(*time.Time)
+			switch {
+			case w == "" && got != nil:
+				t.Errorf("%s[%d]: expected null, got %v", name, i, got)
+			case w != "" && (got == nil || got.UTC().Format(time.RFC3339) != w):
+				t.Errorf("%s[%d]: expected %s, got %v", name, i, w, got)
+			}
+		}
+	}
+
+	if frame.Meta == nil || len(frame.Meta.Notices) != 1 || !strings.Contains(frame.Meta.Notices[0].Text, "2 value(s) of orders.order_date") {
+		t.Errorf("expected a notice about the unparseable orders.order_date values, got %+v", frame.Meta)
+	}
+}
+
+func TestBuildFrameCustomTimeLayouts(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"orders.shipped_at": "15/01/2024 10:30"},
+		{"orders.shipped_at": float64(1705314600000)},
+	}
+	annotation := CubeAnnotation{Dimensions: map[string]CubeFieldInfo{"orders.shipped_at": {Type: "time"}}}
+	query := CubeQuery{Dimensions: []string{"orders.shipped_at"}}
+
+	frame := (&Datasource{}).buildFrame("response", rows, query, annotation, []string{"02/01/2006 15:04"})
+	for i := range rows {
+		got := frame.Fields[0].At(i).(*time.Time)
+		if got == nil || got.UTC().Format(time.RFC3339) != "2024-01-15T10:30:00Z" {
+			t.Errorf("row %d: expected 2024-01-15T10:30:00Z, got %v", i, got)
+		}
+	}
+	if frame.Meta != nil && len(frame.Meta.Notices) > 0 {
+		t.Errorf("expected no notices, got %+v", frame.Meta.Notices)
+	}
+}
+
+func TestBuildFrameTypesAndOrder(t *testing.T) {
+	ds := &Datasource{}
+
+	rows := []map[string]interface{}{
+		{"orders.count": "10", "orders.avg": 2.5, "orders.status": "completed", "orders.is_paid": true, "orders.raw": 7.0},
+		{"orders.count": "n/a", "orders.status": nil, "orders.is_paid": false, "orders.raw": "x"},
+	}
+	annotation := CubeAnnotation{
+		Measures: map[string]CubeFieldInfo{
+			"orders.count": {Type: "number"},
+			"orders.avg":   {Type: "number"},
+			"orders.empty": {Type: "number"},
+		},
+		Dimensions: map[string]CubeFieldInfo{
+			"orders.status":  {Type: "string"},
+			"orders.is_paid": {Type: "boolean"},
+		},
+	}
+	query := CubeQuery{
+		Dimensions: []string{"orders.status", "orders.is_paid", "orders.raw"},
+		Measures:   []string{"orders.count", "orders.avg", "orders.empty"},
+	}
+
+	frame := ds.buildFrame("response", rows, query, annotation, nil)
+
+	wantOrder := []string{"orders.status", "orders.is_paid", "orders.raw", "orders.count", "orders.avg", "orders.empty"}
+	wantTypes := []data.FieldType{
+		data.FieldTypeNullableString, data.FieldTypeNullableBool, data.FieldTypeNullableFloat64,
+		data.FieldTypeNullableFloat64, data.FieldTypeNullableFloat64, data.FieldTypeNullableFloat64,
+	}
+	if len(frame.Fields) != len(wantOrder) {
+		t.Fatalf("expected %d fields, got %d", len(wantOrder), len(frame.Fields))
+	}
+	for i, field := range frame.Fields {
+		if field.Name != wantOrder[i] || field.Type() != wantTypes[i] {
+			t.Errorf("field %d: expected %s (%s), got %s (%s)", i, wantOrder[i], wantTypes[i], field.Name, field.Type())
+		}
+		if field.Len() != len(rows) {
+			t.Errorf("field %s: expected %d values, got %d", field.Name, len(rows), field.Len())
+		}
+	}
+
+	// Numeric strings are parsed; non-numeric values of number members and
+	// values not matching the inferred type become null.
+	assertFloats(t, "orders.count", nullableFloats(t, frame.Fields[3]), []*float64{floatPtr(10), nil})
+	assertFloats(t, "orders.avg", nullableFloats(t, frame.Fields[4]), []*float64{floatPtr(2.5), nil})
+	assertFloats(t, "orders.raw", nullableFloats(t, frame.Fields[2]), []*float64{floatPtr(7), nil})
+	assertFloats(t, "orders.empty", nullableFloats(t, frame.Fields[5]), []*float64{nil, nil})
+}
+
+func TestBuildFrameBooleanMembers(t *testing.T) {
+	ds := &Datasource{}
+
+	rows := []map[string]interface{}{
+		{"orders.is_paid": "true", "orders.is_gift": nil},
+		{"orders.is_paid": "false"},
+		{"orders.is_paid": true},
+		{"orders.is_paid": "maybe"},
+		{"orders.is_paid": nil},
+	}
+	annotation := CubeAnnotation{Dimensions: map[string]CubeFieldInfo{
+		"orders.is_paid": {Type: "boolean"},
+		"orders.is_gift": {Type: "boolean"},
+	}}
+	query := CubeQuery{Dimensions: []string{"orders.is_paid", "orders.is_gift"}}
+
+	frame := ds.buildFrame("response", rows, query, annotation, nil)
+	for _, field := range frame.Fields {
+		if field.Type() != data.FieldTypeNullableBool {
+			t.Fatalf("field %s: expected %s, got %s", field.Name, data.FieldTypeNullableBool, field.Type())
+		}
+	}
+	want := []string{"true", "false", "true", "null", "null"}
+	for i, w := range want {
+		got := "null"
+		if v := frame.Fields[0].At(i).(*bool); v != nil {
+			got = fmt.Sprint(*v)
+		}
+		if got != w {
+			t.Errorf("row %d: expected %s, got %s", i, w, got)
+		}
+	}
+
+	query.BooleansAsNumbers = true
+	frame = ds.buildFrame("response", rows, query, annotation, nil)
+	assertFloats(t, "orders.is_paid", nullableFloats(t, frame.Fields[0]), []*float64{floatPtr(1), floatPtr(0), floatPtr(1), nil, nil})
+	assertFloats(t, "orders.is_gift", nullableFloats(t, frame.Fields[1]), []*float64{nil, nil, nil, nil, nil})
+}
+
+// benchmarkRows returns n rows shaped like a typical Cube time series
+// response: a time dimension, a string dimension and two numeric-string
+// measures.
+func benchmarkRows(n int) ([]map[string]interface{}, CubeQuery, CubeAnnotation) {
+	rows := make([]map[string]interface{}, n)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	statuses := []string{"completed", "pending", "shipped"}
+	for i := range rows {
+		rows[i] = map[string]interface{}{
+			"orders.created_at.day": start.Add(time.Duration(i) * time.Hour).Format("2006-01-02T15:04:05.000"),
+			"orders.status":         statuses[i%len(statuses)],
+			"orders.count":          fmt.Sprint(i),
+			"orders.total":          fmt.Sprintf("%d.%02d", i*3, i%100),
+		}
+	}
+	query := CubeQuery{
+		Dimensions: []string{"orders.created_at.day", "orders.status"},
+		Measures:   []string{"orders.count", "orders.total"},
+	}
+	annotation := CubeAnnotation{
+		Measures: map[string]CubeFieldInfo{
+			"orders.count": {Type: "number"},
+			"orders.total": {Type: "number"},
+		},
+		Dimensions:     map[string]CubeFieldInfo{"orders.status": {Type: "string"}},
+		TimeDimensions: map[string]CubeFieldInfo{"orders.created_at.day": {Type: "time"}},
+	}
+	return rows, query, annotation
+}
+
+func BenchmarkBuildFrame(b *testing.B) {
+	ds := &Datasource{}
+	rows, query, annotation := benchmarkRows(100_000)
+	b.ReportAllocs()
+	for b.Loop() {
+		ds.buildFrame("response", rows, query, annotation, nil)
+	}
+}
+
+// BenchmarkFramestructConversion measures the previous conversion (numeric
+// strings converted row by row, then framestruct.ToDataFrame, then time
+// parsing) for comparison with BenchmarkBuildFrame.
+func BenchmarkFramestructConversion(b *testing.B) {
+	ds := &Datasource{}
+	rows, _, annotation := benchmarkRows(100_000)
+	b.ReportAllocs()
+	for b.Loop() {
+		converted := make([]map[string]interface{}, len(rows))
+		for i, row := range rows {
+			convertedRow := make(map[string]interface{}, len(row))
+			for name, value := range row {
+				if memberType(name, annotation) == "number" {
+					convertedRow[name] = ds.convertToNumber(value)
+				} else {
+					convertedRow[name] = value
+				}
+			}
+			converted[i] = convertedRow
+		}
+		frame, err := framestruct.ToDataFrame("response", converted)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, field := range frame.Fields {
+			if !isTimeMember(field.Name, annotation) {
+				continue
+			}
+			values := make([]*time.Time, field.Len())
+			for i := range values {
+				if s, ok := field.At(i).(*string); ok && s != nil {
+					if t, ok := parseCubeTime(*s); ok {
+						values[i] = &t
+					}
+				}
+			}
+		}
+	}
+}
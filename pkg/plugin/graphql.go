@@ -0,0 +1,533 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// usesGraphQL reports whether the datasource sends panel queries to Cube's
+// GraphQL API instead of /v1/load.
+func usesGraphQL(config *models.PluginSettings) bool {
+	return config != nil && config.QueryTransport == models.QueryTransportGraphQL
+}
+
+// graphQLURL returns the URL of Cube's GraphQL endpoint from the /v1/load URL.
+func graphQLURL(loadURL string) string {
+	return strings.TrimSuffix(loadURL, "v1/load") + "graphql"
+}
+
+// graphQLNamePattern matches a valid GraphQL name. Member names and
+// granularities are written into the GraphQL document as field names, so
+// anything else is rejected rather than escaped.
+var graphQLNamePattern = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// graphQLName returns the GraphQL name Cube gives a cube or member name:
+// snake_case names are camelCased, e.g. line_items.created_at becomes
+// lineItems.createdAt.
+func graphQLName(name string) string {
+	parts := strings.Split(name, "_")
+	var b strings.Builder
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(part)
+		if i == 0 || b.Len() == 0 {
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(unicode.ToUpper(r))
+		}
+		b.WriteString(part[size:])
+	}
+	return b.String()
+}
+
+// graphQLField is a field selected on a cube, and the member it is returned
+// as in result rows.
+type graphQLField struct {
+	name string
+	// granularity selects a time dimension's sub-field; empty for other
+	// members.
+	granularity string
+	member      string
+	measure     bool
+}
+
+// graphQLCube is a cube queried in a GraphQL query.
+type graphQLCube struct {
+	name    string
+	fields  []graphQLField
+	orderBy []string
+}
+
+// graphQLCompiler translates a Cube query into a GraphQL query on Cube's
+// cube root field: one selection per cube with its members, filters and
+// time dimension date ranges in the root where argument and the order in
+// each cube's orderBy argument.
+type graphQLCompiler struct {
+	cubes    []*graphQLCube
+	byName   map[string]*graphQLCube
+	measures map[string]bool
+}
+
+// compileGraphQLQuery compiles a Cube /v1/load query JSON into a GraphQL
+// query and the cubes it selects, to convert the result back into rows.
+// Filters on number dimensions are sent as strings, as the member types are
+// not known here; filters on measures are sent as numbers.
+func compileGraphQLQuery(queryJSON []byte) (string, []*graphQLCube, error) {
+	var q sqlAPIQuery
+	if err := json.Unmarshal(queryJSON, &q); err != nil {
+		return "", nil, err
+	}
+	if len(q.Segments) > 0 {
+		return "", nil, errors.New("segments are not supported by the GraphQL transport")
+	}
+	c := &graphQLCompiler{byName: make(map[string]*graphQLCube), measures: make(map[string]bool)}
+
+	var where []string
+	granularities := make(map[string]string)
+	for _, td := range q.TimeDimensions {
+		cube, field, err := c.member(td.Dimension)
+		if err != nil {
+			return "", nil, err
+		}
+		if td.Granularity != "" {
+			if !graphQLNamePattern.MatchString(td.Granularity) {
+				return "", nil, fmt.Errorf("invalid granularity %q for %s", td.Granularity, td.Dimension)
+			}
+			granularities[td.Dimension] = td.Granularity
+			c.selectField(cube, graphQLField{name: field, granularity: td.Granularity, member: td.Dimension + "." + td.Granularity})
+		}
+		if td.DateRange != nil {
+			value, err := graphQLValue(td.DateRange, false)
+			if err != nil {
+				return "", nil, err
+			}
+			where = append(where, fmt.Sprintf("{%s: {%s: {inDateRange: %s}}}", cube.name, field, value))
+		}
+	}
+	for _, dimension := range q.Dimensions {
+		// A time dimension is selected with a granularity sub-field, also
+		// when queried as a plain dimension.
+		member, granularity := dimension, granularities[dimension]
+		if parts := strings.Split(dimension, "."); len(parts) == 3 {
+			member, granularity = parts[0]+"."+parts[1], parts[2]
+		}
+		if granularity != "" && !graphQLNamePattern.MatchString(granularity) {
+			return "", nil, fmt.Errorf("invalid granularity %q for %s", granularity, member)
+		}
+		cube, field, err := c.member(member)
+		if err != nil {
+			return "", nil, err
+		}
+		c.selectField(cube, graphQLField{name: field, granularity: granularity, member: dimension})
+	}
+	for _, measure := range q.Measures {
+		cube, field, err := c.member(measure)
+		if err != nil {
+			return "", nil, err
+		}
+		c.measures[measure] = true
+		c.selectField(cube, graphQLField{name: field, member: measure, measure: true})
+	}
+	for _, filter := range q.Filters {
+		cond, err := c.filter(filter)
+		if err != nil {
+			return "", nil, err
+		}
+		where = append(where, cond)
+	}
+	pairs, err := orderPairs(q.Order)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, pair := range pairs {
+		direction := strings.ToLower(pair[1])
+		if direction != "asc" && direction != "desc" {
+			return "", nil, fmt.Errorf("invalid order direction %q for %s", pair[1], pair[0])
+		}
+		member := pair[0]
+		if parts := strings.Split(member, "."); len(parts) == 3 {
+			member = parts[0] + "." + parts[1]
+		}
+		cube, field, err := c.member(member)
+		if err != nil {
+			return "", nil, err
+		}
+		cube.orderBy = append(cube.orderBy, field+": "+direction)
+	}
+	if len(c.cubes) == 0 {
+		return "", nil, errors.New("query must have at least one measure or dimension")
+	}
+
+	var args []string
+	if len(where) > 0 {
+		args = append(args, "where: {AND: ["+strings.Join(where, ", ")+"]}")
+	}
+	if q.Limit != nil {
+		args = append(args, fmt.Sprintf("limit: %d", *q.Limit))
+	}
+	if q.Offset != nil {
+		args = append(args, fmt.Sprintf("offset: %d", *q.Offset))
+	}
+
+	var query strings.Builder
+	query.WriteString("query CubeQuery { cube")
+	if len(args) > 0 {
+		query.WriteString("(" + strings.Join(args, ", ") + ")")
+	}
+	query.WriteString(" {")
+	for _, cube := range c.cubes {
+		if len(cube.fields) == 0 {
+			// Only filtered or ordered on.
+			continue
+		}
+		query.WriteString(" " + cube.name)
+		if len(cube.orderBy) > 0 {
+			query.WriteString("(orderBy: {" + strings.Join(cube.orderBy, ", ") + "})")
+		}
+		query.WriteString(" {")
+		selected := make(map[graphQLField]bool)
+		for _, field := range cube.fields {
+			// Fields selected under several member names are selected once.
+			key := graphQLField{name: field.name, granularity: field.granularity}
+			if selected[key] {
+				continue
+			}
+			selected[key] = true
+			query.WriteString(" " + field.name)
+			if field.granularity != "" {
+				query.WriteString(" { " + field.granularity + " }")
+			}
+		}
+		query.WriteString(" }")
+	}
+	query.WriteString(" } }")
+	return query.String(), c.cubes, nil
+}
+
+// member returns the cube of a member and the member's GraphQL field name.
+// Members whose cube or field name is not a valid GraphQL name are rejected.
+func (c *graphQLCompiler) member(member string) (*graphQLCube, string, error) {
+	cubeName, name, ok := strings.Cut(member, ".")
+	if !ok || cubeName == "" || name == "" || strings.Contains(name, ".") {
+		return nil, "", fmt.Errorf("invalid member %q", member)
+	}
+	gqlName, field := graphQLName(cubeName), graphQLName(name)
+	if !graphQLNamePattern.MatchString(gqlName) || !graphQLNamePattern.MatchString(field) {
+		return nil, "", fmt.Errorf("invalid member %q", member)
+	}
+	cube, ok := c.byName[gqlName]
+	if !ok {
+		cube = &graphQLCube{name: gqlName}
+		c.byName[gqlName] = cube
+		c.cubes = append(c.cubes, cube)
+	}
+	return cube, field, nil
+}
+
+// selectField adds a field to the selection of cube once.
+func (c *graphQLCompiler) selectField(cube *graphQLCube, field graphQLField) {
+	for _, selected := range cube.fields {
+		if selected.member == field.member {
+			return
+		}
+	}
+	cube.fields = append(cube.fields, field)
+}
+
+// graphQLOperators maps Cube filter operators to Cube's GraphQL filter
+// operators, and whether the operator takes a list of values.
+var graphQLOperators = map[string]struct {
+	name string
+	list bool
+}{
+	"equals":         {"in", true},
+	"notEquals":      {"notIn", true},
+	"contains":       {"contains", false},
+	"notContains":    {"notContains", false},
+	"startsWith":     {"startsWith", false},
+	"endsWith":       {"endsWith", false},
+	"gt":             {"gt", false},
+	"gte":            {"gte", false},
+	"lt":             {"lt", false},
+	"lte":            {"lte", false},
+	"inDateRange":    {"inDateRange", true},
+	"notInDateRange": {"notInDateRange", true},
+	"beforeDate":     {"beforeDate", false},
+	"afterDate":      {"afterDate", false},
+}
+
+// filter compiles a Cube filter (a member filter or an and/or group) into a
+// GraphQL where input.
+func (c *graphQLCompiler) filter(filter interface{}) (string, error) {
+	obj, ok := filter.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("invalid filter %v", filter)
+	}
+	for _, op := range []string{"and", "or"} {
+		group, ok := obj[op].([]interface{})
+		if !ok {
+			continue
+		}
+		conds := make([]string, 0, len(group))
+		for _, f := range group {
+			cond, err := c.filter(f)
+			if err != nil {
+				return "", err
+			}
+			conds = append(conds, cond)
+		}
+		return "{" + strings.ToUpper(op) + ": [" + strings.Join(conds, ", ") + "]}", nil
+	}
+
+	member, _ := obj["member"].(string)
+	if member == "" {
+		member, _ = obj["dimension"].(string)
+	}
+	cube, field, err := c.member(member)
+	if err != nil {
+		return "", err
+	}
+	operator, _ := obj["operator"].(string)
+	values, _ := obj["values"].([]interface{})
+
+	var condition string
+	switch operator {
+	case "set", "notSet":
+		condition = fmt.Sprintf("set: %t", operator == "set")
+	default:
+		op, ok := graphQLOperators[operator]
+		if !ok {
+			return "", fmt.Errorf("filter operator %q is not supported by the GraphQL transport", operator)
+		}
+		if len(values) == 0 {
+			return "", fmt.Errorf("%s filter requires values", operator)
+		}
+		var value interface{} = values
+		if !op.list {
+			if len(values) != 1 {
+				return "", fmt.Errorf("%s filter requires exactly one value", operator)
+			}
+			value = values[0]
+		}
+		encoded, err := graphQLValue(value, c.measures[member])
+		if err != nil {
+			return "", err
+		}
+		condition = op.name + ": " + encoded
+	}
+	return fmt.Sprintf("{%s: {%s: {%s}}}", cube.name, field, condition), nil
+}
+
+// graphQLValue encodes a filter value as a GraphQL literal: strings, or
+// numbers when number is set and the value is numeric.
+func graphQLValue(value interface{}, number bool) (string, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		encoded := make([]string, len(v))
+		for i, item := range v {
+			var err error
+			if encoded[i], err = graphQLValue(item, number); err != nil {
+				return "", err
+			}
+		}
+		return "[" + strings.Join(encoded, ", ") + "]", nil
+	case []string:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = item
+		}
+		return graphQLValue(items, number)
+	case nil:
+		return "", errors.New("filter values cannot be null")
+	}
+	text := fmt.Sprint(value)
+	if number && numericLiteral.MatchString(text) {
+		return text, nil
+	}
+	// JSON string literals are valid GraphQL string literals.
+	encoded, err := json.Marshal(text)
+	return string(encoded), err
+}
+
+// graphQLResponse is the body of a GraphQL response.
+type graphQLResponse struct {
+	Data struct {
+		Cube []map[string]interface{} `json:"cube"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// graphQLResult converts the rows of a GraphQL response into a /v1/load
+// result: rows keyed by member, and an annotation typing measures as numbers
+// and time dimensions as time.
+func graphQLResult(rows []map[string]interface{}, cubes []*graphQLCube) CubeAPIResponse {
+	annotation := CubeAnnotation{
+		Measures:       map[string]CubeFieldInfo{},
+		Dimensions:     map[string]CubeFieldInfo{},
+		Segments:       map[string]CubeFieldInfo{},
+		TimeDimensions: map[string]CubeFieldInfo{},
+	}
+	for _, cube := range cubes {
+		for _, field := range cube.fields {
+			info := CubeFieldInfo{Title: field.member, ShortTitle: field.member}
+			switch {
+			case field.measure:
+				info.Type = "number"
+				annotation.Measures[field.member] = info
+			case field.granularity != "" && strings.HasSuffix(field.member, "."+field.granularity):
+				info.Type = "time"
+				annotation.TimeDimensions[field.member] = info
+			case field.granularity != "":
+				info.Type = "time"
+				annotation.Dimensions[field.member] = info
+			default:
+				annotation.Dimensions[field.member] = info
+			}
+		}
+	}
+
+	data := make([]map[string]interface{}, 0, len(rows))
+	for _, item := range rows {
+		row := make(map[string]interface{})
+		for _, cube := range cubes {
+			values, _ := item[cube.name].(map[string]interface{})
+			for _, field := range cube.fields {
+				value := values[field.name]
+				if field.granularity != "" {
+					buckets, _ := value.(map[string]interface{})
+					value = buckets[field.granularity]
+				}
+				if value != nil {
+					row[field.member] = value
+				}
+			}
+		}
+		data = append(data, row)
+	}
+	return CubeAPIResponse{Data: data, Annotation: annotation}
+}
+
+// loadGraphQLResult runs a query on Cube's GraphQL API. Like /v1/load, the
+// GraphQL API answers "Continue wait" while the result is computed; the
+// query is sent again until it is ready, within the Continue-wait settings.
+func (d *Datasource) loadGraphQLResult(ctx context.Context, apiReq *APIRequestContext, cubeAPIQueryJSON []byte, cacheKey string) (CubeAPIResponse, error) {
+	config := apiReq.Config
+	query, cubes, err := compileGraphQLQuery(cubeAPIQueryJSON)
+	if err != nil {
+		return CubeAPIResponse{}, &loadRequestError{status: backend.StatusBadRequest, msg: fmt.Sprintf("Failed to compile query for the GraphQL API: %v", err)}
+	}
+	requestBody, err := json.Marshal(map[string]string{"query": query})
+	if err != nil {
+		return CubeAPIResponse{}, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	gqlURL := graphQLURL(apiReq.URL.String())
+	backend.Logger.FromContext(ctx).Debug("Running query on the Cube GraphQL API", "url", gqlURL, "query", query)
+
+	ctx, cancel := withTimeout(ctx, config.QueryTimeoutDuration())
+	defer cancel()
+	ctx, done := d.loads.track(ctx)
+	defer done()
+	release, err := d.acquireQuerySlot(ctx, config)
+	if err != nil {
+		return CubeAPIResponse{}, err
+	}
+	defer release()
+
+	start := time.Now()
+	polls := 0
+	for {
+		rows, err := d.doGraphQLRequest(ctx, gqlURL, requestBody, config)
+		if errors.Is(err, errGraphQLContinueWait) {
+			polls++
+			if observe := continueWaitObserverFrom(ctx); observe != nil {
+				observe(continueWaitProgress{})
+			}
+			if maxWait := config.MaxContinueWait(); maxWait > 0 && time.Since(start) >= maxWait {
+				return CubeAPIResponse{}, &loadRequestError{status: backend.StatusTimeout, msg: fmt.Sprintf("Cube query still not ready after waiting %s (continueWaitMaxDuration)", time.Since(start).Round(time.Millisecond))}
+			}
+			if err := sleepWithContext(ctx, config.ContinueWaitPollIntervalDuration()); err != nil {
+				return CubeAPIResponse{}, interruptedWaitError(err, continueWaitProgress{}, false)
+			}
+			continue
+		}
+		if err != nil {
+			logSlowQuery(ctx, config, []byte(query), time.Since(start), polls, 0, err)
+			return CubeAPIResponse{}, err
+		}
+		apiResponse := graphQLResult(rows, cubes)
+		logSlowQuery(ctx, config, []byte(query), time.Since(start), polls, len(apiResponse.Data), nil)
+		d.cacheResult(cacheKey, apiResponse, config)
+		return apiResponse, nil
+	}
+}
+
+// errGraphQLContinueWait is returned by doGraphQLRequest while Cube is still
+// computing the result.
+var errGraphQLContinueWait = errors.New("continue wait")
+
+// doGraphQLRequest posts a GraphQL query to Cube and returns the rows of its
+// cube field. GraphQL errors are reported as query errors.
+func (d *Datasource) doGraphQLRequest(ctx context.Context, gqlURL string, requestBody []byte, config *models.PluginSettings) ([]map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", gqlURL, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := d.addAuthHeaders(req, config); err != nil {
+		return nil, fmt.Errorf("failed to add auth headers: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.doHTTP(req, config)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, &loadRequestError{status: statusForContextErr(ctxErr), msg: fmt.Sprintf("Cube GraphQL API request failed: %v", ctxErr)}
+		}
+		return nil, &loadRequestError{status: backend.StatusBadGateway, msg: fmt.Sprintf("Cube GraphQL API request failed: %v", err)}
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			backend.Logger.FromContext(ctx).Warn("Failed to close response body", "error", err)
+		}
+	}()
+	body, err := readLoadBody(resp.Body, maxResponseBytes(config))
+	if err != nil {
+		return nil, err
+	}
+	if body, err = jsonResponseBody(resp, body); err != nil {
+		return nil, err
+	}
+
+	var result graphQLResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse GraphQL response: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		messages := make([]string, len(result.Errors))
+		for i, e := range result.Errors {
+			if e.Message == "Continue wait" {
+				return nil, errGraphQLContinueWait
+			}
+			messages[i] = e.Message
+		}
+		sort.Strings(messages)
+		return nil, &loadRequestError{status: backend.StatusBadRequest, msg: "Cube GraphQL API error: " + strings.Join(messages, "; ")}
+	}
+	return result.Data.Cube, nil
+}
@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestCompileGraphQLQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "measures, dimensions, order and limit",
+			query: `{"measures": ["orders.count"], "dimensions": ["orders.status", "line_items.product_name"], "order": {"orders.count": "desc"}, "limit": 10}`,
+			want:  `query CubeQuery { cube(limit: 10) { orders(orderBy: {count: desc}) { status count } lineItems { productName } } }`,
+		},
+		{
+			name: "time dimension with granularity and date range",
+			query: `{"measures": ["orders.count"], "dimensions": ["orders.created_at"],
+				"timeDimensions": [{"dimension": "orders.created_at", "granularity": "day", "dateRange": ["2024-01-01", "2024-01-31"]}]}`,
+			want: `query CubeQuery { cube(where: {AND: [{orders: {createdAt: {inDateRange: ["2024-01-01", "2024-01-31"]}}}]}) { orders { createdAt { day } count } } }`,
+		},
+		{
+			name: "filters",
+			query: `{"measures": ["orders.total"], "filters": [
+				{"member": "orders.status", "operator": "equals", "values": ["shipped", "say \"hi\""]},
+				{"or": [{"member": "orders.city", "operator": "contains", "values": ["Par"]}, {"member": "orders.city", "operator": "notSet"}]},
+				{"member": "orders.total", "operator": "gt", "values": ["100"]}]}`,
+			want: `query CubeQuery { cube(where: {AND: [{orders: {status: {in: ["shipped", "say \"hi\""]}}}, ` +
+				`{OR: [{orders: {city: {contains: "Par"}}}, {orders: {city: {set: false}}}]}, {orders: {total: {gt: 100}}}]}) { orders { total } } }`,
+		},
+		{
+			name:    "segments",
+			query:   `{"measures": ["orders.count"], "segments": ["orders.completed"]}`,
+			wantErr: "segments are not supported",
+		},
+		{
+			name:    "member name injection",
+			query:   `{"dimensions": ["orders.status } secret_view { ssn"]}`,
+			wantErr: "invalid member",
+		},
+		{
+			name:    "filter member name injection",
+			query:   `{"measures": ["orders.count"], "filters": [{"member": "orders.status: {set: true}} secret_view: {ssn", "operator": "set"}]}`,
+			wantErr: "invalid member",
+		},
+		{
+			name:    "granularity injection",
+			query:   `{"timeDimensions": [{"dimension": "orders.created_at", "granularity": "day } secret_view { ssn"}]}`,
+			wantErr: "invalid granularity",
+		},
+		{
+			name:    "dimension granularity injection",
+			query:   `{"dimensions": ["orders.created_at.day } secret_view { ssn"]}`,
+			wantErr: "invalid granularity",
+		},
+		{
+			name:    "unsupported operator",
+			query:   `{"measures": ["orders.count"], "filters": [{"member": "orders.status", "operator": "matches", "values": ["x"]}]}`,
+			wantErr: `operator "matches" is not supported`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := compileGraphQLQuery([]byte(tt.query))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func graphQLPluginContext(url string) backend.PluginContext {
+	pCtx := newTestPluginContext(url)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "queryTransport": "graphql", "continueWaitPollInterval": 0}`)
+	return pCtx
+}
+
+func TestQueryDataGraphQLTransport(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cubejs-api/graphql" || r.Method != http.MethodPost {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var body struct {
+			Query string `json:"query"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !strings.Contains(body.Query, "createdAt { day }") {
+			t.Errorf("unexpected GraphQL query %q: %v", body.Query, err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&calls, 1) == 1 {
+			_, _ = w.Write([]byte(`{"errors": [{"message": "Continue wait"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": {"cube": [
+			{"orders": {"createdAt": {"day": "2024-01-01T00:00:00.000"}, "count": 42}},
+			{"orders": {"createdAt": {"day": "2024-01-02T00:00:00.000"}, "count": null}}]}}`))
+	}))
+	defer server.Close()
+
+	res := runSingleQuery(t, &Datasource{}, graphQLPluginContext(server.URL), `{"refId": "A",
+		"measures": ["orders.count"], "dimensions": ["orders.created_at"],
+		"timeDimensions": [{"dimension": "orders.created_at", "granularity": "day"}]}`)
+	if res.Error != nil {
+		t.Fatalf("query failed: %v", res.Error)
+	}
+	if calls := atomic.LoadInt32(&calls); calls != 2 {
+		t.Errorf("expected the query to be sent again after Continue wait, got %d calls", calls)
+	}
+
+	frame := res.Frames[0]
+	if len(frame.Fields) != 2 || frame.Rows() != 2 {
+		t.Fatalf("expected 2 fields and 2 rows, got %d fields and %d rows", len(frame.Fields), frame.Rows())
+	}
+	timestamp, ok := frame.Fields[0].ConcreteAt(1)
+	if !ok || !timestamp.(time.Time).Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the second day, got %v", timestamp)
+	}
+	if value, ok := frame.Fields[1].ConcreteAt(0); !ok || value.(float64) != 42 {
+		t.Errorf("expected 42, got %v", value)
+	}
+	if _, ok := frame.Fields[1].ConcreteAt(1); ok {
+		t.Error("expected null to convert to a null value")
+	}
+}
+
+func TestQueryDataGraphQLError(t *testing.T) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"errors": [{"message": "Cannot query field \"nope\" on type \"OrdersMembers\"."}]}`))
+	}))
+	defer server.Close()
+
+	res := runSingleQuery(t, &Datasource{}, graphQLPluginContext(server.URL), `{"refId": "A", "measures": ["orders.nope"]}`)
+	if res.Error == nil || res.Status != backend.StatusBadRequest || !strings.Contains(res.Error.Error(), `Cannot query field "nope"`) {
+		t.Errorf("expected the GraphQL error as a bad request, got %d: %v", res.Status, res.Error)
+	}
+}
@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Status values of a health step.
+const (
+	healthStepOK      = "ok"
+	healthStepError   = "error"
+	healthStepSkipped = "skipped"
+)
+
+// healthStep is the outcome of one readiness check. Steps after a failed
+// step are skipped.
+type healthStep struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// healthResponse is the body of the health resource.
+type healthResponse struct {
+	// Status is "ok" when every step passed, "error" otherwise.
+	Status string `json:"status"`
+	// Config checks the URL, deployment type and credentials settings.
+	Config healthStep `json:"config"`
+	// Connectivity checks that Cube (and not, e.g., a proxy page) answered.
+	Connectivity healthStep `json:"connectivity"`
+	// Auth checks that Cube accepted the credentials.
+	Auth healthStep `json:"auth"`
+	// Meta checks that the data model could be fetched.
+	Meta healthStep `json:"meta"`
+	// Query checks that a one-row /v1/load query succeeds, i.e. that Cube
+	// reaches the warehouse. Only set by deep checks.
+	Query *healthStep `json:"query,omitempty"`
+}
+
+// errNoHealthQueryMember is reported when a deep health check has no member
+// to query.
+var errNoHealthQueryMember = errors.New("the data model has no members to query")
+
+// runHealthQuery runs the smallest /v1/load query on the model: limit 1 on a
+// member of the first view (see probeMember). It returns
+// errNoHealthQueryMember when the model is empty.
+func (d *Datasource) runHealthQuery(ctx context.Context, pCtx backend.PluginContext, meta *CubeMetaResponse) error {
+	member, ok := probeMember(meta)
+	if !ok {
+		return errNoHealthQueryMember
+	}
+	apiReq, err := d.buildAPIURL(pCtx, "load")
+	if err != nil {
+		return err
+	}
+	body, err := d.doCubeLoadRequest(ctx, apiReq.URL.String(), probeQuery(member, meta), apiReq.Config)
+	if err != nil {
+		return err
+	}
+	_, err = decodeLoadResult(body)
+	return err
+}
+
+// readiness runs the health steps for the datasource. The model is read
+// through the metadata cache, so polling is cheap while it is fresh and a
+// cached model counts as Cube being reachable. deep adds the query step,
+// which always sends a load query to Cube.
+func (d *Datasource) readiness(ctx context.Context, pCtx backend.PluginContext, deep bool) healthResponse {
+	res := healthResponse{
+		Status:       healthStepError,
+		Config:       healthStep{Status: healthStepSkipped},
+		Connectivity: healthStep{Status: healthStepSkipped},
+		Auth:         healthStep{Status: healthStepSkipped},
+		Meta:         healthStep{Status: healthStepSkipped},
+	}
+	if deep {
+		res.Query = &healthStep{Status: healthStepSkipped}
+	}
+	fail := func(step *healthStep, err error) healthResponse {
+		*step = healthStep{Status: healthStepError, Error: err.Error()}
+		return res
+	}
+
+	apiReq, err := d.buildAPIURL(pCtx, "meta")
+	if err != nil {
+		return fail(&res.Config, err)
+	}
+	if _, err := d.authToken(apiReq.Config); err != nil {
+		return fail(&res.Config, err)
+	}
+	res.Config.Status = healthStepOK
+
+	meta, err := d.getCubeMetadata(ctx, pCtx)
+	if err == nil {
+		res.Connectivity.Status = healthStepOK
+		res.Auth.Status = healthStepOK
+		res.Meta.Status = healthStepOK
+		if deep {
+			err := d.runHealthQuery(ctx, pCtx, meta)
+			if errors.Is(err, errNoHealthQueryMember) {
+				res.Query.Error = err.Error()
+			} else if err != nil {
+				return fail(res.Query, err)
+			} else {
+				res.Query.Status = healthStepOK
+			}
+		}
+		res.Status = healthStepOK
+		return res
+	}
+
+	var cubeErr *CubeAPIError
+	if !errors.As(err, &cubeErr) {
+		// Transport failures and proxy pages: Cube was not reached.
+		return fail(&res.Connectivity, err)
+	}
+	res.Connectivity.Status = healthStepOK
+	if cubeErr.StatusCode == http.StatusUnauthorized || cubeErr.StatusCode == http.StatusForbidden {
+		return fail(&res.Auth, err)
+	}
+	res.Auth.Status = healthStepOK
+	return fail(&res.Meta, err)
+}
+
+// handleHealth returns the readiness of the datasource as structured JSON,
+// for the config editor and other clients that poll it. It is lighter than
+// CheckHealth and always answers 200; failures are reported in the body.
+// health?deep=true, or the deepHealthCheck setting, adds the query step.
+func (d *Datasource) handleHealth(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	deep := false
+	if config := d.pluginSettings(req.PluginContext); config != nil {
+		deep = config.DeepHealthCheck
+	}
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
+	}
+	if value := parsedURL.Query().Get("deep"); value != "" {
+		if deep, err = strconv.ParseBool(value); err != nil {
+			return sender.Send(jsonErrorResponse(400, fmt.Errorf("invalid deep %q", value)))
+		}
+	}
+
+	body, err := json.Marshal(d.readiness(ctx, req.PluginContext, deep))
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal health response", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   body,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
@@ -0,0 +1,211 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHandleHealth(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc // nil: no server is listening
+		url     string           // overrides the server URL
+		want    healthResponse
+	}{
+		{
+			name: "ready",
+			handler: serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("unexpected request to %s", r.URL.Path)
+			}),
+			want: healthResponse{Status: "ok", Config: healthStep{Status: "ok"}, Connectivity: healthStep{Status: "ok"}, Auth: healthStep{Status: "ok"}, Meta: healthStep{Status: "ok"}},
+		},
+		{
+			name: "not configured",
+			url:  " ",
+			want: healthResponse{Status: "error", Config: healthStep{Status: "error"}, Connectivity: healthStep{Status: "skipped"}, Auth: healthStep{Status: "skipped"}, Meta: healthStep{Status: "skipped"}},
+		},
+		{
+			name: "unreachable",
+			url:  "http://127.0.0.1:1",
+			want: healthResponse{Status: "error", Config: healthStep{Status: "ok"}, Connectivity: healthStep{Status: "error"}, Auth: healthStep{Status: "skipped"}, Meta: healthStep{Status: "skipped"}},
+		},
+		{
+			name: "proxy page",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = w.Write([]byte("<html>Sign in</html>"))
+			},
+			want: healthResponse{Status: "error", Config: healthStep{Status: "ok"}, Connectivity: healthStep{Status: "error"}, Auth: healthStep{Status: "skipped"}, Meta: healthStep{Status: "skipped"}},
+		},
+		{
+			name: "credentials rejected",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"error": "Invalid token"}`))
+			},
+			want: healthResponse{Status: "error", Config: healthStep{Status: "ok"}, Connectivity: healthStep{Status: "ok"}, Auth: healthStep{Status: "error"}, Meta: healthStep{Status: "skipped"}},
+		},
+		{
+			name: "model error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(`{"error": "Compile errors"}`))
+			},
+			want: healthResponse{Status: "error", Config: healthStep{Status: "ok"}, Connectivity: healthStep{Status: "ok"}, Auth: healthStep{Status: "ok"}, Meta: healthStep{Status: "error"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := tt.url
+			if tt.handler != nil {
+				server := httptest.NewServer(tt.handler)
+				defer server.Close()
+				url = server.URL
+			}
+			ds := &Datasource{}
+
+			resp := callHandler(t, ds.CallResource, &backend.CallResourceRequest{
+				Path:          "health",
+				Method:        "GET",
+				PluginContext: newTestPluginContext(url),
+			})
+			if resp.Status != 200 {
+				t.Fatalf("expected status 200, got %d: %s", resp.Status, resp.Body)
+			}
+			var got healthResponse
+			if err := json.Unmarshal(resp.Body, &got); err != nil {
+				t.Fatalf("invalid response %s: %v", resp.Body, err)
+			}
+			for _, step := range []*healthStep{&got.Config, &got.Connectivity, &got.Auth, &got.Meta} {
+				if (step.Status == "error") != (step.Error != "") {
+					t.Errorf("expected an error message exactly for failed steps, got %s", resp.Body)
+				}
+				step.Error = ""
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandleHealthDeep(t *testing.T) {
+	meta := `{"cubes": [{"name": "orders_view", "type": "view", "measures": [{"name": "orders_view.count", "type": "number"}]}]}`
+	tests := []struct {
+		name     string
+		meta     string
+		load     http.HandlerFunc
+		jsonData string
+		url      string
+		want     *healthStep
+	}{
+		{name: "shallow by default", meta: meta, url: "health"},
+		{name: "query succeeds", meta: meta, url: "health?deep=true", want: &healthStep{Status: "ok"}},
+		{name: "enabled by the setting", meta: meta, jsonData: `{"deploymentType": "self-hosted-dev", "deepHealthCheck": true}`, url: "health", want: &healthStep{Status: "ok"}},
+		{name: "empty model", meta: `{"cubes": []}`, url: "health?deep=true", want: &healthStep{Status: "skipped", Error: "the data model has no members to query"}},
+		{
+			name: "warehouse unreachable",
+			meta: meta,
+			load: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error": "connect ECONNREFUSED"}`))
+			},
+			url:  "health?deep=true",
+			want: &healthStep{Status: "error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Path == "/cubejs-api/v1/meta" {
+					_, _ = w.Write([]byte(tt.meta))
+					return
+				}
+				query = r.URL.Query().Get("query")
+				if tt.load != nil {
+					tt.load(w, r)
+					return
+				}
+				_, _ = w.Write([]byte(`{"data": [{"orders_view.count": "3"}]}`))
+			}))
+			defer server.Close()
+			ds := &Datasource{}
+			pCtx := newTestPluginContext(server.URL)
+			if tt.jsonData != "" {
+				pCtx.DataSourceInstanceSettings.JSONData = []byte(tt.jsonData)
+			}
+
+			resp := callHandler(t, ds.CallResource, &backend.CallResourceRequest{Path: "health", Method: "GET", URL: tt.url, PluginContext: pCtx})
+			var got healthResponse
+			if err := json.Unmarshal(resp.Body, &got); err != nil {
+				t.Fatalf("invalid response %s: %v", resp.Body, err)
+			}
+			if tt.want == nil {
+				if got.Query != nil || query != "" {
+					t.Errorf("expected no query step, got %s", resp.Body)
+				}
+				return
+			}
+			if got.Query == nil || got.Query.Status != tt.want.Status || (tt.want.Error != "" && got.Query.Error != tt.want.Error) {
+				t.Fatalf("expected query step %+v, got %s", tt.want, resp.Body)
+			}
+			wantStatus := "ok"
+			if tt.want.Status == "error" {
+				wantStatus = "error"
+			}
+			if got.Status != wantStatus {
+				t.Errorf("expected status %s, got %s", wantStatus, got.Status)
+			}
+			if tt.want.Status != "skipped" && query != `{"limit":1,"measures":["orders_view.count"]}` {
+				t.Errorf("unexpected health query %s", query)
+			}
+		})
+	}
+
+	resp := callHandler(t, (&Datasource{}).CallResource, &backend.CallResourceRequest{Path: "health", Method: "GET", URL: "health?deep=maybe", PluginContext: newTestPluginContext("http://localhost:4000")})
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid deep, got %d", resp.Status)
+	}
+}
+
+func TestCheckHealthDeep(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/cubejs-api/v1/meta" {
+			_, _ = w.Write([]byte(`{"cubes": [{"name": "orders_view", "type": "view", "dimensions": [{"name": "orders_view.status", "type": "string"}]}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": "connect ECONNREFUSED"}`))
+	}))
+	defer server.Close()
+
+	for _, deep := range []bool{false, true} {
+		ds := &Datasource{}
+		pCtx := newTestPluginContext(server.URL)
+		if deep {
+			pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "deepHealthCheck": true}`)
+		}
+		res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: pCtx})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !deep && res.Status != backend.HealthStatusOk {
+			t.Errorf("expected a shallow check to pass, got %s", res.Message)
+		}
+		if deep && (res.Status != backend.HealthStatusError || !strings.Contains(res.Message, "test query failed")) {
+			t.Errorf("expected a deep check to report the failed query, got %v: %s", res.Status, res.Message)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// healthDetails is the JSONDetails of a successful health check, so admins
+// can verify the datasource points at the intended Cube deployment and model.
+type healthDetails struct {
+	// CubeVersion is the Cube server version, when Cube reports it (the
+	// playground API of dev-mode deployments does).
+	CubeVersion string `json:"cubeVersion,omitempty"`
+	Cubes       int    `json:"cubes"`
+	Views       int    `json:"views"`
+	// SecurityContext holds the claims of the token sent to Cube, which Cube
+	// uses as the security context. Omitted without authentication or when
+	// the credential is not a JWT.
+	SecurityContext map[string]interface{} `json:"securityContext,omitempty"`
+}
+
+// countCubesAndViews counts the cubes and views of a model.
+func countCubesAndViews(meta *CubeMetaResponse) (cubes, views int) {
+	for _, cube := range meta.Cubes {
+		if cube.Type == "view" {
+			views++
+		} else {
+			cubes++
+		}
+	}
+	return cubes, views
+}
+
+// tokenClaims returns the claims of a JWT without verifying it, or nil when
+// token is not a JWT.
+func tokenClaims(token string) map[string]interface{} {
+	if token == "" {
+		return nil
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return nil
+	}
+	return claims
+}
+
+// fetchCubeVersion returns the Cube server version from the playground
+// context, or "" when it is unavailable (the playground API is only served
+// in dev mode). Best effort: errors are logged at debug level.
+func (d *Datasource) fetchCubeVersion(ctx context.Context, config *models.PluginSettings) string {
+	// Get base URL with test override support
+	baseURL := d.baseURL(config)
+	contextURL := strings.TrimRight(baseURL, "/") + "/playground/context"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", contextURL, nil)
+	if err != nil {
+		return ""
+	}
+	if err := d.addAuthHeaders(req, config); err != nil {
+		return ""
+	}
+	resp, err := d.doHTTP(req, config)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Debug("Failed to fetch Cube version", "error", err)
+		return ""
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			backend.Logger.FromContext(ctx).Warn("Failed to close response body", "error", err)
+		}
+	}()
+
+	body, err := readJSONResponse(resp)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Debug("Failed to fetch Cube version", "error", err)
+		return ""
+	}
+	var playgroundContext struct {
+		CoreServerVersion string `json:"coreServerVersion"`
+	}
+	if err := json.Unmarshal(body, &playgroundContext); err != nil {
+		return ""
+	}
+	return playgroundContext.CoreServerVersion
+}
+
+// healthCheckDetails builds the JSONDetails of a successful health check.
+// meta may be nil when the meta response could not be parsed.
+func (d *Datasource) healthCheckDetails(ctx context.Context, config *models.PluginSettings, meta *CubeMetaResponse) []byte {
+	var details healthDetails
+	if meta != nil {
+		details.Cubes, details.Views = countCubesAndViews(meta)
+	}
+	if token, err := d.authToken(config); err == nil {
+		details.SecurityContext = tokenClaims(token)
+	}
+	details.CubeVersion = d.fetchCubeVersion(ctx, config)
+
+	body, err := json.Marshal(details)
+	if err != nil {
+		return nil
+	}
+	return body
+}
@@ -0,0 +1,80 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestCheckHealthDetails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/cubejs-api/v1/meta":
+			_, _ = w.Write([]byte(`{"cubes": [
+				{"name": "orders", "type": "cube"},
+				{"name": "customers", "type": "cube"},
+				{"name": "sales", "type": "view"}
+			]}`))
+		case "/playground/context":
+			_, _ = w.Write([]byte(`{"coreServerVersion": "1.3.20"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+		PluginContext: backend.PluginContext{
+			DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				URL:                     server.URL,
+				JSONData:                []byte(`{"deploymentType": "self-hosted"}`),
+				DecryptedSecureJSONData: map[string]string{"apiSecret": "secret"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CheckHealth failed: %v", err)
+	}
+	if res.Status != backend.HealthStatusOk {
+		t.Fatalf("expected OK, got %v: %s", res.Status, res.Message)
+	}
+
+	var details healthDetails
+	if err := json.Unmarshal(res.JSONDetails, &details); err != nil {
+		t.Fatalf("invalid details %s: %v", res.JSONDetails, err)
+	}
+	if details.Cubes != 2 || details.Views != 1 {
+		t.Errorf("expected 2 cubes and 1 view, got %d and %d", details.Cubes, details.Views)
+	}
+	if details.CubeVersion != "1.3.20" {
+		t.Errorf("expected Cube version 1.3.20, got %q", details.CubeVersion)
+	}
+	if details.SecurityContext["sub"] != "grafana-cube-datasource" {
+		t.Errorf("expected the JWT claims as security context, got %v", details.SecurityContext)
+	}
+}
+
+func TestCheckHealthDetailsWithoutVersionOrAuth(t *testing.T) {
+	server := httptest.NewServer(serveEmptyMeta(http.NotFound))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+		PluginContext: newTestPluginContext(server.URL),
+	})
+	if err != nil {
+		t.Fatalf("CheckHealth failed: %v", err)
+	}
+	if res.Status != backend.HealthStatusOk {
+		t.Fatalf("expected OK, got %v: %s", res.Status, res.Message)
+	}
+	if string(res.JSONDetails) != `{"cubes":0,"views":0}` {
+		t.Errorf("unexpected details %s", res.JSONDetails)
+	}
+}
@@ -2,6 +2,10 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -38,3 +42,44 @@ func callHandlerWithContext(ctx context.Context, t *testing.T, fn func(context.C
 	}
 	return resp
 }
+
+// serveEmptyMeta wraps a mock /v1/load handler so /v1/meta requests (made by
+// the query path for deprecation warnings) get an empty model and never reach
+// the handler.
+func serveEmptyMeta(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"cubes": []}`))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// newCubeLoadServer starts a mock Cube server that answers every request with
+// the given /v1/load response.
+func newCubeLoadServer(t *testing.T, response CubeAPIResponse) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to encode response: %v", err)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// runSingleQuery runs queryJSON through QueryData as refId "A" and returns its response.
+func runSingleQuery(t *testing.T, ds *Datasource, pluginContext backend.PluginContext, queryJSON string) backend.DataResponse {
+	t.Helper()
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pluginContext,
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: []byte(queryJSON)}},
+	})
+	if err != nil {
+		t.Fatalf("QueryData failed: %v", err)
+	}
+	return resp.Responses["A"]
+}
@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+)
+
+// Connection pool tuning for the shared Cube HTTP client. A dashboard refresh
+// fans out many queries to the same Cube host, so we keep more idle
+// connections per host than net/http's default of 2 to avoid a TLS handshake
+// per query.
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 20
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultConnectTimeout      = 30 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+)
+
+// newHTTPClient builds the HTTP client shared by all requests of a datasource
+// instance. Only the connect timeout lives on the transport; request timeouts
+// are applied per call via withTimeout, because a client-wide timeout would
+// also cut short Continue-wait polling. config may be nil.
+func newHTTPClient(config *models.PluginSettings) *http.Client {
+	dialer := &net.Dialer{Timeout: defaultConnectTimeout, KeepAlive: defaultKeepAlive}
+	if timeout := config.ConnectTimeoutDuration(); timeout > 0 {
+		dialer.Timeout = timeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.MaxIdleConns = defaultMaxIdleConns
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	transport.IdleConnTimeout = defaultIdleConnTimeout
+
+	if config != nil {
+		if config.KeepAlive != nil {
+			// Negative disables keep-alive probes, matching net.Dialer.
+			dialer.KeepAlive = time.Duration(*config.KeepAlive) * time.Second
+		}
+		if config.MaxIdleConns != nil && *config.MaxIdleConns >= 0 {
+			transport.MaxIdleConns = *config.MaxIdleConns
+		}
+		if config.MaxIdleConnsPerHost != nil && *config.MaxIdleConnsPerHost >= 0 {
+			transport.MaxIdleConnsPerHost = *config.MaxIdleConnsPerHost
+		}
+		if config.IdleConnTimeout != nil && *config.IdleConnTimeout >= 0 {
+			transport.IdleConnTimeout = time.Duration(*config.IdleConnTimeout) * time.Second
+		}
+		if config.TLSHandshakeTimeout != nil && *config.TLSHandshakeTimeout >= 0 {
+			transport.TLSHandshakeTimeout = time.Duration(*config.TLSHandshakeTimeout) * time.Second
+		}
+		if config.ForceHTTP2 {
+			protocols := new(http.Protocols)
+			protocols.SetHTTP2(true)
+			protocols.SetUnencryptedHTTP2(true)
+			transport.Protocols = protocols
+		}
+		// Invalid TLS settings are reported per request by buildAPIURL.
+		if tlsConfig, err := clientTLSConfig(config); err == nil && tlsConfig != nil {
+			transport.TLSClientConfig = tlsConfig
+		}
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// clientTLSConfig returns the TLS configuration of connections to Cube, or
+// nil when the transport defaults apply. A client certificate is presented
+// when both tlsClientCert and tlsClientKey are set, for Cube instances behind
+// mutual TLS. tlsCACert adds CAs to the system roots, so self-signed
+// deployments are trusted, and tlsSkipVerify disables verification.
+func clientTLSConfig(config *models.PluginSettings) (*tls.Config, error) {
+	if config == nil {
+		return nil, nil
+	}
+	secrets := config.Secrets
+	if secrets == nil {
+		secrets = &models.SecretPluginSettings{}
+	}
+	if secrets.TLSClientCert == "" && secrets.TLSClientKey == "" && secrets.TLSCACert == "" && !config.TLSSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.TLSSkipVerify, //nolint:gosec // Opt-in setting for test deployments
+	}
+	if secrets.TLSClientCert != "" || secrets.TLSClientKey != "" {
+		if secrets.TLSClientCert == "" || secrets.TLSClientKey == "" {
+			return nil, errors.New("TLS client certificate and key must be set together")
+		}
+		pair, err := tls.X509KeyPair([]byte(secrets.TLSClientCert), []byte(secrets.TLSClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	if secrets.TLSCACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(secrets.TLSCACert)) {
+			return nil, errors.New("invalid TLS CA certificate: no PEM encoded certificate found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// addCustomHeaders sets the custom headers configured for the datasource on
+// header. Headers already set by the plugin, such as Authorization, are kept.
+func addCustomHeaders(header http.Header, config *models.PluginSettings) {
+	if config == nil {
+		return
+	}
+	for name, value := range config.CustomHeaders {
+		if header.Get(name) == "" {
+			header.Set(name, value)
+		}
+	}
+}
+
+// getHTTPClient returns the instance's shared HTTP client, creating it from
+// config on first use. NewDatasource creates it eagerly; the lazy path covers
+// tests that build a Datasource literal directly.
+func (d *Datasource) getHTTPClient(config *models.PluginSettings) *http.Client {
+	d.httpClientOnce.Do(func() {
+		if d.httpClient == nil {
+			d.httpClient = newHTTPClient(config)
+		}
+	})
+	return d.httpClient
+}
+
+// withTimeout derives a context bounded by timeout. A non-positive timeout
+// returns ctx unchanged, leaving only the caller's deadline in effect.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
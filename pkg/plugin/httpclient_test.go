@@ -0,0 +1,313 @@
+package plugin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestGetHTTPClientIsShared(t *testing.T) {
+	ds := &Datasource{}
+	first := ds.getHTTPClient(nil)
+	if first == nil {
+		t.Fatal("expected a client")
+	}
+	if second := ds.getHTTPClient(nil); second != first {
+		t.Error("expected the same client on every call")
+	}
+
+	transport, ok := first.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected *http.Transport, got %T", first.Transport)
+	}
+	if transport.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost {
+		t.Errorf("expected MaxIdleConnsPerHost %d, got %d", defaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	}
+}
+
+func TestNewDatasourceCreatesHTTPClient(t *testing.T) {
+	instance, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{})
+	if err != nil {
+		t.Fatalf("NewDatasource failed: %v", err)
+	}
+	ds := instance.(*Datasource)
+	if ds.httpClient == nil {
+		t.Fatal("expected NewDatasource to create the shared client")
+	}
+	if ds.getHTTPClient(nil) != ds.httpClient {
+		t.Error("expected getHTTPClient to return the client created by NewDatasource")
+	}
+	ds.Dispose()
+}
+
+func TestSharedHTTPClientReusesConnections(t *testing.T) {
+	var newConns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[],"annotation":{}}`))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	for i := 0; i < 3; i++ {
+		if _, err := ds.doCubeLoadRequest(context.Background(), server.URL+"/cubejs-api/v1/load", []byte(`{}`), devConfig()); err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+	}
+
+	if n := newConns.Load(); n != 1 {
+		t.Errorf("expected sequential requests to reuse 1 connection, got %d", n)
+	}
+}
+
+func TestDoCubeLoadRequestHonoursQueryTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"error":"Continue wait"}`))
+	}))
+	defer server.Close()
+
+	config := devConfig()
+	config.QueryTimeout = intPtr(1)
+
+	ds := &Datasource{BaseURL: server.URL}
+	start := time.Now()
+	_, err := ds.doCubeLoadRequest(context.Background(), server.URL+"/cubejs-api/v1/load", []byte(`{}`), config)
+	if err == nil {
+		t.Fatal("expected the query timeout to stop Continue-wait polling")
+	}
+	if !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the call to stop after ~1s, took %s", elapsed)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx, cancel := withTimeout(context.Background(), 0)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("expected no deadline for a zero timeout")
+	}
+
+	ctx, cancel = withTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("expected a deadline for a positive timeout")
+	}
+}
+
+func TestNewHTTPClientAppliesTransportSettings(t *testing.T) {
+	config := devConfig()
+	config.MaxIdleConns = intPtr(10)
+	config.MaxIdleConnsPerHost = intPtr(4)
+	config.IdleConnTimeout = intPtr(30)
+	config.TLSHandshakeTimeout = intPtr(5)
+
+	transport := newHTTPClient(config).Transport.(*http.Transport)
+
+	if transport.MaxIdleConns != 10 {
+		t.Errorf("expected MaxIdleConns 10, got %d", transport.MaxIdleConns)
+	}
+	if transport.MaxIdleConnsPerHost != 4 {
+		t.Errorf("expected MaxIdleConnsPerHost 4, got %d", transport.MaxIdleConnsPerHost)
+	}
+	if transport.IdleConnTimeout != 30*time.Second {
+		t.Errorf("expected IdleConnTimeout 30s, got %s", transport.IdleConnTimeout)
+	}
+	if transport.TLSHandshakeTimeout != 5*time.Second {
+		t.Errorf("expected TLSHandshakeTimeout 5s, got %s", transport.TLSHandshakeTimeout)
+	}
+	if transport.Protocols != nil {
+		t.Error("expected default protocol negotiation without forceHTTP2")
+	}
+}
+
+func TestNewHTTPClientForceHTTP2(t *testing.T) {
+	var proto atomic.Value
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto.Store(r.Proto)
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+
+	config := devConfig()
+	config.ForceHTTP2 = true
+
+	resp, err := newHTTPClient(config).Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if got := proto.Load(); got != "HTTP/2.0" {
+		t.Errorf("expected HTTP/2.0, got %v", got)
+	}
+}
+
+// selfSignedCert returns a PEM encoded self-signed client certificate and its
+// key.
+func selfSignedCert(t *testing.T, commonName string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+}
+
+// serverCAPEM returns the PEM encoded certificate of a TLS test server, which
+// is self-signed.
+func serverCAPEM(server *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+}
+
+func TestNewHTTPClientTrustsCustomCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if _, err := newHTTPClient(devConfig()).Get(server.URL); err == nil {
+		t.Fatal("expected the self-signed certificate to be rejected by default")
+	}
+
+	config := devConfig()
+	config.Secrets = &models.SecretPluginSettings{TLSCACert: serverCAPEM(server)}
+	resp, err := newHTTPClient(config).Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected the custom CA to be trusted: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	config = devConfig()
+	config.TLSSkipVerify = true
+	resp, err = newHTTPClient(config).Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected verification to be skipped: %v", err)
+	}
+	_ = resp.Body.Close()
+}
+
+func TestNewHTTPClientPresentsClientCertificate(t *testing.T) {
+	var peer atomic.Value
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer.Store(r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	config := devConfig()
+	cert, key := selfSignedCert(t, "grafana")
+	config.Secrets = &models.SecretPluginSettings{TLSClientCert: cert, TLSClientKey: key, TLSCACert: serverCAPEM(server)}
+
+	resp, err := newHTTPClient(config).Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if got := peer.Load(); got != "grafana" {
+		t.Errorf("expected the server to see the client certificate, got %v", got)
+	}
+}
+
+func TestClientTLSConfigErrors(t *testing.T) {
+	cert, _ := selfSignedCert(t, "grafana")
+	_, otherKey := selfSignedCert(t, "other")
+	tests := map[string]*models.SecretPluginSettings{
+		"certificate without key": {TLSClientCert: cert},
+		"key without certificate": {TLSClientKey: otherKey},
+		"mismatched key":          {TLSClientCert: cert, TLSClientKey: otherKey},
+		"invalid CA":              {TLSCACert: "not a certificate"},
+	}
+	for name, secrets := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := clientTLSConfig(&models.PluginSettings{Secrets: secrets}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	if tlsConfig, err := clientTLSConfig(devConfig()); err != nil || tlsConfig != nil {
+		t.Errorf("expected the transport defaults without TLS settings, got %v, %v", tlsConfig, err)
+	}
+}
+
+func TestBuildAPIURLReportsInvalidClientCertificate(t *testing.T) {
+	pCtx := newTestPluginContext("https://cube:4000")
+	pCtx.DataSourceInstanceSettings.DecryptedSecureJSONData = map[string]string{"tlsClientCert": "not a certificate"}
+	ds := &Datasource{}
+	if _, err := ds.buildAPIURL(pCtx, "load"); err == nil || !strings.Contains(err.Error(), "TLS client certificate") {
+		t.Errorf("expected a TLS client certificate error, got %v", err)
+	}
+}
+
+func TestQueryDataSendsCustomHeaders(t *testing.T) {
+	var tenant, auth atomic.Value
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		tenant.Store(r.Header.Get("X-Tenant"))
+		auth.Store(r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [], "annotation": {}}`))
+	}))
+	defer server.Close()
+
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "cloud", "httpHeaderName1": "X-Tenant", "httpHeaderName2": "Authorization"}`)
+	pCtx.DataSourceInstanceSettings.DecryptedSecureJSONData = map[string]string{
+		"apiKey":           "key",
+		"httpHeaderValue1": "acme",
+		"httpHeaderValue2": "Bearer gateway",
+	}
+	ds := &Datasource{BaseURL: server.URL}
+	if res := runSingleQuery(t, ds, pCtx, `{"refId": "A", "measures": ["orders.count"]}`); res.Error != nil {
+		t.Fatalf("query failed: %v", res.Error)
+	}
+	if got := tenant.Load(); got != "acme" {
+		t.Errorf("expected the custom header to be sent, got %v", got)
+	}
+	if got := auth.Load(); got != "Bearer key" {
+		t.Errorf("expected the plugin's Authorization header to take precedence, got %v", got)
+	}
+}
@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// continueWaitResumeWindow is how long a shared query Cube is still
+// computing (in a Continue-wait cycle) keeps polling after its last caller
+// left, so that the same query issued again by a dashboard refresh resumes
+// it instead of starting over.
+const continueWaitResumeWindow = 30 * time.Second
+
+// inflightQueries collapses identical /v1/load queries running at the same
+// time into one upstream request. Dashboards with repeated rows or panels
+// often issue the same Cube query several times at once; the first caller
+// sends it and the others wait for its result, including while it is polling
+// through Continue wait.
+//
+// The shared request does not run on any one caller's context: a caller that
+// gives up (panel closed, dashboard refreshed) stops waiting without failing
+// the others, and the request is only cancelled once every caller is gone.
+// A request already polling through Continue wait is kept for
+// continueWaitResumeWindow after that, for a refresh to pick it up.
+type inflightQueries struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+
+	// resumeWindow overrides continueWaitResumeWindow when positive. Set by
+	// tests to keep them fast.
+	resumeWindow time.Duration
+}
+
+// inflightCall is a shared /v1/load request and the callers waiting for it.
+type inflightCall struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+
+	result CubeAPIResponse
+	err    error
+
+	// Guarded by inflightQueries.mu.
+	waiters      int
+	observers    []*inflightObserver
+	lastProgress continueWaitProgress
+	haveProgress bool
+	// abandon cancels the request once the resume window of a request nobody
+	// waits for is over; nil while callers wait.
+	abandon *time.Timer
+}
+
+// inflightObserver is the Continue-wait observer of a caller of a shared
+// request. running counts the calls of observe in progress, so a caller
+// that leaves can wait for them: observers such as RunStream's send frames,
+// which must not happen once the caller has returned.
+type inflightObserver struct {
+	observe continueWaitObserver
+	running sync.WaitGroup
+}
+
+// do runs load once for all concurrent callers with the same key and returns
+// its result to each of them. The context passed to load keeps the values of
+// the first caller's context (but not its cancellation) and reports
+// Continue-wait progress to the observers of every caller.
+func (g *inflightQueries) do(ctx context.Context, key string, load func(ctx context.Context) (CubeAPIResponse, error)) (CubeAPIResponse, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*inflightCall)
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		call = &inflightCall{done: make(chan struct{})}
+		var callCtx context.Context
+		callCtx, call.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		callCtx = withContinueWaitObserver(callCtx, func(progress continueWaitProgress) {
+			g.observe(call, progress)
+		})
+		g.calls[key] = call
+
+		go func() {
+			defer call.cancel()
+			result, err := load(callCtx)
+
+			g.mu.Lock()
+			call.result, call.err = result, err
+			if call.abandon != nil {
+				call.abandon.Stop()
+				call.abandon = nil
+			}
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	if call.abandon != nil {
+		// Resume a request left polling by a previous caller.
+		call.abandon.Stop()
+		call.abandon = nil
+	}
+	call.waiters++
+	// Callers that stop waiting are unregistered by clearing their slot.
+	slot := len(call.observers)
+	observer := &inflightObserver{observe: continueWaitObserverFrom(ctx)}
+	call.observers = append(call.observers, observer)
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.result, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		call.observers[slot] = nil
+		if call.waiters == 0 {
+			if call.haveProgress {
+				// Cube is computing the result: keep polling for a while in
+				// case the query is issued again.
+				call.abandon = time.AfterFunc(g.resumeWindowDuration(), func() { g.abandon(key, call) })
+			} else {
+				g.drop(key, call)
+			}
+		}
+		progress, haveProgress := call.lastProgress, call.haveProgress
+		g.mu.Unlock()
+		// The observer is unregistered; wait for calls already under way.
+		observer.running.Wait()
+		return CubeAPIResponse{}, interruptedWaitError(ctx.Err(), progress, haveProgress)
+	}
+}
+
+func (g *inflightQueries) resumeWindowDuration() time.Duration {
+	if g.resumeWindow > 0 {
+		return g.resumeWindow
+	}
+	return continueWaitResumeWindow
+}
+
+// drop cancels a request nobody waits for; later callers start afresh. g.mu
+// must be held.
+func (g *inflightQueries) drop(key string, call *inflightCall) {
+	call.cancel()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+// abandon drops a request left polling once its resume window is over,
+// unless a caller resumed it in the meantime.
+func (g *inflightQueries) abandon(key string, call *inflightCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if call.waiters == 0 && call.abandon != nil {
+		call.abandon = nil
+		g.drop(key, call)
+	}
+}
+
+// observe records Continue-wait progress of a shared request and passes it on
+// to every caller waiting for it. The observers run without the lock, each
+// counted as running until it returns.
+func (g *inflightQueries) observe(call *inflightCall, progress continueWaitProgress) {
+	g.mu.Lock()
+	call.lastProgress, call.haveProgress = progress, true
+	observers := make([]*inflightObserver, 0, len(call.observers))
+	for _, observer := range call.observers {
+		if observer != nil && observer.observe != nil {
+			observer.running.Add(1)
+			observers = append(observers, observer)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, observer := range observers {
+		observer.observe(progress)
+		observer.running.Done()
+	}
+}
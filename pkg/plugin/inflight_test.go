@@ -0,0 +1,357 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForWaiters blocks until the in-flight call for key has n callers.
+func waitForWaiters(t *testing.T, g *inflightQueries, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		g.mu.Lock()
+		call := g.calls[key]
+		joined := call != nil && call.waiters == n
+		g.mu.Unlock()
+		if joined {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d callers", n)
+}
+
+func TestInflightQueriesSharesOneLoad(t *testing.T) {
+	var g inflightQueries
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (CubeAPIResponse, error) {
+		loads.Add(1)
+		<-release
+		return CubeAPIResponse{Data: []map[string]interface{}{{"orders.count": "5"}}}, nil
+	}
+
+	const callers = 3
+	var wg sync.WaitGroup
+	results := make([]CubeAPIResponse, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := g.do(context.Background(), "q", load)
+			if err != nil {
+				t.Errorf("caller %d: unexpected error %v", i, err)
+			}
+			results[i] = result
+		}(i)
+	}
+	waitForWaiters(t, &g, "q", callers)
+	close(release)
+	wg.Wait()
+
+	if n := loads.Load(); n != 1 {
+		t.Errorf("expected 1 load, got %d", n)
+	}
+	for i, result := range results {
+		if len(result.Data) != 1 || result.Data[0]["orders.count"] != "5" {
+			t.Errorf("caller %d: unexpected result %v", i, result.Data)
+		}
+	}
+
+	// Once finished, the same key loads again.
+	release = make(chan struct{})
+	close(release)
+	if _, err := g.do(context.Background(), "q", load); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if n := loads.Load(); n != 2 {
+		t.Errorf("expected a second load after the first finished, got %d loads", n)
+	}
+}
+
+func TestInflightQueriesFansOutContinueWaitProgress(t *testing.T) {
+	var g inflightQueries
+	release := make(chan struct{})
+	load := func(ctx context.Context) (CubeAPIResponse, error) {
+		<-release
+		continueWaitObserverFrom(ctx)(continueWaitProgress{Stage: "Executing query", TimeElapsed: 2})
+		return CubeAPIResponse{}, nil
+	}
+
+	var mu sync.Mutex
+	seen := map[string]int{}
+	observed := func(name string) context.Context {
+		return withContinueWaitObserver(context.Background(), func(progress continueWaitProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			if progress.Stage == "Executing query" {
+				seen[name]++
+			}
+		})
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"first", "second"} {
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			_, _ = g.do(ctx, "q", load)
+		}(observed(name))
+	}
+	waitForWaiters(t, &g, "q", 2)
+	close(release)
+	wg.Wait()
+
+	if seen["first"] != 1 || seen["second"] != 1 {
+		t.Errorf("expected every caller to observe the progress once, got %v", seen)
+	}
+}
+
+func TestInflightQueriesCallerCancellation(t *testing.T) {
+	var g inflightQueries
+	release := make(chan struct{})
+	loadCancelled := make(chan struct{})
+	polled := make(chan struct{})
+	load := func(ctx context.Context) (CubeAPIResponse, error) {
+		continueWaitObserverFrom(ctx)(continueWaitProgress{Stage: "Executing query", TimeElapsed: 3})
+		close(polled)
+		select {
+		case <-release:
+			return CubeAPIResponse{Data: []map[string]interface{}{{"orders.count": "1"}}}, nil
+		case <-ctx.Done():
+			close(loadCancelled)
+			return CubeAPIResponse{}, ctx.Err()
+		}
+	}
+
+	leaving, leave := context.WithCancel(context.Background())
+	leftErr := make(chan error, 1)
+	go func() {
+		_, err := g.do(leaving, "q", load)
+		leftErr <- err
+	}()
+	stayed := make(chan error, 1)
+	go func() {
+		_, err := g.do(context.Background(), "q", load)
+		stayed <- err
+	}()
+	waitForWaiters(t, &g, "q", 2)
+	<-polled
+
+	// One caller giving up does not cancel the shared load.
+	leave()
+	err := <-leftErr
+	if err == nil || !strings.Contains(err.Error(), "query cancelled while waiting for Cube") || !strings.Contains(err.Error(), "stage: Executing query") {
+		t.Errorf("expected a cancellation error with progress, got %v", err)
+	}
+	select {
+	case <-loadCancelled:
+		t.Fatal("expected the load to keep running for the remaining caller")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-stayed; err != nil {
+		t.Errorf("expected the remaining caller to get the result, got %v", err)
+	}
+}
+
+func TestInflightQueriesLeavingWaitsForObserver(t *testing.T) {
+	g := inflightQueries{resumeWindow: time.Millisecond}
+	observing := make(chan struct{})
+	finishObserver := make(chan struct{})
+	load := func(ctx context.Context) (CubeAPIResponse, error) {
+		continueWaitObserverFrom(ctx)(continueWaitProgress{Stage: "Executing query"})
+		<-ctx.Done()
+		return CubeAPIResponse{}, ctx.Err()
+	}
+
+	var observerDone atomic.Bool
+	leaving, leave := context.WithCancel(withContinueWaitObserver(context.Background(), func(continueWaitProgress) {
+		close(observing)
+		<-finishObserver
+		observerDone.Store(true)
+	}))
+	returned := make(chan struct{})
+	go func() {
+		_, _ = g.do(leaving, "q", load)
+		close(returned)
+	}()
+	<-observing
+
+	leave()
+	select {
+	case <-returned:
+		t.Fatal("expected the caller to wait for its running observer")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(finishObserver)
+	<-returned
+	if !observerDone.Load() {
+		t.Error("expected the observer to have finished before the caller returned")
+	}
+}
+
+func TestInflightQueriesLastCallerCancelsLoad(t *testing.T) {
+	var g inflightQueries
+	loadCancelled := make(chan struct{})
+	load := func(ctx context.Context) (CubeAPIResponse, error) {
+		<-ctx.Done()
+		close(loadCancelled)
+		return CubeAPIResponse{}, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = g.do(ctx, "q", load)
+	}()
+	waitForWaiters(t, &g, "q", 1)
+	cancel()
+	<-done
+
+	select {
+	case <-loadCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the load to be cancelled once nobody waits for it")
+	}
+
+	// A new caller does not join the abandoned load.
+	result, err := g.do(context.Background(), "q", func(ctx context.Context) (CubeAPIResponse, error) {
+		return CubeAPIResponse{Data: []map[string]interface{}{{"orders.count": "2"}}}, nil
+	})
+	if err != nil || len(result.Data) != 1 {
+		t.Errorf("expected a fresh load, got %v (%v)", result.Data, err)
+	}
+}
+
+func TestInflightQueriesResumeContinueWait(t *testing.T) {
+	g := inflightQueries{resumeWindow: 200 * time.Millisecond}
+	var loads atomic.Int32
+	release := make(chan struct{})
+	loadCancelled := make(chan struct{})
+	polling := func(ctx context.Context) (CubeAPIResponse, error) {
+		loads.Add(1)
+		continueWaitObserverFrom(ctx)(continueWaitProgress{Stage: "Executing query"})
+		select {
+		case <-release:
+			return CubeAPIResponse{Data: []map[string]interface{}{{"orders.count": "1"}}}, nil
+		case <-ctx.Done():
+			close(loadCancelled)
+			return CubeAPIResponse{}, ctx.Err()
+		}
+	}
+	leaveWhilePolling := func() {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = g.do(ctx, "q", polling)
+		}()
+		waitForWaiters(t, &g, "q", 1)
+		for {
+			g.mu.Lock()
+			polled := g.calls["q"].haveProgress
+			g.mu.Unlock()
+			if polled {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+		<-done
+	}
+
+	// A refresh issuing the query again resumes the load left polling.
+	leaveWhilePolling()
+	resumed := make(chan error, 1)
+	go func() {
+		_, err := g.do(context.Background(), "q", polling)
+		resumed <- err
+	}()
+	waitForWaiters(t, &g, "q", 1)
+	close(release)
+	if err := <-resumed; err != nil {
+		t.Errorf("expected the resumed load to return its result, got %v", err)
+	}
+	if n := loads.Load(); n != 1 {
+		t.Errorf("expected 1 load, got %d", n)
+	}
+
+	// Nobody resuming it within the window cancels it.
+	release = make(chan struct{})
+	leaveWhilePolling()
+	select {
+	case <-loadCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the load to be cancelled after the resume window")
+	}
+}
+
+func TestQueryDataDeduplicatesConcurrentIdenticalQueries(t *testing.T) {
+	var loads atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		loads.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CubeAPIResponse{
+			Data:       []map[string]interface{}{{"orders.count": "42"}},
+			Annotation: CubeAnnotation{Measures: map[string]CubeFieldInfo{"orders.count": {Type: "number"}}},
+		})
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+	const panels = 4
+	var wg sync.WaitGroup
+	for i := 0; i < panels; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := runSingleQuery(t, ds, pCtx, `{"refId": "A", "measures": ["orders.count"]}`)
+			if resp.Error != nil {
+				t.Errorf("unexpected error %v", resp.Error)
+				return
+			}
+			if v := resp.Frames[0].Fields[0].At(0).(*float64); v == nil || *v != 42 {
+				t.Errorf("unexpected value %v", v)
+			}
+		}()
+	}
+
+	// Wait until every panel has joined the single in-flight request.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ds.inflight.mu.Lock()
+		waiters := 0
+		for _, call := range ds.inflight.calls {
+			waiters += call.waiters
+		}
+		ds.inflight.mu.Unlock()
+		if waiters == panels {
+			break
+		}
+		if time.Now().After(deadline) {
+			close(release)
+			t.Fatalf("only %d of %d panels joined the in-flight query", waiters, panels)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := loads.Load(); n != 1 {
+		t.Errorf("expected 1 /v1/load request, got %d", n)
+	}
+}
@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"bytes"
+	"maps"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// instanceState is what NewDatasource derives from the datasource settings
+// once, instead of on every request: the parsed settings, the outcome of
+// loading the TLS certificates and the parsed JWT signing key. Grafana
+// creates a new instance when the settings change, so the state never goes
+// stale; requests carrying other settings (datasources built without
+// NewDatasource, as in tests) fall back to loading them.
+type instanceState struct {
+	// settings are the instance settings the state was built from.
+	settings backend.DataSourceInstanceSettings
+
+	config    *models.PluginSettings
+	configErr error
+
+	// tlsErr is the error of loading the TLS settings of config.
+	tlsErr error
+
+	// signer is the JWT signing key of self-hosted deployments.
+	signer *jwtSigner
+}
+
+// jwtSigner is a JWT signing key parsed for the algorithm and key it was
+// built from.
+type jwtSigner struct {
+	algorithm string
+	key       string
+
+	method     jwt.SigningMethod
+	signingKey interface{}
+	err        error
+}
+
+// newInstanceState loads settings.
+func newInstanceState(settings backend.DataSourceInstanceSettings) *instanceState {
+	state := &instanceState{settings: settings}
+	state.config, state.configErr = models.LoadPluginSettings(settings)
+	if state.configErr != nil {
+		return state
+	}
+	_, state.tlsErr = clientTLSConfig(state.config)
+	if state.config.DeploymentType == "self-hosted" {
+		opts := jwtOptionsFrom(state.config)
+		signer := &jwtSigner{algorithm: opts.algorithm(), key: opts.Key}
+		signer.method, signer.signingKey, signer.err = opts.signingKey()
+		state.signer = signer
+	}
+	return state
+}
+
+// matches reports whether settings are the ones the state was built from.
+func (s *instanceState) matches(settings backend.DataSourceInstanceSettings) bool {
+	return s.settings.UID == settings.UID &&
+		s.settings.Updated.Equal(settings.Updated) &&
+		s.settings.URL == settings.URL &&
+		bytes.Equal(s.settings.JSONData, settings.JSONData) &&
+		maps.Equal(s.settings.DecryptedSecureJSONData, settings.DecryptedSecureJSONData)
+}
+
+// loadSettings returns the parsed settings, from the instance state when it
+// was built from settings.
+func (d *Datasource) loadSettings(settings backend.DataSourceInstanceSettings) (*models.PluginSettings, error) {
+	if d.state != nil && d.state.matches(settings) {
+		return d.state.config, d.state.configErr
+	}
+	return models.LoadPluginSettings(settings)
+}
+
+// pluginSettings returns the datasource settings, or nil when there are none
+// or they cannot be loaded; settings errors are reported by buildAPIURL when
+// Cube is called.
+func (d *Datasource) pluginSettings(pCtx backend.PluginContext) *models.PluginSettings {
+	if pCtx.DataSourceInstanceSettings == nil {
+		return nil
+	}
+	config, err := d.loadSettings(*pCtx.DataSourceInstanceSettings)
+	if err != nil {
+		return nil
+	}
+	return config
+}
+
+// checkTLSConfig reports whether the TLS settings of config can be loaded,
+// without loading them again for the instance's own settings.
+func (d *Datasource) checkTLSConfig(config *models.PluginSettings) error {
+	if d.state != nil && d.state.config == config {
+		return d.state.tlsErr
+	}
+	_, err := clientTLSConfig(config)
+	return err
+}
+
+// signingKey returns the signing method and the parsed key of opts, parsed
+// once per instance for the instance's own key.
+func (d *Datasource) signingKey(opts jwtOptions) (jwt.SigningMethod, interface{}, error) {
+	if d.state != nil && d.state.signer != nil {
+		signer := d.state.signer
+		if signer.algorithm == opts.algorithm() && signer.key == opts.Key {
+			return signer.method, signer.signingKey, signer.err
+		}
+	}
+	return opts.signingKey()
+}
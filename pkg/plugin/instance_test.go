@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestInstanceStateSettings(t *testing.T) {
+	settings := backend.DataSourceInstanceSettings{
+		UID:                     "cube",
+		JSONData:                []byte(`{"url": "http://localhost:4000", "deploymentType": "self-hosted"}`),
+		DecryptedSecureJSONData: map[string]string{"apiSecret": "secret"},
+	}
+	instance, err := NewDatasource(context.Background(), settings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ds := instance.(*Datasource)
+	defer ds.Dispose()
+
+	first, err := ds.loadSettings(settings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second, _ := ds.loadSettings(settings); second != first {
+		t.Errorf("expected the instance's settings to be parsed once")
+	}
+
+	other := settings
+	other.DecryptedSecureJSONData = map[string]string{"apiSecret": "other"}
+	config, err := ds.loadSettings(other)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config == first || config.Secrets.ApiSecret != "other" {
+		t.Errorf("expected other settings to be loaded, got %+v", config.Secrets)
+	}
+}
+
+func TestInstanceStateSigningKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := backend.DataSourceInstanceSettings{
+		JSONData:                []byte(`{"url": "http://localhost:4000", "deploymentType": "self-hosted", "jwtAlgorithm": "ES256"}`),
+		DecryptedSecureJSONData: map[string]string{"jwtPrivateKey": privateKeyPEM(t, ecKey)},
+	}
+	ds := &Datasource{state: newInstanceState(settings)}
+	if ds.state.signer == nil || ds.state.signer.err != nil {
+		t.Fatalf("expected the signing key to be parsed, got %+v", ds.state.signer)
+	}
+
+	config, _ := ds.loadSettings(settings)
+	_, key, err := ds.signingKey(jwtOptionsFrom(config))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if key != ds.state.signer.signingKey {
+		t.Errorf("expected the parsed signing key to be reused")
+	}
+
+	invalid := &Datasource{state: newInstanceState(backend.DataSourceInstanceSettings{
+		JSONData:                settings.JSONData,
+		DecryptedSecureJSONData: map[string]string{"jwtPrivateKey": "not a key"},
+	})}
+	if _, err := invalid.authToken(invalid.pluginSettings(backend.PluginContext{DataSourceInstanceSettings: &invalid.state.settings})); err == nil {
+		t.Errorf("expected an invalid signing key to be reported")
+	}
+}
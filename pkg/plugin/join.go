@@ -0,0 +1,237 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Join types.
+const (
+	joinTypeLeft  = "left"
+	joinTypeInner = "inner"
+)
+
+// QueryJoin joins the result of a query to the result of another query of
+// the panel (see CubeQuery.Join).
+type QueryJoin struct {
+	// RefID is the query joined to.
+	RefID string `json:"refId"`
+	// On are the dimensions of this query rows are matched on, and LeftOn
+	// the dimensions of the RefID query they match, in the same order;
+	// LeftOn defaults to On, for views sharing dimension names.
+	On     []string `json:"on"`
+	LeftOn []string `json:"leftOn,omitempty"`
+	// Type is "left" (the default), which keeps the rows of the RefID query
+	// that match no row, or "inner", which drops them.
+	Type string `json:"type,omitempty"`
+}
+
+// leftOn returns the dimensions of the query joined to.
+func (j *QueryJoin) leftOn() []string {
+	if len(j.LeftOn) > 0 {
+		return j.LeftOn
+	}
+	return j.On
+}
+
+// validateJoin checks the join of a query.
+func validateJoin(query CubeQuery) error {
+	j := query.Join
+	if j == nil {
+		return nil
+	}
+	if j.RefID == "" || j.RefID == query.RefID {
+		return errors.New("join must name another query of the panel")
+	}
+	if len(j.On) == 0 {
+		return errors.New("join needs at least one dimension to join on")
+	}
+	if len(j.LeftOn) > 0 && len(j.LeftOn) != len(j.On) {
+		return fmt.Errorf("join has %d dimensions on and %d leftOn", len(j.On), len(j.LeftOn))
+	}
+	if j.Type != "" && j.Type != joinTypeLeft && j.Type != joinTypeInner {
+		return fmt.Errorf("invalid join type %q: must be %q or %q", j.Type, joinTypeLeft, joinTypeInner)
+	}
+	if len(query.Blend) > 0 {
+		return errors.New("blended queries cannot be joined")
+	}
+	for _, member := range j.On {
+		if !slices.Contains(query.Dimensions, member) {
+			return fmt.Errorf("join dimension %s is not a dimension of the query", member)
+		}
+	}
+	return nil
+}
+
+// joinResponses merges the results of the queries that join another query
+// into the result of that query, so the panel gets one frame. The joining
+// query's response is left empty. Queries that failed are not joined.
+func joinResponses(queries []backend.DataQuery, responses backend.Responses) {
+	joins := make(map[string]*QueryJoin)
+	for _, q := range queries {
+		var parsed struct {
+			Join *QueryJoin `json:"join"`
+		}
+		if json.Unmarshal(q.JSON, &parsed) == nil && parsed.Join != nil {
+			joins[q.RefID] = parsed.Join
+		}
+	}
+	for _, q := range queries {
+		j := joins[q.RefID]
+		if j == nil {
+			continue
+		}
+		right := responses[q.RefID]
+		if right.Error != nil {
+			continue
+		}
+		left, ok := responses[j.RefID]
+		if !ok {
+			responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("join query %s is not part of the request", j.RefID))
+			continue
+		}
+		if joins[j.RefID] != nil {
+			responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("query %s joins another query and cannot be joined to", j.RefID))
+			continue
+		}
+		if left.Error != nil {
+			responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("join query %s failed", j.RefID))
+			continue
+		}
+		if len(left.Frames) != 1 || len(right.Frames) != 1 {
+			responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, "joined queries must return a single frame")
+			continue
+		}
+		merged, err := joinFrames(left.Frames[0], right.Frames[0], j, q.RefID)
+		if err != nil {
+			responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+			continue
+		}
+		left.Frames = data.Frames{merged}
+		responses[j.RefID] = left
+		responses[q.RefID] = backend.DataResponse{Status: right.Status}
+	}
+}
+
+// joinFrames hash joins right to left: each row of left is repeated for every
+// row of right with the same join key, with the fields of right other than
+// its join fields added. Null keys match nothing. Right fields named like a
+// left field are labeled with the right query's refId.
+func joinFrames(left, right *data.Frame, j *QueryJoin, refID string) (*data.Frame, error) {
+	leftKeys, err := joinKeyFields(left, j.leftOn())
+	if err != nil {
+		return nil, err
+	}
+	rightKeys, err := joinKeyFields(right, j.On)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make(map[string][]int)
+	for row := 0; row < right.Rows(); row++ {
+		if key, ok := joinKey(right, rightKeys, row); ok {
+			matches[key] = append(matches[key], row)
+		}
+	}
+
+	// Rows of the joined frame: a left row and its right row, -1 for none.
+	var pairs [][2]int
+	for row := 0; row < left.Rows(); row++ {
+		key, ok := joinKey(left, leftKeys, row)
+		if ok && len(matches[key]) > 0 {
+			for _, match := range matches[key] {
+				pairs = append(pairs, [2]int{row, match})
+			}
+		} else if j.Type != joinTypeInner {
+			pairs = append(pairs, [2]int{row, -1})
+		}
+	}
+
+	names := make(map[string]bool, len(left.Fields))
+	merged := data.NewFrame(left.Name)
+	for _, field := range left.Fields {
+		names[field.Name] = true
+		merged.Fields = append(merged.Fields, joinedField(field, pairs, 0))
+	}
+	for f, field := range right.Fields {
+		if slices.Contains(rightKeys, f) {
+			continue
+		}
+		joined := joinedField(field, pairs, 1)
+		if names[field.Name] {
+			joined.Labels = data.Labels{blendLabel: refID}
+		}
+		merged.Fields = append(merged.Fields, joined)
+	}
+	if left.Meta != nil {
+		meta := *left.Meta
+		merged.Meta = &meta
+	}
+	if right.Meta != nil {
+		merged.AppendNotices(right.Meta.Notices...)
+	}
+	return merged, nil
+}
+
+// joinKeyFields returns the indexes of the fields of frame named members.
+func joinKeyFields(frame *data.Frame, members []string) ([]int, error) {
+	indexes := make([]int, len(members))
+	for i, member := range members {
+		indexes[i] = -1
+		for f, field := range frame.Fields {
+			if field.Name == member {
+				indexes[i] = f
+				break
+			}
+		}
+		if indexes[i] < 0 {
+			return nil, fmt.Errorf("join dimension %s is not in the result", member)
+		}
+	}
+	return indexes, nil
+}
+
+// joinKey returns the join key of a row of frame, and false when one of its
+// key values is null.
+func joinKey(frame *data.Frame, fields []int, row int) (string, bool) {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		v, ok := frame.Fields[f].ConcreteAt(row)
+		if !ok {
+			return "", false
+		}
+		if t, isTime := v.(time.Time); isTime {
+			v = t.UnixNano()
+		}
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, "\x00"), true
+}
+
+// joinedField returns field with the rows of side (0 for left, 1 for right)
+// of pairs, as a nullable field.
+func joinedField(field *data.Field, pairs [][2]int, side int) *data.Field {
+	joined := data.NewFieldFromFieldType(field.Type().NullableType(), len(pairs))
+	joined.Name = field.Name
+	joined.Labels = field.Labels
+	if field.Config != nil {
+		config := *field.Config
+		joined.Config = &config
+	}
+	for i, pair := range pairs {
+		if pair[side] < 0 {
+			continue
+		}
+		if v, ok := field.ConcreteAt(pair[side]); ok {
+			joined.SetConcrete(i, v)
+		}
+	}
+	return joined
+}
@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestJoinResponses(t *testing.T) {
+	left := data.NewFrame("response",
+		data.NewField("orders.status", nil, []string{"completed", "shipped", "cancelled"}),
+		data.NewField("orders.count", nil, []float64{10, 20, 30}))
+	right := data.NewFrame("response",
+		data.NewField("returns.order_status", nil, []string{"shipped", "completed"}),
+		data.NewField("orders.count", nil, []float64{2, 1}))
+
+	for name, tt := range map[string]struct {
+		joinType string
+		statuses []string
+		returns  []*float64
+	}{
+		"left":  {"", []string{"completed", "shipped", "cancelled"}, []*float64{floatPtr(1), floatPtr(2), nil}},
+		"inner": {"inner", []string{"completed", "shipped"}, []*float64{floatPtr(1), floatPtr(2)}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			queries := []backend.DataQuery{
+				{RefID: "A", JSON: []byte(`{"refId": "A"}`)},
+				{RefID: "B", JSON: []byte(`{"refId": "B", "join": {"refId": "A", "on": ["returns.order_status"], "leftOn": ["orders.status"], "type": "` + tt.joinType + `"}}`)},
+			}
+			responses := backend.Responses{
+				"A": {Frames: data.Frames{left}},
+				"B": {Frames: data.Frames{right}},
+			}
+			joinResponses(queries, responses)
+
+			if len(responses["B"].Frames) != 0 || responses["B"].Error != nil {
+				t.Errorf("expected the joining query's response to be empty, got %+v", responses["B"])
+			}
+			merged := responses["A"].Frames[0]
+			if len(merged.Fields) != 3 {
+				t.Fatalf("expected the left fields and the right measure, got %d fields", len(merged.Fields))
+			}
+			if merged.Rows() != len(tt.statuses) {
+				t.Fatalf("expected %d rows, got %d", len(tt.statuses), merged.Rows())
+			}
+			for i, status := range tt.statuses {
+				if got, _ := merged.Fields[0].ConcreteAt(i); got != status {
+					t.Errorf("row %d: expected %s, got %v", i, status, got)
+				}
+			}
+			if merged.Fields[2].Labels[blendLabel] != "B" {
+				t.Errorf("expected the clashing right field to be labeled, got %v", merged.Fields[2].Labels)
+			}
+			assertFloats(t, "returns", nullableFloats(t, merged.Fields[2]), tt.returns)
+		})
+	}
+}
+
+func TestJoinResponsesErrors(t *testing.T) {
+	frame := data.NewFrame("response", data.NewField("orders.status", nil, []string{"completed"}))
+	queries := []backend.DataQuery{
+		{RefID: "B", JSON: []byte(`{"join": {"refId": "Z", "on": ["orders.status"]}}`)},
+		{RefID: "C", JSON: []byte(`{"join": {"refId": "D", "on": ["orders.city"]}}`)},
+		{RefID: "D"},
+	}
+	responses := backend.Responses{
+		"B": {Frames: data.Frames{frame}},
+		"C": {Frames: data.Frames{frame}},
+		"D": {Frames: data.Frames{frame}},
+	}
+	joinResponses(queries, responses)
+	for _, refID := range []string{"B", "C"} {
+		if responses[refID].Status != backend.StatusBadRequest {
+			t.Errorf("%s: expected a bad request, got %v: %v", refID, responses[refID].Status, responses[refID].Error)
+		}
+	}
+	if len(responses["D"].Frames[0].Fields) != 1 {
+		t.Errorf("expected the query joined to to be unchanged")
+	}
+}
+
+func TestQueryDataJoinValidation(t *testing.T) {
+	ds := &Datasource{}
+	pCtx := newTestPluginContext("http://localhost:4000")
+	for name, query := range map[string]string{
+		"no dimensions":     `{"refId": "B", "measures": ["orders.count"], "dimensions": ["orders.status"], "join": {"refId": "A"}}`,
+		"self join":         `{"refId": "B", "measures": ["orders.count"], "dimensions": ["orders.status"], "join": {"refId": "B", "on": ["orders.status"]}}`,
+		"not a dimension":   `{"refId": "B", "measures": ["orders.count"], "join": {"refId": "A", "on": ["orders.status"]}}`,
+		"mismatched leftOn": `{"refId": "B", "measures": ["orders.count"], "dimensions": ["orders.status"], "join": {"refId": "A", "on": ["orders.status"], "leftOn": ["a.x", "a.y"]}}`,
+		"invalid join type": `{"refId": "B", "measures": ["orders.count"], "dimensions": ["orders.status"], "join": {"refId": "A", "on": ["orders.status"], "type": "full"}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp := ds.query(context.Background(), pCtx, backend.DataQuery{RefID: "B", JSON: []byte(query)})
+			if resp.Status != backend.StatusBadRequest {
+				t.Errorf("expected 400, got %v: %v", resp.Status, resp.Error)
+			}
+		})
+	}
+}
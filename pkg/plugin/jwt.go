@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/grafana/cube/pkg/models"
+)
+
+// defaultJWTTTL is the lifetime of the JWTs signed for self-hosted Cube when
+// jwtTTL is not set.
+const defaultJWTTTL = time.Hour
+
+// jwtOptions describes how the JWT sent to a self-hosted Cube is signed.
+// Zero values select the defaults: HS256 and a one hour lifetime.
+type jwtOptions struct {
+	// Algorithm is one of models.ValidJWTAlgorithms.
+	Algorithm string
+	// Key is the API secret for HS256, or the PEM encoded private key for
+	// RS256 and ES256.
+	Key string
+	TTL time.Duration
+	// Issuer and Audience set the iss and aud claims when not empty, for
+	// Cube deployments that check them (CUBEJS_JWT_ISSUER, CUBEJS_JWT_AUDIENCE).
+	Issuer   string
+	Audience string
+	// SecurityContext holds extra claims; see generateJWT.
+	SecurityContext map[string]interface{}
+}
+
+// jwtOptionsFrom returns the JWT options of a self-hosted datasource.
+func jwtOptionsFrom(config *models.PluginSettings) jwtOptions {
+	opts := jwtOptions{
+		Algorithm:       config.JWTAlgorithm,
+		Key:             config.Secrets.ApiSecret,
+		TTL:             config.JWTTTLDuration(),
+		Issuer:          config.JWTIssuer,
+		Audience:        config.JWTAudience,
+		SecurityContext: config.SecurityContext,
+	}
+	if opts.algorithm() != models.JWTAlgorithmHS256 {
+		opts.Key = config.Secrets.JWTPrivateKey
+	}
+	return opts
+}
+
+func (o jwtOptions) algorithm() string {
+	if o.Algorithm == "" {
+		return models.JWTAlgorithmHS256
+	}
+	return o.Algorithm
+}
+
+func (o jwtOptions) ttl() time.Duration {
+	if o.TTL <= 0 {
+		return defaultJWTTTL
+	}
+	return o.TTL
+}
+
+// cacheFor returns how long a signed token is reused: until about 92% of its
+// lifetime (55 minutes of an hour), so it is refreshed before it expires.
+func (o jwtOptions) cacheFor() time.Duration {
+	return o.ttl() * 11 / 12
+}
+
+// cacheKey returns the key of the token signed with o in the JWT cache: the
+// key alone when every other option has its default.
+func (o jwtOptions) cacheKey() (string, error) {
+	if o.algorithm() == models.JWTAlgorithmHS256 && o.TTL <= 0 && o.Issuer == "" && o.Audience == "" && len(o.SecurityContext) == 0 {
+		return o.Key, nil
+	}
+	// Maps marshal with sorted keys, so equal options share a key.
+	encoded, err := json.Marshal(map[string]interface{}{
+		"alg": o.algorithm(),
+		"ttl": o.ttl().String(),
+		"iss": o.Issuer,
+		"aud": o.Audience,
+		"ctx": o.SecurityContext,
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalid security context: %w", err)
+	}
+	return o.Key + "\x00" + string(encoded), nil
+}
+
+// signingKey returns the signing method and the parsed key of o.
+func (o jwtOptions) signingKey() (jwt.SigningMethod, interface{}, error) {
+	switch o.algorithm() {
+	case models.JWTAlgorithmHS256:
+		return jwt.SigningMethodHS256, []byte(o.Key), nil
+	case models.JWTAlgorithmRS256:
+		key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(o.Key))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid RSA private key: %w", err)
+		}
+		return jwt.SigningMethodRS256, key, nil
+	case models.JWTAlgorithmES256:
+		key, err := jwt.ParseECPrivateKeyFromPEM([]byte(o.Key))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid ECDSA private key: %w", err)
+		}
+		return jwt.SigningMethodES256, key, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported JWT algorithm: %q", o.Algorithm)
+	}
+}
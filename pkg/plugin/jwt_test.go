@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/grafana/cube/pkg/models"
+)
+
+// privateKeyPEM returns key PKCS#8 encoded as PEM.
+func privateKeyPEM(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestGenerateJWTAsymmetric(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %v", err)
+	}
+	tests := []struct {
+		algorithm string
+		key       crypto.Signer
+	}{
+		{models.JWTAlgorithmRS256, rsaKey},
+		{models.JWTAlgorithmES256, ecKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			ds := &Datasource{}
+			tokenString, err := ds.generateJWT(jwtOptions{
+				Algorithm: tt.algorithm,
+				Key:       privateKeyPEM(t, tt.key),
+				TTL:       10 * time.Minute,
+				Issuer:    "grafana",
+				Audience:  "cube",
+			})
+			if err != nil {
+				t.Fatalf("generateJWT failed: %v", err)
+			}
+
+			claims := jwt.MapClaims{}
+			token, err := jwt.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+				return tt.key.Public(), nil
+			}, jwt.WithValidMethods([]string{tt.algorithm}), jwt.WithIssuer("grafana"), jwt.WithAudience("cube"))
+			if err != nil || !token.Valid {
+				t.Fatalf("Failed to verify token: %v", err)
+			}
+			exp, err := claims.GetExpirationTime()
+			if err != nil {
+				t.Fatalf("Failed to read exp: %v", err)
+			}
+			if until := time.Until(exp.Time); until > 10*time.Minute || until < 9*time.Minute {
+				t.Errorf("Expected the token to expire in 10 minutes, expires in %s", until)
+			}
+		})
+	}
+}
+
+func TestGenerateJWTInvalidPrivateKey(t *testing.T) {
+	ds := &Datasource{}
+	if _, err := ds.generateJWT(jwtOptions{Algorithm: models.JWTAlgorithmRS256, Key: "not a key"}); err == nil {
+		t.Error("Expected an error for an invalid private key")
+	}
+}
+
+func TestJWTOptionsCaching(t *testing.T) {
+	if key, _ := (jwtOptions{Key: "secret"}).cacheKey(); key != "secret" {
+		t.Errorf("Expected the default options to be keyed by secret, got %q", key)
+	}
+	defaults, _ := jwtOptions{Key: "secret"}.cacheKey()
+	shortLived, _ := jwtOptions{Key: "secret", TTL: time.Minute}.cacheKey()
+	withIssuer, _ := jwtOptions{Key: "secret", Issuer: "grafana"}.cacheKey()
+	if shortLived == defaults || withIssuer == defaults || shortLived == withIssuer {
+		t.Error("Expected different options to give different cache keys")
+	}
+
+	if got := (jwtOptions{}).cacheFor(); got != 55*time.Minute {
+		t.Errorf("Expected default tokens to be cached for 55m, got %s", got)
+	}
+	if got := (jwtOptions{TTL: 12 * time.Minute}).cacheFor(); got != 11*time.Minute {
+		t.Errorf("Expected 12m tokens to be cached for 11m, got %s", got)
+	}
+}
+
+func TestJWTOptionsFrom(t *testing.T) {
+	ttl := 600
+	config := &models.PluginSettings{
+		JWTAlgorithm: models.JWTAlgorithmES256,
+		JWTTTL:       &ttl,
+		Secrets:      &models.SecretPluginSettings{ApiSecret: "secret", JWTPrivateKey: "pem"},
+	}
+	opts := jwtOptionsFrom(config)
+	if opts.Key != "pem" || opts.TTL != 10*time.Minute {
+		t.Errorf("Expected the private key and a 10m TTL, got %+v", opts)
+	}
+	config.JWTAlgorithm = ""
+	if opts := jwtOptionsFrom(config); opts.Key != "secret" {
+		t.Errorf("Expected HS256 to sign with the API secret, got %q", opts.Key)
+	}
+}
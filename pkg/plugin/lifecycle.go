@@ -0,0 +1,201 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// disposeGracePeriod bounds how long Dispose waits for the requests still
+// running on a replaced instance (typically queries polling Cube with
+// Continue wait) before releasing its connections.
+const disposeGracePeriod = 30 * time.Second
+
+// drainPollInterval is how often Dispose checks for finished requests.
+const drainPollInterval = 50 * time.Millisecond
+
+// liveInstances tracks the current instance of every datasource by UID, so the
+// instance Grafana creates after a settings change can take over the warm
+// caches of the instance it replaces instead of starting cold.
+var liveInstances = struct {
+	mu    sync.Mutex
+	byUID map[string]*Datasource
+}{byUID: make(map[string]*Datasource)}
+
+// cacheFingerprint identifies the settings the Cube responses cached by an
+// instance depend on: where Cube is and the credentials sent to it, which
+// decide the security context. Instances with the same fingerprint can share
+// metadata. An empty fingerprint matches nothing.
+func cacheFingerprint(config *models.PluginSettings) string {
+	if config == nil {
+		return ""
+	}
+	// Cube can serve a different model per security context.
+	securityContext, _ := json.Marshal(config.SecurityContext)
+	customHeaders, _ := json.Marshal(config.CustomHeaders)
+	h := sha256.New()
+	parts := []string{
+		config.URL,
+		config.DeploymentType,
+		config.Secrets.ApiKey,
+		config.Secrets.ApiSecret,
+		config.Secrets.JWTPrivateKey,
+		config.Secrets.TLSClientCert,
+		string(securityContext),
+		string(customHeaders),
+	}
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// register makes d the live instance of its datasource, taking over the warm
+// caches of the instance it replaces. Signed JWTs are keyed by secret and
+// security context and are always reused; metadata and the database schema
+// only when the fingerprints match.
+func (d *Datasource) register() {
+	if d.uid == "" {
+		return
+	}
+	liveInstances.mu.Lock()
+	prev := liveInstances.byUID[d.uid]
+	liveInstances.byUID[d.uid] = d
+	liveInstances.mu.Unlock()
+
+	if prev == nil {
+		return
+	}
+	prev.jwtCacheMutex.RLock()
+	d.jwtCacheMutex.Lock()
+	if d.jwtCache == nil {
+		d.jwtCache = make(map[string]jwtCacheEntry)
+	}
+	maps.Copy(d.jwtCache, prev.jwtCache)
+	d.jwtCacheMutex.Unlock()
+	prev.jwtCacheMutex.RUnlock()
+
+	if d.fingerprint == "" || d.fingerprint != prev.fingerprint {
+		return
+	}
+	prev.meta.mu.Lock()
+	d.meta.meta, d.meta.fetchedAt = prev.meta.meta, prev.meta.fetchedAt
+	prev.meta.mu.Unlock()
+
+	prev.dbSchema.mu.Lock()
+	d.dbSchema.schema, d.dbSchema.fetchedAt = prev.dbSchema.schema, prev.dbSchema.fetchedAt
+	prev.dbSchema.mu.Unlock()
+
+	prev.validators.mu.Lock()
+	d.validators.entries = maps.Clone(prev.validators.entries)
+	prev.validators.mu.Unlock()
+}
+
+// unregister removes d from the live instances unless it was already
+// replaced.
+func (d *Datasource) unregister() {
+	liveInstances.mu.Lock()
+	defer liveInstances.mu.Unlock()
+	if liveInstances.byUID[d.uid] == d {
+		delete(liveInstances.byUID, d.uid)
+	}
+}
+
+// trackRequest counts a request as running on d until the returned function
+// is called, so Dispose can let it finish.
+func (d *Datasource) trackRequest() func() {
+	d.activeRequests.Add(1)
+	return func() { d.activeRequests.Add(-1) }
+}
+
+// drain waits until no request is running on d, or until the grace period
+// has passed. It reports whether every request finished.
+func (d *Datasource) drain(grace time.Duration) bool {
+	deadline := time.Now().Add(grace)
+	for d.activeRequests.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+	return true
+}
+
+// errInstanceDisposed is the cancellation cause of the Cube loads still
+// running when their instance is disposed.
+var errInstanceDisposed = errors.New("datasource instance disposed")
+
+// activeLoads tracks the /v1/load calls running on an instance, Continue-wait
+// polling included, so Dispose can cancel the ones that outlive the grace
+// period instead of leaving them polling Cube for an instance nobody uses.
+type activeLoads struct {
+	mu      sync.Mutex
+	next    uint64
+	cancels map[uint64]context.CancelCauseFunc
+	closed  bool
+}
+
+// track derives the context of a load from ctx and registers it until the
+// returned function is called. Loads started after cancelAll are cancelled
+// right away.
+func (l *activeLoads) track(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		cancel(errInstanceDisposed)
+		return ctx, func() {}
+	}
+	if l.cancels == nil {
+		l.cancels = make(map[uint64]context.CancelCauseFunc)
+	}
+	id := l.next
+	l.next++
+	l.cancels[id] = cancel
+	return ctx, func() {
+		l.mu.Lock()
+		delete(l.cancels, id)
+		l.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancelAll cancels the running loads and every later one. It returns how
+// many loads were running.
+func (l *activeLoads) cancelAll() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for _, cancel := range l.cancels {
+		cancel(errInstanceDisposed)
+	}
+	n := len(l.cancels)
+	clear(l.cancels)
+	return n
+}
+
+// Shutdown cancels the Cube loads still running on every live instance. It is
+// called when the plugin stops serving requests.
+func Shutdown() {
+	liveInstances.mu.Lock()
+	instances := make([]*Datasource, 0, len(liveInstances.byUID))
+	for _, d := range liveInstances.byUID {
+		instances = append(instances, d)
+	}
+	liveInstances.mu.Unlock()
+
+	for _, d := range instances {
+		if n := d.loads.cancelAll(); n > 0 {
+			backend.Logger.Info("Cancelled Cube queries on shutdown", "datasource", d.uid, "queries", n)
+		}
+	}
+}
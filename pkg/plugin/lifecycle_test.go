@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func newTestInstance(t *testing.T, settings backend.DataSourceInstanceSettings) *Datasource {
+	t.Helper()
+	instance, err := NewDatasource(context.Background(), settings)
+	if err != nil {
+		t.Fatalf("NewDatasource() error: %v", err)
+	}
+	ds := instance.(*Datasource)
+	t.Cleanup(ds.unregister)
+	return ds
+}
+
+func TestNewDatasourceTakesOverWarmCaches(t *testing.T) {
+	settings := backend.DataSourceInstanceSettings{
+		UID:                     "cube-reload",
+		URL:                     "http://cube:4000",
+		JSONData:                []byte(`{"deploymentType": "self-hosted"}`),
+		DecryptedSecureJSONData: map[string]string{"apiSecret": "secret"},
+	}
+	old := newTestInstance(t, settings)
+	meta := &CubeMetaResponse{Cubes: []CubeMeta{{Name: "orders"}}}
+	old.meta.meta, old.meta.fetchedAt = meta, time.Now()
+	token, err := old.generateJWT(jwtOptions{Key: "secret"})
+	if err != nil {
+		t.Fatalf("generateJWT() error: %v", err)
+	}
+
+	// A compatible settings change (here, a display option) keeps everything.
+	settings.JSONData = []byte(`{"deploymentType": "self-hosted", "seriesColors": {"failed": "red"}}`)
+	next := newTestInstance(t, settings)
+	if next.meta.meta != meta {
+		t.Error("expected the metadata to be handed over")
+	}
+	if next.jwtCache["secret"].token != token {
+		t.Error("expected the signed JWT to be handed over")
+	}
+
+	// Pointing the datasource at another Cube drops the metadata.
+	settings.URL = "http://other-cube:4000"
+	moved := newTestInstance(t, settings)
+	if moved.meta.meta != nil {
+		t.Error("expected no metadata to be handed over to an instance of another Cube")
+	}
+	if moved.jwtCache["secret"].token != token {
+		t.Error("expected JWTs, which are keyed by secret, to be handed over")
+	}
+
+	// So does changing the security context the JWTs carry.
+	moved.meta.meta, moved.meta.fetchedAt = meta, time.Now()
+	settings.JSONData = []byte(`{"deploymentType": "self-hosted", "securityContext": {"tenant": "acme"}}`)
+	if scoped := newTestInstance(t, settings); scoped.meta.meta != nil {
+		t.Error("expected no metadata to be handed over to an instance with another security context")
+	}
+}
+
+func TestNewDatasourceWithoutPreviousInstance(t *testing.T) {
+	ds := newTestInstance(t, backend.DataSourceInstanceSettings{UID: "cube-fresh", JSONData: []byte(`{}`)})
+	if ds.meta.meta != nil || len(ds.jwtCache) != 0 {
+		t.Error("expected a new datasource to start with empty caches")
+	}
+}
+
+func TestDisposeWaitsForRunningRequests(t *testing.T) {
+	ds := newTestInstance(t, backend.DataSourceInstanceSettings{UID: "cube-dispose", JSONData: []byte(`{}`)})
+	done := ds.trackRequest()
+
+	disposed := make(chan struct{})
+	go func() {
+		ds.Dispose()
+		close(disposed)
+	}()
+
+	select {
+	case <-disposed:
+		t.Fatal("expected Dispose to wait for the running request")
+	case <-time.After(100 * time.Millisecond):
+	}
+	done()
+	select {
+	case <-disposed:
+	case <-time.After(time.Second):
+		t.Fatal("expected Dispose to return once the request finished")
+	}
+}
+
+func TestDrainGivesUpAfterGracePeriod(t *testing.T) {
+	ds := &Datasource{}
+	done := ds.trackRequest()
+	defer done()
+
+	start := time.Now()
+	if ds.drain(100 * time.Millisecond) {
+		t.Error("expected drain to report the request still running")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected drain to give up after the grace period, took %s", elapsed)
+	}
+}
+
+func TestCancelAllStopsSQLAPIQueries(t *testing.T) {
+	server := newFakeSQLAPI(t, nil, nil)
+	server.hang = true
+
+	ds := &Datasource{}
+	pCtx := sqlAPIPluginContext(t, server.listener.Addr().String())
+	result := make(chan backend.DataResponse, 1)
+	go func() {
+		result <- runSingleQuery(t, ds, pCtx, `{"refId": "A", "measures": ["orders.count"]}`)
+	}()
+
+	<-server.queries
+	if n := ds.loads.cancelAll(); n != 1 {
+		t.Errorf("expected 1 running load, got %d", n)
+	}
+	select {
+	case res := <-result:
+		if res.Error == nil || !strings.Contains(res.Error.Error(), errInstanceDisposed.Error()) {
+			t.Errorf("expected the query to be cancelled, got %v", res.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the SQL API query to stop")
+	}
+}
+
+func TestCancelAllStopsContinueWaitPolling(t *testing.T) {
+	polled := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case polled <- struct{}{}:
+		default:
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"error": "Continue wait"}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{}
+	apiReq, err := ds.buildAPIURL(newTestPluginContext(server.URL), "load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := ds.doCubeLoadRequest(context.Background(), apiReq.URL.String(), []byte(`{"measures": ["orders.count"]}`), apiReq.Config)
+		result <- err
+	}()
+
+	<-polled
+	if n := ds.loads.cancelAll(); n != 1 {
+		t.Errorf("expected 1 running load, got %d", n)
+	}
+	select {
+	case err := <-result:
+		if err == nil || !strings.Contains(err.Error(), "settings changed") {
+			t.Errorf("expected the load to be cancelled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the load to stop polling")
+	}
+
+	if _, err := ds.doCubeLoadRequest(context.Background(), apiReq.URL.String(), []byte(`{"measures": ["orders.count"]}`), apiReq.Config); err == nil {
+		t.Error("expected loads on a disposed instance to be cancelled")
+	}
+}
@@ -0,0 +1,63 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// queryLimiter is a semaphore bounding the Cube queries an instance runs at
+// once, so a dashboard with many panels queues its queries in the plugin
+// instead of opening that many warehouse queries at the same time. Its zero
+// value is ready to use.
+type queryLimiter struct {
+	mu    sync.Mutex
+	slots chan struct{}
+}
+
+// acquire waits for one of size slots and returns the function releasing it.
+// A size of 0 or less is no limit. It fails with the cause of ctx when ctx
+// ends first.
+func (l *queryLimiter) acquire(ctx context.Context, size int) (func(), error) {
+	if size <= 0 {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	if l.slots == nil || cap(l.slots) != size {
+		// Settings changes create a new instance, so this only happens for
+		// the first query; queries holding a slot of a replaced channel
+		// release it there.
+		l.slots = make(chan struct{}, size)
+	}
+	slots := l.slots
+	l.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// acquireQuerySlot waits until fewer than maxConcurrentQueries queries run on
+// the instance. Waiting counts toward the query timeout, which fails the
+// query like a timeout while running.
+func (d *Datasource) acquireQuerySlot(ctx context.Context, config *models.PluginSettings) (func(), error) {
+	start := time.Now()
+	release, err := d.queries.acquire(ctx, config.QueryConcurrency())
+	if err != nil {
+		return nil, &loadRequestError{
+			status: statusForContextErr(err),
+			msg:    fmt.Sprintf("query was not started: waited %s for one of %d query slots (maxConcurrentQueries): %v", time.Since(start).Round(time.Millisecond), config.QueryConcurrency(), err),
+		}
+	}
+	if waited := time.Since(start); waited >= time.Second {
+		backend.Logger.FromContext(ctx).Debug("Query waited for a free query slot", "waited", waited, "maxConcurrentQueries", config.QueryConcurrency())
+	}
+	return release, nil
+}
@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestConcurrentQueriesLimited(t *testing.T) {
+	var running, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": []}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{}
+	config := &models.PluginSettings{DeploymentType: "self-hosted-dev", MaxConcurrentQueries: intPtr(2)}
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ds.doCubeLoadRequest(context.Background(), server.URL+"/cubejs-api/v1/load", []byte(`{"measures": ["orders.count"]}`), config); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := peak.Load(); got != 2 {
+		t.Errorf("expected at most 2 queries at once, got %d", got)
+	}
+}
+
+func TestQueryLimiterTimeout(t *testing.T) {
+	ds := &Datasource{}
+	config := &models.PluginSettings{MaxConcurrentQueries: intPtr(1)}
+	release, err := ds.acquireQuerySlot(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = ds.acquireQuerySlot(ctx, config)
+	var loadErr *loadRequestError
+	if !errors.As(err, &loadErr) || loadErr.status != backend.StatusTimeout {
+		t.Errorf("expected a timeout waiting for a slot, got %v", err)
+	}
+
+	if release, err := ds.acquireQuerySlot(context.Background(), &models.PluginSettings{}); err != nil {
+		t.Errorf("expected no limit by default, got %v", err)
+	} else {
+		release()
+	}
+}
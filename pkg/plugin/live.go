@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/net/websocket"
+)
+
+// streamLivePathPrefix prefixes the Grafana Live channel paths of live
+// queries, e.g. ds/<uid>/live/<key>. Their subscription data is the same as
+// for query streams.
+const streamLivePathPrefix = "live/"
+
+// runLiveStream subscribes to the prepared query over Cube's WebSocket API and
+// sends the query's frames every time Cube pushes a new result, which it does
+// when the refresh keys of the query's cubes change. Panels on a live channel
+// thus update without dashboard refreshes. The stream runs until the last
+// subscriber leaves or the instance is disposed; failures end it with an
+// error notice.
+func (d *Datasource) runLiveStream(ctx context.Context, pCtx backend.PluginContext, path string, prepared *preparedQuery, sender *backend.StreamSender) error {
+	ctx, done := d.loads.track(ctx)
+	defer done()
+
+	refID := prepared.refID
+	if len(prepared.blended) > 0 {
+		return sendStreamResponse(sender, refID, backend.ErrDataResponse(backend.StatusBadRequest, "blended queries cannot be live"))
+	}
+	apiReq, err := d.buildAPIURL(pCtx, "load")
+	if err != nil {
+		return sendStreamResponse(sender, refID, backend.ErrDataResponse(backend.StatusBadRequest, err.Error()))
+	}
+	if usesSQLAPI(apiReq.Config) || usesGraphQL(apiReq.Config) {
+		return sendStreamResponse(sender, refID, backend.ErrDataResponse(backend.StatusBadRequest, "live queries need the REST query transport"))
+	}
+	queryJSON, err := json.Marshal(prepared.apiQuery)
+	if err != nil {
+		return sendStreamResponse(sender, refID, backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Failed to marshal Cube query: %v", err)))
+	}
+
+	ctx = withStreamProgress(ctx, sender, path, refID)
+	err = d.subscribeCubeWebSocket(ctx, apiReq.URL.String(), queryJSON, apiReq.Config, func(body []byte) error {
+		result, err := decodeLoadResult(body)
+		if err != nil {
+			return err
+		}
+		return sendStreamResponse(sender, refID, d.respond(ctx, pCtx, prepared, result))
+	})
+	if ctx.Err() != nil {
+		// The last subscriber left or the instance was disposed.
+		return nil
+	}
+	backend.Logger.FromContext(ctx).Warn("Live query ended", "path", path, "error", err)
+	return sendStreamResponse(sender, refID, loadErrorResponse(err))
+}
+
+// subscribeCubeWebSocket subscribes to a query over Cube's WebSocket API and
+// calls onResult with every new result Cube pushes; a result identical to the
+// previous one is skipped. It returns when ctx ends, the connection fails or
+// onResult returns an error. Cube drops the subscription when the connection
+// closes, so no unsubscribe message is needed.
+//
+// SDK alignment: mirrors @cubejs-client/core's subscribe() over the
+// WebSocket transport, the "subscribe" counterpart of the "load" method in
+// doCubeLoadWebSocket, with the same Continue-wait handling.
+func (d *Datasource) subscribeCubeWebSocket(ctx context.Context, loadURL string, queryJSON []byte, config *models.PluginSettings, onResult func([]byte) error) error {
+	conn, closeConn, err := d.dialCubeWebSocket(ctx, loadURL, config)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	request := wsRequest{
+		MessageID: wsLoadMessageID,
+		Method:    "subscribe",
+		Params:    wsLoadParams{Query: queryJSON},
+	}
+	if err := websocket.JSON.Send(conn, request); err != nil {
+		return &webSocketUnavailableError{err: err}
+	}
+
+	var last []byte
+	for {
+		var msg wsMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			if ctx.Err() != nil {
+				return context.Cause(ctx)
+			}
+			if errors.Is(err, websocket.ErrFrameTooLarge) {
+				return responseTooLargeError(maxResponseBytes(config))
+			}
+			return &loadRequestError{status: backend.StatusBadGateway, msg: fmt.Sprintf("Cube WebSocket connection failed: %v", err)}
+		}
+		if msg.MessageID != wsLoadMessageID || len(msg.Message) == 0 {
+			continue
+		}
+		if msg.Status >= http.StatusBadRequest {
+			return &CubeAPIError{StatusCode: msg.Status, Body: msg.Message}
+		}
+
+		if envelope, err := decodeCubeEnvelope(msg.Message); err == nil && envelope.isContinueWait() {
+			if observe := continueWaitObserverFrom(ctx); observe != nil {
+				observe(envelope.progress())
+			}
+			if err := websocket.JSON.Send(conn, request); err != nil {
+				if ctx.Err() != nil {
+					return context.Cause(ctx)
+				}
+				return &loadRequestError{status: backend.StatusBadGateway, msg: fmt.Sprintf("Cube WebSocket connection failed: %v", err)}
+			}
+			continue
+		}
+
+		if bytes.Equal(msg.Message, last) {
+			continue
+		}
+		last = msg.Message
+		if err := onResult(msg.Message); err != nil {
+			return err
+		}
+	}
+}
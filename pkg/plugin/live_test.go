@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"golang.org/x/net/websocket"
+)
+
+func TestRunLiveStream(t *testing.T) {
+	result := func(count string) json.RawMessage {
+		return json.RawMessage(`{"data": [{"orders.status": "completed", "orders.count": "` + count + `"}],
+			"annotation": {"measures": {"orders.count": {"type": "number"}}, "dimensions": {"orders.status": {"type": "string"}}}}`)
+	}
+	var methods []string
+	server := newCubeWebSocketServer(t, func(ws *websocket.Conn) {
+		for range 2 {
+			var req wsRequest
+			if err := websocket.JSON.Receive(ws, &req); err != nil {
+				t.Errorf("receive subscribe: %v", err)
+				return
+			}
+			methods = append(methods, req.Method)
+			if len(methods) == 1 {
+				_ = websocket.JSON.Send(ws, wsMessage{MessageID: req.MessageID, Message: json.RawMessage(`{"error": "Continue wait", "stage": "Executing query"}`), Status: http.StatusOK})
+			}
+		}
+		for _, count := range []string{"5", "5", "6"} {
+			_ = websocket.JSON.Send(ws, wsMessage{MessageID: wsLoadMessageID, Message: result(count), Status: http.StatusOK})
+		}
+		// Wait for the plugin to close the connection.
+		var req wsRequest
+		_ = websocket.JSON.Receive(ws, &req)
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	packets := &recordingPacketSender{}
+	packets.onSend = func() {
+		// Unsubscribe after the progress frame and two results.
+		if len(packets.frames) == 2 {
+			cancel()
+		}
+	}
+	err := (&Datasource{}).RunStream(ctx, &backend.RunStreamRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Path:          "live/A",
+		Data:          json.RawMessage(testStreamData),
+	}, backend.NewStreamSender(packets))
+	if err != nil {
+		t.Fatalf("RunStream failed: %v", err)
+	}
+
+	if len(methods) != 2 || methods[0] != "subscribe" || methods[1] != "subscribe" {
+		t.Errorf("expected the subscription to be sent again after Continue wait, got %v", methods)
+	}
+	if len(packets.frames) != 3 {
+		t.Fatalf("expected a progress frame and two results, the repeated one skipped, got %d frames", len(packets.frames))
+	}
+	if len(packets.frames[0].Fields) != 0 {
+		t.Errorf("expected a progress frame first, got %d fields", len(packets.frames[0].Fields))
+	}
+	for i, want := range []float64{5, 6} {
+		frame := packets.frames[i+1]
+		field, _ := frame.FieldByName("orders.count")
+		if field == nil || frame.RefID != "A" {
+			t.Fatalf("expected a result frame for refId A, got %+v", frame)
+		}
+		if got, _ := field.ConcreteAt(0); got != want {
+			t.Errorf("result %d: expected %v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestRunLiveStreamSendsErrorNotice(t *testing.T) {
+	server := newCubeWebSocketServer(t, func(ws *websocket.Conn) {
+		var req wsRequest
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			return
+		}
+		_ = websocket.JSON.Send(ws, wsMessage{MessageID: req.MessageID, Message: json.RawMessage(`{"error": "Cube not found"}`), Status: http.StatusBadRequest})
+	}, nil)
+
+	packets := &recordingPacketSender{}
+	err := (&Datasource{}).RunStream(context.Background(), &backend.RunStreamRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Path:          "live/A",
+		Data:          json.RawMessage(testStreamData),
+	}, backend.NewStreamSender(packets))
+	if err != nil {
+		t.Fatalf("RunStream failed: %v", err)
+	}
+	if len(packets.frames) != 1 {
+		t.Fatalf("expected 1 error frame, got %d", len(packets.frames))
+	}
+	meta := packets.frames[0].Meta
+	if meta == nil || len(meta.Notices) != 1 || meta.Notices[0].Severity != data.NoticeSeverityError {
+		t.Fatalf("expected an error notice, got %+v", meta)
+	}
+}
@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Filters whose member is a measure are applied by Cube after aggregation,
+// as a HAVING clause, and so before the query's order and limit: "the top 10
+// customers with more than 100 orders" rather than "those of the top 10
+// customers with more than 100 orders".
+
+// measureFilterMembers returns the measures the filters apply to, including
+// those nested in and/or groups, in filter order.
+func measureFilterMembers(filters []interface{}, members map[string]filterMember) []string {
+	var measures []string
+	walkMemberFilters(filters, func(name string, _ map[string]interface{}) {
+		if members[name].Kind == "measure" && !slices.Contains(measures, name) {
+			measures = append(measures, name)
+		}
+	})
+	return measures
+}
+
+// validateMeasureFilters checks what Cube requires of measure filters: an
+// and/or group cannot mix measures and dimensions (the group would have to
+// be split between WHERE and HAVING), and numeric measures are compared to
+// numbers.
+func validateMeasureFilters(filters []interface{}, members map[string]filterMember) error {
+	for _, f := range filters {
+		obj, ok := f.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, op := range []string{"and", "or"} {
+			group, ok := obj[op].([]interface{})
+			if !ok {
+				continue
+			}
+			var measure, dimension string
+			walkMemberFilters(group, func(name string, _ map[string]interface{}) {
+				switch members[name].Kind {
+				case "measure":
+					measure = name
+				case "dimension":
+					dimension = name
+				}
+			})
+			if measure != "" && dimension != "" {
+				return fmt.Errorf("'%s' group mixes measure %s and dimension %s: filters on measures and dimensions must be in separate groups", op, measure, dimension)
+			}
+		}
+	}
+
+	var err error
+	walkMemberFilters(filters, func(name string, filter map[string]interface{}) {
+		member := members[name]
+		if err != nil || member.Kind != "measure" || member.Type != "number" {
+			return
+		}
+		values, _ := filter["values"].([]interface{})
+		for _, v := range values {
+			value := fmt.Sprint(v)
+			if _, parseErr := strconv.ParseFloat(value, 64); parseErr != nil {
+				err = fmt.Errorf("filter on measure %s needs numeric values, got %q", name, value)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// walkMemberFilters calls fn with the member and filter of every member
+// filter, descending into and/or groups.
+func walkMemberFilters(filters []interface{}, fn func(member string, filter map[string]interface{})) {
+	for _, f := range filters {
+		obj, ok := f.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, op := range []string{"and", "or"} {
+			if group, ok := obj[op].([]interface{}); ok {
+				walkMemberFilters(group, fn)
+			}
+		}
+		member, _ := obj["member"].(string)
+		if member == "" {
+			member, _ = obj["dimension"].(string)
+		}
+		if member != "" {
+			fn(member, obj)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateMeasureFilters(t *testing.T) {
+	members := filterableMembers(&CubeMetaResponse{Cubes: []CubeMeta{{
+		Name:       "orders",
+		Dimensions: []CubeDimension{{Name: "orders.status", Type: "string"}},
+		Measures:   []CubeMeasure{{Name: "orders.count", Type: "number"}, {Name: "orders.last_status", Type: "string"}},
+	}}})
+	tests := []struct {
+		name    string
+		filters string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "measure and dimension filters side by side",
+			filters: `[{"member": "orders.count", "operator": "gt", "values": ["100"]}, {"member": "orders.status", "operator": "equals", "values": ["shipped"]}]`,
+			want:    []string{"orders.count"},
+		},
+		{
+			name:    "group of measure filters",
+			filters: `[{"or": [{"member": "orders.count", "operator": "gt", "values": [100]}, {"member": "orders.count", "operator": "lt", "values": ["1.5"]}]}]`,
+			want:    []string{"orders.count"},
+		},
+		{
+			name:    "non-numeric measure is compared to strings",
+			filters: `[{"member": "orders.last_status", "operator": "equals", "values": ["shipped"]}]`,
+			want:    []string{"orders.last_status"},
+		},
+		{
+			name:    "group mixing measures and dimensions",
+			filters: `[{"or": [{"member": "orders.count", "operator": "gt", "values": ["100"]}, {"and": [{"member": "orders.status", "operator": "equals", "values": ["shipped"]}]}]}]`,
+			wantErr: "'or' group mixes measure orders.count and dimension orders.status",
+		},
+		{
+			name:    "non-numeric value",
+			filters: `[{"member": "orders.count", "operator": "gt", "values": ["many"]}]`,
+			wantErr: `filter on measure orders.count needs numeric values, got "many"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filters []interface{}
+			if err := json.Unmarshal([]byte(tt.filters), &filters); err != nil {
+				t.Fatalf("invalid filters: %v", err)
+			}
+			err := validateMeasureFilters(filters, members)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := measureFilterMembers(filters, members); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected measure filters %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestQueryDataMeasureFilter(t *testing.T) {
+	var gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+			_, _ = w.Write([]byte(`{"cubes": [{"name": "orders", "dimensions": [{"name": "orders.status", "type": "string"}],
+				"measures": [{"name": "orders.count", "type": "number"}]}]}`))
+			return
+		}
+		gotQuery = r.URL.Query().Get("query")
+		_, _ = w.Write([]byte(`{"data": [{"orders.status": "shipped"}]}`))
+	}))
+	defer server.Close()
+
+	res := runSingleQuery(t, &Datasource{BaseURL: server.URL}, newTestPluginContext(server.URL), `{"refId":"A","dimensions":["orders.status"],
+		"filters":[{"member":"orders.count","operator":"gt","values":["100"]}],"order":{"orders.status":"asc"},"limit":10}`)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	if !strings.Contains(gotQuery, `"filters":[{"member":"orders.count","operator":"gt","values":["100"]}]`) || !strings.Contains(gotQuery, `"limit":10`) {
+		t.Errorf("expected the measure filter to be sent with the limit, got %s", gotQuery)
+	}
+}
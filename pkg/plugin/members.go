@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// MembersResponse lists the members that can be added to a query next.
+type MembersResponse struct {
+	Dimensions []SelectOption `json:"dimensions"`
+	Measures   []SelectOption `json:"measures"`
+	Segments   []SelectOption `json:"segments,omitempty"`
+}
+
+// handleMembers returns the members that can be combined with the members
+// already selected in the query editor (members?selected=orders.count), so
+// the editor only offers queries Cube accepts: a view's members combine only
+// with members of the same view, a cube's members with members of cubes it
+// joins with (the same connected component of the join graph). It takes the
+// metadata resource's parameters to narrow the result down further, e.g.
+// cube=orders. Without selected members every member is combinable.
+func (d *Datasource) handleMembers(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	metaResponse, err := d.getCubeMetadata(ctx, req.PluginContext)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to fetch cube metadata", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to fetch metadata from Cube API")))
+	}
+
+	opts, err := d.metadataOptionsFromRequest(req)
+	if errors.Is(err, errHiddenMembersAdminOnly) {
+		return sender.Send(accessDeniedResponse())
+	}
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
+	}
+	var selected []string
+	for _, param := range parsedURL.Query()["selected"] {
+		for _, member := range strings.Split(param, ",") {
+			if member = strings.TrimSpace(member); member != "" {
+				selected = append(selected, member)
+			}
+		}
+	}
+
+	combinable, err := combinableCubes(metaResponse, selected)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	if combinable != nil {
+		if len(opts.cubes) > 0 {
+			combinable = slices.DeleteFunc(combinable, func(name string) bool { return !slices.Contains(opts.cubes, name) })
+		}
+		opts.cubes = combinable
+		// Members of the selected cubes are offered even without
+		// include=cubes; a query on cubes only combines with cubes.
+		opts.includeCubes = opts.includeCubes || !isView(metaResponse, combinable)
+	}
+
+	var metadata MetadataResponse
+	if combinable != nil && len(combinable) == 0 {
+		metadata = d.extractMetadata(&CubeMetaResponse{}, opts)
+	} else {
+		metadata = d.extractMetadata(metaResponse, opts)
+	}
+
+	body, err := json.Marshal(MembersResponse{
+		Dimensions: metadata.Dimensions,
+		Measures:   metadata.Measures,
+		Segments:   metadata.Segments,
+	})
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal members response", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   body,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
+
+// combinableCubes returns the cubes and views whose members can be queried
+// together with the selected members, or nil when nothing is selected. It
+// fails for members not in the model and for selections Cube already
+// rejects, such as members of two views.
+func combinableCubes(meta *CubeMetaResponse, selected []string) ([]string, error) {
+	if len(selected) == 0 {
+		return nil, nil
+	}
+	var cubes []CubeMeta
+	for _, member := range selected {
+		name, _, _ := strings.Cut(strings.TrimPrefix(member, "segment:"), ".")
+		i := slices.IndexFunc(meta.Cubes, func(c CubeMeta) bool { return c.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("member %q not found in the Cube model", member)
+		}
+		if !slices.ContainsFunc(cubes, func(c CubeMeta) bool { return c.Name == name }) {
+			cubes = append(cubes, meta.Cubes[i])
+		}
+	}
+
+	for _, cube := range cubes {
+		if cube.Type == "view" {
+			if len(cubes) > 1 {
+				return nil, fmt.Errorf("members of view %s cannot be combined with members of other views or cubes", cube.Name)
+			}
+			return []string{cube.Name}, nil
+		}
+	}
+
+	// Cubes combine with the cubes of their connected component. Without
+	// connectivity information only the selected cubes are offered.
+	component := cubes[0].ConnectedComponent
+	for _, cube := range cubes[1:] {
+		if component == nil || cube.ConnectedComponent == nil {
+			component = nil
+			break
+		}
+		if *cube.ConnectedComponent != *component {
+			return nil, fmt.Errorf("cubes %s and %s are not joined and cannot be queried together", cubes[0].Name, cube.Name)
+		}
+	}
+	if component == nil {
+		names := make([]string, 0, len(cubes))
+		for _, cube := range cubes {
+			names = append(names, cube.Name)
+		}
+		return names, nil
+	}
+	names := []string{}
+	for _, cube := range meta.Cubes {
+		if cube.Type != "view" && cube.ConnectedComponent != nil && *cube.ConnectedComponent == *component {
+			names = append(names, cube.Name)
+		}
+	}
+	return names, nil
+}
+
+// isView reports whether names is a single view of the model.
+func isView(meta *CubeMetaResponse, names []string) bool {
+	return len(names) == 1 && slices.ContainsFunc(meta.Cubes, func(c CubeMeta) bool {
+		return c.Name == names[0] && c.Type == "view"
+	})
+}
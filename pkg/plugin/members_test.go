@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHandleMembers(t *testing.T) {
+	component := func(n int) *int { return &n }
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CubeMetaResponse{Cubes: []CubeMeta{
+			{
+				Name: "orders_view", Type: "view",
+				Dimensions: []CubeDimension{{Name: "orders_view.status", Type: "string"}},
+				Measures:   []CubeMeasure{{Name: "orders_view.count", Type: "number"}},
+			},
+			{Name: "users_view", Type: "view", Dimensions: []CubeDimension{{Name: "users_view.name", Type: "string"}}},
+			{Name: "orders", Type: "cube", ConnectedComponent: component(1), Measures: []CubeMeasure{{Name: "orders.count", Type: "number"}}},
+			{Name: "customers", Type: "cube", ConnectedComponent: component(1), Dimensions: []CubeDimension{{Name: "customers.city", Type: "string"}}},
+			{Name: "events", Type: "cube", ConnectedComponent: component(2), Dimensions: []CubeDimension{{Name: "events.kind", Type: "string"}}},
+		}})
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	tests := []struct {
+		url    string
+		status int
+		want   []string
+	}{
+		{url: "members", status: http.StatusOK, want: []string{"orders_view.status", "users_view.name", "orders_view.count"}},
+		{url: "members?selected=orders_view.count", status: http.StatusOK, want: []string{"orders_view.status", "orders_view.count"}},
+		{url: "members?selected=orders.count", status: http.StatusOK, want: []string{"customers.city", "orders.count"}},
+		{url: "members?selected=orders.count&cube=orders", status: http.StatusOK, want: []string{"orders.count"}},
+		{url: "members?selected=orders.count&cube=events", status: http.StatusOK},
+		{url: "members?selected=orders.count,customers.city", status: http.StatusOK, want: []string{"customers.city", "orders.count"}},
+		{url: "members?selected=orders.count,events.kind", status: http.StatusBadRequest},
+		{url: "members?selected=orders_view.count,users_view.name", status: http.StatusBadRequest},
+		{url: "members?selected=nope.count", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			resp := callHandler(t, ds.handleMembers, &backend.CallResourceRequest{
+				PluginContext: newTestPluginContext(server.URL),
+				Path:          "members",
+				URL:           tt.url,
+			})
+			if resp.Status != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, resp.Status, resp.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var members MembersResponse
+			if err := json.Unmarshal(resp.Body, &members); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			var got []string
+			for _, options := range [][]SelectOption{members.Dimensions, members.Measures} {
+				for _, option := range options {
+					got = append(got, option.Value)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// defaultMetaCacheTTL is how long /v1/meta responses are reused when
+// metaCacheTTL is not configured.
+const defaultMetaCacheTTL = time.Minute
+
+// metaCache holds the last /v1/meta response of the instance. The query
+// editor asks for metadata on every change, and the model rarely changes, so
+// the response is reused until it is older than the TTL or invalidated with
+// POST /resources/metadata/refresh. The cached response is shared and must
+// not be modified.
+type metaCache struct {
+	// mu is held during a fetch, so concurrent callers wait for the fetch in
+	// progress instead of sending their own.
+	mu        sync.Mutex
+	meta      *CubeMetaResponse
+	fetchedAt time.Time
+}
+
+// metaCacheTTL returns the configured metadata cache TTL: nil means
+// defaultMetaCacheTTL, and 0 (or less) disables the cache.
+func metaCacheTTL(config *models.PluginSettings) time.Duration {
+	if config.MetaCacheTTL == nil {
+		return defaultMetaCacheTTL
+	}
+	if *config.MetaCacheTTL <= 0 {
+		return 0
+	}
+	return time.Duration(*config.MetaCacheTTL) * time.Second
+}
+
+// getCubeMetadata returns the model metadata, from the cache while it is
+// fresh. Failed fetches are not cached.
+func (d *Datasource) getCubeMetadata(ctx context.Context, pCtx backend.PluginContext) (*CubeMetaResponse, error) {
+	if pCtx.DataSourceInstanceSettings == nil {
+		return d.fetchCubeMetadata(ctx, pCtx)
+	}
+	config, err := d.loadSettings(*pCtx.DataSourceInstanceSettings)
+	if err != nil {
+		// fetchCubeMetadata reports the settings error.
+		return d.fetchCubeMetadata(ctx, pCtx)
+	}
+	ttl := metaCacheTTL(config)
+	if ttl == 0 {
+		return d.fetchCubeMetadata(ctx, pCtx)
+	}
+
+	d.meta.mu.Lock()
+	defer d.meta.mu.Unlock()
+
+	if d.meta.meta != nil && time.Since(d.meta.fetchedAt) < ttl {
+		return d.meta.meta, nil
+	}
+	meta, err := d.fetchCubeMetadata(ctx, pCtx)
+	if err != nil {
+		return nil, err
+	}
+	d.meta.meta, d.meta.fetchedAt = meta, time.Now()
+	return meta, nil
+}
+
+// invalidateMetadata drops the cached metadata and everything derived from
+// it, so the next request fetches the model from Cube again.
+func (d *Datasource) invalidateMetadata() {
+	d.meta.mu.Lock()
+	d.meta.meta = nil
+	d.meta.mu.Unlock()
+
+	d.deprecations.mu.Lock()
+	d.deprecations.meta, d.deprecations.members = nil, nil
+	d.deprecations.mu.Unlock()
+
+	d.colors.mu.Lock()
+	d.colors.meta, d.colors.colors = nil, nil
+	d.colors.mu.Unlock()
+}
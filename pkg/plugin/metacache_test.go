@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// newCountingMetaServer serves a one-view model at /v1/meta and counts the
+// requests.
+func newCountingMetaServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"cubes": [{"name": "orders_view", "type": "view", "dimensions": [{"name": "orders_view.status", "type": "string"}], "measures": []}]}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &fetches
+}
+
+func metadataRequest(pCtx backend.PluginContext) *backend.CallResourceRequest {
+	return &backend.CallResourceRequest{Path: "metadata", Method: "GET", URL: "/metadata", PluginContext: pCtx}
+}
+
+func TestMetaCacheTTL(t *testing.T) {
+	zero, ten := 0, 10
+	for _, tt := range []struct {
+		setting *int
+		want    time.Duration
+	}{
+		{setting: nil, want: defaultMetaCacheTTL},
+		{setting: &zero, want: 0},
+		{setting: &ten, want: 10 * time.Second},
+	} {
+		if got := metaCacheTTL(&models.PluginSettings{MetaCacheTTL: tt.setting}); got != tt.want {
+			t.Errorf("metaCacheTTL(%v) = %v, want %v", tt.setting, got, tt.want)
+		}
+	}
+}
+
+func TestHandleMetadataCachesMeta(t *testing.T) {
+	server, fetches := newCountingMetaServer(t)
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+
+	for i := 0; i < 3; i++ {
+		if resp := callHandler(t, ds.CallResource, metadataRequest(pCtx)); resp.Status != 200 {
+			t.Fatalf("request %d: expected status 200, got %d: %s", i, resp.Status, resp.Body)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected /v1/meta to be fetched once, got %d", n)
+	}
+
+	// A refresh makes the next request fetch the model again.
+	refresh := &backend.CallResourceRequest{Path: "metadata/refresh", Method: "POST", URL: "/metadata/refresh", PluginContext: pCtx}
+	if resp := callHandler(t, ds.CallResource, refresh); resp.Status != 200 {
+		t.Fatalf("expected refresh to succeed, got %d: %s", resp.Status, resp.Body)
+	}
+	if resp := callHandler(t, ds.CallResource, metadataRequest(pCtx)); resp.Status != 200 {
+		t.Fatalf("expected status 200, got %d", resp.Status)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected a fetch after the refresh, got %d fetches", n)
+	}
+}
+
+func TestHandleMetadataCacheDisabled(t *testing.T) {
+	server, fetches := newCountingMetaServer(t)
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "metaCacheTTL": 0}`)
+
+	for i := 0; i < 2; i++ {
+		callHandler(t, ds.CallResource, metadataRequest(pCtx))
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected every request to fetch /v1/meta, got %d fetches", n)
+	}
+}
+
+func TestHandleMetadataFailureIsNotCached(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error": "boom"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"cubes": []}`))
+	}))
+	defer server.Close()
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+
+	if resp := callHandler(t, ds.CallResource, metadataRequest(pCtx)); resp.Status != 500 {
+		t.Fatalf("expected the failure to be reported, got %d", resp.Status)
+	}
+	fail.Store(false)
+	if resp := callHandler(t, ds.CallResource, metadataRequest(pCtx)); resp.Status != 200 {
+		t.Errorf("expected the next request to fetch again, got %d: %s", resp.Status, resp.Body)
+	}
+}
+
+func TestHandleMetadataRefreshRequiresPost(t *testing.T) {
+	ds := &Datasource{}
+	resp := callHandler(t, ds.CallResource, &backend.CallResourceRequest{
+		Path:          "metadata/refresh",
+		Method:        "GET",
+		URL:           "/metadata/refresh",
+		PluginContext: newTestPluginContext("http://example.com"),
+	})
+	if resp.Status != 405 {
+		t.Errorf("expected status 405, got %d", resp.Status)
+	}
+}
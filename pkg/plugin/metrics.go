@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Plugin metrics are registered with the default Prometheus registry, which
+// the plugin SDK exposes through Grafana's plugin metrics endpoint.
+var (
+	slowQueriesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "grafana_plugin",
+		Subsystem: "cube",
+		Name:      "slow_queries_total",
+		Help:      "Number of Cube /v1/load requests that took longer than the configured slowQueryThresholdMs.",
+	})
+
+	loadRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "grafana_plugin",
+		Subsystem: "cube",
+		Name:      "load_request_duration_seconds",
+		Help:      "Duration of Cube /v1/load requests, Continue-wait polling and retries included, by outcome (success, error, timeout).",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"outcome"})
+
+	continueWaitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "grafana_plugin",
+		Subsystem: "cube",
+		Name:      "continue_waits_total",
+		Help:      "Number of Continue-wait responses received from Cube /v1/load, i.e. polls for results still being computed.",
+	})
+
+	cubeErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana_plugin",
+		Subsystem: "cube",
+		Name:      "errors_total",
+		Help:      "Number of error responses from Cube /v1/load by HTTP status code (200 for errors reported in a successful response).",
+	}, []string{"status_code"})
+
+	jwtCacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana_plugin",
+		Subsystem: "cube",
+		Name:      "jwt_cache_requests_total",
+		Help:      "Number of JWT lookups for self-hosted Cube authentication by result (hit, miss).",
+	}, []string{"result"})
+
+	rateLimitedRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana_plugin",
+		Subsystem: "cube",
+		Name:      "rate_limited_requests_total",
+		Help:      "Number of resource calls rejected with HTTP 429 by the resourceRateLimit, by resource path.",
+	}, []string{"path"})
+)
+
+// observeLoadRequest records a finished /v1/load request: its duration by
+// outcome, the Continue-wait responses it polled through and, for error
+// responses from Cube, their status code.
+func observeLoadRequest(duration time.Duration, polls int, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+		var reqErr *loadRequestError
+		if errors.As(err, &reqErr) && reqErr.status == backend.StatusTimeout {
+			outcome = "timeout"
+		}
+	}
+	loadRequestDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+	continueWaitsTotal.Add(float64(polls))
+
+	var cubeErr *CubeAPIError
+	var nonJSONErr *nonJSONResponseError
+	switch {
+	case errors.As(err, &cubeErr):
+		cubeErrorsTotal.WithLabelValues(strconv.Itoa(cubeErr.StatusCode)).Inc()
+	case errors.As(err, &nonJSONErr):
+		cubeErrorsTotal.WithLabelValues(strconv.Itoa(nonJSONErr.StatusCode)).Inc()
+	}
+}
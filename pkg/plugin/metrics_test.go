@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQueryMetrics(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("query") {
+		case `{"measures":["orders.count"]}`:
+			if calls.Add(1) <= 2 {
+				_, _ = w.Write([]byte(`{"error": "Continue wait"}`))
+				return
+			}
+			_, _ = w.Write(successBody(t))
+		case `{"measures":["orders.total"]}`:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "Query should contain either measures, dimensions or timeDimensions"}`))
+		default:
+			_, _ = w.Write([]byte(`{"error": "Error: Table 'orders' not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+
+	waitsBefore := testutil.ToFloat64(continueWaitsTotal)
+	if resp := runSingleQuery(t, ds, pCtx, `{"refId": "A", "measures": ["orders.count"]}`); resp.Error != nil {
+		t.Fatalf("unexpected error: %v", resp.Error)
+	}
+	if got := testutil.ToFloat64(continueWaitsTotal) - waitsBefore; got != 2 {
+		t.Errorf("expected 2 Continue-wait responses to be counted, got %v", got)
+	}
+	if testutil.CollectAndCount(loadRequestDuration) == 0 {
+		t.Errorf("expected the request duration to be observed")
+	}
+
+	for _, tt := range []struct {
+		query  string
+		status string
+	}{
+		{query: `{"refId": "A", "measures": ["orders.total"]}`, status: "400"},
+		{query: `{"refId": "A", "measures": ["orders.missing"]}`, status: "200"},
+	} {
+		before := testutil.ToFloat64(cubeErrorsTotal.WithLabelValues(tt.status))
+		if resp := runSingleQuery(t, ds, pCtx, tt.query); resp.Error == nil {
+			t.Fatalf("%s: expected an error", tt.query)
+		}
+		if got := testutil.ToFloat64(cubeErrorsTotal.WithLabelValues(tt.status)) - before; got != 1 {
+			t.Errorf("%s: expected one error with status %s to be counted, got %v", tt.query, tt.status, got)
+		}
+	}
+}
+
+func TestJWTCacheMetrics(t *testing.T) {
+	ds := &Datasource{}
+	config := &models.PluginSettings{DeploymentType: "self-hosted", Secrets: &models.SecretPluginSettings{ApiSecret: "metrics-secret"}}
+
+	hits := testutil.ToFloat64(jwtCacheRequestsTotal.WithLabelValues("hit"))
+	misses := testutil.ToFloat64(jwtCacheRequestsTotal.WithLabelValues("miss"))
+	for i := 0; i < 3; i++ {
+		if _, err := ds.authToken(config); err != nil {
+			t.Fatalf("authToken failed: %v", err)
+		}
+	}
+	if got := testutil.ToFloat64(jwtCacheRequestsTotal.WithLabelValues("miss")) - misses; got != 1 {
+		t.Errorf("expected 1 miss, got %v", got)
+	}
+	if got := testutil.ToFloat64(jwtCacheRequestsTotal.WithLabelValues("hit")) - hits; got != 2 {
+		t.Errorf("expected 2 hits, got %v", got)
+	}
+}
@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// SaveModelFilesRequest is the body of a POST to the model-files resource.
+type SaveModelFilesRequest struct {
+	Files []ModelFile `json:"files"`
+}
+
+// errModelFileWritesDisabled is returned when model files are saved on a
+// datasource that does not allow it.
+var errModelFileWritesDisabled = errors.New("saving model files requires a self-hosted-dev deployment with allowModelFileWrites enabled")
+
+// modelFileWritesAllowed reports whether the settings allow saving model
+// files: only Cube's dev server has the playground file API, and writing to
+// the model is opt-in.
+func modelFileWritesAllowed(config *models.PluginSettings) bool {
+	return config != nil && config.AllowModelFileWrites && config.DeploymentType == models.DeploymentTypeSelfHostedDev
+}
+
+// validateModelFileName checks that a model file stays inside Cube's model
+// directory and is a YAML or JavaScript model file.
+func validateModelFileName(name string) error {
+	if name == "" {
+		return errors.New("file name is required")
+	}
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "\\") || path.Clean(name) != name || strings.HasPrefix(name, "..") {
+		return fmt.Errorf("invalid file name %q: must be a relative path inside the model directory", name)
+	}
+	switch path.Ext(name) {
+	case ".yml", ".yaml", ".js":
+		return nil
+	default:
+		return fmt.Errorf("invalid file name %q: model files must be .yml, .yaml or .js", name)
+	}
+}
+
+// handleSaveModelFiles writes edited or generated model files back to a Cube
+// dev server through its playground file API, completing the generate-schema
+// workflow. Admin-only and disabled unless allowModelFileWrites is set on a
+// self-hosted-dev datasource. The cached model is dropped so the query editor
+// sees the new members.
+func (d *Datasource) handleSaveModelFiles(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if !modelFileWritesAllowed(d.pluginSettings(req.PluginContext)) {
+		return sender.Send(jsonErrorResponse(403, errModelFileWritesDisabled))
+	}
+
+	var saveReq SaveModelFilesRequest
+	if err := json.Unmarshal(req.Body, &saveReq); err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid request body")))
+	}
+	if len(saveReq.Files) == 0 {
+		return sender.Send(jsonErrorResponse(400, errors.New("no files to save")))
+	}
+	for _, file := range saveReq.Files {
+		if err := validateModelFileName(file.FileName); err != nil {
+			return sender.Send(jsonErrorResponse(400, err))
+		}
+	}
+
+	if err := d.writeCubeModelFiles(ctx, req.PluginContext, saveReq.Files); err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to save cube model files", "error", err)
+		return sender.Send(cubeLoadErrorResponse(err))
+	}
+	d.invalidateMetadata()
+
+	body, err := json.Marshal(map[string]int{"saved": len(saveReq.Files)})
+	if err != nil {
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   body,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
+
+// writeCubeModelFiles sends model files to Cube's POST /playground/files
+// endpoint.
+func (d *Datasource) writeCubeModelFiles(ctx context.Context, pluginContext backend.PluginContext, files []ModelFile) error {
+	apiReq, err := d.buildAPIURL(pluginContext, "")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, apiReq.Config.MetaTimeoutDuration())
+	defer cancel()
+
+	baseURL := d.baseURL(apiReq.Config)
+	filesURL := strings.TrimRight(baseURL, "/") + "/playground/files"
+
+	requestBody, err := json.Marshal(SaveModelFilesRequest{Files: files})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", filesURL, bytes.NewReader(requestBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := d.addAuthHeaders(req, apiReq.Config); err != nil {
+		return fmt.Errorf("failed to add auth headers: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.doHTTP(req, apiReq.Config)
+	if err != nil {
+		return fmt.Errorf("failed to make API request: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			backend.Logger.FromContext(ctx).Warn("Failed to close response body", "error", err)
+		}
+	}()
+
+	_, err = readJSONResponse(resp)
+	return err
+}
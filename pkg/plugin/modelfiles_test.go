@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestValidateModelFileName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"orders.yml":            true,
+		"views/orders_view.yml": true,
+		"cubes/orders.js":       true,
+		"":                      false,
+		"/etc/passwd.yml":       false,
+		"../secrets.yml":        false,
+		"cubes/../../x.yml":     false,
+		"cubes//orders.yml":     false,
+		"orders.sql":            false,
+	} {
+		if err := validateModelFileName(name); (err == nil) != valid {
+			t.Errorf("%q: expected valid=%v, got %v", name, valid, err)
+		}
+	}
+}
+
+func TestSaveModelFiles(t *testing.T) {
+	var gotBody string
+	metaRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/playground/files":
+			if r.Method != http.MethodPost {
+				t.Errorf("expected POST, got %s", r.Method)
+			}
+			body, _ := io.ReadAll(r.Body)
+			gotBody = string(body)
+			_, _ = w.Write([]byte(`{}`))
+		case "/cubejs-api/v1/meta":
+			metaRequests++
+			_, _ = w.Write([]byte(`{"cubes": []}`))
+		}
+	}))
+	defer server.Close()
+
+	jsonData := `{"deploymentType": "self-hosted-dev", "allowModelFileWrites": true}`
+	body := `{"files": [{"fileName": "cubes/orders.yml", "content": "cubes:\n  - name: orders\n"}]}`
+	tests := []struct {
+		name     string
+		role     string
+		jsonData string
+		body     string
+		status   int
+	}{
+		{name: "saves files", role: "Admin", jsonData: jsonData, body: body, status: http.StatusOK},
+		{name: "admins only", role: "Editor", jsonData: jsonData, body: body, status: http.StatusForbidden},
+		{name: "disabled by default", role: "Admin", jsonData: `{"deploymentType": "self-hosted-dev"}`, body: body, status: http.StatusForbidden},
+		{name: "dev deployments only", role: "Admin", jsonData: `{"deploymentType": "self-hosted", "allowModelFileWrites": true}`, body: body, status: http.StatusForbidden},
+		{name: "rejects paths outside the model", role: "Admin", jsonData: jsonData, body: `{"files": [{"fileName": "../cube.js", "content": ""}]}`, status: http.StatusBadRequest},
+		{name: "rejects empty requests", role: "Admin", jsonData: jsonData, body: `{"files": []}`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotBody = ""
+			ds := &Datasource{BaseURL: server.URL}
+			pCtx := newTestPluginContextWithUser(server.URL, tt.role)
+			pCtx.DataSourceInstanceSettings.JSONData = []byte(tt.jsonData)
+			pCtx.DataSourceInstanceSettings.DecryptedSecureJSONData = map[string]string{"apiSecret": "secret"}
+			if _, err := ds.getCubeMetadata(context.Background(), pCtx); err != nil {
+				t.Fatalf("failed to fetch metadata: %v", err)
+			}
+			before := metaRequests
+
+			resp := callHandler(t, ds.CallResource, &backend.CallResourceRequest{
+				PluginContext: pCtx,
+				Path:          "model-files",
+				Method:        http.MethodPost,
+				URL:           "model-files",
+				Body:          []byte(tt.body),
+			})
+			if resp.Status != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, resp.Status, resp.Body)
+			}
+			if tt.status != http.StatusOK {
+				if gotBody != "" {
+					t.Errorf("expected nothing to be written, got %s", gotBody)
+				}
+				return
+			}
+
+			var sent SaveModelFilesRequest
+			if err := json.Unmarshal([]byte(gotBody), &sent); err != nil || len(sent.Files) != 1 || sent.Files[0].FileName != "cubes/orders.yml" {
+				t.Errorf("unexpected files sent to Cube: %s", gotBody)
+			}
+			if _, err := ds.getCubeMetadata(context.Background(), pCtx); err != nil {
+				t.Fatalf("failed to fetch metadata: %v", err)
+			}
+			if metaRequests != before+1 {
+				t.Errorf("expected the cached model to be dropped after saving")
+			}
+		})
+	}
+}
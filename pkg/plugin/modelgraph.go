@@ -0,0 +1,275 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryTypeModel is the query type of model queries, which return the model
+// graph as node graph frames instead of querying data.
+const queryTypeModel = "model"
+
+// ModelGraphResponse is the model-graph resource response: the cubes and
+// views of the data model and the joins between cubes.
+type ModelGraphResponse struct {
+	Nodes []ModelGraphNode `json:"nodes"`
+	Edges []ModelGraphEdge `json:"edges"`
+}
+
+// ModelGraphNode is a cube or view of the data model.
+type ModelGraphNode struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	// Type is "cube" or "view". Views have no edges.
+	Type string `json:"type"`
+	// ConnectedComponent is the part of the join graph the cube belongs to:
+	// cubes with the same component can be queried together. nil for views
+	// and when Cube does not report it.
+	ConnectedComponent *int `json:"connectedComponent,omitempty"`
+}
+
+// ModelGraphEdge is a join from the Source cube to the Target cube.
+type ModelGraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// Relationship is "many_to_one", "one_to_many" or "one_to_one".
+	Relationship string `json:"relationship"`
+}
+
+// cubeExtendedMeta is the part of a cube of Cube's extended metadata
+// (/v1/meta?extended=true) the model graph reads.
+type cubeExtendedMeta struct {
+	CubeMeta
+	Joins []struct {
+		Name         string `json:"name"`
+		Relationship string `json:"relationship"`
+	} `json:"joins,omitempty"`
+}
+
+// joinRelationships maps the relationships of Cube's legacy join syntax to
+// the ones the model graph reports.
+var joinRelationships = map[string]string{
+	"belongsTo":   "many_to_one",
+	"belongs_to":  "many_to_one",
+	"hasMany":     "one_to_many",
+	"has_many":    "one_to_many",
+	"hasOne":      "one_to_one",
+	"has_one":     "one_to_one",
+	"many_to_one": "many_to_one",
+	"one_to_many": "one_to_many",
+	"one_to_one":  "one_to_one",
+}
+
+// handleModelGraph returns the data model as a graph, for the frontend to
+// draw and the Assistant to reason about which cubes can be queried
+// together: a node per cube and view, and an edge per join between cubes,
+// from Cube's extended metadata. Hidden cubes and views, and those outside
+// the datasource's allowedViews, are left out with their joins.
+func (d *Datasource) handleModelGraph(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	apiReq, err := d.buildAPIURL(req.PluginContext, "meta")
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	cubes, err := d.fetchCubeExtendedMeta(ctx, apiReq)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to fetch extended cube metadata", "error", err)
+		return sender.Send(cubeLoadErrorResponse(err))
+	}
+
+	body, err := json.Marshal(modelGraph(cubes, apiReq.Config))
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal model graph response", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   body,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
+
+// fetchCubeExtendedMeta fetches Cube's extended metadata, which adds the
+// joins of each cube to /v1/meta. It bypasses the metadata cache, which
+// holds the plain metadata.
+func (d *Datasource) fetchCubeExtendedMeta(ctx context.Context, apiReq *APIRequestContext) ([]cubeExtendedMeta, error) {
+	ctx, cancel := withTimeout(ctx, apiReq.Config.MetaTimeoutDuration())
+	defer cancel()
+
+	u, err := url.Parse(apiReq.URL.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse API URL: %w", err)
+	}
+	u.RawQuery = url.Values{"extended": {"true"}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := d.addAuthHeaders(req, apiReq.Config); err != nil {
+		return nil, fmt.Errorf("failed to add auth headers: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.doHTTP(req, apiReq.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make API request: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			backend.Logger.FromContext(ctx).Warn("Failed to close response body", "error", err)
+		}
+	}()
+	body, err := readJSONResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta struct {
+		Cubes []cubeExtendedMeta `json:"cubes"`
+	}
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+	return meta.Cubes, nil
+}
+
+// modelGraph builds the graph of the cubes and views of the extended
+// metadata that config lets users see. Joins to cubes not in the graph, and
+// joins Cube reports from both ends, are dropped.
+func modelGraph(cubes []cubeExtendedMeta, config *models.PluginSettings) ModelGraphResponse {
+	graph := ModelGraphResponse{Nodes: []ModelGraphNode{}, Edges: []ModelGraphEdge{}}
+	for _, cube := range cubes {
+		if !isVisible(cube.IsVisible, cube.Public) || !config.ViewAllowed(cube.Name) {
+			continue
+		}
+		graph.Nodes = append(graph.Nodes, ModelGraphNode{
+			Name:               cube.Name,
+			Title:              cube.Title,
+			Type:               cubeType(cube.Type),
+			ConnectedComponent: cube.ConnectedComponent,
+		})
+	}
+	inGraph := func(name string) bool {
+		return slices.ContainsFunc(graph.Nodes, func(n ModelGraphNode) bool { return n.Name == name && n.Type == "cube" })
+	}
+
+	for _, cube := range cubes {
+		if !inGraph(cube.Name) {
+			continue
+		}
+		for _, join := range cube.Joins {
+			relationship, ok := joinRelationships[join.Relationship]
+			if !ok || !inGraph(join.Name) || join.Name == cube.Name {
+				continue
+			}
+			edge := ModelGraphEdge{Source: cube.Name, Target: join.Name, Relationship: relationship}
+			if slices.ContainsFunc(graph.Edges, func(e ModelGraphEdge) bool { return e == reverseEdge(edge) || e == edge }) {
+				continue
+			}
+			graph.Edges = append(graph.Edges, edge)
+		}
+	}
+	return graph
+}
+
+// cubeType returns the type of a cube or view of the metadata, which Cube
+// leaves out for cubes in older versions.
+func cubeType(t string) string {
+	if t == "" {
+		return "cube"
+	}
+	return t
+}
+
+// reverseEdge returns edge as declared from its target.
+func reverseEdge(edge ModelGraphEdge) ModelGraphEdge {
+	reversed := ModelGraphEdge{Source: edge.Target, Target: edge.Source, Relationship: edge.Relationship}
+	switch edge.Relationship {
+	case "many_to_one":
+		reversed.Relationship = "one_to_many"
+	case "one_to_many":
+		reversed.Relationship = "many_to_one"
+	}
+	return reversed
+}
+
+// isModelQuery reports whether query is a model query: its queryType, in the
+// query JSON or set by Grafana, is "model".
+func isModelQuery(query backend.DataQuery) bool {
+	if query.QueryType == queryTypeModel {
+		return true
+	}
+	var q struct {
+		QueryType string `json:"queryType"`
+	}
+	return json.Unmarshal(query.JSON, &q) == nil && q.QueryType == queryTypeModel
+}
+
+// modelQuery answers a model query with the model graph as the nodes and
+// edges frames of Grafana's node graph panel. Nodes show the cube or view
+// name with its type, edges the relationship of the join.
+func (d *Datasource) modelQuery(ctx context.Context, pCtx backend.PluginContext) backend.DataResponse {
+	apiReq, err := d.buildAPIURL(pCtx, "meta")
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	cubes, err := d.fetchCubeExtendedMeta(ctx, apiReq)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to fetch extended cube metadata", "error", err)
+		return loadErrorResponse(err)
+	}
+	return backend.DataResponse{Frames: modelGraphFrames(modelGraph(cubes, apiReq.Config))}
+}
+
+// modelGraphFrames converts a model graph into node graph frames.
+func modelGraphFrames(graph ModelGraphResponse) data.Frames {
+	nodeIDs := make([]string, len(graph.Nodes))
+	titles := make([]string, len(graph.Nodes))
+	subtitles := make([]string, len(graph.Nodes))
+	components := make([]*int64, len(graph.Nodes))
+	for i, node := range graph.Nodes {
+		nodeIDs[i], subtitles[i] = node.Name, node.Type
+		titles[i] = node.Title
+		if titles[i] == "" {
+			titles[i] = node.Name
+		}
+		if node.ConnectedComponent != nil {
+			component := int64(*node.ConnectedComponent)
+			components[i] = &component
+		}
+	}
+	nodes := data.NewFrame("nodes",
+		data.NewField("id", nil, nodeIDs),
+		data.NewField("title", nil, titles),
+		data.NewField("subtitle", nil, subtitles),
+		data.NewField("detail__connectedComponent", nil, components).SetConfig(&data.FieldConfig{DisplayName: "Connected component"}),
+	)
+	nodes.SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph})
+
+	edgeIDs := make([]string, len(graph.Edges))
+	sources := make([]string, len(graph.Edges))
+	targets := make([]string, len(graph.Edges))
+	relationships := make([]string, len(graph.Edges))
+	for i, edge := range graph.Edges {
+		edgeIDs[i] = edge.Source + "->" + edge.Target
+		sources[i], targets[i], relationships[i] = edge.Source, edge.Target, edge.Relationship
+	}
+	edges := data.NewFrame("edges",
+		data.NewField("id", nil, edgeIDs),
+		data.NewField("source", nil, sources),
+		data.NewField("target", nil, targets),
+		data.NewField("mainstat", nil, relationships).SetConfig(&data.FieldConfig{DisplayName: "Relationship"}),
+	)
+	edges.SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph})
+	return data.Frames{nodes, edges}
+}
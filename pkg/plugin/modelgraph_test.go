@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestHandleModelGraph(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cubejs-api/v1/meta" || r.URL.Query().Get("extended") != "true" {
+			t.Errorf("expected an extended meta request, got %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"cubes": [
+			{"name": "orders", "title": "Orders", "type": "cube", "connectedComponent": 1,
+				"joins": [{"name": "users", "relationship": "belongsTo", "sql": "{CUBE}.user_id = {users}.id"}, {"name": "line_items", "relationship": "one_to_many"}]},
+			{"name": "users", "title": "Users", "type": "cube", "connectedComponent": 1,
+				"joins": [{"name": "orders", "relationship": "hasMany"}, {"name": "secrets", "relationship": "one_to_one"}]},
+			{"name": "line_items", "title": "Line Items", "connectedComponent": 1},
+			{"name": "secrets", "title": "Secrets", "type": "cube", "isVisible": false},
+			{"name": "orders_view", "title": "Orders View", "type": "view"}]}`))
+	}))
+	defer server.Close()
+
+	resp := callHandler(t, (&Datasource{}).handleModelGraph, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "model-graph",
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+	var graph ModelGraphResponse
+	if err := json.Unmarshal(resp.Body, &graph); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+
+	var names []string
+	for _, node := range graph.Nodes {
+		names = append(names, node.Name+":"+node.Type)
+	}
+	if want := []string{"orders:cube", "users:cube", "line_items:cube", "orders_view:view"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected nodes %v without the hidden cube, got %v", want, names)
+	}
+	want := []ModelGraphEdge{
+		{Source: "orders", Target: "users", Relationship: "many_to_one"},
+		{Source: "orders", Target: "line_items", Relationship: "one_to_many"},
+	}
+	if !reflect.DeepEqual(graph.Edges, want) {
+		t.Errorf("expected edges %v, got %v", want, graph.Edges)
+	}
+}
+
+func TestModelGraphAllowedViews(t *testing.T) {
+	cubes := []cubeExtendedMeta{
+		{CubeMeta: CubeMeta{Name: "orders", Type: "cube"}},
+		{CubeMeta: CubeMeta{Name: "orders_view", Type: "view"}},
+		{CubeMeta: CubeMeta{Name: "users_view", Type: "view"}},
+	}
+	graph := modelGraph(cubes, &models.PluginSettings{AllowedViews: []string{"orders_view"}})
+	if len(graph.Nodes) != 1 || graph.Nodes[0].Name != "orders_view" || len(graph.Edges) != 0 {
+		t.Errorf("expected only the allowed view, got %+v", graph)
+	}
+}
+
+func TestHandleModelGraphCubeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error": "Compile error"}`))
+	}))
+	defer server.Close()
+
+	resp := callHandler(t, (&Datasource{}).handleModelGraph, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "model-graph",
+	})
+	if resp.Status < 400 {
+		t.Errorf("expected an error status, got %d: %s", resp.Status, resp.Body)
+	}
+}
+
+func TestQueryDataModelQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("extended") != "true" {
+			t.Errorf("expected only an extended meta request, got %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"cubes": [
+			{"name": "orders", "title": "Orders", "type": "cube", "connectedComponent": 1, "joins": [{"name": "users", "relationship": "many_to_one"}]},
+			{"name": "users", "type": "cube", "connectedComponent": 1},
+			{"name": "orders_view", "title": "Orders View", "type": "view"}]}`))
+	}))
+	defer server.Close()
+
+	resp := runSingleQuery(t, &Datasource{}, newTestPluginContext(server.URL), `{"refId": "A", "queryType": "model"}`)
+	if resp.Error != nil {
+		t.Fatalf("unexpected error: %v", resp.Error)
+	}
+	if len(resp.Frames) != 2 || resp.Frames[0].Name != "nodes" || resp.Frames[1].Name != "edges" {
+		t.Fatalf("expected nodes and edges frames, got %v", resp.Frames)
+	}
+	nodes, edges := resp.Frames[0], resp.Frames[1]
+	if nodes.Meta == nil || nodes.Meta.PreferredVisualization != data.VisTypeNodeGraph {
+		t.Errorf("expected the node graph visualization, got %+v", nodes.Meta)
+	}
+	if nodes.Rows() != 3 {
+		t.Fatalf("expected 3 nodes, got %d", nodes.Rows())
+	}
+	if title, _ := nodes.Fields[1].ConcreteAt(1); title != "users" {
+		t.Errorf("expected untitled nodes titled with their name, got %v", title)
+	}
+	if subtitle, _ := nodes.Fields[2].ConcreteAt(2); subtitle != "view" {
+		t.Errorf("expected the view subtitled with its type, got %v", subtitle)
+	}
+	if edges.Rows() != 1 {
+		t.Fatalf("expected 1 edge, got %d", edges.Rows())
+	}
+	for i, want := range []string{"orders->users", "orders", "users", "many_to_one"} {
+		if got, _ := edges.Fields[i].ConcreteAt(0); got != want {
+			t.Errorf("edge field %s: expected %s, got %v", edges.Fields[i].Name, want, got)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// defaultPageSize is the page size of page calls that do not set one.
+const defaultPageSize = 100
+
+// maxPageSize bounds the page size, like exportPageSize bounds export pages.
+const maxPageSize = exportPageSize
+
+// PageResponse is the page resource response: a page of a query's rows and
+// the row count of the whole result.
+type PageResponse struct {
+	Page     int `json:"page"`
+	PageSize int `json:"pageSize"`
+	// Total is the row count of the query without pagination; nil when Cube
+	// did not report it.
+	Total *int64      `json:"total"`
+	Frame *data.Frame `json:"frame"`
+}
+
+// parsePageParam parses a positive page or pageSize parameter, returning def
+// when it is not set.
+func parsePageParam(params url.Values, name string, def int) (int, error) {
+	value := params.Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// handlePage returns one page of a panel query's rows for paginated tables,
+// so tables over large results load the rows they show rather than all of
+// them. The page (from 1) and pageSize parameters become Cube's limit and
+// offset, replacing the query's own limit. The query and time range are read
+// like for the export resource. The response holds the page as a data frame
+// and the row count of the whole result.
+func (d *Datasource) handlePage(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
+	}
+	params := parsedURL.Query()
+	page, err := parsePageParam(params, "page", 1)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	pageSize, err := parsePageParam(params, "pageSize", defaultPageSize)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	if pageSize > maxPageSize {
+		return sender.Send(jsonErrorResponse(400, fmt.Errorf("pageSize must be at most %d", maxPageSize)))
+	}
+	queryJSON, timeRange, err := parsePanelQuery(req, params)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+
+	prepared, errResponse := d.prepareQuery(ctx, req.PluginContext, backend.DataQuery{RefID: "page", JSON: queryJSON, TimeRange: timeRange})
+	if prepared == nil {
+		return sender.Send(jsonErrorResponse(int(errResponse.Status), errResponse.Error))
+	}
+	if len(prepared.blended) > 0 {
+		return sender.Send(jsonErrorResponse(400, errors.New("blended queries cannot be paginated")))
+	}
+	apiReq, err := d.buildAPIURL(req.PluginContext, "load")
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+
+	apiQuery := maps.Clone(prepared.apiQuery)
+	apiQuery["limit"] = pageSize
+	apiQuery["offset"] = (page - 1) * pageSize
+	apiQuery["total"] = true
+	apiQueryJSON, err := json.Marshal(apiQuery)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal query")))
+	}
+	body, err := d.doCubeLoadRequest(ctx, apiReq.URL.String(), apiQueryJSON, apiReq.Config)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to load page", "error", err, "page", page)
+		return sender.Send(cubeLoadErrorResponse(err))
+	}
+	result, err := decodeLoadResult(body)
+	if err != nil {
+		return sender.Send(cubeLoadErrorResponse(err))
+	}
+	query := prepared.query
+	annotation := applyTypeOverrides(result.Annotation, query.TypeOverrides)
+	rows := coerceOverriddenStrings(result.Data, query.TypeOverrides)
+
+	responseBody, err := json.Marshal(PageResponse{
+		Page:     page,
+		PageSize: pageSize,
+		Total:    result.Total,
+		Frame:    d.buildFrame("page", rows, query, annotation, prepared.timeLayouts),
+	})
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal page response", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   responseBody,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHandlePage(t *testing.T) {
+	var sent map[string]interface{}
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("query")), &sent); err != nil {
+			t.Errorf("invalid query: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [{"orders.status": "completed", "orders.count": "3"}, {"orders.status": "shipped", "orders.count": "2"}],
+			"annotation": {"dimensions": {"orders.status": {"type": "string"}}, "measures": {"orders.count": {"type": "number"}}},
+			"total": 1234}`))
+	}))
+	defer server.Close()
+
+	params := url.Values{"page": {"3"}, "pageSize": {"50"}, "query": {`{"refId": "A", "dimensions": ["orders.status"], "measures": ["orders.count"], "limit": 10}`}}
+	resp := callHandler(t, (&Datasource{}).handlePage, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "page?" + params.Encode(),
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+	if sent["limit"] != float64(50) || sent["offset"] != float64(100) || sent["total"] != true {
+		t.Errorf("expected limit 50, offset 100 and total, got %v %v %v", sent["limit"], sent["offset"], sent["total"])
+	}
+
+	var page struct {
+		Page     int             `json:"page"`
+		PageSize int             `json:"pageSize"`
+		Total    *int64          `json:"total"`
+		Frame    json.RawMessage `json:"frame"`
+	}
+	if err := json.Unmarshal(resp.Body, &page); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if page.Page != 3 || page.PageSize != 50 || page.Total == nil || *page.Total != 1234 {
+		t.Errorf("unexpected page %+v", page)
+	}
+	var frame struct {
+		Data struct {
+			Values [][]interface{} `json:"values"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(page.Frame, &frame); err != nil {
+		t.Fatalf("invalid frame: %v", err)
+	}
+	if len(frame.Data.Values) != 2 || len(frame.Data.Values[0]) != 2 {
+		t.Errorf("expected 2 fields of 2 rows, got %v", frame.Data.Values)
+	}
+}
+
+func TestHandlePageErrors(t *testing.T) {
+	query := url.QueryEscape(`{"measures": ["orders.count"]}`)
+	for name, rawURL := range map[string]string{
+		"no query":          "page",
+		"invalid page":      "page?page=0&query=" + query,
+		"invalid page size": "page?pageSize=abc&query=" + query,
+		"page size too big": "page?pageSize=10001&query=" + query,
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp := callHandler(t, (&Datasource{}).handlePage, &backend.CallResourceRequest{
+				PluginContext: newTestPluginContext("http://localhost:4000"),
+				URL:           rawURL,
+			})
+			if resp.Status != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d: %s", resp.Status, resp.Body)
+			}
+		})
+	}
+}
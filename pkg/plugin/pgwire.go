@@ -0,0 +1,293 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5" //nolint:gosec // Required by Postgres MD5 password authentication
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// pgProtocolVersion is version 3.0 of the Postgres frontend/backend protocol.
+const pgProtocolVersion = 3 << 16
+
+// maxPGMessageSize bounds a single message read from the SQL API, so a
+// corrupt length cannot make us allocate unbounded memory.
+const maxPGMessageSize = 1 << 30
+
+// pgColumn describes a result column of a Postgres query.
+type pgColumn struct {
+	Name    string
+	TypeOID uint32
+}
+
+// pgResult is the result of a Postgres query. Values are in the text format;
+// a nil value is NULL.
+type pgResult struct {
+	Columns []pgColumn
+	Rows    [][]*string
+}
+
+// pgError is an ErrorResponse sent by the server.
+type pgError struct {
+	Code    string
+	Message string
+}
+
+func (e *pgError) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (SQLSTATE %s)", e.Message, e.Code)
+}
+
+// pgConn is a minimal client of the Postgres wire protocol, speaking just
+// enough of it to run queries against Cube's SQL API: startup with cleartext
+// or MD5 password authentication, and the simple query protocol.
+type pgConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialPG connects to a Postgres server and authenticates. The connection is
+// closed when ctx is done.
+func dialPG(ctx context.Context, dialer *net.Dialer, addr, user, password, database string) (*pgConn, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &pgConn{conn: conn, r: bufio.NewReader(conn)}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if err := c.startup(user, password, database); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// startup sends the startup message and runs the authentication exchange
+// until the server is ready for queries.
+func (c *pgConn) startup(user, password, database string) error {
+	var body []byte
+	body = binary.BigEndian.AppendUint32(body, pgProtocolVersion)
+	for _, param := range []string{"user", user, "database", database, "application_name", "grafana-cube-datasource"} {
+		body = append(body, param...)
+		body = append(body, 0)
+	}
+	body = append(body, 0)
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(body)+4))
+	if _, err := c.conn.Write(append(msg, body...)); err != nil {
+		return err
+	}
+
+	for {
+		typ, payload, err := c.receive()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case 'R':
+			if len(payload) < 4 {
+				return errors.New("malformed authentication message")
+			}
+			switch code := binary.BigEndian.Uint32(payload); code {
+			case 0: // AuthenticationOk
+			case 3: // AuthenticationCleartextPassword
+				if err := c.send('p', append([]byte(password), 0)); err != nil {
+					return err
+				}
+			case 5: // AuthenticationMD5Password
+				if len(payload) < 8 {
+					return errors.New("malformed MD5 authentication message")
+				}
+				if err := c.send('p', append([]byte(pgMD5Password(user, password, payload[4:8])), 0)); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unsupported authentication method %d", code)
+			}
+		case 'E':
+			return parsePGError(payload)
+		case 'Z':
+			return nil
+		}
+		// ParameterStatus, BackendKeyData and notices are not needed.
+	}
+}
+
+// pgMD5Password returns the response to an MD5 password challenge.
+func pgMD5Password(user, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + user))                               //nolint:gosec // Required by the protocol
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...)) //nolint:gosec // Required by the protocol
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+// query runs sql with the simple query protocol and returns the rows of its
+// last statement. When the rows received exceed maxBytes (0 for no limit),
+// the query fails with responseTooLargeError.
+func (c *pgConn) query(sql string, maxBytes int64) (*pgResult, error) {
+	if err := c.send('Q', append([]byte(sql), 0)); err != nil {
+		return nil, err
+	}
+	result := &pgResult{}
+	var queryErr error
+	var rowBytes int64
+	for {
+		typ, payload, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case 'T':
+			columns, err := parsePGRowDescription(payload)
+			if err != nil {
+				return nil, err
+			}
+			result = &pgResult{Columns: columns}
+		case 'D':
+			rowBytes += int64(len(payload))
+			if maxBytes > 0 && rowBytes > maxBytes {
+				return nil, responseTooLargeError(maxBytes)
+			}
+			row, err := parsePGDataRow(payload)
+			if err != nil {
+				return nil, err
+			}
+			result.Rows = append(result.Rows, row)
+		case 'E':
+			queryErr = parsePGError(payload)
+		case 'Z':
+			if queryErr != nil {
+				return nil, queryErr
+			}
+			return result, nil
+		}
+		// CommandComplete, EmptyQueryResponse and notices need no handling.
+	}
+}
+
+// Close terminates the session and closes the connection.
+func (c *pgConn) Close() error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_ = c.send('X', nil)
+	return c.conn.Close()
+}
+
+func (c *pgConn) send(typ byte, payload []byte) error {
+	msg := make([]byte, 0, 5+len(payload))
+	msg = append(msg, typ)
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(payload)+4))
+	msg = append(msg, payload...)
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+func (c *pgConn) receive() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size < 4 || size > maxPGMessageSize {
+		return 0, nil, fmt.Errorf("invalid message length %d", size)
+	}
+	payload := make([]byte, size-4)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// pgReader reads the fields of a message payload.
+type pgReader struct {
+	buf []byte
+	err error
+}
+
+func (r *pgReader) next(n int) []byte {
+	if r.err != nil || n < 0 || len(r.buf) < n {
+		r.err = errors.New("malformed message")
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *pgReader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *pgReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *pgReader) cstring() string {
+	for i, b := range r.buf {
+		if b == 0 {
+			s := string(r.buf[:i])
+			r.buf = r.buf[i+1:]
+			return s
+		}
+	}
+	r.err = errors.New("malformed message")
+	return ""
+}
+
+func parsePGRowDescription(payload []byte) ([]pgColumn, error) {
+	r := &pgReader{buf: payload}
+	columns := make([]pgColumn, r.uint16())
+	for i := range columns {
+		columns[i].Name = r.cstring()
+		r.next(6) // table OID, attribute number
+		columns[i].TypeOID = r.uint32()
+		r.next(8) // type size, type modifier, format code
+	}
+	return columns, r.err
+}
+
+func parsePGDataRow(payload []byte) ([]*string, error) {
+	r := &pgReader{buf: payload}
+	values := make([]*string, r.uint16())
+	for i := range values {
+		size := int32(r.uint32())
+		if size < 0 {
+			continue
+		}
+		value := string(r.next(int(size)))
+		values[i] = &value
+	}
+	return values, r.err
+}
+
+func parsePGError(payload []byte) error {
+	r := &pgReader{buf: payload}
+	pgErr := &pgError{}
+	for r.err == nil && len(r.buf) > 0 && r.buf[0] != 0 {
+		field := r.next(1)[0]
+		value := r.cstring()
+		switch field {
+		case 'C':
+			pgErr.Code = value
+		case 'M':
+			pgErr.Message = value
+		}
+	}
+	if pgErr.Message == "" {
+		pgErr.Message = "unknown error"
+	}
+	return pgErr
+}
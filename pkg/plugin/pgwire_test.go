@@ -0,0 +1,282 @@
+package plugin
+
+import (
+	"bufio"
+	"crypto/md5" //nolint:gosec // Required by Postgres MD5 password authentication
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// pgTestServer is the server end of an in-memory connection to a pgConn,
+// driven message by message by a test.
+type pgTestServer struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newPGTestPipe connects a pgConn to a pgTestServer. Both ends are closed
+// when the test ends.
+func newPGTestPipe(t *testing.T) (*pgConn, *pgTestServer) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return &pgConn{conn: client, r: bufio.NewReader(client)}, &pgTestServer{t: t, conn: server, r: bufio.NewReader(server)}
+}
+
+func (s *pgTestServer) send(typ byte, payload []byte) {
+	msg := append([]byte{typ}, binary.BigEndian.AppendUint32(nil, uint32(len(payload)+4))...)
+	_, _ = s.conn.Write(append(msg, payload...))
+}
+
+// receive reads a typed message, returning its payload without the
+// terminating NUL.
+func (s *pgTestServer) receive() (byte, string) {
+	var header [5]byte
+	if _, err := io.ReadFull(s.r, header[:]); err != nil {
+		return 0, ""
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	_, _ = io.ReadFull(s.r, payload)
+	return header[0], strings.TrimSuffix(string(payload), "\x00")
+}
+
+// receiveStartup reads the untyped startup message and returns its
+// parameters.
+func (s *pgTestServer) receiveStartup() map[string]string {
+	var size uint32
+	if err := binary.Read(s.r, binary.BigEndian, &size); err != nil {
+		return nil
+	}
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(s.r, body); err != nil {
+		return nil
+	}
+	if version := binary.BigEndian.Uint32(body); version != pgProtocolVersion {
+		s.t.Errorf("expected protocol version 3.0, got %#x", version)
+	}
+	params := map[string]string{}
+	fields := strings.Split(string(body[4:]), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		params[fields[i]] = fields[i+1]
+	}
+	return params
+}
+
+func pgAuth(code uint32, extra ...byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, code), extra...)
+}
+
+func TestPGStartupCleartextPassword(t *testing.T) {
+	client, server := newPGTestPipe(t)
+	password := make(chan string, 1)
+	go func() {
+		params := server.receiveStartup()
+		if params["user"] != "grafana" || params["database"] != "db" {
+			t.Errorf("unexpected startup parameters %v", params)
+		}
+		server.send('R', pgAuth(3))
+		if typ, p := server.receive(); typ == 'p' {
+			password <- p
+		}
+		server.send('R', pgAuth(0))
+		server.send('S', []byte("server_version\x0014\x00"))
+		server.send('Z', []byte{'I'})
+	}()
+
+	if err := client.startup("grafana", "s3cret", "db"); err != nil {
+		t.Fatalf("startup failed: %v", err)
+	}
+	if got := <-password; got != "s3cret" {
+		t.Errorf("expected the password in cleartext, got %q", got)
+	}
+}
+
+func TestPGStartupMD5Password(t *testing.T) {
+	client, server := newPGTestPipe(t)
+	salt := []byte{1, 2, 3, 4}
+	password := make(chan string, 1)
+	go func() {
+		server.receiveStartup()
+		server.send('R', pgAuth(5, salt...))
+		if typ, p := server.receive(); typ == 'p' {
+			password <- p
+		}
+		server.send('R', pgAuth(0))
+		server.send('Z', []byte{'I'})
+	}()
+
+	if err := client.startup("grafana", "s3cret", "db"); err != nil {
+		t.Fatalf("startup failed: %v", err)
+	}
+	inner := md5.Sum([]byte("s3cretgrafana"))                               //nolint:gosec // Required by the protocol
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...)) //nolint:gosec // Required by the protocol
+	if got, want := <-password, "md5"+hex.EncodeToString(outer[:]); got != want {
+		t.Errorf("expected MD5 response %q, got %q", want, got)
+	}
+}
+
+func TestPGStartupFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   func(server *pgTestServer)
+		wantErr string
+	}{
+		{
+			name:    "unsupported authentication method",
+			reply:   func(server *pgTestServer) { server.send('R', pgAuth(10, []byte("SCRAM-SHA-256\x00\x00")...)) },
+			wantErr: "unsupported authentication method 10",
+		},
+		{
+			name:    "malformed MD5 challenge",
+			reply:   func(server *pgTestServer) { server.send('R', pgAuth(5, 1, 2)) },
+			wantErr: "malformed MD5 authentication message",
+		},
+		{
+			name: "authentication rejected",
+			reply: func(server *pgTestServer) {
+				server.send('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"))
+			},
+			wantErr: "password authentication failed (SQLSTATE 28P01)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := newPGTestPipe(t)
+			go func() {
+				server.receiveStartup()
+				tt.reply(server)
+			}()
+			err := client.startup("grafana", "s3cret", "db")
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// pgRowDescription encodes a RowDescription message for columns.
+func pgRowDescription(columns ...pgColumn) []byte {
+	desc := binary.BigEndian.AppendUint16(nil, uint16(len(columns)))
+	for _, col := range columns {
+		desc = append(desc, col.Name...)
+		desc = append(desc, 0, 0, 0, 0, 0, 0, 0)
+		desc = binary.BigEndian.AppendUint32(desc, col.TypeOID)
+		desc = append(desc, make([]byte, 8)...)
+	}
+	return desc
+}
+
+// pgDataRow encodes a DataRow message; a nil value is NULL.
+func pgDataRow(values ...*string) []byte {
+	data := binary.BigEndian.AppendUint16(nil, uint16(len(values)))
+	for _, value := range values {
+		if value == nil {
+			data = binary.BigEndian.AppendUint32(data, 0xFFFFFFFF)
+			continue
+		}
+		data = binary.BigEndian.AppendUint32(data, uint32(len(*value)))
+		data = append(data, *value...)
+	}
+	return data
+}
+
+func TestPGQuery(t *testing.T) {
+	client, server := newPGTestPipe(t)
+	status, count := "completed", "42"
+	go func() {
+		if typ, sql := server.receive(); typ != 'Q' || sql != "SELECT 1" {
+			t.Errorf("expected a simple query, got %c %q", typ, sql)
+		}
+		server.send('T', pgRowDescription(pgColumn{Name: "orders.status", TypeOID: 25}, pgColumn{Name: "orders.count", TypeOID: pgTypeInt4}))
+		server.send('D', pgDataRow(&status, &count))
+		server.send('D', pgDataRow(nil, &count))
+		server.send('C', []byte("SELECT 2\x00"))
+		server.send('Z', []byte{'I'})
+	}()
+
+	result, err := client.query("SELECT 1", 0)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(result.Columns) != 2 || result.Columns[0] != (pgColumn{Name: "orders.status", TypeOID: 25}) || result.Columns[1] != (pgColumn{Name: "orders.count", TypeOID: pgTypeInt4}) {
+		t.Errorf("unexpected columns %v", result.Columns)
+	}
+	if len(result.Rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(result.Rows))
+	}
+	if *result.Rows[0][0] != "completed" || *result.Rows[0][1] != "42" {
+		t.Errorf("unexpected first row [%s %s]", *result.Rows[0][0], *result.Rows[0][1])
+	}
+	if result.Rows[1][0] != nil {
+		t.Errorf("expected NULL to decode to nil, got %q", *result.Rows[1][0])
+	}
+}
+
+func TestPGQueryErrorResponse(t *testing.T) {
+	client, server := newPGTestPipe(t)
+	go func() {
+		server.receive()
+		server.send('E', []byte("SERROR\x00C42703\x00MUnknown column 'nope'\x00\x00"))
+		server.send('Z', []byte{'I'})
+	}()
+
+	_, err := client.query("SELECT nope", 0)
+	var pgErr *pgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "42703" || pgErr.Message != "Unknown column 'nope'" {
+		t.Errorf("expected the server's error, got %v", err)
+	}
+}
+
+func TestPGQueryTooLarge(t *testing.T) {
+	client, server := newPGTestPipe(t)
+	value := strings.Repeat("x", 16)
+	go func() {
+		server.receive()
+		server.send('T', pgRowDescription(pgColumn{Name: "orders.status", TypeOID: 25}))
+		for i := 0; i < 4; i++ {
+			server.send('D', pgDataRow(&value))
+		}
+		server.send('Z', []byte{'I'})
+	}()
+
+	_, err := client.query("SELECT 1", 50)
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("expected a response too large error, got %v", err)
+	}
+}
+
+func TestPGReceiveInvalidLength(t *testing.T) {
+	for _, size := range []uint32{3, maxPGMessageSize + 1} {
+		client, server := newPGTestPipe(t)
+		go func() {
+			_, _ = server.conn.Write(append([]byte{'D'}, binary.BigEndian.AppendUint32(nil, size)...))
+		}()
+		if _, _, err := client.receive(); err == nil || !strings.Contains(err.Error(), "invalid message length") {
+			t.Errorf("expected length %d to be rejected, got %v", size, err)
+		}
+	}
+}
+
+func TestParsePGMalformedMessages(t *testing.T) {
+	status := "completed"
+	desc := pgRowDescription(pgColumn{Name: "orders.status", TypeOID: 25})
+	if _, err := parsePGRowDescription(desc[:len(desc)-1]); err == nil {
+		t.Error("expected a truncated RowDescription to fail")
+	}
+	row := pgDataRow(&status)
+	if _, err := parsePGDataRow(row[:len(row)-1]); err == nil {
+		t.Error("expected a truncated DataRow to fail")
+	}
+	if err := parsePGError([]byte("SERROR\x00\x00")); err.Error() != "unknown error" {
+		t.Errorf("expected an ErrorResponse without a message to report an unknown error, got %v", err)
+	}
+}
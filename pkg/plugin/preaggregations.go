@@ -0,0 +1,356 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// cubeSQLPlan is the part of a /v1/sql response describing how Cube will
+// answer the query.
+type cubeSQLPlan struct {
+	SQL struct {
+		SQL []interface{} `json:"sql"`
+		// External is set when the query runs against Cube Store rather
+		// than the source database.
+		External        bool                    `json:"external"`
+		DataSource      string                  `json:"dataSource"`
+		PreAggregations []cubeSQLPreAggregation `json:"preAggregations"`
+	} `json:"sql"`
+}
+
+// cubeSQLPreAggregation is a pre-aggregation a compiled query reads from.
+type cubeSQLPreAggregation struct {
+	PreAggregationID string `json:"preAggregationId"`
+	TableName        string `json:"tableName"`
+	Type             string `json:"type"`
+	External         bool   `json:"external"`
+	// LoadSQL is the [sqlString, parameters] pair that builds the
+	// pre-aggregation table.
+	LoadSQL []interface{} `json:"loadSql,omitempty"`
+}
+
+// PreAggregationUsage is a pre-aggregation that would serve a query.
+type PreAggregationUsage struct {
+	// ID is "<cube>.<pre-aggregation name>".
+	ID        string `json:"id"`
+	TableName string `json:"tableName"`
+	Type      string `json:"type,omitempty"`
+	// External is set when the pre-aggregation is stored in Cube Store.
+	External bool `json:"external"`
+}
+
+// PreAggregationPreview reports how Cube would serve a query.
+type PreAggregationPreview struct {
+	// UsesPreAggregation is false when the query would hit the warehouse.
+	UsesPreAggregation bool                  `json:"usesPreAggregation"`
+	PreAggregations    []PreAggregationUsage `json:"preAggregations"`
+	DataSource         string                `json:"dataSource,omitempty"`
+	// SQL is the SQL Cube would run, against the pre-aggregation tables when
+	// one is used.
+	SQL string `json:"sql"`
+}
+
+// previewFromSQLPlan builds the PreAggregationPreview of a /v1/sql response.
+func previewFromSQLPlan(plan cubeSQLPlan) (PreAggregationPreview, error) {
+	preview := PreAggregationPreview{
+		PreAggregations: make([]PreAggregationUsage, 0, len(plan.SQL.PreAggregations)),
+		DataSource:      plan.SQL.DataSource,
+	}
+	if len(plan.SQL.SQL) > 0 {
+		sql, ok := plan.SQL.SQL[0].(string)
+		if !ok {
+			return preview, errors.New("SQL response is not a string")
+		}
+		preview.SQL = sql
+	}
+	for _, p := range plan.SQL.PreAggregations {
+		preview.PreAggregations = append(preview.PreAggregations, PreAggregationUsage{
+			ID:        p.PreAggregationID,
+			TableName: p.TableName,
+			Type:      p.Type,
+			External:  p.External || plan.SQL.External,
+		})
+	}
+	preview.UsesPreAggregation = len(preview.PreAggregations) > 0
+	return preview, nil
+}
+
+// handlePreAggregationPreview reports whether Cube would serve a query from
+// a pre-aggregation, and which, or from the warehouse. It compiles the query
+// with /v1/sql, as it will run (default filters, segment filters and rewrite
+// rules applied), without running it.
+func (d *Datasource) handlePreAggregationPreview(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
+	}
+	queryParam := parsedURL.Query().Get("query")
+	if queryParam == "" {
+		return sender.Send(jsonErrorResponse(400, errors.New("query parameter is required")))
+	}
+	var cubeQuery CubeQuery
+	if err := json.Unmarshal([]byte(queryParam), &cubeQuery); err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid query JSON")))
+	}
+	if !cubeQuery.IgnoreDefaultFilters {
+		if queryParam, err = withDefaultFiltersJSON(queryParam, d.defaultFilters(req.PluginContext)); err != nil {
+			return sender.Send(jsonErrorResponse(400, errors.New("invalid query JSON")))
+		}
+	}
+	if queryParam, err = withSegmentFiltersJSON(queryParam); err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	config := d.pluginSettings(req.PluginContext)
+	if err := checkAllowedQueryJSON(config, queryParam); err != nil {
+		return sender.Send(jsonErrorResponse(403, err))
+	}
+	queryJSON, err := rewriteQueryJSON([]byte(queryParam), req.PluginContext, config)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+
+	body, err := d.fetchCubeSQLBody(ctx, req.PluginContext, string(queryJSON))
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to compile query with Cube", "error", err)
+		return sender.Send(cubeLoadErrorResponse(err))
+	}
+	var plan cubeSQLPlan
+	if err := json.Unmarshal(body, &plan); err != nil {
+		return sender.Send(jsonErrorResponse(502, fmt.Errorf("failed to parse API response: %w", err)))
+	}
+	preview, err := previewFromSQLPlan(plan)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(502, err))
+	}
+
+	responseBody, err := json.Marshal(preview)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal pre-aggregation preview", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   responseBody,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
+
+// Build statuses of a pre-aggregation.
+const (
+	preAggregationBuilt    = "built"
+	preAggregationPartial  = "partial"
+	preAggregationNotBuilt = "notBuilt"
+	// preAggregationUnknown is reported when Cube did not return the
+	// partitions of the pre-aggregations.
+	preAggregationUnknown = "unknown"
+)
+
+// PreAggregationStatus is a pre-aggregation of the data model and its build
+// status.
+type PreAggregationStatus struct {
+	// ID is "<cube>.<pre-aggregation name>".
+	ID         string          `json:"id"`
+	Cube       string          `json:"cube"`
+	Name       string          `json:"name"`
+	Type       string          `json:"type,omitempty"`
+	External   bool            `json:"external"`
+	RefreshKey json.RawMessage `json:"refreshKey,omitempty"`
+	// Status is "built" when every partition is built, "partial" when some
+	// are, "notBuilt" when none are and "unknown" when Cube did not report
+	// them.
+	Status          string `json:"status"`
+	Partitions      int    `json:"partitions"`
+	BuiltPartitions int    `json:"builtPartitions"`
+	// LastRefreshAt is when a partition was last built.
+	LastRefreshAt *time.Time `json:"lastRefreshAt,omitempty"`
+}
+
+// PreAggregationsResponse is the response of the pre-aggregations resource.
+type PreAggregationsResponse struct {
+	PreAggregations []PreAggregationStatus `json:"preAggregations"`
+}
+
+// cubePreAggregationsResponse is the response of Cube's
+// /cubejs-system/v1/pre-aggregations endpoint.
+type cubePreAggregationsResponse struct {
+	PreAggregations []struct {
+		ID             string `json:"id"`
+		Cube           string `json:"cube"`
+		Name           string `json:"preAggregationName"`
+		PreAggregation struct {
+			Type       string          `json:"type"`
+			External   bool            `json:"external"`
+			RefreshKey json.RawMessage `json:"refreshKey"`
+		} `json:"preAggregation"`
+	} `json:"preAggregations"`
+}
+
+// cubePreAggregationPartitionsResponse is the response of Cube's
+// /cubejs-system/v1/pre-aggregations/partitions endpoint. A partition is
+// built when it has version entries.
+type cubePreAggregationPartitionsResponse struct {
+	PreAggregationPartitions []struct {
+		PreAggregation struct {
+			ID string `json:"id"`
+		} `json:"preAggregation"`
+		Partitions []struct {
+			VersionEntries []struct {
+				// LastUpdatedAt is in milliseconds since the epoch.
+				LastUpdatedAt int64 `json:"last_updated_at"`
+			} `json:"versionEntries"`
+		} `json:"partitions"`
+	} `json:"preAggregationPartitions"`
+}
+
+// handlePreAggregations lists the pre-aggregations of the data model with
+// their build status, from Cube's system API. The pre-aggregations are
+// listed even when Cube does not report their partitions, with status
+// "unknown".
+func (d *Datasource) handlePreAggregations(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	body, err := d.fetchCubeSystemAPI(ctx, req.PluginContext, "pre-aggregations", nil)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to fetch pre-aggregations from Cube", "error", err)
+		return sender.Send(cubeLoadErrorResponse(err))
+	}
+	var list cubePreAggregationsResponse
+	if err := json.Unmarshal(body, &list); err != nil {
+		return sender.Send(jsonErrorResponse(502, fmt.Errorf("failed to parse API response: %w", err)))
+	}
+
+	response := PreAggregationsResponse{PreAggregations: make([]PreAggregationStatus, 0, len(list.PreAggregations))}
+	ids := make([]map[string]string, 0, len(list.PreAggregations))
+	for _, p := range list.PreAggregations {
+		response.PreAggregations = append(response.PreAggregations, PreAggregationStatus{
+			ID:         p.ID,
+			Cube:       p.Cube,
+			Name:       p.Name,
+			Type:       p.PreAggregation.Type,
+			External:   p.PreAggregation.External,
+			RefreshKey: p.PreAggregation.RefreshKey,
+			Status:     preAggregationUnknown,
+		})
+		ids = append(ids, map[string]string{"id": p.ID})
+	}
+
+	if len(ids) > 0 {
+		partitionsQuery, err := json.Marshal(map[string]interface{}{"query": map[string]interface{}{"preAggregations": ids}})
+		if err != nil {
+			return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal partitions query")))
+		}
+		body, err := d.fetchCubeSystemAPI(ctx, req.PluginContext, "pre-aggregations/partitions", partitionsQuery)
+		var partitions cubePreAggregationPartitionsResponse
+		if err == nil {
+			err = json.Unmarshal(body, &partitions)
+		}
+		if err != nil {
+			backend.Logger.FromContext(ctx).Warn("Failed to fetch pre-aggregation partitions from Cube", "error", err)
+		} else {
+			applyPartitionStatus(response.PreAggregations, partitions)
+		}
+	}
+
+	responseBody, err := json.Marshal(response)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal pre-aggregations response", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   responseBody,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
+
+// applyPartitionStatus sets the build status of the pre-aggregations from
+// their partitions.
+func applyPartitionStatus(statuses []PreAggregationStatus, partitions cubePreAggregationPartitionsResponse) {
+	for _, p := range partitions.PreAggregationPartitions {
+		i := slices.IndexFunc(statuses, func(s PreAggregationStatus) bool { return s.ID == p.PreAggregation.ID })
+		if i < 0 {
+			continue
+		}
+		status := &statuses[i]
+		status.Partitions = len(p.Partitions)
+		status.BuiltPartitions = 0
+		for _, partition := range p.Partitions {
+			if len(partition.VersionEntries) == 0 {
+				continue
+			}
+			status.BuiltPartitions++
+			for _, entry := range partition.VersionEntries {
+				updated := time.UnixMilli(entry.LastUpdatedAt).UTC()
+				if status.LastRefreshAt == nil || updated.After(*status.LastRefreshAt) {
+					status.LastRefreshAt = &updated
+				}
+			}
+		}
+		switch {
+		case status.BuiltPartitions == 0:
+			status.Status = preAggregationNotBuilt
+		case status.BuiltPartitions < status.Partitions:
+			status.Status = preAggregationPartial
+		default:
+			status.Status = preAggregationBuilt
+		}
+	}
+}
+
+// fetchCubeSystemAPI calls an endpoint of Cube's system API
+// (/cubejs-system/v1/<endpoint>): a GET, or a POST of payload when it is not
+// nil.
+func (d *Datasource) fetchCubeSystemAPI(ctx context.Context, pluginContext backend.PluginContext, endpoint string, payload []byte) ([]byte, error) {
+	// Build base URL and load configuration
+	apiReq, err := d.buildAPIURL(pluginContext, "")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, apiReq.Config.MetaTimeoutDuration())
+	defer cancel()
+
+	// Get base URL with test override support
+	baseURL := d.baseURL(apiReq.Config)
+	systemURL := strings.TrimRight(baseURL, "/") + "/cubejs-system/v1/" + endpoint
+
+	method := http.MethodGet
+	var body io.Reader
+	if payload != nil {
+		method = http.MethodPost
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, systemURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := d.addAuthHeaders(req, apiReq.Config); err != nil {
+		return nil, fmt.Errorf("failed to add auth headers: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.doHTTP(req, apiReq.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make API request: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			backend.Logger.FromContext(ctx).Warn("Failed to close response body", "error", err)
+		}
+	}()
+
+	return readJSONResponse(resp)
+}
@@ -0,0 +1,190 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHandlePreAggregationPreview(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     PreAggregationPreview
+	}{
+		{
+			name: "served from a pre-aggregation",
+			response: `{"sql": {"sql": ["SELECT sum(count) FROM prod_pre_aggregations.orders_main", []], "external": true, "dataSource": "default",
+				"preAggregations": [{"preAggregationId": "orders.main", "tableName": "prod_pre_aggregations.orders_main", "type": "rollup"}]}}`,
+			want: PreAggregationPreview{
+				UsesPreAggregation: true,
+				PreAggregations:    []PreAggregationUsage{{ID: "orders.main", TableName: "prod_pre_aggregations.orders_main", Type: "rollup", External: true}},
+				DataSource:         "default",
+				SQL:                "SELECT sum(count) FROM prod_pre_aggregations.orders_main",
+			},
+		},
+		{
+			name:     "hits the warehouse",
+			response: `{"sql": {"sql": ["SELECT count(*) FROM orders", []], "external": false, "preAggregations": []}}`,
+			want:     PreAggregationPreview{PreAggregations: []PreAggregationUsage{}, SQL: "SELECT count(*) FROM orders"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/cubejs-api/v1/sql" {
+					t.Errorf("expected path /cubejs-api/v1/sql, got %s", r.URL.Path)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			resp := callHandler(t, (&Datasource{}).handlePreAggregationPreview, &backend.CallResourceRequest{
+				PluginContext: newTestPluginContext(server.URL),
+				URL:           "pre-aggregations/preview?query=" + url.QueryEscape(`{"measures":["orders.count"]}`),
+			})
+			if resp.Status != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+			}
+			want, _ := json.Marshal(tt.want)
+			if string(resp.Body) != string(want) {
+				t.Errorf("expected %s, got %s", want, resp.Body)
+			}
+		})
+	}
+}
+
+func TestHandlePreAggregationPreviewForwardsCubeErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": "'orders.nope' not found"}`))
+	}))
+	defer server.Close()
+
+	resp := callHandler(t, (&Datasource{}).handlePreAggregationPreview, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "pre-aggregations/preview?query=" + url.QueryEscape(`{"measures":["orders.nope"]}`),
+	})
+	if resp.Status != http.StatusBadRequest || string(resp.Body) != `{"error": "'orders.nope' not found"}` {
+		t.Errorf("expected Cube's error to be forwarded, got %d: %s", resp.Status, resp.Body)
+	}
+}
+
+func TestHandlePreAggregations(t *testing.T) {
+	var gotPartitionsQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/cubejs-system/v1/pre-aggregations":
+			_, _ = w.Write([]byte(`{"preAggregations": [
+				{"id": "orders.main", "cube": "orders", "preAggregationName": "main", "preAggregation": {"type": "rollup", "external": true, "refreshKey": {"every": "1 hour"}}},
+				{"id": "orders.daily", "cube": "orders", "preAggregationName": "daily", "preAggregation": {"type": "rollup"}},
+				{"id": "users.all", "cube": "users", "preAggregationName": "all", "preAggregation": {"type": "originalSql"}}
+			]}`))
+		case "/cubejs-system/v1/pre-aggregations/partitions":
+			if r.Method != http.MethodPost {
+				t.Errorf("expected POST, got %s", r.Method)
+			}
+			body, _ := io.ReadAll(r.Body)
+			gotPartitionsQuery = string(body)
+			_, _ = w.Write([]byte(`{"preAggregationPartitions": [
+				{"preAggregation": {"id": "orders.main"}, "partitions": [
+					{"versionEntries": [{"last_updated_at": 1704067200000}]},
+					{"versionEntries": [{"last_updated_at": 1704070800000}]}]},
+				{"preAggregation": {"id": "orders.daily"}, "partitions": [
+					{"versionEntries": [{"last_updated_at": 1704067200000}]},
+					{"versionEntries": []}]},
+				{"preAggregation": {"id": "users.all"}, "partitions": [{"versionEntries": []}]}
+			]}`))
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	resp := callHandler(t, (&Datasource{}).handlePreAggregations, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "pre-aggregations",
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+	if gotPartitionsQuery != `{"query":{"preAggregations":[{"id":"orders.main"},{"id":"orders.daily"},{"id":"users.all"}]}}` {
+		t.Errorf("unexpected partitions query: %s", gotPartitionsQuery)
+	}
+
+	var result PreAggregationsResponse
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(result.PreAggregations) != 3 {
+		t.Fatalf("expected 3 pre-aggregations, got %d", len(result.PreAggregations))
+	}
+	main, daily, all := result.PreAggregations[0], result.PreAggregations[1], result.PreAggregations[2]
+	if main.Status != "built" || main.BuiltPartitions != 2 || !main.External || string(main.RefreshKey) != `{"every":"1 hour"}` {
+		t.Errorf("unexpected orders.main status: %+v", main)
+	}
+	if main.LastRefreshAt == nil || !main.LastRefreshAt.Equal(time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the latest partition build time, got %v", main.LastRefreshAt)
+	}
+	if daily.Status != "partial" || daily.Partitions != 2 || daily.BuiltPartitions != 1 {
+		t.Errorf("unexpected orders.daily status: %+v", daily)
+	}
+	if all.Status != "notBuilt" || all.LastRefreshAt != nil {
+		t.Errorf("unexpected users.all status: %+v", all)
+	}
+}
+
+func TestHandlePreAggregationsWithoutPartitions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/cubejs-system/v1/pre-aggregations" {
+			_, _ = w.Write([]byte(`{"preAggregations": [{"id": "orders.main", "cube": "orders", "preAggregationName": "main", "preAggregation": {"type": "rollup"}}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error": "Cube Store is unavailable"}`))
+	}))
+	defer server.Close()
+
+	resp := callHandler(t, (&Datasource{}).handlePreAggregations, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "pre-aggregations",
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+	var result PreAggregationsResponse
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(result.PreAggregations) != 1 || result.PreAggregations[0].Status != "unknown" {
+		t.Errorf("expected the pre-aggregation with an unknown status, got %+v", result.PreAggregations)
+	}
+}
+
+func TestHandlePreAggregationsForwardsCubeErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error": "Permission denied"}`))
+	}))
+	defer server.Close()
+
+	resp := callHandler(t, (&Datasource{}).handlePreAggregations, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "pre-aggregations",
+	})
+	if resp.Status != http.StatusForbidden {
+		t.Errorf("expected Cube's 403 to be forwarded, got %d: %s", resp.Status, resp.Body)
+	}
+}
@@ -8,9 +8,12 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/grafana/cube/pkg/models"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
-	"github.com/grafana/grafana-plugin-sdk-go/data/framestruct"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // CubeQuery represents the structure of a Cube query
@@ -22,6 +25,98 @@ type CubeQuery struct {
 	Filters        []interface{} `json:"filters,omitempty"`
 	Order          interface{}   `json:"order,omitempty"`
 	Limit          *int          `json:"limit,omitempty"`
+	// Normalize rescales measures per series after the response is converted:
+	// "index100" rebases to 100, "minmax" rescales into [0, 1]. Backend-only;
+	// never sent to Cube.
+	Normalize string `json:"normalize,omitempty"`
+	// TypeOverrides forces the conversion type ("string", "number", "time",
+	// "boolean") for specific members, for when the Cube model's annotated
+	// type is wrong. Backend-only; never sent to Cube.
+	TypeOverrides map[string]string `json:"typeOverrides,omitempty"`
+	// InstantTime adds a "time" field set to the end of the dashboard time
+	// range to measures-only results, so stat panels and instant alert queries
+	// get a timestamped single row. Backend-only; never sent to Cube.
+	InstantTime bool `json:"instantTime,omitempty"`
+	// UnitConversion scales numeric members from the unit Cube stores them in
+	// to the unit dashboards should display, e.g.
+	// {"orders.duration_ms": {"from": "ms", "to": "s"}}. Backend-only.
+	UnitConversion map[string]UnitConversion `json:"unitConversion,omitempty"`
+	// IgnoreDefaultFilters opts the query out of the datasource's
+	// defaultFilters. Backend-only.
+	IgnoreDefaultFilters bool `json:"ignoreDefaultFilters,omitempty"`
+	// Format is the result shape the panel expects: "time_series" or
+	// "table". Used to decide whether autoTimeDimension applies.
+	// Backend-only.
+	Format string `json:"format,omitempty"`
+	// ContinueWaitTimeoutSeconds and PollIntervalSeconds override the
+	// datasource's continueWaitMaxDuration and continueWaitPollInterval for
+	// this query, in seconds, e.g. so alert queries fail fast while
+	// exploratory panels wait minutes. Backend-only.
+	ContinueWaitTimeoutSeconds *int `json:"continueWaitTimeoutSeconds,omitempty"`
+	PollIntervalSeconds        *int `json:"pollIntervalSeconds,omitempty"`
+	// QueryType reshapes the result into Grafana's numeric data types for
+	// alert rules: "instant" returns a numeric wide frame with one value per
+	// series, "range" a time series wide frame. String dimensions become
+	// labels. Empty keeps the long frame. "model" returns the data model's
+	// join graph as node graph frames instead; see modelQuery. Backend-only.
+	QueryType string `json:"queryType,omitempty"`
+	// RawQuery is a full Cube query as JSON text, from the raw JSON query
+	// mode. When set it replaces the builder fields above and is validated
+	// with a dry run before it runs.
+	RawQuery string `json:"rawQuery,omitempty"`
+	// Fill adds the time buckets missing from each series when the query has
+	// a time dimension with a granularity, with measures "zero" or "null".
+	// MeasureFill overrides it per measure. Backend-only.
+	Fill        string            `json:"fill,omitempty"`
+	MeasureFill map[string]string `json:"measureFill,omitempty"`
+	// BooleansAsNumbers returns boolean members as 1/0 numbers instead of
+	// bool fields, for panels such as stat that only display numbers.
+	// Backend-only.
+	BooleansAsNumbers bool `json:"booleansAsNumbers,omitempty"`
+	// Blend lists queries blended with this one (Cube's blending query
+	// type): each keeps its own measures and filters and shares this
+	// query's time dimension, granularity and date range. The results are
+	// merged on time into one frame, with the series of each query labeled
+	// by its name, for comparison panels. Backend-only.
+	Blend []BlendedQuery `json:"blend,omitempty"`
+	// Join joins the result of this query to the result of another query of
+	// the panel on shared dimensions, returning one merged frame for both,
+	// e.g. to combine measures of views Cube cannot join. Backend-only.
+	Join *QueryJoin `json:"join,omitempty"`
+	// FiscalLabels adds a fiscalPeriod field labeling each bucket of the
+	// query's quarter or year time dimension with its fiscal period, e.g.
+	// "FY25 Q2", and widens its date range to whole fiscal periods; see the
+	// datasource's fiscalYearStartMonth. Backend-only.
+	FiscalLabels bool `json:"fiscalLabels,omitempty"`
+	// SchemaVersion is the version of the query model the query was saved
+	// with; see ConvertQueryDataRequest. Backend-only.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+}
+
+// continueWaitConfig returns config with the Continue-wait overrides of the
+// queries applied. Batched queries share one request, so when several
+// queries override a setting the smallest value wins.
+func continueWaitConfig(config *models.PluginSettings, queries ...CubeQuery) *models.PluginSettings {
+	var pollInterval, maxDuration *int
+	for _, q := range queries {
+		if q.PollIntervalSeconds != nil && (pollInterval == nil || *q.PollIntervalSeconds < *pollInterval) {
+			pollInterval = q.PollIntervalSeconds
+		}
+		if q.ContinueWaitTimeoutSeconds != nil && (maxDuration == nil || *q.ContinueWaitTimeoutSeconds < *maxDuration) {
+			maxDuration = q.ContinueWaitTimeoutSeconds
+		}
+	}
+	if pollInterval == nil && maxDuration == nil {
+		return config
+	}
+	overridden := *config
+	if pollInterval != nil {
+		overridden.ContinueWaitPollInterval = pollInterval
+	}
+	if maxDuration != nil {
+		overridden.ContinueWaitMaxDuration = maxDuration
+	}
+	return &overridden
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -29,45 +124,132 @@ type CubeQuery struct {
 // The QueryDataResponse contains a map of RefID to the response for each query, and each response
 // contains Frames ([]*Frame).
 func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "cube QueryData", trace.WithAttributes(attribute.Int("cube.queries", len(req.Queries))))
+	defer span.End()
+	defer d.trackRequest()()
+
+	// The panel's queries share one correlation ID: batched, they are sent to
+	// Cube in a single request.
+	ctx = withCorrelationID(ctx)
+
 	// create response struct
 	response := backend.NewQueryDataResponse()
 
-	// loop over queries and execute them individually.
-	for _, q := range req.Queries {
-		res := d.query(ctx, req.PluginContext, q)
-
-		// save the response in a hashmap
-		// based on with RefID as identifier
-		response.Responses[q.RefID] = res
+	// Several queries from the same panel are combined into one Cube
+	// multi-query request to save round trips.
+	switch {
+	case len(req.Queries) > 1:
+		for refID, res := range d.queryBatch(ctx, req.PluginContext, req.Queries) {
+			response.Responses[refID] = res
+		}
+	case len(req.Queries) == 1:
+		// A single query has nothing to batch with and is run on its own.
+		q := req.Queries[0]
+		response.Responses[q.RefID] = d.query(ctx, req.PluginContext, q)
 	}
 
+	joinResponses(req.Queries, response.Responses)
+	for refID, res := range response.Responses {
+		response.Responses[refID] = withCorrelationIDError(ctx, res)
+	}
 	return response, nil
 }
 
+// preparedQuery is a panel query that has been parsed and validated and is
+// ready to be sent to Cube.
+type preparedQuery struct {
+	refID string
+	query CubeQuery
+	// timeRange is the dashboard time range the query was issued with.
+	timeRange backend.TimeRange
+	// apiQuery is the Cube /v1/load query JSON (only the Cube-specific fields).
+	apiQuery map[string]interface{}
+	// measureFilters are the measures the query filters on (HAVING), queried
+	// or not.
+	measureFilters []string
+	// timeLayouts are the datasource's extra timestamp formats.
+	timeLayouts []string
+	// blended are the queries blended with this one; see CubeQuery.Blend.
+	blended []*preparedQuery
+	// fiscalYearStart is the month fiscal labels count fiscal years from.
+	fiscalYearStart time.Month
+}
+
 func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
-	var response backend.DataResponse
+	if variable, ok := parseMetadataVariableQuery(query.JSON); ok {
+		return d.variableQuery(ctx, pCtx, variable)
+	}
+	if isModelQuery(query) {
+		return d.modelQuery(ctx, pCtx)
+	}
+	prepared, errResponse := d.prepareQuery(ctx, pCtx, query)
+	if prepared == nil {
+		return errResponse
+	}
+	return d.executeQuery(ctx, pCtx, prepared)
+}
 
+// prepareQuery parses and validates a panel query. When the query is invalid it
+// returns a nil preparedQuery and the error response to send back for it.
+func (d *Datasource) prepareQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) (*preparedQuery, backend.DataResponse) {
 	// Ensure query JSON is provided
 	if len(query.JSON) == 0 {
-		return backend.ErrDataResponse(backend.StatusBadRequest, "Query JSON is required")
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, "Query JSON is required")
 	}
 
 	// Debug: Log the raw JSON to see what we're actually trying to unmarshal
-	backend.Logger.Debug("Raw query JSON", "rawJSON", string(query.JSON))
+	backend.Logger.FromContext(ctx).Debug("Raw query JSON", "rawJSON", string(query.JSON))
 
 	// Parse the query JSON into CubeQuery struct
 	var cubeQuery CubeQuery
 	if err := json.Unmarshal(query.JSON, &cubeQuery); err != nil {
-		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Invalid query JSON: %v", err))
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Invalid query JSON: %v", err))
+	}
+	order, err := builderOrder(query.JSON)
+	if err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	cubeQuery.Order = order
+
+	if err := validateNormalize(cubeQuery.Normalize); err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateFill(cubeQuery); err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateTypeOverrides(cubeQuery.TypeOverrides); err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateUnitConversions(cubeQuery.UnitConversion); err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if cubeQuery.QueryType == "" {
+		cubeQuery.QueryType = query.QueryType
 	}
+	if err := validateQueryType(cubeQuery.QueryType); err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateJoin(cubeQuery); err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	var rawQuery *rawCubeQuery
+	if cubeQuery.RawQuery != "" {
+		var err error
+		if rawQuery, err = parseRawQuery(cubeQuery.RawQuery); err != nil {
+			return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		rawQuery.applyTo(&cubeQuery)
+	}
+
+	d.addAutoTimeDimension(ctx, pCtx, query, &cubeQuery)
 
-	backend.Logger.Debug("Parsed cube query", "measures", cubeQuery.Measures, "dimensions", cubeQuery.Dimensions, "timeDimensions", cubeQuery.TimeDimensions)
+	backend.Logger.FromContext(ctx).Debug("Parsed cube query", "measures", cubeQuery.Measures, "dimensions", cubeQuery.Dimensions, "timeDimensions", cubeQuery.TimeDimensions)
 
 	// Additional debugging: If arrays are empty, let's see the full JSON structure
 	if len(cubeQuery.Measures) == 0 && len(cubeQuery.Dimensions) == 0 {
 		var genericJSON map[string]interface{}
 		if err := json.Unmarshal(query.JSON, &genericJSON); err == nil {
-			backend.Logger.Debug("Full JSON structure", "structure", genericJSON)
+			backend.Logger.FromContext(ctx).Debug("Full JSON structure", "structure", genericJSON)
 		}
 	}
 
@@ -82,17 +264,119 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 	if len(cubeQuery.TimeDimensions) > 0 {
 		cubeAPIQuery["timeDimensions"] = cubeQuery.TimeDimensions
 	}
-	if len(cubeQuery.Filters) > 0 {
-		cubeAPIQuery["filters"] = cubeQuery.Filters
+	filters := cubeQuery.Filters
+	if !cubeQuery.IgnoreDefaultFilters {
+		filters = withDefaultFilters(filters, d.defaultFilters(pCtx))
+	}
+	// AdHoc filters on segment keys select segments
+	filters, segments, err := extractSegmentFilters(filters)
+	if err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	measureFilters, err := d.checkFilters(ctx, pCtx, filters)
+	if err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if len(filters) > 0 {
+		cubeAPIQuery["filters"] = filters
+	}
+	if len(segments) > 0 {
+		cubeAPIQuery["segments"] = segments
 	}
 	if cubeQuery.Order != nil {
 		cubeAPIQuery["order"] = cubeQuery.Order
 	}
+	config := d.pluginSettings(pCtx)
+	if err := checkAllowedMembers(config, append(queryMembers(cubeQuery), segments...)); err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusForbidden, err.Error())
+	}
+	cubeQuery.Limit = config.RowLimit(cubeQuery.Limit)
 	if cubeQuery.Limit != nil {
 		cubeAPIQuery["limit"] = cubeQuery.Limit
 	}
+	if rawQuery != nil {
+		if err := checkAllowedMembers(config, rawQuery.Segments); err != nil {
+			return nil, backend.ErrDataResponse(backend.StatusForbidden, err.Error())
+		}
+		rawQuery.addOptions(cubeAPIQuery)
+	}
+	if err := applyFiscalPeriods(cubeAPIQuery, cubeQuery, config, time.Now()); err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := rewriteQuery(cubeAPIQuery, pCtx, config, time.Now()); err != nil {
+		return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if rawQuery != nil {
+		if err := d.dryRunQuery(ctx, pCtx, cubeAPIQuery); err != nil {
+			return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+	}
+	if config.DimensionCardinalityLimit() > 0 {
+		apiReq, err := d.buildAPIURL(pCtx, "load")
+		if err != nil {
+			return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		if err := d.checkCardinality(ctx, pCtx, apiReq, cubeQuery.Dimensions); err != nil {
+			return nil, loadErrorResponse(err)
+		}
+	}
+
+	prepared := &preparedQuery{
+		refID:     query.RefID,
+		query:     cubeQuery,
+		timeRange: query.TimeRange,
+		apiQuery:  cubeAPIQuery,
 
-	cubeAPIQueryJSON, err := json.Marshal(cubeAPIQuery)
+		measureFilters: measureFilters,
+		timeLayouts:    config.CustomTimeLayouts(),
+	}
+	if cubeQuery.FiscalLabels {
+		// Validated by applyFiscalPeriods.
+		prepared.fiscalYearStart, _ = config.FiscalYearStart()
+	}
+	if len(cubeQuery.Blend) > 0 {
+		if err := validateBlend(cubeQuery, cubeAPIQuery); err != nil {
+			return nil, backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		var errResponse backend.DataResponse
+		if prepared.blended, errResponse = d.prepareBlend(ctx, pCtx, query, cubeQuery); prepared.blended == nil {
+			return nil, errResponse
+		}
+	}
+	return prepared, backend.DataResponse{}
+}
+
+// builderOrder reads the order of a panel query in Cube's array form. Cube
+// sorts by an object order's keys in the order they are written, which
+// decoding into a map would lose, so the object is read key by key.
+func builderOrder(queryJSON []byte) (interface{}, error) {
+	var q struct {
+		Order json.RawMessage `json:"order"`
+	}
+	if err := json.Unmarshal(queryJSON, &q); err != nil {
+		return nil, fmt.Errorf("Invalid query JSON: %v", err)
+	}
+	pairs, err := orderPairs(q.Order)
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	order := make([]interface{}, 0, len(pairs))
+	for _, pair := range pairs {
+		order = append(order, []interface{}{pair[0], pair[1]})
+	}
+	return order, nil
+}
+
+// executeQuery sends a single prepared query to Cube's /v1/load endpoint and
+// converts the result into a data frame.
+func (d *Datasource) executeQuery(ctx context.Context, pCtx backend.PluginContext, prepared *preparedQuery) backend.DataResponse {
+	if len(prepared.blended) > 0 {
+		return d.executeBlend(ctx, pCtx, prepared)
+	}
+	cubeAPIQueryJSON, err := json.Marshal(prepared.apiQuery)
 	if err != nil {
 		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Failed to marshal Cube query: %v", err))
 	}
@@ -102,43 +386,123 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, quer
 	if err != nil {
 		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
 	}
+	apiReq.Config = continueWaitConfig(apiReq.Config, prepared.query)
+	if err := validateQueryTransport(apiReq.Config); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+
+	cacheKey := resultCacheKey(cubeAPIQueryJSON, prepared.timeRange, apiReq.Config)
+	if cached, ok := d.cachedResult(cacheKey, apiReq.Config); ok {
+		backend.Logger.FromContext(ctx).Debug("Serving Cube query from the result cache", "cubeQuery", string(cubeAPIQueryJSON))
+		return d.respond(ctx, pCtx, prepared, cached)
+	}
 
+	// Identical queries running at the same time share one request.
+	apiResponse, err := d.inflight.do(ctx, cacheKey, func(ctx context.Context) (CubeAPIResponse, error) {
+		if usesSQLAPI(apiReq.Config) {
+			return d.loadSQLAPIResult(ctx, apiReq.Config, cubeAPIQueryJSON, prepared.query.Measures, prepared.measureFilters, cacheKey)
+		}
+		if usesGraphQL(apiReq.Config) {
+			return d.loadGraphQLResult(ctx, apiReq, cubeAPIQueryJSON, cacheKey)
+		}
+		return d.loadQueryResult(ctx, apiReq, cubeAPIQueryJSON, cacheKey)
+	})
+	if err != nil {
+		return loadErrorResponse(err)
+	}
+
+	return d.respond(ctx, pCtx, prepared, apiResponse)
+}
+
+// respond converts a query result into the panel's response, with colors,
+// deprecation warnings, Cube's response metadata and refresh hints attached.
+func (d *Datasource) respond(ctx context.Context, pCtx backend.PluginContext, prepared *preparedQuery, result CubeAPIResponse) backend.DataResponse {
+	response := d.addColors(ctx, pCtx, prepared, d.buildDataResponse(prepared, result))
+	response = d.addDeprecationNotices(ctx, pCtx, prepared, response)
+	response = addResponseMeta(result, response)
+	return d.addRefreshHint(ctx, pCtx, result, response)
+}
+
+// loadQueryResult sends a query to Cube's /v1/load endpoint and decodes the
+// result, caching it when the result cache is enabled.
+func (d *Datasource) loadQueryResult(ctx context.Context, apiReq *APIRequestContext, cubeAPIQueryJSON []byte, cacheKey string) (CubeAPIResponse, error) {
 	// Debug: Log what we're sending to the API
-	backend.Logger.Debug("Making API request", "url", apiReq.URL.String(), "cubeQuery", string(cubeAPIQueryJSON))
+	backend.Logger.FromContext(ctx).Debug("Making API request", "url", apiReq.URL.String(), "cubeQuery", string(cubeAPIQueryJSON))
 
 	// Use shared helper to make the request with "Continue wait" polling.
 	// The helper picks GET or POST based on the encoded query size.
-	body, err := d.doCubeLoadRequest(ctx, apiReq.URL.String(), cubeAPIQueryJSON, apiReq.Config)
+	start := time.Now()
+	loadCtx, polls := countContinueWaits(ctx)
+	body, err := d.doCubeLoadRequest(loadCtx, apiReq.URL.String(), cubeAPIQueryJSON, apiReq.Config)
 	if err != nil {
-		backend.Logger.Error("Failed to fetch data from Cube API", "error", err, "url", apiReq.URL.String())
-		return loadErrorResponse(err)
+		observeLoadRequest(time.Since(start), *polls, err)
+		logSlowQuery(ctx, apiReq.Config, cubeAPIQueryJSON, time.Since(start), *polls, 0, err)
+		backend.Logger.FromContext(ctx).Error("Failed to fetch data from Cube API", "error", err, "url", apiReq.URL.String())
+		return CubeAPIResponse{}, err
 	}
 
 	// Parse the API response
-	var apiResponse CubeAPIResponse
-	err = json.Unmarshal(body, &apiResponse)
+	apiResponse, err := decodeLoadResult(body)
 	if err != nil {
-		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Failed to parse API response: %v", err))
+		var reqErr *loadRequestError
+		if errors.As(err, &reqErr) {
+			// Cube reported an error in an HTTP 200 response.
+			observeLoadRequest(time.Since(start), *polls, err)
+			cubeErrorsTotal.WithLabelValues("200").Inc()
+			return CubeAPIResponse{}, err
+		}
+		return CubeAPIResponse{}, &loadRequestError{status: backend.StatusBadRequest, msg: fmt.Sprintf("Failed to parse API response: %v", err)}
 	}
+	observeLoadRequest(time.Since(start), *polls, nil)
+	logSlowQuery(ctx, apiReq.Config, cubeAPIQueryJSON, time.Since(start), *polls, len(apiResponse.Data), nil)
+	d.cacheResult(cacheKey, apiResponse, apiReq.Config)
+	return apiResponse, nil
+}
 
-	// Convert string values to numbers based on type annotations
-	convertedData := d.convertDataTypes(apiResponse.Data, apiResponse.Annotation)
+// buildDataResponse converts a Cube /v1/load result into the data frame
+// returned for a query.
+func (d *Datasource) buildDataResponse(prepared *preparedQuery, apiResponse CubeAPIResponse) backend.DataResponse {
+	var response backend.DataResponse
+	cubeQuery := prepared.query
 
-	// Create DataFrame using framestruct utility
-	frame, err := framestruct.ToDataFrame("response", convertedData)
-	if err != nil {
-		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Failed to convert data to frame: %v", err))
-	}
+	// Apply per-query type overrides on top of Cube's annotation so every
+	// conversion step below sees the corrected types
+	annotation := applyTypeOverrides(apiResponse.Annotation, cubeQuery.TypeOverrides)
+	rows := coerceOverriddenStrings(apiResponse.Data, cubeQuery.TypeOverrides)
+
+	// Build typed fields in query order (dimensions first, then measures),
+	// converting numeric strings and timestamps according to the annotation.
+	// Columns Cube omitted (all values null) become null fields.
+	frame := d.buildFrame("response", rows, cubeQuery, annotation, prepared.timeLayouts)
 
-	// Reorder fields according to query specification (dimensions first, then measures)
-	// Also adds missing fields (e.g., columns with all null values) as nullable fields
-	frame = d.reorderFrameFields(frame, cubeQuery, apiResponse.Annotation, len(apiResponse.Data))
+	// Add the time buckets Cube returned no rows for when the query asks
+	frame = fillTimeBuckets(frame, cubeQuery, prepared.apiQuery, prepared.timeRange)
+
+	// Label the buckets with their fiscal period when the query asks
+	if cubeQuery.FiscalLabels {
+		frame = addFiscalLabels(frame, prepared.apiQuery, prepared.timeRange, prepared.fiscalYearStart)
+	}
 
 	// Mark dimension fields as filterable to enable AdHoc filter buttons
 	d.markFieldsAsFilterable(frame, cubeQuery)
 
-	// Convert time dimension strings to proper time.Time values for better UI display
-	d.convertTimeDimensions(frame, apiResponse.Annotation)
+	// Scale members from their storage unit to the requested display unit
+	d.convertUnits(frame, cubeQuery.UnitConversion)
+
+	// Rescale measures per series when the query asks for it
+	d.normalizeMeasures(frame, cubeQuery, cubeQuery.Normalize)
+
+	// Alerting query types reshape the frame into numeric wide frames;
+	// otherwise measures-only queries become a single-row stats frame
+	if cubeQuery.QueryType != "" {
+		wide, err := alertingFrame(frame, cubeQuery)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		frame = wide
+	} else if isMeasuresOnly(cubeQuery) {
+		frame = d.instantFrame(frame, cubeQuery, prepared.timeRange)
+	}
 
 	// add the frames to the response.
 	response.Frames = append(response.Frames, frame)
@@ -168,11 +532,19 @@ func backendStatusFromHTTP(code int) backend.Status {
 func loadErrorResponse(err error) backend.DataResponse {
 	var cubeErr *CubeAPIError
 	if errors.As(err, &cubeErr) {
+		if nonJSON := cubeErr.nonJSON(); nonJSON != nil {
+			return backend.ErrDataResponse(backendStatusFromHTTP(cubeErr.StatusCode), nonJSON.Error())
+		}
 		return backend.ErrDataResponse(
 			backendStatusFromHTTP(cubeErr.StatusCode),
 			fmt.Sprintf("Cube API request failed with status %d: %s", cubeErr.StatusCode, string(cubeErr.Body)),
 		)
 	}
+	var nonJSONErr *nonJSONResponseError
+	if errors.As(err, &nonJSONErr) {
+		// A 200 that is not JSON came from something between us and Cube.
+		return backend.ErrDataResponse(backend.StatusBadGateway, nonJSONErr.Error())
+	}
 	var reqErr *loadRequestError
 	if errors.As(err, &reqErr) {
 		return backend.ErrDataResponse(reqErr.status, reqErr.msg)
@@ -182,51 +554,6 @@ func loadErrorResponse(err error) backend.DataResponse {
 	return backend.ErrDataResponse(backend.StatusInternal, err.Error())
 }
 
-// reorderFrameFields reorders the fields of a DataFrame according to the query specification.
-// It also adds missing fields (e.g., columns with all null values) as nullable fields.
-// The dataRowCount parameter is needed when all columns have null values (frame has no fields).
-func (d *Datasource) reorderFrameFields(frame *data.Frame, query CubeQuery, annotation CubeAnnotation, dataRowCount int) *data.Frame {
-	// Create a new frame with the reordered fields
-	newFrame := data.NewFrame(frame.Name)
-
-	// Create a map to track the new positions of the fields
-	fieldPositions := make(map[string]int)
-
-	// Populate the field positions map
-	for i, field := range frame.Fields {
-		fieldPositions[field.Name] = i
-	}
-
-	// Determine row count from existing frame (needed for creating null-filled fields)
-	// Fall back to dataRowCount when frame has no fields (all columns have null values)
-	rowCount := dataRowCount
-	if len(frame.Fields) > 0 {
-		rowCount = frame.Fields[0].Len()
-	}
-
-	// Reorder the fields according to the query specification
-	// If a field doesn't exist (all null values), create it as a nullable field
-	for _, fieldName := range query.Dimensions {
-		if pos, exists := fieldPositions[fieldName]; exists {
-			newFrame.Fields = append(newFrame.Fields, frame.Fields[pos])
-		} else {
-			// Field missing (all null values) - create a nullable field
-			newFrame.Fields = append(newFrame.Fields, d.createNullField(fieldName, rowCount, annotation))
-		}
-	}
-
-	for _, fieldName := range query.Measures {
-		if pos, exists := fieldPositions[fieldName]; exists {
-			newFrame.Fields = append(newFrame.Fields, frame.Fields[pos])
-		} else {
-			// Field missing (all null values) - create a nullable field
-			newFrame.Fields = append(newFrame.Fields, d.createNullField(fieldName, rowCount, annotation))
-		}
-	}
-
-	return newFrame
-}
-
 // createNullField creates a nullable field with nil values for columns that were omitted
 // from the Cube API response (because all values were null).
 func (d *Datasource) createNullField(fieldName string, rowCount int, annotation CubeAnnotation) *data.Field {
@@ -276,121 +603,6 @@ func (d *Datasource) markFieldsAsFilterable(frame *data.Frame, query CubeQuery)
 	}
 }
 
-// convertTimeDimensions converts time dimension string fields to proper time.Time values.
-// This enables proper time formatting and sorting in Grafana's UI.
-func (d *Datasource) convertTimeDimensions(frame *data.Frame, annotation CubeAnnotation) {
-	for i, field := range frame.Fields {
-		// Check if this is a time dimension field (from timeDimensions annotation)
-		if timeDimInfo, isTimeDim := annotation.TimeDimensions[field.Name]; isTimeDim {
-			if timeDimInfo.Type == "time" {
-				newField := d.convertTimeField(field)
-				if newField != nil {
-					frame.Fields[i] = newField
-				}
-				continue
-			}
-		}
-
-		// Also check regular dimensions that have type "time"
-		// (e.g., date fields used as regular dimensions without granularity)
-		if dimInfo, isDim := annotation.Dimensions[field.Name]; isDim {
-			if dimInfo.Type == "time" {
-				newField := d.convertTimeField(field)
-				if newField != nil {
-					frame.Fields[i] = newField
-				}
-			}
-		}
-	}
-}
-
-// convertTimeField converts string time values to proper time.Time values.
-// Returns nil if the field is not a string type (no conversion needed).
-func (d *Datasource) convertTimeField(field *data.Field) *data.Field {
-	if field.Type() != data.FieldTypeString && field.Type() != data.FieldTypeNullableString {
-		return nil // Already proper type or not convertible
-	}
-
-	// Create a new time vector with the same length
-	timeValues := make([]*time.Time, field.Len())
-
-	for i := 0; i < field.Len(); i++ {
-		val := field.At(i)
-		if val == nil {
-			continue
-		}
-
-		var timeStr string
-		switch v := val.(type) {
-		case string:
-			timeStr = v
-		case *string:
-			if v != nil {
-				timeStr = *v
-			}
-		default:
-			continue
-		}
-
-		if timeStr == "" {
-			continue
-		}
-
-		// Try parsing common time formats used by Cube
-		if t, err := time.Parse(time.RFC3339, timeStr); err == nil {
-			timeValues[i] = &t
-		} else if t, err := time.Parse("2006-01-02T15:04:05.000Z", timeStr); err == nil {
-			timeValues[i] = &t
-		} else if t, err := time.Parse("2006-01-02T15:04:05.000", timeStr); err == nil {
-			timeValues[i] = &t
-		} else if t, err := time.Parse("2006-01-02", timeStr); err == nil {
-			timeValues[i] = &t
-		}
-		// If parsing fails, keep as nil
-	}
-
-	// Create a new time field and copy values
-	newField := data.NewField(field.Name, field.Labels, timeValues)
-	newField.Config = field.Config
-
-	return newField
-}
-
-// convertDataTypes converts string values to numbers based on type annotations from Cube API
-func (d *Datasource) convertDataTypes(data []map[string]interface{}, annotation CubeAnnotation) []map[string]interface{} {
-	convertedData := make([]map[string]interface{}, len(data))
-
-	// Create a combined map of all field types for easy lookup
-	fieldTypes := make(map[string]string)
-	for fieldName, info := range annotation.Measures {
-		fieldTypes[fieldName] = info.Type
-	}
-	for fieldName, info := range annotation.Dimensions {
-		fieldTypes[fieldName] = info.Type
-	}
-	for fieldName, info := range annotation.Segments {
-		fieldTypes[fieldName] = info.Type
-	}
-	for fieldName, info := range annotation.TimeDimensions {
-		fieldTypes[fieldName] = info.Type
-	}
-
-	// Convert each row
-	for i, row := range data {
-		convertedRow := make(map[string]interface{})
-		for fieldName, value := range row {
-			if fieldTypes[fieldName] == "number" {
-				convertedRow[fieldName] = d.convertToNumber(value)
-			} else {
-				convertedRow[fieldName] = value
-			}
-		}
-		convertedData[i] = convertedRow
-	}
-
-	return convertedData
-}
-
 // convertToNumber attempts to convert a value to a number if it's a string representation of a number
 // Always return float64. Fields within Grafana DataFrame cannot have a mix of types
 func (d *Datasource) convertToNumber(value interface{}) interface{} {
@@ -8,16 +8,18 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/grafana/cube/pkg/models"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
 func TestQueryData(t *testing.T) {
 	// Create a mock server that returns empty data for an empty query
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 		// Parse the query parameter to validate it's empty
 		query := r.URL.Query().Get("query")
 		if query != "{}" {
@@ -68,7 +70,7 @@ func TestQueryData(t *testing.T) {
 
 func TestQueryDataWithCubeQuery(t *testing.T) {
 	// Create a mock server that returns expected test data
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 		// Parse the query parameter
 		query := r.URL.Query().Get("query")
 
@@ -211,7 +213,7 @@ func TestQueryDataMethodSelection(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 				if r.Method != tt.expectedMethod {
 					t.Errorf("Expected %s request, got %s", tt.expectedMethod, r.Method)
 				}
@@ -292,7 +294,7 @@ func TestQueryDataLargeQueryContinueWaitRepostsBody(t *testing.T) {
 	}
 
 	requestCount := 0
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 		requestCount++
 		if r.Method != "POST" {
 			t.Errorf("Expected POST request, got %s", r.Method)
@@ -358,7 +360,7 @@ func TestQueryDataContinueWaitThenSuccess(t *testing.T) {
 	// Cube returns {"error": "Continue wait"} (HTTP 200) when query results
 	// aren't cached yet. The plugin must poll until data is ready.
 	requestCount := 0
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 		requestCount++
 		w.Header().Set("Content-Type", "application/json")
 
@@ -423,7 +425,7 @@ func TestQueryDataContinueWaitThenSuccess(t *testing.T) {
 func TestQueryDataContinueWaitContextCancelled(t *testing.T) {
 	// If the context is cancelled while polling, the plugin should return an error
 	// rather than hanging forever.
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]interface{}{
 			"error": "Continue wait",
@@ -464,7 +466,7 @@ func TestQueryDataHTTPTimeoutWrapped(t *testing.T) {
 	// When an HTTP request to Cube times out (context deadline exceeded), the
 	// error message should be wrapped with helpful context rather than showing
 	// a raw Go error like "context deadline exceeded".
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 		// Simulate a slow response that will exceed the context deadline
 		time.Sleep(200 * time.Millisecond)
 		w.Header().Set("Content-Type", "application/json")
@@ -513,7 +515,7 @@ func TestQueryDataContinueWaitCancelledIncludesElapsedTime(t *testing.T) {
 	// When the context is cancelled during "Continue wait" polling, the error
 	// should include the timeElapsed from the last Cube response so users know
 	// how long the upstream warehouse had been computing.
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		_, _ = fmt.Fprintln(w, `{"error": "Continue wait", "stage": "Executing query", "timeElapsed": 25}`)
 	}))
@@ -577,7 +579,7 @@ func TestQueryDataForwardsUpstreamStatus(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(tc.httpStatus)
 				_, _ = w.Write([]byte(tc.body))
@@ -617,7 +619,7 @@ func TestQueryDataForwardsUpstreamStatus(t *testing.T) {
 
 func TestQueryDataWithMultipleDimensions(t *testing.T) {
 	// Create a mock server that returns expected test data with multiple dimensions
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 		// Parse the query parameter
 		query := r.URL.Query().Get("query")
 
@@ -755,7 +757,7 @@ func TestQueryDataWithAllNullColumn(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 				// Return mock Cube API response where one dimension has all null values
 				// When Cube returns all nulls for a column, the key is omitted from the data rows
 				response := CubeAPIResponse{
@@ -834,7 +836,7 @@ func TestQueryDataWithAllNullColumn(t *testing.T) {
 func TestQueryDataWithAllColumnsNull(t *testing.T) {
 	// Test edge case: when ALL columns have all null values, the Cube API returns
 	// empty objects like [{}, {}]. The frame should still have the correct row count.
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 		// Return mock Cube API response where ALL columns have null values
 		// When all values are null, Cube returns empty objects for each row
 		response := CubeAPIResponse{
@@ -908,7 +910,7 @@ func TestCreateNullFieldWithTimeDimension(t *testing.T) {
 }
 
 func TestQueryDataWithOrderField(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 		query := r.URL.Query().Get("query")
 		var cubeQuery CubeQuery
 		if err := json.Unmarshal([]byte(query), &cubeQuery); err != nil {
@@ -924,23 +926,14 @@ func TestQueryDataWithOrderField(t *testing.T) {
 			t.Errorf("Expected measures [orders.count], got %v", cubeQuery.Measures)
 		}
 
-		orderMap, ok := cubeQuery.Order.(map[string]interface{})
-		if !ok {
-			t.Errorf("Expected order field as object, got %T", cubeQuery.Order)
+		// An object order is sent as pairs in the order it was written, not
+		// re-marshaled with sorted keys.
+		order, _ := json.Marshal(cubeQuery.Order)
+		if string(order) != `[["orders.status","asc"],["orders.count","desc"]]` {
+			t.Errorf("Expected order status asc then count desc, got %s", order)
 			http.Error(w, "Invalid order", http.StatusBadRequest)
 			return
 		}
-		if len(orderMap) != 2 {
-			t.Errorf("Expected 2 order entries, got %v", orderMap)
-			http.Error(w, "Invalid order", http.StatusBadRequest)
-			return
-		}
-		if orderMap["orders.count"] != "desc" {
-			t.Errorf("Expected orders.count sort direction desc, got %v", orderMap["orders.count"])
-		}
-		if orderMap["orders.status"] != "asc" {
-			t.Errorf("Expected orders.status sort direction asc, got %v", orderMap["orders.status"])
-		}
 
 		response := CubeAPIResponse{
 			Data: []map[string]interface{}{
@@ -968,15 +961,7 @@ func TestQueryDataWithOrderField(t *testing.T) {
 	defer server.Close()
 
 	ds := Datasource{BaseURL: server.URL}
-	queryJSON, _ := json.Marshal(map[string]interface{}{
-		"refId":      "A",
-		"dimensions": []string{"orders.status"},
-		"measures":   []string{"orders.count"},
-		"order": map[string]string{
-			"orders.count":  "desc",
-			"orders.status": "asc",
-		},
-	})
+	queryJSON := []byte(`{"refId":"A","dimensions":["orders.status"],"measures":["orders.count"],"order":{"orders.status":"asc","orders.count":"desc"}}`)
 
 	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
 		PluginContext: newTestPluginContext(server.URL),
@@ -1179,260 +1164,9 @@ func TestConvertToNumber(t *testing.T) {
 	}
 }
 
-func TestConvertTimeField(t *testing.T) {
-	ds := &Datasource{}
-
-	tests := []struct {
-		name          string
-		inputValues   []interface{}
-		expectedTimes []string // Expected RFC3339 format or empty for nil
-		shouldConvert bool     // Whether conversion should happen
-	}{
-		{
-			name:          "RFC3339 format",
-			inputValues:   []interface{}{"2024-01-15T10:30:00Z", "2024-02-20T14:45:00Z"},
-			expectedTimes: []string{"2024-01-15T10:30:00Z", "2024-02-20T14:45:00Z"},
-			shouldConvert: true,
-		},
-		{
-			name:          "ISO 8601 with milliseconds",
-			inputValues:   []interface{}{"2024-01-15T10:30:00.123Z", "2024-02-20T14:45:00.456Z"},
-			expectedTimes: []string{"2024-01-15T10:30:00Z", "2024-02-20T14:45:00Z"},
-			shouldConvert: true,
-		},
-		{
-			name:          "Date only format",
-			inputValues:   []interface{}{"2024-01-15", "2024-02-20"},
-			expectedTimes: []string{"2024-01-15T00:00:00Z", "2024-02-20T00:00:00Z"},
-			shouldConvert: true,
-		},
-		{
-			name:          "Mixed valid formats",
-			inputValues:   []interface{}{"2024-01-15T10:30:00Z", "2024-02-20", "2024-03-25T08:15:00.789Z"},
-			expectedTimes: []string{"2024-01-15T10:30:00Z", "2024-02-20T00:00:00Z", "2024-03-25T08:15:00Z"},
-			shouldConvert: true,
-		},
-		{
-			name:          "With nil values",
-			inputValues:   []interface{}{"2024-01-15T10:30:00Z", nil, "2024-02-20T14:45:00Z"},
-			expectedTimes: []string{"2024-01-15T10:30:00Z", "", "2024-02-20T14:45:00Z"},
-			shouldConvert: true,
-		},
-		{
-			name:          "Invalid time format stays nil",
-			inputValues:   []interface{}{"not-a-date", "also-not-a-date"},
-			expectedTimes: []string{"", ""},
-			shouldConvert: true,
-		},
-		{
-			name:          "Empty string stays nil",
-			inputValues:   []interface{}{"", "2024-01-15T10:30:00Z"},
-			expectedTimes: []string{"", "2024-01-15T10:30:00Z"},
-			shouldConvert: true,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// Create a string field with the input values
-			stringValues := make([]*string, len(tt.inputValues))
-			for i, v := range tt.inputValues {
-				if v == nil {
-					stringValues[i] = nil
-				} else {
-					str := v.(string)
-					stringValues[i] = &str
-				}
-			}
-
-			// Use data.NewField to create a nullable string field
-			field := data.NewField("test_time", nil, stringValues)
-
-			// Convert the field
-			result := ds.convertTimeField(field)
-
-			if !tt.shouldConvert {
-				if result != nil {
-					t.Errorf("Expected no conversion, but got converted field")
-				}
-				return
-			}
-
-			if result == nil {
-				t.Fatalf("Expected converted field, got nil")
-			}
-
-			// Verify field name is preserved
-			if result.Name != "test_time" {
-				t.Errorf("Expected field name 'test_time', got '%s'", result.Name)
-			}
-
-			// Verify the converted values
-			if result.Len() != len(tt.expectedTimes) {
-				t.Fatalf("Expected %d values, got %d", len(tt.expectedTimes), result.Len())
-			}
-
-			for i, expected := range tt.expectedTimes {
-				val := result.At(i)
-				timeVal, ok := val.(*time.Time)
-				if expected == "" {
-					// Expect nil - either interface nil or typed nil pointer
-					if ok && timeVal != nil {
-						t.Errorf("Index %d: expected nil time, got %v", i, val)
-					}
-				} else {
-					// Expect time value
-					if !ok {
-						t.Errorf("Index %d: expected *time.Time, got %T", i, val)
-						continue
-					}
-					if timeVal == nil {
-						t.Errorf("Index %d: expected non-nil time, got nil pointer", i)
-						continue
-					}
-					// Compare formatted times (ignoring sub-second precision)
-					actualFormatted := timeVal.UTC().Format(time.RFC3339)
-					if actualFormatted != expected {
-						t.Errorf("Index %d: expected %s, got %s", i, expected, actualFormatted)
-					}
-				}
-			}
-		})
-	}
-}
-
-func TestConvertTimeFieldNonStringField(t *testing.T) {
-	ds := &Datasource{}
-
-	// Test that non-string fields return nil (no conversion needed)
-	intValues := []int64{1, 2, 3}
-	intField := data.NewField("test_int", nil, intValues)
-
-	result := ds.convertTimeField(intField)
-	if result != nil {
-		t.Errorf("Expected nil for non-string field, got converted field")
-	}
-
-	// Test float field
-	floatValues := []float64{1.1, 2.2, 3.3}
-	floatField := data.NewField("test_float", nil, floatValues)
-
-	result = ds.convertTimeField(floatField)
-	if result != nil {
-		t.Errorf("Expected nil for float field, got converted field")
-	}
-
-	// Test time field (already time type)
-	now := time.Now()
-	timeValues := []*time.Time{&now, nil}
-	timeField := data.NewField("test_time", nil, timeValues)
-
-	result = ds.convertTimeField(timeField)
-	if result != nil {
-		t.Errorf("Expected nil for already-time field, got converted field")
-	}
-}
-
-func TestConvertTimeDimensions(t *testing.T) {
-	ds := &Datasource{}
-
-	// Create frame with a time dimension field (as string) and a regular dimension
-	timeStr1 := "2024-01-15T10:30:00Z"
-	timeStr2 := "2024-02-20T14:45:00Z"
-	statusStr1 := "completed"
-	statusStr2 := "pending"
-
-	frame := data.NewFrame("test",
-		data.NewField("orders.created_at", nil, []*string{&timeStr1, &timeStr2}),
-		data.NewField("orders.status", nil, []*string{&statusStr1, &statusStr2}),
-	)
-
-	annotation := CubeAnnotation{
-		TimeDimensions: map[string]CubeFieldInfo{
-			"orders.created_at": {Title: "Created At", Type: "time"},
-		},
-		Dimensions: map[string]CubeFieldInfo{
-			"orders.status": {Title: "Status", Type: "string"},
-		},
-		Measures: map[string]CubeFieldInfo{},
-		Segments: map[string]CubeFieldInfo{},
-	}
-
-	// Run conversion
-	ds.convertTimeDimensions(frame, annotation)
-
-	// Verify time field was converted
-	timeField := frame.Fields[0]
-	if timeField.Type() != data.FieldTypeNullableTime {
-		t.Errorf("Expected time field to be NullableTime, got %s", timeField.Type())
-	}
-
-	// Verify non-time field was NOT converted
-	statusField := frame.Fields[1]
-	if statusField.Type() != data.FieldTypeNullableString {
-		t.Errorf("Expected status field to remain NullableString, got %s", statusField.Type())
-	}
-
-	// Verify time values are correct
-	val := timeField.At(0)
-	if timeVal, ok := val.(*time.Time); ok && timeVal != nil {
-		expected := "2024-01-15T10:30:00Z"
-		actual := timeVal.UTC().Format(time.RFC3339)
-		if actual != expected {
-			t.Errorf("Expected time %s, got %s", expected, actual)
-		}
-	} else {
-		t.Errorf("Expected *time.Time value, got %T", val)
-	}
-}
-
-func TestConvertTimeDimensionsRegularDimensionWithTimeType(t *testing.T) {
-	ds := &Datasource{}
-
-	// Test case: a date field used as a regular dimension (not in timeDimensions)
-	// This happens when you query a date field without granularity
-	dateStr1 := "2018-01-01T00:00:00.000"
-	dateStr2 := "2018-01-02T00:00:00.000"
-
-	frame := data.NewFrame("test",
-		data.NewField("orders.order_date", nil, []*string{&dateStr1, &dateStr2}),
-	)
-
-	// The field appears in Dimensions (not TimeDimensions) but has type "time"
-	annotation := CubeAnnotation{
-		TimeDimensions: map[string]CubeFieldInfo{}, // Empty - not a time dimension query
-		Dimensions: map[string]CubeFieldInfo{
-			"orders.order_date": {Title: "Order Date", Type: "time"},
-		},
-		Measures: map[string]CubeFieldInfo{},
-		Segments: map[string]CubeFieldInfo{},
-	}
-
-	// Run conversion
-	ds.convertTimeDimensions(frame, annotation)
-
-	// Verify the field was converted to time type
-	dateField := frame.Fields[0]
-	if dateField.Type() != data.FieldTypeNullableTime {
-		t.Errorf("Expected date dimension to be NullableTime, got %s", dateField.Type())
-	}
-
-	// Verify time value is correct
-	val := dateField.At(0)
-	if timeVal, ok := val.(*time.Time); ok && timeVal != nil {
-		expected := "2018-01-01T00:00:00Z"
-		actual := timeVal.UTC().Format(time.RFC3339)
-		if actual != expected {
-			t.Errorf("Expected time %s, got %s", expected, actual)
-		}
-	} else {
-		t.Errorf("Expected *time.Time value, got %T", val)
-	}
-}
-
 func TestConvertTimeDimensionsIntegration(t *testing.T) {
 	// Create a mock server that returns data with time dimensions
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 		// Return mock Cube API response with time dimension
 		response := CubeAPIResponse{
 			Data: []map[string]interface{}{
@@ -1544,3 +1278,112 @@ func TestConvertTimeDimensionsIntegration(t *testing.T) {
 		t.Errorf("Expected *time.Time value at index 0, got %T", val)
 	}
 }
+
+func TestQueryDataContinueWaitMaxDuration(t *testing.T) {
+	var requestCount atomic.Int32
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"error": "Continue wait", "stage": "Executing query"}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "continueWaitPollInterval": 1, "continueWaitMaxDuration": 60}`)
+
+	// The query's max duration overrides the datasource's.
+	start := time.Now()
+	res := runSingleQuery(t, ds, pCtx, `{"refId": "A", "measures": ["orders.count"], "continueWaitTimeoutSeconds": 1}`)
+	if res.Error == nil {
+		t.Fatal("expected the query to fail once the max duration was reached")
+	}
+	if res.Status != backend.StatusTimeout {
+		t.Errorf("expected a timeout status, got %d", res.Status)
+	}
+	if !strings.Contains(res.Error.Error(), "continueWaitMaxDuration") || !strings.Contains(res.Error.Error(), "stage: Executing query") {
+		t.Errorf("unexpected error message: %v", res.Error)
+	}
+	// One poll, a 1s pause, then a second poll past the limit.
+	if got := requestCount.Load(); got != 2 {
+		t.Errorf("expected 2 requests, got %d", got)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected polls to be 1s apart, finished after %s", elapsed)
+	}
+}
+
+func TestContinueWaitConfig(t *testing.T) {
+	one, five, ten := 1, 5, 10
+	config := &models.PluginSettings{ContinueWaitPollInterval: &five, ContinueWaitMaxDuration: &ten}
+
+	if got := continueWaitConfig(config, CubeQuery{}); got != config {
+		t.Errorf("expected queries without overrides to keep the settings")
+	}
+
+	got := continueWaitConfig(config, CubeQuery{ContinueWaitTimeoutSeconds: &five}, CubeQuery{ContinueWaitTimeoutSeconds: &one})
+	if got.MaxContinueWait() != time.Second {
+		t.Errorf("expected the smallest query override to win, got %s", got.MaxContinueWait())
+	}
+	if got.ContinueWaitPollIntervalDuration() != 5*time.Second {
+		t.Errorf("expected the datasource poll interval to be kept, got %s", got.ContinueWaitPollIntervalDuration())
+	}
+	if config.MaxContinueWait() != 10*time.Second {
+		t.Errorf("expected the datasource settings to be left unchanged")
+	}
+
+	got = continueWaitConfig(config, CubeQuery{PollIntervalSeconds: &one})
+	if got.ContinueWaitPollIntervalDuration() != time.Second || got.MaxContinueWait() != 10*time.Second {
+		t.Errorf("expected only the poll interval to be overridden, got %s and %s", got.ContinueWaitPollIntervalDuration(), got.MaxContinueWait())
+	}
+}
+
+func TestQueryDataRowLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		jsonData  string
+		query     string
+		wantLimit string
+	}{
+		{name: "no limit settings", jsonData: `{"deploymentType": "self-hosted-dev"}`, query: `{"refId":"A","measures":["orders.count"]}`},
+		{name: "default limit", jsonData: `{"deploymentType": "self-hosted-dev", "defaultLimit": 1000}`, query: `{"refId":"A","measures":["orders.count"]}`, wantLimit: `"limit":1000`},
+		{name: "clamped to max limit", jsonData: `{"deploymentType": "self-hosted-dev", "maxLimit": 5000}`, query: `{"refId":"A","measures":["orders.count"],"limit":50000}`, wantLimit: `"limit":5000`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotQuery string
+			server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+				gotQuery = r.URL.Query().Get("query")
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"data": []}`))
+			}))
+			defer server.Close()
+
+			pCtx := newTestPluginContext(server.URL)
+			pCtx.DataSourceInstanceSettings.JSONData = []byte(tt.jsonData)
+			res := runSingleQuery(t, &Datasource{BaseURL: server.URL}, pCtx, tt.query)
+			if res.Error != nil {
+				t.Fatalf("unexpected error: %v", res.Error)
+			}
+			if tt.wantLimit == "" && strings.Contains(gotQuery, `"limit"`) {
+				t.Errorf("expected no limit, got %s", gotQuery)
+			}
+			if tt.wantLimit != "" && !strings.Contains(gotQuery, tt.wantLimit) {
+				t.Errorf("expected %s, got %s", tt.wantLimit, gotQuery)
+			}
+		})
+	}
+}
+
+func TestQueryDataWithoutQueries(t *testing.T) {
+	ds := &Datasource{}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: newTestPluginContext("http://cube:4000"),
+	})
+	if err != nil {
+		t.Fatalf("QueryData failed: %v", err)
+	}
+	if len(resp.Responses) != 0 {
+		t.Errorf("expected no responses, got %v", resp.Responses)
+	}
+}
@@ -0,0 +1,161 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// RangeResponse is the range resource response: the smallest and largest
+// values of a member. Min and Max are numbers for number members and Cube
+// timestamps for time members, or null when the member has no values.
+type RangeResponse struct {
+	Member string      `json:"member"`
+	Type   string      `json:"type"`
+	Min    interface{} `json:"min"`
+	Max    interface{} `json:"max"`
+}
+
+// handleRange returns the bounds of a number or time dimension
+// (range?member=orders.amount), for range slider variables and axis
+// defaults. Like tag-values it takes scoping filters (filters=[...]) and
+// applies the datasource's default filters.
+func (d *Datasource) handleRange(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
+	}
+	member := parsedURL.Query().Get("member")
+	if member == "" {
+		return sender.Send(jsonErrorResponse(400, errors.New("member parameter is required")))
+	}
+
+	meta, err := d.getCubeMetadata(ctx, req.PluginContext)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to fetch cube metadata", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to fetch metadata from Cube API")))
+	}
+	info, ok := filterableMembers(meta)[member]
+	if !ok {
+		return sender.Send(jsonErrorResponse(400, fmt.Errorf("member %q not found in the Cube model", member)))
+	}
+	if info.Kind != "dimension" || (info.Type != "number" && info.Type != "time") {
+		return sender.Send(jsonErrorResponse(400, fmt.Errorf("%s is not a number or time dimension", member)))
+	}
+
+	filters, segments := d.tagValueFilters(ctx, req.PluginContext, parsedURL.Query().Get("filters"))
+	if err := checkAllowedMembers(d.pluginSettings(req.PluginContext), tagValuesMembers(member, filters, segments)); err != nil {
+		return sender.Send(jsonErrorResponse(http.StatusForbidden, err))
+	}
+	apiReq, err := d.buildAPIURL(req.PluginContext, "load")
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	queriesJSON, err := memberBoundsQueryJSON(req.PluginContext, apiReq.Config, member, filters, segments)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	bounds, err := d.loadMemberBounds(ctx, apiReq, queriesJSON, member)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to fetch member range from Cube API", "error", err)
+		return sender.Send(cubeLoadErrorResponse(err))
+	}
+
+	res := RangeResponse{Member: member, Type: info.Type, Min: bounds[0], Max: bounds[1]}
+	if info.Type == "number" {
+		if res.Min, err = rangeNumber(bounds[0]); err == nil {
+			res.Max, err = rangeNumber(bounds[1])
+		}
+		if err != nil {
+			return sender.Send(jsonErrorResponse(502, fmt.Errorf("unexpected value of %s: %w", member, err)))
+		}
+	}
+	body, err := json.Marshal(res)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal range response", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   body,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
+
+// rangeNumber converts a value of a number dimension, which Cube returns as
+// a number or a string depending on the database, into a float64. nil stays
+// nil.
+func rangeNumber(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return nil, fmt.Errorf("%v is not a number", v)
+	}
+}
+
+// memberBoundsQueryJSON returns a multi-query for the smallest and largest
+// value of member, within the scoping filters and segments, with the
+// datasource's rewrite rules applied. Cube has no min and max of a
+// dimension, so each query orders by the member and keeps one row.
+func memberBoundsQueryJSON(pCtx backend.PluginContext, config *models.PluginSettings, member string, filters []interface{}, segments []string) ([]byte, error) {
+	queries := make([]json.RawMessage, 0, 2)
+	for _, direction := range []string{"asc", "desc"} {
+		query := map[string]interface{}{
+			"dimensions": []string{member},
+			"filters":    append([]interface{}{map[string]interface{}{"member": member, "operator": "set"}}, filters...),
+			"order":      [][]string{{member, direction}},
+			"limit":      1,
+		}
+		if len(segments) > 0 {
+			query["segments"] = segments
+		}
+		queryJSON, err := json.Marshal(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal query: %w", err)
+		}
+		if queryJSON, err = rewriteQueryJSON(queryJSON, pCtx, config); err != nil {
+			return nil, err
+		}
+		queries = append(queries, queryJSON)
+	}
+	return json.Marshal(queries)
+}
+
+// loadMemberBounds runs the queries of memberBoundsQueryJSON and returns the
+// smallest and largest value of member, nil when it has none.
+func (d *Datasource) loadMemberBounds(ctx context.Context, apiReq *APIRequestContext, queriesJSON []byte, member string) ([2]interface{}, error) {
+	var bounds [2]interface{}
+	body, err := d.doCubeMultiLoadRequest(ctx, apiReq.URL.String(), queriesJSON, apiReq.Config)
+	if err != nil {
+		return bounds, err
+	}
+	envelope, err := decodeCubeEnvelope(body)
+	if err != nil {
+		return bounds, err
+	}
+	results, err := envelope.results()
+	if err != nil {
+		return bounds, err
+	}
+	if len(results) != len(bounds) {
+		return bounds, fmt.Errorf("expected %d results, got %d", len(bounds), len(results))
+	}
+	for i, result := range results {
+		if len(result.Data) > 0 {
+			bounds[i] = result.Data[0][member]
+		}
+	}
+	return bounds, nil
+}
@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// newRangeServer starts a mock Cube server with an orders cube whose bounds
+// queries answer with results.
+func newRangeServer(t *testing.T, results string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+			_, _ = w.Write([]byte(`{"cubes": [{"name": "orders", "type": "cube",
+				"dimensions": [{"name": "orders.amount", "type": "number"}, {"name": "orders.created_at", "type": "time"}, {"name": "orders.status", "type": "string"}],
+				"measures": [{"name": "orders.count", "type": "number"}]}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"queryType": "multi", "results": ` + results + `}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHandleRange(t *testing.T) {
+	server := newRangeServer(t, `[{"data": [{"orders.amount": "0.5"}]}, {"data": [{"orders.amount": 1200}]}]`)
+	resp := callHandler(t, (&Datasource{}).handleRange, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "range?member=orders.amount",
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+	if want := `{"member":"orders.amount","type":"number","min":0.5,"max":1200}`; string(resp.Body) != want {
+		t.Errorf("expected %s, got %s", want, resp.Body)
+	}
+}
+
+func TestHandleRangeTimeWithoutData(t *testing.T) {
+	server := newRangeServer(t, `[{"data": []}, {"data": []}]`)
+	resp := callHandler(t, (&Datasource{}).handleRange, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "range?member=orders.created_at",
+	})
+	var res RangeResponse
+	if err := json.Unmarshal(resp.Body, &res); err != nil || resp.Status != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", resp.Status, resp.Body)
+	}
+	if res.Type != "time" || res.Min != nil || res.Max != nil {
+		t.Errorf("expected null bounds, got %+v", res)
+	}
+}
+
+func TestHandleRangeErrors(t *testing.T) {
+	server := newRangeServer(t, `[]`)
+	for name, rawURL := range map[string]string{
+		"no member":        "range",
+		"unknown member":   "range?member=orders.nope",
+		"string dimension": "range?member=orders.status",
+		"measure":          "range?member=orders.count",
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp := callHandler(t, (&Datasource{}).handleRange, &backend.CallResourceRequest{
+				PluginContext: newTestPluginContext(server.URL),
+				URL:           rawURL,
+			})
+			if resp.Status != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d: %s", resp.Status, resp.Body)
+			}
+		})
+	}
+}
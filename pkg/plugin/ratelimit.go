@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// defaultResourceRateLimit is the resourceRateLimit of datasources that do
+// not set one, in calls per second.
+const defaultResourceRateLimit = 20
+
+// rateLimitedResources are the resources the frontend calls often enough to
+// flood Cube, e.g. while typing in ad-hoc filters or refreshing variables.
+var rateLimitedResources = map[string]bool{
+	"tag-keys":        true,
+	"tag-values":      true,
+	"tag-values-bulk": true,
+	"metadata":        true,
+	"members":         true,
+	"cardinality":     true,
+	"range":           true,
+	"sql":             true,
+}
+
+// resourceRateLimit returns the resourceRateLimit setting, 0 meaning no
+// limit.
+func resourceRateLimit(config *models.PluginSettings) int {
+	if config == nil || config.ResourceRateLimit == nil {
+		return defaultResourceRateLimit
+	}
+	return max(*config.ResourceRateLimit, 0)
+}
+
+// tokenBucket is a token bucket rate limiter. Its zero value is ready to use
+// and starts full.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token from a bucket refilled with rate tokens per second up to
+// burst. When the bucket is empty it returns false and how long until the
+// next token.
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// rateLimitResponse returns the 429 response of a call over the
+// resourceRateLimit, or nil when the call may proceed.
+func (d *Datasource) rateLimitResponse(req *backend.CallResourceRequest) *backend.CallResourceResponse {
+	limit := resourceRateLimit(d.pluginSettings(req.PluginContext))
+	if limit == 0 {
+		return nil
+	}
+	ok, retryAfter := d.resourceRate.take(time.Now(), float64(limit), 2*limit)
+	if ok {
+		return nil
+	}
+	rateLimitedRequestsTotal.WithLabelValues(req.Path).Inc()
+	res := jsonErrorResponse(429, fmt.Errorf("too many requests: the datasource allows %d %s calls per second", limit, req.Path))
+	res.Headers["Retry-After"] = []string{strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))}
+	return res
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	now := time.Now()
+	for i := range 4 {
+		if ok, _ := b.take(now, 2, 4); !ok {
+			t.Fatalf("expected call %d within the burst to pass", i)
+		}
+	}
+	ok, retryAfter := b.take(now, 2, 4)
+	if ok || retryAfter != 500*time.Millisecond {
+		t.Errorf("expected the empty bucket to refuse for 500ms, got %v %s", ok, retryAfter)
+	}
+	if ok, _ := b.take(now.Add(500*time.Millisecond), 2, 4); !ok {
+		t.Error("expected a token after 500ms")
+	}
+}
+
+func TestCallResourceRateLimit(t *testing.T) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	ds := &Datasource{}
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "resourceRateLimit": 1}`)
+	call := func(path string) *backend.CallResourceResponse {
+		var res *backend.CallResourceResponse
+		sender := backend.CallResourceResponseSenderFunc(func(r *backend.CallResourceResponse) error {
+			res = r
+			return nil
+		})
+		if err := ds.CallResource(context.Background(), &backend.CallResourceRequest{PluginContext: pCtx, Path: path, URL: path}, sender); err != nil {
+			t.Fatalf("CallResource returned error: %v", err)
+		}
+		return res
+	}
+
+	for i := range 2 {
+		if res := call("metadata"); res.Status != http.StatusOK {
+			t.Fatalf("expected call %d within the burst to pass, got %d: %s", i, res.Status, res.Body)
+		}
+	}
+	res := call("metadata")
+	if res.Status != http.StatusTooManyRequests || res.Headers["Retry-After"][0] != "1" {
+		t.Errorf("expected 429 with Retry-After, got %d %v", res.Status, res.Headers)
+	}
+	if res := call("capabilities"); res.Status != http.StatusOK {
+		t.Errorf("expected other resources not to be limited, got %d", res.Status)
+	}
+}
@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// rawCubeQuery is a Cube query written by hand in the panel's raw JSON mode
+// (CubeQuery.RawQuery). It can use Cube query features the builder does not
+// offer yet.
+type rawCubeQuery struct {
+	Measures       []string        `json:"measures"`
+	Dimensions     []string        `json:"dimensions"`
+	TimeDimensions []interface{}   `json:"timeDimensions"`
+	Filters        []interface{}   `json:"filters"`
+	Segments       []string        `json:"segments"`
+	Order          json.RawMessage `json:"order"`
+	Limit          *int            `json:"limit"`
+	Offset         *int            `json:"offset"`
+	Timezone       string          `json:"timezone"`
+	Total          *bool           `json:"total"`
+	Ungrouped      *bool           `json:"ungrouped"`
+
+	// order is Order as [member, direction] pairs, in priority order.
+	order []interface{}
+}
+
+// rawQueryFields are the fields of a Cube query a raw query may set.
+var rawQueryFields = []string{
+	"measures", "dimensions", "timeDimensions", "filters", "segments",
+	"order", "limit", "offset", "timezone", "total", "ungrouped",
+}
+
+// parseRawQuery parses and checks the structure of a raw query. Whether Cube
+// accepts it is checked by dryRunQuery.
+func parseRawQuery(raw string) (*rawCubeQuery, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		return nil, fmt.Errorf("raw query is not a JSON object: %w", err)
+	}
+	var unknown []string
+	for field := range fields {
+		if !slices.Contains(rawQueryFields, field) {
+			unknown = append(unknown, field)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("raw query has unknown field '%s'", unknown[0])
+	}
+
+	var q rawCubeQuery
+	if err := json.Unmarshal([]byte(raw), &q); err != nil {
+		return nil, fmt.Errorf("invalid raw query: %w", err)
+	}
+	if len(q.Measures) == 0 && len(q.Dimensions) == 0 && len(q.TimeDimensions) == 0 && len(q.Segments) == 0 {
+		return nil, fmt.Errorf("raw query must have measures, dimensions, time dimensions or segments")
+	}
+	pairs, err := orderPairs(q.Order)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw query: %w", err)
+	}
+	for _, pair := range pairs {
+		q.order = append(q.order, []interface{}{pair[0], pair[1]})
+	}
+	return &q, nil
+}
+
+// applyTo replaces the builder fields of a panel query with the raw query's,
+// so the raw query is converted like a built one.
+func (q *rawCubeQuery) applyTo(cubeQuery *CubeQuery) {
+	cubeQuery.Measures = q.Measures
+	cubeQuery.Dimensions = q.Dimensions
+	cubeQuery.TimeDimensions = q.TimeDimensions
+	cubeQuery.Filters = q.Filters
+	cubeQuery.Order = nil
+	if len(q.order) > 0 {
+		cubeQuery.Order = q.order
+	}
+	cubeQuery.Limit = q.Limit
+}
+
+// addOptions adds the raw query's fields that the builder has no equivalent
+// for to a Cube API query.
+func (q *rawCubeQuery) addOptions(apiQuery map[string]interface{}) {
+	if len(q.Segments) > 0 {
+		segments, _ := apiQuery["segments"].([]string)
+		for _, segment := range q.Segments {
+			if !slices.Contains(segments, segment) {
+				segments = append(segments, segment)
+			}
+		}
+		apiQuery["segments"] = segments
+	}
+	if q.Offset != nil {
+		apiQuery["offset"] = q.Offset
+	}
+	if q.Timezone != "" {
+		apiQuery["timezone"] = q.Timezone
+	}
+	if q.Total != nil {
+		apiQuery["total"] = q.Total
+	}
+	if q.Ungrouped != nil {
+		apiQuery["ungrouped"] = q.Ungrouped
+	}
+}
+
+// dryRunQuery validates a Cube API query with Cube's /v1/dry-run endpoint
+// before it runs. A dry run that fails for another reason than an invalid
+// query is only logged: the query itself will report the problem.
+func (d *Datasource) dryRunQuery(ctx context.Context, pCtx backend.PluginContext, apiQuery map[string]interface{}) error {
+	queryJSON, err := json.Marshal(apiQuery)
+	if err != nil {
+		return fmt.Errorf("failed to marshal query: %w", err)
+	}
+	result, err := d.fetchCubeDryRun(ctx, pCtx, string(queryJSON))
+	if err != nil {
+		backend.Logger.FromContext(ctx).Warn("Failed to dry-run raw query", "error", err)
+		return nil
+	}
+	if !result.Valid {
+		return fmt.Errorf("Cube rejected the raw query: %s", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRawQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr string
+	}{
+		{name: "valid", raw: `{"measures": ["orders.count"], "segments": ["orders.completed"], "offset": 10, "timezone": "Europe/Berlin"}`},
+		{name: "not an object", raw: `["orders.count"]`, wantErr: "raw query is not a JSON object"},
+		{name: "unknown field", raw: `{"measures": ["orders.count"], "measure": ["orders.total"]}`, wantErr: "raw query has unknown field 'measure'"},
+		{name: "wrong type", raw: `{"measures": "orders.count"}`, wantErr: "invalid raw query"},
+		{name: "invalid order", raw: `{"measures": ["orders.count"], "order": "orders.count"}`, wantErr: "invalid raw query"},
+		{name: "no members", raw: `{"limit": 10}`, wantErr: "raw query must have measures"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRawQuery(tt.raw)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestQueryDataRawQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		dryRun     func(w http.ResponseWriter)
+		wantErr    string
+		wantLoaded bool
+	}{
+		{
+			name: "valid",
+			dryRun: func(w http.ResponseWriter) {
+				_, _ = w.Write([]byte(`{"queryType": "regularQuery", "normalizedQueries": []}`))
+			},
+			wantLoaded: true,
+		},
+		{
+			name: "rejected by Cube",
+			dryRun: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error": "'orders.nope' not found"}`))
+			},
+			wantErr: "Cube rejected the raw query: 'orders.nope' not found",
+		},
+		{
+			name: "dry run unavailable",
+			dryRun: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error": "Not found"}`))
+			},
+			wantLoaded: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dryRunQuery, loadQuery string
+			server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/cubejs-api/v1/dry-run":
+					dryRunQuery = r.URL.Query().Get("query")
+					tt.dryRun(w)
+				case "/cubejs-api/v1/load":
+					loadQuery = r.URL.Query().Get("query")
+					_, _ = w.Write([]byte(`{"data": [{"orders.count": "5"}], "annotation": {"measures": {"orders.count": {"type": "number"}}}}`))
+				}
+			}))
+			defer server.Close()
+
+			rawQuery, _ := json.Marshal(`{"measures": ["orders.count"], "segments": ["orders.completed"], "ungrouped": false,
+				"order": {"orders.status": "asc", "orders.count": "desc"}}`)
+			res := runSingleQuery(t, &Datasource{BaseURL: server.URL}, newTestPluginContext(server.URL),
+				`{"refId":"A","measures":["orders.ignored"],"rawQuery":`+string(rawQuery)+`}`)
+			if tt.wantErr != "" {
+				if res.Error == nil || !strings.Contains(res.Error.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, res.Error)
+				}
+				if loadQuery != "" {
+					t.Errorf("expected the rejected query not to run, got %s", loadQuery)
+				}
+				return
+			}
+			if res.Error != nil {
+				t.Fatalf("unexpected error: %v", res.Error)
+			}
+			want := `{"measures":["orders.count"],"order":[["orders.status","asc"],["orders.count","desc"]],"segments":["orders.completed"],"ungrouped":false}`
+			if dryRunQuery != want || loadQuery != want {
+				t.Errorf("expected %s to be validated and run, got dry run %s and load %s", want, dryRunQuery, loadQuery)
+			}
+			if len(res.Frames) != 1 || res.Frames[0].Rows() != 1 {
+				t.Errorf("expected one row, got %v", res.Frames)
+			}
+		})
+	}
+}
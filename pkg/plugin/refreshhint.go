@@ -0,0 +1,262 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// refreshScheduleTTL is how long the pre-aggregation refresh schedules read
+// from Cube's system API are reused before they are fetched again.
+const refreshScheduleTTL = 5 * time.Minute
+
+// defaultRefreshScheduleTimeout bounds the system API call reading the
+// refresh schedules when the datasource sets no metaTimeout.
+const defaultRefreshScheduleTimeout = 10 * time.Second
+
+// usedPreAggregation is an entry of usedPreAggregations in a /v1/load
+// response, keyed by the pre-aggregation table name (schema.table).
+type usedPreAggregation struct {
+	TargetTableName string `json:"targetTableName"`
+	// LastUpdatedAt is when the table was last built, in Unix milliseconds.
+	LastUpdatedAt int64 `json:"lastUpdatedAt,omitempty"`
+}
+
+// refreshHint is attached to the frames of a query answered from
+// pre-aggregations (frame meta custom "refresh"), so dashboards can refresh
+// in step with the data rather than on an arbitrary interval.
+type refreshHint struct {
+	// SuggestedInterval is the most frequent refresh schedule among the
+	// pre-aggregations, as a Grafana interval ("30m", "1h").
+	SuggestedInterval string `json:"suggestedInterval"`
+	// PreAggregations are the pre-aggregations the query used.
+	PreAggregations []string `json:"preAggregations"`
+	// LastUpdatedAt is when the oldest of them was last built, in Unix
+	// milliseconds. Omitted when Cube did not report it.
+	LastUpdatedAt int64 `json:"lastUpdatedAt,omitempty"`
+}
+
+// refreshSchedules caches the refresh schedule of every pre-aggregation,
+// keyed by table name. fetching is set while a fetch is in progress.
+type refreshSchedules struct {
+	mu        sync.Mutex
+	every     map[string]time.Duration
+	fetchedAt time.Time
+	fetching  bool
+}
+
+// everyPattern matches the interval form of a refreshKey "every" ("1 hour",
+// "30 minutes"). Cron schedules have no fixed interval and are not matched.
+var everyPattern = regexp.MustCompile(`^(\d+)\s*(second|minute|hour|day|week)s?$`)
+
+var everyUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+}
+
+// parseEvery converts a refreshKey "every" interval to a duration.
+func parseEvery(every string) (time.Duration, bool) {
+	match := everyPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(every)))
+	if match == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return time.Duration(n) * everyUnits[match[2]], true
+}
+
+// formatInterval formats a duration in the largest unit Grafana's refresh
+// picker understands that divides it evenly.
+func formatInterval(d time.Duration) string {
+	for _, unit := range []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+	} {
+		if d%unit.size == 0 {
+			return fmt.Sprintf("%d%s", d/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%ds", int64(d/time.Second))
+}
+
+// snakeCase converts a cube or pre-aggregation name to the form Cube uses in
+// pre-aggregation table names ("LineItems" -> "line_items").
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 && name[i-1] != '_' {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// preAggregationTable returns the table name of a usedPreAggregations key
+// without its schema.
+func preAggregationTable(key string) string {
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		return key[i+1:]
+	}
+	return key
+}
+
+// fetchRefreshSchedules reads the refresh schedules of the pre-aggregations
+// from Cube's system API (/cubejs-system/v1/pre-aggregations), within
+// metaTimeout or defaultRefreshScheduleTimeout.
+func (d *Datasource) fetchRefreshSchedules(ctx context.Context, config *models.PluginSettings) (map[string]time.Duration, error) {
+	timeout := config.MetaTimeoutDuration()
+	if timeout == 0 {
+		timeout = defaultRefreshScheduleTimeout
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	// Get base URL with test override support
+	baseURL := d.baseURL(config)
+	systemURL := strings.TrimRight(baseURL, "/") + "/cubejs-system/v1/pre-aggregations"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", systemURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := d.addAuthHeaders(req, config); err != nil {
+		return nil, fmt.Errorf("failed to add auth headers: %w", err)
+	}
+	resp, err := d.doHTTP(req, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make API request: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			backend.Logger.FromContext(ctx).Warn("Failed to close response body", "error", err)
+		}
+	}()
+
+	body, err := readJSONResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		PreAggregations []struct {
+			Cube               string `json:"cube"`
+			PreAggregationName string `json:"preAggregationName"`
+			PreAggregation     struct {
+				RefreshKey struct {
+					Every string `json:"every"`
+				} `json:"refreshKey"`
+			} `json:"preAggregation"`
+		} `json:"preAggregations"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	every := make(map[string]time.Duration)
+	for _, p := range list.PreAggregations {
+		if interval, ok := parseEvery(p.PreAggregation.RefreshKey.Every); ok {
+			every[snakeCase(p.Cube)+"_"+snakeCase(p.PreAggregationName)] = interval
+		}
+	}
+	return every, nil
+}
+
+// getRefreshSchedules returns the cached refresh schedules, fetching them when
+// older than refreshScheduleTTL. One caller fetches at a time, without the
+// lock held; the others get the schedules cached so far rather than wait.
+// The system API needs extra permissions and may be disabled, so a failed
+// fetch is cached too and hints are best effort.
+func (d *Datasource) getRefreshSchedules(ctx context.Context, config *models.PluginSettings) map[string]time.Duration {
+	d.schedules.mu.Lock()
+	if d.schedules.fetching || (!d.schedules.fetchedAt.IsZero() && time.Since(d.schedules.fetchedAt) < refreshScheduleTTL) {
+		every := d.schedules.every
+		d.schedules.mu.Unlock()
+		return every
+	}
+	d.schedules.fetching = true
+	d.schedules.mu.Unlock()
+
+	every, err := d.fetchRefreshSchedules(ctx, config)
+
+	d.schedules.mu.Lock()
+	defer d.schedules.mu.Unlock()
+	d.schedules.fetching = false
+	if err != nil && ctx.Err() != nil {
+		// The query was cancelled; try again on the next one.
+		return d.schedules.every
+	}
+	if err != nil {
+		backend.Logger.FromContext(ctx).Debug("Failed to fetch pre-aggregation refresh schedules", "error", err)
+	}
+	d.schedules.every, d.schedules.fetchedAt = every, time.Now()
+	return every
+}
+
+// refreshHintFor builds the refresh hint for a result, or returns nil when
+// the result used no pre-aggregation with a known interval schedule.
+func refreshHintFor(used map[string]usedPreAggregation, every map[string]time.Duration) *refreshHint {
+	var hint refreshHint
+	var interval time.Duration
+	for key, p := range used {
+		schedule, ok := every[preAggregationTable(key)]
+		if !ok {
+			continue
+		}
+		if interval == 0 || schedule < interval {
+			interval = schedule
+		}
+		hint.PreAggregations = append(hint.PreAggregations, key)
+		if p.LastUpdatedAt > 0 && (hint.LastUpdatedAt == 0 || p.LastUpdatedAt < hint.LastUpdatedAt) {
+			hint.LastUpdatedAt = p.LastUpdatedAt
+		}
+	}
+	if interval == 0 {
+		return nil
+	}
+	sort.Strings(hint.PreAggregations)
+	hint.SuggestedInterval = formatInterval(interval)
+	return &hint
+}
+
+// addRefreshHint attaches a refreshHint to the frames of a successful
+// response whose result came from pre-aggregations.
+func (d *Datasource) addRefreshHint(ctx context.Context, pCtx backend.PluginContext, result CubeAPIResponse, response backend.DataResponse) backend.DataResponse {
+	if response.Error != nil || len(response.Frames) == 0 || len(result.UsedPreAggregations) == 0 {
+		return response
+	}
+	config, err := d.loadSettings(*pCtx.DataSourceInstanceSettings)
+	if err != nil {
+		return response
+	}
+	hint := refreshHintFor(result.UsedPreAggregations, d.getRefreshSchedules(ctx, config))
+	if hint == nil {
+		return response
+	}
+	for _, frame := range response.Frames {
+		setCustomMeta(frame, "refresh", hint)
+	}
+	return response
+}
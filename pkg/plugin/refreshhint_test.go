@@ -0,0 +1,156 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+)
+
+func TestParseEvery(t *testing.T) {
+	tests := map[string]time.Duration{
+		"1 hour":     time.Hour,
+		"30 minutes": 30 * time.Minute,
+		"2 days":     48 * time.Hour,
+		"10 second":  10 * time.Second,
+		"1 Week":     7 * 24 * time.Hour,
+	}
+	for every, want := range tests {
+		if got, ok := parseEvery(every); !ok || got != want {
+			t.Errorf("parseEvery(%q) = %v, %v; want %v", every, got, ok, want)
+		}
+	}
+	for _, every := range []string{"0 * * * *", "", "0 hours", "hourly"} {
+		if _, ok := parseEvery(every); ok {
+			t.Errorf("expected %q not to parse", every)
+		}
+	}
+}
+
+func TestFormatInterval(t *testing.T) {
+	tests := map[time.Duration]string{
+		90 * time.Second: "90s",
+		30 * time.Minute: "30m",
+		2 * time.Hour:    "2h",
+		48 * time.Hour:   "2d",
+		90 * time.Minute: "90m",
+	}
+	for d, want := range tests {
+		if got := formatInterval(d); got != want {
+			t.Errorf("formatInterval(%v) = %q; want %q", d, got, want)
+		}
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, want := range map[string]string{"orders": "orders", "LineItems": "line_items", "main_rollup": "main_rollup", "byDay": "by_day"} {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q; want %q", name, got, want)
+		}
+	}
+}
+
+func TestQueryDataRefreshHint(t *testing.T) {
+	var systemRequests atomic.Int32
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/cubejs-system/v1/pre-aggregations" {
+			systemRequests.Add(1)
+			_, _ = w.Write([]byte(`{"preAggregations": [
+				{"cube": "orders", "preAggregationName": "main", "preAggregation": {"refreshKey": {"every": "1 hour"}}},
+				{"cube": "LineItems", "preAggregationName": "byDay", "preAggregation": {"refreshKey": {"every": "30 minutes"}}},
+				{"cube": "orders", "preAggregationName": "nightly", "preAggregation": {"refreshKey": {"every": "0 2 * * *"}}}
+			]}`))
+			return
+		}
+		switch r.URL.Query().Get("query") {
+		case `{"measures":["orders.count"]}`:
+			_, _ = w.Write([]byte(`{"data": [{"orders.count": "1"}], "annotation": {},
+				"usedPreAggregations": {
+					"prod_pre_aggregations.orders_main": {"targetTableName": "prod_pre_aggregations.orders_main_abc", "lastUpdatedAt": 1700000000000},
+					"prod_pre_aggregations.line_items_by_day": {"targetTableName": "prod_pre_aggregations.line_items_by_day_def", "lastUpdatedAt": 1690000000000}
+				}}`))
+		case `{"measures":["orders.total"]}`:
+			_, _ = w.Write([]byte(`{"data": [{"orders.total": "1"}], "annotation": {},
+				"usedPreAggregations": {"prod_pre_aggregations.orders_nightly": {"targetTableName": "prod_pre_aggregations.orders_nightly_abc"}}}`))
+		default:
+			_, _ = w.Write([]byte(`{"data": [{"orders.status": "done"}], "annotation": {}}`))
+		}
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+
+	resp := runSingleQuery(t, ds, pCtx, `{"refId": "A", "measures": ["orders.count"]}`)
+	if resp.Error != nil {
+		t.Fatalf("unexpected error: %v", resp.Error)
+	}
+	custom, _ := resp.Frames[0].Meta.Custom.(map[string]interface{})
+	hint, _ := custom["refresh"].(*refreshHint)
+	if hint == nil {
+		t.Fatalf("expected a refresh hint, got frame meta %+v", resp.Frames[0].Meta)
+	}
+	if hint.SuggestedInterval != "30m" {
+		t.Errorf("expected the most frequent schedule, got %q", hint.SuggestedInterval)
+	}
+	if len(hint.PreAggregations) != 2 || hint.LastUpdatedAt != 1690000000000 {
+		t.Errorf("unexpected hint %+v", hint)
+	}
+
+	// Cron schedules and results without pre-aggregations get no hint.
+	for _, query := range []string{
+		`{"refId": "A", "measures": ["orders.total"]}`,
+		`{"refId": "A", "dimensions": ["orders.status"]}`,
+	} {
+		resp := runSingleQuery(t, ds, pCtx, query)
+		if resp.Error != nil {
+			t.Fatalf("unexpected error: %v", resp.Error)
+		}
+		if meta := resp.Frames[0].Meta; meta != nil && meta.Custom != nil {
+			t.Errorf("%s: expected no refresh hint, got %+v", query, meta.Custom)
+		}
+	}
+
+	if n := systemRequests.Load(); n != 1 {
+		t.Errorf("expected the schedules to be fetched once, got %d requests", n)
+	}
+}
+
+func TestGetRefreshSchedulesDoesNotWaitForAFetch(t *testing.T) {
+	requested, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"preAggregations": [
+			{"cube": "orders", "preAggregationName": "main", "preAggregation": {"refreshKey": {"every": "1 hour"}}}]}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	config := &models.PluginSettings{DeploymentType: "self-hosted-dev"}
+	fetched := make(chan map[string]time.Duration)
+	go func() { fetched <- ds.getRefreshSchedules(context.Background(), config) }()
+	select {
+	case <-requested:
+	case every := <-fetched:
+		t.Fatalf("expected the schedules to be requested, got %v", every)
+	}
+
+	// The fetch in progress does not block other callers.
+	if every := ds.getRefreshSchedules(context.Background(), config); every != nil {
+		t.Errorf("expected no schedules while the first fetch runs, got %v", every)
+	}
+	close(release)
+	if every := <-fetched; every["orders_main"] != time.Hour {
+		t.Errorf("expected the fetched schedules, got %v", every)
+	}
+	if every := ds.getRefreshSchedules(context.Background(), config); every["orders_main"] != time.Hour {
+		t.Errorf("expected the cached schedules, got %v", every)
+	}
+}
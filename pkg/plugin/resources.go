@@ -1,13 +1,15 @@
 package plugin
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -28,6 +30,37 @@ type TagValue struct {
 type MetadataResponse struct {
 	Dimensions []SelectOption `json:"dimensions"`
 	Measures   []SelectOption `json:"measures"`
+	// DataSources lists the (labelled) Cube data sources the returned members
+	// belong to. Omitted when the model does not report data sources.
+	DataSources []string `json:"dataSources,omitempty"`
+	// Segments lists the views' segments as AdHoc filter keys
+	// ("segment:orders.completed"). Omitted when the views have none.
+	Segments []SelectOption `json:"segments,omitempty"`
+	// Folders is the folder tree of the views, and Hierarchies their
+	// hierarchies, so the query builder can group members the way the
+	// modeler organized them. Omitted when the views have none.
+	Folders     []MetadataFolder    `json:"folders,omitempty"`
+	Hierarchies []MetadataHierarchy `json:"hierarchies,omitempty"`
+	// Views lists the members nested under the view they belong to. Only
+	// returned with groupBy=view, so the editor can keep queries to one view
+	// and group members by view.
+	Views []MetadataView `json:"views,omitempty"`
+	// FilterOperators maps member types ("string", "number", "time",
+	// "boolean") to the filter operators Cube accepts for them, so the
+	// filter editor only offers valid operators.
+	FilterOperators map[string][]string `json:"filterOperators"`
+}
+
+// MetadataView is a view with its members, for the metadata response grouped
+// by view.
+type MetadataView struct {
+	Name        string         `json:"name"`
+	Title       string         `json:"title,omitempty"`
+	Description string         `json:"description,omitempty"`
+	DataSource  string         `json:"dataSource,omitempty"`
+	Dimensions  []SelectOption `json:"dimensions"`
+	Measures    []SelectOption `json:"measures"`
+	Segments    []SelectOption `json:"segments"`
 }
 
 // SelectOption represents an option for select components.
@@ -41,6 +74,21 @@ type SelectOption struct {
 	// Cube identifies the Cube view this field originates from. The visual
 	// query builder uses this as the curated query scope.
 	Cube string `json:"cube"`
+	// DataSource is the Cube data source (warehouse) the member's view reads
+	// from, mapped through the datasource's dataSourceLabels setting.
+	DataSource string `json:"dataSource,omitempty"`
+	// DrillMembers are the members that list the rows behind a measure's
+	// value, for drill-down actions.
+	DrillMembers []string `json:"drillMembers,omitempty"`
+	// Meta is the member's custom meta object from the data model.
+	Meta map[string]interface{} `json:"meta,omitempty"`
+	// Aggregation is how a measure aggregates ("count", "sum", "avg",
+	// "countDistinct", ...), and RollingWindow the window a cumulative
+	// measure aggregates over, so the editor can explain what a measure
+	// computes.
+	Aggregation   string             `json:"aggregation,omitempty"`
+	Cumulative    bool               `json:"cumulative,omitempty"`
+	RollingWindow *CubeRollingWindow `json:"rollingWindow,omitempty"`
 }
 
 // ModelFile represents a data model file from Cube
@@ -61,9 +109,27 @@ type DbSchemaResponse struct {
 
 // GenerateSchemaRequest represents the request for the generate-schema endpoint
 type GenerateSchemaRequest struct {
+	// Format is the model file format, one of generateSchemaFormats; Cube
+	// picks its default when empty.
 	Format       string                 `json:"format"`
 	Tables       [][]string             `json:"tables"`
 	TablesSchema map[string]interface{} `json:"tablesSchema"`
+	// DataSource is the Cube data source the tables belong to, for
+	// deployments with several; empty means the default data source.
+	DataSource string `json:"dataSource,omitempty"`
+}
+
+// generateSchemaFormats are the model file formats the Cube playground
+// generates.
+var generateSchemaFormats = []string{"yaml", "js"}
+
+// validateGenerateSchemaRequest checks a generate-schema request before it is
+// sent to Cube.
+func validateGenerateSchemaRequest(r GenerateSchemaRequest) error {
+	if r.Format != "" && !slices.Contains(generateSchemaFormats, r.Format) {
+		return fmt.Errorf("invalid format %q (must be one of: %s)", r.Format, strings.Join(generateSchemaFormats, ", "))
+	}
+	return nil
 }
 
 // GenerateSchemaResponse represents the response for the generate-schema endpoint
@@ -86,14 +152,61 @@ type CubeSQLResponse struct {
 
 // CallResource handles resource calls for AdHoc filtering
 func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	ctx = withCorrelationID(ctx)
+	defer d.trackRequest()()
+	if rateLimitedResources[req.Path] {
+		if res := d.rateLimitResponse(req); res != nil {
+			return sender.Send(res)
+		}
+	}
 	switch req.Path {
+	case "tag-keys":
+		return d.handleTagKeys(ctx, req, sender)
 	case "tag-values":
 		return d.handleTagValues(ctx, req, sender)
+	case "tag-values-bulk":
+		return d.handleTagValuesBulk(ctx, req, sender)
+	case "variable-values":
+		return d.handleVariableValues(ctx, req, sender)
 	case "sql":
 		return d.handleSQLCompilation(ctx, req, sender)
+	case "dry-run":
+		return d.handleDryRun(ctx, req, sender)
+	case "export":
+		return d.handleExport(ctx, req, sender)
+	case "page":
+		return d.handlePage(ctx, req, sender)
+	case "pre-aggregations":
+		return d.handlePreAggregations(ctx, req, sender)
+	case "pre-aggregations/preview":
+		return d.handlePreAggregationPreview(ctx, req, sender)
+	case "explain":
+		return d.handleExplain(ctx, req, sender)
 	case "metadata":
 		return d.handleMetadata(ctx, req, sender)
+	case "members":
+		return d.handleMembers(ctx, req, sender)
+	case "range":
+		return d.handleRange(ctx, req, sender)
+	case "cardinality":
+		return d.handleCardinality(ctx, req, sender)
+	case "model-graph":
+		return d.handleModelGraph(ctx, req, sender)
+	case "metadata/refresh":
+		return d.handleMetadataRefresh(ctx, req, sender)
+	case "capabilities":
+		return d.handleCapabilities(ctx, req, sender)
+	case "health":
+		return d.handleHealth(ctx, req, sender)
+	case "diagnostics":
+		return d.handleDiagnostics(ctx, req, sender)
 	case "model-files":
+		if req.Method == http.MethodPost {
+			if !isAdmin(req) {
+				return sender.Send(accessDeniedResponse())
+			}
+			return d.handleSaveModelFiles(ctx, req, sender)
+		}
 		return d.handleModelFiles(ctx, req, sender)
 	case "db-schema":
 		return d.handleDbSchema(ctx, req, sender)
@@ -139,22 +252,53 @@ func jsonErrorResponse(status int, err error) *backend.CallResourceResponse {
 	}
 }
 
-// handleMetadata returns dimensions and measures for the query builder
+// handleMetadataRefresh drops the cached Cube metadata, e.g. after the data
+// model was changed, so the next metadata request sees the current model.
+func (d *Datasource) handleMetadataRefresh(_ context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if req.Method != "POST" {
+		return sender.Send(jsonErrorResponse(405, errors.New("method not allowed")))
+	}
+	d.invalidateMetadata()
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   []byte(`{"refreshed":true}`),
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
+
+// handleMetadata returns dimensions and measures for the query builder.
+// With one or more cube parameters (metadata?cube=orders) only the members of
+// those views are returned. Cube has no per-view meta endpoint, so the model
+// is still fetched whole, but only once per metaCacheTTL and revalidated with
+// a conditional request after that, and each view is sent on its own.
 func (d *Datasource) handleMetadata(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	// Fetch metadata from Cube API
-	metaResponse, err := d.fetchCubeMetadata(ctx, req.PluginContext)
+	metaResponse, err := d.getCubeMetadata(ctx, req.PluginContext)
 	if err != nil {
-		backend.Logger.Error("Failed to fetch cube metadata", "error", err)
+		backend.Logger.FromContext(ctx).Error("Failed to fetch cube metadata", "error", err)
 		return sender.Send(jsonErrorResponse(500, errors.New("failed to fetch metadata from Cube API")))
 	}
 
+	opts, err := d.metadataOptionsFromRequest(req)
+	if errors.Is(err, errHiddenMembersAdminOnly) {
+		return sender.Send(accessDeniedResponse())
+	}
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	if name, ok := unknownView(metaResponse, opts); !ok {
+		return sender.Send(jsonErrorResponse(404, fmt.Errorf("view %q not found in the Cube model", name)))
+	}
+
 	// Extract dimensions and measures from metadata
-	metadata := d.extractMetadataFromResponse(metaResponse)
+	metadata := d.extractMetadata(metaResponse, opts)
 
 	// Marshal response
 	body, err := json.Marshal(metadata)
 	if err != nil {
-		backend.Logger.Error("Failed to marshal metadata response", "error", err)
+		backend.Logger.FromContext(ctx).Error("Failed to marshal metadata response", "error", err)
 		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
 	}
 
@@ -167,146 +311,356 @@ func (d *Datasource) handleMetadata(ctx context.Context, req *backend.CallResour
 	})
 }
 
-// extractMetadataFromResponse extracts dimensions and measures from views only.
+// metadataOptions controls how a /v1/meta response is turned into a
+// MetadataResponse.
+type metadataOptions struct {
+	// dataSourceLabels maps Cube data source names to display labels.
+	dataSourceLabels map[string]string
+	// dataSource, when set, keeps only views whose data source name or label
+	// matches.
+	dataSource string
+	// cubes, when set, keeps only the named views, so the query editor of a
+	// large model can load the members of the views it shows on demand.
+	cubes []string
+	// groupByView also returns the members nested under their view.
+	groupByView bool
+	// includeCubes also returns the members of raw cubes, for users who
+	// query cubes directly.
+	includeCubes bool
+	// includeHidden also returns the views and members the model hides
+	// (public: false or isVisible: false). Admin-only.
+	includeHidden bool
+	// search, when set, keeps only the members whose name or title contains
+	// it (case-insensitive), so large models are not shipped wholesale.
+	search string
+	// allowedViews, when set, keeps only these views (the allowedViews
+	// setting).
+	allowedViews []string
+}
+
+// errHiddenMembersAdminOnly is returned when a user who is not an admin asks
+// for hidden members.
+var errHiddenMembersAdminOnly = errors.New("only admins can include hidden members")
+
+// metadataOptionsFromRequest builds metadataOptions from the datasource
+// settings and the metadata resource query parameters.
+func (d *Datasource) metadataOptionsFromRequest(req *backend.CallResourceRequest) (metadataOptions, error) {
+	var opts metadataOptions
+
+	if req.PluginContext.DataSourceInstanceSettings != nil {
+		config, err := d.loadSettings(*req.PluginContext.DataSourceInstanceSettings)
+		if err != nil {
+			return opts, fmt.Errorf("failed to load plugin settings: %w", err)
+		}
+		opts.dataSourceLabels = config.DataSourceLabels
+		opts.allowedViews = config.AllowedViews
+	}
+
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return opts, errors.New("invalid URL")
+	}
+	opts.dataSource = parsedURL.Query().Get("dataSource")
+	opts.search = strings.TrimSpace(parsedURL.Query().Get("search"))
+	for _, param := range parsedURL.Query()["include"] {
+		for _, include := range strings.Split(param, ",") {
+			switch include = strings.TrimSpace(include); include {
+			case "":
+			case "cubes":
+				opts.includeCubes = true
+			default:
+				return opts, fmt.Errorf("invalid include %q (must be \"cubes\")", include)
+			}
+		}
+	}
+	if includeHidden := parsedURL.Query().Get("includeHidden"); includeHidden != "" {
+		if opts.includeHidden, err = strconv.ParseBool(includeHidden); err != nil {
+			return opts, fmt.Errorf("invalid includeHidden %q", includeHidden)
+		}
+		if opts.includeHidden && !isAdmin(req) {
+			return opts, errHiddenMembersAdminOnly
+		}
+	}
+	switch groupBy := parsedURL.Query().Get("groupBy"); groupBy {
+	case "":
+	case "view":
+		opts.groupByView = true
+	default:
+		return opts, fmt.Errorf("invalid groupBy %q (must be \"view\")", groupBy)
+	}
+	for _, param := range parsedURL.Query()["cube"] {
+		for _, name := range strings.Split(param, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				opts.cubes = append(opts.cubes, name)
+			}
+		}
+	}
+
+	return opts, nil
+}
+
+// dataSourceLabel returns the display label for a Cube data source.
+func (o metadataOptions) dataSourceLabel(dataSource string) string {
+	if label, ok := o.dataSourceLabels[dataSource]; ok {
+		return label
+	}
+	return dataSource
+}
+
+// viewAllowed reports whether the allowedViews setting allows a view.
+func (o metadataOptions) viewAllowed(name string) bool {
+	return len(o.allowedViews) == 0 || slices.Contains(o.allowedViews, name)
+}
+
+// unknownView returns the first of the requested views (opts.cubes) that is
+// not a view of the model (or a cube, when includeCubes is set) or not
+// allowed, and false, or "" and true when they all are.
+func unknownView(metaResponse *CubeMetaResponse, opts metadataOptions) (string, bool) {
+	for _, name := range opts.cubes {
+		if !opts.viewAllowed(name) || !slices.ContainsFunc(metaResponse.Cubes, func(item CubeMeta) bool {
+			return (item.Type == "view" || opts.includeCubes) && item.Name == name
+		}) {
+			return name, false
+		}
+	}
+	return "", true
+}
+
+// extractMetadataFromResponse extracts dimensions and measures from views
+// using default options.
+func (d *Datasource) extractMetadataFromResponse(metaResponse *CubeMetaResponse) MetadataResponse {
+	return d.extractMetadata(metaResponse, metadataOptions{})
+}
+
+// extractMetadata extracts dimensions and measures from views only.
 // Cubes are implementation details; views are the public API for the visual
 // query builder. If no views are defined, return empty arrays so the UI can
-// explain that views are required instead of exposing raw cubes.
-func (d *Datasource) extractMetadataFromResponse(metaResponse *CubeMetaResponse) MetadataResponse {
+// explain that views are required instead of exposing raw cubes. Advanced
+// users querying cubes directly opt into their members with includeCubes.
+func (d *Datasource) extractMetadata(metaResponse *CubeMetaResponse, opts metadataOptions) MetadataResponse {
 	dimensions := make([]SelectOption, 0)
 	measures := make([]SelectOption, 0)
 
 	processedDimensions := make(map[string]bool)
 	processedMeasures := make(map[string]bool)
 
+	var dataSources []string
+	var segments []SelectOption
+	var folders []MetadataFolder
+	var hierarchies []MetadataHierarchy
+	var views []MetadataView
+
 	viewCount := 0
 	for _, item := range metaResponse.Cubes {
-		if item.Type != "view" {
+		if item.Type != "view" && !opts.includeCubes {
+			continue
+		}
+		if !opts.viewAllowed(item.Name) {
 			continue
 		}
+		if !opts.includeHidden {
+			if !isVisible(item.IsVisible, item.Public) {
+				continue
+			}
+			item = visibleMembers(item)
+		}
+		if opts.search != "" {
+			item = matchingMembers(item, opts.search)
+			if len(item.Dimensions)+len(item.Measures)+len(item.Segments) == 0 {
+				continue
+			}
+		}
+
+		dataSource := ""
+		if item.DataSource != "" {
+			dataSource = opts.dataSourceLabel(item.DataSource)
+		}
+		if opts.dataSource != "" && opts.dataSource != item.DataSource && opts.dataSource != dataSource {
+			continue
+		}
+		if len(opts.cubes) > 0 && !slices.Contains(opts.cubes, item.Name) {
+			continue
+		}
+		if dataSource != "" && !slices.Contains(dataSources, dataSource) {
+			dataSources = append(dataSources, dataSource)
+		}
 		viewCount++
 
-		for _, dimension := range item.Dimensions {
-			if !processedDimensions[dimension.Name] {
-				dimensions = append(dimensions, SelectOption{
-					Label:       dimension.Name,
-					Value:       dimension.Name,
-					Type:        dimension.Type,
-					Description: dimension.Description,
-					Cube:        item.Name,
-				})
-				processedDimensions[dimension.Name] = true
+		viewDimensions := dimensionOptions(item, dataSource)
+		for _, dimension := range viewDimensions {
+			if !processedDimensions[dimension.Value] {
+				dimensions = append(dimensions, dimension)
+				processedDimensions[dimension.Value] = true
 			}
 		}
 
-		for _, measure := range item.Measures {
-			if !processedMeasures[measure.Name] {
-				measures = append(measures, SelectOption{
-					Label:       measure.Name,
-					Value:       measure.Name,
-					Type:        measure.Type,
-					Description: measure.Description,
-					Cube:        item.Name,
-				})
-				processedMeasures[measure.Name] = true
+		viewMeasures := measureOptions(item, dataSource)
+		for _, measure := range viewMeasures {
+			if !processedMeasures[measure.Value] {
+				measures = append(measures, measure)
+				processedMeasures[measure.Value] = true
 			}
 		}
+
+		viewSegments := segmentOptions(item, dataSource)
+		for _, segment := range viewSegments {
+			if !slices.ContainsFunc(segments, func(o SelectOption) bool { return o.Value == segment.Value }) {
+				segments = append(segments, segment)
+			}
+		}
+
+		if opts.groupByView {
+			views = append(views, MetadataView{
+				Name:        item.Name,
+				Title:       item.Title,
+				Description: item.Description,
+				DataSource:  dataSource,
+				Dimensions:  viewDimensions,
+				Measures:    viewMeasures,
+				Segments:    viewSegments,
+			})
+		}
+
+		folders = append(folders, viewFolders(item)...)
+		hierarchies = append(hierarchies, viewHierarchies(item)...)
 	}
 
 	backend.Logger.Debug("Extracted metadata from views", "views", viewCount, "dimensions", len(dimensions), "measures", len(measures))
 
 	return MetadataResponse{
-		Dimensions: dimensions,
-		Measures:   measures,
+		Dimensions:  dimensions,
+		Measures:    measures,
+		DataSources: dataSources,
+		Segments:    segments,
+		Folders:     folders,
+		Hierarchies: hierarchies,
+		Views:       views,
+
+		FilterOperators: filterOperators,
 	}
 }
 
-// handleTagValues returns available tag values for a given tag key (dimension)
-// It queries the Cube /v1/load endpoint with just the dimension to get distinct values
-func (d *Datasource) handleTagValues(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
-	// Parse the URL to get the key parameter
-	parsedURL, err := url.Parse(req.URL)
-	if err != nil {
-		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
-	}
+// isVisible reports whether a view or member is visible, from its isVisible
+// and public flags. Members are visible unless a flag says otherwise.
+func isVisible(isVisible, public *bool) bool {
+	return (isVisible == nil || *isVisible) && (public == nil || *public)
+}
 
-	key := parsedURL.Query().Get("key")
-	if key == "" {
-		return sender.Send(jsonErrorResponse(400, errors.New("key parameter is required")))
+// visibleMembers returns view without the members the model hides.
+func visibleMembers(view CubeMeta) CubeMeta {
+	view.Dimensions = slices.DeleteFunc(slices.Clone(view.Dimensions), func(m CubeDimension) bool { return !isVisible(m.IsVisible, m.Public) })
+	view.Measures = slices.DeleteFunc(slices.Clone(view.Measures), func(m CubeMeasure) bool { return !isVisible(m.IsVisible, m.Public) })
+	view.Segments = slices.DeleteFunc(slices.Clone(view.Segments), func(m CubeSegment) bool { return !isVisible(m.IsVisible, m.Public) })
+	return view
+}
+
+// matchingMembers returns view with only the members whose name or title
+// contains search, ignoring case.
+func matchingMembers(view CubeMeta, search string) CubeMeta {
+	search = strings.ToLower(search)
+	matches := func(name, title string) bool {
+		return strings.Contains(strings.ToLower(name), search) || strings.Contains(strings.ToLower(title), search)
+	}
+	view.Dimensions = slices.DeleteFunc(slices.Clone(view.Dimensions), func(m CubeDimension) bool { return !matches(m.Name, m.Title) })
+	view.Measures = slices.DeleteFunc(slices.Clone(view.Measures), func(m CubeMeasure) bool { return !matches(m.Name, m.Title) })
+	view.Segments = slices.DeleteFunc(slices.Clone(view.Segments), func(m CubeSegment) bool { return !matches(m.Name, m.Title) })
+	return view
+}
+
+// dimensionOptions returns the dimensions of a view as select options.
+func dimensionOptions(view CubeMeta, dataSource string) []SelectOption {
+	options := make([]SelectOption, 0, len(view.Dimensions))
+	for _, dimension := range view.Dimensions {
+		options = append(options, SelectOption{
+			Label:       dimension.Name,
+			Value:       dimension.Name,
+			Type:        dimension.Type,
+			Description: dimension.Description,
+			Cube:        view.Name,
+			DataSource:  dataSource,
+			Meta:        dimension.Meta,
+		})
 	}
+	return options
+}
 
-	// Build a Cube query to get distinct values for this dimension
-	cubeQuery := map[string]interface{}{
-		"dimensions": []string{key},
-		"limit":      10000, // Limit for tag value suggestions
+// measureOptions returns the measures of a view as select options.
+func measureOptions(view CubeMeta, dataSource string) []SelectOption {
+	options := make([]SelectOption, 0, len(view.Measures))
+	for _, measure := range view.Measures {
+		options = append(options, SelectOption{
+			Label:         measure.Name,
+			Value:         measure.Name,
+			Type:          measure.Type,
+			Description:   measure.Description,
+			Cube:          view.Name,
+			DataSource:    dataSource,
+			DrillMembers:  measure.DrillMembers,
+			Meta:          measure.Meta,
+			Aggregation:   measure.AggType,
+			Cumulative:    measure.Cumulative || measure.RollingWindow != nil,
+			RollingWindow: measure.RollingWindow,
+		})
 	}
+	return options
+}
 
-	// Parse existing filters to scope the results (like Prometheus does)
-	filtersJSON := parsedURL.Query().Get("filters")
+// tagValueFilters parses the filters scoping tag values (like Prometheus
+// does) and adds the datasource's default filters, so tag values never suggest
+// values the dashboards cannot query. Scoping filters on segment keys are
+// returned as segments. Invalid scoping filters are ignored.
+func (d *Datasource) tagValueFilters(ctx context.Context, pCtx backend.PluginContext, filtersJSON string) ([]interface{}, []string) {
+	var filters []interface{}
 	if filtersJSON != "" {
 		var scopingFilters []map[string]interface{}
 		if err := json.Unmarshal([]byte(filtersJSON), &scopingFilters); err != nil {
-			backend.Logger.Warn("Failed to parse scoping filters, ignoring", "error", err)
+			backend.Logger.FromContext(ctx).Warn("Failed to parse scoping filters, ignoring", "error", err)
 		} else if len(scopingFilters) > 0 {
-			cubeQuery["filters"] = scopingFilters
-			backend.Logger.Debug("Scoping tag values with existing filters", "filters", scopingFilters)
+			for _, filter := range scopingFilters {
+				filters = append(filters, filter)
+			}
+			backend.Logger.FromContext(ctx).Debug("Scoping tag values with existing filters", "filters", scopingFilters)
 		}
 	}
+	filters = withDefaultFilters(filters, d.defaultFilters(pCtx))
 
-	cubeQueryJSON, err := json.Marshal(cubeQuery)
+	kept, segments, err := extractSegmentFilters(filters)
 	if err != nil {
-		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal query")))
+		backend.Logger.FromContext(ctx).Warn("Failed to parse scoping filters, ignoring", "error", err)
+		return nil, nil
 	}
+	return kept, segments
+}
 
-	// Build API URL
-	apiReq, err := d.buildAPIURL(req.PluginContext, "load")
-	if err != nil {
-		backend.Logger.Error("Failed to build API URL for tag values", "error", err)
-		return sender.Send(jsonErrorResponse(500, fmt.Errorf("failed to build API URL: %w", err)))
+// tagValuesQuery builds the Cube query listing the distinct values of a
+// dimension.
+func tagValuesQuery(key string, filters []interface{}, segments []string) ([]byte, error) {
+	cubeQuery := map[string]interface{}{
+		"dimensions": []string{key},
+		"limit":      10000, // Limit for tag value suggestions
 	}
-
-	// Use shared helper to make the request with "Continue wait" polling.
-	// The helper picks GET or POST based on the encoded query size.
-	body, err := d.doCubeLoadRequest(ctx, apiReq.URL.String(), cubeQueryJSON, apiReq.Config)
-	if err != nil {
-		backend.Logger.Error("Failed to fetch tag values from Cube API", "error", err)
-		// If this is a Cube API error (non-200), forward the original status code and body
-		var cubeErr *CubeAPIError
-		if errors.As(err, &cubeErr) {
-			return sender.Send(&backend.CallResourceResponse{
-				Status: cubeErr.StatusCode,
-				Body:   cubeErr.Body,
-				Headers: map[string][]string{
-					"Content-Type": {"application/json"},
-				},
-			})
-		}
-		// For other errors (timeouts, network, etc.), return 500 with safely encoded JSON
-		return sender.Send(jsonErrorResponse(500, err))
+	if len(filters) > 0 {
+		cubeQuery["filters"] = filters
 	}
-
-	// Parse the Cube API response
-	var apiResponse CubeAPIResponse
-	if err := json.Unmarshal(body, &apiResponse); err != nil {
-		backend.Logger.Error("Failed to parse Cube API response for tag values", "error", err, "body", string(body))
-		return sender.Send(jsonErrorResponse(500, errors.New("failed to parse API response")))
+	if len(segments) > 0 {
+		cubeQuery["segments"] = segments
 	}
+	return json.Marshal(cubeQuery)
+}
 
-	// Extract unique values from the response data
-	// Response format for Grafana: [{ "text": "value1" }, { "text": "value2" }]
+// tagValuesFromRows extracts the unique values of key from Cube result rows,
+// in the format Grafana expects: [{ "text": "value1" }, { "text": "value2" }]
+func tagValuesFromRows(rows []map[string]interface{}, key string) []TagValue {
 	tagValues := []TagValue{}
 	seen := make(map[string]bool)
 
-	for _, row := range apiResponse.Data {
+	for _, row := range rows {
 		if value, ok := row[key]; ok && value != nil {
 			// Convert value to string
 			var strValue string
 			switch v := value.(type) {
 			case string:
 				strValue = v
-			case float64:
-				strValue = fmt.Sprintf("%v", v)
-			case bool:
-				strValue = fmt.Sprintf("%v", v)
 			default:
 				strValue = fmt.Sprintf("%v", v)
 			}
@@ -318,9 +672,147 @@ func (d *Datasource) handleTagValues(ctx context.Context, req *backend.CallResou
 			}
 		}
 	}
+	return tagValues
+}
 
-	// Marshal response
-	responseBody, err := json.Marshal(tagValues)
+// handleTagKeys returns the AdHoc filter keys: the dimensions and segments
+// ("segment:<name>") of the views, optionally scoped to the views named by
+// the view parameter.
+func (d *Datasource) handleTagKeys(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	opts, err := d.metadataOptionsFromRequest(req)
+	if errors.Is(err, errHiddenMembersAdminOnly) {
+		return sender.Send(accessDeniedResponse())
+	}
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	parsedURL, _ := url.Parse(req.URL)
+	for _, param := range parsedURL.Query()["view"] {
+		for _, name := range strings.Split(param, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				opts.cubes = append(opts.cubes, name)
+			}
+		}
+	}
+
+	metaResponse, err := d.getCubeMetadata(ctx, req.PluginContext)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to fetch cube metadata", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to fetch metadata from Cube API")))
+	}
+	if name, ok := unknownView(metaResponse, opts); !ok {
+		return sender.Send(jsonErrorResponse(404, fmt.Errorf("view %q not found in the Cube model", name)))
+	}
+
+	metadata := d.extractMetadata(metaResponse, opts)
+	tagKeys := make([]TagKey, 0, len(metadata.Dimensions)+len(metadata.Segments))
+	for _, option := range slices.Concat(metadata.Dimensions, metadata.Segments) {
+		tagKeys = append(tagKeys, TagKey{Text: option.Label, Value: option.Value})
+	}
+
+	body, err := json.Marshal(tagKeys)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to marshal tag keys response", "error", err)
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   body,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
+
+// handleTagValues returns available tag values for a given tag key (dimension)
+// It queries the Cube /v1/load endpoint with just the dimension to get distinct values.
+// Time dimensions get date range presets and their first and last dates
+// instead; see timeTagValues.
+func (d *Datasource) handleTagValues(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	// Parse the URL to get the key parameter
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
+	}
+
+	key := parsedURL.Query().Get("key")
+	if key == "" {
+		return sender.Send(jsonErrorResponse(400, errors.New("key parameter is required")))
+	}
+
+	if _, ok := segmentFromKey(key); ok {
+		responseBody, err := json.Marshal(segmentTagValues)
+		if err != nil {
+			return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+		}
+		return sender.Send(&backend.CallResourceResponse{
+			Status: 200,
+			Body:   responseBody,
+			Headers: map[string][]string{
+				"Content-Type": {"application/json"},
+			},
+		})
+	}
+
+	filters, segments := d.tagValueFilters(ctx, req.PluginContext, parsedURL.Query().Get("filters"))
+	if err := checkAllowedMembers(d.pluginSettings(req.PluginContext), tagValuesMembers(key, filters, segments)); err != nil {
+		return sender.Send(jsonErrorResponse(http.StatusForbidden, err))
+	}
+	cubeQueryJSON, err := tagValuesQuery(key, filters, segments)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal query")))
+	}
+
+	// Build API URL
+	apiReq, err := d.buildAPIURL(req.PluginContext, "load")
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to build API URL for tag values", "error", err)
+		return sender.Send(jsonErrorResponse(500, fmt.Errorf("failed to build API URL: %w", err)))
+	}
+	if d.isTimeDimension(ctx, req.PluginContext, key) {
+		values, err := d.timeTagValues(ctx, req.PluginContext, apiReq, key, filters, segments)
+		if err != nil {
+			backend.Logger.FromContext(ctx).Error("Failed to fetch time tag values from Cube API", "error", err)
+			return sender.Send(cubeLoadErrorResponse(err))
+		}
+		return sendTagValues(values, sender)
+	}
+	if cubeQueryJSON, err = rewriteQueryJSON(cubeQueryJSON, req.PluginContext, apiReq.Config); err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+
+	if values, ok := d.cachedTagValues(cubeQueryJSON, apiReq.Config); ok {
+		return sendTagValues(values, sender)
+	}
+
+	// Use shared helper to make the request with "Continue wait" polling.
+	// The helper picks GET or POST based on the encoded query size.
+	body, err := d.doCubeLoadRequest(ctx, apiReq.URL.String(), cubeQueryJSON, apiReq.Config)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to fetch tag values from Cube API", "error", err)
+		return sender.Send(cubeLoadErrorResponse(err))
+	}
+
+	// Parse the Cube API response
+	apiResponse, err := decodeLoadResult(body)
+	if err != nil {
+		var reqErr *loadRequestError
+		if errors.As(err, &reqErr) {
+			return sender.Send(jsonErrorResponse(http.StatusBadRequest, err))
+		}
+		backend.Logger.FromContext(ctx).Error("Failed to parse Cube API response for tag values", "error", err, "body", string(body))
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to parse API response")))
+	}
+
+	values := tagValuesFromRows(apiResponse.Data, key)
+	d.cacheTagValues(cubeQueryJSON, values, apiReq.Config)
+	return sendTagValues(values, sender)
+}
+
+// sendTagValues sends tag values as the tag-values response.
+func sendTagValues(values []TagValue, sender backend.CallResourceResponseSender) error {
+	responseBody, err := json.Marshal(values)
 	if err != nil {
 		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
 	}
@@ -334,7 +826,37 @@ func (d *Datasource) handleTagValues(ctx context.Context, req *backend.CallResou
 	})
 }
 
-// handleSQLCompilation compiles a Cube query to SQL using Cube's /v1/sql endpoint
+// cubeLoadErrorResponse converts an error from doCubeLoadRequest into a
+// resource response. Cube API errors (non-200) are forwarded with the original
+// status code and body.
+func cubeLoadErrorResponse(err error) *backend.CallResourceResponse {
+	var cubeErr *CubeAPIError
+	if errors.As(err, &cubeErr) {
+		if cubeErr.nonJSON() != nil {
+			// Don't forward a proxy's HTML error page as JSON.
+			return jsonErrorResponse(cubeErr.StatusCode, err)
+		}
+		return &backend.CallResourceResponse{
+			Status: cubeErr.StatusCode,
+			Body:   cubeErr.Body,
+			Headers: map[string][]string{
+				"Content-Type": {"application/json"},
+			},
+		}
+	}
+	var nonJSONErr *nonJSONResponseError
+	if errors.As(err, &nonJSONErr) {
+		return jsonErrorResponse(http.StatusBadGateway, err)
+	}
+	// For other errors (timeouts, network, etc.), return 500 with safely encoded JSON
+	return jsonErrorResponse(500, err)
+}
+
+// handleSQLCompilation compiles a Cube query to SQL using Cube's /v1/sql endpoint.
+// It returns the SQL with its bind parameters and, with preAggregations=true,
+// the pre-aggregations the query reads from and the SQL that builds them.
+// The query is a query URL parameter, or for large queries the query field of
+// a POST body ({"query": {...}}).
 func (d *Datasource) handleSQLCompilation(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	// Parse the URL to get query parameters
 	parsedURL, err := url.Parse(req.URL)
@@ -342,8 +864,18 @@ func (d *Datasource) handleSQLCompilation(ctx context.Context, req *backend.Call
 		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
 	}
 
-	// Get the query from URL parameters
+	// Get the query from the URL parameters, or from the body of a POST
+	// request for queries too large for a URL
 	queryParam := parsedURL.Query().Get("query")
+	if req.Method == http.MethodPost {
+		var body struct {
+			Query json.RawMessage `json:"query"`
+		}
+		if err := json.Unmarshal(req.Body, &body); err != nil {
+			return sender.Send(jsonErrorResponse(400, errors.New("invalid request body")))
+		}
+		queryParam = string(body.Query)
+	}
 	if queryParam == "" {
 		return sender.Send(jsonErrorResponse(400, errors.New("query parameter is required")))
 	}
@@ -354,18 +886,44 @@ func (d *Datasource) handleSQLCompilation(ctx context.Context, req *backend.Call
 		return sender.Send(jsonErrorResponse(400, errors.New("invalid query JSON")))
 	}
 
+	// Compile the query as it will run, with the default filters and
+	// rewrite rules applied
+	if !cubeQuery.IgnoreDefaultFilters {
+		if queryParam, err = withDefaultFiltersJSON(queryParam, d.defaultFilters(req.PluginContext)); err != nil {
+			return sender.Send(jsonErrorResponse(400, errors.New("invalid query JSON")))
+		}
+	}
+	if queryParam, err = withSegmentFiltersJSON(queryParam); err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+	config := d.pluginSettings(req.PluginContext)
+	if err := checkAllowedQueryJSON(config, queryParam); err != nil {
+		return sender.Send(jsonErrorResponse(403, err))
+	}
+	queryJSON, err := rewriteQueryJSON([]byte(queryParam), req.PluginContext, config)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+
 	// Fetch SQL from Cube API
-	sqlString, err := d.fetchCubeSQL(ctx, req.PluginContext, queryParam)
+	body, err := d.fetchCubeSQLBody(ctx, req.PluginContext, string(queryJSON))
 	if err != nil {
-		backend.Logger.Error("Failed to fetch SQL from Cube", "error", err)
+		backend.Logger.FromContext(ctx).Error("Failed to fetch SQL from Cube", "error", err)
+		return sender.Send(jsonErrorResponse(500, err))
+	}
+	var plan cubeSQLPlan
+	if err := json.Unmarshal(body, &plan); err != nil {
+		return sender.Send(jsonErrorResponse(500, fmt.Errorf("failed to parse API response: %w", err)))
+	}
+	sqlJSON, err := compiledSQLFromPlan(plan, parsedURL.Query().Get("preAggregations") == "true")
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to fetch SQL from Cube", "error", err)
 		return sender.Send(jsonErrorResponse(500, err))
 	}
 
-	// Return the SQL string
-	sqlJSON := map[string]string{"sql": sqlString}
 	responseBody, err := json.Marshal(sqlJSON)
 	if err != nil {
-		backend.Logger.Error("Failed to marshal SQL response", "error", err)
+		backend.Logger.FromContext(ctx).Error("Failed to marshal SQL response", "error", err)
 		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
 	}
 
@@ -378,76 +936,147 @@ func (d *Datasource) handleSQLCompilation(ctx context.Context, req *backend.Call
 	})
 }
 
+// CompiledSQL is the sql resource response: the SQL of a query with its bind
+// parameters.
+type CompiledSQL struct {
+	SQL string `json:"sql"`
+	// Params are the values of the SQL's placeholders, in order.
+	Params []interface{} `json:"params"`
+	// PreAggregations are the pre-aggregations the query reads from, with
+	// the SQL that builds them. Only returned with preAggregations=true.
+	PreAggregations []CompiledPreAggregation `json:"preAggregations,omitempty"`
+}
+
+// CompiledPreAggregation is a pre-aggregation a compiled query reads from.
+type CompiledPreAggregation struct {
+	PreAggregationUsage
+	// LoadSQL and LoadParams build the pre-aggregation table; omitted when
+	// Cube does not report them.
+	LoadSQL    string        `json:"loadSql,omitempty"`
+	LoadParams []interface{} `json:"loadParams,omitempty"`
+}
+
+// compiledSQLFromPlan builds the sql resource response of a /v1/sql response.
+func compiledSQLFromPlan(plan cubeSQLPlan, withPreAggregations bool) (CompiledSQL, error) {
+	sql, params, err := sqlWithParams(plan.SQL.SQL)
+	if err != nil {
+		return CompiledSQL{}, err
+	}
+	compiled := CompiledSQL{SQL: sql, Params: params}
+	if !withPreAggregations {
+		return compiled, nil
+	}
+	compiled.PreAggregations = make([]CompiledPreAggregation, 0, len(plan.SQL.PreAggregations))
+	for _, p := range plan.SQL.PreAggregations {
+		pre := CompiledPreAggregation{PreAggregationUsage: PreAggregationUsage{
+			ID:        p.PreAggregationID,
+			TableName: p.TableName,
+			Type:      p.Type,
+			External:  p.External || plan.SQL.External,
+		}}
+		if len(p.LoadSQL) > 0 {
+			if pre.LoadSQL, pre.LoadParams, err = sqlWithParams(p.LoadSQL); err != nil {
+				return CompiledSQL{}, err
+			}
+		}
+		compiled.PreAggregations = append(compiled.PreAggregations, pre)
+	}
+	return compiled, nil
+}
+
+// sqlWithParams splits Cube's [sqlString, parameters] pair. A missing
+// parameters array is returned as an empty one.
+func sqlWithParams(pair []interface{}) (string, []interface{}, error) {
+	if len(pair) == 0 {
+		return "", nil, fmt.Errorf("SQL array is empty")
+	}
+	sql, ok := pair[0].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("SQL response is not a string")
+	}
+	params := []interface{}{}
+	if len(pair) > 1 {
+		if p, ok := pair[1].([]interface{}); ok {
+			params = p
+		}
+	}
+	return sql, params, nil
+}
+
 // fetchCubeSQL compiles a Cube query to SQL using Cube's /v1/sql endpoint
 func (d *Datasource) fetchCubeSQL(ctx context.Context, pluginContext backend.PluginContext, query string) (string, error) {
+	body, err := d.fetchCubeSQLBody(ctx, pluginContext, query)
+	if err != nil {
+		return "", err
+	}
+
+	// Parse the SQL API response
+	var sqlResponse CubeSQLResponse
+	if err := json.Unmarshal(body, &sqlResponse); err != nil {
+		return "", fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	// Extract SQL string from nested structure: response.sql.sql[0]
+	sql, _, err := sqlWithParams(sqlResponse.SQL.SQL)
+	return sql, err
+}
+
+// fetchCubeSQLBody sends a query to Cube's /v1/sql endpoint and returns the
+// response body.
+func (d *Datasource) fetchCubeSQLBody(ctx context.Context, pluginContext backend.PluginContext, query string) ([]byte, error) {
 	// Build API URL and load configuration
 	apiReq, err := d.buildAPIURL(pluginContext, "sql")
 	if err != nil {
-		return "", fmt.Errorf("failed to build API URL: %w", err)
+		return nil, fmt.Errorf("failed to build API URL: %w", err)
 	}
 
+	ctx, cancel := withTimeout(ctx, apiReq.Config.QueryTimeoutDuration())
+	defer cancel()
+
 	// Add query parameter
 	u, err := url.Parse(apiReq.URL.String())
 	if err != nil {
-		return "", fmt.Errorf("failed to parse API URL: %w", err)
+		return nil, fmt.Errorf("failed to parse API URL: %w", err)
 	}
 
 	params := url.Values{}
 	params.Add("query", query)
 	u.RawQuery = params.Encode()
 
-	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+	// Create HTTP request, sending queries too long for a URL in the body
+	var req *http.Request
+	if len(u.String()) >= urlLengthLimit {
+		postBody, err := json.Marshal(map[string]json.RawMessage{"query": json.RawMessage(query)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		u.RawQuery = ""
+		req, err = http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(postBody))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+	} else if req, err = http.NewRequestWithContext(ctx, "GET", u.String(), nil); err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Add authentication headers
 	if err := d.addAuthHeaders(req, apiReq.Config); err != nil {
-		return "", fmt.Errorf("failed to add auth headers: %w", err)
+		return nil, fmt.Errorf("failed to add auth headers: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	// Make the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := d.doHTTP(req, apiReq.Config)
 	if err != nil {
-		return "", fmt.Errorf("failed to make API request: %w", err)
+		return nil, fmt.Errorf("failed to make API request: %w", err)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			backend.Logger.Warn("Failed to close response body", "error", err)
+			backend.Logger.FromContext(ctx).Warn("Failed to close response body", "error", err)
 		}
 	}()
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	// Parse the SQL API response
-	var sqlResponse CubeSQLResponse
-	if err := json.Unmarshal(body, &sqlResponse); err != nil {
-		return "", fmt.Errorf("failed to parse API response: %w", err)
-	}
-
-	// Extract SQL string from nested structure: response.sql.sql[0]
-	if len(sqlResponse.SQL.SQL) == 0 {
-		return "", fmt.Errorf("SQL array is empty")
-	}
-
-	sql, ok := sqlResponse.SQL.SQL[0].(string)
-	if !ok {
-		return "", fmt.Errorf("SQL response is not a string")
-	}
-
-	return sql, nil
+	return readJSONResponse(resp)
 }
 
 // handleModelFiles fetches data model files from the Cube API
@@ -455,14 +1084,14 @@ func (d *Datasource) handleModelFiles(ctx context.Context, req *backend.CallReso
 	// Fetch model files from Cube API
 	modelFiles, err := d.fetchCubeModelFiles(ctx, req.PluginContext)
 	if err != nil {
-		backend.Logger.Error("Failed to fetch cube model files", "error", err)
+		backend.Logger.FromContext(ctx).Error("Failed to fetch cube model files", "error", err)
 		return sender.Send(jsonErrorResponse(500, errors.New("failed to fetch model files from Cube API")))
 	}
 
 	// Marshal response
 	body, err := json.Marshal(modelFiles)
 	if err != nil {
-		backend.Logger.Error("Failed to marshal model files response", "error", err)
+		backend.Logger.FromContext(ctx).Error("Failed to marshal model files response", "error", err)
 		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
 	}
 
@@ -483,12 +1112,11 @@ func (d *Datasource) fetchCubeModelFiles(ctx context.Context, pluginContext back
 		return nil, err
 	}
 
+	ctx, cancel := withTimeout(ctx, apiReq.Config.MetaTimeoutDuration())
+	defer cancel()
+
 	// Get base URL with test override support
-	baseURL := apiReq.Config.URL
-	if d.BaseURL != "" {
-		// Override for testing
-		baseURL = d.BaseURL
-	}
+	baseURL := d.baseURL(apiReq.Config)
 
 	// Construct playground files URL
 	baseURL = strings.TrimRight(baseURL, "/")
@@ -504,29 +1132,22 @@ func (d *Datasource) fetchCubeModelFiles(ctx context.Context, pluginContext back
 		return nil, fmt.Errorf("failed to add auth headers: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	d.validators.addConditionalHeaders(req)
 
 	// Make the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := d.doHTTP(req, apiReq.Config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make API request: %w", err)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			backend.Logger.Warn("Failed to close response body", "error", err)
+			backend.Logger.FromContext(ctx).Warn("Failed to close response body", "error", err)
 		}
 	}()
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		errorBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(errorBody))
-	}
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := d.validators.readResponse(req, resp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
 
 	// Parse the API response - Cube returns an object with files array
@@ -555,18 +1176,30 @@ func (d *Datasource) fetchCubeModelFiles(ctx context.Context, pluginContext back
 }
 
 // handleDbSchema fetches database schema information from the Cube API
+// It is cached (see dbSchemaCache); db-schema?refresh=true fetches it again.
 func (d *Datasource) handleDbSchema(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
+	}
+	refresh := false
+	if value := parsedURL.Query().Get("refresh"); value != "" {
+		if refresh, err = strconv.ParseBool(value); err != nil {
+			return sender.Send(jsonErrorResponse(400, fmt.Errorf("invalid refresh %q", value)))
+		}
+	}
+
 	// Fetch database schema from Cube API
-	dbSchema, err := d.fetchCubeDbSchema(ctx, req.PluginContext)
+	dbSchema, err := d.getCubeDbSchema(ctx, req.PluginContext, refresh)
 	if err != nil {
-		backend.Logger.Error("Failed to fetch cube database schema", "error", err)
+		backend.Logger.FromContext(ctx).Error("Failed to fetch cube database schema", "error", err)
 		return sender.Send(jsonErrorResponse(500, errors.New("failed to fetch database schema from Cube API")))
 	}
 
 	// Marshal response
 	body, err := json.Marshal(dbSchema)
 	if err != nil {
-		backend.Logger.Error("Failed to marshal database schema response", "error", err)
+		backend.Logger.FromContext(ctx).Error("Failed to marshal database schema response", "error", err)
 		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
 	}
 
@@ -587,12 +1220,11 @@ func (d *Datasource) fetchCubeDbSchema(ctx context.Context, pluginContext backen
 		return nil, err
 	}
 
+	ctx, cancel := withTimeout(ctx, apiReq.Config.MetaTimeoutDuration())
+	defer cancel()
+
 	// Get base URL with test override support
-	baseURL := apiReq.Config.URL
-	if d.BaseURL != "" {
-		// Override for testing
-		baseURL = d.BaseURL
-	}
+	baseURL := d.baseURL(apiReq.Config)
 
 	// Construct playground db-schema URL
 	baseURL = strings.TrimRight(baseURL, "/")
@@ -610,27 +1242,19 @@ func (d *Datasource) fetchCubeDbSchema(ctx context.Context, pluginContext backen
 	req.Header.Set("Content-Type", "application/json")
 
 	// Make the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := d.doHTTP(req, apiReq.Config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make API request: %w", err)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			backend.Logger.Warn("Failed to close response body", "error", err)
+			backend.Logger.FromContext(ctx).Warn("Failed to close response body", "error", err)
 		}
 	}()
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		errorBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(errorBody))
-	}
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := readJSONResponse(resp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
 
 	// Parse the API response - Cube returns { tablesSchema: <schema_data> }
@@ -656,21 +1280,24 @@ func (d *Datasource) handleGenerateSchema(ctx context.Context, req *backend.Call
 	// Parse request body
 	var generateSchemaReq GenerateSchemaRequest
 	if err := json.Unmarshal(req.Body, &generateSchemaReq); err != nil {
-		backend.Logger.Error("Failed to parse generate schema request", "error", err)
+		backend.Logger.FromContext(ctx).Error("Failed to parse generate schema request", "error", err)
 		return sender.Send(jsonErrorResponse(400, errors.New("invalid request body")))
 	}
+	if err := validateGenerateSchemaRequest(generateSchemaReq); err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
 
 	// Generate schema using Cube API
 	schemaResponse, err := d.fetchCubeGenerateSchema(ctx, req.PluginContext, &generateSchemaReq)
 	if err != nil {
-		backend.Logger.Error("Failed to generate cube schema", "error", err)
+		backend.Logger.FromContext(ctx).Error("Failed to generate cube schema", "error", err)
 		return sender.Send(jsonErrorResponse(500, errors.New("failed to generate schema from Cube API")))
 	}
 
 	// Marshal response
 	body, err := json.Marshal(schemaResponse)
 	if err != nil {
-		backend.Logger.Error("Failed to marshal generate schema response", "error", err)
+		backend.Logger.FromContext(ctx).Error("Failed to marshal generate schema response", "error", err)
 		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
 	}
 
@@ -691,12 +1318,11 @@ func (d *Datasource) fetchCubeGenerateSchema(ctx context.Context, pluginContext
 		return nil, err
 	}
 
+	ctx, cancel := withTimeout(ctx, apiReq.Config.MetaTimeoutDuration())
+	defer cancel()
+
 	// Get base URL with test override support
-	baseURL := apiReq.Config.URL
-	if d.BaseURL != "" {
-		// Override for testing
-		baseURL = d.BaseURL
-	}
+	baseURL := d.baseURL(apiReq.Config)
 
 	// Construct playground generate-schema URL
 	baseURL = strings.TrimRight(baseURL, "/")
@@ -720,27 +1346,19 @@ func (d *Datasource) fetchCubeGenerateSchema(ctx context.Context, pluginContext
 	req.Header.Set("Content-Type", "application/json")
 
 	// Make the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := d.doHTTP(req, apiReq.Config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make API request: %w", err)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			backend.Logger.Warn("Failed to close response body", "error", err)
+			backend.Logger.FromContext(ctx).Warn("Failed to close response body", "error", err)
 		}
 	}()
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		errorBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(errorBody))
-	}
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := readJSONResponse(resp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
 
 	// Parse the API response - Cube returns { files: [{ fileName: "...", content: "..." }] }
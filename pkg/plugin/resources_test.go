@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -456,7 +457,7 @@ func TestHandleMetadata(t *testing.T) {
 
 func TestHandleTagValues(t *testing.T) {
 	// Create a mock server that returns load response with dimension values
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
 		// Verify this is a request to the load endpoint
 		if r.URL.Path != "/cubejs-api/v1/load" {
 			t.Errorf("Expected path /cubejs-api/v1/load, got %s", r.URL.Path)
@@ -963,14 +964,118 @@ func TestHandleSQLCompilation(t *testing.T) {
 	}
 
 	// Parse the response and verify it contains the SQL
-	var sqlResponse map[string]string
+	var sqlResponse CompiledSQL
 	if err := json.Unmarshal(resp.Body, &sqlResponse); err != nil {
 		t.Fatalf("Failed to parse response: %v", err)
 	}
 
 	expectedSQL := "SELECT\n  \"customers\".city \"orders__users_city\",\n  count(*) \"orders__count\"\nFROM\n  orders AS \"orders\"\n  LEFT JOIN customers AS \"customers\" ON \"orders\".customer_id = customers.id\nGROUP BY\n  1\nORDER BY\n  2 DESC\nLIMIT\n  10000"
-	if sqlResponse["sql"] != expectedSQL {
-		t.Fatalf("Expected SQL:\n%s\n\nGot:\n%s", expectedSQL, sqlResponse["sql"])
+	if sqlResponse.SQL != expectedSQL {
+		t.Fatalf("Expected SQL:\n%s\n\nGot:\n%s", expectedSQL, sqlResponse.SQL)
+	}
+}
+
+func TestHandleSQLCompilationParamsAndPreAggregations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"sql": {"sql": ["SELECT count FROM prod_pre_aggregations.orders_main WHERE status = $1", ["completed"]], "external": true,
+			"preAggregations": [{"preAggregationId": "orders.main", "tableName": "prod_pre_aggregations.orders_main", "type": "rollup",
+				"loadSql": ["CREATE TABLE prod_pre_aggregations.orders_main AS SELECT status, count(*) FROM orders WHERE created_at >= $1 GROUP BY 1", ["2024-01-01"]]}]}}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	query := "query=" + url.QueryEscape(`{"measures":["orders.count"]}`)
+
+	tests := []struct {
+		url  string
+		want CompiledSQL
+	}{
+		{
+			url:  "sql?" + query,
+			want: CompiledSQL{SQL: "SELECT count FROM prod_pre_aggregations.orders_main WHERE status = $1", Params: []interface{}{"completed"}},
+		},
+		{
+			url: "sql?preAggregations=true&" + query,
+			want: CompiledSQL{
+				SQL:    "SELECT count FROM prod_pre_aggregations.orders_main WHERE status = $1",
+				Params: []interface{}{"completed"},
+				PreAggregations: []CompiledPreAggregation{{
+					PreAggregationUsage: PreAggregationUsage{ID: "orders.main", TableName: "prod_pre_aggregations.orders_main", Type: "rollup", External: true},
+					LoadSQL:             "CREATE TABLE prod_pre_aggregations.orders_main AS SELECT status, count(*) FROM orders WHERE created_at >= $1 GROUP BY 1",
+					LoadParams:          []interface{}{"2024-01-01"},
+				}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			resp := callHandler(t, ds.handleSQLCompilation, &backend.CallResourceRequest{
+				PluginContext: newTestPluginContext(server.URL),
+				Path:          "sql",
+				URL:           tt.url,
+			})
+			if resp.Status != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+			}
+			want, _ := json.Marshal(tt.want)
+			if string(resp.Body) != string(want) {
+				t.Errorf("expected %s, got %s", want, resp.Body)
+			}
+		})
+	}
+}
+
+func TestHandleSQLCompilationPost(t *testing.T) {
+	values := make([]string, 300)
+	for i := range values {
+		values[i] = fmt.Sprintf("customer-%d", i)
+	}
+	filter, _ := json.Marshal(values)
+	query := `{"measures":["orders.count"],"filters":[{"member":"orders.customer","operator":"equals","values":` + string(filter) + `}]}`
+
+	var gotMethod, gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		var body struct {
+			Query json.RawMessage `json:"query"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("expected a JSON body: %v", err)
+		}
+		gotQuery = string(body.Query)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"sql": {"sql": ["SELECT count(*) FROM orders WHERE customer IN ($1, ...)", []]}}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	resp := callHandler(t, ds.handleSQLCompilation, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Path:          "sql",
+		Method:        http.MethodPost,
+		URL:           "sql",
+		Body:          []byte(`{"query": ` + query + `}`),
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+	if gotMethod != http.MethodPost {
+		t.Errorf("expected the long query to be sent to Cube with POST, got %s", gotMethod)
+	}
+	if !strings.Contains(gotQuery, "customer-299") {
+		t.Errorf("expected the whole query to be sent to Cube, got %s", gotQuery)
+	}
+
+	resp = callHandler(t, ds.handleSQLCompilation, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Path:          "sql",
+		Method:        http.MethodPost,
+		URL:           "sql",
+		Body:          []byte(`not json`),
+	})
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid body, got %d: %s", resp.Status, resp.Body)
 	}
 }
 
@@ -1634,6 +1739,55 @@ func TestHandleGenerateSchemaWithInvalidJSON(t *testing.T) {
 	}
 }
 
+func TestHandleGenerateSchemaFormatsAndDataSource(t *testing.T) {
+	var sent GenerateSchemaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = GenerateSchemaRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&sent); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"files": [{"fileName": "orders.js", "content": "cube(` + "`orders`" + `, {})"}]}`))
+	}))
+	defer server.Close()
+	ds := &Datasource{BaseURL: server.URL}
+
+	tests := []struct {
+		body   string
+		status int
+		want   GenerateSchemaRequest
+	}{
+		{
+			body:   `{"format": "js", "dataSource": "analytics", "tables": [["public", "orders"]]}`,
+			status: http.StatusOK,
+			want:   GenerateSchemaRequest{Format: "js", DataSource: "analytics", Tables: [][]string{{"public", "orders"}}},
+		},
+		{
+			body:   `{"tables": [["public", "orders"]]}`,
+			status: http.StatusOK,
+			want:   GenerateSchemaRequest{Tables: [][]string{{"public", "orders"}}},
+		},
+		{body: `{"format": "json", "tables": [["public", "orders"]]}`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			sent = GenerateSchemaRequest{}
+			resp := callHandler(t, ds.handleGenerateSchema, &backend.CallResourceRequest{
+				PluginContext: newTestPluginContext(server.URL),
+				Path:          "generate-schema",
+				Method:        "POST",
+				Body:          []byte(tt.body),
+			})
+			if resp.Status != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, resp.Status, resp.Body)
+			}
+			if !reflect.DeepEqual(sent, tt.want) {
+				t.Errorf("Expected Cube to receive %+v, got %+v", tt.want, sent)
+			}
+		})
+	}
+}
+
 func TestHandleGenerateSchemaWithAPIError(t *testing.T) {
 	// Create a mock server that returns an error
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -1914,3 +2068,443 @@ func TestCallResourceAuthorizationReadRoutes(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractMetadataDataSources(t *testing.T) {
+	ds := &Datasource{}
+	metaResponse := &CubeMetaResponse{
+		Cubes: []CubeMeta{
+			{
+				Name:       "orders_view",
+				Type:       "view",
+				DataSource: "warehouse",
+				Dimensions: []CubeDimension{{Name: "orders_view.status", Type: "string"}},
+				Measures:   []CubeMeasure{{Name: "orders_view.count", Type: "number"}},
+			},
+			{
+				Name:       "events_view",
+				Type:       "view",
+				DataSource: "clickhouse",
+				Dimensions: []CubeDimension{{Name: "events_view.name", Type: "string"}},
+			},
+		},
+	}
+	labels := map[string]string{"warehouse": "Snowflake (prod)"}
+
+	result := ds.extractMetadata(metaResponse, metadataOptions{dataSourceLabels: labels})
+
+	if got := result.Dimensions[0].DataSource; got != "Snowflake (prod)" {
+		t.Errorf("expected mapped label, got %q", got)
+	}
+	if got := result.Dimensions[1].DataSource; got != "clickhouse" {
+		t.Errorf("expected unmapped data source name, got %q", got)
+	}
+	if !reflect.DeepEqual(result.DataSources, []string{"Snowflake (prod)", "clickhouse"}) {
+		t.Errorf("unexpected data sources: %v", result.DataSources)
+	}
+
+	// Filtering accepts either the raw name or the label.
+	for _, filter := range []string{"warehouse", "Snowflake (prod)"} {
+		filtered := ds.extractMetadata(metaResponse, metadataOptions{dataSourceLabels: labels, dataSource: filter})
+		if len(filtered.Dimensions) != 1 || filtered.Dimensions[0].Value != "orders_view.status" {
+			t.Errorf("filter %q: expected only orders_view members, got %+v", filter, filtered.Dimensions)
+		}
+	}
+}
+
+func TestHandleMetadataDataSourceLabelsFromSettings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CubeMetaResponse{Cubes: []CubeMeta{{
+			Name:       "orders_view",
+			Type:       "view",
+			DataSource: "warehouse",
+			Dimensions: []CubeDimension{{Name: "orders_view.status", Type: "string"}},
+		}}})
+	}))
+	defer server.Close()
+
+	pluginContext := newTestPluginContext(server.URL)
+	pluginContext.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType":"self-hosted-dev","dataSourceLabels":{"warehouse":"Snowflake"}}`)
+
+	ds := &Datasource{BaseURL: server.URL}
+	resp := callHandler(t, ds.handleMetadata, &backend.CallResourceRequest{
+		PluginContext: pluginContext,
+		Path:          "metadata",
+		URL:           "metadata",
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+
+	var metadata MetadataResponse
+	if err := json.Unmarshal(resp.Body, &metadata); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(metadata.Dimensions) != 1 || metadata.Dimensions[0].DataSource != "Snowflake" {
+		t.Errorf("expected dimension labelled Snowflake, got %+v", metadata.Dimensions)
+	}
+}
+
+func TestHandleMetadataPerView(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CubeMetaResponse{Cubes: []CubeMeta{
+			{Name: "orders_view", Type: "view", Dimensions: []CubeDimension{{Name: "orders_view.status", Type: "string"}}},
+			{Name: "users_view", Type: "view", Dimensions: []CubeDimension{{Name: "users_view.name", Type: "string"}}},
+			{Name: "events_view", Type: "view", Dimensions: []CubeDimension{{Name: "events_view.type", Type: "string"}}},
+			{Name: "orders", Type: "cube", Dimensions: []CubeDimension{{Name: "orders.status", Type: "string"}}},
+		}})
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	tests := []struct {
+		url        string
+		wantStatus int
+		want       []string
+	}{
+		{url: "metadata?cube=orders_view", wantStatus: http.StatusOK, want: []string{"orders_view.status"}},
+		{url: "metadata?cube=orders_view,events_view", wantStatus: http.StatusOK, want: []string{"orders_view.status", "events_view.type"}},
+		{url: "metadata?cube=users_view&cube=events_view", wantStatus: http.StatusOK, want: []string{"users_view.name", "events_view.type"}},
+		{url: "metadata?cube=missing_view", wantStatus: http.StatusNotFound},
+		{url: "metadata?cube=orders", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			resp := callHandler(t, ds.handleMetadata, &backend.CallResourceRequest{
+				PluginContext: newTestPluginContext(server.URL),
+				Path:          "metadata",
+				URL:           tt.url,
+			})
+			if resp.Status != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, resp.Status, resp.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var metadata MetadataResponse
+			if err := json.Unmarshal(resp.Body, &metadata); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			var got []string
+			for _, dimension := range metadata.Dimensions {
+				got = append(got, dimension.Value)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandleTagKeys(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CubeMetaResponse{Cubes: []CubeMeta{
+			{
+				Name: "orders_view", Type: "view",
+				Dimensions: []CubeDimension{{Name: "orders_view.status", Type: "string"}},
+				Measures:   []CubeMeasure{{Name: "orders_view.count", Type: "number"}},
+				Segments:   []CubeSegment{{Name: "orders_view.completed"}},
+			},
+			{Name: "users_view", Type: "view", Dimensions: []CubeDimension{{Name: "users_view.name", Type: "string"}}},
+		}})
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	tests := []struct {
+		url        string
+		wantStatus int
+		want       []TagKey
+	}{
+		{url: "tag-keys", wantStatus: http.StatusOK, want: []TagKey{
+			{Text: "orders_view.status", Value: "orders_view.status"},
+			{Text: "users_view.name", Value: "users_view.name"},
+			{Text: "orders_view.completed", Value: "segment:orders_view.completed"},
+		}},
+		{url: "tag-keys?view=users_view", wantStatus: http.StatusOK, want: []TagKey{
+			{Text: "users_view.name", Value: "users_view.name"},
+		}},
+		{url: "tag-keys?view=missing_view", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			resp := callHandler(t, ds.handleTagKeys, &backend.CallResourceRequest{
+				PluginContext: newTestPluginContext(server.URL),
+				Path:          "tag-keys",
+				URL:           tt.url,
+			})
+			if resp.Status != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, resp.Status, resp.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got []TagKey
+			if err := json.Unmarshal(resp.Body, &got); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExtractMetadataDrillMembersAndMeta(t *testing.T) {
+	ds := &Datasource{}
+	metadata := ds.extractMetadataFromResponse(&CubeMetaResponse{Cubes: []CubeMeta{{
+		Name: "orders_view",
+		Type: "view",
+		Dimensions: []CubeDimension{
+			{Name: "orders_view.status", Type: "string", Meta: map[string]interface{}{"color": "red"}},
+		},
+		Measures: []CubeMeasure{
+			{Name: "orders_view.count", Type: "number", DrillMembers: []string{"orders_view.id", "orders_view.status"}, Meta: map[string]interface{}{"unit": "orders"}},
+		},
+	}}})
+
+	if got := metadata.Dimensions[0].Meta; !reflect.DeepEqual(got, map[string]interface{}{"color": "red"}) {
+		t.Errorf("expected the dimension meta, got %v", got)
+	}
+	measure := metadata.Measures[0]
+	if !reflect.DeepEqual(measure.DrillMembers, []string{"orders_view.id", "orders_view.status"}) {
+		t.Errorf("expected the drill members, got %v", measure.DrillMembers)
+	}
+	if !reflect.DeepEqual(measure.Meta, map[string]interface{}{"unit": "orders"}) {
+		t.Errorf("expected the measure meta, got %v", measure.Meta)
+	}
+}
+
+func TestHandleMetadataGroupedByView(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CubeMetaResponse{Cubes: []CubeMeta{
+			{
+				Name: "orders_view", Title: "Orders", Description: "Orders placed in the shop", Type: "view",
+				Dimensions: []CubeDimension{{Name: "orders_view.status", Type: "string"}, {Name: "orders_view.user_name", Type: "string"}},
+				Measures:   []CubeMeasure{{Name: "orders_view.count", Type: "number"}},
+				Segments:   []CubeSegment{{Name: "orders_view.completed"}},
+			},
+			{Name: "users_view", Title: "Users", Type: "view", Dimensions: []CubeDimension{{Name: "orders_view.user_name", Type: "string"}}},
+			{Name: "orders", Type: "cube", Dimensions: []CubeDimension{{Name: "orders.status", Type: "string"}}},
+		}})
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	resp := callHandler(t, ds.handleMetadata, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Path:          "metadata",
+		URL:           "metadata?groupBy=view",
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+	var metadata MetadataResponse
+	if err := json.Unmarshal(resp.Body, &metadata); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(metadata.Views) != 2 {
+		t.Fatalf("expected the two views, got %+v", metadata.Views)
+	}
+	orders, users := metadata.Views[0], metadata.Views[1]
+	if orders.Name != "orders_view" || orders.Title != "Orders" || orders.Description != "Orders placed in the shop" {
+		t.Errorf("unexpected view: %+v", orders)
+	}
+	if len(orders.Dimensions) != 2 || len(orders.Measures) != 1 || len(orders.Segments) != 1 {
+		t.Errorf("expected the members of orders_view, got %+v", orders)
+	}
+	// A member shared by several views is listed under each of them.
+	if len(users.Dimensions) != 1 || users.Dimensions[0].Cube != "users_view" {
+		t.Errorf("expected the shared member under users_view, got %+v", users.Dimensions)
+	}
+	if len(metadata.Dimensions) != 2 {
+		t.Errorf("expected the flat member lists to be kept, got %+v", metadata.Dimensions)
+	}
+
+	resp = callHandler(t, ds.handleMetadata, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Path:          "metadata",
+		URL:           "metadata?groupBy=cube",
+	})
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown groupBy, got %d", resp.Status)
+	}
+}
+
+func TestHandleMetadataIncludeCubes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CubeMetaResponse{Cubes: []CubeMeta{
+			{Name: "orders_view", Type: "view", Dimensions: []CubeDimension{{Name: "orders_view.status", Type: "string"}}},
+			{Name: "orders", Type: "cube", Dimensions: []CubeDimension{{Name: "orders.status", Type: "string"}}},
+		}})
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	tests := []struct {
+		url        string
+		wantStatus int
+		want       []string
+	}{
+		{url: "metadata", wantStatus: http.StatusOK, want: []string{"orders_view.status"}},
+		{url: "metadata?include=cubes", wantStatus: http.StatusOK, want: []string{"orders_view.status", "orders.status"}},
+		{url: "metadata?include=cubes&cube=orders", wantStatus: http.StatusOK, want: []string{"orders.status"}},
+		{url: "metadata?include=measures", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			resp := callHandler(t, ds.handleMetadata, &backend.CallResourceRequest{
+				PluginContext: newTestPluginContext(server.URL),
+				Path:          "metadata",
+				URL:           tt.url,
+			})
+			if resp.Status != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, resp.Status, resp.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var metadata MetadataResponse
+			if err := json.Unmarshal(resp.Body, &metadata); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			var got []string
+			for _, dimension := range metadata.Dimensions {
+				got = append(got, dimension.Value)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExtractMetadataMeasureAggregation(t *testing.T) {
+	var meta CubeMetaResponse
+	if err := json.Unmarshal([]byte(`{"cubes": [{"name": "orders_view", "type": "view", "measures": [
+		{"name": "orders_view.count", "type": "number", "aggType": "count"},
+		{"name": "orders_view.rolling_total", "type": "number", "aggType": "sum", "cumulative": true,
+			"rollingWindow": {"trailing": "7 day", "offset": "end"}}
+	]}]}`), &meta); err != nil {
+		t.Fatalf("failed to parse meta: %v", err)
+	}
+
+	measures := (&Datasource{}).extractMetadataFromResponse(&meta).Measures
+	if measures[0].Aggregation != "count" || measures[0].Cumulative || measures[0].RollingWindow != nil {
+		t.Errorf("unexpected count measure: %+v", measures[0])
+	}
+	rolling := measures[1]
+	if rolling.Aggregation != "sum" || !rolling.Cumulative {
+		t.Errorf("expected a cumulative sum, got %+v", rolling)
+	}
+	if rolling.RollingWindow == nil || *rolling.RollingWindow != (CubeRollingWindow{Trailing: "7 day", Offset: "end"}) {
+		t.Errorf("expected the rolling window, got %+v", rolling.RollingWindow)
+	}
+}
+
+func TestHandleMetadataHidesHiddenMembers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"cubes": [
+			{"name": "orders_view", "type": "view",
+				"dimensions": [{"name": "orders_view.status", "type": "string"}, {"name": "orders_view.internal_id", "type": "string", "isVisible": false}],
+				"measures": [{"name": "orders_view.count", "type": "number"}, {"name": "orders_view.cost", "type": "number", "public": false}],
+				"segments": [{"name": "orders_view.test_orders", "public": false}]},
+			{"name": "staging_view", "type": "view", "public": false,
+				"dimensions": [{"name": "staging_view.status", "type": "string"}]}
+		]}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	request := func(url string, role string) *backend.CallResourceResponse {
+		pCtx := newTestPluginContext(server.URL)
+		pCtx.User = &backend.User{Role: role}
+		return callHandler(t, ds.handleMetadata, &backend.CallResourceRequest{PluginContext: pCtx, Path: "metadata", URL: url})
+	}
+	members := func(resp *backend.CallResourceResponse) []string {
+		var metadata MetadataResponse
+		if err := json.Unmarshal(resp.Body, &metadata); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		var got []string
+		for _, options := range [][]SelectOption{metadata.Dimensions, metadata.Measures, metadata.Segments} {
+			for _, option := range options {
+				got = append(got, option.Value)
+			}
+		}
+		return got
+	}
+
+	resp := request("metadata", "Viewer")
+	if want := []string{"orders_view.status", "orders_view.count"}; !reflect.DeepEqual(members(resp), want) {
+		t.Errorf("expected only visible members %v, got %v", want, members(resp))
+	}
+
+	resp = request("metadata?includeHidden=true", "Admin")
+	if want := []string{
+		"orders_view.status", "orders_view.internal_id", "staging_view.status",
+		"orders_view.count", "orders_view.cost", "segment:orders_view.test_orders",
+	}; !reflect.DeepEqual(members(resp), want) {
+		t.Errorf("expected every member for admins %v, got %v", want, members(resp))
+	}
+
+	if resp := request("metadata?includeHidden=true", "Editor"); resp.Status != http.StatusForbidden {
+		t.Errorf("expected 403 for a non-admin, got %d", resp.Status)
+	}
+}
+
+func TestHandleMetadataSearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CubeMetaResponse{Cubes: []CubeMeta{
+			{
+				Name: "orders_view", Type: "view",
+				Dimensions: []CubeDimension{{Name: "orders_view.status", Type: "string"}, {Name: "orders_view.city", Title: "Shipping Town", Type: "string"}},
+				Measures:   []CubeMeasure{{Name: "orders_view.count", Type: "number"}},
+			},
+			{Name: "users_view", Type: "view", Dimensions: []CubeDimension{{Name: "users_view.name", Type: "string"}}},
+		}})
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{url: "metadata?search=STATUS", want: []string{"orders_view.status"}},
+		{url: "metadata?search=town", want: []string{"orders_view.city"}},
+		{url: "metadata?search=name&cube=orders_view"},
+		{url: "metadata?search=_view.", want: []string{"orders_view.status", "orders_view.city", "users_view.name", "orders_view.count"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			resp := callHandler(t, ds.handleMetadata, &backend.CallResourceRequest{
+				PluginContext: newTestPluginContext(server.URL),
+				Path:          "metadata",
+				URL:           tt.url,
+			})
+			if resp.Status != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+			}
+			var metadata MetadataResponse
+			if err := json.Unmarshal(resp.Body, &metadata); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			var got []string
+			for _, options := range [][]SelectOption{metadata.Dimensions, metadata.Measures} {
+				for _, option := range options {
+					got = append(got, option.Value)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
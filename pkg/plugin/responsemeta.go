@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// responseMeta is attached to the frames of a query (frame meta custom
+// "cube"), so the Query Inspector shows how Cube ran it.
+type responseMeta struct {
+	// RequestID is Cube's request ID, to find the query in Cube's logs.
+	RequestID string `json:"requestId,omitempty"`
+	// DBType is the type of the database the query ran on.
+	DBType string `json:"dbType,omitempty"`
+	// External is true when Cube Store answered from a pre-aggregation.
+	External *bool `json:"external,omitempty"`
+	// SlowQuery is true when Cube flagged the query as slow.
+	SlowQuery bool `json:"slowQuery,omitempty"`
+}
+
+// responseMetaFor returns the response metadata of a result, or nil when
+// Cube reported none.
+func responseMetaFor(result CubeAPIResponse) *responseMeta {
+	meta := responseMeta{
+		RequestID: result.RequestID,
+		DBType:    result.DBType,
+		External:  result.External,
+		SlowQuery: result.SlowQuery,
+	}
+	if meta == (responseMeta{}) {
+		return nil
+	}
+	return &meta
+}
+
+// addResponseMeta attaches Cube's response metadata to the frames of a
+// successful response.
+func addResponseMeta(result CubeAPIResponse, response backend.DataResponse) backend.DataResponse {
+	if response.Error != nil {
+		return response
+	}
+	meta := responseMetaFor(result)
+	if meta == nil {
+		return response
+	}
+	for _, frame := range response.Frames {
+		setCustomMeta(frame, "cube", meta)
+	}
+	return response
+}
+
+// setCustomMeta sets key in the custom meta of a frame, keeping the keys
+// already set.
+func setCustomMeta(frame *data.Frame, key string, value interface{}) {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	custom, _ := frame.Meta.Custom.(map[string]interface{})
+	if custom == nil {
+		custom = make(map[string]interface{})
+	}
+	custom[key] = value
+	frame.Meta.Custom = custom
+}
@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestQueryDataResponseMeta(t *testing.T) {
+	external := true
+	server := newCubeLoadServer(t, CubeAPIResponse{
+		Data:       []map[string]interface{}{{"orders.count": "3"}},
+		Annotation: CubeAnnotation{Measures: map[string]CubeFieldInfo{"orders.count": {Type: "number"}}},
+		RequestID:  "5f0c8e4a-span-1",
+		DBType:     "postgres",
+		External:   &external,
+		SlowQuery:  true,
+	})
+	ds := &Datasource{BaseURL: server.URL}
+
+	res := runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId":"A","measures":["orders.count"]}`)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	custom, _ := res.Frames[0].Meta.Custom.(map[string]interface{})
+	meta, ok := custom["cube"].(*responseMeta)
+	if !ok {
+		t.Fatalf("expected cube response meta, got %+v", res.Frames[0].Meta.Custom)
+	}
+	if meta.RequestID != "5f0c8e4a-span-1" || meta.DBType != "postgres" || meta.External == nil || !*meta.External || !meta.SlowQuery {
+		t.Errorf("unexpected response meta %+v", meta)
+	}
+}
+
+func TestAddResponseMetaWithoutMeta(t *testing.T) {
+	response := addResponseMeta(CubeAPIResponse{}, backend.DataResponse{Frames: data.Frames{data.NewFrame("")}})
+	if response.Frames[0].Meta != nil {
+		t.Errorf("expected no frame meta, got %+v", response.Frames[0].Meta)
+	}
+}
+
+func TestSetCustomMetaKeepsKeys(t *testing.T) {
+	frame := data.NewFrame("")
+	setCustomMeta(frame, "refresh", "1h")
+	setCustomMeta(frame, "cube", "meta")
+	custom := frame.Meta.Custom.(map[string]interface{})
+	if custom["refresh"] != "1h" || custom["cube"] != "meta" {
+		t.Errorf("expected both keys, got %v", custom)
+	}
+}
@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// defaultMaxResponseBytes is the maxResponseBytes of datasources that do not
+// set one.
+const defaultMaxResponseBytes = 100 << 20
+
+// maxResponseBytes returns the maxResponseBytes setting, 0 meaning no limit.
+func maxResponseBytes(config *models.PluginSettings) int64 {
+	if config == nil || config.MaxResponseBytes == nil {
+		return defaultMaxResponseBytes
+	}
+	return max(*config.MaxResponseBytes, 0)
+}
+
+// readLoadBody reads a /v1/load response body of at most limit bytes, or of
+// any size when limit is 0. A larger body is not read further, so a huge
+// result fails the query rather than the plugin process.
+func readLoadBody(body io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response body: %w", err)
+		}
+		return data, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, responseTooLargeError(limit)
+	}
+	return data, nil
+}
+
+// responseTooLargeError is the error of a result over maxResponseBytes.
+func responseTooLargeError(limit int64) error {
+	return &loadRequestError{
+		status: backend.StatusBadRequest,
+		msg:    fmt.Sprintf("result too large: Cube's response exceeds %s (maxResponseBytes), add a limit or filters to the query", formatBytes(limit)),
+	}
+}
+
+// formatBytes formats a size in bytes with a binary unit, e.g. "100 MiB".
+func formatBytes(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.4g %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{512: "512 B", 1536: "1.5 KiB", defaultMaxResponseBytes: "100 MiB", 3 << 30: "3 GiB"} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestReadLoadBody(t *testing.T) {
+	if body, err := readLoadBody(strings.NewReader("0123456789"), 10); err != nil || string(body) != "0123456789" {
+		t.Errorf("expected a body at the limit to be read, got %q %v", body, err)
+	}
+	if _, err := readLoadBody(strings.NewReader("0123456789"), 9); err == nil {
+		t.Error("expected a body over the limit to fail")
+	}
+	if body, err := readLoadBody(strings.NewReader("0123456789"), 0); err != nil || len(body) != 10 {
+		t.Errorf("expected no limit, got %q %v", body, err)
+	}
+}
+
+func TestQueryDataResponseTooLarge(t *testing.T) {
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [{"orders.count": "1"}, {"orders.count": "2"}], "annotation": {"measures": {"orders.count": {"type": "number"}}}}`))
+	}))
+	defer server.Close()
+
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "maxResponseBytes": 64}`)
+	res := runSingleQuery(t, &Datasource{}, pCtx, `{"refId": "A", "measures": ["orders.count"]}`)
+	if res.Status != backend.StatusBadRequest || res.Error == nil || !strings.Contains(res.Error.Error(), "result too large") {
+		t.Errorf("expected a result too large error, got %v: %v", res.Status, res.Error)
+	}
+}
+
+func TestQueryDataResponseTooLargeGraphQL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": {"cube": [{"orders": {"count": 1}}, {"orders": {"count": 2}}, {"orders": {"count": 3}}]}}`))
+	}))
+	defer server.Close()
+
+	pCtx := graphQLPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "queryTransport": "graphql", "maxResponseBytes": 64}`)
+	res := runSingleQuery(t, &Datasource{}, pCtx, `{"refId": "A", "measures": ["orders.count"]}`)
+	if res.Status != backend.StatusBadRequest || res.Error == nil || !strings.Contains(res.Error.Error(), "result too large") {
+		t.Errorf("expected a result too large error, got %v: %v", res.Status, res.Error)
+	}
+}
+
+func TestQueryDataResponseTooLargeSQLAPI(t *testing.T) {
+	// DataRows of 7 bytes each.
+	rows := make([][]*string, 10)
+	for i := range rows {
+		value := strconv.Itoa(i)
+		rows[i] = []*string{&value}
+	}
+	server := newFakeSQLAPI(t, []pgColumn{{Name: "orders.count", TypeOID: pgTypeInt4}}, rows)
+
+	pCtx := sqlAPIPluginContext(t, server.listener.Addr().String())
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "queryTransport": "sql", "sqlApiAddress": "` +
+		server.listener.Addr().String() + `", "sqlApiUser": "grafana", "maxResponseBytes": 64}`)
+	res := runSingleQuery(t, &Datasource{}, pCtx, `{"refId": "A", "measures": ["orders.count"]}`)
+	if res.Status != backend.StatusBadRequest || res.Error == nil || !strings.Contains(res.Error.Error(), "result too large") {
+		t.Errorf("expected a result too large error, got %v: %v", res.Status, res.Error)
+	}
+}
@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// defaultResultCacheEntries bounds the result cache when
+// resultCacheMaxEntries is not configured.
+const defaultResultCacheEntries = 500
+
+// resultCache is a per-instance LRU cache of Cube /v1/load results, so the
+// same query from several panels or viewers of a shared dashboard is answered
+// without another round trip to Cube. It is only used when resultCacheTTL is
+// configured. Cached results are shared between responses and must not be
+// modified.
+type resultCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds *resultCacheEntry values, most recently used first.
+	lru *list.List
+}
+
+type resultCacheEntry struct {
+	key     string
+	result  CubeAPIResponse
+	expires time.Time
+}
+
+// get returns the cached result for key if it has not expired.
+func (c *resultCache) get(key string) (CubeAPIResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return CubeAPIResponse{}, false
+	}
+	entry := elem.Value.(*resultCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return CubeAPIResponse{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.result, true
+}
+
+// put stores a result for ttl, evicting the least recently used entries
+// beyond maxEntries.
+func (c *resultCache) put(key string, result CubeAPIResponse, ttl time.Duration, maxEntries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+		c.lru = list.New()
+	}
+
+	entry := &resultCacheEntry{key: key, result: result, expires: time.Now().Add(ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.lru.PushFront(entry)
+	}
+
+	for c.lru.Len() > maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*resultCacheEntry).key)
+	}
+}
+
+// resultCacheKey identifies a result by the Cube query, the dashboard time
+// range and the security context the query runs with (the credentials Cube
+// authenticates it with), so results are never shared across identities. The
+// key is hashed so credentials are not kept in memory in the clear.
+func resultCacheKey(queryJSON []byte, timeRange backend.TimeRange, config *models.PluginSettings) string {
+	h := sha256.New()
+	writeField := func(b []byte) {
+		_ = binary.Write(h, binary.BigEndian, uint64(len(b)))
+		h.Write(b)
+	}
+	writeField(queryJSON)
+	writeField([]byte(timeRange.From.UTC().Format(time.RFC3339Nano)))
+	writeField([]byte(timeRange.To.UTC().Format(time.RFC3339Nano)))
+	writeField([]byte(config.URL))
+	writeField([]byte(config.DeploymentType))
+	if config.Secrets != nil {
+		writeField([]byte(config.Secrets.ApiKey))
+		writeField([]byte(config.Secrets.ApiSecret))
+		writeField([]byte(config.Secrets.JWTPrivateKey))
+		writeField([]byte(config.Secrets.TLSClientCert))
+	}
+	securityContext, _ := json.Marshal(config.SecurityContext)
+	writeField(securityContext)
+	// Gateways may route or scope requests by header (e.g. tenancy headers).
+	customHeaders, _ := json.Marshal(config.CustomHeaders)
+	writeField(customHeaders)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedResult returns the cached result of a query, if result caching is
+// enabled and one is cached.
+func (d *Datasource) cachedResult(key string, config *models.PluginSettings) (CubeAPIResponse, bool) {
+	if config.ResultCacheTTLDuration() == 0 {
+		return CubeAPIResponse{}, false
+	}
+	return d.results.get(key)
+}
+
+// cacheResult stores a query result if result caching is enabled.
+func (d *Datasource) cacheResult(key string, result CubeAPIResponse, config *models.PluginSettings) {
+	ttl := config.ResultCacheTTLDuration()
+	if ttl == 0 {
+		return
+	}
+	d.results.put(key, result, ttl, resultCacheMaxEntries(config))
+}
+
+// resultCacheMaxEntries returns the configured result cache size, or
+// defaultResultCacheEntries when unset.
+func resultCacheMaxEntries(config *models.PluginSettings) int {
+	if config.ResultCacheMaxEntries != nil && *config.ResultCacheMaxEntries > 0 {
+		return *config.ResultCacheMaxEntries
+	}
+	return defaultResultCacheEntries
+}
+
+// preparedCacheKey returns the result cache key of a prepared query.
+func preparedCacheKey(p *preparedQuery, config *models.PluginSettings) (string, bool) {
+	if len(p.blended) > 0 {
+		// The result of a blended query is not the result of its apiQuery.
+		return "", false
+	}
+	queryJSON, err := json.Marshal(p.apiQuery)
+	if err != nil {
+		return "", false
+	}
+	return resultCacheKey(queryJSON, p.timeRange, config), true
+}
+
+// answerFromResultCache fills responses for the prepared queries that have a
+// cached result and returns the queries that still have to be sent to Cube.
+func (d *Datasource) answerFromResultCache(ctx context.Context, pCtx backend.PluginContext, prepared []*preparedQuery, responses map[string]backend.DataResponse) []*preparedQuery {
+	if pCtx.DataSourceInstanceSettings == nil {
+		return prepared
+	}
+	config, err := d.loadSettings(*pCtx.DataSourceInstanceSettings)
+	if err != nil || config.ResultCacheTTLDuration() == 0 {
+		return prepared
+	}
+
+	remaining := prepared[:0:0]
+	for _, p := range prepared {
+		key, ok := preparedCacheKey(p, config)
+		if !ok {
+			remaining = append(remaining, p)
+			continue
+		}
+		result, ok := d.cachedResult(key, config)
+		if !ok {
+			remaining = append(remaining, p)
+			continue
+		}
+		responses[p.refID] = d.respond(ctx, pCtx, p, result)
+	}
+	return remaining
+}
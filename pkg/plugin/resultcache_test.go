@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestResultCacheLRU(t *testing.T) {
+	var cache resultCache
+	result := func(v string) CubeAPIResponse {
+		return CubeAPIResponse{Data: []map[string]interface{}{{"orders.count": v}}}
+	}
+
+	cache.put("a", result("1"), time.Minute, 2)
+	cache.put("b", result("2"), time.Minute, 2)
+	// Using "a" makes "b" the least recently used entry.
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	cache.put("c", result("3"), time.Minute, 2)
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for key, want := range map[string]string{"a": "1", "c": "3"} {
+		got, ok := cache.get(key)
+		if !ok || got.Data[0]["orders.count"] != want {
+			t.Errorf("expected %s to be cached with %s, got %v (%v)", key, want, got.Data, ok)
+		}
+	}
+
+	cache.put("a", result("4"), -time.Second, 2)
+	if _, ok := cache.get("a"); ok {
+		t.Error("expected an expired entry not to be returned")
+	}
+	if _, ok := cache.entries["a"]; ok {
+		t.Error("expected the expired entry to be removed")
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeRange := backend.TimeRange{From: from, To: from.Add(time.Hour)}
+	config := &models.PluginSettings{
+		URL:            "http://cube:4000",
+		DeploymentType: "self-hosted",
+		Secrets:        &models.SecretPluginSettings{ApiSecret: "secret"},
+	}
+	query := []byte(`{"measures":["orders.count"]}`)
+	key := resultCacheKey(query, timeRange, config)
+
+	if got := resultCacheKey(query, timeRange, config); got != key {
+		t.Error("expected the same inputs to give the same key")
+	}
+
+	otherSecret := *config
+	otherSecret.Secrets = &models.SecretPluginSettings{ApiSecret: "other"}
+	otherContext := *config
+	otherContext.SecurityContext = map[string]interface{}{"tenant": "acme"}
+	otherRange := backend.TimeRange{From: from, To: from.Add(2 * time.Hour)}
+	for name, other := range map[string]string{
+		"query":            resultCacheKey([]byte(`{"measures":["orders.total"]}`), timeRange, config),
+		"time range":       resultCacheKey(query, otherRange, config),
+		"credentials":      resultCacheKey(query, timeRange, &otherSecret),
+		"security context": resultCacheKey(query, timeRange, &otherContext),
+	} {
+		if other == key {
+			t.Errorf("expected a different %s to give a different key", name)
+		}
+	}
+}
+
+// newCountingLoadServer answers every /v1/load request with a single
+// orders.count row and counts the requests.
+func newCountingLoadServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var loads atomic.Int32
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		loads.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CubeAPIResponse{
+			Data:       []map[string]interface{}{{"orders.count": "42"}},
+			Annotation: CubeAnnotation{Measures: map[string]CubeFieldInfo{"orders.count": {Type: "number"}}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &loads
+}
+
+func TestQueryDataResultCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		jsonData  string
+		wantLoads int32
+	}{
+		{name: "disabled by default", jsonData: `{"deploymentType": "self-hosted-dev"}`, wantLoads: 2},
+		{name: "enabled", jsonData: `{"deploymentType": "self-hosted-dev", "resultCacheTTL": 60}`, wantLoads: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, loads := newCountingLoadServer(t)
+			ds := &Datasource{BaseURL: server.URL}
+			pCtx := newTestPluginContext(server.URL)
+			pCtx.DataSourceInstanceSettings.JSONData = []byte(tt.jsonData)
+
+			for i := 0; i < 2; i++ {
+				resp := runSingleQuery(t, ds, pCtx, `{"refId": "A", "measures": ["orders.count"]}`)
+				if resp.Error != nil {
+					t.Fatalf("query %d failed: %v", i, resp.Error)
+				}
+				if v := resp.Frames[0].Fields[0].At(0).(*float64); v == nil || *v != 42 {
+					t.Fatalf("query %d: unexpected value %v", i, v)
+				}
+			}
+			if n := loads.Load(); n != tt.wantLoads {
+				t.Errorf("expected %d /v1/load requests, got %d", tt.wantLoads, n)
+			}
+		})
+	}
+}
+
+func TestQueryDataBatchUsesResultCache(t *testing.T) {
+	server, loads := newCountingLoadServer(t)
+	ds := &Datasource{BaseURL: server.URL}
+	pCtx := newTestPluginContext(server.URL)
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "resultCacheTTL": 60}`)
+
+	// Cache A's result with a single query...
+	if resp := runSingleQuery(t, ds, pCtx, `{"refId": "A", "measures": ["orders.count"]}`); resp.Error != nil {
+		t.Fatalf("query failed: %v", resp.Error)
+	}
+
+	// ...then a panel with A and an identical B is answered without Cube.
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pCtx,
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: []byte(`{"refId": "A", "measures": ["orders.count"]}`)},
+			{RefID: "B", JSON: []byte(`{"refId": "B", "measures": ["orders.count"]}`)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("QueryData failed: %v", err)
+	}
+	for _, refID := range []string{"A", "B"} {
+		if res := resp.Responses[refID]; res.Error != nil || len(res.Frames) != 1 {
+			t.Errorf("%s: unexpected response %+v", refID, res)
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Errorf("expected cached queries not to be sent to Cube, got %d /v1/load requests", n)
+	}
+}
@@ -0,0 +1,239 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// cubeDateLayouts are the date formats Cube accepts in a dateRange.
+var cubeDateLayouts = []string{
+	"2006-01-02T15:04:05.000",
+	"2006-01-02T15:04:05",
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+// rewriteQuery applies the datasource's queryRewriteRules to a Cube API
+// query in place. It runs last, on the query as it is sent to Cube, so no
+// other option can undo a rule.
+func rewriteQuery(apiQuery map[string]interface{}, pCtx backend.PluginContext, config *models.PluginSettings, now time.Time) error {
+	if config == nil || len(config.QueryRewriteRules) == 0 {
+		return nil
+	}
+	members := apiQueryMembers(apiQuery)
+	for i, rule := range config.QueryRewriteRules {
+		if len(rule.Views) > 0 && !slices.ContainsFunc(members, func(member string) bool {
+			view, _, _ := strings.Cut(member, ".")
+			return slices.Contains(rule.Views, view)
+		}) {
+			continue
+		}
+		if rule.Filter != nil {
+			filter, err := expandUserVariables(rule.Filter, pCtx)
+			if err != nil {
+				return fmt.Errorf("query rewrite rule %d: %w", i+1, err)
+			}
+			filters, _ := apiQuery["filters"].([]interface{})
+			apiQuery["filters"] = append(slices.Clone(filters), filter)
+		}
+		if rule.MaxDateRangeDays > 0 {
+			if err := boundDateRanges(apiQuery, time.Duration(rule.MaxDateRangeDays)*24*time.Hour, now); err != nil {
+				return fmt.Errorf("query rewrite rule %d: %w", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteQueryJSON applies the datasource's queryRewriteRules to a Cube API
+// query JSON.
+func rewriteQueryJSON(queryJSON []byte, pCtx backend.PluginContext, config *models.PluginSettings) ([]byte, error) {
+	if config == nil || len(config.QueryRewriteRules) == 0 {
+		return queryJSON, nil
+	}
+	var apiQuery map[string]interface{}
+	if err := json.Unmarshal(queryJSON, &apiQuery); err != nil {
+		return nil, err
+	}
+	if err := rewriteQuery(apiQuery, pCtx, config, time.Now()); err != nil {
+		return nil, err
+	}
+	return json.Marshal(apiQuery)
+}
+
+// apiQueryMembers returns the members a Cube API query uses.
+func apiQueryMembers(apiQuery map[string]interface{}) []string {
+	var members []string
+	for _, key := range []string{"measures", "dimensions", "segments"} {
+		switch list := apiQuery[key].(type) {
+		case []string:
+			members = append(members, list...)
+		case []interface{}:
+			for _, m := range list {
+				if member, ok := m.(string); ok {
+					members = append(members, member)
+				}
+			}
+		}
+	}
+	for _, td := range timeDimensionList(apiQuery) {
+		if member, ok := td["dimension"].(string); ok {
+			members = append(members, member)
+		}
+	}
+	filters, _ := apiQuery["filters"].([]interface{})
+	walkMemberFilters(filters, func(member string, _ map[string]interface{}) {
+		members = append(members, member)
+	})
+	return members
+}
+
+// timeDimensionList returns the time dimensions of a Cube API query.
+func timeDimensionList(apiQuery map[string]interface{}) []map[string]interface{} {
+	list, _ := apiQuery["timeDimensions"].([]interface{})
+	result := make([]map[string]interface{}, 0, len(list))
+	for _, td := range list {
+		if obj, ok := td.(map[string]interface{}); ok {
+			result = append(result, obj)
+		}
+	}
+	return result
+}
+
+// expandUserVariables returns a copy of filter with the $user variables in
+// its string values replaced. A rule that references the user fails when the
+// request has none, rather than filtering on an empty value.
+func expandUserVariables(filter map[string]interface{}, pCtx backend.PluginContext) (map[string]interface{}, error) {
+	variables := map[string]string{"$user.orgId": strconv.FormatInt(pCtx.OrgID, 10)}
+	if pCtx.User != nil {
+		variables["$user.login"] = pCtx.User.Login
+		variables["$user.email"] = pCtx.User.Email
+		variables["$user.name"] = pCtx.User.Name
+		variables["$user.role"] = pCtx.User.Role
+	}
+	expand := func(value string) (string, error) {
+		if !strings.Contains(value, "$user.") {
+			return value, nil
+		}
+		for _, name := range []string{"$user.orgId", "$user.login", "$user.email", "$user.name", "$user.role"} {
+			if strings.Contains(value, name) {
+				replacement, ok := variables[name]
+				if !ok {
+					return "", fmt.Errorf("%s is not available: the request has no user", name)
+				}
+				value = strings.ReplaceAll(value, name, replacement)
+			}
+		}
+		return value, nil
+	}
+
+	expanded := make(map[string]interface{}, len(filter))
+	for key, value := range filter {
+		switch v := value.(type) {
+		case string:
+			s, err := expand(v)
+			if err != nil {
+				return nil, err
+			}
+			expanded[key] = s
+		case []interface{}:
+			values := make([]interface{}, len(v))
+			for i, item := range v {
+				values[i] = item
+				if s, ok := item.(string); ok {
+					var err error
+					if values[i], err = expand(s); err != nil {
+						return nil, err
+					}
+				}
+			}
+			expanded[key] = values
+		default:
+			expanded[key] = value
+		}
+	}
+	return expanded, nil
+}
+
+// boundDateRanges shortens the date range of every time dimension of a Cube
+// API query to at most maxRange, keeping its end, and gives time dimensions
+// without one the range ending now. Relative ranges ("last year") cannot be
+// bounded and are rejected.
+func boundDateRanges(apiQuery map[string]interface{}, maxRange time.Duration, now time.Time) error {
+	list, _ := apiQuery["timeDimensions"].([]interface{})
+	bounded := make([]interface{}, len(list))
+	for i, td := range list {
+		obj, ok := td.(map[string]interface{})
+		if !ok {
+			bounded[i] = td
+			continue
+		}
+		obj = maps.Clone(obj)
+		from, to, err := dateRangeBounds(obj["dateRange"])
+		if err != nil {
+			return fmt.Errorf("%v of %v cannot be limited to %d days: %w", obj["dateRange"], obj["dimension"], int(maxRange.Hours()/24), err)
+		}
+		if to.IsZero() {
+			from, to = now.Add(-maxRange), now
+		} else if to.Sub(from) > maxRange {
+			from = to.Add(-maxRange)
+		}
+		obj["dateRange"] = cubeDateRange(from, to)
+		bounded[i] = obj
+	}
+	apiQuery["timeDimensions"] = bounded
+	return nil
+}
+
+// dateRangeBounds parses an absolute Cube dateRange. Both bounds are zero
+// when there is no date range.
+func dateRangeBounds(dateRange interface{}) (time.Time, time.Time, error) {
+	var bounds []string
+	switch r := dateRange.(type) {
+	case nil:
+		return time.Time{}, time.Time{}, nil
+	case []string:
+		bounds = r
+	case []interface{}:
+		for _, b := range r {
+			s, ok := b.(string)
+			if !ok {
+				return time.Time{}, time.Time{}, fmt.Errorf("invalid date %v", b)
+			}
+			bounds = append(bounds, s)
+		}
+	case string:
+		return time.Time{}, time.Time{}, fmt.Errorf("relative date ranges are not supported, use an absolute range")
+	}
+	if len(bounds) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("expected a date range of two dates")
+	}
+	from, err := parseCubeDate(bounds[0])
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := parseCubeDate(bounds[1])
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, to, nil
+}
+
+// parseCubeDate parses a date of a Cube dateRange, in UTC unless it has an
+// offset.
+func parseCubeDate(s string) (time.Time, error) {
+	for _, layout := range cubeDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
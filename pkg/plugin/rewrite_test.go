@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestRewriteQuery(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	pCtx := backend.PluginContext{OrgID: 7, User: &backend.User{Login: "alice"}}
+	tenantRule := models.QueryRewriteRule{
+		Views:  []string{"orders"},
+		Filter: map[string]interface{}{"member": "orders.tenant_id", "operator": "equals", "values": []interface{}{"$user.orgId"}},
+	}
+	tests := []struct {
+		name    string
+		rules   []models.QueryRewriteRule
+		pCtx    backend.PluginContext
+		query   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "adds the filter with user variables",
+			rules: []models.QueryRewriteRule{tenantRule, {Filter: map[string]interface{}{"member": "orders.owner", "operator": "equals", "values": []interface{}{"$user.login"}}}},
+			pCtx:  pCtx,
+			query: `{"measures": ["orders.count"], "filters": [{"member": "orders.status", "operator": "equals", "values": ["shipped"]}]}`,
+			want: `{"filters":[{"member":"orders.status","operator":"equals","values":["shipped"]},` +
+				`{"member":"orders.tenant_id","operator":"equals","values":["7"]},{"member":"orders.owner","operator":"equals","values":["alice"]}],"measures":["orders.count"]}`,
+		},
+		{
+			name:  "rule of another view",
+			rules: []models.QueryRewriteRule{tenantRule},
+			pCtx:  pCtx,
+			query: `{"measures": ["users.count"]}`,
+			want:  `{"measures":["users.count"]}`,
+		},
+		{
+			name:    "user variable without a user",
+			rules:   []models.QueryRewriteRule{{Filter: map[string]interface{}{"member": "orders.owner", "operator": "equals", "values": []interface{}{"$user.login"}}}},
+			query:   `{"measures": ["orders.count"]}`,
+			wantErr: "query rewrite rule 1: $user.login is not available: the request has no user",
+		},
+		{
+			name:  "date range shortened",
+			rules: []models.QueryRewriteRule{{MaxDateRangeDays: 30}},
+			query: `{"timeDimensions": [{"dimension": "orders.created_at", "dateRange": ["2024-01-01", "2024-06-01T00:00:00.000"]}]}`,
+			want:  `{"timeDimensions":[{"dateRange":["2024-05-02T00:00:00.000","2024-06-01T00:00:00.000"],"dimension":"orders.created_at"}]}`,
+		},
+		{
+			name:  "short date range kept",
+			rules: []models.QueryRewriteRule{{MaxDateRangeDays: 30}},
+			query: `{"timeDimensions": [{"dimension": "orders.created_at", "dateRange": ["2024-05-20T00:00:00.000", "2024-06-01T00:00:00.000"]}]}`,
+			want:  `{"timeDimensions":[{"dateRange":["2024-05-20T00:00:00.000","2024-06-01T00:00:00.000"],"dimension":"orders.created_at"}]}`,
+		},
+		{
+			name:  "missing date range",
+			rules: []models.QueryRewriteRule{{MaxDateRangeDays: 1}},
+			query: `{"timeDimensions": [{"dimension": "orders.created_at", "granularity": "hour"}]}`,
+			want:  `{"timeDimensions":[{"dateRange":["2024-06-29T12:00:00.000","2024-06-30T12:00:00.000"],"dimension":"orders.created_at","granularity":"hour"}]}`,
+		},
+		{
+			name:    "relative date range",
+			rules:   []models.QueryRewriteRule{{MaxDateRangeDays: 30}},
+			query:   `{"timeDimensions": [{"dimension": "orders.created_at", "dateRange": "last year"}]}`,
+			wantErr: "relative date ranges are not supported",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var apiQuery map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.query), &apiQuery); err != nil {
+				t.Fatalf("invalid query: %v", err)
+			}
+			err := rewriteQuery(apiQuery, tt.pCtx, &models.PluginSettings{QueryRewriteRules: tt.rules}, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, _ := json.Marshal(apiQuery)
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryDataAndTagValuesApplyRewriteRules(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("query"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [{"orders.status": "shipped"}]}`))
+	}))
+	defer server.Close()
+
+	pCtx := newTestPluginContextWithUser(server.URL, "Viewer")
+	pCtx.OrgID = 3
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "queryRewriteRules": [
+		{"filter": {"member": "orders.tenant_id", "operator": "equals", "values": ["$user.orgId"]}}]}`)
+	ds := &Datasource{BaseURL: server.URL}
+
+	res := runSingleQuery(t, ds, pCtx, `{"refId":"A","dimensions":["orders.status"],"ignoreDefaultFilters":true}`)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	callHandler(t, ds.handleTagValues, &backend.CallResourceRequest{PluginContext: pCtx, URL: "tag-values?key=orders.status"})
+
+	if len(queries) != 2 {
+		t.Fatalf("expected 2 queries, got %v", queries)
+	}
+	for _, q := range queries {
+		if !strings.Contains(q, `{"member":"orders.tenant_id","operator":"equals","values":["3"]}`) {
+			t.Errorf("expected the tenant filter, got %s", q)
+		}
+	}
+}
+
+func TestQueryResourcesApplyRewriteRules(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("query"))
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/v1/dry-run") {
+			_, _ = w.Write([]byte(`{"queryType": "regularQuery", "normalizedQueries": [], "pivotQuery": {}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"sql": {"sql": ["SELECT 1", []]}}`))
+	}))
+	defer server.Close()
+
+	pCtx := newTestPluginContextWithUser(server.URL, "Viewer")
+	pCtx.OrgID = 3
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "queryRewriteRules": [
+		{"filter": {"member": "orders.tenant_id", "operator": "equals", "values": ["$user.orgId"]}}]}`)
+	ds := &Datasource{BaseURL: server.URL}
+
+	query := url.QueryEscape(`{"dimensions": ["orders.status"]}`)
+	for name, handler := range map[string]backend.CallResourceHandlerFunc{
+		"sql":                     ds.handleSQLCompilation,
+		"dry-run":                 ds.handleDryRun,
+		"pre-aggregation-preview": ds.handlePreAggregationPreview,
+	} {
+		resp := callHandler(t, handler, &backend.CallResourceRequest{PluginContext: pCtx, URL: name + "?query=" + query})
+		if resp.Status != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d: %s", name, resp.Status, resp.Body)
+		}
+	}
+
+	if len(queries) != 3 {
+		t.Fatalf("expected 3 queries, got %v", queries)
+	}
+	for _, q := range queries {
+		if !strings.Contains(q, `{"member":"orders.tenant_id","operator":"equals","values":["3"]}`) {
+			t.Errorf("expected the tenant filter, got %s", q)
+		}
+	}
+}
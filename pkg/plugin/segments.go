@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// segmentKeyPrefix marks an AdHoc filter key that stands for a Cube segment
+// rather than a dimension: "segment:orders.completed". Filtering on it with
+// the value true adds the segment to the query.
+const segmentKeyPrefix = "segment:"
+
+// segmentTagValues are the tag values offered for a segment key. Cube
+// segments can only be applied, not negated, so "true" is the only value.
+var segmentTagValues = []TagValue{{Text: "true"}}
+
+// segmentFromKey returns the segment an AdHoc filter key stands for.
+func segmentFromKey(key string) (string, bool) {
+	segment, ok := strings.CutPrefix(key, segmentKeyPrefix)
+	return segment, ok && segment != ""
+}
+
+// appliesSegment reports whether a filter on a segment key selects the
+// segment: "= true" or "!= false".
+func appliesSegment(operator string, values []interface{}) bool {
+	if len(values) != 1 {
+		return false
+	}
+	value := strings.ToLower(fmt.Sprint(values[0]))
+	return (operator == "equals" && value == "true") || (operator == "notEquals" && value == "false")
+}
+
+// extractSegmentFilters moves the top-level filters on segment keys (the
+// filters AdHoc variables produce) out of filters and returns the segments
+// they select. A filter that would exclude a segment is an error, since Cube
+// cannot negate segments.
+func extractSegmentFilters(filters []interface{}) ([]interface{}, []string, error) {
+	var kept []interface{}
+	var segments []string
+	for _, f := range filters {
+		obj, ok := f.(map[string]interface{})
+		if !ok {
+			kept = append(kept, f)
+			continue
+		}
+		member, _ := obj["member"].(string)
+		segment, ok := segmentFromKey(member)
+		if !ok {
+			kept = append(kept, f)
+			continue
+		}
+		operator, _ := obj["operator"].(string)
+		values, _ := obj["values"].([]interface{})
+		if !appliesSegment(operator, values) {
+			return nil, nil, fmt.Errorf("invalid filter on segment %s: segments can only be filtered with = true", segment)
+		}
+		if !slices.Contains(segments, segment) {
+			segments = append(segments, segment)
+		}
+	}
+	return kept, segments, nil
+}
+
+// withSegmentFiltersJSON applies extractSegmentFilters to a Cube query JSON,
+// adding the selected segments to its segments. The query is returned
+// unchanged when it has no filter on a segment key.
+func withSegmentFiltersJSON(queryJSON string) (string, error) {
+	var query map[string]interface{}
+	if err := json.Unmarshal([]byte(queryJSON), &query); err != nil {
+		return "", err
+	}
+	filters, _ := query["filters"].([]interface{})
+	kept, segments, err := extractSegmentFilters(filters)
+	if err != nil {
+		return "", err
+	}
+	if len(segments) == 0 {
+		return queryJSON, nil
+	}
+	existing, _ := query["segments"].([]interface{})
+	for _, segment := range segments {
+		if !slices.Contains(existing, interface{}(segment)) {
+			existing = append(existing, segment)
+		}
+	}
+	query["segments"] = existing
+	if len(kept) > 0 {
+		query["filters"] = kept
+	} else {
+		delete(query, "filters")
+	}
+	out, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// segmentOptions returns the segments of a view as AdHoc filter keys.
+func segmentOptions(view CubeMeta, dataSource string) []SelectOption {
+	options := make([]SelectOption, 0, len(view.Segments))
+	for _, segment := range view.Segments {
+		options = append(options, SelectOption{
+			Label:       segment.Name,
+			Value:       segmentKeyPrefix + segment.Name,
+			Type:        "boolean",
+			Description: segment.Description,
+			Cube:        view.Name,
+			DataSource:  dataSource,
+		})
+	}
+	return options
+}
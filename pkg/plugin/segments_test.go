@@ -0,0 +1,159 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestExtractSegmentFilters(t *testing.T) {
+	status := map[string]interface{}{"member": "orders.status", "operator": "equals", "values": []interface{}{"completed"}}
+	tests := []struct {
+		name         string
+		filters      []interface{}
+		wantFilters  []interface{}
+		wantSegments []string
+		wantErr      bool
+	}{
+		{
+			name:        "no segment filters",
+			filters:     []interface{}{status},
+			wantFilters: []interface{}{status},
+		},
+		{
+			name: "equals true",
+			filters: []interface{}{status,
+				map[string]interface{}{"member": "segment:orders.completed", "operator": "equals", "values": []interface{}{"true"}}},
+			wantFilters:  []interface{}{status},
+			wantSegments: []string{"orders.completed"},
+		},
+		{
+			name: "not equals false, repeated",
+			filters: []interface{}{
+				map[string]interface{}{"member": "segment:orders.completed", "operator": "notEquals", "values": []interface{}{"false"}},
+				map[string]interface{}{"member": "segment:orders.completed", "operator": "equals", "values": []interface{}{true}}},
+			wantSegments: []string{"orders.completed"},
+		},
+		{
+			name: "negated segment",
+			filters: []interface{}{
+				map[string]interface{}{"member": "segment:orders.completed", "operator": "equals", "values": []interface{}{"false"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, segments, err := extractSegmentFilters(tt.filters)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(filters, tt.wantFilters) || !reflect.DeepEqual(segments, tt.wantSegments) {
+				t.Errorf("got filters %v, segments %v", filters, segments)
+			}
+		})
+	}
+}
+
+func TestWithSegmentFiltersJSON(t *testing.T) {
+	got, err := withSegmentFiltersJSON(`{"measures":["orders.count"],"segments":["orders.big"],"filters":[{"member":"segment:orders.completed","operator":"equals","values":["true"]}]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `{"measures":["orders.count"],"segments":["orders.big","orders.completed"]}`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	unchanged := `{"measures": ["orders.count"]}`
+	if got, _ := withSegmentFiltersJSON(unchanged); got != unchanged {
+		t.Errorf("expected a query without segment filters to be unchanged, got %s", got)
+	}
+}
+
+func TestQueryDataSegmentFilter(t *testing.T) {
+	var sent map[string]interface{}
+	server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("query")), &sent); err != nil {
+			t.Errorf("failed to parse query: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [{"orders.count": "3"}], "annotation": {"measures": {"orders.count": {"type": "number"}}}}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	res := runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId": "A", "measures": ["orders.count"],
+		"filters": [{"member": "segment:orders.completed", "operator": "equals", "values": ["true"]}]}`)
+	if res.Error != nil {
+		t.Fatalf("query failed: %v", res.Error)
+	}
+	if !reflect.DeepEqual(sent["segments"], []interface{}{"orders.completed"}) {
+		t.Errorf("expected the segment in the Cube query, got %v", sent["segments"])
+	}
+	if _, ok := sent["filters"]; ok {
+		t.Errorf("expected the segment filter to be removed, got %v", sent["filters"])
+	}
+
+	res = runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId": "A", "measures": ["orders.count"],
+		"filters": [{"member": "segment:orders.completed", "operator": "notEquals", "values": ["true"]}]}`)
+	if res.Error == nil || res.Status != backend.StatusBadRequest {
+		t.Errorf("expected a bad request for a negated segment, got %v", res.Error)
+	}
+}
+
+func TestHandleMetadataSegments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"cubes": [{"name": "orders_view", "type": "view",
+			"dimensions": [{"name": "orders_view.status", "type": "string"}],
+			"measures": [],
+			"segments": [{"name": "orders_view.completed", "description": "Completed orders"}]}]}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	resp := callHandler(t, ds.handleMetadata, &backend.CallResourceRequest{
+		Path:          "metadata",
+		URL:           "/metadata",
+		PluginContext: newTestPluginContext(server.URL),
+	})
+	if resp.Status != 200 {
+		t.Fatalf("expected status 200, got %d: %s", resp.Status, resp.Body)
+	}
+	var metadata MetadataResponse
+	if err := json.Unmarshal(resp.Body, &metadata); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	want := []SelectOption{{
+		Label:       "orders_view.completed",
+		Value:       "segment:orders_view.completed",
+		Type:        "boolean",
+		Description: "Completed orders",
+		Cube:        "orders_view",
+	}}
+	if !reflect.DeepEqual(metadata.Segments, want) {
+		t.Errorf("segments = %+v, want %+v", metadata.Segments, want)
+	}
+}
+
+func TestHandleTagValuesSegmentKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected no Cube request for a segment key, got %s", r.URL.Path)
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	resp := callHandler(t, ds.handleTagValues, &backend.CallResourceRequest{
+		Path:          "tag-values",
+		URL:           "/tag-values?key=segment:orders.completed",
+		PluginContext: newTestPluginContext(server.URL),
+	})
+	if resp.Status != 200 || strings.TrimSpace(string(resp.Body)) != `[{"text":"true"}]` {
+		t.Errorf("unexpected response %d: %s", resp.Status, resp.Body)
+	}
+}
@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// maxLoggedQueryLength bounds how much of the query JSON a slow query log
+// entry includes, so huge filter lists do not flood the logs.
+const maxLoggedQueryLength = 1000
+
+// countContinueWaits returns a context that counts the Continue-wait
+// responses of the /v1/load requests made with it. An observer already set on
+// ctx (e.g. by a stream) keeps being called.
+func countContinueWaits(ctx context.Context) (context.Context, *int) {
+	polls := new(int)
+	parent := continueWaitObserverFrom(ctx)
+	return withContinueWaitObserver(ctx, func(progress continueWaitProgress) {
+		*polls++
+		if parent != nil {
+			parent(progress)
+		}
+	}), polls
+}
+
+// logSlowQuery logs a /v1/load request at WARN and counts it in the slow
+// query metric when it took longer than the configured threshold. Failed
+// requests are reported too: a query that times out after minutes is the
+// slowest query of all.
+func logSlowQuery(ctx context.Context, config *models.PluginSettings, queryJSON []byte, duration time.Duration, polls int, rows int, err error) {
+	threshold := config.SlowQueryThreshold()
+	if threshold == 0 || duration < threshold {
+		return
+	}
+
+	slowQueriesTotal.Inc()
+
+	query := string(queryJSON)
+	if len(query) > maxLoggedQueryLength {
+		query = query[:maxLoggedQueryLength] + "...(truncated)"
+	}
+	args := []interface{}{
+		"query", query,
+		"duration", duration.Round(time.Millisecond),
+		"threshold", threshold,
+		"polls", polls,
+		"rows", rows,
+	}
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	backend.Logger.FromContext(ctx).Warn("Slow Cube query", args...)
+}
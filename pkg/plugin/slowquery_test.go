@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLogSlowQueryCountsOnlySlowQueries(t *testing.T) {
+	threshold := 100
+	config := &models.PluginSettings{SlowQueryThresholdMs: &threshold}
+
+	tests := []struct {
+		name     string
+		config   *models.PluginSettings
+		duration time.Duration
+		err      error
+		want     float64
+	}{
+		{name: "below threshold", config: config, duration: 50 * time.Millisecond, want: 0},
+		{name: "above threshold", config: config, duration: 150 * time.Millisecond, want: 1},
+		{name: "failed and slow", config: config, duration: time.Second, err: errors.New("timeout"), want: 1},
+		{name: "disabled", config: devConfig(), duration: time.Hour, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(slowQueriesTotal)
+			logSlowQuery(context.Background(), tt.config, []byte(`{"measures":["orders.count"]}`), tt.duration, 2, 10, tt.err)
+			if got := testutil.ToFloat64(slowQueriesTotal) - before; got != tt.want {
+				t.Errorf("expected slow query counter to grow by %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCountContinueWaits(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 3 {
+			_, _ = w.Write([]byte(`{"error": "Continue wait"}`))
+			return
+		}
+		_, _ = w.Write(successBody(t))
+	}))
+	defer server.Close()
+
+	parentCalls := 0
+	ctx := withContinueWaitObserver(context.Background(), func(continueWaitProgress) { parentCalls++ })
+	ctx, polls := countContinueWaits(ctx)
+
+	ds := &Datasource{}
+	if _, err := ds.doCubeLoadRequest(ctx, server.URL, []byte(`{}`), devConfig()); err != nil {
+		t.Fatalf("doCubeLoadRequest failed: %v", err)
+	}
+	if *polls != 3 {
+		t.Errorf("expected 3 polls, got %d", *polls)
+	}
+	if parentCalls != 3 {
+		t.Errorf("expected the existing observer to see 3 polls, got %d", parentCalls)
+	}
+}
@@ -0,0 +1,624 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// sqlAPIDefaultPort is the port Cube's SQL API listens on by default
+// (CUBEJS_PG_SQL_PORT).
+const sqlAPIDefaultPort = "15432"
+
+// sqlAPIDatabase is the database name sent on connect. Cube's SQL API
+// accepts any name.
+const sqlAPIDatabase = "db"
+
+// Postgres type OIDs of the SQL API's result columns.
+const (
+	pgTypeBool        = 16
+	pgTypeInt8        = 20
+	pgTypeInt2        = 21
+	pgTypeInt4        = 23
+	pgTypeFloat4      = 700
+	pgTypeFloat8      = 701
+	pgTypeDate        = 1082
+	pgTypeTimestamp   = 1114
+	pgTypeTimestampTZ = 1184
+	pgTypeNumeric     = 1700
+)
+
+// pgTimeLayouts are the text formats of Postgres dates and timestamps.
+var pgTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// dateOnly matches a date range bound without a time of day.
+var dateOnly = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// numericLiteral matches the filter values compiled as SQL numbers.
+var numericLiteral = regexp.MustCompile(`^-?\d+(\.\d+)?([eE][-+]?\d+)?$`)
+
+// usesSQLAPI reports whether the datasource sends panel queries to Cube's
+// SQL API instead of /v1/load.
+func usesSQLAPI(config *models.PluginSettings) bool {
+	return config != nil && config.QueryTransport == models.QueryTransportSQL
+}
+
+// singleQueryTransport reports whether the datasource of pCtx uses a
+// transport that runs one query per request (the SQL API or GraphQL), so
+// queries cannot be batched or blended.
+func (d *Datasource) singleQueryTransport(pCtx backend.PluginContext) bool {
+	if pCtx.DataSourceInstanceSettings == nil {
+		return false
+	}
+	config, err := d.loadSettings(*pCtx.DataSourceInstanceSettings)
+	return err == nil && (usesSQLAPI(config) || usesGraphQL(config))
+}
+
+// validateQueryTransport checks that the configured query transport is known.
+func validateQueryTransport(config *models.PluginSettings) error {
+	switch config.QueryTransport {
+	case "", models.QueryTransportREST, models.QueryTransportSQL, models.QueryTransportGraphQL:
+		return nil
+	default:
+		return fmt.Errorf("unknown query transport: %q (valid values: %s, %s, %s)", config.QueryTransport, models.QueryTransportREST, models.QueryTransportSQL, models.QueryTransportGraphQL)
+	}
+}
+
+// sqlAPIAddress returns the host:port of Cube's SQL API: sqlApiAddress, or
+// the host of the (first) Cube URL on the default SQL API port.
+func sqlAPIAddress(config *models.PluginSettings) (string, error) {
+	if config.SQLAPIAddress != "" {
+		return config.SQLAPIAddress, nil
+	}
+	endpoints := append(config.Endpoints(), "")
+	parsed, err := url.Parse(endpoints[0])
+	if err != nil || parsed.Hostname() == "" {
+		return "", errors.New("SQL API address is required when the Cube URL has no host")
+	}
+	return net.JoinHostPort(parsed.Hostname(), sqlAPIDefaultPort), nil
+}
+
+// sqlAPIQuery is the part of a Cube query the SQL compiler understands.
+type sqlAPIQuery struct {
+	Measures       []string `json:"measures"`
+	Dimensions     []string `json:"dimensions"`
+	TimeDimensions []struct {
+		Dimension   string      `json:"dimension"`
+		Granularity string      `json:"granularity"`
+		DateRange   interface{} `json:"dateRange"`
+	} `json:"timeDimensions"`
+	Filters  []interface{}   `json:"filters"`
+	Segments []string        `json:"segments"`
+	Order    json.RawMessage `json:"order"`
+	Limit    *int            `json:"limit"`
+	Offset   *int            `json:"offset"`
+}
+
+// sqlAPICompiler compiles a Cube query into a query on the cube's (or
+// view's) table in Cube's SQL API. Result columns are aliased to the member
+// names /v1/load returns, so the result converts like a REST result.
+type sqlAPICompiler struct {
+	cube     string
+	measures map[string]bool
+	// selected maps the members in the select list to their expression.
+	selected map[string]string
+	// timeMembers are the selected members truncated to a granularity.
+	timeMembers map[string]bool
+}
+
+// compileSQLAPIQuery compiles a Cube /v1/load query JSON into SQL for Cube's
+// SQL API. Only queries on a single cube or view can be compiled.
+// measureFilters are the filtered measures that are not necessarily queried,
+// whose filters belong in HAVING too.
+func compileSQLAPIQuery(queryJSON []byte, measureFilters []string) (string, map[string]bool, error) {
+	var q sqlAPIQuery
+	if err := json.Unmarshal(queryJSON, &q); err != nil {
+		return "", nil, err
+	}
+	c := &sqlAPICompiler{measures: make(map[string]bool), selected: make(map[string]string), timeMembers: make(map[string]bool)}
+	for _, measure := range measureFilters {
+		c.measures[measure] = true
+	}
+
+	var selects, groupBy, where, having []string
+	selectMember := func(member, expr string, group bool) {
+		if _, ok := c.selected[member]; ok {
+			return
+		}
+		c.selected[member] = expr
+		selects = append(selects, expr+" AS "+quoteIdent(member))
+		if group {
+			groupBy = append(groupBy, strconv.Itoa(len(selects)))
+		}
+	}
+
+	for _, td := range q.TimeDimensions {
+		column, err := c.column(td.Dimension)
+		if err != nil {
+			return "", nil, err
+		}
+		if td.Granularity != "" {
+			selectMember(td.Dimension, fmt.Sprintf("DATE_TRUNC(%s, %s)", quoteLiteral(td.Granularity), column), true)
+			c.timeMembers[td.Dimension] = true
+		}
+		if td.DateRange != nil {
+			cond, err := c.dateRange(column, td.DateRange, false)
+			if err != nil {
+				return "", nil, err
+			}
+			where = append(where, cond)
+		}
+	}
+	for _, dimension := range q.Dimensions {
+		column, err := c.column(dimension)
+		if err != nil {
+			return "", nil, err
+		}
+		selectMember(dimension, column, true)
+	}
+	for _, measure := range q.Measures {
+		column, err := c.column(measure)
+		if err != nil {
+			return "", nil, err
+		}
+		c.measures[measure] = true
+		selectMember(measure, "MEASURE("+column+")", false)
+	}
+	for _, segment := range q.Segments {
+		column, err := c.column(segment)
+		if err != nil {
+			return "", nil, err
+		}
+		where = append(where, column+" IS TRUE")
+	}
+	for _, filter := range q.Filters {
+		cond, onMeasure, err := c.filter(filter)
+		if err != nil {
+			return "", nil, err
+		}
+		if onMeasure {
+			having = append(having, cond)
+		} else {
+			where = append(where, cond)
+		}
+	}
+	if len(selects) == 0 {
+		return "", nil, errors.New("query must have at least one measure or dimension")
+	}
+
+	var sql strings.Builder
+	sql.WriteString("SELECT " + strings.Join(selects, ", ") + " FROM " + quoteIdent(c.cube))
+	if len(where) > 0 {
+		sql.WriteString(" WHERE " + strings.Join(where, " AND "))
+	}
+	if len(groupBy) > 0 {
+		sql.WriteString(" GROUP BY " + strings.Join(groupBy, ", "))
+	}
+	if len(having) > 0 {
+		sql.WriteString(" HAVING " + strings.Join(having, " AND "))
+	}
+	order, err := c.orderBy(q.Order)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(order) > 0 {
+		sql.WriteString(" ORDER BY " + strings.Join(order, ", "))
+	}
+	if q.Limit != nil {
+		sql.WriteString(" LIMIT " + strconv.Itoa(*q.Limit))
+	}
+	if q.Offset != nil {
+		sql.WriteString(" OFFSET " + strconv.Itoa(*q.Offset))
+	}
+	return sql.String(), c.timeMembers, nil
+}
+
+// column returns the quoted column of a member, checking that every member
+// belongs to the same cube.
+func (c *sqlAPICompiler) column(member string) (string, error) {
+	cube, name, ok := strings.Cut(member, ".")
+	if !ok || cube == "" || name == "" {
+		return "", fmt.Errorf("invalid member %q", member)
+	}
+	if c.cube == "" {
+		c.cube = cube
+	} else if c.cube != cube {
+		return "", fmt.Errorf("the SQL API transport only supports queries on a single cube or view, got %s and %s", c.cube, cube)
+	}
+	return quoteIdent(name), nil
+}
+
+// filter compiles a Cube filter (a member filter or an and/or group) into a
+// condition, reporting whether it applies to a measure (and so belongs in
+// HAVING). Filters on members that are neither queried nor known filtered
+// measures are dimension filters.
+func (c *sqlAPICompiler) filter(filter interface{}) (string, bool, error) {
+	obj, ok := filter.(map[string]interface{})
+	if !ok {
+		return "", false, fmt.Errorf("invalid filter %v", filter)
+	}
+	for _, op := range []string{"and", "or"} {
+		group, ok := obj[op].([]interface{})
+		if !ok {
+			continue
+		}
+		conds := make([]string, 0, len(group))
+		var onMeasure, onDimension bool
+		for _, f := range group {
+			cond, measure, err := c.filter(f)
+			if err != nil {
+				return "", false, err
+			}
+			onMeasure = onMeasure || measure
+			onDimension = onDimension || !measure
+			conds = append(conds, cond)
+		}
+		if onMeasure && onDimension {
+			return "", false, fmt.Errorf("%s filters mixing measures and dimensions are not supported by the SQL API transport", op)
+		}
+		if len(conds) == 0 {
+			return "TRUE", false, nil
+		}
+		return "(" + strings.Join(conds, " "+strings.ToUpper(op)+" ") + ")", onMeasure, nil
+	}
+
+	member, _ := obj["member"].(string)
+	if member == "" {
+		member, _ = obj["dimension"].(string)
+	}
+	column, err := c.column(member)
+	if err != nil {
+		return "", false, err
+	}
+	onMeasure := c.measures[member]
+	if onMeasure {
+		column = "MEASURE(" + column + ")"
+	}
+	operator, _ := obj["operator"].(string)
+	values, _ := obj["values"].([]interface{})
+	cond, err := c.condition(column, operator, values)
+	return cond, onMeasure, err
+}
+
+// condition compiles a member filter's operator and values into a condition
+// on column.
+func (c *sqlAPICompiler) condition(column, operator string, values []interface{}) (string, error) {
+	literals := make([]string, len(values))
+	for i, v := range values {
+		literals[i] = quoteLiteral(fmt.Sprint(v))
+	}
+	like := func(pattern func(string) string, not bool) (string, error) {
+		if len(values) == 0 {
+			return "", fmt.Errorf("%s filter requires values", operator)
+		}
+		conds := make([]string, len(values))
+		for i, v := range values {
+			conds[i] = column + " ILIKE " + quoteLiteral(pattern(escapeLike(fmt.Sprint(v))))
+		}
+		if not {
+			return "NOT (" + strings.Join(conds, " OR ") + ")", nil
+		}
+		return "(" + strings.Join(conds, " OR ") + ")", nil
+	}
+	compare := func(op string) (string, error) {
+		if len(values) != 1 {
+			return "", fmt.Errorf("%s filter requires exactly one value", operator)
+		}
+		return column + " " + op + " " + numericOrQuoted(fmt.Sprint(values[0])), nil
+	}
+
+	switch operator {
+	case "equals":
+		if len(values) == 0 {
+			return "", errors.New("equals filter requires values")
+		}
+		return column + " IN (" + strings.Join(literals, ", ") + ")", nil
+	case "notEquals":
+		if len(values) == 0 {
+			return "", errors.New("notEquals filter requires values")
+		}
+		return "(" + column + " NOT IN (" + strings.Join(literals, ", ") + ") OR " + column + " IS NULL)", nil
+	case "contains":
+		return like(func(v string) string { return "%" + v + "%" }, false)
+	case "notContains":
+		return like(func(v string) string { return "%" + v + "%" }, true)
+	case "startsWith":
+		return like(func(v string) string { return v + "%" }, false)
+	case "notStartsWith":
+		return like(func(v string) string { return v + "%" }, true)
+	case "endsWith":
+		return like(func(v string) string { return "%" + v }, false)
+	case "notEndsWith":
+		return like(func(v string) string { return "%" + v }, true)
+	case "gt":
+		return compare(">")
+	case "gte":
+		return compare(">=")
+	case "lt":
+		return compare("<")
+	case "lte":
+		return compare("<=")
+	case "set":
+		return column + " IS NOT NULL", nil
+	case "notSet":
+		return column + " IS NULL", nil
+	case "inDateRange", "notInDateRange":
+		return c.dateRange(column, values, operator == "notInDateRange")
+	case "beforeDate":
+		return compare("<")
+	case "beforeOrOnDate":
+		return compare("<=")
+	case "afterDate":
+		return compare(">")
+	case "afterOrOnDate":
+		return compare(">=")
+	default:
+		return "", fmt.Errorf("filter operator %q is not supported by the SQL API transport", operator)
+	}
+}
+
+// dateRange compiles a [from, to] date range into a condition on column. Like
+// Cube, a date-only end includes the whole day. Relative ranges ("last 7
+// days") are resolved by Cube's REST API only and are rejected.
+func (c *sqlAPICompiler) dateRange(column string, dateRange interface{}, not bool) (string, error) {
+	bounds, ok := dateRange.([]interface{})
+	if !ok || len(bounds) != 2 {
+		return "", fmt.Errorf("date range %v is not supported by the SQL API transport: use a [from, to] range", dateRange)
+	}
+	from, to := fmt.Sprint(bounds[0]), fmt.Sprint(bounds[1])
+	if dateOnly.MatchString(to) {
+		to += "T23:59:59.999"
+	}
+	cond := fmt.Sprintf("%s >= %s AND %s <= %s", column, quoteLiteral(from), column, quoteLiteral(to))
+	if not {
+		return "NOT (" + cond + ")", nil
+	}
+	return "(" + cond + ")", nil
+}
+
+// orderBy compiles a Cube order, either {"member": "asc"} or
+// [["member", "asc"], ...], into ORDER BY terms.
+func (c *sqlAPICompiler) orderBy(order json.RawMessage) ([]string, error) {
+	pairs, err := orderPairs(order)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		member, direction := pair[0], strings.ToUpper(pair[1])
+		if direction != "ASC" && direction != "DESC" {
+			return nil, fmt.Errorf("invalid order direction %q for %s", pair[1], member)
+		}
+		expr := quoteIdent(member)
+		if _, ok := c.selected[member]; !ok {
+			column, err := c.column(member)
+			if err != nil {
+				return nil, err
+			}
+			expr = column
+		}
+		terms = append(terms, expr+" "+direction)
+	}
+	return terms, nil
+}
+
+// orderPairs returns the [member, direction] pairs of a Cube order, given as
+// an object or as an array of pairs. The members of an object are the
+// order's priority, so its keys are read in document order.
+func orderPairs(order json.RawMessage) ([][2]string, error) {
+	order = bytes.TrimSpace(order)
+	if len(order) == 0 || bytes.Equal(order, []byte("null")) {
+		return nil, nil
+	}
+	var pairs [][2]string
+	if order[0] == '[' {
+		var entries []json.RawMessage
+		if err := json.Unmarshal(order, &entries); err != nil {
+			return nil, fmt.Errorf("invalid order: %w", err)
+		}
+		for _, entry := range entries {
+			var pair []interface{}
+			if err := json.Unmarshal(entry, &pair); err != nil || len(pair) != 2 {
+				return nil, fmt.Errorf("invalid order %s", entry)
+			}
+			pairs = append(pairs, [2]string{fmt.Sprint(pair[0]), fmt.Sprint(pair[1])})
+		}
+		return pairs, nil
+	}
+	if order[0] != '{' {
+		return nil, fmt.Errorf("invalid order %s", order)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(order))
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("invalid order: %w", err)
+	}
+	for decoder.More() {
+		member, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("invalid order: %w", err)
+		}
+		var direction interface{}
+		if err := decoder.Decode(&direction); err != nil {
+			return nil, fmt.Errorf("invalid order of %v: %w", member, err)
+		}
+		pairs = append(pairs, [2]string{member.(string), fmt.Sprint(direction)})
+	}
+	return pairs, nil
+}
+
+// sqlAPIResult converts a SQL API result into the shape of a /v1/load
+// result: rows keyed by member, numbers and timestamps as strings, and an
+// annotation typed from the column types.
+func sqlAPIResult(result *pgResult, measures []string, timeMembers map[string]bool) CubeAPIResponse {
+	isMeasure := make(map[string]bool, len(measures))
+	for _, m := range measures {
+		isMeasure[m] = true
+	}
+	annotation := CubeAnnotation{
+		Measures:       map[string]CubeFieldInfo{},
+		Dimensions:     map[string]CubeFieldInfo{},
+		Segments:       map[string]CubeFieldInfo{},
+		TimeDimensions: map[string]CubeFieldInfo{},
+	}
+	for _, col := range result.Columns {
+		info := CubeFieldInfo{Title: col.Name, ShortTitle: col.Name, Type: pgAnnotationType(col.TypeOID)}
+		switch {
+		case timeMembers[col.Name]:
+			annotation.TimeDimensions[col.Name] = info
+		case isMeasure[col.Name]:
+			annotation.Measures[col.Name] = info
+		default:
+			annotation.Dimensions[col.Name] = info
+		}
+	}
+
+	rows := make([]map[string]interface{}, 0, len(result.Rows))
+	for _, values := range result.Rows {
+		row := make(map[string]interface{}, len(values))
+		for i, value := range values {
+			if value == nil || i >= len(result.Columns) {
+				continue
+			}
+			col := result.Columns[i]
+			row[col.Name] = pgValue(*value, col.TypeOID)
+		}
+		rows = append(rows, row)
+	}
+	return CubeAPIResponse{Data: rows, Annotation: annotation}
+}
+
+// pgAnnotationType maps a Postgres type to a Cube annotation type.
+func pgAnnotationType(oid uint32) string {
+	switch oid {
+	case pgTypeInt2, pgTypeInt4, pgTypeInt8, pgTypeFloat4, pgTypeFloat8, pgTypeNumeric:
+		return "number"
+	case pgTypeDate, pgTypeTimestamp, pgTypeTimestampTZ:
+		return "time"
+	case pgTypeBool:
+		return "boolean"
+	default:
+		return "string"
+	}
+}
+
+// pgValue converts a text-format Postgres value into the value /v1/load
+// would return: booleans as bool, timestamps in Cube's format, everything
+// else as a string.
+func pgValue(value string, oid uint32) interface{} {
+	switch oid {
+	case pgTypeBool:
+		return value == "t" || value == "true"
+	case pgTypeDate, pgTypeTimestamp, pgTypeTimestampTZ:
+		for _, layout := range pgTimeLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t.UTC().Format("2006-01-02T15:04:05.000")
+			}
+		}
+	}
+	return value
+}
+
+// loadSQLAPIResult runs a query on Cube's SQL API and converts the result
+// like a /v1/load result, caching it when the result cache is enabled.
+func (d *Datasource) loadSQLAPIResult(ctx context.Context, config *models.PluginSettings, cubeAPIQueryJSON []byte, measures, measureFilters []string, cacheKey string) (CubeAPIResponse, error) {
+	sql, timeMembers, err := compileSQLAPIQuery(cubeAPIQueryJSON, measureFilters)
+	if err != nil {
+		return CubeAPIResponse{}, &loadRequestError{status: backend.StatusBadRequest, msg: fmt.Sprintf("Failed to compile query for the SQL API: %v", err)}
+	}
+	addr, err := sqlAPIAddress(config)
+	if err != nil {
+		return CubeAPIResponse{}, &loadRequestError{status: backend.StatusBadRequest, msg: err.Error()}
+	}
+	backend.Logger.FromContext(ctx).Debug("Running query on the Cube SQL API", "address", addr, "sql", sql)
+
+	ctx, cancel := withTimeout(ctx, config.QueryTimeoutDuration())
+	defer cancel()
+	ctx, done := d.loads.track(ctx)
+	defer done()
+	release, err := d.acquireQuerySlot(ctx, config)
+	if err != nil {
+		return CubeAPIResponse{}, err
+	}
+	defer release()
+
+	start := time.Now()
+	result, err := d.runSQLAPIQuery(ctx, config, addr, sql)
+	if err != nil {
+		logSlowQuery(ctx, config, []byte(sql), time.Since(start), 0, 0, err)
+		backend.Logger.FromContext(ctx).Error("Failed to run query on the Cube SQL API", "error", err, "address", addr)
+		var pgErr *pgError
+		if errors.As(err, &pgErr) {
+			return CubeAPIResponse{}, &loadRequestError{status: backend.StatusBadRequest, msg: "Cube SQL API error: " + pgErr.Error()}
+		}
+		var reqErr *loadRequestError
+		if errors.As(err, &reqErr) {
+			return CubeAPIResponse{}, err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return CubeAPIResponse{}, &loadRequestError{status: statusForContextErr(ctxErr), msg: fmt.Sprintf("Cube SQL API request failed: %v", context.Cause(ctx))}
+		}
+		return CubeAPIResponse{}, &loadRequestError{status: backend.StatusBadGateway, msg: fmt.Sprintf("Cube SQL API request failed: %v", err)}
+	}
+	apiResponse := sqlAPIResult(result, measures, timeMembers)
+	logSlowQuery(ctx, config, []byte(sql), time.Since(start), 0, len(apiResponse.Data), nil)
+	d.cacheResult(cacheKey, apiResponse, config)
+	return apiResponse, nil
+}
+
+// runSQLAPIQuery connects to the SQL API, runs sql and disconnects. The
+// connection is closed early when ctx is done.
+func (d *Datasource) runSQLAPIQuery(ctx context.Context, config *models.PluginSettings, addr, sql string) (*pgResult, error) {
+	connectTimeout := config.ConnectTimeoutDuration()
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: defaultKeepAlive}
+	conn, err := dialPG(ctx, dialer, addr, config.SQLAPIUser, config.Secrets.SQLAPIPassword, sqlAPIDatabase)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	stop := context.AfterFunc(ctx, func() { _ = conn.conn.Close() })
+	defer stop()
+	return conn.query(sql, maxResponseBytes(config))
+}
+
+// quoteIdent quotes a SQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteLiteral quotes a SQL string literal.
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// numericOrQuoted returns value as a numeric literal when it is a number,
+// else as a string literal.
+func numericOrQuoted(value string) string {
+	if numericLiteral.MatchString(value) {
+		return value
+	}
+	return quoteLiteral(value)
+}
+
+// escapeLike escapes the LIKE wildcards of a value.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
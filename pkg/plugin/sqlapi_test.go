@@ -0,0 +1,328 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestCompileSQLAPIQuery(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		measureFilters []string
+		want           string
+		wantErr        string
+	}{
+		{
+			name:  "measures and dimensions",
+			query: `{"measures": ["orders.count"], "dimensions": ["orders.status"], "order": {"orders.count": "desc"}, "limit": 10}`,
+			want:  `SELECT "status" AS "orders.status", MEASURE("count") AS "orders.count" FROM "orders" GROUP BY 1 ORDER BY "orders.count" DESC LIMIT 10`,
+		},
+		{
+			name: "time dimension with granularity and date range",
+			query: `{"measures": ["orders.count"], "dimensions": ["orders.created_at"],
+				"timeDimensions": [{"dimension": "orders.created_at", "granularity": "day", "dateRange": ["2024-01-01", "2024-01-31"]}]}`,
+			want: `SELECT DATE_TRUNC('day', "created_at") AS "orders.created_at", MEASURE("count") AS "orders.count" FROM "orders" ` +
+				`WHERE ("created_at" >= '2024-01-01' AND "created_at" <= '2024-01-31T23:59:59.999') GROUP BY 1`,
+		},
+		{
+			name: "filters, segments and measure filters",
+			query: `{"measures": ["orders.total"], "dimensions": ["orders.status"], "segments": ["orders.completed"], "filters": [
+				{"member": "orders.status", "operator": "equals", "values": ["shipped", "it's"]},
+				{"or": [{"member": "orders.city", "operator": "contains", "values": ["100%"]}, {"member": "orders.city", "operator": "notSet"}]},
+				{"member": "orders.total", "operator": "gt", "values": ["100"]}]}`,
+			want: `SELECT "status" AS "orders.status", MEASURE("total") AS "orders.total" FROM "orders" ` +
+				`WHERE "completed" IS TRUE AND "status" IN ('shipped', 'it''s') AND (("city" ILIKE '%100\%%') OR "city" IS NULL) ` +
+				`GROUP BY 1 HAVING MEASURE("total") > 100`,
+		},
+		{
+			name:    "several cubes",
+			query:   `{"measures": ["orders.count"], "dimensions": ["users.city"]}`,
+			wantErr: "single cube or view",
+		},
+		{
+			name:    "relative date range",
+			query:   `{"measures": ["orders.count"], "timeDimensions": [{"dimension": "orders.created_at", "dateRange": "last 7 days"}]}`,
+			wantErr: "use a [from, to] range",
+		},
+		{
+			name:  "non-numeric comparison value is quoted",
+			query: `{"measures": ["orders.count"], "filters": [{"member": "orders.amount", "operator": "lt", "values": ["1; DROP TABLE x"]}]}`,
+			want:  `SELECT MEASURE("count") AS "orders.count" FROM "orders" WHERE "amount" < '1; DROP TABLE x'`,
+		},
+		{
+			name:  "order object keeps its priority",
+			query: `{"measures": ["orders.count"], "dimensions": ["orders.status"], "order": {"orders.status": "asc", "orders.count": "desc"}}`,
+			want:  `SELECT "status" AS "orders.status", MEASURE("count") AS "orders.count" FROM "orders" GROUP BY 1 ORDER BY "orders.status" ASC, "orders.count" DESC`,
+		},
+		{
+			name:           "filter on a measure that is not queried",
+			query:          `{"dimensions": ["orders.status"], "filters": [{"member": "orders.count", "operator": "gt", "values": ["100"]}], "order": {"orders.status": "asc"}, "limit": 10}`,
+			measureFilters: []string{"orders.count"},
+			want:           `SELECT "status" AS "orders.status" FROM "orders" GROUP BY 1 HAVING MEASURE("count") > 100 ORDER BY "orders.status" ASC LIMIT 10`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := compileSQLAPIQuery([]byte(tt.query), tt.measureFilters)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderPairs(t *testing.T) {
+	for order, want := range map[string][][2]string{
+		``:     nil,
+		`null`: nil,
+		`{"orders.status": "asc", "orders.count": "desc", "orders.city": "asc"}`: {{"orders.status", "asc"}, {"orders.count", "desc"}, {"orders.city", "asc"}},
+		`[["orders.count", "desc"], ["orders.status", "asc"]]`:                   {{"orders.count", "desc"}, {"orders.status", "asc"}},
+	} {
+		got, err := orderPairs(json.RawMessage(order))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", order, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", order, want, got)
+		}
+	}
+	for _, order := range []string{`"orders.count"`, `[["orders.count"]]`, `{"orders.count": }`} {
+		if _, err := orderPairs(json.RawMessage(order)); err == nil {
+			t.Errorf("%s: expected an error", order)
+		}
+	}
+}
+
+func TestSQLAPIAddress(t *testing.T) {
+	config := &models.PluginSettings{URL: "https://cube.example.com:4000"}
+	if got, _ := sqlAPIAddress(config); got != "cube.example.com:15432" {
+		t.Errorf("expected the Cube host on the default port, got %s", got)
+	}
+	config.SQLAPIAddress = "sql.example.com:5432"
+	if got, _ := sqlAPIAddress(config); got != "sql.example.com:5432" {
+		t.Errorf("expected the configured address, got %s", got)
+	}
+}
+
+// fakeSQLAPI is a Postgres wire protocol server answering every query with
+// a fixed result, recording the credentials and queries it receives. With
+// hang set it never answers the query.
+type fakeSQLAPI struct {
+	listener net.Listener
+	columns  []pgColumn
+	rows     [][]*string
+	errMsg   string
+	hang     bool
+	user     chan string
+	password chan string
+	queries  chan string
+}
+
+func newFakeSQLAPI(t *testing.T, columns []pgColumn, rows [][]*string) *fakeSQLAPI {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	f := &fakeSQLAPI{
+		listener: listener,
+		columns:  columns,
+		rows:     rows,
+		user:     make(chan string, 1),
+		password: make(chan string, 1),
+		queries:  make(chan string, 1),
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeSQLAPI) serve() {
+	conn, err := f.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+
+	// Startup message: length, protocol version, key/value pairs.
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return
+	}
+	startup := make([]byte, size-4)
+	if _, err := io.ReadFull(r, startup); err != nil {
+		return
+	}
+	params := strings.Split(string(startup[4:]), "\x00")
+	for i := 0; i+1 < len(params); i += 2 {
+		if params[i] == "user" {
+			f.user <- params[i+1]
+		}
+	}
+
+	send := func(typ byte, payload []byte) {
+		msg := append([]byte{typ}, binary.BigEndian.AppendUint32(nil, uint32(len(payload)+4))...)
+		_, _ = conn.Write(append(msg, payload...))
+	}
+	receive := func() (byte, string) {
+		var header [5]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return 0, ""
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+		_, _ = io.ReadFull(r, payload)
+		return header[0], strings.TrimSuffix(string(payload), "\x00")
+	}
+
+	send('R', binary.BigEndian.AppendUint32(nil, 3)) // cleartext password
+	if typ, password := receive(); typ == 'p' {
+		f.password <- password
+	}
+	send('R', binary.BigEndian.AppendUint32(nil, 0))
+	send('Z', []byte{'I'})
+
+	typ, query := receive()
+	if typ != 'Q' {
+		return
+	}
+	f.queries <- query
+	if f.hang {
+		receive() // Until the client disconnects.
+		return
+	}
+	if f.errMsg != "" {
+		send('E', []byte("SERROR\x00C42000\x00M"+f.errMsg+"\x00\x00"))
+		send('Z', []byte{'I'})
+		return
+	}
+	var desc []byte
+	desc = binary.BigEndian.AppendUint16(desc, uint16(len(f.columns)))
+	for _, col := range f.columns {
+		desc = append(desc, col.Name...)
+		desc = append(desc, 0, 0, 0, 0, 0, 0, 0)
+		desc = binary.BigEndian.AppendUint32(desc, col.TypeOID)
+		desc = append(desc, make([]byte, 8)...)
+	}
+	send('T', desc)
+	for _, row := range f.rows {
+		var data []byte
+		data = binary.BigEndian.AppendUint16(data, uint16(len(row)))
+		for _, value := range row {
+			if value == nil {
+				data = binary.BigEndian.AppendUint32(data, 0xFFFFFFFF)
+				continue
+			}
+			data = binary.BigEndian.AppendUint32(data, uint32(len(*value)))
+			data = append(data, *value...)
+		}
+		send('D', data)
+	}
+	send('C', []byte("SELECT 2\x00"))
+	send('Z', []byte{'I'})
+	receive() // Terminate
+}
+
+func sqlAPIPluginContext(t *testing.T, addr string) backend.PluginContext {
+	t.Helper()
+	pCtx := newTestPluginContext("http://cube:4000")
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "queryTransport": "sql", "sqlApiAddress": "` + addr + `", "sqlApiUser": "grafana"}`)
+	pCtx.DataSourceInstanceSettings.DecryptedSecureJSONData = map[string]string{"sqlApiPassword": "s3cret"}
+	return pCtx
+}
+
+func TestQueryDataSQLAPITransport(t *testing.T) {
+	day1, day2, count, null := "2024-01-01 00:00:00", "2024-01-02 00:00:00", "42", (*string)(nil)
+	server := newFakeSQLAPI(t,
+		[]pgColumn{{Name: "orders.created_at", TypeOID: pgTypeTimestamp}, {Name: "orders.count", TypeOID: pgTypeNumeric}},
+		[][]*string{{&day1, &count}, {&day2, null}})
+
+	ds := &Datasource{}
+	res := runSingleQuery(t, ds, sqlAPIPluginContext(t, server.listener.Addr().String()), `{"refId": "A",
+		"measures": ["orders.count"], "dimensions": ["orders.created_at"],
+		"timeDimensions": [{"dimension": "orders.created_at", "granularity": "day"}]}`)
+	if res.Error != nil {
+		t.Fatalf("query failed: %v", res.Error)
+	}
+
+	if got := <-server.user; got != "grafana" {
+		t.Errorf("expected user grafana, got %q", got)
+	}
+	if got := <-server.password; got != "s3cret" {
+		t.Errorf("expected the configured password, got %q", got)
+	}
+	if got := <-server.queries; !strings.HasPrefix(got, `SELECT DATE_TRUNC('day', "created_at") AS "orders.created_at"`) {
+		t.Errorf("unexpected SQL: %s", got)
+	}
+
+	frame := res.Frames[0]
+	if len(frame.Fields) != 2 || frame.Rows() != 2 {
+		t.Fatalf("expected 2 fields and 2 rows, got %d fields and %d rows", len(frame.Fields), frame.Rows())
+	}
+	timestamp, ok := frame.Fields[0].ConcreteAt(1)
+	if !ok || !timestamp.(time.Time).Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the second day, got %v", timestamp)
+	}
+	if value, ok := frame.Fields[1].ConcreteAt(0); !ok || value.(float64) != 42 {
+		t.Errorf("expected 42, got %v", value)
+	}
+	if _, ok := frame.Fields[1].ConcreteAt(1); ok {
+		t.Error("expected NULL to convert to a null value")
+	}
+}
+
+func TestQueryDataSQLAPIObjectOrder(t *testing.T) {
+	server := newFakeSQLAPI(t, []pgColumn{{Name: "orders.status", TypeOID: pgTypeInt4}}, nil)
+
+	res := runSingleQuery(t, &Datasource{}, sqlAPIPluginContext(t, server.listener.Addr().String()), `{"refId": "A",
+		"measures": ["orders.count"], "dimensions": ["orders.status"],
+		"order": {"orders.status": "asc", "orders.count": "desc"}}`)
+	if res.Error != nil {
+		t.Fatalf("query failed: %v", res.Error)
+	}
+	<-server.user
+	<-server.password
+	if got := <-server.queries; !strings.HasSuffix(got, `ORDER BY "orders.status" ASC, "orders.count" DESC`) {
+		t.Errorf("expected the order's written priority, got SQL: %s", got)
+	}
+}
+
+func TestQueryDataSQLAPIError(t *testing.T) {
+	server := newFakeSQLAPI(t, nil, nil)
+	server.errMsg = "Unknown column 'nope'"
+
+	res := runSingleQuery(t, &Datasource{}, sqlAPIPluginContext(t, server.listener.Addr().String()), `{"refId": "A", "measures": ["orders.nope"]}`)
+	if res.Error == nil || res.Status != backend.StatusBadRequest || !strings.Contains(res.Error.Error(), "Unknown column 'nope'") {
+		t.Errorf("expected the SQL API error as a bad request, got %d: %v", res.Status, res.Error)
+	}
+}
+
+func TestQueryDataUnknownTransport(t *testing.T) {
+	pCtx := newTestPluginContext("http://cube:4000")
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"deploymentType": "self-hosted-dev", "queryTransport": "grpc"}`)
+	res := runSingleQuery(t, &Datasource{}, pCtx, `{"refId": "A", "measures": ["orders.count"]}`)
+	if res.Error == nil || !strings.Contains(res.Error.Error(), "unknown query transport") {
+		t.Errorf("expected an unknown transport error, got %v", res.Error)
+	}
+}
@@ -0,0 +1,191 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// streamQueryPathPrefix prefixes the Grafana Live channel paths that run a
+// Cube query, e.g. ds/<uid>/query/<key>. The key is chosen by the frontend
+// and only needs to be unique per panel query; the query itself travels in
+// the subscription data.
+const streamQueryPathPrefix = "query/"
+
+// streamQueryRequest is the subscription data for a query stream.
+type streamQueryRequest struct {
+	// Query is the panel query, in the same shape QueryData receives.
+	Query json.RawMessage `json:"query"`
+	// TimeRange is the dashboard time range in epoch milliseconds.
+	TimeRange struct {
+		From int64 `json:"from"`
+		To   int64 `json:"to"`
+	} `json:"timeRange"`
+}
+
+// streamProgress is attached as frame metadata to the progress frames sent
+// while Cube answers "Continue wait".
+type streamProgress struct {
+	Stage       string  `json:"stage,omitempty"`
+	TimeElapsed float64 `json:"timeElapsed,omitempty"`
+}
+
+// parseStreamQuery decodes the subscription data of a query stream into a
+// prepared query.
+func (d *Datasource) parseStreamQuery(ctx context.Context, pCtx backend.PluginContext, path string, raw json.RawMessage) (*preparedQuery, error) {
+	var req streamQueryRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, fmt.Errorf("invalid stream request: %w", err)
+	}
+	if len(req.Query) == 0 {
+		return nil, fmt.Errorf("invalid stream request: query is required")
+	}
+
+	var refID struct {
+		RefID string `json:"refId"`
+	}
+	_ = json.Unmarshal(req.Query, &refID)
+	if refID.RefID == "" {
+		refID.RefID = strings.TrimPrefix(strings.TrimPrefix(path, streamQueryPathPrefix), streamLivePathPrefix)
+	}
+
+	prepared, errResponse := d.prepareQuery(ctx, pCtx, backend.DataQuery{
+		RefID: refID.RefID,
+		JSON:  req.Query,
+		TimeRange: backend.TimeRange{
+			From: time.UnixMilli(req.TimeRange.From),
+			To:   time.UnixMilli(req.TimeRange.To),
+		},
+	})
+	if prepared == nil {
+		return nil, errResponse.Error
+	}
+	return prepared, nil
+}
+
+// SubscribeStream accepts subscriptions to query and live streams whose data
+// holds a valid query. Any other path does not exist.
+func (d *Datasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if !strings.HasPrefix(req.Path, streamQueryPathPrefix) && !strings.HasPrefix(req.Path, streamLivePathPrefix) {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	if _, err := d.parseStreamQuery(ctx, req.PluginContext, req.Path, req.Data); err != nil {
+		return nil, err
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream rejects all publications: query streams are written by the
+// backend only.
+func (d *Datasource) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream runs the subscribed query and pushes its frames to the channel
+// once Cube has computed them. While Cube answers "Continue wait", a progress
+// frame without fields is sent for every poll, carrying the stage and Cube
+// timeElapsed in its metadata, so the panel is not blocked on a single HTTP
+// request for the whole computation. Query errors are delivered as a frame
+// with an error notice, since an error returned from RunStream never reaches
+// the panel. Live streams keep pushing results; see runLiveStream.
+//
+// SDK alignment: this is the backend counterpart of @cubejs-client/core's
+// progressCallback, which is invoked on each Continue-wait message.
+func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	ctx = withCorrelationID(ctx)
+	defer d.trackRequest()()
+	prepared, err := d.parseStreamQuery(ctx, req.PluginContext, req.Path, req.Data)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(req.Path, streamLivePathPrefix) {
+		return d.runLiveStream(ctx, req.PluginContext, req.Path, prepared, sender)
+	}
+
+	ctx = withStreamProgress(ctx, sender, req.Path, prepared.refID)
+	response := d.executeQuery(ctx, req.PluginContext, prepared)
+	if ctx.Err() != nil {
+		// The last subscriber left; there is nobody to send the result to.
+		return nil
+	}
+	return sendStreamResponse(sender, prepared.refID, response)
+}
+
+// withStreamProgress returns a context that sends a progress frame to the
+// stream for every Continue-wait answer of Cube.
+func withStreamProgress(ctx context.Context, sender *backend.StreamSender, path, refID string) context.Context {
+	return withContinueWaitObserver(ctx, func(progress continueWaitProgress) {
+		frame := streamFrame(refID)
+		frame.Meta = &data.FrameMeta{
+			Custom: streamProgress{Stage: progress.Stage, TimeElapsed: progress.TimeElapsed},
+			Notices: []data.Notice{{
+				Severity: data.NoticeSeverityInfo,
+				Text:     progressNoticeText(progress),
+			}},
+		}
+		if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+			backend.Logger.FromContext(ctx).Warn("Failed to send query progress to stream", "path", path, "error", err)
+		}
+	})
+}
+
+// sendStreamResponse sends the frames of a query response to the stream, or
+// a frame with an error notice when the query failed.
+func sendStreamResponse(sender *backend.StreamSender, refID string, response backend.DataResponse) error {
+	if response.Error != nil {
+		frame := streamFrame(refID)
+		frame.Meta = &data.FrameMeta{Notices: []data.Notice{{
+			Severity: data.NoticeSeverityError,
+			Text:     response.Error.Error(),
+		}}}
+		return sender.SendFrame(frame, data.IncludeAll)
+	}
+	for _, frame := range response.Frames {
+		frame.RefID = refID
+		if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamFrame returns an empty frame named like query results, used for
+// progress and error updates.
+func streamFrame(refID string) *data.Frame {
+	frame := data.NewFrame("response")
+	frame.RefID = refID
+	return frame
+}
+
+// progressNoticeText describes a Continue-wait progress update for the panel.
+func progressNoticeText(progress continueWaitProgress) string {
+	if progress.Stage == "" {
+		return "Waiting for Cube to compute results"
+	}
+	return fmt.Sprintf("Waiting for Cube to compute results (stage: %s, Cube timeElapsed: %ds)", progress.Stage, int(progress.TimeElapsed))
+}
+
+// continueWaitObserverKey is the context key for a continueWaitObserver.
+type continueWaitObserverKey struct{}
+
+// continueWaitObserver is called by doCubeLoad with the progress of every
+// "Continue wait" response.
+type continueWaitObserver func(continueWaitProgress)
+
+// withContinueWaitObserver returns a context that makes /v1/load requests made
+// with it report Continue-wait progress to fn.
+func withContinueWaitObserver(ctx context.Context, fn continueWaitObserver) context.Context {
+	return context.WithValue(ctx, continueWaitObserverKey{}, fn)
+}
+
+// continueWaitObserverFrom returns the observer set on ctx, or nil.
+func continueWaitObserverFrom(ctx context.Context) continueWaitObserver {
+	fn, _ := ctx.Value(continueWaitObserverKey{}).(continueWaitObserver)
+	return fn
+}
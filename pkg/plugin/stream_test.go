@@ -0,0 +1,186 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// recordingPacketSender collects the frames sent to a stream.
+type recordingPacketSender struct {
+	frames []*data.Frame
+	// onSend, when set, is called before each frame is recorded.
+	onSend func()
+}
+
+func (s *recordingPacketSender) Send(packet *backend.StreamPacket) error {
+	if s.onSend != nil {
+		s.onSend()
+	}
+	var frame data.Frame
+	if err := json.Unmarshal(packet.Data, &frame); err != nil {
+		return err
+	}
+	s.frames = append(s.frames, &frame)
+	return nil
+}
+
+const testStreamData = `{"query": {"refId": "A", "measures": ["orders.count"], "dimensions": ["orders.status"]}, "timeRange": {"from": 0, "to": 1000}}`
+
+func TestSubscribeStream(t *testing.T) {
+	ds := &Datasource{}
+
+	tests := []struct {
+		name       string
+		path       string
+		data       string
+		wantStatus backend.SubscribeStreamStatus
+		wantErr    bool
+	}{
+		{name: "valid query", path: "query/A", data: testStreamData, wantStatus: backend.SubscribeStreamStatusOK},
+		{name: "unknown path", path: "other/A", data: testStreamData, wantStatus: backend.SubscribeStreamStatusNotFound},
+		{name: "missing query", path: "query/A", data: `{}`, wantErr: true},
+		{name: "invalid query", path: "query/A", data: `{"query": {"measures": ["orders.count"], "normalize": "bogus"}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+				PluginContext: newTestPluginContext("http://localhost:4000"),
+				Path:          tt.path,
+				Data:          json.RawMessage(tt.data),
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SubscribeStream failed: %v", err)
+			}
+			if resp.Status != tt.wantStatus {
+				t.Errorf("expected status %v, got %v", tt.wantStatus, resp.Status)
+			}
+		})
+	}
+}
+
+func TestPublishStreamDenied(t *testing.T) {
+	ds := &Datasource{}
+	resp, err := ds.PublishStream(context.Background(), &backend.PublishStreamRequest{Path: "query/A"})
+	if err != nil {
+		t.Fatalf("PublishStream failed: %v", err)
+	}
+	if resp.Status != backend.PublishStreamStatusPermissionDenied {
+		t.Errorf("expected permission denied, got %v", resp.Status)
+	}
+}
+
+func TestRunStreamSendsProgressThenResult(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if calls.Add(1) <= 2 {
+			_, _ = w.Write([]byte(`{"error": "Continue wait", "stage": "Executing query", "timeElapsed": 3}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": [{"orders.status": "completed", "orders.count": "5"}],
+			"annotation": {"measures": {"orders.count": {"type": "number"}}, "dimensions": {"orders.status": {"type": "string"}}}}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	packets := &recordingPacketSender{}
+	err := ds.RunStream(context.Background(), &backend.RunStreamRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Path:          "query/A",
+		Data:          json.RawMessage(testStreamData),
+	}, backend.NewStreamSender(packets))
+	if err != nil {
+		t.Fatalf("RunStream failed: %v", err)
+	}
+
+	if len(packets.frames) != 3 {
+		t.Fatalf("expected 2 progress frames and 1 result frame, got %d frames", len(packets.frames))
+	}
+	for _, frame := range packets.frames[:2] {
+		if len(frame.Fields) != 0 {
+			t.Errorf("expected progress frame without fields, got %d", len(frame.Fields))
+		}
+		if frame.Meta == nil || len(frame.Meta.Notices) != 1 || frame.Meta.Notices[0].Severity != data.NoticeSeverityInfo {
+			t.Fatalf("expected an info notice on the progress frame, got %+v", frame.Meta)
+		}
+		if want := "Waiting for Cube to compute results (stage: Executing query, Cube timeElapsed: 3s)"; frame.Meta.Notices[0].Text != want {
+			t.Errorf("expected notice %q, got %q", want, frame.Meta.Notices[0].Text)
+		}
+	}
+
+	result := packets.frames[2]
+	for _, frame := range packets.frames {
+		if frame.RefID != "A" {
+			t.Errorf("expected frame for refId A, got %q", frame.RefID)
+		}
+	}
+	if result.Rows() != 1 || len(result.Fields) != 2 {
+		t.Fatalf("expected 1 row with 2 fields, got %d rows and %d fields", result.Rows(), len(result.Fields))
+	}
+}
+
+func TestRunStreamSendsErrorNotice(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": "Cube not found"}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	packets := &recordingPacketSender{}
+	err := ds.RunStream(context.Background(), &backend.RunStreamRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Path:          "query/A",
+		Data:          json.RawMessage(testStreamData),
+	}, backend.NewStreamSender(packets))
+	if err != nil {
+		t.Fatalf("RunStream failed: %v", err)
+	}
+
+	if len(packets.frames) != 1 {
+		t.Fatalf("expected 1 error frame, got %d", len(packets.frames))
+	}
+	meta := packets.frames[0].Meta
+	if meta == nil || len(meta.Notices) != 1 || meta.Notices[0].Severity != data.NoticeSeverityError {
+		t.Fatalf("expected an error notice, got %+v", meta)
+	}
+}
+
+func TestRunStreamCancelledSendsNothing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"error": "Continue wait"}`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ds := &Datasource{BaseURL: server.URL}
+	// Unsubscribe on the first progress update.
+	packets := &recordingPacketSender{onSend: cancel}
+	sender := backend.NewStreamSender(packets)
+
+	err := ds.RunStream(ctx, &backend.RunStreamRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Path:          "query/A",
+		Data:          json.RawMessage(testStreamData),
+	}, sender)
+	if err != nil {
+		t.Fatalf("RunStream failed: %v", err)
+	}
+	if len(packets.frames) != 1 {
+		t.Errorf("expected only the progress frame, got %d frames", len(packets.frames))
+	}
+}
@@ -0,0 +1,141 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// maxTagValuesBulkKeys bounds the number of keys of one tag-values-bulk call.
+const maxTagValuesBulkKeys = 50
+
+// tagValuesBulkResponse is the body of the tag-values-bulk resource.
+type tagValuesBulkResponse struct {
+	// Values maps every key that could be loaded to its tag values.
+	Values map[string][]TagValue `json:"values"`
+	// Errors maps every key that failed to the reason.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// tagValuesBulkKeys returns the keys of a tag-values-bulk request, given as
+// repeated or comma-separated key parameters, without duplicates.
+func tagValuesBulkKeys(query url.Values) []string {
+	var keys []string
+	for _, param := range query["key"] {
+		for _, key := range strings.Split(param, ",") {
+			if key = strings.TrimSpace(key); key != "" && !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// loadTagValues runs a tag values query and extracts the values of key.
+// Values are reused from the tag values cache while they are fresh.
+func (d *Datasource) loadTagValues(ctx context.Context, pCtx backend.PluginContext, apiReq *APIRequestContext, key string, filters []interface{}, segments []string) ([]TagValue, error) {
+	if _, ok := segmentFromKey(key); ok {
+		return segmentTagValues, nil
+	}
+	cubeQueryJSON, err := tagValuesQuery(key, filters, segments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+	if cubeQueryJSON, err = rewriteQueryJSON(cubeQueryJSON, pCtx, apiReq.Config); err != nil {
+		return nil, err
+	}
+	if values, ok := d.cachedTagValues(cubeQueryJSON, apiReq.Config); ok {
+		return values, nil
+	}
+	body, err := d.doCubeLoadRequest(ctx, apiReq.URL.String(), cubeQueryJSON, apiReq.Config)
+	if err != nil {
+		return nil, err
+	}
+	apiResponse, err := decodeLoadResult(body)
+	if err != nil {
+		return nil, err
+	}
+	values := tagValuesFromRows(apiResponse.Data, key)
+	d.cacheTagValues(cubeQueryJSON, values, apiReq.Config)
+	return values, nil
+}
+
+// handleTagValuesBulk returns the tag values of several keys (dimensions) in
+// one call, so AdHoc filters can be initialized without a request per key.
+// Keys are passed like the key of tag-values, repeated or comma-separated,
+// and share its filters parameter. The Cube queries run concurrently; a key
+// whose query fails is reported in errors without failing the other keys.
+func (d *Datasource) handleTagValuesBulk(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
+	}
+
+	keys := tagValuesBulkKeys(parsedURL.Query())
+	if len(keys) == 0 {
+		return sender.Send(jsonErrorResponse(400, errors.New("key parameter is required")))
+	}
+	if len(keys) > maxTagValuesBulkKeys {
+		return sender.Send(jsonErrorResponse(400, fmt.Errorf("too many keys: at most %d are allowed", maxTagValuesBulkKeys)))
+	}
+
+	apiReq, err := d.buildAPIURL(req.PluginContext, "load")
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to build API URL for tag values", "error", err)
+		return sender.Send(jsonErrorResponse(500, fmt.Errorf("failed to build API URL: %w", err)))
+	}
+	filters, segments := d.tagValueFilters(ctx, req.PluginContext, parsedURL.Query().Get("filters"))
+	for _, key := range keys {
+		if err := checkAllowedMembers(apiReq.Config, tagValuesMembers(key, filters, segments)); err != nil {
+			return sender.Send(jsonErrorResponse(http.StatusForbidden, err))
+		}
+	}
+
+	res := tagValuesBulkResponse{Values: make(map[string][]TagValue, len(keys))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxQueryConcurrency)
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			values, err := d.loadTagValues(ctx, req.PluginContext, apiReq, key, filters, segments)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				backend.Logger.FromContext(ctx).Error("Failed to fetch tag values from Cube API", "key", key, "error", err)
+				if res.Errors == nil {
+					res.Errors = make(map[string]string)
+				}
+				res.Errors[key] = err.Error()
+				return
+			}
+			res.Values[key] = values
+		}(key)
+	}
+	wg.Wait()
+
+	body, err := json.Marshal(res)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   body,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
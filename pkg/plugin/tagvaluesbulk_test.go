@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestTagValuesBulkKeys(t *testing.T) {
+	query := url.Values{"key": {"orders.status, orders.region", "orders.status", "", "users.country"}}
+	want := []string{"orders.status", "orders.region", "users.country"}
+	if got := tagValuesBulkKeys(query); !reflect.DeepEqual(got, want) {
+		t.Errorf("tagValuesBulkKeys() = %v, want %v", got, want)
+	}
+}
+
+func TestHandleTagValuesBulk(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var query struct {
+			Dimensions []string        `json:"dimensions"`
+			Filters    json.RawMessage `json:"filters"`
+		}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("query")), &query); err != nil || len(query.Dimensions) != 1 {
+			t.Errorf("unexpected query %q", r.URL.Query().Get("query"))
+			return
+		}
+		if !strings.Contains(string(query.Filters), "orders.tenant") {
+			t.Errorf("expected the scoping filter on every query, got %s", query.Filters)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch key := query.Dimensions[0]; key {
+		case "orders.status":
+			_, _ = w.Write([]byte(`{"data": [{"orders.status": "completed"}, {"orders.status": "pending"}, {"orders.status": "completed"}]}`))
+		case "orders.region":
+			_, _ = w.Write([]byte(`{"data": [{"orders.region": "EU"}]}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "Dimension '` + key + `' not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	filters := url.QueryEscape(`[{"member": "orders.tenant", "operator": "equals", "values": ["acme"]}]`)
+	resp := callHandler(t, ds.CallResource, &backend.CallResourceRequest{
+		Path:          "tag-values-bulk",
+		URL:           "/tag-values-bulk?key=orders.status,orders.region&key=orders.missing&filters=" + filters,
+		PluginContext: newTestPluginContext(server.URL),
+	})
+	if resp.Status != 200 {
+		t.Fatalf("expected status 200, got %d: %s", resp.Status, resp.Body)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("expected one Cube query per key, got %d", got)
+	}
+
+	var res tagValuesBulkResponse
+	if err := json.Unmarshal(resp.Body, &res); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	wantValues := map[string][]TagValue{
+		"orders.status": {{Text: "completed"}, {Text: "pending"}},
+		"orders.region": {{Text: "EU"}},
+	}
+	if !reflect.DeepEqual(res.Values, wantValues) {
+		t.Errorf("values = %v, want %v", res.Values, wantValues)
+	}
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors["orders.missing"], "not found") {
+		t.Errorf("expected an error for orders.missing only, got %v", res.Errors)
+	}
+}
+
+func TestHandleTagValuesBulkValidatesKeys(t *testing.T) {
+	ds := &Datasource{BaseURL: "http://localhost:4000"}
+	tooMany := make([]string, maxTagValuesBulkKeys+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("orders.dimension%d", i)
+	}
+
+	for name, rawURL := range map[string]string{
+		"no key":    "/tag-values-bulk",
+		"too many":  "/tag-values-bulk?key=" + strings.Join(tooMany, ","),
+		"blank key": "/tag-values-bulk?key=,",
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp := callHandler(t, ds.handleTagValuesBulk, &backend.CallResourceRequest{
+				URL:           rawURL,
+				PluginContext: newTestPluginContext(ds.BaseURL),
+			})
+			if resp.Status != 400 {
+				t.Errorf("expected status 400, got %d: %s", resp.Status, resp.Body)
+			}
+		})
+	}
+}
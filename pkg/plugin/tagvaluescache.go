@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// defaultTagValuesCacheTTL is how long tag values are reused when
+// tagValuesCacheTTL is not configured.
+const defaultTagValuesCacheTTL = 30 * time.Second
+
+// maxTagValuesCacheEntries bounds the tag values cache.
+const maxTagValuesCacheEntries = 500
+
+// tagValuesCache holds recent tag values per key and scoping filters. AdHoc
+// filter dropdowns ask for the values of a key every time they are opened,
+// and each ask is a warehouse query, so values are reused for a short while.
+// Cached values are shared and must not be modified.
+type tagValuesCache struct {
+	mu      sync.Mutex
+	entries map[string]tagValuesCacheEntry
+}
+
+type tagValuesCacheEntry struct {
+	values  []TagValue
+	expires time.Time
+}
+
+// tagValuesCacheTTL returns the configured tag values cache TTL: nil means
+// defaultTagValuesCacheTTL, and 0 (or less) disables the cache.
+func tagValuesCacheTTL(config *models.PluginSettings) time.Duration {
+	if config.TagValuesCacheTTL == nil {
+		return defaultTagValuesCacheTTL
+	}
+	if *config.TagValuesCacheTTL <= 0 {
+		return 0
+	}
+	return time.Duration(*config.TagValuesCacheTTL) * time.Second
+}
+
+// tagValuesCacheKey identifies tag values by their Cube query (key, filters
+// and segments) and the credentials it runs with, like resultCacheKey.
+func tagValuesCacheKey(queryJSON []byte, config *models.PluginSettings) string {
+	return resultCacheKey(queryJSON, backend.TimeRange{}, config)
+}
+
+// cachedTagValues returns the cached values of a tag values query, if the
+// cache is enabled and they are cached.
+func (d *Datasource) cachedTagValues(queryJSON []byte, config *models.PluginSettings) ([]TagValue, bool) {
+	if tagValuesCacheTTL(config) == 0 {
+		return nil, false
+	}
+	return d.tagValues.get(tagValuesCacheKey(queryJSON, config))
+}
+
+// cacheTagValues stores the values of a tag values query if the cache is
+// enabled.
+func (d *Datasource) cacheTagValues(queryJSON []byte, values []TagValue, config *models.PluginSettings) {
+	ttl := tagValuesCacheTTL(config)
+	if ttl == 0 {
+		return
+	}
+	d.tagValues.put(tagValuesCacheKey(queryJSON, config), values, ttl)
+}
+
+// get returns the cached values for key if they have not expired.
+func (c *tagValuesCache) get(key string) ([]TagValue, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.values, true
+}
+
+// put stores values for ttl. When the cache is full, expired entries are
+// dropped first, then the entries closest to expiry.
+func (c *tagValuesCache) put(key string, values []TagValue, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]tagValuesCacheEntry)
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxTagValuesCacheEntries {
+		now := time.Now()
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		for len(c.entries) >= maxTagValuesCacheEntries {
+			var oldest string
+			for k, entry := range c.entries {
+				if oldest == "" || entry.expires.Before(c.entries[oldest].expires) {
+					oldest = k
+				}
+			}
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = tagValuesCacheEntry{values: values, expires: time.Now().Add(ttl)}
+}
@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHandleTagValuesCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		jsonData  string
+		wantLoads int32
+	}{
+		{name: "cached by default", jsonData: `{"deploymentType": "self-hosted-dev"}`, wantLoads: 2},
+		{name: "disabled", jsonData: `{"deploymentType": "self-hosted-dev", "tagValuesCacheTTL": 0}`, wantLoads: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var loads atomic.Int32
+			server := httptest.NewServer(serveEmptyMeta(func(w http.ResponseWriter, r *http.Request) {
+				loads.Add(1)
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"data": [{"orders.status": "completed"}]}`))
+			}))
+			defer server.Close()
+
+			pCtx := newTestPluginContext(server.URL)
+			pCtx.DataSourceInstanceSettings.JSONData = []byte(tt.jsonData)
+			ds := &Datasource{}
+			for _, reqURL := range []string{
+				"tag-values?key=orders.status",
+				"tag-values?key=orders.status",
+				`tag-values?key=orders.status&filters=[{"key":"orders.city","operator":"=","value":"Berlin"}]`,
+			} {
+				resp := callHandler(t, ds.handleTagValues, &backend.CallResourceRequest{PluginContext: pCtx, URL: reqURL})
+				if resp.Status != http.StatusOK || string(resp.Body) != `[{"text":"completed"}]` {
+					t.Fatalf("unexpected response %d: %s", resp.Status, resp.Body)
+				}
+			}
+			if got := loads.Load(); got != tt.wantLoads {
+				t.Errorf("expected %d Cube queries, got %d", tt.wantLoads, got)
+			}
+		})
+	}
+}
+
+func TestTagValuesCacheExpiry(t *testing.T) {
+	var c tagValuesCache
+	c.put("a", []TagValue{{Text: "x"}}, -time.Second)
+	if _, ok := c.get("a"); ok {
+		t.Error("expected expired values to be dropped")
+	}
+	for i := 0; i < maxTagValuesCacheEntries+10; i++ {
+		c.put(string(rune('a'+i)), nil, time.Minute)
+	}
+	if len(c.entries) != maxTagValuesCacheEntries {
+		t.Errorf("expected the cache to be bounded to %d entries, got %d", maxTagValuesCacheEntries, len(c.entries))
+	}
+}
@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// timeTagValuePresets are the tag values offered for time dimensions before
+// their first and last dates: relative date ranges Cube's inDateRange filter
+// accepts.
+var timeTagValuePresets = []string{
+	"today",
+	"yesterday",
+	"last 7 days",
+	"last 30 days",
+	"this week",
+	"this month",
+	"last month",
+	"this year",
+}
+
+// isTimeDimension reports whether key is a time dimension of the model.
+// Metadata failures are logged and key is treated as a regular dimension.
+func (d *Datasource) isTimeDimension(ctx context.Context, pCtx backend.PluginContext, key string) bool {
+	meta, err := d.getCubeMetadata(ctx, pCtx)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Warn("Failed to fetch cube metadata for tag values", "error", err)
+		return false
+	}
+	member, ok := filterableMembers(meta)[key]
+	return ok && member.Kind == "dimension" && member.Type == "time"
+}
+
+// timeTagValues returns the tag values of the time dimension key: the date
+// range presets, then the first and last dates with data, as YYYY-MM-DD.
+// Timestamps are not listed; there are too many to pick from. The first and
+// last dates come from one multi-query to Cube (see memberBoundsQueryJSON)
+// and are cached like other tag values.
+func (d *Datasource) timeTagValues(ctx context.Context, pCtx backend.PluginContext, apiReq *APIRequestContext, key string, filters []interface{}, segments []string) ([]TagValue, error) {
+	queriesJSON, err := memberBoundsQueryJSON(pCtx, apiReq.Config, key, filters, segments)
+	if err != nil {
+		return nil, &loadRequestError{status: backend.StatusBadRequest, msg: err.Error()}
+	}
+	if values, ok := d.cachedTagValues(queriesJSON, apiReq.Config); ok {
+		return values, nil
+	}
+	bounds, err := d.loadMemberBounds(ctx, apiReq, queriesJSON, key)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]TagValue, 0, len(timeTagValuePresets)+2)
+	for _, preset := range timeTagValuePresets {
+		values = append(values, TagValue{Text: preset})
+	}
+	for _, bound := range bounds {
+		timestamp, ok := bound.(string)
+		if !ok {
+			continue
+		}
+		date := timestamp
+		if t, err := time.Parse("2006-01-02T15:04:05", truncateTimestamp(timestamp)); err == nil {
+			date = t.Format(time.DateOnly)
+		}
+		if values[len(values)-1].Text != date {
+			values = append(values, TagValue{Text: date})
+		}
+	}
+	d.cacheTagValues(queriesJSON, values, apiReq.Config)
+	return values, nil
+}
+
+// truncateTimestamp returns the date and time of a Cube timestamp without
+// fractional seconds or zone, e.g. "2024-01-31T10:00:00" for
+// "2024-01-31T10:00:00.000Z".
+func truncateTimestamp(timestamp string) string {
+	if len(timestamp) > len("2006-01-02T15:04:05") {
+		return timestamp[:len("2006-01-02T15:04:05")]
+	}
+	return timestamp
+}
@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHandleTagValuesTimeDimension(t *testing.T) {
+	var loads []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/v1/meta") {
+			_, _ = w.Write([]byte(`{"cubes": [{"name": "orders", "type": "cube",
+				"dimensions": [{"name": "orders.created_at", "type": "time"}, {"name": "orders.status", "type": "string"}]}]}`))
+			return
+		}
+		if r.URL.Query().Get("queryType") != "multi" {
+			t.Errorf("expected a multi-query, got %s", r.URL)
+		}
+		loads = append(loads, r.URL.Query().Get("query"))
+		_, _ = w.Write([]byte(`{"queryType": "multi", "results": [
+			{"data": [{"orders.created_at": "2023-02-14T08:30:00.000"}]},
+			{"data": [{"orders.created_at": "2024-11-30T23:00:00.000"}]}]}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{}
+	for range 2 {
+		resp := callHandler(t, ds.handleTagValues, &backend.CallResourceRequest{
+			PluginContext: newTestPluginContext(server.URL),
+			URL:           `tag-values?key=orders.created_at&filters=[{"key":"orders.status","operator":"=","value":"completed"}]`,
+		})
+		if resp.Status != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+		}
+		var values []TagValue
+		if err := json.Unmarshal(resp.Body, &values); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		var texts []string
+		for _, value := range values {
+			texts = append(texts, value.Text)
+		}
+		want := append(slices.Clone(timeTagValuePresets), "2023-02-14", "2024-11-30")
+		if !reflect.DeepEqual(texts, want) {
+			t.Errorf("expected %v, got %v", want, texts)
+		}
+	}
+
+	if len(loads) != 1 {
+		t.Fatalf("expected the bounds to be cached after one Cube query, got %d", len(loads))
+	}
+	var queries []map[string]interface{}
+	if err := json.Unmarshal([]byte(loads[0]), &queries); err != nil || len(queries) != 2 {
+		t.Fatalf("expected two queries, got %s", loads[0])
+	}
+	order, _ := json.Marshal(queries[1]["order"])
+	filters, _ := json.Marshal(queries[1]["filters"])
+	if string(order) != `[["orders.created_at","desc"]]` || !strings.Contains(string(filters), `"operator":"set"`) || !strings.Contains(string(filters), "completed") {
+		t.Errorf("unexpected latest date query %s", loads[0])
+	}
+}
@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader is the header Cube reads its request ID from. Cube logs it
+// with every query, so it ties Cube's (and the warehouse's) logs to a trace.
+const requestIDHeader = "X-Request-Id"
+
+// traceContext propagates the span context to Cube as a W3C traceparent
+// header, independently of the propagator Grafana configured globally.
+var traceContext = propagation.TraceContext{}
+
+// doHTTP sends a request to Cube with the instance's HTTP client, failing
+// over to the other Cube URLs of the datasource when its endpoint is down;
+// see doHTTPWithFailover. Each attempt has its own span, which ends when the
+// response body is closed. The datasource's custom headers are added, and
+// the span context is forwarded to Cube in the traceparent header. Unless the
+// request already has an X-Request-Id, it is set to the correlation ID of the
+// request context, or else to the trace ID.
+func (d *Datasource) doHTTP(req *http.Request, config *models.PluginSettings) (*http.Response, error) {
+	return d.doHTTPWithFailover(req, config, func(req *http.Request) (*http.Response, error) {
+		return d.sendHTTP(req, config)
+	})
+}
+
+// sendHTTP sends a single request of doHTTP.
+func (d *Datasource) sendHTTP(req *http.Request, config *models.PluginSettings) (*http.Response, error) {
+	ctx, span := tracing.DefaultTracer().Start(req.Context(), "cube "+req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+			attribute.String("server.address", req.URL.Host),
+		))
+
+	req = req.WithContext(ctx)
+	addCustomHeaders(req.Header, config)
+	traceContext.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if req.Header.Get(requestIDHeader) == "" {
+		requestID := correlationIDFrom(ctx)
+		if requestID == "" {
+			requestID = tracing.TraceIDFromContext(ctx, false)
+		}
+		if requestID != "" {
+			req.Header.Set(requestIDHeader, requestID)
+		}
+	}
+
+	resp, err := d.getHTTPClient(config).Do(req)
+	if err != nil {
+		_ = tracing.Error(span, err)
+		span.End()
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		_ = tracing.Errorf(span, "Cube API returned status %d", resp.StatusCode)
+	}
+	resp.Body = &spanEndingBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// spanEndingBody ends the span of a request when its response body is
+// closed, so the span covers reading the response too.
+type spanEndingBody struct {
+	io.ReadCloser
+	span trace.Span
+}
+
+func (b *spanEndingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.span.End()
+	return err
+}
+
+// traceLoad starts the span of a /v1/load call, which groups its HTTP
+// requests, and records every Continue-wait response as a span event. An
+// observer already set on ctx keeps being called.
+func traceLoad(ctx context.Context, queryType string) (context.Context, trace.Span) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "cube load", trace.WithAttributes(attribute.String("cube.query_type", queryType)))
+	parent := continueWaitObserverFrom(ctx)
+	polls := 0
+	ctx = withContinueWaitObserver(ctx, func(progress continueWaitProgress) {
+		polls++
+		span.AddEvent("continue wait", trace.WithAttributes(
+			attribute.String("cube.stage", progress.Stage),
+			attribute.Float64("cube.time_elapsed", progress.TimeElapsed),
+		))
+		span.SetAttributes(attribute.Int("cube.continue_waits", polls))
+		if parent != nil {
+			parent(progress)
+		}
+	})
+	return ctx, span
+}
@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func sampledSpanContext(t *testing.T) context.Context {
+	t.Helper()
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+}
+
+func TestDoCubeLoadRequestPropagatesTraceContext(t *testing.T) {
+	var traceparent, requestID string
+	body := successBody(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		requestID = r.Header.Get(requestIDHeader)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	ds := &Datasource{BaseURL: server.URL}
+	if _, err := ds.doCubeLoadRequest(sampledSpanContext(t), server.URL+"/cubejs-api/v1/load", []byte(`{"measures":["orders.count"]}`), devConfig()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(traceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Errorf("expected traceparent carrying the trace ID, got %q", traceparent)
+	}
+	if requestID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected X-Request-Id to be the trace ID, got %q", requestID)
+	}
+}
+
+func TestDoHTTPKeepsExistingRequestID(t *testing.T) {
+	var requestID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get(requestIDHeader)
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequestWithContext(sampledSpanContext(t), "GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(requestIDHeader, "dashboard-42")
+
+	ds := &Datasource{BaseURL: server.URL}
+	resp, err := ds.doHTTP(req, devConfig())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if requestID != "dashboard-42" {
+		t.Errorf("expected the existing X-Request-Id to be kept, got %q", requestID)
+	}
+}
+
+func TestDoHTTPWithoutTraceSendsNoTraceparent(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequestWithContext(context.Background(), "GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := &Datasource{BaseURL: server.URL}
+	resp, err := ds.doHTTP(req, devConfig())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if got := header.Get("traceparent"); got != "" {
+		t.Errorf("expected no traceparent without an active trace, got %q", got)
+	}
+	if got := header.Get(requestIDHeader); got != "" {
+		t.Errorf("expected no X-Request-Id without an active trace, got %q", got)
+	}
+}
@@ -0,0 +1,280 @@
+package plugin
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Supported values for CubeQuery.Normalize.
+const (
+	// normalizeIndex100 rebases each series so its first non-zero value is 100.
+	normalizeIndex100 = "index100"
+	// normalizeMinMax rescales each series into the [0, 1] range.
+	normalizeMinMax = "minmax"
+)
+
+// validateNormalize checks that a normalize option is one we know how to apply.
+// An empty value means "no normalization".
+func validateNormalize(mode string) error {
+	switch mode {
+	case "", normalizeIndex100, normalizeMinMax:
+		return nil
+	default:
+		return fmt.Errorf("invalid normalize option %q (must be %q or %q)", mode, normalizeIndex100, normalizeMinMax)
+	}
+}
+
+// normalizeMeasures rescales every measure field in the frame according to mode.
+// Normalization is applied per series: rows are grouped by the values of the
+// non-time dimensions in the query, so a long frame with one row per
+// (time, status) pair is indexed per status rather than across all rows.
+// Measures with different magnitudes (revenue vs. orders) can then share an axis.
+func (d *Datasource) normalizeMeasures(frame *data.Frame, query CubeQuery, mode string) {
+	if mode == "" || frame.Rows() == 0 {
+		return
+	}
+
+	seriesKeys := seriesKeysForRows(frame, query)
+
+	for i, field := range frame.Fields {
+		if !slices.Contains(query.Measures, field.Name) || !field.Type().Numeric() {
+			continue
+		}
+		frame.Fields[i] = normalizeField(field, seriesKeys, mode)
+	}
+}
+
+// seriesKeysForRows returns, for every row, a key identifying the series the
+// row belongs to. Time dimensions are the x-axis and never part of the key.
+func seriesKeysForRows(frame *data.Frame, query CubeQuery) []string {
+	var keyFields []*data.Field
+	for _, field := range frame.Fields {
+		if !slices.Contains(query.Dimensions, field.Name) || field.Type().Time() {
+			continue
+		}
+		keyFields = append(keyFields, field)
+	}
+
+	keys := make([]string, frame.Rows())
+	if len(keyFields) == 0 {
+		return keys
+	}
+
+	parts := make([]string, len(keyFields))
+	for row := range keys {
+		for j, field := range keyFields {
+			if v, ok := field.ConcreteAt(row); ok {
+				parts[j] = fmt.Sprint(v)
+			} else {
+				parts[j] = ""
+			}
+		}
+		keys[row] = strings.Join(parts, "\x00")
+	}
+	return keys
+}
+
+// normalizeField returns a nullable float64 copy of field with its values
+// rescaled per series. Null values stay null, and a series whose base (first
+// non-zero value for index100, range for minmax) is zero becomes null rather
+// than dividing by zero.
+func normalizeField(field *data.Field, seriesKeys []string, mode string) *data.Field {
+	values := make([]*float64, field.Len())
+	for i := range values {
+		if v, err := field.NullableFloatAt(i); err == nil && v != nil {
+			f := *v
+			values[i] = &f
+		}
+	}
+
+	switch mode {
+	case normalizeIndex100:
+		bases := make(map[string]float64)
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			if _, ok := bases[seriesKeys[i]]; !ok && *v != 0 {
+				bases[seriesKeys[i]] = *v
+			}
+		}
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			base, ok := bases[seriesKeys[i]]
+			if !ok {
+				values[i] = nil
+				continue
+			}
+			*v = *v / base * 100
+		}
+	case normalizeMinMax:
+		type bounds struct{ min, max float64 }
+		ranges := make(map[string]*bounds)
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			b, ok := ranges[seriesKeys[i]]
+			if !ok {
+				ranges[seriesKeys[i]] = &bounds{min: *v, max: *v}
+				continue
+			}
+			if *v < b.min {
+				b.min = *v
+			}
+			if *v > b.max {
+				b.max = *v
+			}
+		}
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			b := ranges[seriesKeys[i]]
+			if b.max == b.min {
+				values[i] = nil
+				continue
+			}
+			*v = (*v - b.min) / (b.max - b.min)
+		}
+	}
+
+	newField := data.NewField(field.Name, field.Labels, values)
+	newField.Config = field.Config
+	return newField
+}
+
+// validTypeOverrides lists the member types a query may force via typeOverrides.
+var validTypeOverrides = []string{"string", "number", "time", "boolean"}
+
+// validateTypeOverrides checks that every override names a supported type.
+func validateTypeOverrides(overrides map[string]string) error {
+	for member, fieldType := range overrides {
+		if !slices.Contains(validTypeOverrides, fieldType) {
+			return fmt.Errorf("invalid type override %q for %s (must be one of %s)", fieldType, member, strings.Join(validTypeOverrides, ", "))
+		}
+	}
+	return nil
+}
+
+// applyTypeOverrides returns a copy of annotation with the overridden members'
+// types replaced. A member Cube did not annotate is added as a dimension so the
+// override still takes effect.
+func applyTypeOverrides(annotation CubeAnnotation, overrides map[string]string) CubeAnnotation {
+	if len(overrides) == 0 {
+		return annotation
+	}
+
+	result := CubeAnnotation{
+		Measures:       maps.Clone(annotation.Measures),
+		Dimensions:     maps.Clone(annotation.Dimensions),
+		Segments:       maps.Clone(annotation.Segments),
+		TimeDimensions: maps.Clone(annotation.TimeDimensions),
+	}
+	if result.Dimensions == nil {
+		result.Dimensions = make(map[string]CubeFieldInfo)
+	}
+
+	for member, fieldType := range overrides {
+		overridden := false
+		for _, infos := range []map[string]CubeFieldInfo{result.Measures, result.Dimensions, result.Segments, result.TimeDimensions} {
+			if info, ok := infos[member]; ok {
+				info.Type = fieldType
+				infos[member] = info
+				overridden = true
+			}
+		}
+		if !overridden {
+			result.Dimensions[member] = CubeFieldInfo{Type: fieldType}
+		}
+	}
+	return result
+}
+
+// coerceOverriddenStrings turns non-string values of members overridden to
+// "string" into strings (e.g. a zip code the model declares as a number), so
+// the resulting field has a single string type. Rows are only copied when a
+// value changes.
+func coerceOverriddenStrings(rows []map[string]interface{}, overrides map[string]string) []map[string]interface{} {
+	var stringMembers []string
+	for member, fieldType := range overrides {
+		if fieldType == "string" {
+			stringMembers = append(stringMembers, member)
+		}
+	}
+	if len(stringMembers) == 0 {
+		return rows
+	}
+
+	result := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		result[i] = row
+		copied := false
+		for _, member := range stringMembers {
+			value, ok := row[member]
+			if !ok || value == nil {
+				continue
+			}
+			var str string
+			switch v := value.(type) {
+			case string:
+				continue
+			case float64:
+				str = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				str = fmt.Sprint(v)
+			}
+			if !copied {
+				result[i] = maps.Clone(row)
+				copied = true
+			}
+			result[i][member] = str
+		}
+	}
+	return result
+}
+
+// instantTimeFieldName is the name of the synthetic time field added to
+// measures-only frames when CubeQuery.InstantTime is set.
+const instantTimeFieldName = "time"
+
+// isMeasuresOnly reports whether the query aggregates measures over the whole
+// result, i.e. it has measures but no dimensions or time dimensions.
+func isMeasuresOnly(query CubeQuery) bool {
+	return len(query.Measures) > 0 && len(query.Dimensions) == 0 && len(query.TimeDimensions) == 0
+}
+
+// instantFrame shapes a measures-only result as a single-row stats frame. Cube
+// omits rows entirely when nothing matches the filters; in that case every
+// measure gets a single null value so stat panels show "No data" for the value
+// rather than for the whole frame. When query.InstantTime is set, a time field
+// holding the end of the time range is prepended.
+func (d *Datasource) instantFrame(frame *data.Frame, query CubeQuery, timeRange backend.TimeRange) *data.Frame {
+	if frame.Rows() == 0 {
+		for i, field := range frame.Fields {
+			nullField := data.NewFieldFromFieldType(field.Type().NullableType(), 1)
+			nullField.Name = field.Name
+			nullField.Labels = field.Labels
+			nullField.Config = field.Config
+			frame.Fields[i] = nullField
+		}
+	}
+
+	if query.InstantTime && len(frame.Fields) > 0 {
+		times := make([]time.Time, frame.Rows())
+		for i := range times {
+			times[i] = timeRange.To
+		}
+		frame.Fields = append([]*data.Field{data.NewField(instantTimeFieldName, nil, times)}, frame.Fields...)
+	}
+	return frame
+}
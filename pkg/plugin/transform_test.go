@@ -0,0 +1,267 @@
+package plugin
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func floatPtr(f float64) *float64 { return &f }
+
+func strPtr(s string) *string { return &s }
+
+func nullableFloats(t *testing.T, field *data.Field) []*float64 {
+	t.Helper()
+	out := make([]*float64, field.Len())
+	for i := range out {
+		v, err := field.NullableFloatAt(i)
+		if err != nil {
+			t.Fatalf("field %s is not numeric: %v", field.Name, err)
+		}
+		out[i] = v
+	}
+	return out
+}
+
+func assertFloats(t *testing.T, name string, got []*float64, want []*float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: expected %d values, got %d", name, len(want), len(got))
+	}
+	for i := range want {
+		switch {
+		case want[i] == nil && got[i] == nil:
+		case want[i] == nil || got[i] == nil:
+			t.Errorf("%s[%d]: expected %v, got %v", name, i, want[i], got[i])
+		case math.Abs(*want[i]-*got[i]) > 1e-9:
+			t.Errorf("%s[%d]: expected %v, got %v", name, i, *want[i], *got[i])
+		}
+	}
+}
+
+func TestValidateNormalize(t *testing.T) {
+	for _, mode := range []string{"", "index100", "minmax"} {
+		if err := validateNormalize(mode); err != nil {
+			t.Errorf("expected %q to be valid, got %v", mode, err)
+		}
+	}
+	err := validateNormalize("zscore")
+	if err == nil || !strings.Contains(err.Error(), "zscore") {
+		t.Fatalf("expected error naming the invalid option, got %v", err)
+	}
+}
+
+func TestNormalizeMeasuresIndex100PerSeries(t *testing.T) {
+	ds := &Datasource{}
+	frame := data.NewFrame("response",
+		data.NewField("orders.status", nil, []*string{strPtr("a"), strPtr("b"), strPtr("a"), strPtr("b")}),
+		data.NewField("orders.revenue", nil, []*float64{floatPtr(200), floatPtr(0), floatPtr(300), floatPtr(10)}),
+		data.NewField("orders.count", nil, []*float64{floatPtr(4), nil, floatPtr(2), floatPtr(5)}),
+	)
+	query := CubeQuery{
+		Dimensions: []string{"orders.status"},
+		Measures:   []string{"orders.revenue", "orders.count"},
+	}
+
+	ds.normalizeMeasures(frame, query, normalizeIndex100)
+
+	// Series "b" starts at 0 for revenue, so its base is the first non-zero value (10).
+	assertFloats(t, "revenue", nullableFloats(t, frame.Fields[1]), []*float64{floatPtr(100), floatPtr(0), floatPtr(150), floatPtr(100)})
+	assertFloats(t, "count", nullableFloats(t, frame.Fields[2]), []*float64{floatPtr(100), nil, floatPtr(50), floatPtr(100)})
+
+	if frame.Fields[0].Type() != data.FieldTypeNullableString {
+		t.Errorf("dimension field must be untouched, got %s", frame.Fields[0].Type())
+	}
+}
+
+func TestNormalizeMeasuresMinMax(t *testing.T) {
+	ds := &Datasource{}
+	frame := data.NewFrame("response",
+		data.NewField("orders.revenue", nil, []*float64{floatPtr(10), floatPtr(20), floatPtr(30)}),
+		data.NewField("orders.flat", nil, []*float64{floatPtr(5), floatPtr(5), floatPtr(5)}),
+	)
+	query := CubeQuery{Measures: []string{"orders.revenue", "orders.flat"}}
+
+	ds.normalizeMeasures(frame, query, normalizeMinMax)
+
+	assertFloats(t, "revenue", nullableFloats(t, frame.Fields[0]), []*float64{floatPtr(0), floatPtr(0.5), floatPtr(1)})
+	// A constant series has no range to scale into, so it becomes null.
+	assertFloats(t, "flat", nullableFloats(t, frame.Fields[1]), []*float64{nil, nil, nil})
+}
+
+func TestQueryDataNormalize(t *testing.T) {
+	server := newCubeLoadServer(t, CubeAPIResponse{
+		Data: []map[string]interface{}{
+			{"orders.count": "50"},
+			{"orders.count": "75"},
+		},
+		Annotation: CubeAnnotation{
+			Measures: map[string]CubeFieldInfo{"orders.count": {Type: "number"}},
+		},
+	})
+	ds := &Datasource{BaseURL: server.URL}
+
+	res := runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId":"A","measures":["orders.count"],"normalize":"index100"}`)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	assertFloats(t, "count", nullableFloats(t, res.Frames[0].Fields[0]), []*float64{floatPtr(100), floatPtr(150)})
+}
+
+func TestQueryDataNormalizeInvalidOption(t *testing.T) {
+	ds := &Datasource{BaseURL: "http://unused"}
+
+	res := runSingleQuery(t, ds, newTestPluginContext("http://unused"), `{"refId":"A","measures":["orders.count"],"normalize":"log"}`)
+	if res.Error == nil {
+		t.Fatal("expected an error for an invalid normalize option")
+	}
+	if res.Status != backend.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", res.Status)
+	}
+}
+
+func TestValidateTypeOverrides(t *testing.T) {
+	if err := validateTypeOverrides(map[string]string{"orders.zipcode": "string", "orders.created": "time"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := validateTypeOverrides(map[string]string{"orders.zipcode": "integer"})
+	if err == nil || !strings.Contains(err.Error(), "orders.zipcode") {
+		t.Fatalf("expected error naming the member, got %v", err)
+	}
+}
+
+func TestApplyTypeOverridesDoesNotMutateAnnotation(t *testing.T) {
+	annotation := CubeAnnotation{
+		Dimensions: map[string]CubeFieldInfo{"orders.zipcode": {Title: "Zip", Type: "number"}},
+	}
+
+	got := applyTypeOverrides(annotation, map[string]string{"orders.zipcode": "string", "orders.raw": "time"})
+
+	if got.Dimensions["orders.zipcode"].Type != "string" || got.Dimensions["orders.zipcode"].Title != "Zip" {
+		t.Errorf("expected zipcode overridden to string with title kept, got %+v", got.Dimensions["orders.zipcode"])
+	}
+	if got.Dimensions["orders.raw"].Type != "time" {
+		t.Errorf("expected unannotated member to be added as time dimension, got %+v", got.Dimensions["orders.raw"])
+	}
+	if annotation.Dimensions["orders.zipcode"].Type != "number" {
+		t.Error("original annotation must not be modified")
+	}
+}
+
+func TestQueryDataTypeOverrides(t *testing.T) {
+	server := newCubeLoadServer(t, CubeAPIResponse{
+		Data: []map[string]interface{}{
+			{"orders.zipcode": "02134", "orders.count": "3"},
+			{"orders.zipcode": 10001.0, "orders.count": "4"},
+		},
+		Annotation: CubeAnnotation{
+			Dimensions: map[string]CubeFieldInfo{"orders.zipcode": {Type: "number"}},
+			Measures:   map[string]CubeFieldInfo{"orders.count": {Type: "number"}},
+		},
+	})
+	ds := &Datasource{BaseURL: server.URL}
+
+	res := runSingleQuery(t, ds, newTestPluginContext(server.URL),
+		`{"refId":"A","dimensions":["orders.zipcode"],"measures":["orders.count"],"typeOverrides":{"orders.zipcode":"string"}}`)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+
+	zip := res.Frames[0].Fields[0]
+	if zip.Type() != data.FieldTypeNullableString {
+		t.Fatalf("expected zipcode to stay a string field, got %s", zip.Type())
+	}
+	if v := zip.At(0).(*string); *v != "02134" {
+		t.Errorf("expected leading zero to be preserved, got %q", *v)
+	}
+	if v := zip.At(1).(*string); *v != "10001" {
+		t.Errorf("expected numeric value coerced to string, got %q", *v)
+	}
+}
+
+func TestQueryDataInvalidTypeOverride(t *testing.T) {
+	ds := &Datasource{BaseURL: "http://unused"}
+
+	res := runSingleQuery(t, ds, newTestPluginContext("http://unused"), `{"refId":"A","typeOverrides":{"orders.zipcode":"varchar"}}`)
+	if res.Error == nil || res.Status != backend.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid type override, got status %d err %v", res.Status, res.Error)
+	}
+}
+
+func TestIsMeasuresOnly(t *testing.T) {
+	tests := []struct {
+		name  string
+		query CubeQuery
+		want  bool
+	}{
+		{"measures only", CubeQuery{Measures: []string{"orders.count"}}, true},
+		{"with dimension", CubeQuery{Measures: []string{"orders.count"}, Dimensions: []string{"orders.status"}}, false},
+		{"with time dimension", CubeQuery{Measures: []string{"orders.count"}, TimeDimensions: []interface{}{map[string]interface{}{"dimension": "orders.created_at"}}}, false},
+		{"empty", CubeQuery{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isMeasuresOnly(tt.query); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestQueryDataMeasuresOnlyInstantTime(t *testing.T) {
+	server := newCubeLoadServer(t, CubeAPIResponse{
+		Data:       []map[string]interface{}{{"orders.count": "42"}},
+		Annotation: CubeAnnotation{Measures: map[string]CubeFieldInfo{"orders.count": {Type: "number"}}},
+	})
+	ds := &Datasource{BaseURL: server.URL}
+	to := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		Queries: []backend.DataQuery{{
+			RefID:     "A",
+			JSON:      []byte(`{"refId":"A","measures":["orders.count"],"instantTime":true}`),
+			TimeRange: backend.TimeRange{From: to.Add(-time.Hour), To: to},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("QueryData failed: %v", err)
+	}
+
+	frame := resp.Responses["A"].Frames[0]
+	if len(frame.Fields) != 2 || frame.Fields[0].Name != "time" {
+		t.Fatalf("expected [time, orders.count] fields, got %d fields", len(frame.Fields))
+	}
+	if got := frame.Fields[0].At(0).(time.Time); !got.Equal(to) {
+		t.Errorf("expected time %v, got %v", to, got)
+	}
+	if v, _ := frame.Fields[1].NullableFloatAt(0); v == nil || *v != 42 {
+		t.Errorf("expected count 42, got %v", v)
+	}
+}
+
+func TestQueryDataMeasuresOnlyEmptyResultHasOneNullRow(t *testing.T) {
+	server := newCubeLoadServer(t, CubeAPIResponse{
+		Data:       []map[string]interface{}{},
+		Annotation: CubeAnnotation{Measures: map[string]CubeFieldInfo{"orders.count": {Type: "number"}}},
+	})
+	ds := &Datasource{BaseURL: server.URL}
+
+	res := runSingleQuery(t, ds, newTestPluginContext(server.URL), `{"refId":"A","measures":["orders.count"]}`)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+
+	frame := res.Frames[0]
+	if frame.Rows() != 1 {
+		t.Fatalf("expected a single row, got %d", frame.Rows())
+	}
+	if v, _ := frame.Fields[0].NullableFloatAt(0); v != nil {
+		t.Errorf("expected null count, got %v", *v)
+	}
+}
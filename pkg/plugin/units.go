@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// UnitConversion describes a numeric scaling from the unit a member is stored
+// in to the unit it should be displayed in.
+type UnitConversion struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// unitDimension groups units that can be converted into each other.
+type unitDimension struct {
+	// factors maps a unit to its size in the dimension's base unit.
+	factors map[string]float64
+	// grafanaUnits maps a unit to the Grafana field config unit identifier.
+	grafanaUnits map[string]string
+}
+
+// unitDimensions lists the unit families we know how to convert between.
+// Units are only convertible within the same family.
+var unitDimensions = []unitDimension{
+	{
+		// Time, in seconds
+		factors: map[string]float64{
+			"ns": 1e-9, "us": 1e-6, "ms": 1e-3, "s": 1, "m": 60, "h": 3600, "d": 86400,
+		},
+		grafanaUnits: map[string]string{
+			"ns": "ns", "us": "µs", "ms": "ms", "s": "s", "m": "m", "h": "h", "d": "d",
+		},
+	},
+	{
+		// Data size, in bytes (IEC)
+		factors: map[string]float64{
+			"B": 1, "KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30, "TiB": 1 << 40,
+		},
+		grafanaUnits: map[string]string{
+			"B": "bytes", "KiB": "kbytes", "MiB": "mbytes", "GiB": "gbytes", "TiB": "tbytes",
+		},
+	},
+	{
+		// Data size, in bytes (SI)
+		factors: map[string]float64{
+			"kB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12,
+		},
+		grafanaUnits: map[string]string{
+			"kB": "deckbytes", "MB": "decmbytes", "GB": "decgbytes", "TB": "dectbytes",
+		},
+	},
+	{
+		// Ratio, as a fraction
+		factors: map[string]float64{
+			"ratio": 1, "percent": 0.01, "permille": 0.001,
+		},
+		grafanaUnits: map[string]string{
+			"ratio": "percentunit", "percent": "percent",
+		},
+	},
+}
+
+// findUnitDimension returns the family that defines both units.
+func findUnitDimension(from, to string) (unitDimension, bool) {
+	for _, dim := range unitDimensions {
+		_, okFrom := dim.factors[from]
+		_, okTo := dim.factors[to]
+		if okFrom && okTo {
+			return dim, true
+		}
+	}
+	return unitDimension{}, false
+}
+
+// knownUnits lists every supported unit, for error messages.
+func knownUnits() []string {
+	var units []string
+	for _, dim := range unitDimensions {
+		for unit := range dim.factors {
+			units = append(units, unit)
+		}
+	}
+	sort.Strings(units)
+	return units
+}
+
+// validateUnitConversions checks that every conversion is between two known
+// units of the same family.
+func validateUnitConversions(conversions map[string]UnitConversion) error {
+	for member, conv := range conversions {
+		if _, ok := findUnitDimension(conv.From, conv.To); !ok {
+			known := knownUnits()
+			if !slices.Contains(known, conv.From) || !slices.Contains(known, conv.To) {
+				return fmt.Errorf("invalid unit conversion for %s: unknown unit (supported units: %s)", member, strings.Join(known, ", "))
+			}
+			return fmt.Errorf("invalid unit conversion for %s: cannot convert %q to %q", member, conv.From, conv.To)
+		}
+	}
+	return nil
+}
+
+// convertUnits scales the numeric fields named in conversions and sets the
+// matching Grafana display unit when one exists. Conversions must have been
+// validated with validateUnitConversions.
+func (d *Datasource) convertUnits(frame *data.Frame, conversions map[string]UnitConversion) {
+	if len(conversions) == 0 {
+		return
+	}
+
+	for i, field := range frame.Fields {
+		conv, ok := conversions[field.Name]
+		if !ok || !field.Type().Numeric() {
+			continue
+		}
+		dim, ok := findUnitDimension(conv.From, conv.To)
+		if !ok {
+			continue
+		}
+		scale := dim.factors[conv.From] / dim.factors[conv.To]
+
+		values := make([]*float64, field.Len())
+		for j := range values {
+			if v, err := field.NullableFloatAt(j); err == nil && v != nil {
+				scaled := *v * scale
+				values[j] = &scaled
+			}
+		}
+
+		newField := data.NewField(field.Name, field.Labels, values)
+		newField.Config = field.Config
+		if unit, ok := dim.grafanaUnits[conv.To]; ok {
+			config := data.FieldConfig{}
+			if field.Config != nil {
+				config = *field.Config
+			}
+			config.Unit = unit
+			newField.Config = &config
+		}
+		frame.Fields[i] = newField
+	}
+}
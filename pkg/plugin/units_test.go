@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestValidateUnitConversions(t *testing.T) {
+	tests := []struct {
+		name    string
+		conv    UnitConversion
+		wantErr string
+	}{
+		{"time", UnitConversion{From: "ms", To: "s"}, ""},
+		{"bytes", UnitConversion{From: "B", To: "MiB"}, ""},
+		{"ratio", UnitConversion{From: "ratio", To: "percent"}, ""},
+		{"unknown unit", UnitConversion{From: "furlongs", To: "s"}, "unknown unit"},
+		{"cross family", UnitConversion{From: "ms", To: "MiB"}, `cannot convert "ms" to "MiB"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateUnitConversions(map[string]UnitConversion{"orders.value": tt.conv})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestConvertUnits(t *testing.T) {
+	ds := &Datasource{}
+	frame := data.NewFrame("response",
+		data.NewField("orders.status", nil, []*string{strPtr("done"), strPtr("open")}),
+		data.NewField("orders.duration_ms", nil, []*float64{floatPtr(1500), nil}),
+		data.NewField("orders.share", nil, []*float64{floatPtr(0.25), floatPtr(1)}),
+	)
+
+	ds.convertUnits(frame, map[string]UnitConversion{
+		"orders.duration_ms": {From: "ms", To: "s"},
+		"orders.share":       {From: "ratio", To: "percent"},
+		"orders.status":      {From: "ms", To: "s"},
+	})
+
+	assertFloats(t, "duration", nullableFloats(t, frame.Fields[1]), []*float64{floatPtr(1.5), nil})
+	assertFloats(t, "share", nullableFloats(t, frame.Fields[2]), []*float64{floatPtr(25), floatPtr(100)})
+
+	if unit := frame.Fields[1].Config.Unit; unit != "s" {
+		t.Errorf("expected display unit s, got %q", unit)
+	}
+	if unit := frame.Fields[2].Config.Unit; unit != "percent" {
+		t.Errorf("expected display unit percent, got %q", unit)
+	}
+	if frame.Fields[0].Type() != data.FieldTypeNullableString {
+		t.Errorf("non-numeric fields must be left alone, got %s", frame.Fields[0].Type())
+	}
+}
+
+func TestQueryDataUnitConversion(t *testing.T) {
+	server := newCubeLoadServer(t, CubeAPIResponse{
+		Data:       []map[string]interface{}{{"orders.duration_ms": "2000"}},
+		Annotation: CubeAnnotation{Measures: map[string]CubeFieldInfo{"orders.duration_ms": {Type: "number"}}},
+	})
+	ds := &Datasource{BaseURL: server.URL}
+
+	res := runSingleQuery(t, ds, newTestPluginContext(server.URL),
+		`{"refId":"A","measures":["orders.duration_ms"],"unitConversion":{"orders.duration_ms":{"from":"ms","to":"s"}}}`)
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	assertFloats(t, "duration", nullableFloats(t, res.Frames[0].Fields[0]), []*float64{floatPtr(2)})
+
+	res = runSingleQuery(t, ds, newTestPluginContext(server.URL),
+		`{"refId":"A","measures":["orders.duration_ms"],"unitConversion":{"orders.duration_ms":{"from":"ms","to":"GiB"}}}`)
+	if res.Error == nil {
+		t.Fatal("expected an error for an invalid conversion")
+	}
+}
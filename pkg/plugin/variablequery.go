@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// MetadataVariableQuery is a dashboard variable query listing the members or
+// views of the model instead of querying data, e.g.
+// {"type": "dimensions", "cube": "orders"}.
+type MetadataVariableQuery struct {
+	// Type is "dimensions", "measures" or "views".
+	Type string `json:"type"`
+	// Cube scopes the list to views, comma-separated.
+	Cube string `json:"cube,omitempty"`
+	// Query keeps the options containing it, and Regex filters and extracts
+	// options like Grafana's variable regex.
+	Query string `json:"query,omitempty"`
+	Regex string `json:"regex,omitempty"`
+}
+
+// parseMetadataVariableQuery returns the metadata variable query of a query,
+// and false when the query is a data query.
+func parseMetadataVariableQuery(queryJSON []byte) (*MetadataVariableQuery, bool) {
+	var q MetadataVariableQuery
+	if err := json.Unmarshal(queryJSON, &q); err != nil {
+		return nil, false
+	}
+	switch q.Type {
+	case variableValuesDimensions, variableValuesMeasures, variableValuesViews:
+		return &q, true
+	}
+	return nil, false
+}
+
+// variableQuery answers a metadata variable query from the cached metadata
+// with a frame of text and value fields, the shape Grafana reads variable
+// options from.
+func (d *Datasource) variableQuery(ctx context.Context, pCtx backend.PluginContext, q *MetadataVariableQuery) backend.DataResponse {
+	r := &variableValuesRequest{kind: q.Type, search: q.Query, cubes: splitCubeNames(q.Cube)}
+	if q.Regex != "" {
+		re, err := compileVariableRegex(q.Regex)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		r.regex = re
+	}
+	values, err := d.metadataVariableValues(ctx, pCtx, r.kind, r.cubes)
+	if err != nil {
+		backend.Logger.FromContext(ctx).Error("Failed to fetch cube metadata", "error", err)
+		return backend.ErrDataResponse(backend.StatusBadGateway, fmt.Sprintf("failed to fetch metadata from Cube API: %v", err))
+	}
+	values = r.apply(values)
+
+	texts := make([]string, len(values))
+	vals := make([]string, len(values))
+	for i, v := range values {
+		texts[i], vals[i] = v.Text, v.Value
+	}
+	frame := data.NewFrame("variable", data.NewField("text", nil, texts), data.NewField("value", nil, vals))
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestQueryDataMetadataVariableQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cubejs-api/v1/meta" {
+			t.Errorf("expected only metadata to be fetched, got %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"cubes": [
+			{"name": "orders", "title": "Orders", "type": "view",
+				"dimensions": [{"name": "orders.status", "title": "Status", "type": "string"}, {"name": "orders.city", "title": "City", "type": "string"}],
+				"measures": [{"name": "orders.count", "title": "Count", "type": "number"}]},
+			{"name": "users", "type": "view",
+				"dimensions": [{"name": "users.name", "title": "Name", "type": "string"}], "measures": []}]}`))
+	}))
+	defer server.Close()
+
+	ds := &Datasource{}
+	for name, tt := range map[string]struct {
+		query  string
+		texts  []string
+		values []string
+	}{
+		"dimensions of a view": {`{"refId": "A", "type": "dimensions", "cube": "orders"}`, []string{"orders.status", "orders.city"}, []string{"orders.status", "orders.city"}},
+		"measures":             {`{"refId": "A", "type": "measures"}`, []string{"orders.count"}, []string{"orders.count"}},
+		"views":                {`{"refId": "A", "type": "views"}`, []string{"Orders", "users"}, []string{"orders", "users"}},
+		"regex":                {`{"refId": "A", "type": "dimensions", "regex": "/^users\\.(.*)$/"}`, []string{"name"}, []string{"name"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp := runSingleQuery(t, ds, newTestPluginContext(server.URL), tt.query)
+			if resp.Error != nil {
+				t.Fatalf("unexpected error: %v", resp.Error)
+			}
+			frame := resp.Frames[0]
+			if len(frame.Fields) != 2 || frame.Fields[0].Name != "text" || frame.Fields[1].Name != "value" {
+				t.Fatalf("expected text and value fields, got %v", frame.Fields)
+			}
+			if frame.Rows() != len(tt.values) {
+				t.Fatalf("expected %d options, got %d", len(tt.values), frame.Rows())
+			}
+			for i := range tt.values {
+				if text, value := frame.Fields[0].At(i), frame.Fields[1].At(i); text != tt.texts[i] || value != tt.values[i] {
+					t.Errorf("option %d: expected %s=%s, got %v=%v", i, tt.texts[i], tt.values[i], text, value)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryDataMetadataVariableQueryInvalidRegex(t *testing.T) {
+	ds := &Datasource{}
+	resp := ds.query(context.Background(), newTestPluginContext("http://localhost:4000"), backend.DataQuery{RefID: "A", JSON: []byte(`{"type": "measures", "regex": "("}`)})
+	if resp.Status != backend.StatusBadRequest {
+		t.Errorf("expected 400, got %v: %v", resp.Status, resp.Error)
+	}
+}
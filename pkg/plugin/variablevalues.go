@@ -0,0 +1,318 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Kinds of values the variable-values resource lists.
+const (
+	variableValuesDimensions = "dimensions"
+	variableValuesMeasures   = "measures"
+	variableValuesViews      = "views"
+	variableValuesMember     = "values"
+)
+
+// maxVariableValues bounds the member values loaded for a variable, like the
+// tag values limit.
+const maxVariableValues = 10000
+
+// variableValue is an option of a dashboard variable, in the format of
+// Grafana's MetricFindValue.
+type variableValue struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// variableValuesRequest holds the parameters of a variable-values call.
+type variableValuesRequest struct {
+	kind   string
+	member string
+	// search keeps the values containing it (case-insensitive).
+	search string
+	regex  *regexp.Regexp
+	// cubes scopes dimension and measure lists to these views.
+	cubes []string
+	// timeDimension, from and to scope member values to a time range.
+	timeDimension string
+	from, to      time.Time
+	filters       string
+}
+
+// parseVariableValuesRequest validates the query parameters of a
+// variable-values call.
+func parseVariableValuesRequest(query url.Values) (*variableValuesRequest, error) {
+	r := &variableValuesRequest{
+		kind:          query.Get("type"),
+		member:        query.Get("member"),
+		search:        query.Get("query"),
+		timeDimension: query.Get("timeDimension"),
+		filters:       query.Get("filters"),
+	}
+	if r.kind == "" {
+		r.kind = variableValuesMember
+	}
+	switch r.kind {
+	case variableValuesDimensions, variableValuesMeasures, variableValuesViews:
+	case variableValuesMember:
+		if r.member == "" {
+			return nil, errors.New("member parameter is required")
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q (valid values: %s, %s, %s, %s)", r.kind, variableValuesDimensions, variableValuesMeasures, variableValuesViews, variableValuesMember)
+	}
+
+	if pattern := query.Get("regex"); pattern != "" {
+		re, err := compileVariableRegex(pattern)
+		if err != nil {
+			return nil, err
+		}
+		r.regex = re
+	}
+	for _, param := range query["cube"] {
+		r.cubes = append(r.cubes, splitCubeNames(param)...)
+	}
+
+	from, to := query.Get("from"), query.Get("to")
+	if from != "" || to != "" {
+		var err error
+		if r.from, err = parseEpochMillis(from); err != nil {
+			return nil, fmt.Errorf("invalid from: %w", err)
+		}
+		if r.to, err = parseEpochMillis(to); err != nil {
+			return nil, fmt.Errorf("invalid to: %w", err)
+		}
+		if r.timeDimension == "" {
+			return nil, errors.New("timeDimension parameter is required with a time range")
+		}
+	}
+	return r, nil
+}
+
+// splitCubeNames splits a comma-separated list of view names.
+func splitCubeNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// compileVariableRegex compiles a variable regex, written like Grafana's
+// variable regex with or without enclosing slashes ("/^prod-(.*)$/").
+func compileVariableRegex(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") {
+		if end := strings.LastIndex(pattern, "/"); end > 0 {
+			flags := pattern[end+1:]
+			pattern = pattern[1:end]
+			if strings.Contains(flags, "i") {
+				pattern = "(?i)" + pattern
+			}
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %w", err)
+	}
+	return re, nil
+}
+
+// parseEpochMillis parses a time given in milliseconds since the epoch.
+func parseEpochMillis(s string) (time.Time, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("expected milliseconds since the epoch")
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// apply filters and maps values like Grafana applies a variable regex: a
+// value is kept when it contains the search text and matches the regex. The
+// regex's "text" and "value" named groups, or else its first group, extract
+// the option's text and value.
+func (r *variableValuesRequest) apply(values []variableValue) []variableValue {
+	search := strings.ToLower(r.search)
+	result := make([]variableValue, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		if search != "" && !strings.Contains(strings.ToLower(v.Text), search) && !strings.Contains(strings.ToLower(v.Value), search) {
+			continue
+		}
+		if r.regex != nil {
+			match := r.regex.FindStringSubmatch(v.Value)
+			if match == nil {
+				continue
+			}
+			text, value := v.Text, v.Value
+			if i := r.regex.SubexpIndex("text"); i > 0 {
+				text = match[i]
+			}
+			if i := r.regex.SubexpIndex("value"); i > 0 {
+				value = match[i]
+			}
+			if r.regex.SubexpIndex("text") < 0 && r.regex.SubexpIndex("value") < 0 && len(match) > 1 {
+				text, value = match[1], match[1]
+			}
+			v = variableValue{Text: text, Value: value}
+		}
+		if !seen[v.Value] {
+			seen[v.Value] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// memberValuesQuery builds the Cube query listing the values of the
+// request's member, scoped by its filters, search text and time range.
+func (r *variableValuesRequest) memberValuesQuery(filters []interface{}, segments []string) ([]byte, error) {
+	if r.search != "" {
+		// Let Cube narrow large dimensions down; apply checks again.
+		filters = append(filters, map[string]interface{}{"member": r.member, "operator": "contains", "values": []string{r.search}})
+	}
+	cubeQuery := map[string]interface{}{
+		"dimensions": []string{r.member},
+		"order":      map[string]string{r.member: "asc"},
+		"limit":      maxVariableValues,
+	}
+	if len(filters) > 0 {
+		cubeQuery["filters"] = filters
+	}
+	if len(segments) > 0 {
+		cubeQuery["segments"] = segments
+	}
+	if r.timeDimension != "" && !r.from.IsZero() {
+		cubeQuery["timeDimensions"] = []interface{}{map[string]interface{}{
+			"dimension": r.timeDimension,
+			"dateRange": cubeDateRange(r.from, r.to),
+		}}
+	}
+	return json.Marshal(cubeQuery)
+}
+
+// handleVariableValues returns the options of a dashboard variable: the
+// dimensions, measures or views of the model (type=dimensions|measures|views,
+// optionally scoped to views with cube), or the values of a dimension (type=values,
+// member=...), optionally scoped with filters and a time range
+// (timeDimension, from and to in epoch milliseconds). The query parameter
+// keeps options containing it and regex filters and extracts options like
+// Grafana's variable regex.
+func (d *Datasource) handleVariableValues(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, errors.New("invalid URL")))
+	}
+	r, err := parseVariableValuesRequest(parsedURL.Query())
+	if err != nil {
+		return sender.Send(jsonErrorResponse(400, err))
+	}
+
+	var values []variableValue
+	if r.kind == variableValuesMember {
+		filters, segments := d.tagValueFilters(ctx, req.PluginContext, r.filters)
+		members := append(tagValuesMembers(r.member, filters, segments), r.timeDimension)
+		if err := checkAllowedMembers(d.pluginSettings(req.PluginContext), members); err != nil {
+			return sender.Send(jsonErrorResponse(http.StatusForbidden, err))
+		}
+		values, err = d.memberVariableValues(ctx, req.PluginContext, r)
+		if err != nil {
+			backend.Logger.FromContext(ctx).Error("Failed to fetch variable values from Cube API", "member", r.member, "error", err)
+			return sender.Send(cubeLoadErrorResponse(err))
+		}
+	} else {
+		values, err = d.metadataVariableValues(ctx, req.PluginContext, r.kind, r.cubes)
+		if err != nil {
+			backend.Logger.FromContext(ctx).Error("Failed to fetch cube metadata", "error", err)
+			return sender.Send(jsonErrorResponse(500, errors.New("failed to fetch metadata from Cube API")))
+		}
+	}
+
+	body, err := json.Marshal(r.apply(values))
+	if err != nil {
+		return sender.Send(jsonErrorResponse(500, errors.New("failed to marshal response")))
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: 200,
+		Body:   body,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+		},
+	})
+}
+
+// metadataVariableValues lists the dimensions, measures or views (kind) of
+// the model, from the cached metadata, optionally scoped to the views cubes.
+func (d *Datasource) metadataVariableValues(ctx context.Context, pCtx backend.PluginContext, kind string, cubes []string) ([]variableValue, error) {
+	metaResponse, err := d.getCubeMetadata(ctx, pCtx)
+	if err != nil {
+		return nil, err
+	}
+	opts := metadataOptions{cubes: cubes, groupByView: kind == variableValuesViews}
+	if config := d.pluginSettings(pCtx); config != nil {
+		opts.allowedViews = config.AllowedViews
+	}
+	metadata := d.extractMetadata(metaResponse, opts)
+
+	var values []variableValue
+	switch kind {
+	case variableValuesViews:
+		for _, view := range metadata.Views {
+			text := view.Title
+			if text == "" {
+				text = view.Name
+			}
+			values = append(values, variableValue{Text: text, Value: view.Name})
+		}
+	case variableValuesMeasures:
+		for _, option := range metadata.Measures {
+			values = append(values, variableValue{Text: option.Label, Value: option.Value})
+		}
+	default:
+		for _, option := range metadata.Dimensions {
+			values = append(values, variableValue{Text: option.Label, Value: option.Value})
+		}
+	}
+	return values, nil
+}
+
+// memberVariableValues loads the values of the request's member from Cube.
+func (d *Datasource) memberVariableValues(ctx context.Context, pCtx backend.PluginContext, r *variableValuesRequest) ([]variableValue, error) {
+	filters, segments := d.tagValueFilters(ctx, pCtx, r.filters)
+	cubeQueryJSON, err := r.memberValuesQuery(filters, segments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+	apiReq, err := d.buildAPIURL(pCtx, "load")
+	if err != nil {
+		return nil, fmt.Errorf("failed to build API URL: %w", err)
+	}
+	if cubeQueryJSON, err = rewriteQueryJSON(cubeQueryJSON, pCtx, apiReq.Config); err != nil {
+		return nil, err
+	}
+	body, err := d.doCubeLoadRequest(ctx, apiReq.URL.String(), cubeQueryJSON, apiReq.Config)
+	if err != nil {
+		return nil, err
+	}
+	apiResponse, err := decodeLoadResult(body)
+	if err != nil {
+		return nil, err
+	}
+	tagValues := tagValuesFromRows(apiResponse.Data, r.member)
+	values := make([]variableValue, len(tagValues))
+	for i, v := range tagValues {
+		values[i] = variableValue{Text: v.Text, Value: v.Text}
+	}
+	return values, nil
+}
@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestHandleVariableValues(t *testing.T) {
+	var gotQuery map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("query")), &gotQuery); err != nil {
+			t.Errorf("invalid query: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": [
+			{"orders.region": "prod-eu"},
+			{"orders.region": "prod-us"},
+			{"orders.region": "staging-eu"}
+		]}`))
+	}))
+	defer server.Close()
+
+	params := url.Values{
+		"member":        {"orders.region"},
+		"query":         {"eu"},
+		"regex":         {"/^prod-(.*)$/"},
+		"timeDimension": {"orders.created_at"},
+		"from":          {"1704067200000"},
+		"to":            {"1704153600000"},
+	}
+	resp := callHandler(t, (&Datasource{}).handleVariableValues, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "variable-values?" + params.Encode(),
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+
+	var values []variableValue
+	if err := json.Unmarshal(resp.Body, &values); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if want := []variableValue{{Text: "eu", Value: "eu"}}; !reflect.DeepEqual(values, want) {
+		t.Errorf("expected %v, got %v", want, values)
+	}
+
+	filters, _ := json.Marshal(gotQuery["filters"])
+	if want := `[{"member":"orders.region","operator":"contains","values":["eu"]}]`; string(filters) != want {
+		t.Errorf("expected the search text as a contains filter, got %s", filters)
+	}
+	timeDimensions, _ := json.Marshal(gotQuery["timeDimensions"])
+	if want := `[{"dateRange":["2024-01-01T00:00:00.000","2024-01-02T00:00:00.000"],"dimension":"orders.created_at"}]`; string(timeDimensions) != want {
+		t.Errorf("expected the time range as a date range, got %s", timeDimensions)
+	}
+}
+
+func TestHandleVariableValuesMembers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"cubes": [{"name": "orders", "type": "view",
+			"dimensions": [{"name": "orders.status", "title": "Status", "type": "string"}, {"name": "orders.city", "title": "City", "type": "string"}],
+			"measures": [{"name": "orders.count", "title": "Count", "type": "number"}]}]}`))
+	}))
+	defer server.Close()
+
+	resp := callHandler(t, (&Datasource{}).handleVariableValues, &backend.CallResourceRequest{
+		PluginContext: newTestPluginContext(server.URL),
+		URL:           "variable-values?type=dimensions&regex=status",
+	})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Status, resp.Body)
+	}
+	var values []variableValue
+	if err := json.Unmarshal(resp.Body, &values); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(values) != 1 || values[0].Value != "orders.status" {
+		t.Errorf("expected only orders.status, got %v", values)
+	}
+}
+
+func TestParseVariableValuesRequestErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr string
+	}{
+		{name: "missing member", query: "", wantErr: "member parameter is required"},
+		{name: "unknown type", query: "type=segments", wantErr: "unknown type"},
+		{name: "invalid regex", query: "member=orders.status&regex=(", wantErr: "invalid regex"},
+		{name: "time range without time dimension", query: "member=orders.status&from=0&to=1", wantErr: "timeDimension parameter is required"},
+		{name: "invalid from", query: "member=orders.status&timeDimension=orders.created_at&from=now-1h&to=1", wantErr: "invalid from"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			_, err := parseVariableValuesRequest(query)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestVariableValuesRegexNamedGroups(t *testing.T) {
+	re, err := compileVariableRegex(`/(?P<value>\d+)-(?P<text>.*)/`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := &variableValuesRequest{regex: re}
+	got := r.apply([]variableValue{{Text: "1-Europe", Value: "1-Europe"}, {Text: "none", Value: "none"}})
+	if want := []variableValue{{Text: "Europe", Value: "1"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
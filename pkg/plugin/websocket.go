@@ -0,0 +1,197 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/grafana/cube/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/net/websocket"
+)
+
+// defaultWebSocketPath is the path Cube serves its WebSocket API on when
+// webSocketsBasePath is not configured.
+const defaultWebSocketPath = "/"
+
+// wsLoadMessageID is the messageId of the load request. Each query uses its
+// own connection, so a single id suffices.
+const wsLoadMessageID = 1
+
+// wsRequest is a method call in Cube's WebSocket protocol.
+type wsRequest struct {
+	MessageID int          `json:"messageId"`
+	Method    string       `json:"method"`
+	Params    wsLoadParams `json:"params"`
+}
+
+// wsLoadParams are the parameters of the "load" method, the same as the
+// /v1/load query string.
+type wsLoadParams struct {
+	Query     json.RawMessage `json:"query"`
+	QueryType string          `json:"queryType,omitempty"`
+}
+
+// wsMessage is a message sent by Cube over the WebSocket. Besides results
+// ({"messageId", "message", "status"}) Cube sends {"handshake": true} after
+// authorization and {"messageProcessedId"} acknowledgements, which carry no
+// message and are ignored.
+type wsMessage struct {
+	MessageID int             `json:"messageId"`
+	Message   json.RawMessage `json:"message"`
+	Status    int             `json:"status"`
+}
+
+// webSocketUnavailableError reports that no WebSocket connection to Cube could
+// be established, so nothing was sent and the query can go over HTTP instead.
+type webSocketUnavailableError struct {
+	err error
+}
+
+func (e *webSocketUnavailableError) Error() string {
+	return fmt.Sprintf("failed to connect to Cube WebSocket API: %v", e.err)
+}
+
+func (e *webSocketUnavailableError) Unwrap() error { return e.err }
+
+// webSocketURL derives Cube's WebSocket endpoint from the /v1/load URL: same
+// host, ws/wss scheme, and the configured WebSocket path.
+func webSocketURL(loadURL string, path string) (string, error) {
+	u, err := url.Parse(loadURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid Cube API URL: %w", err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	if path == "" {
+		path = defaultWebSocketPath
+	}
+	u.Path = path
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// dialCubeWebSocket opens a connection to Cube's WebSocket API and sends the
+// authorization token. The returned function closes the connection, which
+// also happens when ctx ends so that reads, which take no context, return.
+// Connection failures are *webSocketUnavailableError.
+func (d *Datasource) dialCubeWebSocket(ctx context.Context, loadURL string, config *models.PluginSettings) (*websocket.Conn, func(), error) {
+	wsURL, err := webSocketURL(loadURL, config.WebSocketPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	wsConfig, err := websocket.NewConfig(wsURL, loadURL)
+	if err != nil {
+		return nil, nil, &webSocketUnavailableError{err: err}
+	}
+	connectTimeout := config.ConnectTimeoutDuration()
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	wsConfig.Dialer = &net.Dialer{Timeout: connectTimeout, KeepAlive: defaultKeepAlive}
+	addCustomHeaders(wsConfig.Header, config)
+	if transport, ok := d.getHTTPClient(config).Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+		wsConfig.TlsConfig = transport.TLSClientConfig.Clone()
+	}
+
+	token, err := d.authToken(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to add auth headers: %w", err)
+	}
+
+	conn, err := wsConfig.DialContext(ctx)
+	if err != nil {
+		return nil, nil, &webSocketUnavailableError{err: err}
+	}
+	if limit := maxResponseBytes(config); limit > 0 {
+		conn.MaxPayloadBytes = int(limit)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	closeConn := func() {
+		stop()
+		_ = conn.Close()
+	}
+
+	if token != "" {
+		if err := websocket.JSON.Send(conn, map[string]string{"authorization": token}); err != nil {
+			closeConn()
+			return nil, nil, &webSocketUnavailableError{err: err}
+		}
+	}
+	return conn, closeConn, nil
+}
+
+// doCubeLoadWebSocket runs a /v1/load query over Cube's WebSocket API. Cube
+// pushes the result on the open connection as soon as it is ready, so no
+// HTTP request is spent per Continue-wait round trip.
+//
+// SDK alignment: mirrors @cubejs-client/ws-transport. The first message
+// carries the authorization token, queries are {"messageId", "method": "load",
+// "params": {"query", "queryType"}}, and a "Continue wait" result re-sends the
+// same message, which is what the SDK's continueWait() does over WebSockets.
+func (d *Datasource) doCubeLoadWebSocket(ctx context.Context, loadURL string, queryJSON []byte, queryType string, config *models.PluginSettings) ([]byte, error) {
+	conn, closeConn, err := d.dialCubeWebSocket(ctx, loadURL, config)
+	if err != nil {
+		return nil, err
+	}
+	defer closeConn()
+	wsURL := conn.Config().Location.String()
+
+	request := wsRequest{
+		MessageID: wsLoadMessageID,
+		Method:    "load",
+		Params:    wsLoadParams{Query: queryJSON, QueryType: queryType},
+	}
+	if err := websocket.JSON.Send(conn, request); err != nil {
+		return nil, &webSocketUnavailableError{err: err}
+	}
+
+	var lastProgress continueWaitProgress
+	haveProgress := false
+	for {
+		var msg wsMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			if ctx.Err() != nil {
+				return nil, interruptedWaitError(context.Cause(ctx), lastProgress, haveProgress)
+			}
+			if errors.Is(err, websocket.ErrFrameTooLarge) {
+				return nil, responseTooLargeError(maxResponseBytes(config))
+			}
+			return nil, &loadRequestError{status: backend.StatusBadGateway, msg: fmt.Sprintf("Cube WebSocket connection failed: %v", err)}
+		}
+		if msg.MessageID != wsLoadMessageID || len(msg.Message) == 0 {
+			continue
+		}
+
+		if msg.Status >= http.StatusBadRequest {
+			return nil, &CubeAPIError{StatusCode: msg.Status, Body: msg.Message}
+		}
+
+		if envelope, err := decodeCubeEnvelope(msg.Message); err == nil && envelope.isContinueWait() {
+			lastProgress = envelope.progress()
+			haveProgress = true
+			backend.Logger.FromContext(ctx).Debug("Cube returned 'Continue wait' over WebSocket, waiting again",
+				"url", wsURL, "stage", lastProgress.Stage, "cubeTimeElapsed", lastProgress.TimeElapsed)
+			if observe := continueWaitObserverFrom(ctx); observe != nil {
+				observe(lastProgress)
+			}
+			if err := websocket.JSON.Send(conn, request); err != nil {
+				if ctx.Err() != nil {
+					return nil, interruptedWaitError(context.Cause(ctx), lastProgress, haveProgress)
+				}
+				return nil, &loadRequestError{status: backend.StatusBadGateway, msg: fmt.Sprintf("Cube WebSocket connection failed: %v", err)}
+			}
+			continue
+		}
+
+		return msg.Message, nil
+	}
+}
@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/cube/pkg/models"
+	"golang.org/x/net/websocket"
+)
+
+func TestWebSocketURL(t *testing.T) {
+	tests := []struct {
+		loadURL string
+		path    string
+		want    string
+	}{
+		{loadURL: "http://cube:4000/cubejs-api/v1/load", want: "ws://cube:4000/"},
+		{loadURL: "https://cube.example.com/cubejs-api/v1/load", want: "wss://cube.example.com/"},
+		{loadURL: "https://cube.example.com/cubejs-api/v1/load", path: "/ws", want: "wss://cube.example.com/ws"},
+	}
+	for _, tt := range tests {
+		got, err := webSocketURL(tt.loadURL, tt.path)
+		if err != nil {
+			t.Fatalf("webSocketURL(%q, %q) failed: %v", tt.loadURL, tt.path, err)
+		}
+		if got != tt.want {
+			t.Errorf("webSocketURL(%q, %q) = %q, want %q", tt.loadURL, tt.path, got, tt.want)
+		}
+	}
+}
+
+// newCubeWebSocketServer serves Cube's WebSocket API at "/" with handler and
+// answers HTTP /v1/load requests with httpBody.
+func newCubeWebSocketServer(t *testing.T, handler func(ws *websocket.Conn), httpBody []byte) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	if handler != nil {
+		mux.Handle("/", websocket.Handler(handler))
+	}
+	mux.HandleFunc("/cubejs-api/v1/load", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(httpBody)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDoCubeLoadWebSocketContinueWaitThenResult(t *testing.T) {
+	var loads []wsRequest
+	var authorization string
+	server := newCubeWebSocketServer(t, func(ws *websocket.Conn) {
+		var auth map[string]string
+		if err := websocket.JSON.Receive(ws, &auth); err != nil {
+			t.Errorf("receive authorization: %v", err)
+			return
+		}
+		authorization = auth["authorization"]
+		_ = websocket.JSON.Send(ws, map[string]bool{"handshake": true})
+
+		for i := 0; i < 2; i++ {
+			var req wsRequest
+			if err := websocket.JSON.Receive(ws, &req); err != nil {
+				t.Errorf("receive load: %v", err)
+				return
+			}
+			loads = append(loads, req)
+			_ = websocket.JSON.Send(ws, map[string]int{"messageProcessedId": req.MessageID})
+			message := json.RawMessage(`{"error": "Continue wait", "stage": "Executing query"}`)
+			if i == 1 {
+				message = successBody(t)
+			}
+			_ = websocket.JSON.Send(ws, wsMessage{MessageID: req.MessageID, Message: message, Status: http.StatusOK})
+		}
+	}, nil)
+
+	ds := &Datasource{}
+	config := &models.PluginSettings{
+		DeploymentType: "self-hosted",
+		Secrets:        &models.SecretPluginSettings{ApiSecret: "secret"},
+		UseWebSockets:  true,
+	}
+	body, err := ds.doCubeLoadRequest(context.Background(), server.URL+"/cubejs-api/v1/load", []byte(`{"measures":["orders.count"]}`), config)
+	if err != nil {
+		t.Fatalf("doCubeLoadRequest failed: %v", err)
+	}
+	if string(body) != string(successBody(t)) {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	if authorization == "" || strings.HasPrefix(authorization, "Bearer ") {
+		t.Errorf("expected the raw JWT as authorization, got %q", authorization)
+	}
+	if len(loads) != 2 {
+		t.Fatalf("expected the load to be re-sent after Continue wait, got %d loads", len(loads))
+	}
+	for _, req := range loads {
+		if req.Method != "load" || string(req.Params.Query) != `{"measures":["orders.count"]}` {
+			t.Errorf("unexpected load request %+v", req)
+		}
+	}
+}
+
+func TestDoCubeLoadWebSocketErrorStatus(t *testing.T) {
+	server := newCubeWebSocketServer(t, func(ws *websocket.Conn) {
+		var req wsRequest
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			return
+		}
+		_ = websocket.JSON.Send(ws, wsMessage{MessageID: req.MessageID, Message: json.RawMessage(`{"error": "Cube not found"}`), Status: http.StatusBadRequest})
+	}, nil)
+
+	ds := &Datasource{}
+	config := devConfig()
+	config.UseWebSockets = true
+	_, err := ds.doCubeLoadRequest(context.Background(), server.URL+"/cubejs-api/v1/load", []byte(`{}`), config)
+
+	var apiErr *CubeAPIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected CubeAPIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || !strings.Contains(string(apiErr.Body), "Cube not found") {
+		t.Errorf("unexpected error %v", apiErr)
+	}
+}
+
+func TestDoCubeLoadWebSocketUnavailableFallsBackToHTTP(t *testing.T) {
+	// No WebSocket handler: the upgrade request gets a 404.
+	server := newCubeWebSocketServer(t, nil, successBody(t))
+
+	ds := &Datasource{}
+	config := devConfig()
+	config.UseWebSockets = true
+	body, err := ds.doCubeLoadRequest(context.Background(), server.URL+"/cubejs-api/v1/load", []byte(`{}`), config)
+	if err != nil {
+		t.Fatalf("expected HTTP fallback to succeed, got %v", err)
+	}
+	if string(body) != string(successBody(t)) {
+		t.Errorf("unexpected body %s", body)
+	}
+}
+
+func TestDoCubeLoadWebSocketCancelled(t *testing.T) {
+	server := newCubeWebSocketServer(t, func(ws *websocket.Conn) {
+		var req wsRequest
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			return
+		}
+		_ = websocket.JSON.Send(ws, wsMessage{MessageID: req.MessageID, Message: json.RawMessage(`{"error": "Continue wait", "stage": "Executing query", "timeElapsed": 4}`)})
+		// Never answer the re-sent load.
+		var ignored json.RawMessage
+		_ = websocket.JSON.Receive(ws, &ignored)
+		_ = websocket.JSON.Receive(ws, &ignored)
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = withContinueWaitObserver(ctx, func(continueWaitProgress) { cancel() })
+
+	ds := &Datasource{}
+	config := devConfig()
+	config.UseWebSockets = true
+	_, err := ds.doCubeLoadRequest(ctx, server.URL+"/cubejs-api/v1/load", []byte(`{}`), config)
+	if err == nil || !strings.Contains(err.Error(), "query cancelled while waiting for Cube") || !strings.Contains(err.Error(), "stage: Executing query") {
+		t.Fatalf("expected cancellation error with progress, got %v", err)
+	}
+}
@@ -70,42 +70,22 @@ describe('DataSource', () => {
   });
 
   describe('getTagKeys', () => {
-    it('should transform metadata dimensions to TagKey format', async () => {
-      const mockMetadata = {
-        dimensions: [
-          { label: 'orders.status', value: 'orders.status' },
-          { label: 'orders.customer_name', value: 'orders.customer_name' },
-        ],
-        measures: [{ label: 'orders.count', value: 'orders.count' }],
-      };
-
-      mockGetResource.mockResolvedValue(mockMetadata);
-      const datasource = createDataSource();
-
-      const result = await datasource.getTagKeys();
-
-      expect(mockGetResource).toHaveBeenCalledWith('metadata');
-      expect(result).toEqual([
+    it('should call tag-keys endpoint', async () => {
+      const mockTagKeys = [
         { text: 'orders.status', value: 'orders.status' },
-        { text: 'orders.customer_name', value: 'orders.customer_name' },
-      ]);
-    });
-
-    it('should handle empty dimensions', async () => {
-      const mockMetadata = {
-        dimensions: [],
-        measures: [{ label: 'orders.count', value: 'orders.count' }],
-      };
+        { text: 'orders.completed', value: 'segment:orders.completed' },
+      ];
 
-      mockGetResource.mockResolvedValue(mockMetadata);
+      mockGetResource.mockResolvedValue(mockTagKeys);
       const datasource = createDataSource();
 
       const result = await datasource.getTagKeys();
 
-      expect(result).toEqual([]);
+      expect(mockGetResource).toHaveBeenCalledWith('tag-keys');
+      expect(result).toEqual(mockTagKeys);
     });
 
-    it('should propagate metadata errors', async () => {
+    it('should propagate tag-keys errors', async () => {
       mockGetResource.mockRejectedValue(new Error('Metadata fetch failed'));
       const datasource = createDataSource();
 
@@ -58,15 +58,12 @@ export class DataSource extends DataSourceWithBackend<CubeQuery, CubeDataSourceO
     return !!(query.dimensions?.length || query.measures?.length);
   }
 
-  // Get available tag keys for AdHoc filtering from the backend
-  // This uses the metadata endpoint and transforms dimensions to the TagKey format
-  async getTagKeys() {
-    const metadata = await this.getMetadata();
-    // Transform dimensions from {label, value} to {text, value} for AdHoc filtering
-    return metadata.dimensions.map((dimension: any) => ({
-      text: dimension.label,
-      value: dimension.value,
-    }));
+  // Get available tag keys for AdHoc filtering from the backend.
+  // The tag-keys endpoint returns the views' dimensions and segments in the
+  // TagKey format. Segments are offered as "segment:<name>" keys; the backend
+  // turns filters on them into query segments.
+  getTagKeys(): Promise<Array<{ text: string; value: string }>> {
+    return this.getResource('tag-keys');
   }
 
   // Get available tag values for a specific key for AdHoc filtering
@@ -168,7 +168,8 @@ export interface ModelFilesResponse {
 }
 
 export interface GenerateSchemaRequest {
-  format: 'yaml';
+  format: 'yaml' | 'js';
   tables: string[][];
   tablesSchema: DbSchemaResponse['tablesSchema'];
+  dataSource?: string;
 }